@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// bundleResult is the result of applying a bundle returned by API v2.
+	bundleResult struct {
+		DryRun  bool            `yaml:"dryRun"`
+		Changes []*bundleChange `yaml:"changes"`
+	}
+
+	bundleChange struct {
+		Name            string   `yaml:"name"`
+		Kind            string   `yaml:"kind"`
+		Action          string   `yaml:"action"`
+		ResourceVersion int64    `yaml:"resourceVersion"`
+		Diffs           []string `yaml:"diffs"`
+	}
+)
+
+// ApplyCmd defines apply command.
+func ApplyCmd() *cobra.Command {
+	var specFile string
+	var dryRun, prune bool
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Create or update objects from a yaml file or stdin",
+		Long: `Create or update objects from a yaml file or stdin.
+
+The objects are applied all at once or none of them, the invalid ones are
+rejected together. With --prune, the running objects absent in the file are
+deleted, so the file could describe all objects and be kept in Git.`,
+		Example: "egctl apply -f <object_specs.yaml> --prune --dry-run",
+		Run: func(cmd *cobra.Command, args []string) {
+			result := applyBundle(readFileOrStdin(specFile, cmd), dryRun, prune, cmd)
+			suffix := ""
+			if result.DryRun {
+				suffix = " (dry run)"
+			}
+			for _, change := range result.Changes {
+				fmt.Printf("%s %s %s%s\n", change.Kind, change.Name, pastTense(change.Action), suffix)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the objects.")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only validate the objects and show the changes.")
+	cmd.Flags().BoolVar(&prune, "prune", false, "Delete the objects absent in the file.")
+
+	return cmd
+}
+
+// DeleteCmd defines delete command.
+func DeleteCmd() *cobra.Command {
+	var specFile string
+	cmd := &cobra.Command{
+		Use:     "delete",
+		Short:   "Delete objects specified in a yaml file or stdin",
+		Example: "egctl delete -f <object_specs.yaml>",
+		Run: func(cmd *cobra.Command, args []string) {
+			visitor := buildVisitorFromFileOrStdin(specFile, cmd)
+			visitor.Visit(func(s *spec) {
+				resp, body := sendRequest(http.MethodDelete, makeURL(objectV2URL, s.Name), nil, nil, cmd)
+				if !successfulStatusCode(resp.StatusCode) {
+					exitWithAPIErr(body)
+				}
+				fmt.Printf("%s %s deleted\n", s.Kind, s.Name)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the objects.")
+
+	return cmd
+}
+
+// DiffCmd defines diff command.
+func DiffCmd() *cobra.Command {
+	var specFile string
+	var prune bool
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Diff objects in a yaml file or stdin against the running ones",
+		Long: `Diff objects in a yaml file or stdin against the running ones.
+
+The objects are validated and compared by the server with default values
+filled. It exits with 1 if there are differences, so it could be used in
+scripts.`,
+		Example: "egctl diff -f <object_specs.yaml> --prune",
+		Run: func(cmd *cobra.Command, args []string) {
+			result := applyBundle(readFileOrStdin(specFile, cmd), true, prune, cmd)
+
+			changed := false
+			for _, change := range result.Changes {
+				switch change.Action {
+				case "unchanged":
+					continue
+				case "create":
+					fmt.Printf("%s %s: not created\n", change.Kind, change.Name)
+				case "delete":
+					fmt.Printf("%s %s (resource version %d): to be deleted\n",
+						change.Kind, change.Name, change.ResourceVersion)
+				default:
+					fmt.Printf("%s %s (resource version %d):\n",
+						change.Kind, change.Name, change.ResourceVersion)
+					for _, d := range change.Diffs {
+						fmt.Printf("  %s\n", d)
+					}
+				}
+				changed = true
+			}
+
+			if changed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the objects.")
+	cmd.Flags().BoolVar(&prune, "prune", false, "Show the objects absent in the file as deletions.")
+
+	return cmd
+}
+
+// applyBundle applies the objects in the bundle by the server.
+func applyBundle(bundle []byte, dryRun, prune bool, cmd *cobra.Command) *bundleResult {
+	url := fmt.Sprintf("%s?dryRun=%t&prune=%t", makeURL(bundleURL), dryRun, prune)
+	resp, body := sendRequest(http.MethodPost, url, nil, bundle, cmd)
+	if !successfulStatusCode(resp.StatusCode) {
+		exitWithAPIErr(body)
+	}
+
+	result := &bundleResult{}
+	if err := yaml.Unmarshal(body, result); err != nil {
+		ExitWithErrorf("%s failed: unmarshal %s to yaml failed: %v", cmd.Short, body, err)
+	}
+	return result
+}
+
+func pastTense(action string) string {
+	switch action {
+	case "create":
+		return "created"
+	case "update":
+		return "updated"
+	case "delete":
+		return "deleted"
+	default:
+		return action
+	}
+}
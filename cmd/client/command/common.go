@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"text/tabwriter"
 
 	yamljsontool "github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
@@ -106,6 +107,19 @@ const (
 
 	// MeshIngressURL is the mesh ingress path.
 	MeshIngressURL = apiURL + "/mesh/ingresses/%s"
+
+	functionsURL     = apiURL + "/faas/%s"
+	functionURL      = apiURL + "/faas/%s/%s"
+	functionStartURL = apiURL + "/faas/%s/%s/start"
+	functionStopURL  = apiURL + "/faas/%s/%s/stop"
+
+	apiV2URL = "/apis/v2"
+
+	objectsV2URL   = apiV2URL + "/objects"
+	objectV2URL    = apiV2URL + "/objects/%s"
+	pipelinesV2URL = apiV2URL + "/pipelines"
+	pipelineV2URL  = apiV2URL + "/pipelines/%s"
+	bundleURL      = apiV2URL + "/bundle"
 )
 
 func makeURL(urlTemplate string, a ...interface{}) string {
@@ -117,10 +131,26 @@ func successfulStatusCode(code int) bool {
 }
 
 func handleRequest(httpMethod string, url string, reqBody []byte, cmd *cobra.Command) {
+	resp, body := sendRequest(httpMethod, url, nil, reqBody, cmd)
+	if !successfulStatusCode(resp.StatusCode) {
+		exitWithAPIErr(body)
+	}
+
+	if len(body) != 0 {
+		printBody(body)
+	}
+}
+
+// sendRequest sends the request and returns the response with its body,
+// it exits if the request fails, but not if the status code is failed.
+func sendRequest(httpMethod string, url string, header http.Header, reqBody []byte, cmd *cobra.Command) (*http.Response, []byte) {
 	req, err := http.NewRequest(httpMethod, url, bytes.NewReader(reqBody))
 	if err != nil {
 		ExitWithError(err)
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -133,19 +163,17 @@ func handleRequest(httpMethod string, url string, reqBody []byte, cmd *cobra.Com
 		ExitWithErrorf("%s failed: %v", cmd.Short, err)
 	}
 
-	if !successfulStatusCode(resp.StatusCode) {
-		msg := string(body)
-		apiErr := &APIErr{}
-		err = yaml.Unmarshal(body, apiErr)
-		if err == nil {
-			msg = apiErr.Message
-		}
-		ExitWithErrorf("%d: %s", apiErr.Code, msg)
-	}
+	return resp, body
+}
 
-	if len(body) != 0 {
-		printBody(body)
+func exitWithAPIErr(body []byte) {
+	msg := string(body)
+	apiErr := &APIErr{}
+	err := yaml.Unmarshal(body, apiErr)
+	if err == nil {
+		msg = apiErr.Message
 	}
+	ExitWithErrorf("%d: %s", apiErr.Code, msg)
 }
 
 func printBody(body []byte) {
@@ -159,12 +187,68 @@ func printBody(body []byte) {
 		if err != nil {
 			ExitWithErrorf("yaml %s to json failed: %v", body, err)
 		}
+	case "table":
+		output = toTable(body)
 	}
 
 	fmt.Printf("%s", output)
 }
 
+// toTable renders the list of objects as a table, other bodies are kept
+// in yaml, which is readable enough.
+func toTable(body []byte) []byte {
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal(body, &items); err != nil || len(items) == 0 {
+		return body
+	}
+
+	// The objects of API v2 are wrapped with their resource versions.
+	_, versioned := items[0]["resourceVersion"]
+
+	buff := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buff, 0, 8, 2, ' ', 0)
+	if versioned {
+		fmt.Fprintln(w, "NAME\tKIND\tRESOURCE VERSION")
+	} else {
+		fmt.Fprintln(w, "NAME\tKIND")
+	}
+	for _, item := range items {
+		fields := item
+		if versioned {
+			spec, ok := item["spec"].(map[interface{}]interface{})
+			if !ok {
+				return body
+			}
+			fields = map[string]interface{}{}
+			for k, v := range spec {
+				fields[fmt.Sprint(k)] = v
+			}
+		}
+
+		name, kind := fields["name"], fields["kind"]
+		if name == nil {
+			return body
+		}
+		if kind == nil {
+			kind = "-"
+		}
+
+		if versioned {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", name, kind, item["resourceVersion"])
+		} else {
+			fmt.Fprintf(w, "%v\t%v\n", name, kind)
+		}
+	}
+	w.Flush()
+
+	return buff.Bytes()
+}
+
 func buildVisitorFromFileOrStdin(specFile string, cmd *cobra.Command) SpecVisitor {
+	return NewSpecVisitor(string(readFileOrStdin(specFile, cmd)))
+}
+
+func readFileOrStdin(specFile string, cmd *cobra.Command) []byte {
 	var buff []byte
 	var err error
 	if specFile != "" {
@@ -178,5 +262,5 @@ func buildVisitorFromFileOrStdin(specFile string, cmd *cobra.Command) SpecVisito
 			ExitWithErrorf("%s failed: %v", cmd.Short, err)
 		}
 	}
-	return NewSpecVisitor(string(buff))
+	return buff
 }
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// FunctionCmd defines function command.
+func FunctionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "function",
+		Aliases: []string{"fn", "faas"},
+		Short:   "View and scale functions of FaaSControllers",
+	}
+
+	cmd.AddCommand(listFunctionsCmd())
+	cmd.AddCommand(getFunctionCmd())
+	cmd.AddCommand(scaleFunctionCmd("start", "Scale up a function by starting it", functionStartURL))
+	cmd.AddCommand(scaleFunctionCmd("stop", "Scale down a function to zero by stopping it", functionStopURL))
+
+	return cmd
+}
+
+func listFunctionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List all functions of a FaaSController",
+		Example: "egctl function list <controller_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one controller name")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(functionsURL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func getFunctionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "get",
+		Short:   "Get a function",
+		Example: "egctl function get <controller_name> <function_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("requires one controller name and one function name")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(functionURL, args[0], args[1]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func scaleFunctionCmd(action, short, urlTemplate string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     action,
+		Short:   short,
+		Example: fmt.Sprintf("egctl function %s <controller_name> <function_name>", action),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("requires one controller name and one function name")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, body := sendRequest(http.MethodPut, makeURL(urlTemplate, args[0], args[1]), nil, nil, cmd)
+			if !successfulStatusCode(resp.StatusCode) {
+				exitWithAPIErr(body)
+			}
+			fmt.Printf("function %s: %s requested\n", args[1], action)
+		},
+	}
+
+	return cmd
+}
@@ -19,7 +19,9 @@ package command
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -155,6 +157,7 @@ func statusObjectCmd() *cobra.Command {
 
 	cmd.AddCommand(getStatusObjectCmd())
 	cmd.AddCommand(listStatusObjectsCmd())
+	cmd.AddCommand(tailStatusObjectCmd())
 
 	return cmd
 }
@@ -180,6 +183,37 @@ func getStatusObjectCmd() *cobra.Command {
 	return cmd
 }
 
+func tailStatusObjectCmd() *cobra.Command {
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:     "tail",
+		Short:   "Get status of an object periodically until interrupted",
+		Example: "egctl object status tail <object_name> --interval 5s",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one object name to be retrieved")
+			}
+			if interval <= 0 {
+				return errors.New("interval must be positive")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			for {
+				fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+				handleRequest(http.MethodGet, makeURL(statusObjectURL, args[0]), nil, cmd)
+				time.Sleep(interval)
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "The interval between two retrievals.")
+
+	return cmd
+}
+
 func listStatusObjectsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "list",
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// PipelineCmd defines pipeline command.
+func PipelineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "pipeline",
+		Aliases: []string{"p", "pipelines"},
+		Short:   "View and delete HTTP pipelines",
+	}
+
+	cmd.AddCommand(listPipelinesCmd())
+	cmd.AddCommand(getPipelineCmd())
+	cmd.AddCommand(deletePipelineCmd())
+
+	return cmd
+}
+
+func listPipelinesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List all pipelines",
+		Example: "egctl pipeline list",
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(pipelinesV2URL), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func getPipelineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "get",
+		Short:   "Get a pipeline with its resource version",
+		Example: "egctl pipeline get <pipeline_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one pipeline name to be retrieved")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(pipelineV2URL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func deletePipelineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete",
+		Short:   "Delete a pipeline",
+		Example: "egctl pipeline delete <pipeline_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one pipeline name to be deleted")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodDelete, makeURL(pipelineV2URL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
@@ -70,6 +70,28 @@ var exampleUsage = `  # List APIs.
 
   # Get object status
   egctl object status get <object_name>
+
+  # Get object status every 5 seconds.
+  egctl object status tail <object_name> --interval 5s
+
+  # Create or update objects from a yaml file all at once.
+  egctl apply -f <object_specs.yaml>
+
+  # Validate objects in a yaml file, and show the changes including the deletions of absent objects.
+  egctl apply -f <object_specs.yaml> --prune --dry-run
+
+  # Diff objects in a yaml file against the running ones.
+  egctl diff -f <object_specs.yaml>
+
+  # Delete objects in a yaml file.
+  egctl delete -f <object_specs.yaml>
+
+  # List pipelines in a table.
+  egctl pipeline list -o table
+
+  # Scale down a function to zero, and scale it up again.
+  egctl function stop <controller_name> <function_name>
+  egctl function start <controller_name> <function_name>
 `
 
 func main() {
@@ -80,7 +102,7 @@ func main() {
 		SuggestFor: []string{"egctl"},
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			switch command.CommandlineGlobalFlags.OutputFormat {
-			case "yaml", "json":
+			case "yaml", "json", "table":
 			default:
 				command.ExitWithErrorf("unsupported output format: %s",
 					command.CommandlineGlobalFlags.OutputFormat)
@@ -106,9 +128,14 @@ func main() {
 
 	rootCmd.AddCommand(
 		command.APICmd(),
+		command.ApplyCmd(),
+		command.DeleteCmd(),
+		command.DiffCmd(),
 		command.HealthCmd(),
 		command.ObjectCmd(),
 		command.MemberCmd(),
+		command.PipelineCmd(),
+		command.FunctionCmd(),
 		command.WasmCmd(),
 		completionCmd,
 	)
@@ -116,7 +143,7 @@ func main() {
 	rootCmd.PersistentFlags().StringVar(&command.CommandlineGlobalFlags.Server,
 		"server", "localhost:2381", "The address of the Easegress endpoint")
 	rootCmd.PersistentFlags().StringVarP(&command.CommandlineGlobalFlags.OutputFormat,
-		"output", "o", "yaml", "Output format(json, yaml)")
+		"output", "o", "yaml", "Output format(json, yaml, table)")
 
 	err := rootCmd.Execute()
 	if err != nil {
@@ -21,6 +21,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/megaease/easegress/pkg/api"
 	"github.com/megaease/easegress/pkg/cluster"
@@ -33,6 +34,7 @@ import (
 	"github.com/megaease/easegress/pkg/profile"
 	_ "github.com/megaease/easegress/pkg/registry"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/expr"
 	"github.com/megaease/easegress/pkg/version"
 )
 
@@ -56,6 +58,10 @@ func main() {
 	defer logger.Sync()
 	logger.Infof("%s", version.Long)
 
+	// NOTE: The duration has been checked in validation.
+	exprTimeout, _ := time.ParseDuration(opt.ExpressionTimeout)
+	expr.SetTimeout(exprTimeout)
+
 	if opt.SignalUpgrade {
 		pid, err := pidfile.Read(opt)
 
@@ -38,6 +38,9 @@ const (
 	// APIPrefix is the prefix of api.
 	APIPrefix = "/apis/v1"
 
+	// APIPrefixV2 is the prefix of api v2.
+	APIPrefixV2 = "/apis/v2"
+
 	lockKey = "/config/lock"
 
 	// ConfigVersionKey is the key of header for config version.
@@ -106,6 +109,17 @@ func (s *Server) registerAPIs() {
 	}
 
 	RegisterAPIs(group)
+
+	groupV2 := &Group{
+		Group:  "admin-v2",
+		Prefix: APIPrefixV2,
+	}
+	groupV2.Entries = append(groupV2.Entries, s.objectAPIEntriesV2()...)
+	groupV2.Entries = append(groupV2.Entries, s.pipelineAPIEntriesV2()...)
+	groupV2.Entries = append(groupV2.Entries, s.optionAPIEntriesV2()...)
+	groupV2.Entries = append(groupV2.Entries, s.bundleAPIEntriesV2()...)
+
+	RegisterAPIs(groupV2)
 }
 
 func (s *Server) listAPIEntries() []*Entry {
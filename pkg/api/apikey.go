@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/apikeyauth"
+)
+
+// APIKeyPrefix is the prefix of the API key management APIs.
+const APIKeyPrefix = "/apikeys"
+
+// createdAPIKey is returned when an API key is created, it is the only
+// time the key is visible.
+type createdAPIKey struct {
+	apikeyauth.APIKey `yaml:",inline"`
+	Key               string `yaml:"key"`
+}
+
+func readAPIKey(r *http.Request) (*apikeyauth.APIKey, error) {
+	body, e := ioutil.ReadAll(r.Body)
+	if e != nil {
+		return nil, fmt.Errorf("read body failed: %v", e)
+	}
+
+	key := &apikeyauth.APIKey{}
+	if e = yaml.Unmarshal(body, key); e != nil {
+		return nil, fmt.Errorf("unmarshal api key failed: %v", e)
+	}
+	if e = key.Validate(); e != nil {
+		return nil, e
+	}
+	return key, nil
+}
+
+func (s *Server) _getAPIKey(id string) (*apikeyauth.APIKey, error) {
+	value, e := s.cluster.Get(s.cluster.Layout().APIKeyKey(id))
+	if e != nil {
+		ClusterPanic(e)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return apikeyauth.ParseAPIKey(*value)
+}
+
+func (s *Server) _putAPIKey(key *apikeyauth.APIKey) {
+	buf, e := yaml.Marshal(key)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", key, e))
+	}
+	if e = s.cluster.Put(s.cluster.Layout().APIKeyKey(key.ID), string(buf)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	buf, e := yaml.Marshal(v)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, e))
+	}
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buf)
+}
+
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, e := readAPIKey(r)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	id, secret := apikeyauth.GenerateKey()
+	key.ID = id
+	key.KeyHash = apikeyauth.HashKey(secret)
+	key.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	s._putAPIKey(key)
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, id))
+	w.WriteHeader(http.StatusCreated)
+
+	key.KeyHash = ""
+	writeYAML(w, &createdAPIKey{APIKey: *key, Key: secret})
+}
+
+func (s *Server) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	values, e := s.cluster.GetPrefix(s.cluster.Layout().APIKeyPrefix())
+	if e != nil {
+		ClusterPanic(e)
+	}
+
+	keys := make([]*apikeyauth.APIKey, 0, len(values))
+	for k, v := range values {
+		key, e := apikeyauth.ParseAPIKey(v)
+		if e != nil {
+			HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("parse api key %s failed: %v", k, e))
+			return
+		}
+		key.KeyHash = ""
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	writeYAML(w, keys)
+}
+
+func (s *Server) getAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, e := s._getAPIKey(chi.URLParam(r, "id"))
+	if e != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, e)
+		return
+	}
+	if key == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	key.KeyHash = ""
+	writeYAML(w, key)
+}
+
+func (s *Server) updateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	update, e := readAPIKey(r)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+	if update.ID != "" && update.ID != id {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("inconsistent id in url and body"))
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	key, e := s._getAPIKey(id)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, e)
+		return
+	}
+	if key == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	// NOTE: The key itself can't be changed, create a new key to rotate it.
+	update.ID, update.KeyHash, update.CreatedAt = key.ID, key.KeyHash, key.CreatedAt
+	s._putAPIKey(update)
+}
+
+func (s *Server) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	s.Lock()
+	defer s.Unlock()
+
+	key, e := s._getAPIKey(id)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusInternalServerError, e)
+		return
+	}
+	if key == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if e = s.cluster.Delete(s.cluster.Layout().APIKeyKey(id)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func appendAPIKeyAPI(s *Server, group *Group) {
+	group.Entries = append(group.Entries, []*Entry{
+		{
+			Path:    APIKeyPrefix,
+			Method:  http.MethodPost,
+			Handler: s.createAPIKey,
+		},
+		{
+			Path:    APIKeyPrefix,
+			Method:  http.MethodGet,
+			Handler: s.listAPIKeys,
+		},
+		{
+			Path:    APIKeyPrefix + "/{id}",
+			Method:  http.MethodGet,
+			Handler: s.getAPIKey,
+		},
+		{
+			Path:    APIKeyPrefix + "/{id}",
+			Method:  http.MethodPut,
+			Handler: s.updateAPIKey,
+		},
+		{
+			Path:    APIKeyPrefix + "/{id}",
+			Method:  http.MethodDelete,
+			Handler: s.deleteAPIKey,
+		},
+	}...)
+}
+
+func init() {
+	appendAddonAPIs = append(appendAddonAPIs, appendAPIKeyAPI)
+}
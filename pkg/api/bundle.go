@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamljsontool "github.com/ghodss/yaml"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// BundlePrefix is the path to apply bundles in API v2.
+const BundlePrefix = "/bundle"
+
+// The actions of the objects in a bundle.
+const (
+	BundleActionCreate    = "create"
+	BundleActionUpdate    = "update"
+	BundleActionDelete    = "delete"
+	BundleActionUnchanged = "unchanged"
+)
+
+type (
+	// BundleResult is the result of applying a bundle, which is a YAML
+	// stream of object specs.
+	BundleResult struct {
+		DryRun  bool            `yaml:"dryRun"`
+		Changes []*BundleChange `yaml:"changes"`
+	}
+
+	// BundleChange is the change of an object in a bundle.
+	BundleChange struct {
+		Name   string `yaml:"name"`
+		Kind   string `yaml:"kind"`
+		Action string `yaml:"action"`
+		// ResourceVersion is the version of the running object, it is
+		// zero for creations.
+		ResourceVersion int64 `yaml:"resourceVersion,omitempty"`
+		// Diffs are the changed fields of updates, in the format of
+		// `~ path: old -> new`, `+ path: new` or `- path: old`.
+		Diffs []string `yaml:"diffs,omitempty"`
+	}
+)
+
+func (s *Server) bundleAPIEntriesV2() []*Entry {
+	return []*Entry{
+		{
+			Path:    BundlePrefix,
+			Method:  "POST",
+			Handler: s.applyBundleV2,
+		},
+	}
+}
+
+// applyBundleV2 applies all objects in the bundle at once or none of them.
+// With the query dryRun, it only validates the bundle and responds the
+// changes. With the query prune, the objects absent in the bundle are
+// deleted.
+func (s *Server) applyBundleV2(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := boolQuery(r, "dryRun")
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+	prune, err := boolQuery(r, "prune")
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	specs, err := s.readBundleV2(r)
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	changes, kvs, versions, err := s._diffBundleV2(specs, prune)
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if !dryRun && len(kvs) != 0 {
+		// NOTE: All puts and deletions are in one transaction, which
+		// fails if any of the objects is modified after the comparison.
+		if !s._putAndDeleteV2(kvs, versions) {
+			HandleAPIErrorV2(w, r, http.StatusConflict,
+				fmt.Errorf("objects in the bundle are modified by others, please retry"))
+			return
+		}
+		s.upgradeConfigVersion(w, r)
+	}
+
+	writeV2(w, r, http.StatusOK, &BundleResult{DryRun: dryRun, Changes: changes})
+}
+
+func boolQuery(r *http.Request, key string) (bool, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid query %s: %s", key, value)
+	}
+	return b, nil
+}
+
+// readBundleV2 reads and validates all specs in the bundle, the errors of
+// them are reported together.
+func (s *Server) readBundleV2(r *http.Request) ([]*supervisor.Spec, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+
+	var specs []*supervisor.Spec
+	var errs []string
+	names := map[string]struct{}{}
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	for i := 0; ; i++ {
+		m := map[string]interface{}{}
+		err = decoder.Decode(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: document %d: %v", i, err)
+		}
+		if len(m) == 0 {
+			continue
+		}
+
+		buff, err := yaml.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %#v to yaml failed: %v", m, err)
+		}
+		spec, err := s.super.NewSpec(string(buff))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("document %d(%v): %v", i, m["name"], err))
+			continue
+		}
+
+		if _, exists := names[spec.Name()]; exists {
+			errs = append(errs, fmt.Sprintf("document %d: duplicated name: %s", i, spec.Name()))
+			continue
+		}
+		names[spec.Name()] = struct{}{}
+		specs = append(specs, spec)
+	}
+
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("invalid bundle:\n%s", strings.Join(errs, "\n"))
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty bundle")
+	}
+	return specs, nil
+}
+
+// _diffBundleV2 compares the specs with the running objects, it returns
+// the changes, the key-values to put or delete and their versions.
+func (s *Server) _diffBundleV2(specs []*supervisor.Spec, prune bool) (
+	[]*BundleChange, map[string]*string, map[string]int64, error) {
+	kvs, err := s.cluster.GetRawPrefix(s.cluster.Layout().ConfigObjectPrefix())
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	existedSpecs := map[string]*supervisor.Spec{}
+	versions := map[string]int64{}
+	for _, kv := range kvs {
+		spec, err := s.super.NewSpec(string(kv.Value))
+		if err != nil {
+			panic(fmt.Errorf("bad spec(err: %v) from yaml: %s", err, kv.Value))
+		}
+		existedSpecs[spec.Name()] = spec
+		versions[spec.Name()] = kv.ModRevision
+	}
+
+	var changes []*BundleChange
+	var errs []string
+	puts := map[string]*string{}
+	putVersions := map[string]int64{}
+	for _, spec := range specs {
+		change := &BundleChange{Name: spec.Name(), Kind: spec.Kind()}
+		changes = append(changes, change)
+
+		existedSpec := existedSpecs[spec.Name()]
+		delete(existedSpecs, spec.Name())
+
+		switch {
+		case existedSpec == nil:
+			change.Action = BundleActionCreate
+		case existedSpec.Kind() != spec.Kind():
+			errs = append(errs, fmt.Sprintf("%s: different kinds: %s, %s",
+				spec.Name(), existedSpec.Kind(), spec.Kind()))
+			continue
+		case existedSpec.Equals(spec):
+			change.Action = BundleActionUnchanged
+			change.ResourceVersion = versions[spec.Name()]
+			continue
+		default:
+			change.Action = BundleActionUpdate
+			change.ResourceVersion = versions[spec.Name()]
+			diffSpecV2("", existedSpec.RawSpec(), spec.RawSpec(), &change.Diffs)
+		}
+
+		key := s.cluster.Layout().ConfigObjectKey(spec.Name())
+		yamlConfig := spec.YAMLConfig()
+		puts[key] = &yamlConfig
+		putVersions[key] = change.ResourceVersion
+	}
+
+	if len(errs) != 0 {
+		return nil, nil, nil, fmt.Errorf("invalid bundle:\n%s", strings.Join(errs, "\n"))
+	}
+
+	if prune {
+		var deletions []*BundleChange
+		for name, spec := range existedSpecs {
+			deletions = append(deletions, &BundleChange{
+				Name:            name,
+				Kind:            spec.Kind(),
+				Action:          BundleActionDelete,
+				ResourceVersion: versions[name],
+			})
+			key := s.cluster.Layout().ConfigObjectKey(name)
+			puts[key] = nil
+			putVersions[key] = versions[name]
+		}
+
+		// NOTE: Keep it consistent.
+		sort.Slice(deletions, func(i, j int) bool {
+			return deletions[i].Name < deletions[j].Name
+		})
+		changes = append(changes, deletions...)
+	}
+
+	return changes, puts, putVersions, nil
+}
+
+// diffSpecV2 appends the differences from the old value to the new one.
+func diffSpecV2(path string, oldValue, newValue interface{}, diffs *[]string) {
+	oldMap, oldIsMap := toStringMap(oldValue)
+	newMap, newIsMap := toStringMap(newValue)
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, exists := oldMap[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			subPath := k
+			if path != "" {
+				subPath = path + "." + k
+			}
+			oldSub, oldExists := oldMap[k]
+			newSub, newExists := newMap[k]
+			switch {
+			case !oldExists:
+				*diffs = append(*diffs, fmt.Sprintf("+ %s: %s", subPath, formatValueV2(newSub)))
+			case !newExists:
+				*diffs = append(*diffs, fmt.Sprintf("- %s: %s", subPath, formatValueV2(oldSub)))
+			default:
+				diffSpecV2(subPath, oldSub, newSub, diffs)
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList {
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			subPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldList):
+				*diffs = append(*diffs, fmt.Sprintf("+ %s: %s", subPath, formatValueV2(newList[i])))
+			case i >= len(newList):
+				*diffs = append(*diffs, fmt.Sprintf("- %s: %s", subPath, formatValueV2(oldList[i])))
+			default:
+				diffSpecV2(subPath, oldList[i], newList[i], diffs)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*diffs = append(*diffs, fmt.Sprintf("~ %s: %s -> %s", path, formatValueV2(oldValue), formatValueV2(newValue)))
+	}
+}
+
+// toStringMap converts the maps unmarshaled from YAML to map[string]interface{}.
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			result[fmt.Sprint(k)] = v
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// formatValueV2 formats the value in one line of JSON.
+func formatValueV2(v interface{}) string {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	buff, err = yamljsontool.YAMLToJSON(buff)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(buff))
+}
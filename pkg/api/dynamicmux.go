@@ -82,8 +82,12 @@ func (m *dynamicMux) reloadAPIs() {
 	router.Use(m.newRecoverer)
 
 	for _, apiGroup := range apiGroups {
+		prefix := apiGroup.Prefix
+		if prefix == "" {
+			prefix = APIPrefix
+		}
 		for _, api := range apiGroup.Entries {
-			path := APIPrefix + api.Path
+			path := prefix + api.Path
 
 			switch api.Method {
 			case "GET":
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/ipfilter"
+)
+
+// IPFilterListPrefix is the prefix of the IP list management APIs.
+const IPFilterListPrefix = "/ipfilter/lists"
+
+func (s *Server) listIPFilterLists(w http.ResponseWriter, r *http.Request) {
+	prefix := s.cluster.Layout().IPFilterListPrefix()
+	kvs, e := s.cluster.GetPrefix(prefix)
+	if e != nil {
+		ClusterPanic(e)
+	}
+
+	names := []string{}
+	for k := range kvs {
+		names = append(names, strings.TrimPrefix(k, prefix))
+	}
+	sort.Strings(names)
+
+	buf, e := yaml.Marshal(names)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", names, e))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buf)
+}
+
+func (s *Server) getIPFilterList(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	value, e := s.cluster.Get(s.cluster.Layout().IPFilterListKey(name))
+	if e != nil {
+		ClusterPanic(e)
+	}
+	if value == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(*value))
+}
+
+func (s *Server) putIPFilterList(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	body, e := ioutil.ReadAll(r.Body)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	if _, e = ipfilter.ParseList(bytes.NewReader(body)); e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	if e = s.cluster.Put(s.cluster.Layout().IPFilterListKey(name), string(body)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func (s *Server) deleteIPFilterList(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	key := s.cluster.Layout().IPFilterListKey(name)
+	value, e := s.cluster.Get(key)
+	if e != nil {
+		ClusterPanic(e)
+	}
+	if value == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if e = s.cluster.Delete(key); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func appendIPFilterAPI(s *Server, group *Group) {
+	group.Entries = append(group.Entries, []*Entry{
+		{
+			Path:    IPFilterListPrefix,
+			Method:  http.MethodGet,
+			Handler: s.listIPFilterLists,
+		},
+		{
+			Path:    IPFilterListPrefix + "/{name}",
+			Method:  http.MethodGet,
+			Handler: s.getIPFilterList,
+		},
+		{
+			Path:    IPFilterListPrefix + "/{name}",
+			Method:  http.MethodPut,
+			Handler: s.putIPFilterList,
+		},
+		{
+			Path:    IPFilterListPrefix + "/{name}",
+			Method:  http.MethodDelete,
+			Handler: s.deleteIPFilterList,
+		},
+	}...)
+}
+
+func init() {
+	appendAddonAPIs = append(appendAddonAPIs, appendIPFilterAPI)
+}
@@ -0,0 +1,486 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// PipelinePrefix is the prefix of pipelines in API v2.
+const PipelinePrefix = "/pipelines"
+
+func (s *Server) objectAPIEntriesV2() []*Entry {
+	return s.crudAPIEntriesV2(ObjectPrefix, "")
+}
+
+func (s *Server) pipelineAPIEntriesV2() []*Entry {
+	entries := s.crudAPIEntriesV2(PipelinePrefix, httppipeline.Kind)
+
+	filterPrefix := PipelinePrefix + "/{name}/filters"
+	return append(entries, []*Entry{
+		{
+			Path:    filterPrefix,
+			Method:  "GET",
+			Handler: s.listFiltersV2,
+		},
+		{
+			Path:    filterPrefix,
+			Method:  "POST",
+			Handler: s.createFilterV2,
+		},
+		{
+			Path:    filterPrefix + "/{filter}",
+			Method:  "GET",
+			Handler: s.getFilterV2,
+		},
+		{
+			Path:    filterPrefix + "/{filter}",
+			Method:  "PUT",
+			Handler: s.updateFilterV2,
+		},
+		{
+			Path:    filterPrefix + "/{filter}",
+			Method:  "DELETE",
+			Handler: s.deleteFilterV2,
+		},
+	}...)
+}
+
+// crudAPIEntriesV2 returns the entries to create, read, update and delete
+// the objects of the kind, or all objects if the kind is empty.
+func (s *Server) crudAPIEntriesV2(prefix, kind string) []*Entry {
+	return []*Entry{
+		{
+			Path:    prefix,
+			Method:  "GET",
+			Handler: s.listObjectsV2(kind),
+		},
+		{
+			Path:    prefix,
+			Method:  "POST",
+			Handler: s.createObjectV2(kind),
+		},
+		{
+			Path:    prefix + "/{name}",
+			Method:  "GET",
+			Handler: s.getObjectV2(kind),
+		},
+		{
+			Path:    prefix + "/{name}",
+			Method:  "PUT",
+			Handler: s.updateObjectV2(kind),
+		},
+		{
+			Path:    prefix + "/{name}",
+			Method:  "DELETE",
+			Handler: s.deleteObjectV2(kind),
+		},
+	}
+}
+
+func (s *Server) listObjectsV2(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// No need to lock.
+
+		k := kind
+		if k == "" {
+			k = r.URL.Query().Get("kind")
+		}
+		writeV2(w, r, http.StatusOK, s._listObjectsV2(k))
+	}
+}
+
+// _getKindObjectV2 returns the object of the kind, an object of another
+// kind is regarded as not found.
+func (s *Server) _getKindObjectV2(kind, name string) (*supervisor.Spec, int64) {
+	spec, version := s._getObjectV2(name)
+	if spec == nil || (kind != "" && spec.Kind() != kind) {
+		return nil, 0
+	}
+	return spec, version
+}
+
+func (s *Server) getObjectV2(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		// No need to lock.
+
+		spec, version := s._getKindObjectV2(kind, name)
+		if spec == nil {
+			HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("%s not found", name))
+			return
+		}
+
+		writeObjectV2(w, r, http.StatusOK, newObjectV2(spec, version))
+	}
+}
+
+func (s *Server) createObjectV2(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec, err := s.readObjectSpecV2(r, kind, "")
+		if err != nil {
+			HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		name := spec.Name()
+
+		s.Lock()
+		defer s.Unlock()
+
+		if existedSpec, _ := s._getObjectV2(name); existedSpec != nil {
+			HandleAPIErrorV2(w, r, http.StatusConflict, fmt.Errorf("conflict name: %s", name))
+			return
+		}
+
+		obj := s._putObjectV2(spec, 0)
+		if obj == nil {
+			HandleAPIErrorV2(w, r, http.StatusConflict, fmt.Errorf("conflict name: %s", name))
+			return
+		}
+		s.upgradeConfigVersion(w, r)
+
+		w.Header().Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, name))
+		writeObjectV2(w, r, http.StatusCreated, obj)
+	}
+}
+
+func (s *Server) updateObjectV2(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		spec, err := s.readObjectSpecV2(r, kind, name)
+		if err != nil {
+			HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		s.Lock()
+		defer s.Unlock()
+
+		existedSpec, version := s._getKindObjectV2(kind, name)
+		if existedSpec == nil {
+			HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("%s not found", name))
+			return
+		}
+		if err = checkResourceVersion(r, version); err != nil {
+			HandleAPIErrorV2(w, r, http.StatusPreconditionFailed, err)
+			return
+		}
+		if existedSpec.Kind() != spec.Kind() {
+			HandleAPIErrorV2(w, r, http.StatusBadRequest,
+				fmt.Errorf("different kinds: %s, %s", existedSpec.Kind(), spec.Kind()))
+			return
+		}
+
+		obj := s._putObjectV2(spec, version)
+		if obj == nil {
+			handleModifiedV2(w, r, name)
+			return
+		}
+		s.upgradeConfigVersion(w, r)
+
+		writeObjectV2(w, r, http.StatusOK, obj)
+	}
+}
+
+func (s *Server) deleteObjectV2(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		s.Lock()
+		defer s.Unlock()
+
+		spec, version := s._getKindObjectV2(kind, name)
+		if spec == nil {
+			HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("%s not found", name))
+			return
+		}
+		if err := checkResourceVersion(r, version); err != nil {
+			HandleAPIErrorV2(w, r, http.StatusPreconditionFailed, err)
+			return
+		}
+
+		if !s._deleteObjectV2(name, version) {
+			handleModifiedV2(w, r, name)
+			return
+		}
+		s.upgradeConfigVersion(w, r)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pipelineFilters returns the raw spec of the pipeline and its filters,
+// the filters are the elements of the raw spec, so changing them
+// changes the raw spec.
+func pipelineFilters(spec *supervisor.Spec) (map[string]interface{}, []interface{}) {
+	m := newObjectV2(spec, 0).Spec
+	filters, _ := m["filters"].([]interface{})
+	return m, filters
+}
+
+func filterName(filter interface{}) string {
+	m, _ := filter.(map[interface{}]interface{})
+	name, _ := m["name"].(string)
+	return name
+}
+
+func findFilter(filters []interface{}, name string) int {
+	for i, f := range filters {
+		if filterName(f) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// _getPipelineV2 gets the pipeline in the url, it writes the error if the
+// pipeline is not found.
+func (s *Server) _getPipelineV2(w http.ResponseWriter, r *http.Request) (*supervisor.Spec, int64) {
+	name := chi.URLParam(r, "name")
+	spec, version := s._getKindObjectV2(httppipeline.Kind, name)
+	if spec == nil {
+		HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("pipeline %s not found", name))
+	}
+	return spec, version
+}
+
+// readFilterV2 reads the spec of the filter, the name in the url is filled
+// if it is absent in the body.
+func readFilterV2(r *http.Request, name string) (map[interface{}]interface{}, error) {
+	m, err := readBodyV2(r)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := map[interface{}]interface{}{}
+	for k, v := range m {
+		filter[k] = v
+	}
+
+	if name != "" {
+		if v, ok := filter["name"]; !ok {
+			filter["name"] = name
+		} else if v != name {
+			return nil, fmt.Errorf("name must be %s, got %v", name, v)
+		}
+	}
+	if filterName(filter) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return filter, nil
+}
+
+// _putPipelineFiltersV2 validates the pipeline with the filters and puts
+// it if it is not modified since the version, it writes the error if the
+// pipeline is invalid or modified.
+func (s *Server) _putPipelineFiltersV2(w http.ResponseWriter, r *http.Request,
+	m map[string]interface{}, filters []interface{}, version int64) *ObjectV2 {
+	m["filters"] = filters
+	buff, err := yaml.Marshal(m)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", m, err))
+	}
+
+	spec, err := s.super.NewSpec(string(buff))
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return nil
+	}
+
+	obj := s._putObjectV2(spec, version)
+	if obj == nil {
+		handleModifiedV2(w, r, spec.Name())
+		return nil
+	}
+	s.upgradeConfigVersion(w, r)
+	return obj
+}
+
+func (s *Server) listFiltersV2(w http.ResponseWriter, r *http.Request) {
+	// No need to lock.
+
+	spec, version := s._getPipelineV2(w, r)
+	if spec == nil {
+		return
+	}
+
+	_, filters := pipelineFilters(spec)
+	objects := make([]*ObjectV2, 0, len(filters))
+	for _, f := range filters {
+		objects = append(objects, newFilterObjectV2(f, version))
+	}
+	writeV2(w, r, http.StatusOK, objects)
+}
+
+// newFilterObjectV2 creates the object of the filter, whose resource
+// version is the one of its pipeline.
+func newFilterObjectV2(filter interface{}, version int64) *ObjectV2 {
+	spec := map[string]interface{}{}
+	m, _ := filter.(map[interface{}]interface{})
+	for k, v := range m {
+		spec[fmt.Sprint(k)] = v
+	}
+	return &ObjectV2{ResourceVersion: version, Spec: spec}
+}
+
+func (s *Server) getFilterV2(w http.ResponseWriter, r *http.Request) {
+	// No need to lock.
+
+	spec, version := s._getPipelineV2(w, r)
+	if spec == nil {
+		return
+	}
+
+	name := chi.URLParam(r, "filter")
+	_, filters := pipelineFilters(spec)
+	i := findFilter(filters, name)
+	if i < 0 {
+		HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("filter %s not found", name))
+		return
+	}
+
+	writeObjectV2(w, r, http.StatusOK, newFilterObjectV2(filters[i], version))
+}
+
+func (s *Server) createFilterV2(w http.ResponseWriter, r *http.Request) {
+	filter, err := readFilterV2(r, "")
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+	name := filterName(filter)
+
+	s.Lock()
+	defer s.Unlock()
+
+	spec, version := s._getPipelineV2(w, r)
+	if spec == nil {
+		return
+	}
+	if err = checkResourceVersion(r, version); err != nil {
+		HandleAPIErrorV2(w, r, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	m, filters := pipelineFilters(spec)
+	if findFilter(filters, name) >= 0 {
+		HandleAPIErrorV2(w, r, http.StatusConflict, fmt.Errorf("conflict name: %s", name))
+		return
+	}
+
+	// NOTE: The filter is appended to the filters only, it needs to be
+	// added to the flow too if the pipeline has one.
+	obj := s._putPipelineFiltersV2(w, r, m, append(filters, filter), version)
+	if obj == nil {
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, name))
+	writeObjectV2(w, r, http.StatusCreated, newFilterObjectV2(filter, obj.ResourceVersion))
+}
+
+func (s *Server) updateFilterV2(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "filter")
+	filter, err := readFilterV2(r, name)
+	if err != nil {
+		HandleAPIErrorV2(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	spec, version := s._getPipelineV2(w, r)
+	if spec == nil {
+		return
+	}
+
+	m, filters := pipelineFilters(spec)
+	i := findFilter(filters, name)
+	if i < 0 {
+		HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("filter %s not found", name))
+		return
+	}
+	if err = checkResourceVersion(r, version); err != nil {
+		HandleAPIErrorV2(w, r, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	filters[i] = filter
+	obj := s._putPipelineFiltersV2(w, r, m, filters, version)
+	if obj == nil {
+		return
+	}
+
+	writeObjectV2(w, r, http.StatusOK, newFilterObjectV2(filter, obj.ResourceVersion))
+}
+
+func (s *Server) deleteFilterV2(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "filter")
+
+	s.Lock()
+	defer s.Unlock()
+
+	spec, version := s._getPipelineV2(w, r)
+	if spec == nil {
+		return
+	}
+
+	m, filters := pipelineFilters(spec)
+	i := findFilter(filters, name)
+	if i < 0 {
+		HandleAPIErrorV2(w, r, http.StatusNotFound, fmt.Errorf("filter %s not found", name))
+		return
+	}
+	if err := checkResourceVersion(r, version); err != nil {
+		HandleAPIErrorV2(w, r, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	// NOTE: The filter referred by the flow can't be deleted, or the
+	// pipeline becomes invalid.
+	for _, f := range spec.ObjectSpec().(*httppipeline.Spec).Flow {
+		if f.Filter == name {
+			HandleAPIErrorV2(w, r, http.StatusConflict, fmt.Errorf("filter %s is in the flow", name))
+			return
+		}
+		for _, target := range f.JumpIf {
+			if target == name {
+				HandleAPIErrorV2(w, r, http.StatusConflict,
+					fmt.Errorf("filter %s is the jump target of %s", name, f.Filter))
+				return
+			}
+		}
+	}
+
+	if s._putPipelineFiltersV2(w, r, m, append(filters[:i], filters[i+1:]...), version) == nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
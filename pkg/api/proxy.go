@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+)
+
+func (s *Server) proxyGetWeights(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	value, e := s.cluster.Get(s.cluster.Layout().ProxyWeightsKey(pipeline, filter))
+	if e != nil {
+		ClusterPanic(e)
+	}
+
+	weights := map[string]int{}
+	if value != nil {
+		weights, e = proxy.ParseWeights(*value)
+		if e != nil {
+			HandleAPIError(w, r, http.StatusInternalServerError, e)
+			return
+		}
+	}
+
+	buf, e := yaml.Marshal(weights)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", weights, e))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buf)
+}
+
+func (s *Server) proxyApplyWeights(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	body, e := ioutil.ReadAll(r.Body)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	weights, e := proxy.ParseWeights(string(body))
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+	for url, weight := range weights {
+		if weight < 0 || weight > 100 {
+			HandleAPIError(w, r, http.StatusBadRequest,
+				fmt.Errorf("weight of %s must be in [0, 100], got %d", url, weight))
+			return
+		}
+	}
+
+	buf, e := yaml.Marshal(weights)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", weights, e))
+	}
+
+	if e = s.cluster.Put(s.cluster.Layout().ProxyWeightsKey(pipeline, filter), string(buf)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func (s *Server) proxyDeleteWeights(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if e := s.cluster.Delete(s.cluster.Layout().ProxyWeightsKey(pipeline, filter)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func (s *Server) proxyGetTrafficSplit(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	value, e := s.cluster.Get(s.cluster.Layout().ProxyTrafficSplitKey(pipeline, filter))
+	if e != nil {
+		ClusterPanic(e)
+	}
+
+	weights := map[string]int{}
+	if value != nil {
+		weights, e = proxy.ParseTrafficSplitWeights(*value)
+		if e != nil {
+			HandleAPIError(w, r, http.StatusInternalServerError, e)
+			return
+		}
+	}
+
+	buf, e := yaml.Marshal(weights)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", weights, e))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buf)
+}
+
+func (s *Server) proxyApplyTrafficSplit(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	body, e := ioutil.ReadAll(r.Body)
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	weights, e := proxy.ParseTrafficSplitWeights(string(body))
+	if e != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, e)
+		return
+	}
+
+	buf, e := yaml.Marshal(weights)
+	if e != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", weights, e))
+	}
+
+	if e = s.cluster.Put(s.cluster.Layout().ProxyTrafficSplitKey(pipeline, filter), string(buf)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func (s *Server) proxyDeleteTrafficSplit(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+	filter := chi.URLParam(r, "filter")
+	if !s.isFilterExist(pipeline, filter, proxy.Kind) {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if e := s.cluster.Delete(s.cluster.Layout().ProxyTrafficSplitKey(pipeline, filter)); e != nil {
+		ClusterPanic(e)
+	}
+}
+
+func appendProxyAPI(s *Server, group *Group) {
+	group.Entries = append(group.Entries, []*Entry{
+		{
+			Path:    "/proxy/weights/{pipeline}/{filter}",
+			Method:  http.MethodGet,
+			Handler: s.proxyGetWeights,
+		},
+		{
+			Path:    "/proxy/weights/{pipeline}/{filter}",
+			Method:  http.MethodPut,
+			Handler: s.proxyApplyWeights,
+		},
+		{
+			Path:    "/proxy/weights/{pipeline}/{filter}",
+			Method:  http.MethodDelete,
+			Handler: s.proxyDeleteWeights,
+		},
+		{
+			Path:    "/proxy/trafficsplit/{pipeline}/{filter}",
+			Method:  http.MethodGet,
+			Handler: s.proxyGetTrafficSplit,
+		},
+		{
+			Path:    "/proxy/trafficsplit/{pipeline}/{filter}",
+			Method:  http.MethodPut,
+			Handler: s.proxyApplyTrafficSplit,
+		},
+		{
+			Path:    "/proxy/trafficsplit/{pipeline}/{filter}",
+			Method:  http.MethodDelete,
+			Handler: s.proxyDeleteTrafficSplit,
+		},
+	}...)
+}
+
+func init() {
+	appendAddonAPIs = append(appendAddonAPIs, appendProxyAPI)
+}
@@ -44,7 +44,10 @@ type (
 
 	// Group is the API group
 	Group struct {
-		Group   string
+		Group string
+		// Prefix is the prefix of the paths of the entries, default is
+		// APIPrefix.
+		Prefix  string `yaml:",omitempty"`
 		Entries []*Entry
 	}
 
@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamljsontool "github.com/ghodss/yaml"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// ResourceVersionKey is the key of header for the resource version in
+// API v2, it is the same as the ETag.
+const ResourceVersionKey = "X-Resource-Version"
+
+type (
+	// ObjectV2 is the object with its resource version in API v2, the
+	// version changes whenever the object is updated.
+	ObjectV2 struct {
+		ResourceVersion int64                  `yaml:"resourceVersion"`
+		Spec            map[string]interface{} `yaml:"spec"`
+	}
+
+	// ErrV2 is the structured error of API v2.
+	ErrV2 struct {
+		Code int `yaml:"code"`
+		// Reason is the status text of the code without spaces, e.g.
+		// NotFound, which is for the programs to check.
+		Reason  string `yaml:"reason"`
+		Message string `yaml:"message"`
+	}
+
+	objectsV2 []*ObjectV2
+)
+
+func (o objectsV2) Less(i, j int) bool {
+	return o[i].Spec["name"].(string) < o[j].Spec["name"].(string)
+}
+func (o objectsV2) Len() int      { return len(o) }
+func (o objectsV2) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// acceptsJSON reports whether the client prefers JSON to YAML, YAML is
+// the default as API v1.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/vnd.yaml", "application/yaml", "application/x-yaml", "text/yaml":
+			return false
+		}
+	}
+	return false
+}
+
+// writeV2 writes v in YAML or JSON by the header Accept.
+func writeV2(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", v, err))
+	}
+
+	contentType := "text/vnd.yaml"
+	if acceptsJSON(r) {
+		buff, err = yamljsontool.YAMLToJSON(buff)
+		if err != nil {
+			panic(fmt.Errorf("convert yaml %s to json failed: %v", buff, err))
+		}
+		contentType = "application/json"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(buff)
+}
+
+// writeObjectV2 writes the object with its resource version in the
+// headers ETag and X-Resource-Version.
+func writeObjectV2(w http.ResponseWriter, r *http.Request, code int, obj *ObjectV2) {
+	version := strconv.FormatInt(obj.ResourceVersion, 10)
+	w.Header().Set("ETag", strconv.Quote(version))
+	w.Header().Set(ResourceVersionKey, version)
+	writeV2(w, r, code, obj)
+}
+
+// HandleAPIErrorV2 handles api error of API v2.
+func HandleAPIErrorV2(w http.ResponseWriter, r *http.Request, code int, err error) {
+	writeV2(w, r, code, ErrV2{
+		Code:    code,
+		Reason:  strings.ReplaceAll(http.StatusText(code), " ", ""),
+		Message: err.Error(),
+	})
+}
+
+// checkResourceVersion checks the resource version against the header
+// If-Match, which is optional.
+func checkResourceVersion(r *http.Request, version int64) error {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return nil
+	}
+
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		if tag == strconv.FormatInt(version, 10) {
+			return nil
+		}
+	}
+	return fmt.Errorf("resource version is %d, not %s", version, match)
+}
+
+// readBodyV2 reads the body in YAML or JSON, which is a subset of YAML.
+func readBodyV2(r *http.Request) (map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+
+	m := map[string]interface{}{}
+	if err = yaml.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	return m, nil
+}
+
+// readObjectSpecV2 reads the spec of the object, the kind and the name
+// in the url are filled if they are absent in the body.
+func (s *Server) readObjectSpecV2(r *http.Request, kind, name string) (*supervisor.Spec, error) {
+	m, err := readBodyV2(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range []struct{ key, value string }{{"kind", kind}, {"name", name}} {
+		if field.value == "" {
+			continue
+		}
+		v, ok := m[field.key]
+		if !ok {
+			m[field.key] = field.value
+		} else if v != field.value {
+			return nil, fmt.Errorf("%s must be %s, got %v", field.key, field.value, v)
+		}
+	}
+
+	buff, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %#v to yaml failed: %v", m, err)
+	}
+	return s.super.NewSpec(string(buff))
+}
+
+func newObjectV2(spec *supervisor.Spec, version int64) *ObjectV2 {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(spec.YAMLConfig()), &m); err != nil {
+		panic(fmt.Errorf("unmarshal %s to yaml failed: %v", spec.YAMLConfig(), err))
+	}
+	return &ObjectV2{ResourceVersion: version, Spec: m}
+}
+
+// _getObjectV2 returns the object and its resource version, which is the
+// revision of its last modification in the cluster.
+func (s *Server) _getObjectV2(name string) (*supervisor.Spec, int64) {
+	kv, err := s.cluster.GetRaw(s.cluster.Layout().ConfigObjectKey(name))
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	if kv == nil {
+		return nil, 0
+	}
+
+	spec, err := s.super.NewSpec(string(kv.Value))
+	if err != nil {
+		panic(fmt.Errorf("bad spec(err: %v) from yaml: %s", err, kv.Value))
+	}
+
+	return spec, kv.ModRevision
+}
+
+// _listObjectsV2 lists the objects of the kind, or all objects if the
+// kind is empty.
+func (s *Server) _listObjectsV2(kind string) []*ObjectV2 {
+	kvs, err := s.cluster.GetRawPrefix(s.cluster.Layout().ConfigObjectPrefix())
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	objects := make(objectsV2, 0, len(kvs))
+	for _, kv := range kvs {
+		spec, err := s.super.NewSpec(string(kv.Value))
+		if err != nil {
+			panic(fmt.Errorf("bad spec(err: %v) from yaml: %s", err, kv.Value))
+		}
+		if kind != "" && spec.Kind() != kind {
+			continue
+		}
+		objects = append(objects, newObjectV2(spec, kv.ModRevision))
+	}
+
+	// NOTE: Keep it consistent.
+	sort.Sort(objects)
+	return objects
+}
+
+// _putAndDeleteV2 puts and deletes the key-values in one transaction of
+// the cluster if the keys are not modified since the versions, the version
+// of absent keys is zero. It returns false if any of them is modified.
+func (s *Server) _putAndDeleteV2(kvs map[string]*string, versions map[string]int64) bool {
+	modified := false
+	err := s.cluster.STM(func(stm concurrency.STM) error {
+		modified = false
+		for k := range kvs {
+			if stm.Rev(k) != versions[k] {
+				modified = true
+				return nil
+			}
+		}
+
+		for k, v := range kvs {
+			if v == nil {
+				stm.Del(k)
+			} else {
+				stm.Put(k, *v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ClusterPanic(err)
+	}
+	return !modified
+}
+
+// _putObjectV2 puts the object if it is not modified since the version,
+// and returns it with the new version, it returns nil if it is modified.
+func (s *Server) _putObjectV2(spec *supervisor.Spec, version int64) *ObjectV2 {
+	name := spec.Name()
+	key := s.cluster.Layout().ConfigObjectKey(name)
+	yamlConfig := spec.YAMLConfig()
+	if !s._putAndDeleteV2(map[string]*string{key: &yamlConfig}, map[string]int64{key: version}) {
+		return nil
+	}
+
+	spec, version = s._getObjectV2(name)
+	if spec == nil {
+		ClusterPanic(fmt.Errorf("object %s disappeared after put", name))
+	}
+	return newObjectV2(spec, version)
+}
+
+// _deleteObjectV2 deletes the object if it is not modified since the
+// version, it returns false if it is modified.
+func (s *Server) _deleteObjectV2(name string, version int64) bool {
+	key := s.cluster.Layout().ConfigObjectKey(name)
+	return s._putAndDeleteV2(map[string]*string{key: nil}, map[string]int64{key: version})
+}
+
+// handleModifiedV2 handles the object modified by others after it is read,
+// which fails the header If-Match if there is one.
+func handleModifiedV2(w http.ResponseWriter, r *http.Request, name string) {
+	code := http.StatusConflict
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" {
+		code = http.StatusPreconditionFailed
+	}
+	HandleAPIErrorV2(w, r, code, fmt.Errorf("%s is modified by others, please retry", name))
+}
+
+func (s *Server) optionAPIEntriesV2() []*Entry {
+	return []*Entry{
+		{
+			Path:    "/options",
+			Method:  "GET",
+			Handler: s.getOptionsV2,
+		},
+	}
+}
+
+// getOptionsV2 returns the options of the member, which are read-only as
+// they are loaded at startup.
+func (s *Server) getOptionsV2(w http.ResponseWriter, r *http.Request) {
+	writeV2(w, r, http.StatusOK, s.opt)
+}
@@ -34,6 +34,17 @@ const (
 	configVersion            = "/config/version"
 	wasmCodeEvent            = "/wasm/code"
 	wasmDataPrefixFormat     = "/wasm/data/%s/%s/"
+	proxyWeightsFormat       = "/proxy/weights/%s/%s"      // +pipelineName +filterName
+	proxyTrafficSplitFormat  = "/proxy/trafficsplit/%s/%s" // +pipelineName +filterName
+	rateLimiterFormat        = "/ratelimiter/%s/%s/%d"     // +pipelineName +filterName +urlIndex
+	apiKeyPrefix             = "/apikeys/"
+	apiKeyFormat             = "/apikeys/%s" // +keyID
+	autoCertAccountKey       = "/autocert/account"
+	autoCertCertPrefix       = "/autocert/certs/"
+	autoCertCertFormat       = "/autocert/certs/%s"      // +domain
+	autoCertChallengeFormat  = "/autocert/challenges/%s" // +token
+	ipFilterListPrefix       = "/ipfilter/lists/"
+	ipFilterListFormat       = "/ipfilter/lists/%s" // +listName
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(reader or writer ) will be rejected if it is configured a different cluster name
@@ -128,3 +139,60 @@ func (l *Layout) WasmCodeEvent() string {
 func (l *Layout) WasmDataPrefix(pipeline string, name string) string {
 	return fmt.Sprintf(wasmDataPrefixFormat, pipeline, name)
 }
+
+// ProxyWeightsKey returns the key of the server weights of a proxy filter
+func (l *Layout) ProxyWeightsKey(pipeline string, name string) string {
+	return fmt.Sprintf(proxyWeightsFormat, pipeline, name)
+}
+
+// ProxyTrafficSplitKey returns the key of the traffic split weights of a
+// proxy filter
+func (l *Layout) ProxyTrafficSplitKey(pipeline string, name string) string {
+	return fmt.Sprintf(proxyTrafficSplitFormat, pipeline, name)
+}
+
+// RateLimiterKey returns the key of the cluster level token counter
+// of a URL rule of a rate limiter filter
+func (l *Layout) RateLimiterKey(pipeline string, name string, index int) string {
+	return fmt.Sprintf(rateLimiterFormat, pipeline, name, index)
+}
+
+// APIKeyPrefix returns the prefix of API keys
+func (l *Layout) APIKeyPrefix() string {
+	return apiKeyPrefix
+}
+
+// APIKeyKey returns the key of an API key
+func (l *Layout) APIKeyKey(id string) string {
+	return fmt.Sprintf(apiKeyFormat, id)
+}
+
+// AutoCertAccountKey returns the key of the ACME account
+func (l *Layout) AutoCertAccountKey() string {
+	return autoCertAccountKey
+}
+
+// AutoCertCertPrefix returns the prefix of certificates managed by ACME
+func (l *Layout) AutoCertCertPrefix() string {
+	return autoCertCertPrefix
+}
+
+// AutoCertCertKey returns the key of a certificate managed by ACME
+func (l *Layout) AutoCertCertKey(domain string) string {
+	return fmt.Sprintf(autoCertCertFormat, domain)
+}
+
+// AutoCertChallengeKey returns the key of an ACME HTTP-01 challenge
+func (l *Layout) AutoCertChallengeKey(token string) string {
+	return fmt.Sprintf(autoCertChallengeFormat, token)
+}
+
+// IPFilterListPrefix returns the prefix of IP lists of IPFilter
+func (l *Layout) IPFilterListPrefix() string {
+	return ipFilterListPrefix
+}
+
+// IPFilterListKey returns the key of an IP list of IPFilter
+func (l *Layout) IPFilterListKey(name string) string {
+	return fmt.Sprintf(ipFilterListFormat, name)
+}
@@ -29,32 +29,43 @@ import (
 
 // MockedHTTPContext is the mocked HTTP context
 type MockedHTTPContext struct {
-	lock                     sync.Mutex
-	finishFuncs              []func()
-	MockedLock               func()
-	MockedUnlock             func()
-	MockedSpan               func() tracing.Span
-	MockedRequest            MockedHTTPRequest
-	MockedResponse           MockedHTTPResponse
-	MockedDeadline           func() (time.Time, bool)
-	MockedDone               func() <-chan struct{}
-	MockedErr                func() error
-	MockedValue              func(key interface{}) interface{}
-	MockedCancel             func(err error)
-	MockedCancelled          func() bool
-	MockedClientDisconnected func() bool
-	MockedDuration           func() time.Duration
-	MockedOnFinish           func(func())
-	MockedAddTag             func(tag string)
-	MockedStatMetric         func() *httpstat.Metric
-	MockedLog                func() string
-	MockedFinish             func()
-	MockedTemplate           func() texttemplate.TemplateEngine
-	MockedSetTemplate        func(ht *context.HTTPTemplate)
-	MockedSaveReqToTemplate  func(filterName string) error
-	MockedSaveRspToTemplate  func(filterName string) error
-	MockedCallNextHandler    func(lastResult string) string
-	MockedSetHandlerCaller   func(caller context.HandlerCaller)
+	lock                      sync.Mutex
+	finishFuncs               []func()
+	upstreamTimeout           time.Duration
+	upstreamDeadlineHeader    string
+	upstreamDeadline          time.Time
+	upstreamGuards            []context.UpstreamGuard
+	MockedLock                func()
+	MockedUnlock              func()
+	MockedSpan                func() tracing.Span
+	MockedRequest             MockedHTTPRequest
+	MockedResponse            MockedHTTPResponse
+	MockedDeadline            func() (time.Time, bool)
+	MockedDone                func() <-chan struct{}
+	MockedErr                 func() error
+	MockedValue               func(key interface{}) interface{}
+	MockedCancel              func(err error)
+	MockedCancelled           func() bool
+	MockedClientDisconnected  func() bool
+	MockedUpstreamTimeout     func() time.Duration
+	MockedSetUpstreamTimeout  func(d time.Duration)
+	MockedUpstreamDeadline    func() (string, time.Time)
+	MockedSetUpstreamDeadline func(header string, deadline time.Time)
+	MockedUpstreamGuards      func() []context.UpstreamGuard
+	MockedSetUpstreamGuards   func(guards []context.UpstreamGuard)
+	MockedDuration            func() time.Duration
+	MockedOnFinish            func(func())
+	MockedAddTag              func(tag string)
+	MockedAddLogMasker        func(fn context.LogMaskFunc)
+	MockedStatMetric          func() *httpstat.Metric
+	MockedLog                 func() string
+	MockedFinish              func()
+	MockedTemplate            func() texttemplate.TemplateEngine
+	MockedSetTemplate         func(ht *context.HTTPTemplate)
+	MockedSaveReqToTemplate   func(filterName string) error
+	MockedSaveRspToTemplate   func(filterName string) error
+	MockedCallNextHandler     func(lastResult string) string
+	MockedSetHandlerCaller    func(caller context.HandlerCaller)
 }
 
 // Lock mocks the Lock function of HTTPContext
@@ -144,6 +155,57 @@ func (c *MockedHTTPContext) ClientDisconnected() bool {
 	return false
 }
 
+// UpstreamTimeout mocks the UpstreamTimeout function of HTTPContext
+func (c *MockedHTTPContext) UpstreamTimeout() time.Duration {
+	if c.MockedUpstreamTimeout != nil {
+		return c.MockedUpstreamTimeout()
+	}
+	return c.upstreamTimeout
+}
+
+// SetUpstreamTimeout mocks the SetUpstreamTimeout function of HTTPContext
+func (c *MockedHTTPContext) SetUpstreamTimeout(d time.Duration) {
+	if c.MockedSetUpstreamTimeout != nil {
+		c.MockedSetUpstreamTimeout(d)
+		return
+	}
+	c.upstreamTimeout = d
+}
+
+// UpstreamDeadline mocks the UpstreamDeadline function of HTTPContext
+func (c *MockedHTTPContext) UpstreamDeadline() (string, time.Time) {
+	if c.MockedUpstreamDeadline != nil {
+		return c.MockedUpstreamDeadline()
+	}
+	return c.upstreamDeadlineHeader, c.upstreamDeadline
+}
+
+// SetUpstreamDeadline mocks the SetUpstreamDeadline function of HTTPContext
+func (c *MockedHTTPContext) SetUpstreamDeadline(header string, deadline time.Time) {
+	if c.MockedSetUpstreamDeadline != nil {
+		c.MockedSetUpstreamDeadline(header, deadline)
+		return
+	}
+	c.upstreamDeadlineHeader, c.upstreamDeadline = header, deadline
+}
+
+// UpstreamGuards mocks the UpstreamGuards function of HTTPContext
+func (c *MockedHTTPContext) UpstreamGuards() []context.UpstreamGuard {
+	if c.MockedUpstreamGuards != nil {
+		return c.MockedUpstreamGuards()
+	}
+	return c.upstreamGuards
+}
+
+// SetUpstreamGuards mocks the SetUpstreamGuards function of HTTPContext
+func (c *MockedHTTPContext) SetUpstreamGuards(guards []context.UpstreamGuard) {
+	if c.MockedSetUpstreamGuards != nil {
+		c.MockedSetUpstreamGuards(guards)
+		return
+	}
+	c.upstreamGuards = guards
+}
+
 // Duration mocks the Duration function of HTTPContext
 func (c *MockedHTTPContext) Duration() time.Duration {
 	if c.MockedDuration != nil {
@@ -169,6 +231,13 @@ func (c *MockedHTTPContext) AddTag(tag string) {
 	}
 }
 
+// AddLogMasker mocks the AddLogMasker function of HTTPContext
+func (c *MockedHTTPContext) AddLogMasker(fn context.LogMaskFunc) {
+	if c.MockedAddLogMasker != nil {
+		c.MockedAddLogMasker(fn)
+	}
+}
+
 // StatMetric mocks the StatMetric function of HTTPContext
 func (c *MockedHTTPContext) StatMetric() *httpstat.Metric {
 	if c.MockedStatMetric != nil {
@@ -42,6 +42,13 @@ type (
 	// HandlerCaller is a helper function to call the handler
 	HandlerCaller func(lastResult string) string
 
+	// UpstreamGuard guards the requests to each server of the upstream,
+	// it is called with the URL of the server selected by the Proxy
+	// before sending the request. The request is rejected if ok is
+	// false, otherwise done must be called with the status code of the
+	// response, 0 means the request was not sent.
+	UpstreamGuard func(server string) (done func(statusCode int), ok bool)
+
 	// HTTPContext is all context of an HTTP processing.
 	// It is not goroutine-safe, callers must use Lock/Unlock
 	// to protect it by themselves.
@@ -59,10 +66,35 @@ type (
 		Cancelled() bool
 		ClientDisconnected() bool
 
+		// UpstreamTimeout is the timeout of a single request to the
+		// upstream, 0 means no timeout. It is set by filters like
+		// Retryer to limit the time of one attempt without cancelling
+		// the whole context.
+		UpstreamTimeout() time.Duration
+		SetUpstreamTimeout(d time.Duration)
+
+		// UpstreamDeadline is the deadline of the requests to the
+		// upstream and the header to propagate the remaining time in
+		// milliseconds, the header is set just before forwarding. It is
+		// set by filters like TimeLimiter, an empty header means no
+		// propagation.
+		UpstreamDeadline() (header string, deadline time.Time)
+		SetUpstreamDeadline(header string, deadline time.Time)
+
+		// UpstreamGuards are called by the Proxy in order with the
+		// selected server, they are set by filters like CircuitBreaker
+		// to protect each server separately.
+		UpstreamGuards() []UpstreamGuard
+		SetUpstreamGuards(guards []UpstreamGuard)
+
 		Duration() time.Duration // For log, sample, etc.
 		OnFinish(func())         // For setting final client statistics, etc.
 		AddTag(tag string)       // For debug, log, etc.
 
+		// AddLogMasker adds a function to mask the sensitive data in the
+		// access log, the maskers are called in the order they are added.
+		AddLogMasker(fn LogMaskFunc)
+
 		StatMetric() *httpstat.Metric
 		Log() string
 
@@ -131,12 +163,16 @@ type (
 	// when HTTPContext is finishing.
 	FinishFunc = func()
 
+	// LogMaskFunc is the type of function to mask the access log.
+	LogMaskFunc = func(log string) string
+
 	httpContext struct {
 		mutex sync.Mutex
 
 		startTime   *time.Time
 		endTime     *time.Time
 		finishFuncs []FinishFunc
+		logMaskers  []LogMaskFunc
 		tags        []string
 		caller      HandlerCaller
 
@@ -150,6 +186,11 @@ type (
 		stdctx         stdcontext.Context
 		cancelFunc     stdcontext.CancelFunc
 		err            error
+
+		upstreamTimeout        time.Duration
+		upstreamDeadlineHeader string
+		upstreamDeadline       time.Time
+		upstreamGuards         []UpstreamGuard
 	}
 )
 
@@ -200,6 +241,10 @@ func (ctx *httpContext) AddTag(tag string) {
 	ctx.tags = append(ctx.tags, tag)
 }
 
+func (ctx *httpContext) AddLogMasker(fn LogMaskFunc) {
+	ctx.logMaskers = append(ctx.logMaskers, fn)
+}
+
 func (ctx *httpContext) Request() HTTPRequest {
 	return ctx.r
 }
@@ -244,6 +289,30 @@ func (ctx *httpContext) Cancelled() bool {
 	return ctx.err != nil || ctx.stdctx.Err() != nil
 }
 
+func (ctx *httpContext) UpstreamTimeout() time.Duration {
+	return ctx.upstreamTimeout
+}
+
+func (ctx *httpContext) SetUpstreamTimeout(d time.Duration) {
+	ctx.upstreamTimeout = d
+}
+
+func (ctx *httpContext) UpstreamDeadline() (string, time.Time) {
+	return ctx.upstreamDeadlineHeader, ctx.upstreamDeadline
+}
+
+func (ctx *httpContext) SetUpstreamDeadline(header string, deadline time.Time) {
+	ctx.upstreamDeadlineHeader, ctx.upstreamDeadline = header, deadline
+}
+
+func (ctx *httpContext) UpstreamGuards() []UpstreamGuard {
+	return ctx.upstreamGuards
+}
+
+func (ctx *httpContext) SetUpstreamGuards(guards []UpstreamGuard) {
+	ctx.upstreamGuards = guards
+}
+
 func (ctx *httpContext) Duration() time.Duration {
 	if ctx.endTime != nil {
 		return ctx.endTime.Sub(*ctx.startTime)
@@ -307,7 +376,7 @@ func (ctx *httpContext) Log() string {
 	// [$remoteAddr $realIP $method $requestURL $proto $statusCode]
 	// [$contextDuration $readBytes $writeBytes]
 	// [$tags]
-	return fmt.Sprintf("[%s] "+
+	log := fmt.Sprintf("[%s] "+
 		"[%s %s %s %s %s %d] "+
 		"[%v rx:%dB tx:%dB] "+
 		"[%s]",
@@ -315,6 +384,11 @@ func (ctx *httpContext) Log() string {
 		stdr.RemoteAddr, ctx.r.RealIP(), stdr.Method, stdr.RequestURI, stdr.Proto, ctx.w.code,
 		ctx.Duration(), ctx.r.Size(), ctx.w.Size(),
 		strings.Join(ctx.tags, " | "))
+
+	for _, fn := range ctx.logMaskers {
+		log = fn(log)
+	}
+	return log
 }
 
 // Template returns the template engine
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/httpheader"
@@ -103,6 +104,15 @@ func (w *httpResponse) flushBody() {
 		}
 	}()
 
+	// NOTE: Events must be sent to the client as soon as possible,
+	// so the body of event streams is never buffered.
+	if w.isEventStream() {
+		if flusher, ok := w.std.(http.Flusher); ok {
+			w.streamBody(flusher)
+			return
+		}
+	}
+
 	copyToClient := func(src io.Reader) (succeed bool) {
 		written, err := io.Copy(w.std, src)
 		if err != nil {
@@ -155,6 +165,47 @@ func (w *httpResponse) flushBody() {
 	}
 }
 
+func (w *httpResponse) isEventStream() bool {
+	return strings.HasPrefix(w.header.Get(httpheader.KeyContentType), "text/event-stream")
+}
+
+// streamBody copies the body to the client, and flushes the data after
+// every read, the body flushing handlers are called for every read too.
+func (w *httpResponse) streamBody(flusher http.Flusher) {
+	// NOTE: Send the header to the client before the first event.
+	flusher.Flush()
+
+	buff := make([]byte, bodyFlushBuffSize)
+	for {
+		n, err := w.body.Read(buff)
+		body := buff[:n]
+
+		complete := err == io.EOF
+		if n > 0 || complete {
+			for _, fn := range w.bodyFlushFuncs {
+				body = fn(body, complete)
+			}
+		}
+
+		if len(body) > 0 {
+			written, werr := w.std.Write(body)
+			w.bodyWritten += uint64(written)
+			if werr != nil {
+				logger.Warnf("write body failed: %v", werr)
+				return
+			}
+			flusher.Flush()
+		}
+
+		if err != nil {
+			if !complete {
+				logger.Warnf("read body failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
 func (w *httpResponse) FlushedBodyBytes() uint64 {
 	return w.bodyWritten
 }
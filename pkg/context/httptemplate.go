@@ -143,7 +143,11 @@ func NewHTTPTemplate(filterBuffs []FilterBuff) (*HTTPTemplate, error) {
 				break
 			} else {
 				dependFilterName := tags[filterNameTagIndex]
-				dependFilters = append(dependFilters, dependFilterName)
+				// NOTE: The request of a filter is saved before it's
+				// executed, so it could rely on its own request.
+				if dependFilterName != filterBuff.Name || tags[filterReqRspTagIndex] != "req" {
+					dependFilters = append(dependFilters, dependFilterName)
+				}
 				funcTag := tags[filterReqRspTagIndex] + texttemplate.DefaultSeparator +
 					tags[filterValueTagIndex]
 
@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package abtest
+
+import (
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/hashtool"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ABTest.
+	Kind = "ABTest"
+
+	sourceCookie = "cookie"
+	sourceHeader = "header"
+	sourceRealIP = "realIP"
+
+	defaultGroupHeader = "X-AB-Group"
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&ABTest{})
+}
+
+type (
+	// ABTest buckets the users into the groups of an experiment by the
+	// hash of their keys, so a user is always in the same group.
+	ABTest struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		groups      []*group
+		totalWeight uint32
+	}
+
+	// Spec describes the ABTest.
+	Spec struct {
+		// Experiment is the name of the experiment, it salts the hash, so
+		// the users are bucketed independently in different experiments.
+		Experiment string `yaml:"experiment" jsonschema:"required,minLength=1"`
+		// GroupHeader is the request header set to the group name for the
+		// backends, default is X-AB-Group.
+		GroupHeader string `yaml:"groupHeader" jsonschema:"omitempty"`
+		// Keys are tried in order, the first present one identifies the
+		// user, the real IP is used if none of them is present.
+		Keys   []*KeySpec   `yaml:"keys" jsonschema:"omitempty"`
+		Groups []*GroupSpec `yaml:"groups" jsonschema:"required,minItems=1"`
+	}
+
+	// KeySpec describes where the key of a user is from.
+	KeySpec struct {
+		Source string `yaml:"source,omitempty" jsonschema:"required,enum=cookie,enum=header,enum=realIP"`
+		// Name is the name of the cookie or the header.
+		Name string `yaml:"name" jsonschema:"omitempty"`
+	}
+
+	// GroupSpec describes a group of the experiment.
+	GroupSpec struct {
+		Name string `yaml:"name" jsonschema:"required,minLength=1"`
+		// Weight is relative to the weights of the other groups.
+		Weight int `yaml:"weight" jsonschema:"required,minimum=0"`
+	}
+
+	// Status is the status of ABTest.
+	Status struct {
+		Experiment string `yaml:"experiment"`
+		// Groups are the statistics of the requests of the groups, the
+		// latencies are measured until the responses are sent.
+		Groups map[string]*httpstat.Status `yaml:"groups"`
+	}
+
+	group struct {
+		name string
+		// upper is the exclusive upper bound of the buckets of the group.
+		upper    uint32
+		httpStat *httpstat.HTTPStat
+	}
+)
+
+// Validate validates the KeySpec.
+func (spec KeySpec) Validate() error {
+	if spec.Source != sourceRealIP && spec.Name == "" {
+		return fmt.Errorf("name of %s key is required", spec.Source)
+	}
+	return nil
+}
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	names := map[string]bool{}
+	total := 0
+	for _, g := range spec.Groups {
+		if names[g.Name] {
+			return fmt.Errorf("group %s is duplicated", g.Name)
+		}
+		names[g.Name] = true
+		total += g.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("sum of weights of groups must be greater than 0")
+	}
+	return nil
+}
+
+// Kind returns the kind of ABTest.
+func (ab *ABTest) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ABTest.
+func (ab *ABTest) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ABTest.
+func (ab *ABTest) Description() string {
+	return "ABTest buckets users into experiment groups deterministically."
+}
+
+// Results returns the results of ABTest.
+func (ab *ABTest) Results() []string {
+	return results
+}
+
+// Init initializes ABTest.
+func (ab *ABTest) Init(filterSpec *httppipeline.FilterSpec) {
+	ab.filterSpec, ab.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ab.reload(nil)
+}
+
+// Inherit inherits previous generation of ABTest.
+func (ab *ABTest) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*ABTest)
+	previousGeneration.Close()
+
+	ab.filterSpec, ab.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ab.reload(prev)
+}
+
+func (ab *ABTest) reload(prev *ABTest) {
+	if ab.spec.GroupHeader == "" {
+		ab.spec.GroupHeader = defaultGroupHeader
+	}
+
+	// NOTE: Keep the statistics of the groups of the same experiment, so
+	// the analysis isn't interrupted by adjusting the weights.
+	prevStats := map[string]*httpstat.HTTPStat{}
+	if prev != nil && prev.spec.Experiment == ab.spec.Experiment {
+		for _, g := range prev.groups {
+			prevStats[g.name] = g.httpStat
+		}
+	}
+
+	ab.groups = nil
+	ab.totalWeight = 0
+	for _, gs := range ab.spec.Groups {
+		ab.totalWeight += uint32(gs.Weight)
+		g := &group{name: gs.Name, upper: ab.totalWeight, httpStat: prevStats[gs.Name]}
+		if g.httpStat == nil {
+			g.httpStat = httpstat.New()
+		}
+		ab.groups = append(ab.groups, g)
+	}
+}
+
+// Handle buckets the request into a group of the experiment.
+func (ab *ABTest) Handle(ctx context.HTTPContext) string {
+	result := ab.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ab *ABTest) handle(ctx context.HTTPContext) string {
+	g := ab.bucket(ab.userKey(ctx))
+
+	// NOTE: The header from the client is overwritten, or users could
+	// choose their groups.
+	ctx.Request().Header().Set(ab.spec.GroupHeader, g.name)
+	ctx.AddTag(stringtool.Cat("abtest: ", g.name))
+	ctx.OnFinish(func() {
+		g.httpStat.Stat(ctx.StatMetric())
+	})
+
+	return ""
+}
+
+func (ab *ABTest) userKey(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	for _, k := range ab.spec.Keys {
+		switch k.Source {
+		case sourceCookie:
+			if c, err := r.Cookie(k.Name); err == nil && c.Value != "" {
+				return c.Value
+			}
+		case sourceHeader:
+			if v := r.Header().Get(k.Name); v != "" {
+				return v
+			}
+		case sourceRealIP:
+			return r.RealIP()
+		}
+	}
+	return r.RealIP()
+}
+
+// bucket returns the group of the key, the groups of the same key are
+// the same as long as the weights are unchanged.
+func (ab *ABTest) bucket(key string) *group {
+	b := hashtool.Hash32(stringtool.Cat(ab.spec.Experiment, ":", key)) % ab.totalWeight
+	for _, g := range ab.groups {
+		if b < g.upper {
+			return g
+		}
+	}
+	return ab.groups[len(ab.groups)-1]
+}
+
+// Status returns status.
+func (ab *ABTest) Status() interface{} {
+	s := &Status{
+		Experiment: ab.spec.Experiment,
+		Groups:     map[string]*httpstat.Status{},
+	}
+	for _, g := range ab.groups {
+		s.Groups[g.name] = g.httpStat.Status()
+	}
+	return s
+}
+
+// Close closes ABTest.
+func (ab *ABTest) Close() {
+}
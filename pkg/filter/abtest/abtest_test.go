@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package abtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const specYAML = `
+kind: ABTest
+name: abtest
+experiment: checkout
+keys:
+- source: cookie
+  name: uid
+- source: header
+  name: X-User-Id
+groups:
+- name: control
+  weight: 50
+- name: variant
+  weight: 50
+`
+
+func doRequest(ab *ABTest, setup func(r *http.Request), status int) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	setup(req)
+
+	httppipelinetest.Handle(ab, req, func(ctx context.HTTPContext, lastResult string) string {
+		ctx.Response().SetStatusCode(status)
+		return lastResult
+	})
+	return req
+}
+
+func TestABTest(t *testing.T) {
+	ab := httppipelinetest.InitFilter(t, &ABTest{}, specYAML).(*ABTest)
+	defer ab.Close()
+
+	groups := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		withCookie := func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "uid", Value: uid})
+			r.Header.Set("X-AB-Group", "forged")
+		}
+		g1 := doRequest(ab, withCookie, http.StatusOK).Header.Get("X-AB-Group")
+		g2 := doRequest(ab, withCookie, http.StatusInternalServerError).Header.Get("X-AB-Group")
+		if g1 != g2 {
+			t.Fatalf("user %s is bucketed into %s and %s", uid, g1, g2)
+		}
+		groups[g1]++
+	}
+	if len(groups) != 2 || groups["control"] < 400 || groups["variant"] < 400 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	status := ab.Status().(*Status)
+	for name, count := range groups {
+		s := status.Groups[name]
+		if s.Count != uint64(count*2) || s.ErrCount != uint64(count) {
+			t.Errorf("unexpected status of %s: count %d, errCount %d", name, s.Count, s.ErrCount)
+		}
+	}
+
+	// The header key is used without the cookie, and the key is the same
+	// as the cookie.
+	g1 := doRequest(ab, func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "uid", Value: "user-1"})
+	}, http.StatusOK).Header.Get("X-AB-Group")
+	g2 := doRequest(ab, func(r *http.Request) {
+		r.Header.Set("X-User-Id", "user-1")
+	}, http.StatusOK).Header.Get("X-AB-Group")
+	if g1 != g2 {
+		t.Errorf("expected the same group, got %s and %s", g1, g2)
+	}
+}
+
+func TestABTestInherit(t *testing.T) {
+	ab := httppipelinetest.InitFilter(t, &ABTest{}, specYAML).(*ABTest)
+	doRequest(ab, func(r *http.Request) {}, http.StatusOK)
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(specYAML+`
+- name: empty
+  weight: 0
+`), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newAB := &ABTest{}
+	newAB.Inherit(spec, ab)
+	defer newAB.Close()
+
+	total := uint64(0)
+	for _, s := range newAB.Status().(*Status).Groups {
+		total += s.Count
+	}
+	if total != 1 {
+		t.Errorf("expected statistics kept, got %d requests", total)
+	}
+
+	// A group of weight 0 gets no users.
+	for i := 0; i < 100; i++ {
+		if g := newAB.bucket(fmt.Sprintf("user-%d", i)); g.name == "empty" {
+			t.Errorf("user-%d is bucketed into empty group", i)
+		}
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	for _, spec := range []string{
+		`
+kind: ABTest
+name: abtest
+experiment: checkout
+groups:
+- name: a
+  weight: 0
+`,
+		`
+kind: ABTest
+name: abtest
+experiment: checkout
+groups:
+- name: a
+  weight: 1
+- name: a
+  weight: 1
+`,
+		`
+kind: ABTest
+name: abtest
+experiment: checkout
+keys:
+- source: header
+groups:
+- name: a
+  weight: 1
+`,
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("expected error for %s", spec)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adaptivelimiter
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/concurrencylimiter"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of AdaptiveLimiter.
+	Kind                     = "AdaptiveLimiter"
+	resultConcurrencyLimited = "concurrencyLimited"
+
+	// AlgorithmGradient is the gradient algorithm.
+	AlgorithmGradient = "gradient"
+	// AlgorithmVegas is the vegas algorithm.
+	AlgorithmVegas = "vegas"
+
+	// serverIdleTimeout is how long the limiter of a server is kept
+	// without requests, the limiters of the servers no longer selected
+	// by the Proxy are dropped after it.
+	serverIdleTimeout = 10 * time.Minute
+)
+
+var results = []string{resultConcurrencyLimited}
+
+func init() {
+	httppipeline.Register(&AdaptiveLimiter{})
+}
+
+type (
+	// Policy defines the policy of an adaptive limiter
+	Policy struct {
+		Name                 string  `yaml:"name" jsonschema:"required"`
+		Algorithm            string  `yaml:"algorithm,omitempty" jsonschema:"omitempty,enum=gradient,enum=vegas"`
+		InitialLimit         int     `yaml:"initialLimit,omitempty" jsonschema:"omitempty,minimum=1"`
+		MinLimit             int     `yaml:"minLimit,omitempty" jsonschema:"omitempty,minimum=1"`
+		MaxLimit             int     `yaml:"maxLimit,omitempty" jsonschema:"omitempty,minimum=1"`
+		Smoothing            float64 `yaml:"smoothing,omitempty" jsonschema:"omitempty,minimum=0,maximum=1"`
+		Tolerance            float64 `yaml:"tolerance,omitempty" jsonschema:"omitempty,minimum=1"`
+		CountingNetworkError bool    `yaml:"countingNetworkError" jsonschema:"omitempty"`
+		FailureStatusCodes   []int   `yaml:"failureStatusCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+	}
+
+	// URLRule defines the adaptive limiter rule for a URL pattern
+	URLRule struct {
+		urlrule.URLRule `yaml:",inline"`
+		// PerServer limits the concurrency of each server selected by
+		// the Proxy separately, the limit of a server is learned from
+		// its own latency.
+		PerServer bool `yaml:"perServer" jsonschema:"omitempty"`
+		policy    *Policy
+		limiter   *concurrencylimiter.Limiter
+
+		mutex   sync.Mutex
+		servers map[string]*serverLimiter
+	}
+
+	// serverLimiter is the limiter of a server.
+	serverLimiter struct {
+		limiter  *concurrencylimiter.Limiter
+		lastSeen time.Time
+	}
+
+	// Spec is the configuration of an adaptive limiter
+	Spec struct {
+		Policies         []*Policy  `yaml:"policies" jsonschema:"required"`
+		DefaultPolicyRef string     `yaml:"defaultPolicyRef" jsonschema:"omitempty"`
+		URLs             []*URLRule `yaml:"urls" jsonschema:"required"`
+	}
+
+	// AdaptiveLimiter defines the adaptive limiter
+	AdaptiveLimiter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Status is the status of AdaptiveLimiter.
+	Status struct {
+		URLs []*URLStatus `yaml:"urls"`
+	}
+
+	// URLStatus is the status of the limiters of a URL rule.
+	URLStatus struct {
+		ID                         string `yaml:"id"`
+		*concurrencylimiter.Status `yaml:",inline"`
+		// Servers is the status of the limiters by server URL.
+		Servers map[string]*concurrencylimiter.Status `yaml:"servers,omitempty"`
+	}
+)
+
+// Validate implements custom validation for Spec
+func (spec Spec) Validate() error {
+URLLoop:
+	for _, u := range spec.URLs {
+		name := u.PolicyRef
+		if name == "" {
+			name = spec.DefaultPolicyRef
+		}
+
+		for _, p := range spec.Policies {
+			if p.Name == name {
+				continue URLLoop
+			}
+		}
+
+		return fmt.Errorf("policy '%s' is not defined", name)
+	}
+
+	return nil
+}
+
+// Validate implements custom validation for Policy
+func (p Policy) Validate() error {
+	if p.MinLimit > 0 && p.MaxLimit > 0 && p.MinLimit > p.MaxLimit {
+		return fmt.Errorf("minLimit must not be greater than maxLimit")
+	}
+	return nil
+}
+
+func (url *URLRule) buildPolicy() *concurrencylimiter.Policy {
+	policy := concurrencylimiter.NewDefaultPolicy()
+
+	if url.policy.Algorithm == AlgorithmVegas {
+		policy.Algorithm = concurrencylimiter.Vegas
+	}
+	if url.policy.InitialLimit > 0 {
+		policy.InitialLimit = url.policy.InitialLimit
+	}
+	if url.policy.MinLimit > 0 {
+		policy.MinLimit = url.policy.MinLimit
+	}
+	if url.policy.MaxLimit > 0 {
+		policy.MaxLimit = url.policy.MaxLimit
+	}
+	if policy.MaxLimit < policy.MinLimit {
+		policy.MaxLimit = policy.MinLimit
+	}
+	if policy.InitialLimit < policy.MinLimit {
+		policy.InitialLimit = policy.MinLimit
+	}
+	if policy.InitialLimit > policy.MaxLimit {
+		policy.InitialLimit = policy.MaxLimit
+	}
+	if url.policy.Smoothing > 0 {
+		policy.Smoothing = url.policy.Smoothing
+	}
+	if url.policy.Tolerance >= 1 {
+		policy.Tolerance = url.policy.Tolerance
+	}
+
+	return policy
+}
+
+func (url *URLRule) createLimiter() {
+	url.limiter = concurrencylimiter.New(url.buildPolicy())
+	url.servers = make(map[string]*serverLimiter)
+}
+
+// serverLimiter returns the limiter of the server, and drops the limiters
+// of idle servers before creating a new one.
+func (url *URLRule) serverLimiter(server string) *concurrencylimiter.Limiter {
+	url.mutex.Lock()
+	defer url.mutex.Unlock()
+
+	now := time.Now()
+	if sl := url.servers[server]; sl != nil {
+		sl.lastSeen = now
+		return sl.limiter
+	}
+
+	for s, sl := range url.servers {
+		if now.Sub(sl.lastSeen) > serverIdleTimeout {
+			delete(url.servers, s)
+		}
+	}
+
+	l := concurrencylimiter.New(url.buildPolicy())
+	url.servers[server] = &serverLimiter{limiter: l, lastSeen: now}
+	return l
+}
+
+func (url *URLRule) isFailure(statusCode int) bool {
+	if url.policy.CountingNetworkError && context.IsNetworkError(statusCode) {
+		return true
+	}
+	for _, c := range url.policy.FailureStatusCodes {
+		if statusCode == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (url *URLRule) status() *URLStatus {
+	s := &URLStatus{
+		ID:     url.ID(),
+		Status: url.limiter.Status(),
+	}
+
+	url.mutex.Lock()
+	defer url.mutex.Unlock()
+	if len(url.servers) > 0 {
+		s.Servers = make(map[string]*concurrencylimiter.Status, len(url.servers))
+		for server, sl := range url.servers {
+			s.Servers[server] = sl.limiter.Status()
+		}
+	}
+
+	return s
+}
+
+// Kind returns the kind of AdaptiveLimiter.
+func (al *AdaptiveLimiter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AdaptiveLimiter.
+func (al *AdaptiveLimiter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of AdaptiveLimiter
+func (al *AdaptiveLimiter) Description() string {
+	return "AdaptiveLimiter limits the concurrency of http requests adaptively according to latency."
+}
+
+// Results returns the results of AdaptiveLimiter.
+func (al *AdaptiveLimiter) Results() []string {
+	return results
+}
+
+func (al *AdaptiveLimiter) bindPolicyToURL(u *URLRule) {
+	name := u.PolicyRef
+	if name == "" {
+		name = al.spec.DefaultPolicyRef
+	}
+
+	for _, p := range al.spec.Policies {
+		if p.Name == name {
+			u.policy = p
+			break
+		}
+	}
+}
+
+func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
+	if policyName == "" {
+		if spec1.DefaultPolicyRef != spec2.DefaultPolicyRef {
+			return false
+		}
+		policyName = spec1.DefaultPolicyRef
+	}
+
+	var p1, p2 *Policy
+	for _, p := range spec1.Policies {
+		if p.Name == policyName {
+			p1 = p
+			break
+		}
+	}
+
+	for _, p := range spec2.Policies {
+		if p.Name == policyName {
+			p2 = p
+			break
+		}
+	}
+
+	return reflect.DeepEqual(p1, p2)
+}
+
+func (al *AdaptiveLimiter) reload(previousGeneration *AdaptiveLimiter) {
+OuterLoop:
+	for _, url := range al.spec.URLs {
+		url.Init()
+		al.bindPolicyToURL(url)
+
+		if previousGeneration == nil {
+			url.createLimiter()
+			continue
+		}
+
+		for _, prev := range previousGeneration.spec.URLs {
+			if !url.DeepEqual(&prev.URLRule) || url.PerServer != prev.PerServer {
+				continue
+			}
+			if !isSamePolicy(al.spec, previousGeneration.spec, url.PolicyRef) {
+				continue
+			}
+
+			// NOTE: Inherit the limiters to keep the learned limits and
+			// the count of in-flight requests.
+			url.limiter = prev.limiter
+			prev.mutex.Lock()
+			url.servers = prev.servers
+			prev.mutex.Unlock()
+			continue OuterLoop
+		}
+
+		url.createLimiter()
+	}
+}
+
+// Init initializes AdaptiveLimiter.
+func (al *AdaptiveLimiter) Init(filterSpec *httppipeline.FilterSpec) {
+	al.filterSpec, al.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	al.reload(nil)
+}
+
+// Inherit inherits previous generation of AdaptiveLimiter.
+func (al *AdaptiveLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	al.filterSpec, al.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	al.reload(previousGeneration.(*AdaptiveLimiter))
+}
+
+func (al *AdaptiveLimiter) handle(ctx context.HTTPContext, u *URLRule) string {
+	if u.PerServer {
+		return al.handlePerServer(ctx, u)
+	}
+
+	limiter := u.limiter
+	if !limiter.AcquirePermission() {
+		ctx.AddTag("adaptiveLimiter: too many concurrent requests")
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		ctx.Response().Std().Header().Set("X-EG-Adaptive-Limiter", "concurrency-limited")
+		return ctx.CallNextHandler(resultConcurrencyLimited)
+	}
+
+	start := time.Now()
+	defer func() {
+		if e := recover(); e != nil {
+			limiter.Release(time.Since(start), true)
+			panic(e)
+		}
+	}()
+
+	result := ctx.CallNextHandler("")
+	limiter.Release(time.Since(start), u.isFailure(ctx.Response().StatusCode()))
+
+	return result
+}
+
+// handlePerServer leaves the limiting to the Proxy, which asks the limiter
+// of the selected server for permission before sending the request.
+func (al *AdaptiveLimiter) handlePerServer(ctx context.HTTPContext, u *URLRule) string {
+	guard := func(server string) (func(int), bool) {
+		limiter := u.serverLimiter(server)
+		if !limiter.AcquirePermission() {
+			ctx.Lock()
+			ctx.AddTag(stringtool.Cat("adaptiveLimiter: too many concurrent requests to ", server))
+			ctx.Response().Std().Header().Set("X-EG-Adaptive-Limiter", "concurrency-limited")
+			ctx.Unlock()
+			return nil, false
+		}
+
+		start := time.Now()
+		return func(statusCode int) {
+			// NOTE: A zero latency releases the permission without
+			// adjusting the limit, as the request was not sent.
+			if statusCode == 0 {
+				limiter.Release(0, false)
+				return
+			}
+			limiter.Release(time.Since(start), u.isFailure(statusCode))
+		}, true
+	}
+
+	guards := ctx.UpstreamGuards()
+	ctx.SetUpstreamGuards(append(guards[:len(guards):len(guards)], guard))
+	defer ctx.SetUpstreamGuards(guards)
+
+	return ctx.CallNextHandler("")
+}
+
+// Handle handles HTTP request
+func (al *AdaptiveLimiter) Handle(ctx context.HTTPContext) string {
+	for _, u := range al.spec.URLs {
+		if u.Match(ctx.Request()) {
+			return al.handle(ctx, u)
+		}
+	}
+	return ctx.CallNextHandler("")
+}
+
+// Status returns Status generated by Runtime.
+func (al *AdaptiveLimiter) Status() interface{} {
+	s := &Status{}
+	for _, u := range al.spec.URLs {
+		s.URLs = append(s.URLs, u.status())
+	}
+	return s
+}
+
+// Close closes AdaptiveLimiter.
+func (al *AdaptiveLimiter) Close() {
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adaptivelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const yamlSpec = `
+kind: AdaptiveLimiter
+name: adaptivelimiter
+policies:
+- name: default
+  initialLimit: 2
+  failureStatusCodes: [503]
+defaultPolicyRef: default
+urls:
+- perServer: true
+  url:
+    prefix: /
+`
+
+func TestAdaptiveLimiter(t *testing.T) {
+	al := &AdaptiveLimiter{}
+	al.Init(httppipelinetest.NewFilterSpec(t, yamlSpec))
+
+	resp := httptest.NewRecorder()
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodGet
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/"
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return resp
+	}
+
+	// The next handler mocks the Proxy, the requests are kept in flight
+	// until the pending ones are done.
+	server, code := "", http.StatusOK
+	rejected := false
+	var pending []func(int)
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		rejected = false
+		for _, guard := range ctx.UpstreamGuards() {
+			done, ok := guard(server)
+			if !ok {
+				rejected = true
+				return lastResult
+			}
+			pending = append(pending, done)
+		}
+		return lastResult
+	}
+	finish := func() {
+		for _, done := range pending {
+			done(code)
+		}
+		pending = nil
+	}
+
+	server = "http://a"
+	for i := 0; i < 3; i++ {
+		al.Handle(ctx)
+	}
+	if !rejected {
+		t.Fatal("the third concurrent request to the server should be limited")
+	}
+	if len(ctx.UpstreamGuards()) != 0 {
+		t.Error("upstream guards should be restored")
+	}
+
+	server = "http://b"
+	if al.Handle(ctx); rejected {
+		t.Error("requests to another server should not be limited")
+	}
+	finish()
+
+	status := al.Status().(*Status).URLs[0]
+	if len(status.Servers) != 2 || status.Servers["http://a"].Inflight != 0 {
+		t.Errorf("unexpected status: %+v", status.Servers)
+	}
+
+	code = http.StatusServiceUnavailable
+	for i := 0; i < 10; i++ {
+		al.Handle(ctx)
+		finish()
+	}
+	if status := al.Status().(*Status).URLs[0]; status.Servers["http://b"].Limit != 1 {
+		t.Errorf("limit should back off to the min limit on failures, got %d", status.Servers["http://b"].Limit)
+	}
+
+	newAl := &AdaptiveLimiter{}
+	newAl.Inherit(httppipelinetest.NewFilterSpec(t, yamlSpec), al)
+	if status := newAl.Status().(*Status).URLs[0]; len(status.Servers) != 2 {
+		t.Error("limiters should be inherited")
+	}
+
+	// The limiters of idle servers are dropped.
+	u := newAl.spec.URLs[0]
+	u.servers["http://a"].lastSeen = time.Now().Add(-2 * serverIdleTimeout)
+	server = "http://c"
+	newAl.Handle(ctx)
+	finish()
+	if _, ok := u.servers["http://a"]; ok {
+		t.Error("limiter of the idle server should be dropped")
+	}
+}
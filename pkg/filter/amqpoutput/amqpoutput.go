@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package amqpoutput
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/amqptool"
+)
+
+const (
+	// Kind is the kind of AMQPOutput.
+	Kind = "AMQPOutput"
+
+	resultPublishFailed = "publishFailed"
+
+	defaultConfirmTimeout = 5 * time.Second
+	// redialInterval limits the frequency to connect the broker.
+	redialInterval = time.Second
+)
+
+var results = []string{resultPublishFailed}
+
+func init() {
+	httppipeline.Register(&AMQPOutput{})
+}
+
+type (
+	// AMQPOutput is filter AMQPOutput.
+	AMQPOutput struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		confirmTimeout time.Duration
+
+		mutex      sync.Mutex
+		publisher  *publisher
+		lastDialed time.Time
+		closed     bool
+
+		published uint64
+		confirmed uint64
+		failed    uint64
+		lastError atomic.Value // string
+	}
+
+	// Spec is the spec of AMQPOutput.
+	Spec struct {
+		URL string `yaml:"url" jsonschema:"required"`
+		// Exchange is the default exchange if it is empty.
+		Exchange string `yaml:"exchange" jsonschema:"omitempty"`
+		// RoutingKey could be a template of the pipeline.
+		RoutingKey string              `yaml:"routingKey" jsonschema:"required"`
+		Queue      *amqptool.QueueSpec `yaml:"queue,omitempty" jsonschema:"omitempty"`
+
+		// ContentType is the content type of the request by default.
+		ContentType    string `yaml:"contentType" jsonschema:"omitempty"`
+		Persistent     bool   `yaml:"persistent" jsonschema:"omitempty"`
+		Confirm        bool   `yaml:"confirm" jsonschema:"omitempty"`
+		ConfirmTimeout string `yaml:"confirmTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of AMQPOutput.
+	Status struct {
+		// Published is the number of messages published to the broker.
+		Published uint64 `yaml:"published"`
+		// Confirmed is the number of messages acknowledged by the broker,
+		// it is always 0 if publisher confirms are disabled.
+		Confirmed uint64 `yaml:"confirmed"`
+		// Failed is the number of messages failed to be published or
+		// confirmed.
+		Failed    uint64 `yaml:"failed"`
+		Connected bool   `yaml:"connected"`
+		LastError string `yaml:"lastError,omitempty"`
+	}
+)
+
+// Kind returns the kind of AMQPOutput.
+func (ao *AMQPOutput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of AMQPOutput.
+func (ao *AMQPOutput) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of AMQPOutput.
+func (ao *AMQPOutput) Description() string {
+	return "AMQPOutput publishes the request body to an AMQP 0.9.1 broker."
+}
+
+// Results returns the results of AMQPOutput.
+func (ao *AMQPOutput) Results() []string {
+	return results
+}
+
+// Init initializes AMQPOutput.
+func (ao *AMQPOutput) Init(filterSpec *httppipeline.FilterSpec) {
+	ao.filterSpec, ao.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ao.reload()
+}
+
+// Inherit inherits previous generation of AMQPOutput.
+func (ao *AMQPOutput) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ao.Init(filterSpec)
+}
+
+func (ao *AMQPOutput) reload() {
+	ao.lastError.Store("")
+
+	ao.confirmTimeout = defaultConfirmTimeout
+	if ao.spec.ConfirmTimeout != "" {
+		// NOTE: The duration has been checked in validation.
+		ao.confirmTimeout, _ = time.ParseDuration(ao.spec.ConfirmTimeout)
+	}
+
+	// NOTE: Connect the broker in advance, failures are retried when
+	// handling requests.
+	ao.mutex.Lock()
+	ao.getPublisher()
+	ao.mutex.Unlock()
+}
+
+// getPublisher returns the publisher, it connects the broker if the
+// connection is not available, the caller must hold the mutex.
+func (ao *AMQPOutput) getPublisher() (*publisher, error) {
+	if ao.closed {
+		return nil, fmt.Errorf("filter closed")
+	}
+	if ao.publisher != nil && !ao.publisher.closed() {
+		return ao.publisher, nil
+	}
+	if time.Since(ao.lastDialed) < redialInterval {
+		return nil, fmt.Errorf("broker not available: %s", ao.lastError.Load().(string))
+	}
+
+	ao.lastDialed = time.Now()
+	p, err := newPublisher(ao.spec.URL, ao.spec.Queue, ao.spec.Confirm)
+	if err != nil {
+		logger.Errorf("%s: connect amqp broker failed: %v", ao.filterSpec.Name(), err)
+		ao.lastError.Store(err.Error())
+		return nil, err
+	}
+
+	ao.publisher = p
+	return p, nil
+}
+
+// Handle publishes the request body to the broker.
+func (ao *AMQPOutput) Handle(ctx context.HTTPContext) string {
+	result := ao.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ao *AMQPOutput) handle(ctx context.HTTPContext) string {
+	err := ao.publish(ctx)
+	if err != nil {
+		atomic.AddUint64(&ao.failed, 1)
+		ao.lastError.Store(err.Error())
+		ctx.AddTag(fmt.Sprintf("amqpOutput: %v", err))
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultPublishFailed
+	}
+	return ""
+}
+
+func (ao *AMQPOutput) buildMessage(ctx context.HTTPContext) (string, amqp.Publishing, error) {
+	msg := amqp.Publishing{
+		ContentType: ao.spec.ContentType,
+		Timestamp:   time.Now(),
+	}
+	if msg.ContentType == "" {
+		msg.ContentType = ctx.Request().Header().Get("Content-Type")
+	}
+	if ao.spec.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	key := ao.spec.RoutingKey
+	if hte := ctx.Template(); hte != nil && hte.HasTemplates(key) {
+		var err error
+		if key, err = hte.Render(key); err != nil {
+			return "", msg, fmt.Errorf("render routing key failed: %v", err)
+		}
+	}
+
+	// NOTE: The request body must be set back for the following filters.
+	body, err := io.ReadAll(ctx.Request().Body())
+	if err != nil {
+		return "", msg, fmt.Errorf("read request body failed: %v", err)
+	}
+	ctx.Request().SetBody(bytes.NewReader(body))
+	msg.Body = body
+
+	return key, msg, nil
+}
+
+func (ao *AMQPOutput) publish(ctx context.HTTPContext) error {
+	key, msg, err := ao.buildMessage(ctx)
+	if err != nil {
+		return err
+	}
+
+	ao.mutex.Lock()
+	p, err := ao.getPublisher()
+	ao.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	wait, err := p.publish(ao.spec.Exchange, key, msg)
+	if err != nil {
+		return fmt.Errorf("publish failed: %v", err)
+	}
+	atomic.AddUint64(&ao.published, 1)
+
+	if wait == nil {
+		return nil
+	}
+
+	select {
+	case ack, ok := <-wait:
+		if !ok {
+			return errPublisherClosed
+		}
+		if !ack {
+			return fmt.Errorf("message nacked by broker")
+		}
+		atomic.AddUint64(&ao.confirmed, 1)
+		return nil
+	case <-time.After(ao.confirmTimeout):
+		return fmt.Errorf("wait for confirmation timeout")
+	}
+}
+
+// Status returns status.
+func (ao *AMQPOutput) Status() interface{} {
+	ao.mutex.Lock()
+	connected := ao.publisher != nil && !ao.publisher.closed()
+	ao.mutex.Unlock()
+
+	return &Status{
+		Published: atomic.LoadUint64(&ao.published),
+		Confirmed: atomic.LoadUint64(&ao.confirmed),
+		Failed:    atomic.LoadUint64(&ao.failed),
+		Connected: connected,
+		LastError: ao.lastError.Load().(string),
+	}
+}
+
+// Close closes AMQPOutput.
+func (ao *AMQPOutput) Close() {
+	ao.mutex.Lock()
+	defer ao.mutex.Unlock()
+
+	ao.closed = true
+	if ao.publisher == nil {
+		return
+	}
+	if err := ao.publisher.close(); err != nil && err != amqp.ErrClosed {
+		logger.Errorf("%s: close amqp connection failed: %v", ao.filterSpec.Name(), err)
+	}
+}
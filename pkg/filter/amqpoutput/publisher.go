@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package amqpoutput
+
+import (
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/megaease/easegress/pkg/util/amqptool"
+)
+
+var errPublisherClosed = fmt.Errorf("amqp channel closed")
+
+type (
+	// publisher publishes messages to an AMQP channel, when publisher
+	// confirms are enabled, it tracks the delivery tags of the messages
+	// to notify the confirmations to their publishers.
+	publisher struct {
+		conn    *amqp.Connection
+		ch      *amqp.Channel
+		confirm bool
+
+		// NOTE: The delivery tags are sequence numbers of the messages
+		// on the channel, so the tag must be assigned and the message
+		// must be published atomically.
+		publishMutex sync.Mutex
+		seq          uint64
+
+		pendingMutex sync.Mutex
+		pending      map[uint64]chan bool
+
+		done chan struct{}
+	}
+)
+
+func newPublisher(url string, queue *amqptool.QueueSpec, confirm bool) (*publisher, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("dial failed: %v", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open channel failed: %v", err)
+	}
+
+	if queue != nil {
+		if err = queue.Declare(ch); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	p := &publisher{
+		conn:    conn,
+		ch:      ch,
+		confirm: confirm,
+		pending: make(map[uint64]chan bool),
+		done:    make(chan struct{}),
+	}
+
+	if !confirm {
+		go p.watchClose(ch.NotifyClose(make(chan *amqp.Error, 1)))
+		return p, nil
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("enable publisher confirms failed: %v", err)
+	}
+	// NOTE: The channel of confirmations is closed with the AMQP channel.
+	go p.dispatch(ch.NotifyPublish(make(chan amqp.Confirmation, 256)))
+	return p, nil
+}
+
+func (p *publisher) watchClose(closed chan *amqp.Error) {
+	for range closed {
+	}
+	close(p.done)
+}
+
+func (p *publisher) dispatch(confirms chan amqp.Confirmation) {
+	for c := range confirms {
+		p.pendingMutex.Lock()
+		wait := p.pending[c.DeliveryTag]
+		delete(p.pending, c.DeliveryTag)
+		p.pendingMutex.Unlock()
+
+		if wait != nil {
+			wait <- c.Ack
+		}
+	}
+
+	p.pendingMutex.Lock()
+	close(p.done)
+	for tag, wait := range p.pending {
+		close(wait)
+		delete(p.pending, tag)
+	}
+	p.pendingMutex.Unlock()
+}
+
+func (p *publisher) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// publish publishes the message, when publisher confirms are enabled,
+// the returned channel receives whether the message is acknowledged by
+// the broker, or is closed if the AMQP channel is closed before that.
+func (p *publisher) publish(exchange, key string, msg amqp.Publishing) (<-chan bool, error) {
+	p.publishMutex.Lock()
+	defer p.publishMutex.Unlock()
+
+	if p.closed() {
+		return nil, errPublisherClosed
+	}
+
+	var wait chan bool
+	if p.confirm {
+		// NOTE: Check closed again with the lock, otherwise the pending
+		// message may be missed by dispatch.
+		p.pendingMutex.Lock()
+		if p.closed() {
+			p.pendingMutex.Unlock()
+			return nil, errPublisherClosed
+		}
+		p.seq++
+		wait = make(chan bool, 1)
+		p.pending[p.seq] = wait
+		p.pendingMutex.Unlock()
+	}
+
+	err := p.ch.Publish(exchange, key, false, false, msg)
+	if err != nil {
+		if p.confirm {
+			p.pendingMutex.Lock()
+			delete(p.pending, p.seq)
+			p.pendingMutex.Unlock()
+		}
+		return nil, err
+	}
+
+	return wait, nil
+}
+
+func (p *publisher) close() error {
+	return p.conn.Close()
+}
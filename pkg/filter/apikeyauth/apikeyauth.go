@@ -0,0 +1,344 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apikeyauth
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// Kind is the kind of APIKeyAuth.
+	Kind = "APIKeyAuth"
+
+	resultUnauthorized = "unauthorized"
+	resultForbidden    = "forbidden"
+	resultRateLimited  = "rateLimited"
+
+	defaultHeader = "X-API-Key"
+)
+
+var results = []string{resultUnauthorized, resultForbidden, resultRateLimited}
+
+func init() {
+	httppipeline.Register(&APIKeyAuth{})
+}
+
+type (
+	// APIKeyAuth authenticates requests by the API keys managed by the
+	// admin API, the keys are stored in the cluster and synced to all
+	// members.
+	APIKeyAuth struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		mutex sync.RWMutex
+		// keys is indexed by the hash of the keys.
+		keys map[string]*keyState
+		done chan struct{}
+
+		authorized   uint64
+		unauthorized uint64
+		forbidden    uint64
+		rateLimited  uint64
+	}
+
+	keyState struct {
+		key     *APIKey
+		limiter *librl.TokenBucket
+	}
+
+	// Spec describes the APIKeyAuth.
+	Spec struct {
+		// Header is the header of the key, default is X-API-Key.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+		// Query is the query parameter of the key, it is used if the
+		// header is absent.
+		Query string `yaml:"query" jsonschema:"omitempty"`
+		// IDHeader and OwnerHeader pass the ID and owner of the key to
+		// the backends.
+		IDHeader    string `yaml:"idHeader" jsonschema:"omitempty"`
+		OwnerHeader string `yaml:"ownerHeader" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of APIKeyAuth.
+	Status struct {
+		Keys         int    `yaml:"keys"`
+		Authorized   uint64 `yaml:"authorized"`
+		Unauthorized uint64 `yaml:"unauthorized"`
+		Forbidden    uint64 `yaml:"forbidden"`
+		RateLimited  uint64 `yaml:"rateLimited"`
+	}
+)
+
+// Kind returns the kind of APIKeyAuth.
+func (a *APIKeyAuth) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of APIKeyAuth.
+func (a *APIKeyAuth) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of APIKeyAuth.
+func (a *APIKeyAuth) Description() string {
+	return "APIKeyAuth authenticates requests by API keys."
+}
+
+// Results returns the results of APIKeyAuth.
+func (a *APIKeyAuth) Results() []string {
+	return results
+}
+
+// Init initializes APIKeyAuth.
+func (a *APIKeyAuth) Init(filterSpec *httppipeline.FilterSpec) {
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	a.reload(nil)
+}
+
+// Inherit inherits previous generation of APIKeyAuth.
+func (a *APIKeyAuth) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*APIKeyAuth)
+	previousGeneration.Close()
+
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	// NOTE: Keep the keys and their rate limiters until the keys are
+	// synced again.
+	prev.mutex.RLock()
+	keys := prev.keys
+	prev.mutex.RUnlock()
+	a.reload(keys)
+}
+
+func (a *APIKeyAuth) reload(keys map[string]*keyState) {
+	if a.spec.Header == "" {
+		a.spec.Header = defaultHeader
+	}
+
+	if keys == nil {
+		keys = map[string]*keyState{}
+	}
+	a.keys = keys
+	a.done = make(chan struct{})
+
+	super := a.filterSpec.Super()
+	if super != nil && super.Cluster() != nil {
+		go a.watchKeys(super.Cluster())
+	}
+}
+
+// watchKeys watches the API keys managed by the admin API.
+func (a *APIKeyAuth) watchKeys(c cluster.Cluster) {
+	var (
+		ch     <-chan map[string]string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	for {
+		syncer, err = c.Syncer(time.Minute)
+		if err == nil {
+			ch, err = syncer.SyncPrefix(c.Layout().APIKeyPrefix())
+			if err == nil {
+				break
+			}
+		}
+		logger.Errorf("failed to watch api keys: %v", err)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-a.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case values := <-ch:
+			a.setKeys(values)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// setKeys replaces the keys by the ones in the cluster, the rate limiters
+// of keys whose rate limit is unchanged are kept.
+func (a *APIKeyAuth) setKeys(values map[string]string) {
+	a.mutex.RLock()
+	old := map[string]*keyState{}
+	for _, ks := range a.keys {
+		old[ks.key.ID] = ks
+	}
+	a.mutex.RUnlock()
+
+	keys := make(map[string]*keyState, len(values))
+	for k, v := range values {
+		key, err := ParseAPIKey(v)
+		if err != nil {
+			logger.Errorf("failed to parse api key %s: %v", k, err)
+			continue
+		}
+		if key.KeyHash == "" {
+			continue
+		}
+
+		ks := &keyState{key: key}
+		if key.RateLimit != nil {
+			if prev := old[key.ID]; prev != nil && reflect.DeepEqual(prev.key.RateLimit, key.RateLimit) {
+				ks.limiter = prev.limiter
+			} else {
+				ks.limiter = key.RateLimit.newLimiter()
+			}
+		}
+		keys[key.KeyHash] = ks
+	}
+
+	a.mutex.Lock()
+	a.keys = keys
+	a.mutex.Unlock()
+}
+
+// Handle authenticates the request.
+func (a *APIKeyAuth) Handle(ctx context.HTTPContext) string {
+	result := a.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (a *APIKeyAuth) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	if a.spec.IDHeader != "" {
+		r.Header().Del(a.spec.IDHeader)
+	}
+	if a.spec.OwnerHeader != "" {
+		r.Header().Del(a.spec.OwnerHeader)
+	}
+
+	key := a.extractKey(r)
+	if key == "" {
+		return a.reject(ctx, http.StatusUnauthorized, resultUnauthorized, "missing api key")
+	}
+
+	a.mutex.RLock()
+	ks := a.keys[HashKey(key)]
+	a.mutex.RUnlock()
+
+	if ks == nil || ks.key.Disabled || ks.key.expired(time.Now()) {
+		return a.reject(ctx, http.StatusUnauthorized, resultUnauthorized, "invalid api key")
+	}
+	if !ks.key.allow(r.Method(), r.Path()) {
+		return a.reject(ctx, http.StatusForbidden, resultForbidden, "route not allowed for api key "+ks.key.ID)
+	}
+	if ks.limiter != nil {
+		if ok, d := ks.limiter.AcquirePermission(); !ok {
+			w.Header().Set("Retry-After", retryAfter(d))
+			return a.reject(ctx, http.StatusTooManyRequests, resultRateLimited, "api key "+ks.key.ID+" rate limited")
+		}
+	}
+
+	if a.spec.IDHeader != "" {
+		r.Header().Set(a.spec.IDHeader, ks.key.ID)
+	}
+	if a.spec.OwnerHeader != "" && ks.key.Owner != "" {
+		r.Header().Set(a.spec.OwnerHeader, ks.key.Owner)
+	}
+
+	atomic.AddUint64(&a.authorized, 1)
+	return ""
+}
+
+// extractKey gets the key from the request, and removes it from the
+// request, so it isn't sent to the backends.
+func (a *APIKeyAuth) extractKey(r context.HTTPRequest) string {
+	if key := r.Header().Get(a.spec.Header); key != "" {
+		r.Header().Del(a.spec.Header)
+		return key
+	}
+
+	if a.spec.Query == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(r.Query())
+	if err != nil {
+		return ""
+	}
+	key := query.Get(a.spec.Query)
+	if key != "" {
+		query.Del(a.spec.Query)
+		r.SetQuery(query.Encode())
+	}
+	return key
+}
+
+// retryAfter returns the value of the Retry-After header in seconds,
+// which is at least 1.
+func retryAfter(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+func (a *APIKeyAuth) reject(ctx context.HTTPContext, code int, result, reason string) string {
+	switch result {
+	case resultUnauthorized:
+		atomic.AddUint64(&a.unauthorized, 1)
+	case resultForbidden:
+		atomic.AddUint64(&a.forbidden, 1)
+	case resultRateLimited:
+		atomic.AddUint64(&a.rateLimited, 1)
+	}
+	ctx.Response().SetStatusCode(code)
+	ctx.AddTag("apiKeyAuth: " + reason)
+	return result
+}
+
+// Status returns status.
+func (a *APIKeyAuth) Status() interface{} {
+	a.mutex.RLock()
+	keys := len(a.keys)
+	a.mutex.RUnlock()
+
+	return &Status{
+		Keys:         keys,
+		Authorized:   atomic.LoadUint64(&a.authorized),
+		Unauthorized: atomic.LoadUint64(&a.unauthorized),
+		Forbidden:    atomic.LoadUint64(&a.forbidden),
+		RateLimited:  atomic.LoadUint64(&a.rateLimited),
+	}
+}
+
+// Close closes APIKeyAuth.
+func (a *APIKeyAuth) Close() {
+	close(a.done)
+}
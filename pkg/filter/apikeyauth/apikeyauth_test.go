@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apikeyauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func do(a *APIKeyAuth, method, target string, header http.Header) (string, *http.Request) {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	result, _ := httppipelinetest.Handle(a, req, nil)
+	return result, req
+}
+
+// newKeys generates the keys and returns the values stored in the cluster
+// and the keys.
+func newKeys(t *testing.T, keys ...*APIKey) (map[string]string, []string) {
+	values := map[string]string{}
+	var secrets []string
+	for _, key := range keys {
+		id, secret := GenerateKey()
+		key.ID, key.KeyHash = id, HashKey(secret)
+		buf, err := yaml.Marshal(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		values["/apikeys/"+id] = string(buf)
+		secrets = append(secrets, secret)
+	}
+	return values, secrets
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &APIKeyAuth{}, `
+kind: APIKeyAuth
+name: apikey
+query: api_key
+idHeader: X-Key-Id
+ownerHeader: X-Key-Owner
+`).(*APIKeyAuth)
+	defer a.Close()
+
+	values, secrets := newKeys(t,
+		&APIKey{Owner: "alice"},
+		&APIKey{
+			Owner:  "bob",
+			Routes: []*Route{{PathPrefix: "/orders/", Methods: []string{http.MethodGet}}},
+		},
+		&APIKey{ExpiresAt: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		&APIKey{Disabled: true},
+	)
+	a.setKeys(values)
+
+	header := http.Header{"X-Api-Key": []string{secrets[0]}, "X-Key-Owner": []string{"mallory"}}
+	result, req := do(a, http.MethodPost, "/users", header)
+	if result != "" {
+		t.Fatalf("request should be authorized, got %s", result)
+	}
+	if req.Header.Get("X-Key-Owner") != "alice" || req.Header.Get("X-Key-Id") == "" {
+		t.Errorf("owner and id of the key should be passed, got %v", req.Header)
+	}
+	if req.Header.Get("X-Api-Key") != "" {
+		t.Errorf("key should be removed from the request")
+	}
+
+	result, req = do(a, http.MethodGet, "/orders/1?api_key="+secrets[1]+"&x=1", nil)
+	if result != "" {
+		t.Errorf("request should be authorized by query, got %s", result)
+	}
+	if req.URL.RawQuery != "x=1" {
+		t.Errorf("key should be removed from the query, got %s", req.URL.RawQuery)
+	}
+
+	header = http.Header{"X-Api-Key": []string{secrets[1]}}
+	if result, _ = do(a, http.MethodDelete, "/orders/1", header); result != resultForbidden {
+		t.Errorf("method should be forbidden, got %s", result)
+	}
+	header = http.Header{"X-Api-Key": []string{secrets[1]}}
+	if result, _ = do(a, http.MethodGet, "/users", header); result != resultForbidden {
+		t.Errorf("route should be forbidden, got %s", result)
+	}
+
+	for i, secret := range []string{secrets[2], secrets[3], "egk_unknown", ""} {
+		header = http.Header{"X-Api-Key": []string{secret}}
+		if result, _ = do(a, http.MethodGet, "/users", header); result != resultUnauthorized {
+			t.Errorf("key %d should be unauthorized, got %s", i, result)
+		}
+	}
+
+	// revoke the key.
+	delete(values, "/apikeys/"+a.keys[HashKey(secrets[0])].key.ID)
+	a.setKeys(values)
+	header = http.Header{"X-Api-Key": []string{secrets[0]}}
+	if result, _ = do(a, http.MethodGet, "/users", header); result != resultUnauthorized {
+		t.Errorf("revoked key should be unauthorized, got %s", result)
+	}
+
+	s := a.Status().(*Status)
+	if s.Keys != 3 || s.Authorized != 2 || s.Forbidden != 2 || s.Unauthorized != 5 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &APIKeyAuth{}, `
+kind: APIKeyAuth
+name: apikey
+`).(*APIKeyAuth)
+	defer a.Close()
+
+	values, secrets := newKeys(t, &APIKey{RateLimit: &RateLimit{RPS: 1, Burst: 2}})
+	a.setKeys(values)
+
+	header := http.Header{"X-Api-Key": []string{secrets[0]}}
+	for i := 0; i < 2; i++ {
+		if result, _ := do(a, http.MethodGet, "/", header); result != "" {
+			t.Errorf("request %d should be authorized, got %s", i, result)
+		}
+	}
+	if result, _ := do(a, http.MethodGet, "/", header); result != resultRateLimited {
+		t.Errorf("request should be rate limited, got %s", result)
+	}
+
+	// the limiter is kept if the rate limit is unchanged.
+	a.setKeys(values)
+	if result, _ := do(a, http.MethodGet, "/", header); result != resultRateLimited {
+		t.Errorf("request should be rate limited, got %s", result)
+	}
+
+	// the rps could be less than 1.
+	limiter := (&RateLimit{RPS: 0.1}).newLimiter()
+	if ok, _ := limiter.AcquirePermission(); !ok {
+		t.Error("request should be permitted by burst")
+	}
+	if ok, d := limiter.AcquirePermission(); ok || retryAfter(d) != "10" {
+		t.Errorf("request should be rate limited for 10s, got %v %s", ok, d)
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	for _, value := range []string{
+		"routes: [{pathPrefix: orders}]",
+		"rateLimit: {rps: 0}",
+		"expiresAt: tomorrow",
+		"[",
+	} {
+		if _, err := ParseAPIKey(value); err == nil {
+			t.Errorf("%q should be invalid", value)
+		}
+	}
+
+	key, err := ParseAPIKey("id: abc\nrateLimit: {rps: 10}\nexpiresAt: 2030-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.ID != "abc" || key.RateLimit.RPS != 10 {
+		t.Errorf("unexpected key %+v", key)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apikeyauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const keyPrefix = "egk_"
+
+type (
+	// APIKey is an API key stored in the cluster, only the hash of the
+	// key is stored, so the key can't be recovered from the store.
+	APIKey struct {
+		ID        string     `yaml:"id"`
+		Owner     string     `yaml:"owner,omitempty"`
+		KeyHash   string     `yaml:"keyHash,omitempty"`
+		Routes    []*Route   `yaml:"routes,omitempty"`
+		RateLimit *RateLimit `yaml:"rateLimit,omitempty"`
+		// ExpiresAt is in RFC3339 format, the key never expires if empty.
+		ExpiresAt string `yaml:"expiresAt,omitempty"`
+		Disabled  bool   `yaml:"disabled,omitempty"`
+		CreatedAt string `yaml:"createdAt,omitempty"`
+	}
+
+	// Route is a route the key is allowed to access.
+	Route struct {
+		PathPrefix string   `yaml:"pathPrefix"`
+		Methods    []string `yaml:"methods,omitempty"`
+	}
+
+	// RateLimit is the rate limit of a key, it is enforced by every
+	// member of the cluster separately.
+	RateLimit struct {
+		RPS   float64 `yaml:"rps"`
+		Burst int     `yaml:"burst,omitempty"`
+	}
+)
+
+// ParseAPIKey parses the API key stored in the cluster.
+func ParseAPIKey(value string) (*APIKey, error) {
+	key := &APIKey{}
+	if err := yaml.Unmarshal([]byte(value), key); err != nil {
+		return nil, err
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Validate validates the API key.
+func (k *APIKey) Validate() error {
+	for _, r := range k.Routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return fmt.Errorf("path prefix of route should begin with /: %s", r.PathPrefix)
+		}
+	}
+	if k.RateLimit != nil {
+		if k.RateLimit.RPS <= 0 {
+			return fmt.Errorf("rps of rate limit should be greater than 0")
+		}
+		if k.RateLimit.Burst < 0 {
+			return fmt.Errorf("burst of rate limit should not be negative")
+		}
+	}
+	if k.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, k.ExpiresAt); err != nil {
+			return fmt.Errorf("invalid expiresAt: %v", err)
+		}
+	}
+	return nil
+}
+
+// GenerateKey generates an ID and a key, the key contains the ID, so it
+// could be identified in logs without revealing the secret part.
+func GenerateKey() (id string, key string) {
+	buff := make([]byte, 8+32)
+	rand.Read(buff)
+	id = hex.EncodeToString(buff[:8])
+	key = keyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(buff[8:])
+	return id, key
+}
+
+// HashKey returns the hash of the key to store.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (k *APIKey) expired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	// NOTE: ExpiresAt has been checked by Validate.
+	t, _ := time.Parse(time.RFC3339, k.ExpiresAt)
+	return now.After(t)
+}
+
+func (k *APIKey) allow(method, path string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, r := range k.Routes {
+		if !strings.HasPrefix(path, r.PathPrefix) {
+			continue
+		}
+		if len(r.Methods) == 0 {
+			return true
+		}
+		for _, m := range r.Methods {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newLimiter creates the token bucket of the rate limit, the burst is the
+// rps by default, and at least 1.
+func (rl *RateLimit) newLimiter() *librl.TokenBucket {
+	burst := rl.Burst
+	if burst < 1 {
+		burst = int(rl.RPS)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	// NOTE: The rps may be less than 1, so one token is added every
+	// period of 1/rps second.
+	return librl.NewTokenBucket(1, time.Duration(float64(time.Second)/rl.RPS), burst)
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package botdetector
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of BotDetector.
+	Kind = "BotDetector"
+
+	resultChallenged = "challenged"
+	resultBlocked    = "blocked"
+
+	actionJSChallenge  = "jsChallenge"
+	actionPoWChallenge = "powChallenge"
+	actionTarpit       = "tarpit"
+	actionBlock        = "block"
+
+	sensitivityOff    = "off"
+	sensitivityLow    = "low"
+	sensitivityMedium = "medium"
+	sensitivityHigh   = "high"
+
+	defaultCookieName    = "eg_bot_clearance"
+	defaultClearanceTTL  = time.Hour
+	defaultTarpitDelay   = 5 * time.Second
+	defaultPoWDifficulty = 16
+	defaultRateWindow    = 10 * time.Second
+	defaultRateRequests  = 50
+)
+
+var (
+	results = []string{resultChallenged, resultBlocked}
+
+	// thresholds are the scores to take the action at the sensitivities.
+	thresholds = map[string]int{
+		sensitivityLow:    70,
+		sensitivityMedium: 50,
+		sensitivityHigh:   30,
+	}
+)
+
+func init() {
+	httppipeline.Register(&BotDetector{})
+}
+
+type (
+	// BotDetector scores requests by heuristics, and challenges, slows
+	// down or blocks the requests which are likely from bots.
+	BotDetector struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		clearanceTTL time.Duration
+		tarpitDelay  time.Duration
+		rates        *rateCounter
+		challenger   *challenger
+		allowedUAs   []*regexp.Regexp
+		routes       []*route
+
+		requests   uint64
+		detected   uint64
+		challenged uint64
+		cleared    uint64
+		tarpitted  uint64
+		blocked    uint64
+	}
+
+	// Spec describes the BotDetector.
+	Spec struct {
+		// Sensitivity is off, low, medium (default) or high.
+		Sensitivity string `yaml:"sensitivity,omitempty" jsonschema:"omitempty,enum=off,enum=low,enum=medium,enum=high"`
+		// Action is jsChallenge (default), powChallenge, tarpit or block.
+		Action string `yaml:"action,omitempty" jsonschema:"omitempty,enum=jsChallenge,enum=powChallenge,enum=tarpit,enum=block"`
+
+		Rate          *RateSpec `yaml:"rate,omitempty" jsonschema:"omitempty"`
+		PoWDifficulty int       `yaml:"powDifficulty" jsonschema:"omitempty,minimum=0,maximum=32"`
+		TarpitDelay   string    `yaml:"tarpitDelay" jsonschema:"omitempty,format=duration"`
+		ClearanceTTL  string    `yaml:"clearanceTTL" jsonschema:"omitempty,format=duration"`
+		CookieName    string    `yaml:"cookieName" jsonschema:"omitempty"`
+		// Secret signs the challenges, it must be the same for all
+		// instances serving the same clients.
+		Secret string `yaml:"secret" jsonschema:"omitempty"`
+		// AllowedUserAgents are regular expressions of user agents which
+		// are never checked, like well-known crawlers.
+		AllowedUserAgents []string `yaml:"allowedUserAgents" jsonschema:"omitempty"`
+
+		Routes []*RouteSpec `yaml:"routes" jsonschema:"omitempty"`
+	}
+
+	// RateSpec describes the request rate regarded as a bot.
+	RateSpec struct {
+		Window   string `yaml:"window" jsonschema:"omitempty,format=duration"`
+		Requests int    `yaml:"requests" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// RouteSpec overrides the sensitivity and the action of requests of
+	// matched paths.
+	RouteSpec struct {
+		Path        string `yaml:"path" jsonschema:"omitempty"`
+		PathPrefix  string `yaml:"pathPrefix" jsonschema:"omitempty"`
+		Sensitivity string `yaml:"sensitivity,omitempty" jsonschema:"omitempty,enum=off,enum=low,enum=medium,enum=high"`
+		Action      string `yaml:"action,omitempty" jsonschema:"omitempty,enum=jsChallenge,enum=powChallenge,enum=tarpit,enum=block"`
+	}
+
+	route struct {
+		spec        *RouteSpec
+		sensitivity string
+		action      string
+	}
+
+	// Status is the status of BotDetector.
+	Status struct {
+		Requests   uint64 `yaml:"requests"`
+		Detected   uint64 `yaml:"detected"`
+		Challenged uint64 `yaml:"challenged"`
+		Cleared    uint64 `yaml:"cleared"`
+		Tarpitted  uint64 `yaml:"tarpitted"`
+		Blocked    uint64 `yaml:"blocked"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	for _, s := range spec.AllowedUserAgents {
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("invalid user agent regexp %s: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the RouteSpec.
+func (spec RouteSpec) Validate() error {
+	if (spec.Path == "") == (spec.PathPrefix == "") {
+		return fmt.Errorf("one and only one of path and pathPrefix should be specified")
+	}
+	return nil
+}
+
+func (r *route) match(path string) bool {
+	if r.spec.Path != "" {
+		return r.spec.Path == path
+	}
+	return strings.HasPrefix(path, r.spec.PathPrefix)
+}
+
+// Kind returns the kind of BotDetector.
+func (b *BotDetector) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of BotDetector.
+func (b *BotDetector) DefaultSpec() interface{} {
+	return &Spec{
+		Sensitivity: sensitivityMedium,
+		Action:      actionJSChallenge,
+	}
+}
+
+// Description returns the description of BotDetector.
+func (b *BotDetector) Description() string {
+	return "BotDetector detects bots and challenges, slows down or blocks them."
+}
+
+// Results returns the results of BotDetector.
+func (b *BotDetector) Results() []string {
+	return results
+}
+
+// Init initializes BotDetector.
+func (b *BotDetector) Init(filterSpec *httppipeline.FilterSpec) {
+	b.filterSpec, b.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	b.reload(nil)
+}
+
+// Inherit inherits previous generation of BotDetector.
+func (b *BotDetector) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*BotDetector)
+	previousGeneration.Close()
+
+	b.filterSpec, b.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	b.reload(prev)
+}
+
+func parseDurationOr(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		return d
+	}
+	return v
+}
+
+func (b *BotDetector) reload(prev *BotDetector) {
+	if b.spec.Sensitivity == "" {
+		b.spec.Sensitivity = sensitivityMedium
+	}
+	if b.spec.Action == "" {
+		b.spec.Action = actionJSChallenge
+	}
+	if b.spec.PoWDifficulty == 0 {
+		b.spec.PoWDifficulty = defaultPoWDifficulty
+	}
+	if b.spec.CookieName == "" {
+		b.spec.CookieName = defaultCookieName
+	}
+	if b.spec.Rate == nil {
+		b.spec.Rate = &RateSpec{}
+	}
+	if b.spec.Rate.Requests == 0 {
+		b.spec.Rate.Requests = defaultRateRequests
+	}
+
+	b.clearanceTTL = parseDurationOr(b.spec.ClearanceTTL, defaultClearanceTTL)
+	b.tarpitDelay = parseDurationOr(b.spec.TarpitDelay, defaultTarpitDelay)
+	window := parseDurationOr(b.spec.Rate.Window, defaultRateWindow)
+
+	// NOTE: Keep the rates and the generated secret, so clearances issued
+	// by the previous generation are still valid.
+	if prev != nil && prev.rates.window == window {
+		b.rates = prev.rates
+	} else {
+		b.rates = newRateCounter(window)
+	}
+	if prev != nil && prev.spec.Secret == b.spec.Secret {
+		b.challenger = prev.challenger
+	} else {
+		b.challenger = newChallenger(b.spec.Secret)
+	}
+
+	for _, s := range b.spec.AllowedUserAgents {
+		b.allowedUAs = append(b.allowedUAs, regexp.MustCompile(s))
+	}
+
+	for _, spec := range b.spec.Routes {
+		r := &route{spec: spec, sensitivity: spec.Sensitivity, action: spec.Action}
+		if r.sensitivity == "" {
+			r.sensitivity = b.spec.Sensitivity
+		}
+		if r.action == "" {
+			r.action = b.spec.Action
+		}
+		b.routes = append(b.routes, r)
+	}
+}
+
+// policy returns the sensitivity and the action of the path.
+func (b *BotDetector) policy(path string) (string, string) {
+	for _, r := range b.routes {
+		if r.match(path) {
+			return r.sensitivity, r.action
+		}
+	}
+	return b.spec.Sensitivity, b.spec.Action
+}
+
+// Handle detects bots.
+func (b *BotDetector) Handle(ctx context.HTTPContext) string {
+	result := b.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (b *BotDetector) handle(ctx context.HTTPContext) string {
+	atomic.AddUint64(&b.requests, 1)
+
+	r := ctx.Request()
+	sensitivity, action := b.policy(r.Path())
+	if sensitivity == sensitivityOff {
+		return ""
+	}
+
+	ua := r.Header().Get("User-Agent")
+	for _, re := range b.allowedUAs {
+		if re.MatchString(ua) {
+			return ""
+		}
+	}
+
+	now := time.Now()
+	// NOTE: The rate is counted for all requests, including the cleared
+	// ones, so it is accurate.
+	rate := b.rates.hit(r.RealIP(), now)
+
+	minDifficulty := 0
+	if action == actionPoWChallenge {
+		minDifficulty = b.spec.PoWDifficulty
+	}
+	if cookie, err := r.Cookie(b.spec.CookieName); err == nil {
+		if b.challenger.verify(cookie.Value, ua, minDifficulty, now) {
+			atomic.AddUint64(&b.cleared, 1)
+			return ""
+		}
+	}
+
+	score, reasons := scoreHeaders(r)
+	if rate > float64(b.spec.Rate.Requests) {
+		score += scoreRateExceeded
+		reasons = append(reasons, "rate exceeded")
+	}
+	if score > maxScore {
+		score = maxScore
+	}
+	if score < thresholds[sensitivity] {
+		return ""
+	}
+
+	atomic.AddUint64(&b.detected, 1)
+	ctx.AddTag(fmt.Sprintf("botDetector: score %d (%s), %s", score, strings.Join(reasons, ", "), action))
+
+	switch action {
+	case actionTarpit:
+		atomic.AddUint64(&b.tarpitted, 1)
+		select {
+		case <-time.After(b.tarpitDelay):
+		case <-r.Std().Context().Done():
+		}
+		return ""
+	case actionBlock:
+		atomic.AddUint64(&b.blocked, 1)
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		return resultBlocked
+	default:
+		atomic.AddUint64(&b.challenged, 1)
+		b.challenge(ctx, action, ua, now)
+		return resultChallenged
+	}
+}
+
+func (b *BotDetector) challenge(ctx context.HTTPContext, action, ua string, now time.Time) {
+	expires := now.Add(b.clearanceTTL)
+	maxAge := int(b.clearanceTTL / time.Second)
+
+	var page string
+	if action == actionPoWChallenge {
+		kind := fmt.Sprintf("%s%d", challengePoW, b.spec.PoWDifficulty)
+		challenge := b.challenger.newChallenge(kind, ua, expires)
+		page = powChallengePage(challenge, b.spec.CookieName, maxAge, b.spec.PoWDifficulty)
+	} else {
+		challenge := b.challenger.newChallenge(challengeJS, ua, expires)
+		page = jsChallengePage(challenge, b.spec.CookieName, maxAge)
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(http.StatusForbidden)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.SetBody(strings.NewReader(page))
+}
+
+// Status returns status.
+func (b *BotDetector) Status() interface{} {
+	return &Status{
+		Requests:   atomic.LoadUint64(&b.requests),
+		Detected:   atomic.LoadUint64(&b.detected),
+		Challenged: atomic.LoadUint64(&b.challenged),
+		Cleared:    atomic.LoadUint64(&b.cleared),
+		Tarpitted:  atomic.LoadUint64(&b.tarpitted),
+		Blocked:    atomic.LoadUint64(&b.blocked),
+	}
+}
+
+// Close closes BotDetector.
+func (b *BotDetector) Close() {}
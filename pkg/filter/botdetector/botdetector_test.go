@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package botdetector
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func browserRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0 Safari/537.36")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "en-US")
+	req.Header.Set("Accept-Encoding", "gzip")
+	return req
+}
+
+func scriptRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("User-Agent", "python-requests/2.26.0")
+	req.Header.Set("Accept", "*/*")
+	return req
+}
+
+func newRequest(req *http.Request) context.HTTPRequest {
+	ctx, _ := httppipelinetest.NewContext(req, nil)
+	return ctx.Request()
+}
+
+func TestScoreHeaders(t *testing.T) {
+	if score, _ := scoreHeaders(newRequest(browserRequest("/"))); score != 0 {
+		t.Errorf("browser should score 0, got %d", score)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	score, reasons := scoreHeaders(newRequest(req))
+	if score != scoreEmptyUserAgent+scoreNoAccept+scoreNoAcceptLanguage+scoreNoAcceptEncoding || len(reasons) != 4 {
+		t.Errorf("unexpected score %d: %v", score, reasons)
+	}
+
+	req = browserRequest("/")
+	req.TLS = &tls.ConnectionState{}
+	score, _ = scoreHeaders(newRequest(req))
+	if score != scoreInconsistentClient {
+		t.Errorf("browser without fetch metadata over https should be inconsistent, got %d", score)
+	}
+}
+
+func TestJSChallenge(t *testing.T) {
+	b := httppipelinetest.InitFilter(t, &BotDetector{}, `
+kind: BotDetector
+name: bot
+clearanceTTL: 10m
+`).(*BotDetector)
+
+	if result, _ := httppipelinetest.Handle(b, browserRequest("/"), nil); result != "" {
+		t.Error("browser should pass")
+	}
+
+	result, w := httppipelinetest.Handle(b, scriptRequest("/"), nil)
+	if result != resultChallenged || w.Code != http.StatusForbidden {
+		t.Fatal("script should be challenged")
+	}
+	body, _ := io.ReadAll(w.Body)
+
+	// NOTE: Solve the challenge like a browser.
+	m := regexp.MustCompile(`var a=(\d+),b=(\d+),c=(\d+);document.cookie="eg_bot_clearance=([^"]+)\."`).FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("unexpected challenge page %s", body)
+	}
+	a, _ := strconv.Atoi(string(m[1]))
+	bb, _ := strconv.Atoi(string(m[2]))
+	c, _ := strconv.Atoi(string(m[3]))
+	clearance := string(m[4]) + "." + strconv.Itoa(a*bb+c)
+
+	req := scriptRequest("/")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: clearance})
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != "" {
+		t.Error("client with clearance should pass")
+	}
+
+	req = scriptRequest("/")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: string(m[4]) + ".1"})
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != resultChallenged {
+		t.Error("wrong answer should be challenged")
+	}
+
+	// NOTE: The clearance is bound to the user agent.
+	req = scriptRequest("/")
+	req.Header.Set("User-Agent", "curl/7.79.1")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: clearance})
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != resultChallenged {
+		t.Error("clearance of another user agent should be challenged")
+	}
+
+	s := b.Status().(*Status)
+	if s.Challenged != 3 || s.Cleared != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestPoWChallenge(t *testing.T) {
+	b := httppipelinetest.InitFilter(t, &BotDetector{}, `
+kind: BotDetector
+name: bot
+action: powChallenge
+powDifficulty: 8
+`).(*BotDetector)
+
+	ua := "python-requests/2.26.0"
+	challenge := b.challenger.newChallenge("pow8", ua, time.Now().Add(time.Minute))
+	answer := 0
+	for ; leadingZeroBits(challenge, strconv.Itoa(answer)) < 8; answer++ {
+	}
+	clearance := challenge + "." + strconv.Itoa(answer)
+
+	req := scriptRequest("/")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: clearance})
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != "" {
+		t.Error("client with proof of work should pass")
+	}
+
+	// NOTE: The clearance of the JavaScript challenge isn't enough.
+	js := b.challenger.newChallenge(challengeJS, ua, time.Now().Add(time.Minute))
+	req = scriptRequest("/")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: js + "." + jsAnswer(js)})
+	result, w := httppipelinetest.Handle(b, req, nil)
+	if result != resultChallenged {
+		t.Fatal("js clearance should not pass the proof of work challenge")
+	}
+	body, _ := io.ReadAll(w.Body)
+	if !regexp.MustCompile(`crypto\.subtle\.digest`).Match(body) {
+		t.Error("proof of work page should be returned")
+	}
+
+	if b.challenger.verify(clearance, ua, 8, time.Now().Add(2*time.Minute)) {
+		t.Error("expired clearance should be rejected")
+	}
+}
+
+func TestRoutesAndActions(t *testing.T) {
+	b := httppipelinetest.InitFilter(t, &BotDetector{}, `
+kind: BotDetector
+name: bot
+action: block
+tarpitDelay: 50ms
+rate:
+  window: 1m
+  requests: 3
+allowedUserAgents: ["^Googlebot/"]
+routes:
+- pathPrefix: /api/
+  sensitivity: "off"
+- path: /login
+  sensitivity: high
+  action: tarpit
+`).(*BotDetector)
+
+	if result, w := httppipelinetest.Handle(b, scriptRequest("/"), nil); result != resultBlocked || w.Code != http.StatusForbidden {
+		t.Error("script should be blocked")
+	}
+	if result, _ := httppipelinetest.Handle(b, scriptRequest("/api/users"), nil); result != "" {
+		t.Error("script should pass on /api")
+	}
+
+	req := scriptRequest("/")
+	req.Header.Set("User-Agent", "Googlebot/2.1")
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != "" {
+		t.Error("allowed user agent should pass")
+	}
+
+	// NOTE: Requests without accept-language and accept-encoding score 25,
+	// which is lower than medium but the rate makes it a bot.
+	req = browserRequest("/login")
+	req.Header.Del("Accept-Language")
+	req.Header.Del("Accept-Encoding")
+	start := time.Now()
+	if result, _ := httppipelinetest.Handle(b, req, nil); result != "" || time.Since(start) > 40*time.Millisecond {
+		t.Error("request scoring 25 should pass on /login")
+	}
+	for i := 0; i < 3; i++ {
+		httppipelinetest.Handle(b, browserRequest("/"), nil)
+	}
+	start = time.Now()
+	result, _ := httppipelinetest.Handle(b, req, nil)
+	if result != "" || time.Since(start) < 50*time.Millisecond {
+		t.Error("request exceeding the rate should be tarpitted on /login")
+	}
+	if s := b.Status().(*Status); s.Tarpitted != 1 || s.Blocked != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestRateCounter(t *testing.T) {
+	rc := newRateCounter(time.Second)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		rc.hit("a", now)
+	}
+	if n := rc.hit("a", now.Add(1500*time.Millisecond)); n < 5.9 || n > 6.1 {
+		t.Errorf("half of previous window should be counted, got %v", n)
+	}
+	if n := rc.hit("a", now.Add(5*time.Second)); n != 1 {
+		t.Errorf("old windows should be dropped, got %v", n)
+	}
+}
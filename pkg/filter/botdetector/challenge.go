@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package botdetector
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	challengeJS  = "js"
+	challengePoW = "pow"
+)
+
+type (
+	// challenger issues and verifies challenges, which are stateless: a
+	// challenge is like {kind}.{expires}.{random}.{signature}, and the
+	// clearance cookie is the challenge with the answer appended.
+	challenger struct {
+		key []byte
+	}
+)
+
+func newChallenger(secret string) *challenger {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Errorf("read random bytes failed: %v", err))
+		}
+	}
+	return &challenger{key: key}
+}
+
+func (c *challenger) sign(payload, userAgent string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return mac.Sum(nil)
+}
+
+// newChallenge creates a challenge bound to the user agent, the kind is
+// like js or pow16, where 16 is the difficulty.
+func (c *challenger) newChallenge(kind, userAgent string, expires time.Time) string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Errorf("read random bytes failed: %v", err))
+	}
+	payload := kind + "." + strconv.FormatInt(expires.Unix(), 10) + "." +
+		base64.RawURLEncoding.EncodeToString(random)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload, userAgent))
+}
+
+// jsOperands returns the operands of the JavaScript challenge, the answer
+// is a*b+c, they are derived from the signature, so no state is kept.
+func jsOperands(challenge string) (uint32, uint32, uint32) {
+	sum := sha256.Sum256([]byte(challenge))
+	return binary.BigEndian.Uint32(sum[0:4]) % 10000,
+		binary.BigEndian.Uint32(sum[4:8]) % 10000,
+		binary.BigEndian.Uint32(sum[8:12]) % 10000
+}
+
+func jsAnswer(challenge string) string {
+	a, b, c := jsOperands(challenge)
+	return strconv.FormatUint(uint64(a)*uint64(b)+uint64(c), 10)
+}
+
+// leadingZeroBits returns the number of leading zero bits of the SHA-256
+// of {challenge}:{answer}.
+func leadingZeroBits(challenge, answer string) int {
+	sum := sha256.Sum256([]byte(challenge + ":" + answer))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// verify verifies the clearance, the clearance of a proof-of-work
+// challenge is accepted if its difficulty isn't less than minDifficulty,
+// and the clearance of the JavaScript challenge is accepted only if
+// minDifficulty is 0.
+func (c *challenger) verify(clearance, userAgent string, minDifficulty int, now time.Time) bool {
+	idx := strings.LastIndexByte(clearance, '.')
+	if idx < 0 {
+		return false
+	}
+	challenge, answer := clearance[:idx], clearance[idx+1:]
+
+	fields := strings.Split(challenge, ".")
+	if len(fields) != 4 {
+		return false
+	}
+	kind, expiresField, signature := fields[0], fields[1], fields[3]
+
+	payload := strings.Join(fields[:3], ".")
+	expected := base64.RawURLEncoding.EncodeToString(c.sign(payload, userAgent))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(expiresField, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+
+	if kind == challengeJS {
+		return minDifficulty == 0 && answer == jsAnswer(challenge)
+	}
+	if !strings.HasPrefix(kind, challengePoW) {
+		return false
+	}
+	difficulty, err := strconv.Atoi(strings.TrimPrefix(kind, challengePoW))
+	if err != nil || difficulty < minDifficulty {
+		return false
+	}
+	return leadingZeroBits(challenge, answer) >= difficulty
+}
+
+// jsChallengePage returns the page setting the clearance cookie by
+// JavaScript, which is executed by browsers but not by most scripts.
+func jsChallengePage(challenge, cookieName string, maxAge int) string {
+	a, b, c := jsOperands(challenge)
+	script := fmt.Sprintf(`(function(){var a=%d,b=%d,c=%d;`+
+		`document.cookie="%s=%s."+(a*b+c)+"; path=/; max-age=%d; SameSite=Lax";`+
+		`location.reload();})();`, a, b, c, cookieName, challenge, maxAge)
+	return challengePage(script)
+}
+
+// powChallengePage returns the page solving the proof-of-work challenge,
+// it finds the answer making SHA-256 of {challenge}:{answer} have enough
+// leading zero bits, WebCrypto is only available in secure contexts.
+func powChallengePage(challenge, cookieName string, maxAge, difficulty int) string {
+	script := fmt.Sprintf(`(async function(){var n="%s",d=%d,e=new TextEncoder();`+
+		`function lz(h){var z=0;for(var j=0;j<h.length;j++){if(h[j]===0){z+=8;continue}return z+Math.clz32(h[j])-24}return z}`+
+		`for(var i=0;;i++){var h=new Uint8Array(await crypto.subtle.digest("SHA-256",e.encode(n+":"+i)));`+
+		`if(lz(h)>=d){document.cookie="%s="+n+"."+i+"; path=/; max-age=%d; SameSite=Lax";location.reload();return}}})();`,
+		challenge, difficulty, cookieName, maxAge)
+	return challengePage(script)
+}
+
+func challengePage(script string) string {
+	return `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Checking your browser</title></head>
+<body>
+<p>Checking your browser before accessing the site, this may take a few seconds.</p>
+<noscript><p>Please enable JavaScript and cookies to continue.</p></noscript>
+<script>` + script + `</script>
+</body>
+</html>
+`
+}
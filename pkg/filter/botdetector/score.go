@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package botdetector
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	scoreEmptyUserAgent     = 40
+	scoreToolUserAgent      = 50
+	scoreNoAccept           = 15
+	scoreNoAcceptLanguage   = 15
+	scoreNoAcceptEncoding   = 10
+	scoreInconsistentClient = 20
+	scoreRateExceeded       = 40
+	maxScore                = 100
+
+	maxRateEntries = 100000
+)
+
+var (
+	// toolUserAgentRE matches the user agents of HTTP libraries, command
+	// line tools, crawler frameworks and headless browsers.
+	toolUserAgentRE = regexp.MustCompile(`(?i)(?:curl|wget|python-requests|python-urllib|aiohttp|httpx|go-http-client|java/|okhttp|apache-httpclient|libwww-perl|lwp::|php/|guzzle|ruby|node-fetch|axios|scrapy|httpie|headlesschrome|phantomjs|selenium|puppeteer|playwright|slimerjs|bot\b|crawler|spider)`)
+
+	browserUserAgentRE = regexp.MustCompile(`^Mozilla/5\.0 `)
+)
+
+type (
+	// rateCounter approximates the number of requests of every client in
+	// the sliding window by the counts of the current and the previous
+	// fixed windows.
+	rateCounter struct {
+		window time.Duration
+
+		mutex    sync.Mutex
+		start    time.Time
+		current  map[string]uint32
+		previous map[string]uint32
+	}
+)
+
+// scoreHeaders scores the request by the heuristics of the user agent and
+// the fingerprint of the headers, real browsers always send some headers
+// which are often missed by scripts.
+func scoreHeaders(r context.HTTPRequest) (int, []string) {
+	h := r.Header()
+	ua := h.Get("User-Agent")
+
+	score := 0
+	var reasons []string
+	add := func(n int, reason string) {
+		score += n
+		reasons = append(reasons, reason)
+	}
+
+	switch {
+	case ua == "":
+		add(scoreEmptyUserAgent, "empty user agent")
+	case toolUserAgentRE.MatchString(ua):
+		add(scoreToolUserAgent, "tool user agent")
+	}
+
+	if h.Get("Accept") == "" {
+		add(scoreNoAccept, "no accept")
+	}
+	if h.Get("Accept-Language") == "" {
+		add(scoreNoAcceptLanguage, "no accept-language")
+	}
+	if h.Get("Accept-Encoding") == "" {
+		add(scoreNoAcceptEncoding, "no accept-encoding")
+	}
+
+	// NOTE: Modern browsers send the fetch metadata headers over HTTPS,
+	// and never send HTTP/1.0 requests.
+	if browserUserAgentRE.MatchString(ua) {
+		if r.Proto() == "HTTP/1.0" || (r.Std().TLS != nil && h.Get("Sec-Fetch-Mode") == "") {
+			add(scoreInconsistentClient, "inconsistent browser")
+		}
+	}
+
+	return score, reasons
+}
+
+func newRateCounter(window time.Duration) *rateCounter {
+	return &rateCounter{
+		window:   window,
+		start:    time.Now(),
+		current:  map[string]uint32{},
+		previous: map[string]uint32{},
+	}
+}
+
+// hit counts a request of the client, and returns the estimated number
+// of requests in the sliding window.
+func (rc *rateCounter) hit(client string, now time.Time) float64 {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	elapsed := now.Sub(rc.start)
+	if elapsed >= 2*rc.window {
+		rc.previous, rc.current = map[string]uint32{}, map[string]uint32{}
+		rc.start, elapsed = now, 0
+	} else if elapsed >= rc.window {
+		rc.previous, rc.current = rc.current, map[string]uint32{}
+		rc.start = rc.start.Add(rc.window)
+		elapsed -= rc.window
+	}
+
+	n, ok := rc.current[client]
+	// NOTE: The counter is bounded, new clients aren't counted until the
+	// next window if it is full.
+	if ok || len(rc.current) < maxRateEntries {
+		n++
+		rc.current[client] = n
+	}
+
+	weight := 1 - float64(elapsed)/float64(rc.window)
+	return float64(rc.previous[client])*weight + float64(n)
+}
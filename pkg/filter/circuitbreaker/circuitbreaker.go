@@ -22,12 +22,14 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
+	"github.com/megaease/easegress/pkg/util/stringtool"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
@@ -35,6 +37,13 @@ const (
 	// Kind is the kind of CircuitBreaker.
 	Kind                 = "CircuitBreaker"
 	resultShortCircuited = "shortCircuited"
+
+	// instanceIdleTimeout is the time after which the circuit breaker
+	// instance of a server is evicted if no requests are sent to it,
+	// e.g. the server has been removed from the Proxy.
+	instanceIdleTimeout = 10 * time.Minute
+	// maxEvents is the number of recent state transition events kept.
+	maxEvents = 20
 )
 
 var results = []string{resultShortCircuited}
@@ -63,8 +72,28 @@ type (
 	// URLRule defines the circuit breaker rule for a URL pattern
 	URLRule struct {
 		urlrule.URLRule `yaml:",inline"`
-		policy          *Policy
-		cb              *libcb.CircuitBreaker
+		// PerServer creates a circuit breaker instance for each server
+		// selected by the Proxy, instead of one for the whole URL rule.
+		PerServer bool `yaml:"perServer" jsonschema:"omitempty"`
+		policy    *Policy
+		cb        *libcb.CircuitBreaker
+		stat      *urlStat
+
+		mutex     sync.Mutex
+		instances map[string]*instance
+	}
+
+	// instance is the circuit breaker of a server.
+	instance struct {
+		cb       *libcb.CircuitBreaker
+		lastUsed time.Time
+	}
+
+	// urlStat is the statistics of state transitions of a URL rule.
+	urlStat struct {
+		mutex       sync.Mutex
+		transitions map[string]uint64
+		events      []*Event
 	}
 
 	// Spec is the configuration of a circuit breaker
@@ -82,7 +111,29 @@ type (
 
 	// Status is the status of CircuitBreaker.
 	Status struct {
-		Health string `yaml:"health"`
+		URLs []*URLStatus `yaml:"urls"`
+	}
+
+	// URLStatus is the status of the circuit breaker of a URL rule.
+	URLStatus struct {
+		ID    string `yaml:"id"`
+		State string `yaml:"state"`
+		// Instances is the states of circuit breaker instances by server.
+		Instances map[string]string `yaml:"instances,omitempty"`
+		// Transitions is the count of state transitions, the key is
+		// like 'Closed->Open'.
+		Transitions map[string]uint64 `yaml:"transitions"`
+		// Events is the recent state transition events.
+		Events []*Event `yaml:"events"`
+	}
+
+	// Event is a state transition event of a circuit breaker.
+	Event struct {
+		Time     time.Time `yaml:"time"`
+		Instance string    `yaml:"instance,omitempty"`
+		OldState string    `yaml:"oldState"`
+		NewState string    `yaml:"newState"`
+		Reason   string    `yaml:"reason"`
 	}
 )
 
@@ -165,6 +216,89 @@ func (url *URLRule) createCircuitBreaker() {
 	url.cb = libcb.New(policy)
 }
 
+// instance returns the circuit breaker instance of the server, the idle
+// instances are evicted when a new one is created.
+func (url *URLRule) instance(server string, listener func(string) libcb.EventListenerFunc) *libcb.CircuitBreaker {
+	url.mutex.Lock()
+	defer url.mutex.Unlock()
+
+	now := time.Now()
+	if inst := url.instances[server]; inst != nil {
+		inst.lastUsed = now
+		return inst.cb
+	}
+
+	for key, inst := range url.instances {
+		if now.Sub(inst.lastUsed) > instanceIdleTimeout {
+			delete(url.instances, key)
+		}
+	}
+
+	cb := libcb.New(url.buildPolicy())
+	cb.SetStateListener(listener(server))
+	url.instances[server] = &instance{cb: cb, lastUsed: now}
+	return cb
+}
+
+func (url *URLRule) isFailure(statusCode int) bool {
+	if url.policy.CountingNetworkError && context.IsNetworkError(statusCode) {
+		return true
+	}
+	for _, c := range url.policy.FailureStatusCodes {
+		if statusCode == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (url *URLRule) status() *URLStatus {
+	s := &URLStatus{
+		ID:    url.ID(),
+		State: url.cb.State().String(),
+	}
+
+	url.mutex.Lock()
+	if len(url.instances) > 0 {
+		s.Instances = make(map[string]string, len(url.instances))
+		for key, inst := range url.instances {
+			s.Instances[key] = inst.cb.State().String()
+		}
+	}
+	url.mutex.Unlock()
+
+	s.Transitions, s.Events = url.stat.snapshot()
+	return s
+}
+
+func newURLStat() *urlStat {
+	return &urlStat{transitions: make(map[string]uint64)}
+}
+
+func (us *urlStat) record(event *Event) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	us.transitions[event.OldState+"->"+event.NewState]++
+	us.events = append(us.events, event)
+	if len(us.events) > maxEvents {
+		us.events = us.events[len(us.events)-maxEvents:]
+	}
+}
+
+func (us *urlStat) snapshot() (map[string]uint64, []*Event) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	transitions := make(map[string]uint64, len(us.transitions))
+	for k, v := range us.transitions {
+		transitions[k] = v
+	}
+	events := make([]*Event, len(us.events))
+	copy(events, us.events)
+	return transitions, events
+}
+
 // Kind returns the kind of CircuitBreaker.
 func (cb *CircuitBreaker) Kind() string {
 	return Kind
@@ -185,17 +319,39 @@ func (cb *CircuitBreaker) Results() []string {
 	return results
 }
 
-func (cb *CircuitBreaker) setStateListenerForURL(u *URLRule) {
-	u.cb.SetStateListener(func(event *libcb.Event) {
+func (cb *CircuitBreaker) stateListener(u *URLRule, instance string) libcb.EventListenerFunc {
+	return func(event *libcb.Event) {
+		id := u.ID()
+		if instance != "" {
+			id = stringtool.Cat(id, "#", instance)
+		}
 		logger.Infof("state of circuit breaker '%s' on URL(%s) transited from %s to %s at %d, reason: %s",
 			cb.filterSpec.Name(),
-			u.ID(),
+			id,
 			event.OldState,
 			event.NewState,
 			event.Time.UnixNano()/1e6,
 			event.Reason,
 		)
-	})
+
+		u.stat.record(&Event{
+			Time:     event.Time,
+			Instance: instance,
+			OldState: event.OldState,
+			NewState: event.NewState,
+			Reason:   event.Reason,
+		})
+	}
+}
+
+func (cb *CircuitBreaker) setStateListenerForURL(u *URLRule) {
+	u.cb.SetStateListener(cb.stateListener(u, ""))
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for key, inst := range u.instances {
+		inst.cb.SetStateListener(cb.stateListener(u, key))
+	}
 }
 
 func (cb *CircuitBreaker) bindPolicyToURL(u *URLRule) {
@@ -216,6 +372,8 @@ func (cb *CircuitBreaker) createCircuitBreakerForURL(u *URLRule) {
 	u.Init()
 	cb.bindPolicyToURL(u)
 	u.createCircuitBreaker()
+	u.stat = newURLStat()
+	u.instances = make(map[string]*instance)
 	cb.setStateListenerForURL(u)
 }
 
@@ -256,7 +414,7 @@ func (cb *CircuitBreaker) reload(previousGeneration *CircuitBreaker) {
 OuterLoop:
 	for _, url := range cb.spec.URLs {
 		for _, prev := range previousGeneration.spec.URLs {
-			if !url.DeepEqual(&prev.URLRule) {
+			if !url.DeepEqual(&prev.URLRule) || url.PerServer != prev.PerServer {
 				continue
 			}
 			if !isSamePolicy(cb.spec, previousGeneration.spec, url.PolicyRef) {
@@ -266,6 +424,10 @@ OuterLoop:
 			url.Init()
 			cb.bindPolicyToURL(url)
 			url.cb = prev.cb
+			url.stat = prev.stat
+			prev.mutex.Lock()
+			url.instances = prev.instances
+			prev.mutex.Unlock()
 			prev.cb = nil
 			cb.setStateListenerForURL(url)
 			continue OuterLoop
@@ -287,6 +449,10 @@ func (cb *CircuitBreaker) Inherit(filterSpec *httppipeline.FilterSpec, previousG
 }
 
 func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
+	if u.PerServer {
+		return cb.handlePerServer(ctx, u)
+	}
+
 	permitted, stateID := u.cb.AcquirePermission()
 	if !permitted {
 		ctx.AddTag("circuitBreaker: circuit is broken")
@@ -307,19 +473,46 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 	result := ctx.CallNextHandler("")
 	d := time.Since(start)
 
-	statusCode := ctx.Response().StatusCode()
-	hasErr := u.policy.CountingNetworkError && context.IsNetworkError(statusCode)
-	if !hasErr {
-		for _, c := range u.policy.FailureStatusCodes {
-			if statusCode == c {
-				hasErr = true
-				break
-			}
+	u.cb.RecordResult(stateID, u.isFailure(ctx.Response().StatusCode()), d)
+
+	return result
+}
+
+// handlePerServer guards each server selected by the Proxy with its own
+// circuit breaker, the request is rejected by the Proxy if the circuit
+// of the server is broken.
+func (cb *CircuitBreaker) handlePerServer(ctx context.HTTPContext, u *URLRule) string {
+	listener := func(server string) libcb.EventListenerFunc {
+		return cb.stateListener(u, server)
+	}
+
+	guard := func(server string) (func(int), bool) {
+		breaker := u.instance(server, listener)
+		permitted, stateID := breaker.AcquirePermission()
+		if !permitted {
+			ctx.Lock()
+			ctx.AddTag(stringtool.Cat("circuitBreaker: circuit of ", server, " is broken"))
+			ctx.Response().Std().Header().Set("X-EG-Circuit-Breaker", "circurit-is-broken")
+			ctx.Unlock()
+			return nil, false
 		}
+
+		start := time.Now()
+		return func(statusCode int) {
+			// NOTE: The request was not sent, so it is not a result of
+			// the server.
+			if statusCode == 0 {
+				return
+			}
+			breaker.RecordResult(stateID, u.isFailure(statusCode), time.Since(start))
+		}, true
 	}
-	u.cb.RecordResult(stateID, hasErr, d)
 
-	return result
+	guards := ctx.UpstreamGuards()
+	ctx.SetUpstreamGuards(append(guards[:len(guards):len(guards)], guard))
+	defer ctx.SetUpstreamGuards(guards)
+
+	return ctx.CallNextHandler("")
 }
 
 // Handle handles HTTP request
@@ -334,7 +527,11 @@ func (cb *CircuitBreaker) Handle(ctx context.HTTPContext) string {
 
 // Status returns Status generated by Runtime.
 func (cb *CircuitBreaker) Status() interface{} {
-	return nil
+	s := &Status{}
+	for _, u := range cb.spec.URLs {
+		s.URLs = append(s.URLs, u.status())
+	}
+	return s
 }
 
 // Close closes CircuitBreaker.
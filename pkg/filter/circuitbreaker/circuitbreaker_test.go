@@ -103,8 +103,20 @@ urls:
 		t.Error("should not be short circuited")
 	}
 
-	if cb.Status() != nil {
-		t.Error("behavior changed, please update this case")
+	status := cb.Status().(*Status)
+	if len(status.URLs) != 1 || status.URLs[0].State != "Open" {
+		t.Error("state of the circuit breaker should be Open")
+	}
+	// NOTE: The state listener is called asynchronously.
+	for i := 0; i < 100 && len(cb.Status().(*Status).URLs[0].Events) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	status = cb.Status().(*Status)
+	if status.URLs[0].Transitions["Closed->Open"] != 1 {
+		t.Error("transition from Closed to Open should be counted")
+	}
+	if len(status.URLs[0].Events) != 1 || status.URLs[0].Events[0].NewState != "Open" {
+		t.Error("transition from Closed to Open should be recorded")
 	}
 	cb.Description()
 
@@ -121,6 +133,116 @@ urls:
 	}
 }
 
+func TestPerServer(t *testing.T) {
+	const yamlSpec = `
+kind: CircuitBreaker
+name: circuitbreaker
+policies:
+- name: default
+  slowCallRateThreshold: 100
+  failureRateThreshold: 50
+  slidingWindowType: COUNT_BASED
+  slidingWindowSize: 10
+  minimumNumberOfCalls: 5
+  failureStatusCodes: [500]
+defaultPolicyRef: default
+urls:
+- perServer: true
+  url:
+    prefix: /
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	cb := &CircuitBreaker{}
+	cb.Init(spec)
+
+	resp := httptest.NewRecorder()
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodGet
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/"
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return resp
+	}
+
+	// The next handler mocks the Proxy which sends the request to
+	// the server, a header sent by the client has nothing to do with
+	// the instances.
+	server, rejected := "", false
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		var dones []func(int)
+		for _, guard := range ctx.UpstreamGuards() {
+			done, ok := guard(server)
+			if rejected = !ok; rejected {
+				return lastResult
+			}
+			dones = append(dones, done)
+		}
+
+		code := http.StatusOK
+		if server == "http://bad" {
+			code = http.StatusInternalServerError
+		}
+		for _, done := range dones {
+			done(code)
+		}
+		return lastResult
+	}
+
+	server = "http://bad"
+	for i := 0; i < 6; i++ {
+		cb.Handle(ctx)
+	}
+	if !rejected {
+		t.Error("circuit breaker of the bad server should be open")
+	}
+	if len(ctx.UpstreamGuards()) != 0 {
+		t.Error("upstream guards should be restored")
+	}
+
+	server = "http://good"
+	if cb.Handle(ctx); rejected {
+		t.Error("circuit breaker of the good server should be closed")
+	}
+
+	status := cb.Status().(*Status).URLs[0]
+	if status.State != "Closed" {
+		t.Error("circuit breaker of the URL rule should be closed")
+	}
+	if status.Instances["http://bad"] != "Open" || status.Instances["http://good"] != "Closed" {
+		t.Errorf("unexpected states of instances: %v", status.Instances)
+	}
+
+	newCb := &CircuitBreaker{}
+	spec, _ = httppipeline.NewFilterSpec(rawSpec, nil)
+	newCb.Inherit(spec, cb)
+	server = "http://bad"
+	if newCb.Handle(ctx); !rejected {
+		t.Error("instances should be inherited")
+	}
+
+	// Idle instances are evicted when a new one is created.
+	u := newCb.spec.URLs[0]
+	u.instances["http://good"].lastUsed = time.Now().Add(-2 * instanceIdleTimeout)
+	server = "http://new"
+	newCb.Handle(ctx)
+	if _, ok := u.instances["http://good"]; ok {
+		t.Error("idle instance should be evicted")
+	}
+	if len(u.instances) != 2 {
+		t.Errorf("expected 2 instances, got %d", len(u.instances))
+	}
+}
+
 func TestBuildPolicy(t *testing.T) {
 	url := &URLRule{
 		policy: &Policy{
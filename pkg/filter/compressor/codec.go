@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+
+	levelFastest = "fastest"
+	levelDefault = "default"
+	levelBest    = "best"
+
+	// pullSize is the size of the source read at a time by encoders.
+	pullSize = 32 * 1024
+)
+
+var supportedEncodings = []string{encodingBrotli, encodingZstd, encodingGzip}
+
+type (
+	// lockedBuffer is a goroutine-safe buffer, since the zstd encoder
+	// writes blocks in its own goroutine.
+	lockedBuffer struct {
+		mutex sync.Mutex
+		buff  bytes.Buffer
+	}
+
+	// encodingBody compresses the source body when it is read.
+	encodingBody struct {
+		src  io.Reader
+		buff lockedBuffer
+		w    io.WriteCloser
+		eof  bool
+		err  error
+	}
+
+	// decodingBody decompresses the source body when it is read.
+	decodingBody struct {
+		io.Reader
+		src     io.Reader
+		decoder io.Closer
+	}
+)
+
+func newEncoder(encoding, level string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case encodingGzip:
+		l := gzip.DefaultCompression
+		switch level {
+		case levelFastest:
+			l = gzip.BestSpeed
+		case levelBest:
+			l = gzip.BestCompression
+		}
+		return gzip.NewWriterLevel(w, l)
+	case encodingBrotli:
+		l := brotli.DefaultCompression
+		switch level {
+		case levelFastest:
+			l = brotli.BestSpeed
+		case levelBest:
+			l = brotli.BestCompression
+		}
+		return brotli.NewWriterLevel(w, l), nil
+	case encodingZstd:
+		l := zstd.SpeedDefault
+		switch level {
+		case levelFastest:
+			l = zstd.SpeedFastest
+		case levelBest:
+			l = zstd.SpeedBestCompression
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(l), zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case encodingGzip:
+		return gzip.NewReader(r)
+	case encodingBrotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case encodingZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buff.Write(p)
+}
+
+func (b *lockedBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buff.Read(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buff.Len()
+}
+
+func newEncodingBody(src io.Reader, encoding, level string) (*encodingBody, error) {
+	eb := &encodingBody{src: src}
+	w, err := newEncoder(encoding, level, &eb.buff)
+	if err != nil {
+		return nil, err
+	}
+	eb.w = w
+	return eb, nil
+}
+
+// Read reads the compressed data: src -> w -> buff -> p.
+func (eb *encodingBody) Read(p []byte) (int, error) {
+	for eb.buff.Len() == 0 && !eb.eof {
+		eb.pull()
+	}
+
+	if eb.buff.Len() == 0 {
+		if eb.err != nil {
+			return 0, eb.err
+		}
+		return 0, io.EOF
+	}
+	return eb.buff.Read(p)
+}
+
+func (eb *encodingBody) pull() {
+	_, err := io.CopyN(eb.w, eb.src, pullSize)
+	switch err {
+	case nil:
+	case io.EOF:
+		eb.eof = true
+		eb.err = eb.w.Close()
+	default:
+		eb.eof = true
+		eb.err = err
+	}
+}
+
+// Close closes the source, it must be closed to release the connection
+// to the servers.
+func (eb *encodingBody) Close() error {
+	if !eb.eof {
+		eb.eof = true
+		eb.w.Close()
+	}
+	if c, ok := eb.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func newDecodingBody(src io.Reader, encoding string) (*decodingBody, error) {
+	d, err := newDecoder(encoding, src)
+	if err != nil {
+		return nil, err
+	}
+	return &decodingBody{Reader: d, src: src, decoder: d}, nil
+}
+
+// Close closes the decoder and the source.
+func (db *decodingBody) Close() error {
+	db.decoder.Close()
+	if c, ok := db.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
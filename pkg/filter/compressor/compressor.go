@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of Compressor.
+	Kind = "Compressor"
+
+	resultDecompressFailed = "decompressFailed"
+	resultRequestTooLarge  = "requestTooLarge"
+
+	keyETag = "ETag"
+
+	defaultMinLength           = 1024
+	defaultMaxDecompressedSize = 4 << 20
+)
+
+var (
+	results = []string{resultDecompressFailed, resultRequestTooLarge}
+
+	errRequestTooLarge = fmt.Errorf("decompressed request body is too large")
+
+	defaultMIMETypes = []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/x-javascript",
+		"application/xml",
+		"application/wasm",
+		"image/svg+xml",
+	}
+)
+
+func init() {
+	httppipeline.Register(&Compressor{})
+}
+
+type (
+	// Compressor is filter Compressor.
+	Compressor struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		encodings           []string
+		mimeTypes           []string
+		minLength           int64
+		maxDecompressedSize int64
+
+		compressed   map[string]*uint64
+		decompressed uint64
+	}
+
+	// Spec describes the Compressor.
+	Spec struct {
+		// Encodings are the encodings to compress responses in the order
+		// of preference, when the client accepts more than one of them
+		// with the same quality.
+		Encodings []string `yaml:"encodings" jsonschema:"omitempty,uniqueItems=true"`
+		Level     string   `yaml:"level" jsonschema:"omitempty,enum=,enum=fastest,enum=default,enum=best"`
+		MIMETypes []string `yaml:"mimeTypes" jsonschema:"omitempty,uniqueItems=true"`
+		// MinLength is the min length of responses to be compressed,
+		// responses of unknown length are always compressed.
+		MinLength *int64 `yaml:"minLength,omitempty" jsonschema:"omitempty,minimum=0"`
+		// DecompressRequest decompresses the request bodies of supported
+		// encodings before sending them to the servers.
+		DecompressRequest bool `yaml:"decompressRequest" jsonschema:"omitempty"`
+		// MaxDecompressedSize is the max size of the decompressed request
+		// bodies, larger requests are rejected, so a small compressed
+		// body can't expand to exhaust the memory. Default is 4MB.
+		MaxDecompressedSize int64 `yaml:"maxDecompressedSize" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// Status is the status of Compressor.
+	Status struct {
+		Compressed   map[string]uint64 `yaml:"compressed"`
+		Decompressed uint64            `yaml:"decompressed"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, e := range spec.Encodings {
+		if !stringtool.StrInSlice(e, supportedEncodings) {
+			return fmt.Errorf("unsupported encoding %s", e)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of Compressor.
+func (c *Compressor) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of Compressor.
+func (c *Compressor) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Compressor.
+func (c *Compressor) Description() string {
+	return "Compressor compresses and decompresses bodies by gzip, brotli and zstd."
+}
+
+// Results returns the results of Compressor.
+func (c *Compressor) Results() []string {
+	return results
+}
+
+// Init initializes Compressor.
+func (c *Compressor) Init(filterSpec *httppipeline.FilterSpec) {
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	c.reload()
+}
+
+// Inherit inherits previous generation of Compressor.
+func (c *Compressor) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	c.Init(filterSpec)
+}
+
+func (c *Compressor) reload() {
+	c.encodings = c.spec.Encodings
+	if len(c.encodings) == 0 {
+		c.encodings = supportedEncodings
+	}
+	c.mimeTypes = c.spec.MIMETypes
+	if len(c.mimeTypes) == 0 {
+		c.mimeTypes = defaultMIMETypes
+	}
+	c.minLength = defaultMinLength
+	if c.spec.MinLength != nil {
+		c.minLength = *c.spec.MinLength
+	}
+	c.maxDecompressedSize = defaultMaxDecompressedSize
+	if c.spec.MaxDecompressedSize > 0 {
+		c.maxDecompressedSize = c.spec.MaxDecompressedSize
+	}
+
+	c.compressed = make(map[string]*uint64)
+	for _, e := range c.encodings {
+		c.compressed[e] = new(uint64)
+	}
+}
+
+// Handle decompresses the request, calls the following handlers and
+// then compresses or decompresses the response for the client.
+func (c *Compressor) Handle(ctx context.HTTPContext) string {
+	if c.spec.DecompressRequest {
+		if err := c.decompressRequest(ctx); err == errRequestTooLarge {
+			logger.Debugf("%s: decompressed request exceeds %d bytes", c.filterSpec.Name(), c.maxDecompressedSize)
+			ctx.Response().SetStatusCode(http.StatusRequestEntityTooLarge)
+			return ctx.CallNextHandler(resultRequestTooLarge)
+		} else if err != nil {
+			logger.Debugf("%s: decompress request failed: %v", c.filterSpec.Name(), err)
+			ctx.Response().SetStatusCode(http.StatusBadRequest)
+			return ctx.CallNextHandler(resultDecompressFailed)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	c.handleResponse(ctx)
+	return result
+}
+
+func (c *Compressor) decompressRequest(ctx context.HTTPContext) error {
+	r := ctx.Request()
+
+	encoding := contentEncoding(r.Header())
+	if !stringtool.StrInSlice(encoding, supportedEncodings) || r.Body() == nil {
+		return nil
+	}
+
+	body, err := newDecodingBody(r.Body(), encoding)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// NOTE: The body is read before sending to the servers, so the
+	// oversized requests are rejected as a whole.
+	data, err := io.ReadAll(io.LimitReader(body, c.maxDecompressedSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > c.maxDecompressedSize {
+		return errRequestTooLarge
+	}
+
+	r.Header().Del(httpheader.KeyContentEncoding)
+	r.Header().Del(httpheader.KeyContentLength)
+	r.SetBody(bytes.NewReader(data))
+	return nil
+}
+
+func (c *Compressor) handleResponse(ctx context.HTTPContext) {
+	r, w := ctx.Request(), ctx.Response()
+
+	if w.Body() == nil || r.Method() == http.MethodHead {
+		return
+	}
+	switch w.StatusCode() {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return
+	}
+
+	// NOTE: Events must be sent as soon as possible, and no-transform
+	// forbids changing the body.
+	// Reference: https://tools.ietf.org/html/rfc7234#section-5.2.2.4
+	if strings.HasPrefix(w.Header().Get(httpheader.KeyContentType), "text/event-stream") {
+		return
+	}
+	for _, value := range w.Header().GetAll(httpheader.KeyCacheControl) {
+		if strings.Contains(value, "no-transform") {
+			return
+		}
+	}
+
+	accepted := parseAcceptEncoding(r.Header().GetAll(httpheader.KeyAcceptEncoding))
+
+	if encoding := contentEncoding(w.Header()); encoding != "" {
+		if accepted.accepts(encoding) || !stringtool.StrInSlice(encoding, supportedEncodings) {
+			return
+		}
+
+		// The client doesn't support the encoding of the servers.
+		body, err := newDecodingBody(w.Body(), encoding)
+		if err != nil {
+			logger.Warnf("%s: decompress response failed: %v", c.filterSpec.Name(), err)
+			return
+		}
+		w.Header().Del(httpheader.KeyContentEncoding)
+		w.Header().Del(httpheader.KeyContentLength)
+		weakenETag(w.Header())
+		w.SetBody(body)
+		atomic.AddUint64(&c.decompressed, 1)
+		ctx.AddTag("decompressed: " + encoding)
+	}
+
+	// NOTE: The response varies by Accept-Encoding once it could be
+	// compressed, even if it isn't compressed for this request.
+	if !c.matchMIMEType(w.Header().Get(httpheader.KeyContentType)) {
+		return
+	}
+	addVary(w.Header(), httpheader.KeyAcceptEncoding)
+
+	if cl, err := strconv.ParseInt(w.Header().Get(httpheader.KeyContentLength), 10, 64); err == nil && cl < c.minLength {
+		return
+	}
+
+	encoding := accepted.choose(c.encodings)
+	if encoding == "" {
+		return
+	}
+
+	body, err := newEncodingBody(w.Body(), encoding, c.spec.Level)
+	if err != nil {
+		logger.Errorf("BUG: create %s encoder failed: %v", encoding, err)
+		return
+	}
+	w.Header().Set(httpheader.KeyContentEncoding, encoding)
+	w.Header().Del(httpheader.KeyContentLength)
+	weakenETag(w.Header())
+	w.SetBody(body)
+	atomic.AddUint64(c.compressed[encoding], 1)
+	ctx.AddTag("compressed: " + encoding)
+}
+
+func (c *Compressor) matchMIMEType(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType == "" {
+		return false
+	}
+
+	for _, t := range c.mimeTypes {
+		if t == contentType || t == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentEncoding returns the content encoding, it is empty for the
+// identity encoding.
+func contentEncoding(h *httpheader.HTTPHeader) string {
+	encoding := strings.ToLower(strings.TrimSpace(h.Get(httpheader.KeyContentEncoding)))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+func addVary(h *httpheader.HTTPHeader, key string) {
+	for _, value := range h.GetAll(httpheader.KeyVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" || strings.EqualFold(name, key) {
+				return
+			}
+		}
+	}
+	h.Add(httpheader.KeyVary, key)
+}
+
+// weakenETag makes the strong ETag weak, since the body is changed.
+func weakenETag(h *httpheader.HTTPHeader) {
+	etag := h.Get(keyETag)
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(keyETag, "W/"+etag)
+	}
+}
+
+// Status returns status.
+func (c *Compressor) Status() interface{} {
+	s := &Status{
+		Compressed:   make(map[string]uint64),
+		Decompressed: atomic.LoadUint64(&c.decompressed),
+	}
+	for e, n := range c.compressed {
+		s.Compressed[e] = atomic.LoadUint64(n)
+	}
+	return s
+}
+
+// Close closes Compressor.
+func (c *Compressor) Close() {}
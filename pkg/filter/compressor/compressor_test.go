@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	buff := &bytes.Buffer{}
+	w, err := newEncoder(encoding, levelDefault, buff)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.Write(data)
+	w.Close()
+	return buff.Bytes()
+}
+
+func decode(t *testing.T, encoding string, data []byte) []byte {
+	r, err := newDecoder(encoding, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	result, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return result
+}
+
+// do handles a request by the Compressor, the handler plays the role
+// of the following filters.
+func do(c *Compressor, req *http.Request, handler func(ctx context.HTTPContext)) (*httptest.ResponseRecorder, string) {
+	result, w := httppipelinetest.Handle(c, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult == "" {
+			handler(ctx)
+		}
+		return lastResult
+	})
+	return w, result
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	ae := parseAcceptEncoding([]string{"gzip;q=0.8, br", "zstd;q=0"})
+	if ae.choose(supportedEncodings) != encodingBrotli {
+		t.Error("br should be chosen")
+	}
+	if ae.accepts(encodingZstd) {
+		t.Error("zstd should not be accepted")
+	}
+
+	ae = parseAcceptEncoding([]string{"*;q=0.5, gzip"})
+	if ae.choose(supportedEncodings) != encodingGzip {
+		t.Error("gzip should be chosen")
+	}
+	if ae.choose([]string{encodingZstd, encodingBrotli}) != encodingZstd {
+		t.Error("zstd should be chosen by the preference")
+	}
+
+	ae = parseAcceptEncoding(nil)
+	if ae.choose(supportedEncodings) != "" || !ae.accepts(encodingGzip) {
+		t.Error("requests without Accept-Encoding should accept any but choose none")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	data := []byte(strings.Repeat("hello easegress ", 10000))
+	for _, e := range supportedEncodings {
+		body, err := newEncodingBody(bytes.NewReader(data), e, levelFastest)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		compressed, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(compressed) >= len(data) {
+			t.Errorf("%s: data should be compressed", e)
+		}
+		if !bytes.Equal(decode(t, e, compressed), data) {
+			t.Errorf("%s: decompressed data should be the original", e)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	c := httppipelinetest.InitFilter(t, &Compressor{}, `
+kind: Compressor
+name: compressor
+minLength: 10
+`).(*Compressor)
+	data := strings.Repeat("a", 100)
+
+	cases := []struct {
+		accept      string
+		contentType string
+		body        string
+		encoding    string
+	}{
+		{accept: "gzip, br", contentType: "text/plain; charset=utf-8", body: data, encoding: encodingBrotli},
+		{accept: "gzip", contentType: "application/json", body: data, encoding: encodingGzip},
+		{accept: "zstd", contentType: "text/html", body: data, encoding: encodingZstd},
+		{accept: "gzip", contentType: "image/png", body: data},
+		{accept: "gzip", contentType: "text/plain", body: "short"},
+		{contentType: "text/plain", body: data},
+	}
+
+	for i, cs := range cases {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if cs.accept != "" {
+			req.Header.Set("Accept-Encoding", cs.accept)
+		}
+		w, _ := do(c, req, func(ctx context.HTTPContext) {
+			ctx.Response().Header().Set("Content-Type", cs.contentType)
+			ctx.Response().Header().Set("Content-Length", strconv.Itoa(len(cs.body)))
+			ctx.Response().Header().Set("ETag", `"v1"`)
+			ctx.Response().SetBody(strings.NewReader(cs.body))
+		})
+
+		encoding := w.Header().Get("Content-Encoding")
+		if encoding != cs.encoding {
+			t.Errorf("case %d: encoding should be %q, got %q", i, cs.encoding, encoding)
+			continue
+		}
+		if encoding == "" {
+			if w.Body.String() != cs.body {
+				t.Errorf("case %d: body should not be changed", i)
+			}
+			continue
+		}
+		if string(decode(t, encoding, w.Body.Bytes())) != cs.body {
+			t.Errorf("case %d: body should be compressed correctly", i)
+		}
+		if w.Header().Get("ETag") != `W/"v1"` || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("case %d: unexpected headers %v", i, w.Header())
+		}
+	}
+
+	s := c.Status().(*Status)
+	if s.Compressed[encodingBrotli] != 1 || s.Compressed[encodingGzip] != 1 || s.Compressed[encodingZstd] != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestDecompressResponse(t *testing.T) {
+	c := httppipelinetest.InitFilter(t, &Compressor{}, `
+kind: Compressor
+name: compressor
+encodings: [gzip]
+`).(*Compressor)
+	data := strings.Repeat("hello ", 1000)
+	compressed := encode(t, encodingBrotli, []byte(data))
+
+	handler := func(ctx context.HTTPContext) {
+		ctx.Response().Header().Set("Content-Type", "text/plain")
+		ctx.Response().Header().Set("Content-Encoding", encodingBrotli)
+		ctx.Response().SetBody(bytes.NewReader(compressed))
+	}
+
+	// The client accepts the encoding of the servers.
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	w, _ := do(c, req, handler)
+	if w.Header().Get("Content-Encoding") != encodingBrotli || !bytes.Equal(w.Body.Bytes(), compressed) {
+		t.Error("response should not be changed")
+	}
+
+	// The response is decompressed and compressed again.
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w, _ = do(c, req, handler)
+	if w.Header().Get("Content-Encoding") != encodingGzip || string(decode(t, encodingGzip, w.Body.Bytes())) != data {
+		t.Error("response should be compressed by gzip")
+	}
+
+	// The response is decompressed only.
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	w, _ = do(c, req, handler)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != data {
+		t.Error("response should be decompressed")
+	}
+
+	if s := c.Status().(*Status); s.Decompressed != 2 {
+		t.Errorf("2 responses should be decompressed: %+v", s)
+	}
+}
+
+func TestDecompressRequest(t *testing.T) {
+	c := httppipelinetest.InitFilter(t, &Compressor{}, `
+kind: Compressor
+name: compressor
+decompressRequest: true
+`).(*Compressor)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/",
+		bytes.NewReader(encode(t, encodingZstd, []byte("hello"))))
+	req.Header.Set("Content-Encoding", encodingZstd)
+	var body []byte
+	do(c, req, func(ctx context.HTTPContext) {
+		body, _ = io.ReadAll(ctx.Request().Body())
+		if ctx.Request().Header().Get("Content-Encoding") != "" {
+			t.Error("Content-Encoding should be removed")
+		}
+	})
+	if string(body) != "hello" {
+		t.Errorf("request should be decompressed, got %q", body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", encodingGzip)
+	w, result := do(c, req, func(ctx context.HTTPContext) {
+		t.Error("the following handlers should not be called")
+	})
+	if result != resultDecompressFailed || w.Code != http.StatusBadRequest {
+		t.Errorf("request should fail, got %d", w.Code)
+	}
+
+	c = httppipelinetest.InitFilter(t, &Compressor{}, `
+kind: Compressor
+name: compressor
+decompressRequest: true
+maxDecompressedSize: 5
+`).(*Compressor)
+	for _, tc := range []struct {
+		body string
+		code int
+	}{{"hello", http.StatusOK}, {"hello world", http.StatusRequestEntityTooLarge}} {
+		req = httptest.NewRequest(http.MethodPost, "http://example.com/",
+			bytes.NewReader(encode(t, encodingGzip, []byte(tc.body))))
+		req.Header.Set("Content-Encoding", encodingGzip)
+		w, result = do(c, req, func(ctx context.HTTPContext) {
+			if tc.code != http.StatusOK {
+				t.Error("the following handlers should not be called")
+			}
+		})
+		if w.Code != tc.code {
+			t.Errorf("%s: status code should be %d, got %d", tc.body, tc.code, w.Code)
+		}
+		if tc.code == http.StatusRequestEntityTooLarge && result != resultRequestTooLarge {
+			t.Errorf("%s: result should be %s, got %s", tc.body, resultRequestTooLarge, result)
+		}
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestBodyClose(t *testing.T) {
+	src := &closeRecorder{Reader: strings.NewReader("hello")}
+	body, _ := newEncodingBody(src, encodingGzip, levelDefault)
+	body.Close()
+	if !src.closed {
+		t.Error("source should be closed")
+	}
+
+	src = &closeRecorder{Reader: bytes.NewReader(encode(t, encodingGzip, []byte("hello")))}
+	dbody, _ := newDecodingBody(src, encodingGzip)
+	dbody.Close()
+	if !src.closed {
+		t.Error("source should be closed")
+	}
+}
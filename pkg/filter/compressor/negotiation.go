@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compressor
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptEncoding is the qualities of the encodings accepted by the
+// client, the "*" is for all other encodings. It is nil if the request
+// has no Accept-Encoding.
+// Reference: https://tools.ietf.org/html/rfc7231#section-5.3.4
+type acceptEncoding map[string]float64
+
+func parseAcceptEncoding(values []string) acceptEncoding {
+	if len(values) == 0 {
+		return nil
+	}
+
+	ae := acceptEncoding{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			params := strings.Split(item, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			if coding == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+			ae[coding] = q
+		}
+	}
+	return ae
+}
+
+func (ae acceptEncoding) quality(encoding string) float64 {
+	if q, ok := ae[encoding]; ok {
+		return q
+	}
+	if q, ok := ae["*"]; ok {
+		return q
+	}
+	return 0
+}
+
+// accepts reports whether the client accepts the encoding, a request
+// without Accept-Encoding accepts any encoding.
+func (ae acceptEncoding) accepts(encoding string) bool {
+	return ae == nil || ae.quality(encoding) > 0
+}
+
+// choose chooses the encoding of the highest quality, the earlier one
+// is chosen for the same quality. NOTE: Nothing is chosen for requests
+// without Accept-Encoding, since clients may not know the encodings.
+func (ae acceptEncoding) choose(encodings []string) string {
+	chosen, max := "", 0.0
+	for _, e := range encodings {
+		if q := ae.quality(e); q > max {
+			chosen, max = e, q
+		}
+	}
+	return chosen
+}
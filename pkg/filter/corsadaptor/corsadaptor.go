@@ -18,7 +18,10 @@
 package corsadaptor
 
 import (
+	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/rs/cors"
 
@@ -45,19 +48,77 @@ type (
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
 
-		cors *cors.Cors
+		cors   *cors.Cors
+		routes []*route
 	}
 
 	// Spec is describes of CORSAdaptor.
 	Spec struct {
-		AllowedOrigins   []string `yaml:"allowedOrigins" jsonschema:"omitempty"`
-		AllowedMethods   []string `yaml:"allowedMethods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
-		AllowedHeaders   []string `yaml:"allowedHeaders" jsonschema:"omitempty"`
-		AllowCredentials bool     `yaml:"allowCredentials" jsonschema:"omitempty"`
-		ExposedHeaders   []string `yaml:"exposedHeaders" jsonschema:"omitempty"`
+		PolicySpec `yaml:",inline"`
+
+		// Routes override the policy for the requests of matched paths,
+		// the first matched route is used.
+		Routes []*RouteSpec `yaml:"routes" jsonschema:"omitempty"`
+	}
+
+	// PolicySpec describes the CORS policy.
+	PolicySpec struct {
+		AllowedOrigins []string `yaml:"allowedOrigins" jsonschema:"omitempty"`
+		// AllowedOriginRegexps are regular expressions of the origins,
+		// besides AllowedOrigins.
+		AllowedOriginRegexps []string `yaml:"allowedOriginRegexps" jsonschema:"omitempty"`
+		AllowedMethods       []string `yaml:"allowedMethods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		AllowedHeaders       []string `yaml:"allowedHeaders" jsonschema:"omitempty"`
+		AllowCredentials     bool     `yaml:"allowCredentials" jsonschema:"omitempty"`
+		ExposedHeaders       []string `yaml:"exposedHeaders" jsonschema:"omitempty"`
+		// MaxAge is the seconds the results of preflight requests can be
+		// cached by clients.
+		MaxAge int `yaml:"maxAge" jsonschema:"omitempty,minimum=0"`
+		// SupportCORSRequest adds CORS headers to the responses of actual
+		// cross-origin requests besides handling the preflight requests.
+		SupportCORSRequest bool `yaml:"supportCORSRequest" jsonschema:"omitempty"`
+	}
+
+	// RouteSpec describes the policy of requests of matched paths.
+	RouteSpec struct {
+		Path       string `yaml:"path" jsonschema:"omitempty"`
+		PathPrefix string `yaml:"pathPrefix" jsonschema:"omitempty"`
+		PathRegexp string `yaml:"pathRegexp" jsonschema:"omitempty,format=regexp"`
+
+		PolicySpec `yaml:",inline"`
+	}
+
+	route struct {
+		spec       *RouteSpec
+		pathRegexp *regexp.Regexp
+		cors       *cors.Cors
 	}
 )
 
+// Validate validates the PolicySpec.
+func (spec PolicySpec) Validate() error {
+	for _, s := range spec.AllowedOriginRegexps {
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("invalid origin regexp %s: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the RouteSpec.
+func (spec RouteSpec) Validate() error {
+	n := 0
+	for _, s := range []string{spec.Path, spec.PathPrefix, spec.PathRegexp} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("one and only one of path, pathPrefix and pathRegexp should be specified")
+	}
+	return spec.PolicySpec.Validate()
+}
+
 // Kind returns the kind of CORSAdaptor.
 func (a *CORSAdaptor) Kind() string {
 	return Kind
@@ -92,33 +153,130 @@ func (a *CORSAdaptor) Inherit(filterSpec *httppipeline.FilterSpec, previousGener
 }
 
 func (a *CORSAdaptor) reload() {
-	a.cors = cors.New(cors.Options{
-		AllowedOrigins:   a.spec.AllowedOrigins,
-		AllowedMethods:   a.spec.AllowedMethods,
-		AllowedHeaders:   a.spec.AllowedHeaders,
-		AllowCredentials: a.spec.AllowCredentials,
-		ExposedHeaders:   a.spec.ExposedHeaders,
-	})
+	a.cors = newCors(&a.spec.PolicySpec)
+	for _, spec := range a.spec.Routes {
+		r := &route{spec: spec, cors: newCors(&spec.PolicySpec)}
+		if spec.PathRegexp != "" {
+			r.pathRegexp = regexp.MustCompile(spec.PathRegexp)
+		}
+		a.routes = append(a.routes, r)
+	}
+}
+
+func newCors(spec *PolicySpec) *cors.Cors {
+	opts := cors.Options{
+		AllowedOrigins:   spec.AllowedOrigins,
+		AllowedMethods:   spec.AllowedMethods,
+		AllowedHeaders:   spec.AllowedHeaders,
+		AllowCredentials: spec.AllowCredentials,
+		ExposedHeaders:   spec.ExposedHeaders,
+		MaxAge:           spec.MaxAge,
+	}
+
+	// NOTE: AllowedOrigins is ignored by cors if AllowOriginFunc is set,
+	// so the wildcards are matched here too.
+	if len(spec.AllowedOriginRegexps) > 0 {
+		var res []*regexp.Regexp
+		for _, s := range spec.AllowedOriginRegexps {
+			res = append(res, regexp.MustCompile(s))
+		}
+		origins := spec.AllowedOrigins
+		opts.AllowOriginFunc = func(origin string) bool {
+			for _, o := range origins {
+				if matchOrigin(o, origin) {
+					return true
+				}
+			}
+			for _, re := range res {
+				if re.MatchString(origin) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return cors.New(opts)
+}
+
+// matchOrigin matches the origin with the pattern, which may contain one
+// wildcard (*) like http://*.example.com.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	idx := strings.IndexByte(pattern, '*')
+	if idx < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:idx], pattern[idx+1:]
+	return len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func (r *route) match(path string) bool {
+	switch {
+	case r.spec.Path != "":
+		return r.spec.Path == path
+	case r.spec.PathPrefix != "":
+		return strings.HasPrefix(path, r.spec.PathPrefix)
+	default:
+		return r.pathRegexp.MatchString(path)
+	}
+}
+
+// policy returns the policy of the path.
+func (a *CORSAdaptor) policy(path string) (*cors.Cors, *PolicySpec) {
+	for _, r := range a.routes {
+		if r.match(path) {
+			return r.cors, &r.spec.PolicySpec
+		}
+	}
+	return a.cors, &a.spec.PolicySpec
 }
 
 // Handle handles simple cross-origin requests or directs.
 func (a *CORSAdaptor) Handle(ctx context.HTTPContext) string {
-	result := a.handle(ctx)
-	return ctx.CallNextHandler(result)
+	r := ctx.Request()
+	c, spec := a.policy(r.Path())
+
+	result := a.handle(ctx, c)
+	result = ctx.CallNextHandler(result)
+
+	if result != resultPreflighted && spec.SupportCORSRequest {
+		a.handleActualRequest(ctx, c)
+	}
+	return result
 }
 
-func (a *CORSAdaptor) handle(ctx context.HTTPContext) string {
+func (a *CORSAdaptor) handle(ctx context.HTTPContext, c *cors.Cors) string {
 	r := ctx.Request()
 	w := ctx.Response()
 	method := r.Method()
 	headerAllowMethod := r.Header().Get("Access-Control-Request-Method")
 	if method == http.MethodOptions && headerAllowMethod != "" {
-		a.cors.HandlerFunc(w.Std(), r.Std())
+		c.HandlerFunc(w.Std(), r.Std())
 		return resultPreflighted
 	}
 	return ""
 }
 
+// handleActualRequest adds the CORS headers to the response after the
+// following filters, the CORS headers from the backends are replaced, so
+// the policy here is the only one.
+func (a *CORSAdaptor) handleActualRequest(ctx context.HTTPContext, c *cors.Cors) {
+	h := ctx.Response().Header()
+	var keys []string
+	h.VisitAll(func(key, value string) {
+		if strings.HasPrefix(key, "Access-Control-") {
+			keys = append(keys, key)
+		}
+	})
+	for _, key := range keys {
+		h.Del(key)
+	}
+
+	c.HandlerFunc(ctx.Response().Std(), ctx.Request().Std())
+}
+
 // Status return status.
 func (a *CORSAdaptor) Status() interface{} {
 	return nil
@@ -19,14 +19,25 @@ package corsadaptor
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
 
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
 func TestCORSAdaptor(t *testing.T) {
 	const yamlSpec = `
 kind: CORSAdaptor
@@ -75,3 +86,151 @@ name: cors
 		t.Error("request should not be preflighted")
 	}
 }
+
+// do handles the request, the backend is called if the pipeline goes on,
+// and it responds with the header.
+func do(a *CORSAdaptor, req *http.Request, backendHeader http.Header) (string, http.Header, bool) {
+	called := false
+	result, w := httppipelinetest.Handle(a, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		called = true
+		ctx.Response().Header().AddFromStd(backendHeader)
+		return ""
+	})
+	return result, w.Header(), called
+}
+
+func preflight(path, origin, method string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	return req
+}
+
+func TestPolicies(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &CORSAdaptor{}, `
+kind: CORSAdaptor
+name: cors
+allowedOrigins: ["https://*.example.com"]
+allowedOriginRegexps: ["^https://app[0-9]+\\.example\\.org$"]
+allowedMethods: [GET, PUT]
+maxAge: 600
+routes:
+- pathPrefix: /public/
+  allowedOrigins: ["*"]
+- pathRegexp: ^/private/
+  allowedOrigins: [https://admin.example.com]
+  allowCredentials: true
+`).(*CORSAdaptor)
+
+	cases := []struct {
+		path   string
+		origin string
+		method string
+		allow  string
+	}{
+		{"/api", "https://www.example.com", http.MethodPut, "https://www.example.com"},
+		{"/api", "https://EXAMPLE.com", http.MethodPut, ""},
+		{"/api", "https://app12.example.org", http.MethodGet, "https://app12.example.org"},
+		{"/api", "https://appx.example.org", http.MethodGet, ""},
+		{"/api", "https://www.example.com", http.MethodDelete, ""},
+		{"/public/a", "https://foo.com", http.MethodGet, "*"},
+		{"/private/a", "https://www.example.com", http.MethodGet, ""},
+		{"/private/a", "https://admin.example.com", http.MethodGet, "https://admin.example.com"},
+	}
+	for _, c := range cases {
+		result, header, called := do(a, preflight(c.path, c.origin, c.method), nil)
+		if result != resultPreflighted || called {
+			t.Errorf("%s from %s should be preflighted without calling the backend", c.path, c.origin)
+		}
+		if got := header.Get("Access-Control-Allow-Origin"); got != c.allow {
+			t.Errorf("%s from %s: allowed origin should be %q, got %q", c.path, c.origin, c.allow, got)
+		}
+	}
+
+	_, header, _ := do(a, preflight("/api", "https://www.example.com", http.MethodGet), nil)
+	if header.Get("Access-Control-Max-Age") != "600" {
+		t.Error("max age should be 600")
+	}
+	_, header, _ = do(a, preflight("/private/a", "https://admin.example.com", http.MethodGet), nil)
+	if header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("credentials should be allowed")
+	}
+}
+
+func TestActualRequest(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &CORSAdaptor{}, `
+kind: CORSAdaptor
+name: cors
+allowedOrigins: ["https://*.example.com"]
+exposedHeaders: [X-Request-Id]
+supportCORSRequest: true
+routes:
+- path: /legacy
+  allowedOrigins: ["https://*.example.com"]
+`).(*CORSAdaptor)
+
+	backendHeader := http.Header{"Access-Control-Allow-Origin": {"*"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("Origin", "https://www.example.com")
+	result, header, called := do(a, req, backendHeader)
+	if result != "" || !called {
+		t.Error("actual request should be sent to the backend")
+	}
+	if header.Get("Access-Control-Allow-Origin") != "https://www.example.com" {
+		t.Errorf("allowed origin should be set, got %v", header)
+	}
+	if header.Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Error("exposed headers should be set")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	_, header, _ = do(a, req, backendHeader)
+	if _, ok := header["Access-Control-Allow-Origin"]; ok {
+		t.Error("cors headers of the backend should be removed for disallowed origins")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/legacy", nil)
+	req.Header.Set("Origin", "https://www.example.com")
+	_, header, _ = do(a, req, backendHeader)
+	if header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("route without supportCORSRequest should keep headers of the backend")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []string{`
+kind: CORSAdaptor
+name: cors
+allowedOriginRegexps: ["("]
+`, `
+kind: CORSAdaptor
+name: cors
+routes:
+- path: /a
+  pathPrefix: /a
+`, `
+kind: CORSAdaptor
+name: cors
+routes:
+- allowedOrigins: ["*"]
+`, `
+kind: CORSAdaptor
+name: cors
+routes:
+- path: /a
+  allowedOriginRegexps: ["("]
+`}
+
+	for i, c := range cases {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(c), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csrf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of CSRF.
+	Kind = "CSRF"
+
+	resultInvalidToken = "invalidToken"
+
+	modeDoubleSubmit = "doubleSubmit"
+	modeSynchronizer = "synchronizer"
+
+	defaultCookieName        = "csrf_token"
+	defaultSessionCookieName = "csrf_session"
+	defaultHeaderName        = "X-CSRF-Token"
+	defaultFormField         = "csrf_token"
+	defaultTokenTTL          = 12 * time.Hour
+
+	// maxFormSize limits the body read to look for the token in forms.
+	maxFormSize = 1 << 20
+
+	evictInterval = time.Minute
+)
+
+var (
+	results = []string{resultInvalidToken}
+
+	defaultSafeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace}
+
+	sameSiteModes = map[string]http.SameSite{
+		"":       http.SameSiteLaxMode,
+		"lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"none":   http.SameSiteNoneMode,
+	}
+)
+
+func init() {
+	httppipeline.Register(&CSRF{})
+}
+
+type (
+	// CSRF protects the backends from cross-site request forgery, by the
+	// double-submit cookie pattern or the synchronizer token pattern.
+	CSRF struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		tokenTTL time.Duration
+		signer   *signer
+		sessions *sessionStore
+		done     chan struct{}
+
+		issued   uint64
+		verified uint64
+		rejected uint64
+	}
+
+	// Spec describes the CSRF.
+	Spec struct {
+		// Mode is doubleSubmit (default) or synchronizer.
+		Mode string `yaml:"mode,omitempty" jsonschema:"omitempty,enum=doubleSubmit,enum=synchronizer"`
+		// SafeMethods are the methods not checked, default is GET, HEAD,
+		// OPTIONS and TRACE.
+		SafeMethods []string `yaml:"safeMethods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		TokenTTL    string   `yaml:"tokenTTL" jsonschema:"omitempty,format=duration"`
+		// Secret signs the tokens, it must be the same for all instances
+		// serving the same clients.
+		Secret string `yaml:"secret" jsonschema:"omitempty"`
+
+		HeaderName string `yaml:"headerName" jsonschema:"omitempty"`
+		FormField  string `yaml:"formField" jsonschema:"omitempty"`
+
+		Cookie *CookieSpec `yaml:"cookie,omitempty" jsonschema:"omitempty"`
+	}
+
+	// CookieSpec describes the attributes of the cookie.
+	CookieSpec struct {
+		Name     string `yaml:"name" jsonschema:"omitempty"`
+		Domain   string `yaml:"domain" jsonschema:"omitempty"`
+		Path     string `yaml:"path" jsonschema:"omitempty"`
+		Secure   bool   `yaml:"secure" jsonschema:"omitempty"`
+		SameSite string `yaml:"sameSite,omitempty" jsonschema:"omitempty,enum=strict,enum=lax,enum=none"`
+	}
+
+	// Status is the status of CSRF.
+	Status struct {
+		Issued   uint64 `yaml:"issued"`
+		Verified uint64 `yaml:"verified"`
+		Rejected uint64 `yaml:"rejected"`
+		Sessions int    `yaml:"sessions,omitempty"`
+	}
+)
+
+// Validate validates the CookieSpec.
+func (spec CookieSpec) Validate() error {
+	if spec.SameSite == "none" && !spec.Secure {
+		return fmt.Errorf("cookies of sameSite none must be secure")
+	}
+	return nil
+}
+
+// Kind returns the kind of CSRF.
+func (c *CSRF) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of CSRF.
+func (c *CSRF) DefaultSpec() interface{} {
+	return &Spec{Mode: modeDoubleSubmit}
+}
+
+// Description returns the description of CSRF.
+func (c *CSRF) Description() string {
+	return "CSRF protects backends from cross-site request forgery."
+}
+
+// Results returns the results of CSRF.
+func (c *CSRF) Results() []string {
+	return results
+}
+
+// Init initializes CSRF.
+func (c *CSRF) Init(filterSpec *httppipeline.FilterSpec) {
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	c.reload(nil)
+}
+
+// Inherit inherits previous generation of CSRF.
+func (c *CSRF) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	prev := previousGeneration.(*CSRF)
+	previousGeneration.Close()
+
+	c.filterSpec, c.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	// NOTE: Keep the sessions and the generated secret, so the tokens
+	// issued by the previous generation are still valid.
+	c.reload(prev)
+}
+
+func (c *CSRF) reload(prev *CSRF) {
+	if c.spec.Mode == "" {
+		c.spec.Mode = modeDoubleSubmit
+	}
+	if len(c.spec.SafeMethods) == 0 {
+		c.spec.SafeMethods = defaultSafeMethods
+	}
+	if c.spec.HeaderName == "" {
+		c.spec.HeaderName = defaultHeaderName
+	}
+	if c.spec.FormField == "" {
+		c.spec.FormField = defaultFormField
+	}
+	if c.spec.Cookie == nil {
+		c.spec.Cookie = &CookieSpec{}
+	}
+	if c.spec.Cookie.Name == "" {
+		if c.spec.Mode == modeSynchronizer {
+			c.spec.Cookie.Name = defaultSessionCookieName
+		} else {
+			c.spec.Cookie.Name = defaultCookieName
+		}
+	}
+	if c.spec.Cookie.Path == "" {
+		c.spec.Cookie.Path = "/"
+	}
+
+	c.tokenTTL = defaultTokenTTL
+	if c.spec.TokenTTL != "" {
+		if d, err := time.ParseDuration(c.spec.TokenTTL); err == nil && d > 0 {
+			c.tokenTTL = d
+		}
+	}
+
+	if prev != nil && prev.spec.Secret == c.spec.Secret {
+		c.signer = prev.signer
+	} else {
+		c.signer = newSigner(c.spec.Secret)
+	}
+
+	if c.spec.Mode == modeSynchronizer {
+		if prev != nil && prev.sessions != nil {
+			c.sessions = prev.sessions
+		} else {
+			c.sessions = newSessionStore()
+		}
+	}
+
+	c.done = make(chan struct{})
+	if c.sessions != nil {
+		go c.evictSessions()
+	}
+}
+
+func (c *CSRF) evictSessions() {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.sessions.evict(now)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Handle protects the request from cross-site request forgery.
+func (c *CSRF) Handle(ctx context.HTTPContext) string {
+	result := c.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (c *CSRF) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	now := time.Now()
+
+	if stringtool.StrInSlice(r.Method(), c.spec.SafeMethods) {
+		c.ensureToken(ctx, now)
+		return ""
+	}
+
+	expected := c.expectedToken(r, now)
+	submitted := c.submittedToken(r)
+	if expected == "" || submitted == "" || !tokenEqual(expected, submitted) {
+		atomic.AddUint64(&c.rejected, 1)
+		ctx.Response().SetStatusCode(http.StatusForbidden)
+		ctx.AddTag("csrf: invalid token")
+		return resultInvalidToken
+	}
+
+	atomic.AddUint64(&c.verified, 1)
+	return ""
+}
+
+// expectedToken returns the valid token bound to the client, it is empty
+// if there isn't one.
+func (c *CSRF) expectedToken(r context.HTTPRequest, now time.Time) string {
+	cookie, err := r.Cookie(c.spec.Cookie.Name)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+
+	if c.spec.Mode == modeSynchronizer {
+		return c.sessions.get(cookie.Value, now)
+	}
+
+	if !c.signer.verify(cookie.Value, now) {
+		return ""
+	}
+	return cookie.Value
+}
+
+// submittedToken returns the token in the header, or in the field of the
+// urlencoded form.
+func (c *CSRF) submittedToken(r context.HTTPRequest) string {
+	if token := r.Header().Get(c.spec.HeaderName); token != "" {
+		return token
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header().Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	// NOTE: The request body must be set back for the following filters.
+	body := r.Body()
+	buff, err := io.ReadAll(io.LimitReader(body, maxFormSize))
+	r.SetBody(io.MultiReader(bytes.NewReader(buff), body))
+	if err != nil {
+		return ""
+	}
+	form, err := url.ParseQuery(string(buff))
+	if err != nil {
+		return ""
+	}
+	return form.Get(c.spec.FormField)
+}
+
+// ensureToken issues a new token if the client has no valid token, the
+// token is always returned in the response header for the clients, the
+// token is also readable in the cookie in doubleSubmit mode.
+func (c *CSRF) ensureToken(ctx context.HTTPContext, now time.Time) {
+	r, w := ctx.Request(), ctx.Response()
+
+	token := c.expectedToken(r, now)
+	if token == "" {
+		atomic.AddUint64(&c.issued, 1)
+
+		expires := now.Add(c.tokenTTL)
+		token = c.signer.newToken(expires)
+		value := token
+		if c.spec.Mode == modeSynchronizer {
+			value = randomString(tokenRandomSize)
+			c.sessions.put(value, token, expires)
+		}
+
+		w.SetCookie(&http.Cookie{
+			Name:     c.spec.Cookie.Name,
+			Value:    value,
+			Domain:   c.spec.Cookie.Domain,
+			Path:     c.spec.Cookie.Path,
+			Expires:  expires,
+			MaxAge:   int(c.tokenTTL / time.Second),
+			Secure:   c.spec.Cookie.Secure,
+			HttpOnly: c.spec.Mode == modeSynchronizer,
+			SameSite: sameSiteModes[strings.ToLower(c.spec.Cookie.SameSite)],
+		})
+	}
+
+	w.Header().Set(c.spec.HeaderName, token)
+}
+
+// Status returns status.
+func (c *CSRF) Status() interface{} {
+	s := &Status{
+		Issued:   atomic.LoadUint64(&c.issued),
+		Verified: atomic.LoadUint64(&c.verified),
+		Rejected: atomic.LoadUint64(&c.rejected),
+	}
+	if c.sessions != nil {
+		s.Sessions = c.sessions.len()
+	}
+	return s
+}
+
+// Close closes CSRF.
+func (c *CSRF) Close() {
+	close(c.done)
+}
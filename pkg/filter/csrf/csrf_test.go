@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csrf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func do(c *CSRF, req *http.Request) (string, *httptest.ResponseRecorder, string) {
+	var body string
+	result, w := httppipelinetest.Handle(c, req, func(ctx context.HTTPContext, lastResult string) string {
+		buf, _ := io.ReadAll(ctx.Request().Body())
+		body = string(buf)
+		return lastResult
+	})
+	return result, w, body
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestDoubleSubmit(t *testing.T) {
+	c := httppipelinetest.InitFilter(t, &CSRF{}, `
+kind: CSRF
+name: csrf
+tokenTTL: 1h
+cookie:
+  sameSite: strict
+  secure: true
+`).(*CSRF)
+
+	result, w, _ := do(c, httptest.NewRequest(http.MethodGet, "/", nil))
+	if result != "" {
+		t.Fatal("safe request should pass")
+	}
+	cookie := responseCookie(w, defaultCookieName)
+	if cookie == nil || cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 3600 {
+		t.Fatalf("unexpected cookie %+v", cookie)
+	}
+	token := cookie.Value
+	if w.Header().Get(defaultHeaderName) != token {
+		t.Error("token should be returned in header")
+	}
+
+	// NOTE: The valid token isn't issued again.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: token})
+	_, w, _ = do(c, req)
+	if responseCookie(w, defaultCookieName) != nil || w.Header().Get(defaultHeaderName) != token {
+		t.Error("valid token should be kept")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: token})
+	req.Header.Set(defaultHeaderName, token)
+	if result, _, _ := do(c, req); result != "" {
+		t.Error("request with matched token should pass")
+	}
+
+	form := url.Values{"csrf_token": {token}, "a": {"b"}}.Encode()
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: token})
+	result, _, body := do(c, req)
+	if result != "" {
+		t.Error("request with token in form should pass")
+	}
+	if body != form {
+		t.Error("body should be kept for the following filters")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: token})
+	if result, w, _ := do(c, req); result != resultInvalidToken || w.Code != http.StatusForbidden {
+		t.Error("request without submitted token should be rejected")
+	}
+
+	// NOTE: A forged cookie has an invalid signature even if it matches
+	// the submitted token.
+	forged := newSigner("another").newToken(time.Now().Add(time.Hour))
+	req = httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: forged})
+	req.Header.Set(defaultHeaderName, forged)
+	if result, _, _ := do(c, req); result != resultInvalidToken {
+		t.Error("forged token should be rejected")
+	}
+
+	expired := c.signer.newToken(time.Now().Add(-time.Second))
+	req = httptest.NewRequest(http.MethodPut, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: expired})
+	req.Header.Set(defaultHeaderName, expired)
+	if result, _, _ := do(c, req); result != resultInvalidToken {
+		t.Error("expired token should be rejected")
+	}
+
+	s := c.Status().(*Status)
+	if s.Issued != 1 || s.Verified != 2 || s.Rejected != 3 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestSynchronizer(t *testing.T) {
+	c := httppipelinetest.InitFilter(t, &CSRF{}, `
+kind: CSRF
+name: csrf
+mode: synchronizer
+safeMethods: [GET]
+headerName: X-XSRF-Token
+`).(*CSRF)
+
+	_, w, _ := do(c, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookie := responseCookie(w, defaultSessionCookieName)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("session cookie should be http only, got %+v", cookie)
+	}
+	token := w.Header().Get("X-XSRF-Token")
+	if token == "" || token == cookie.Value {
+		t.Fatal("token should differ from the session id")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-XSRF-Token", token)
+	if result, _, _ := do(c, req); result != "" {
+		t.Error("request with the token of the session should pass")
+	}
+
+	// NOTE: The session id can't be used as the token.
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-XSRF-Token", cookie.Value)
+	if result, _, _ := do(c, req); result != resultInvalidToken {
+		t.Error("session id should not be accepted as the token")
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-XSRF-Token", token+"x")
+	if result, _, _ := do(c, req); result != resultInvalidToken {
+		t.Error("HEAD isn't safe and should be checked")
+	}
+
+	// NOTE: Sessions are inherited by the next generation.
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: CSRF
+name: csrf
+mode: synchronizer
+safeMethods: [GET]
+headerName: X-XSRF-Token
+`), &rawSpec)
+	spec, _ := httppipeline.NewFilterSpec(rawSpec, nil)
+	next := &CSRF{}
+	next.Inherit(spec, c)
+	defer next.Close()
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("X-XSRF-Token", token)
+	if result, _, _ := do(next, req); result != "" {
+		t.Error("session should be valid after inheriting")
+	}
+	if next.Status().(*Status).Sessions != 1 {
+		t.Error("there should be 1 session")
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	ss := newSessionStore()
+	now := time.Now()
+	ss.put("a", "ta", now.Add(time.Minute))
+	ss.put("b", "tb", now.Add(-time.Minute))
+	if ss.get("a", now) != "ta" || ss.get("b", now) != "" {
+		t.Error("expired session should not be returned")
+	}
+
+	ss.put("c", "tc", now.Add(-time.Minute))
+	ss.evict(now)
+	if ss.len() != 1 {
+		t.Errorf("expired sessions should be evicted, got %d", ss.len())
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: CSRF
+name: csrf
+cookie:
+  sameSite: none
+`), &rawSpec)
+	if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+		t.Error("sameSite none without secure should be invalid")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenRandomSize = 32
+	maxSessions     = 100000
+)
+
+type (
+	// signer signs the tokens, so forged or expired tokens are rejected
+	// without any state.
+	signer struct {
+		key []byte
+	}
+
+	// sessionStore stores the tokens of the synchronizer token pattern,
+	// which are indexed by the session IDs.
+	sessionStore struct {
+		mutex    sync.Mutex
+		sessions map[string]*session
+	}
+
+	session struct {
+		token   string
+		expires time.Time
+	}
+)
+
+func randomString(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("read random bytes failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func newSigner(secret string) *signer {
+	if secret == "" {
+		secret = randomString(tokenRandomSize)
+	}
+	return &signer{key: []byte(secret)}
+}
+
+func (s *signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newToken creates a token like {random}.{expires}.{signature}.
+func (s *signer) newToken(expires time.Time) string {
+	payload := randomString(tokenRandomSize) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// verify checks the signature and the expiry of the token.
+func (s *signer) verify(token string, now time.Time) bool {
+	idx := strings.LastIndexByte(token, '.')
+	if idx < 0 {
+		return false
+	}
+	payload, signature := token[:idx], token[idx+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return false
+	}
+
+	fields := strings.Split(payload, ".")
+	if len(fields) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.Unix() < expires
+}
+
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: map[string]*session{}}
+}
+
+func (ss *sessionStore) get(id string, now time.Time) string {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	s := ss.sessions[id]
+	if s == nil {
+		return ""
+	}
+	if !now.Before(s.expires) {
+		delete(ss.sessions, id)
+		return ""
+	}
+	return s.token
+}
+
+func (ss *sessionStore) put(id, token string, expires time.Time) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	// NOTE: Sessions are created by safe requests from anyone, so the
+	// store is bounded, the expired sessions are evicted first.
+	if len(ss.sessions) >= maxSessions {
+		ss.evictLocked(time.Now())
+	}
+	for id := range ss.sessions {
+		if len(ss.sessions) < maxSessions {
+			break
+		}
+		delete(ss.sessions, id)
+	}
+
+	ss.sessions[id] = &session{token: token, expires: expires}
+}
+
+func (ss *sessionStore) evict(now time.Time) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.evictLocked(now)
+}
+
+func (ss *sessionStore) evictLocked(now time.Time) {
+	for id, s := range ss.sessions {
+		if !now.Before(s.expires) {
+			delete(ss.sessions, id)
+		}
+	}
+}
+
+func (ss *sessionStore) len() int {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return len(ss.sessions)
+}
@@ -0,0 +1,504 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package datamasker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of DataMasker.
+	Kind = "DataMasker"
+
+	targetRequest   = "request"
+	targetResponse  = "response"
+	targetAccessLog = "accessLog"
+
+	defaultMaxBodySize = 1 << 20
+)
+
+var (
+	results = []string{}
+
+	allTargets = []string{targetRequest, targetResponse, targetAccessLog}
+)
+
+func init() {
+	httppipeline.Register(&DataMasker{})
+}
+
+type (
+	// DataMasker masks the sensitive data in the requests, the responses
+	// and the access logs, so it doesn't reach the backends, the clients
+	// or the log files.
+	DataMasker struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		maxBodySize   int64
+		requestRules  []*rule
+		responseRules []*rule
+		logRules      []*rule
+
+		requests  uint64
+		responses uint64
+		logs      uint64
+		skipped   uint64
+	}
+
+	// Spec describes the DataMasker.
+	Spec struct {
+		Rules []*RuleSpec `yaml:"rules" jsonschema:"required,minItems=1"`
+		// HashSalt is the key to hash the values, the hashes of the same
+		// value are the same, so they are still able to be correlated.
+		HashSalt string `yaml:"hashSalt" jsonschema:"omitempty"`
+		// MaxBodySize is the maximum size of the body to mask, larger
+		// bodies are passed without masking, default is 1MB.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// RuleSpec describes what to mask and how.
+	RuleSpec struct {
+		// Targets are request, response and accessLog, default is all.
+		Targets     []string `yaml:"targets" jsonschema:"omitempty,uniqueItems=true"`
+		Headers     []string `yaml:"headers" jsonschema:"omitempty,uniqueItems=true"`
+		QueryParams []string `yaml:"queryParams" jsonschema:"omitempty,uniqueItems=true"`
+		JSONPaths   []string `yaml:"jsonPaths" jsonschema:"omitempty,uniqueItems=true"`
+		Patterns    []string `yaml:"patterns" jsonschema:"omitempty,uniqueItems=true"`
+		// Builtins are the built-in patterns: creditCard, email, jwt and
+		// bearerToken.
+		Builtins []string `yaml:"builtins" jsonschema:"omitempty,uniqueItems=true"`
+
+		// Action is redact (default), hash or partial.
+		Action      string `yaml:"action,omitempty" jsonschema:"omitempty,enum=redact,enum=hash,enum=partial"`
+		Replacement string `yaml:"replacement" jsonschema:"omitempty"`
+		// ShowLast is the number of trailing characters kept by partial,
+		// default is 4.
+		ShowLast int `yaml:"showLast" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// Status is the status of DataMasker.
+	Status struct {
+		Requests  uint64 `yaml:"requests"`
+		Responses uint64 `yaml:"responses"`
+		Logs      uint64 `yaml:"logs"`
+		// Skipped is the number of bodies passed without masking, because
+		// they are too large or compressed.
+		Skipped uint64 `yaml:"skipped"`
+	}
+
+	rule struct {
+		headers     []string
+		queryParams []string
+		paths       []jsonPath
+		patterns    []*pattern
+		// logQueries are the patterns of the query parameters in the
+		// request URI of the access log.
+		logQueries []*regexp.Regexp
+		mask       maskFunc
+	}
+)
+
+// Validate validates the RuleSpec.
+func (spec RuleSpec) Validate() error {
+	for _, t := range spec.Targets {
+		if !stringtool.StrInSlice(t, allTargets) {
+			return fmt.Errorf("unknown target %s", t)
+		}
+	}
+	if len(spec.Headers)+len(spec.QueryParams)+len(spec.JSONPaths)+len(spec.Patterns)+len(spec.Builtins) == 0 {
+		return fmt.Errorf("none of headers, queryParams, jsonPaths, patterns and builtins is specified")
+	}
+	for _, p := range spec.JSONPaths {
+		if _, err := parseJSONPath(p); err != nil {
+			return err
+		}
+	}
+	for _, p := range spec.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+	}
+	for _, b := range spec.Builtins {
+		if builtinPatterns[b] == nil {
+			return fmt.Errorf("unknown builtin pattern %s", b)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of DataMasker.
+func (dm *DataMasker) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of DataMasker.
+func (dm *DataMasker) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of DataMasker.
+func (dm *DataMasker) Description() string {
+	return "DataMasker masks sensitive data in requests, responses and access logs."
+}
+
+// Results returns the results of DataMasker.
+func (dm *DataMasker) Results() []string {
+	return results
+}
+
+// Init initializes DataMasker.
+func (dm *DataMasker) Init(filterSpec *httppipeline.FilterSpec) {
+	dm.filterSpec, dm.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	dm.reload()
+}
+
+// Inherit inherits previous generation of DataMasker.
+func (dm *DataMasker) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	dm.Init(filterSpec)
+}
+
+func (dm *DataMasker) reload() {
+	dm.maxBodySize = dm.spec.MaxBodySize
+	if dm.maxBodySize == 0 {
+		dm.maxBodySize = defaultMaxBodySize
+	}
+
+	for _, spec := range dm.spec.Rules {
+		r := newRule(spec, dm.spec.HashSalt)
+
+		targets := spec.Targets
+		if len(targets) == 0 {
+			targets = allTargets
+		}
+		if stringtool.StrInSlice(targetRequest, targets) {
+			dm.requestRules = append(dm.requestRules, r)
+		}
+		if stringtool.StrInSlice(targetResponse, targets) {
+			dm.responseRules = append(dm.responseRules, r)
+		}
+		if stringtool.StrInSlice(targetAccessLog, targets) {
+			dm.logRules = append(dm.logRules, r)
+		}
+	}
+}
+
+func newRule(spec *RuleSpec, salt string) *rule {
+	r := &rule{
+		headers:     spec.Headers,
+		queryParams: spec.QueryParams,
+		mask:        newMaskFunc(spec, salt),
+	}
+
+	for _, s := range spec.JSONPaths {
+		p, err := parseJSONPath(s)
+		if err != nil {
+			logger.Errorf("BUG: parse json path %s failed: %v", s, err)
+			continue
+		}
+		r.paths = append(r.paths, p)
+	}
+	for _, s := range spec.Patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			logger.Errorf("BUG: compile pattern %s failed: %v", s, err)
+			continue
+		}
+		r.patterns = append(r.patterns, &pattern{re: re})
+	}
+	for _, b := range spec.Builtins {
+		if p := builtinPatterns[b]; p != nil {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	for _, name := range spec.QueryParams {
+		re := regexp.MustCompile(`([?&]` + regexp.QuoteMeta(url.QueryEscape(name)) + `=)([^&\s]*)`)
+		r.logQueries = append(r.logQueries, re)
+	}
+
+	return r
+}
+
+// Handle masks the request, the response and the access log.
+func (dm *DataMasker) Handle(ctx context.HTTPContext) string {
+	if len(dm.logRules) > 0 {
+		ctx.AddLogMasker(dm.maskLog)
+	}
+
+	if len(dm.requestRules) > 0 && dm.maskRequest(ctx) {
+		atomic.AddUint64(&dm.requests, 1)
+	}
+
+	result := ctx.CallNextHandler("")
+
+	if len(dm.responseRules) > 0 && dm.maskResponse(ctx) {
+		atomic.AddUint64(&dm.responses, 1)
+	}
+
+	return result
+}
+
+func (dm *DataMasker) maskRequest(ctx context.HTTPContext) bool {
+	r := ctx.Request()
+
+	masked := false
+	for _, rule := range dm.requestRules {
+		if rule.maskHeaders(r.Header()) {
+			masked = true
+		}
+		if len(rule.queryParams) == 0 {
+			continue
+		}
+		if query, ok := rule.maskQuery(r.Query()); ok {
+			r.SetQuery(query)
+			masked = true
+		}
+	}
+
+	if dm.maskBody(ctx, dm.requestRules, r.Header(), r.Body(), r.SetBody) {
+		masked = true
+	}
+	return masked
+}
+
+func (dm *DataMasker) maskResponse(ctx context.HTTPContext) bool {
+	r, w := ctx.Request(), ctx.Response()
+
+	masked := false
+	for _, rule := range dm.responseRules {
+		if rule.maskHeaders(w.Header()) {
+			masked = true
+		}
+	}
+
+	if w.Body() == nil || r.Method() == http.MethodHead {
+		return masked
+	}
+	switch w.StatusCode() {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return masked
+	}
+	// NOTE: Events must be sent as soon as possible.
+	if strings.HasPrefix(w.Header().Get(httpheader.KeyContentType), "text/event-stream") {
+		return masked
+	}
+
+	if dm.maskBody(ctx, dm.responseRules, w.Header(), w.Body(), w.SetBody) {
+		masked = true
+	}
+	return masked
+}
+
+func (dm *DataMasker) maskLog(log string) string {
+	masked := log
+	for _, rule := range dm.logRules {
+		for _, re := range rule.logQueries {
+			masked = re.ReplaceAllStringFunc(masked, func(m string) string {
+				sub := re.FindStringSubmatch(m)
+				value, err := url.QueryUnescape(sub[2])
+				if err != nil {
+					value = sub[2]
+				}
+				return sub[1] + url.QueryEscape(rule.mask(value))
+			})
+		}
+		for _, p := range rule.patterns {
+			masked = p.replace(masked, rule.mask)
+		}
+	}
+
+	if masked != log {
+		atomic.AddUint64(&dm.logs, 1)
+	}
+	return masked
+}
+
+// maskBody masks the body by the JSON paths and the patterns of the rules,
+// the body is set back whether it is masked or not.
+func (dm *DataMasker) maskBody(ctx context.HTTPContext, rules []*rule, h *httpheader.HTTPHeader,
+	body io.Reader, setBody func(io.Reader)) bool {
+	if body == nil {
+		return false
+	}
+
+	hasPaths, hasPatterns := false, false
+	for _, rule := range rules {
+		hasPaths = hasPaths || len(rule.paths) > 0
+		hasPatterns = hasPatterns || len(rule.patterns) > 0
+	}
+	if !hasPaths && !hasPatterns {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(h.Get(httpheader.KeyContentType))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if !isJSON && !isText(mediaType) {
+		return false
+	}
+	if !isJSON && !hasPatterns {
+		return false
+	}
+
+	if encoding := h.Get(httpheader.KeyContentEncoding); encoding != "" && encoding != "identity" {
+		atomic.AddUint64(&dm.skipped, 1)
+		ctx.AddTag(stringtool.Cat("dataMasker: skip body of encoding ", encoding))
+		return false
+	}
+
+	buff, err := io.ReadAll(io.LimitReader(body, dm.maxBodySize+1))
+	if err != nil || int64(len(buff)) > dm.maxBodySize {
+		setBody(io.MultiReader(bytes.NewReader(buff), body))
+		atomic.AddUint64(&dm.skipped, 1)
+		ctx.AddTag("dataMasker: skip large body")
+		return false
+	}
+
+	masked := buff
+	if isJSON && hasPaths {
+		masked = maskJSON(masked, rules)
+	}
+	s := string(masked)
+	for _, rule := range rules {
+		for _, p := range rule.patterns {
+			s = p.replace(s, rule.mask)
+		}
+	}
+	masked = []byte(s)
+
+	setBody(bytes.NewReader(masked))
+	if bytes.Equal(masked, buff) {
+		return false
+	}
+	h.Del(httpheader.KeyContentLength)
+	return true
+}
+
+// maskJSON masks the values selected by the JSON paths, the body is
+// returned unchanged if it isn't valid JSON or nothing is masked.
+func maskJSON(body []byte, rules []*rule) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return body
+	}
+
+	n := 0
+	for _, rule := range rules {
+		for _, p := range rule.paths {
+			n += p.apply(doc, rule.mask)
+		}
+	}
+	if n == 0 {
+		return body
+	}
+
+	buff := &bytes.Buffer{}
+	encoder := json.NewEncoder(buff)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(buff.Bytes(), []byte("\n"))
+}
+
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/xml",
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "application/javascript":
+		return true
+	}
+	return false
+}
+
+func (r *rule) maskHeaders(h *httpheader.HTTPHeader) bool {
+	masked := false
+	for _, name := range r.headers {
+		values := h.GetAll(name)
+		if len(values) == 0 {
+			continue
+		}
+		h.Del(name)
+		for _, v := range values {
+			h.Add(name, r.mask(v))
+		}
+		masked = true
+	}
+	return masked
+}
+
+// maskQuery masks the query parameters, the other parameters are kept
+// as they are.
+func (r *rule) maskQuery(query string) (string, bool) {
+	if query == "" {
+		return query, false
+	}
+
+	pairs := strings.Split(query, "&")
+	masked := false
+	for i, pair := range pairs {
+		key, value := pair, ""
+		if idx := strings.IndexByte(pair, '='); idx >= 0 {
+			key, value = pair[:idx], pair[idx+1:]
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil || !stringtool.StrInSlice(name, r.queryParams) {
+			continue
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		pairs[i] = key + "=" + url.QueryEscape(r.mask(value))
+		masked = true
+	}
+
+	return strings.Join(pairs, "&"), masked
+}
+
+// Status returns status.
+func (dm *DataMasker) Status() interface{} {
+	return &Status{
+		Requests:  atomic.LoadUint64(&dm.requests),
+		Responses: atomic.LoadUint64(&dm.responses),
+		Logs:      atomic.LoadUint64(&dm.logs),
+		Skipped:   atomic.LoadUint64(&dm.skipped),
+	}
+}
+
+// Close closes DataMasker.
+func (dm *DataMasker) Close() {}
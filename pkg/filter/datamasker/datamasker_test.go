@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package datamasker
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParseJSONPath(t *testing.T) {
+	for _, s := range []string{"$.a", "$.a.b", "$['a b'].c", "$.a[0]", "$.a[*].b", "$.*", "$..token"} {
+		if _, err := parseJSONPath(s); err != nil {
+			t.Errorf("%s should be valid, got %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "a.b", "$", "$.", "$..", "$.a[", "$.a[-1]", "$.a[x]", "$a"} {
+		if _, err := parseJSONPath(s); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+	}
+}
+
+func TestMaskFunc(t *testing.T) {
+	redact := newMaskFunc(&RuleSpec{}, "")
+	if redact("secret") != defaultReplacement {
+		t.Errorf("value should be redacted")
+	}
+
+	partial := newMaskFunc(&RuleSpec{Action: actionPartial}, "")
+	if got := partial("4111111111111111"); got != "************1111" {
+		t.Errorf("only the last 4 characters should be kept, got %s", got)
+	}
+	if got := partial("abc"); got != "**c" {
+		t.Errorf("at least half of short values should be masked, got %s", got)
+	}
+
+	hash := newMaskFunc(&RuleSpec{Action: actionHash}, "salt")
+	other := newMaskFunc(&RuleSpec{Action: actionHash}, "pepper")
+	if hash("a") != hash("a") || hash("a") == hash("b") || hash("a") == other("a") {
+		t.Errorf("hashes should be decided by the value and the salt")
+	}
+	if !strings.HasPrefix(hash("a"), hashPrefix) {
+		t.Errorf("hash should be prefixed by %s", hashPrefix)
+	}
+
+	if !luhnValid("4111 1111 1111 1111") || luhnValid("4111 1111 1111 1112") {
+		t.Errorf("luhn check is wrong")
+	}
+}
+
+func TestRuleSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  RuleSpec
+		valid bool
+	}{
+		{RuleSpec{}, false},
+		{RuleSpec{Headers: []string{"Authorization"}}, true},
+		{RuleSpec{Headers: []string{"Authorization"}, Targets: []string{"upstream"}}, false},
+		{RuleSpec{JSONPaths: []string{"password"}}, false},
+		{RuleSpec{Patterns: []string{"("}}, false},
+		{RuleSpec{Builtins: []string{"ssn"}}, false},
+		{RuleSpec{Builtins: []string{"creditCard", "email"}}, true},
+	}
+	for i, c := range cases {
+		if err := c.spec.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: valid should be %v, got %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestDataMasker(t *testing.T) {
+	dm := httppipelinetest.InitFilter(t, &DataMasker{}, `
+kind: DataMasker
+name: dm
+rules:
+- targets: [request]
+  headers: [X-Api-Key]
+  jsonPaths: [$.card.number, "$..password"]
+  action: partial
+- targets: [request, accessLog]
+  queryParams: [token]
+- targets: [response]
+  jsonPaths: ["$.users[*].email"]
+  action: hash
+  headers: [X-Internal-Token]
+- builtins: [creditCard]
+`).(*DataMasker)
+	defer dm.Close()
+
+	body := `{"card":{"number":"4111111111111111","cvv":123},"user":{"name":"bob","password":"p@ssw0rd!"},"note":"paid by 5500 0000 0000 0004"}`
+	req := httptest.NewRequest(http.MethodPost, "/pay?token=abc%2Fdef&page=1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "1000")
+	req.Header.Set("X-Api-Key", "key-0123456789")
+
+	got := map[string]string{}
+	ctx, w := httppipelinetest.NewContext(req, func(ctx context.HTTPContext, lastResult string) string {
+		r := ctx.Request()
+		buf, _ := io.ReadAll(r.Body())
+		got["body"] = string(buf)
+		got["query"] = r.Query()
+		got["key"] = r.Header().Get("X-Api-Key")
+		got["length"] = r.Header().Get("Content-Length")
+
+		ctx.Response().Header().Set("Content-Type", "application/json; charset=utf-8")
+		ctx.Response().Header().Set("X-Internal-Token", "internal")
+		ctx.Response().SetBody(strings.NewReader(`{"users":[{"email":"a@b.com"},{"email":"c@d.com"}],"card":"4111-1111-1111-1111"}`))
+		return lastResult
+	})
+
+	dm.Handle(ctx)
+	ctx.AddTag("card: 4111111111111111")
+	ctx.Finish()
+
+	if got["key"] != "**********6789" {
+		t.Errorf("header should be partially masked, got %s", got["key"])
+	}
+	if got["query"] != "token=%2A%2A%2A%2A&page=1" {
+		t.Errorf("query should be masked, got %s", got["query"])
+	}
+	if got["length"] != "" {
+		t.Errorf("content length should be removed")
+	}
+	for _, s := range []string{"4111111111111111", "p@ssw0rd!", "5500 0000 0000 0004"} {
+		if strings.Contains(got["body"], s) {
+			t.Errorf("%s should be masked in the request body: %s", s, got["body"])
+		}
+	}
+	if !strings.Contains(got["body"], `"number":"************1111"`) || !strings.Contains(got["body"], `"cvv":123`) {
+		t.Errorf("unexpected request body: %s", got["body"])
+	}
+
+	respBody := w.Body.String()
+	if strings.Contains(respBody, "a@b.com") || strings.Contains(respBody, "4111-1111-1111-1111") {
+		t.Errorf("response body should be masked: %s", respBody)
+	}
+	if !strings.Contains(respBody, `"email":"sha256:`) {
+		t.Errorf("emails should be hashed: %s", respBody)
+	}
+	if w.Header().Get("X-Internal-Token") != newMaskFunc(&RuleSpec{Action: actionHash}, "")("internal") {
+		t.Errorf("response header should be hashed, got %s", w.Header().Get("X-Internal-Token"))
+	}
+
+	log := ctx.Log()
+	if strings.Contains(log, "abc") || strings.Contains(log, "4111111111111111") {
+		t.Errorf("access log should be masked: %s", log)
+	}
+	if !strings.Contains(log, "token=%2A%2A%2A%2A&page=1") {
+		t.Errorf("query of the access log should be masked: %s", log)
+	}
+
+	s := dm.Status().(*Status)
+	if s.Requests != 1 || s.Responses != 1 || s.Logs == 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestDataMaskerSkip(t *testing.T) {
+	dm := httppipelinetest.InitFilter(t, &DataMasker{}, `
+kind: DataMasker
+name: dm
+maxBodySize: 16
+rules:
+- builtins: [email]
+`).(*DataMasker)
+	defer dm.Close()
+
+	do := func(contentType, encoding, body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		var got string
+		httppipelinetest.Handle(dm, req, func(ctx context.HTTPContext, lastResult string) string {
+			buf, _ := io.ReadAll(ctx.Request().Body())
+			got = string(buf)
+			return lastResult
+		})
+		return got
+	}
+
+	if got := do("text/plain", "", "to a@b.com"); got != "to ****" {
+		t.Errorf("text body should be masked, got %s", got)
+	}
+	large := "to a@b.com " + strings.Repeat("x", 16)
+	if got := do("text/plain", "", large); got != large {
+		t.Errorf("large body should be kept, got %s", got)
+	}
+	if got := do("text/plain", "gzip", "to a@b.com"); got != "to a@b.com" {
+		t.Errorf("compressed body should be kept, got %s", got)
+	}
+	if got := do("application/octet-stream", "", "to a@b.com"); got != "to a@b.com" {
+		t.Errorf("binary body should be kept, got %s", got)
+	}
+	if s := dm.Status().(*Status); s.Skipped != 2 {
+		t.Errorf("2 bodies should be skipped, got %d", s.Skipped)
+	}
+}
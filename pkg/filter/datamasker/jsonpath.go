@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package datamasker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	stepKey = iota
+	stepIndex
+	stepWildcard
+	stepDescendant
+)
+
+type (
+	// jsonPath is a simplified JSON path, it supports $.a.b, $['a'],
+	// $.a[0], $.a[*], $.* and $..a.
+	jsonPath []step
+
+	step struct {
+		kind  int
+		key   string
+		index int
+	}
+)
+
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+
+	var p jsonPath
+	s := path[1:]
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, ".."):
+			name, rest := splitName(s[2:])
+			if name == "" {
+				return nil, fmt.Errorf("json path %q: missing name after ..", path)
+			}
+			p = append(p, step{kind: stepDescendant, key: name})
+			s = rest
+
+		case s[0] == '.':
+			name, rest := splitName(s[1:])
+			switch name {
+			case "":
+				return nil, fmt.Errorf("json path %q: missing name after .", path)
+			case "*":
+				p = append(p, step{kind: stepWildcard})
+			default:
+				p = append(p, step{kind: stepKey, key: name})
+			}
+			s = rest
+
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: missing ]", path)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+
+			if inner == "*" {
+				p = append(p, step{kind: stepWildcard})
+				continue
+			}
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p = append(p, step{kind: stepKey, key: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("json path %q: invalid index %q", path, inner)
+			}
+			p = append(p, step{kind: stepIndex, index: index})
+
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", path, s)
+		}
+	}
+
+	if len(p) == 0 {
+		return nil, fmt.Errorf("json path %q selects the whole document", path)
+	}
+	return p, nil
+}
+
+func splitName(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// apply masks the values selected by the path, it returns the number of
+// masked values.
+func (p jsonPath) apply(v interface{}, mask maskFunc) int {
+	if len(p) == 0 {
+		return 0
+	}
+	st, rest := p[0], p[1:]
+
+	n := 0
+	visit := func(child interface{}, matched bool, set func(interface{})) {
+		if matched {
+			if len(rest) == 0 {
+				set(maskValue(child, mask))
+				n++
+				// NOTE: The whole child is masked, so the descendants
+				// aren't visited again.
+				return
+			}
+			n += rest.apply(child, mask)
+		}
+		if st.kind == stepDescendant {
+			n += p.apply(child, mask)
+		}
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			k := k
+			matched := st.kind == stepWildcard || ((st.kind == stepKey || st.kind == stepDescendant) && (st.key == k || st.key == "*"))
+			visit(child, matched, func(nv interface{}) { x[k] = nv })
+		}
+	case []interface{}:
+		for i, child := range x {
+			i := i
+			matched := st.kind == stepWildcard || (st.kind == stepIndex && st.index == i)
+			visit(child, matched, func(nv interface{}) { x[i] = nv })
+		}
+	}
+
+	return n
+}
+
+// maskValue masks the scalar values, the objects and arrays are masked
+// recursively, null and booleans are kept.
+func maskValue(v interface{}, mask maskFunc) interface{} {
+	switch x := v.(type) {
+	case string:
+		return mask(x)
+	case json.Number:
+		return mask(x.String())
+	case map[string]interface{}:
+		for k, child := range x {
+			x[k] = maskValue(child, mask)
+		}
+		return x
+	case []interface{}:
+		for i, child := range x {
+			x[i] = maskValue(child, mask)
+		}
+		return x
+	default:
+		return v
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package datamasker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+const (
+	actionRedact  = "redact"
+	actionHash    = "hash"
+	actionPartial = "partial"
+
+	defaultReplacement = "****"
+	defaultShowLast    = 4
+	hashPrefix         = "sha256:"
+)
+
+type (
+	maskFunc func(s string) string
+
+	// pattern is a regular expression of the sensitive data, the matches
+	// are masked only if they pass the check.
+	pattern struct {
+		re    *regexp.Regexp
+		check func(s string) bool
+	}
+)
+
+// builtinPatterns are the patterns of the common sensitive data.
+var builtinPatterns = map[string]*pattern{
+	"creditCard": {
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		check: luhnValid,
+	},
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	"jwt": {
+		re: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+	},
+	"bearerToken": {
+		re: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	},
+}
+
+// luhnValid checks the digits by the Luhn algorithm, to reduce the false
+// positives of the credit card numbers.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+func newMaskFunc(spec *RuleSpec, salt string) maskFunc {
+	switch spec.Action {
+	case actionHash:
+		return func(s string) string {
+			mac := hmac.New(sha256.New, []byte(salt))
+			mac.Write([]byte(s))
+			return hashPrefix + hex.EncodeToString(mac.Sum(nil))
+		}
+
+	case actionPartial:
+		showLast := spec.ShowLast
+		if showLast == 0 {
+			showLast = defaultShowLast
+		}
+		return func(s string) string {
+			runes := []rune(s)
+			// NOTE: Mask at least half of the value, or short values
+			// would be leaked entirely.
+			keep := showLast
+			if keep > len(runes)/2 {
+				keep = len(runes) / 2
+			}
+			return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+		}
+
+	default:
+		replacement := spec.Replacement
+		if replacement == "" {
+			replacement = defaultReplacement
+		}
+		return func(s string) string {
+			return replacement
+		}
+	}
+}
+
+// replace masks all matches of the pattern in s.
+func (p *pattern) replace(s string, mask maskFunc) string {
+	return p.re.ReplaceAllStringFunc(s, func(m string) string {
+		if p.check != nil && !p.check(m) {
+			return m
+		}
+		return mask(m)
+	})
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dubboproxy
+
+import (
+	"bufio"
+	"bytes"
+	stdcontext "context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+
+	"github.com/megaease/easegress/pkg/util/rpcbridge"
+)
+
+const (
+	// genericMethod is the method of the generic invocation, its
+	// parameters are the method name, the parameter types and the
+	// parameter values.
+	genericMethod = "$invoke"
+
+	// serialHessian2 is the serialization id of hessian2.
+	serialHessian2 = 2
+
+	maxPacketSize = 8 << 20
+)
+
+type (
+	// invocation is a generic invocation of a Dubbo method.
+	invocation struct {
+		service hessian.Service
+		types   []string
+		args    []hessian.Object
+	}
+
+	client struct {
+		pool    *rpcbridge.ConnPool
+		counter int64
+	}
+)
+
+// invoke calls the method, it returns the exception message if the
+// method throws an exception, and returns error if the call fails.
+func (c *client) invoke(ctx stdcontext.Context, inv *invocation) (interface{}, string, error) {
+	id := atomic.AddInt64(&c.counter, 1)
+
+	service := inv.service
+	method := service.Method
+	service.Method = genericMethod
+	header := hessian.DubboHeader{
+		SerialID: serialHessian2,
+		Type:     hessian.PackageRequest_TwoWay,
+		ID:       id,
+	}
+	body := hessian.NewRequest(
+		[]interface{}{method, inv.types, inv.args},
+		map[string]string{"generic": "true"},
+	)
+
+	codec := hessian.NewHessianCodec(nil)
+	packet, err := codec.Write(service, header, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("encode request failed: %v", err)
+	}
+
+	conn, err := c.pool.Get(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	result, exception, err := c.roundTrip(conn, id, packet)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("%s: %v", conn.Server, err)
+	}
+	c.pool.Put(conn)
+	return result, exception, nil
+}
+
+func (c *client) roundTrip(conn *rpcbridge.Conn, id int64, packet []byte) (interface{}, string, error) {
+	if _, err := conn.Write(packet); err != nil {
+		return nil, "", err
+	}
+
+	for {
+		packet, err := readPacket(conn)
+		if err != nil {
+			return nil, "", err
+		}
+
+		codec := hessian.NewHessianCodec(bufio.NewReaderSize(bytes.NewReader(packet), len(packet)))
+		header := hessian.DubboHeader{}
+		if err = codec.ReadHeader(&header); err != nil {
+			return nil, "", fmt.Errorf("decode response failed: %v", err)
+		}
+
+		// NOTE: The providers send heartbeats on the idle connections,
+		// which must be replied, or the connections are closed.
+		if header.Type&hessian.PackageHeartbeat != 0 {
+			if header.Type&hessian.PackageRequest == 0 {
+				continue
+			}
+			reply, err := codec.Write(hessian.Service{}, hessian.DubboHeader{
+				SerialID:       serialHessian2,
+				Type:           hessian.PackageHeartbeat,
+				ID:             header.ID,
+				ResponseStatus: hessian.Response_OK,
+			}, nil)
+			if err != nil {
+				return nil, "", err
+			}
+			if _, err = conn.Write(reply); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		if header.Type&hessian.PackageResponse == 0 || header.ID != id {
+			continue
+		}
+
+		var result interface{}
+		resp := &hessian.Response{RspObj: &result}
+		if header.ResponseStatus != hessian.Response_OK {
+			codec.ReadBody(resp)
+			return nil, "", fmt.Errorf("response status %d: %v", header.ResponseStatus, resp.Exception)
+		}
+		if err = codec.ReadBody(resp); err != nil {
+			return nil, "", fmt.Errorf("decode response failed: %v", err)
+		}
+		if resp.Exception != nil {
+			return nil, resp.Exception.Error(), nil
+		}
+		return result, "", nil
+	}
+}
+
+// readPacket reads a whole packet, as the codec requires the packet to
+// be buffered before decoding.
+func readPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, hessian.HEADER_LENGTH)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != hessian.MAGIC_HIGH || header[1] != hessian.MAGIC_LOW {
+		return nil, fmt.Errorf("invalid magic number")
+	}
+
+	n := binary.BigEndian.Uint32(header[12:])
+	if n > maxPacketSize {
+		return nil, fmt.Errorf("packet size %d exceeds %d", n, maxPacketSize)
+	}
+	packet := make([]byte, hessian.HEADER_LENGTH+int(n))
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[hessian.HEADER_LENGTH:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// convert converts the value from the request to the Java type, the
+// values of the primitive types could be strings.
+func convert(javaType string, v interface{}) (hessian.Object, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	s, isString := v.(string)
+	if n, ok := v.(json.Number); ok {
+		s, isString = n.String(), true
+	}
+
+	switch javaType {
+	case "boolean", "java.lang.Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if isString {
+			return strconv.ParseBool(s)
+		}
+	case "byte", "java.lang.Byte", "short", "java.lang.Short", "int", "java.lang.Integer":
+		if isString {
+			i, err := strconv.ParseInt(s, 10, 32)
+			return int32(i), err
+		}
+	case "long", "java.lang.Long":
+		if isString {
+			return strconv.ParseInt(s, 10, 64)
+		}
+	case "float", "java.lang.Float", "double", "java.lang.Double":
+		if isString {
+			return strconv.ParseFloat(s, 64)
+		}
+	case "char", "java.lang.Character", "java.lang.String":
+		if isString {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%v is not a string", v)
+	default:
+		// NOTE: The generic invocation converts the maps to the POJOs
+		// at the provider side.
+		return fromJSON(v), nil
+	}
+
+	return nil, fmt.Errorf("%v is not a %s", v, javaType)
+}
+
+// fromJSON converts the JSON numbers to int64 or float64.
+func fromJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = fromJSON(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = fromJSON(value)
+		}
+		return l
+	}
+	return v
+}
+
+// toJSON converts the result to the value which could be encoded as
+// JSON, the "class" entries of the generalized POJOs are removed.
+func toJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k := fmt.Sprint(key)
+			if _, ok := value.(string); ok && k == "class" {
+				continue
+			}
+			m[k] = toJSON(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = toJSON(value)
+		}
+		return l
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
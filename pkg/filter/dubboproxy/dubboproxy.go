@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dubboproxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/rpcbridge"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of DubboProxy.
+	Kind = "DubboProxy"
+
+	resultNoRoute        = "noRoute"
+	resultInvalidRequest = "invalidRequest"
+	resultServerError    = "serverError"
+)
+
+var results = []string{resultNoRoute, resultInvalidRequest, resultServerError}
+
+func init() {
+	httppipeline.Register(&DubboProxy{})
+}
+
+type (
+	// DubboProxy translates RESTful HTTP requests into Dubbo calls.
+	DubboProxy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout time.Duration
+		pool    *rpcbridge.ConnPool
+		client  *client
+
+		calls      uint64
+		exceptions uint64
+		failures   uint64
+	}
+
+	// Spec is the spec of DubboProxy.
+	Spec struct {
+		// Servers are the addresses of the Dubbo providers in the form
+		// of host:port, they are chosen by round-robin.
+		Servers      []string  `yaml:"servers" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Timeout      string    `yaml:"timeout" jsonschema:"required,format=duration"`
+		MaxIdleConns int       `yaml:"maxIdleConns" jsonschema:"required,minimum=1"`
+		Methods      []*Method `yaml:"methods" jsonschema:"required,minItems=1"`
+	}
+
+	// Method maps a RESTful endpoint to a Dubbo method, which is called
+	// by generic invocation, so no Java classes are required.
+	Method struct {
+		Method string `yaml:"method" jsonschema:"required,format=httpmethod"`
+		// Path is the path template, e.g. /v1/users/{id}.
+		Path      string   `yaml:"path" jsonschema:"required,pattern=^/"`
+		Interface string   `yaml:"interface" jsonschema:"required"`
+		Version   string   `yaml:"version" jsonschema:"omitempty"`
+		Group     string   `yaml:"group" jsonschema:"omitempty"`
+		RPCMethod string   `yaml:"rpcMethod" jsonschema:"required"`
+		Params    []*Param `yaml:"params" jsonschema:"omitempty"`
+
+		endpoint *rpcbridge.Endpoint
+	}
+
+	// Param is a parameter of the Dubbo method.
+	Param struct {
+		// Type is the Java type of the parameter, e.g. java.lang.String,
+		// int, or com.example.User.
+		Type string `yaml:"type" jsonschema:"required"`
+		// From is the source of the value, one of path.NAME, query.NAME,
+		// header.NAME, body and body.FIELD.
+		From string `yaml:"from" jsonschema:"required"`
+	}
+
+	// Status is the status of DubboProxy.
+	Status struct {
+		Calls      uint64 `yaml:"calls"`
+		Exceptions uint64 `yaml:"exceptions"`
+		Failures   uint64 `yaml:"failures"`
+	}
+)
+
+// Validate validates Param.
+func (p Param) Validate() error {
+	return rpcbridge.ValidateSource(p.From)
+}
+
+// Kind returns the kind of DubboProxy.
+func (dp *DubboProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DubboProxy.
+func (dp *DubboProxy) DefaultSpec() interface{} {
+	return &Spec{
+		Timeout:      "3s",
+		MaxIdleConns: 16,
+	}
+}
+
+// Description returns the description of DubboProxy.
+func (dp *DubboProxy) Description() string {
+	return "DubboProxy translates RESTful HTTP requests into Dubbo calls."
+}
+
+// Results returns the results of DubboProxy.
+func (dp *DubboProxy) Results() []string {
+	return results
+}
+
+// Init initializes DubboProxy.
+func (dp *DubboProxy) Init(filterSpec *httppipeline.FilterSpec) {
+	dp.filterSpec, dp.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	dp.reload()
+}
+
+// Inherit inherits previous generation of DubboProxy.
+func (dp *DubboProxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	dp.Init(filterSpec)
+}
+
+func (dp *DubboProxy) reload() {
+	var err error
+	dp.timeout, err = time.ParseDuration(dp.spec.Timeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", dp.spec.Timeout, err)
+		dp.timeout = 3 * time.Second
+	}
+
+	for _, m := range dp.spec.Methods {
+		m.endpoint = rpcbridge.NewEndpoint(m.Method, m.Path)
+	}
+
+	dp.pool = rpcbridge.NewConnPool(dp.spec.Servers, dp.spec.MaxIdleConns)
+	dp.client = &client{pool: dp.pool}
+}
+
+// Handle handles HTTP request.
+func (dp *DubboProxy) Handle(ctx context.HTTPContext) string {
+	result := dp.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (dp *DubboProxy) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	var method *Method
+	var vars map[string]string
+	for _, m := range dp.spec.Methods {
+		if v, ok := m.endpoint.Match(r.Method(), r.Path()); ok {
+			method, vars = m, v
+			break
+		}
+	}
+	if method == nil {
+		rpcbridge.WriteError(ctx, http.StatusNotFound, "no method matches the request")
+		return resultNoRoute
+	}
+
+	req, err := rpcbridge.NewRequest(ctx, vars)
+	if err != nil {
+		rpcbridge.WriteError(ctx, http.StatusBadRequest, err.Error())
+		return resultInvalidRequest
+	}
+
+	inv := &invocation{
+		service: hessian.Service{
+			Path:      method.Interface,
+			Interface: method.Interface,
+			Group:     method.Group,
+			Version:   method.Version,
+			Method:    method.RPCMethod,
+			Timeout:   dp.timeout,
+		},
+		types: make([]string, 0, len(method.Params)),
+		args:  make([]hessian.Object, 0, len(method.Params)),
+	}
+	for _, p := range method.Params {
+		v, err := convert(p.Type, req.Value(p.From))
+		if err != nil {
+			rpcbridge.WriteError(ctx, http.StatusBadRequest, fmt.Sprintf("invalid %s: %v", p.From, err))
+			return resultInvalidRequest
+		}
+		inv.types = append(inv.types, p.Type)
+		inv.args = append(inv.args, v)
+	}
+
+	timeoutCtx, cancel := stdcontext.WithTimeout(ctx, dp.timeout)
+	defer cancel()
+
+	atomic.AddUint64(&dp.calls, 1)
+	result, exception, err := dp.client.invoke(timeoutCtx, inv)
+	if err != nil {
+		atomic.AddUint64(&dp.failures, 1)
+		ctx.AddTag(stringtool.Cat(dp.filterSpec.Name(), ": call dubbo failed: ", err.Error()))
+		rpcbridge.WriteError(ctx, http.StatusBadGateway, err.Error())
+		return resultServerError
+	}
+	if exception != "" {
+		atomic.AddUint64(&dp.exceptions, 1)
+		rpcbridge.WriteError(ctx, http.StatusInternalServerError, exception)
+		return resultServerError
+	}
+
+	if err = rpcbridge.WriteJSON(ctx, http.StatusOK, toJSON(result)); err != nil {
+		rpcbridge.WriteError(ctx, http.StatusInternalServerError, fmt.Sprintf("encode result failed: %v", err))
+		return resultServerError
+	}
+	return ""
+}
+
+// Status returns Status generated by DubboProxy.
+func (dp *DubboProxy) Status() interface{} {
+	return &Status{
+		Calls:      atomic.LoadUint64(&dp.calls),
+		Exceptions: atomic.LoadUint64(&dp.exceptions),
+		Failures:   atomic.LoadUint64(&dp.failures),
+	}
+}
+
+// Close closes DubboProxy.
+func (dp *DubboProxy) Close() {
+	dp.pool.Close()
+}
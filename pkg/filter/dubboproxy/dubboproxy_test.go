@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dubboproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// serveProvider serves a fake provider of com.example.UserService, which
+// sends a heartbeat before each response.
+func serveProvider(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				packet, err := readPacket(conn)
+				if err != nil {
+					return
+				}
+				codec := hessian.NewHessianCodec(bufio.NewReaderSize(bytes.NewReader(packet), len(packet)))
+				header := hessian.DubboHeader{}
+				codec.ReadHeader(&header)
+				if header.Type&hessian.PackageHeartbeat != 0 {
+					continue
+				}
+				req := make([]interface{}, 7)
+				if err = codec.ReadBody(req); err != nil {
+					t.Errorf("decode request failed: %v", err)
+					return
+				}
+
+				var result interface{}
+				var exception error
+				args := req[5].([]interface{})
+				attachments := req[6].(map[string]string)
+				switch {
+				case req[1] != "com.example.UserService" || req[3] != genericMethod || attachments["generic"] != "true":
+					exception = errors.New("not a generic invocation")
+				case args[0] == "getUser" && args[2].([]hessian.Object)[0] == int64(7):
+					result = map[interface{}]interface{}{
+						"class": "com.example.User",
+						"id":    int64(7),
+						"name":  "alice",
+						"tags":  []interface{}{"admin"},
+					}
+				case args[0] == "createUser":
+					user := args[2].([]hessian.Object)[0].(map[interface{}]interface{})
+					result = map[interface{}]interface{}{
+						"name":    user["name"],
+						"version": attachments["version"],
+					}
+				default:
+					exception = errors.New("user not found")
+				}
+
+				heartbeat, _ := codec.Write(hessian.Service{}, hessian.DubboHeader{
+					SerialID: serialHessian2,
+					Type:     hessian.PackageHeartbeat,
+					ID:       100,
+				}, hessian.NewRequest([]interface{}{}, nil))
+				resp, _ := codec.Write(hessian.Service{}, hessian.DubboHeader{
+					SerialID:       serialHessian2,
+					Type:           hessian.PackageResponse,
+					ID:             header.ID,
+					ResponseStatus: hessian.Response_OK,
+				}, hessian.NewResponse(result, exception, nil))
+				conn.Write(append(heartbeat, resp...))
+			}
+		}()
+	}
+}
+
+func doRequest(dp *DubboProxy, method, path, body string) (string, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	return httppipelinetest.Handle(dp, req, nil)
+}
+
+func TestDubboProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	go serveProvider(t, ln)
+
+	dp := httppipelinetest.InitFilter(t, &DubboProxy{}, `
+kind: DubboProxy
+name: dubbo
+servers: [`+ln.Addr().String()+`]
+methods:
+- method: GET
+  path: /users/{id}
+  interface: com.example.UserService
+  rpcMethod: getUser
+  params:
+  - type: long
+    from: path.id
+- method: POST
+  path: /users
+  interface: com.example.UserService
+  version: 1.0.0
+  rpcMethod: createUser
+  params:
+  - type: com.example.User
+    from: body
+`).(*DubboProxy)
+	defer dp.Close()
+
+	result, w := doRequest(dp, http.MethodGet, "/users/7", "")
+	if result != "" || w.Code != http.StatusOK {
+		t.Fatalf("unexpected result %q, %d, %s", result, w.Code, w.Body.String())
+	}
+	user := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	if user["name"] != "alice" || user["id"] != float64(7) || user["class"] != nil {
+		t.Errorf("unexpected user %s", w.Body.String())
+	}
+
+	result, w = doRequest(dp, http.MethodPost, "/users", `{"name": "bob", "age": 20}`)
+	if result != "" || w.Body.String() != `{"name":"bob","version":"1.0.0"}` {
+		t.Errorf("unexpected result %q, %s", result, w.Body.String())
+	}
+
+	result, w = doRequest(dp, http.MethodGet, "/users/8", "")
+	if result != resultServerError || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "user not found") {
+		t.Errorf("exception should be returned, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	if result, w = doRequest(dp, http.MethodGet, "/users/abc", ""); result != resultInvalidRequest || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid id should be rejected, got %q, %d", result, w.Code)
+	}
+	if result, w = doRequest(dp, http.MethodDelete, "/users/7", ""); result != resultNoRoute || w.Code != http.StatusNotFound {
+		t.Errorf("request should not be routed, got %q, %d", result, w.Code)
+	}
+
+	if s := dp.Status().(*Status); s.Calls != 3 || s.Exceptions != 1 || s.Failures != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dp := httppipelinetest.InitFilter(t, &DubboProxy{}, `
+kind: DubboProxy
+name: dubbo
+servers: [`+addr+`]
+methods:
+- method: GET
+  path: /users/{id}
+  interface: com.example.UserService
+  rpcMethod: getUser
+  params:
+  - type: long
+    from: path.id
+`).(*DubboProxy)
+	defer dp.Close()
+
+	if result, w := doRequest(dp, http.MethodGet, "/users/7", ""); result != resultServerError || w.Code != http.StatusBadGateway {
+		t.Errorf("unexpected result %q, %d", result, w.Code)
+	}
+	if s := dp.Status().(*Status); s.Failures != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: DubboProxy
+name: dubbo
+servers: [127.0.0.1:20880]
+methods:
+- method: GET
+  path: /users/{id}
+  interface: com.example.UserService
+  rpcMethod: getUser
+  params:
+  - type: long
+    from: cookie.id
+`), &rawSpec)
+	if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+		t.Error("invalid source should be rejected")
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extprocessor
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ExternalProcessor.
+	Kind = "ExternalProcessor"
+
+	// ProtocolHTTP calls the processor by HTTP requests with JSON bodies.
+	ProtocolHTTP = "http"
+	// ProtocolGRPC calls the processor by gRPC.
+	ProtocolGRPC = "grpc"
+
+	// FailureModeAllow passes the requests if the processor fails.
+	FailureModeAllow = "allow"
+	// FailureModeDeny rejects the requests if the processor fails.
+	FailureModeDeny = "deny"
+
+	resultDenied = "denied"
+	resultFailed = "failed"
+
+	defaultCacheMaxEntries = 10000
+)
+
+var results = []string{resultDenied, resultFailed}
+
+func init() {
+	httppipeline.Register(&ExternalProcessor{})
+}
+
+type (
+	// ExternalProcessor calls an external service to decide whether to
+	// allow the requests, and how to modify them.
+	ExternalProcessor struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout   time.Duration
+		processor processor
+		cache     *cache.Cache
+
+		requests  uint64
+		allowed   uint64
+		denied    uint64
+		failures  uint64
+		cacheHits uint64
+	}
+
+	// Spec describes the ExternalProcessor.
+	Spec struct {
+		Protocol string `yaml:"protocol" jsonschema:"required,enum=http,enum=grpc"`
+		// URL is the URL of the HTTP endpoint, or the address of the gRPC
+		// server in the form of http://host:port or https://host:port.
+		URL               string `yaml:"url" jsonschema:"required,format=url"`
+		Timeout           string `yaml:"timeout" jsonschema:"required,format=duration"`
+		FailureMode       string `yaml:"failureMode" jsonschema:"required,enum=allow,enum=deny"`
+		FailureStatusCode int    `yaml:"failureStatusCode" jsonschema:"required,format=httpcode"`
+		// IncludeHeaders are the request headers sent to the processor,
+		// all headers are sent if it is empty.
+		IncludeHeaders []string `yaml:"includeHeaders" jsonschema:"omitempty"`
+		IncludeBody    bool     `yaml:"includeBody" jsonschema:"omitempty"`
+		// MaxBodySize is the maximum size of the request body sent to the
+		// processor, the body is truncated if it is larger.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"required,minimum=1"`
+		// MaxIdleConns is the maximum number of the idle connections kept
+		// to the processor.
+		MaxIdleConns int        `yaml:"maxIdleConns" jsonschema:"required,minimum=1"`
+		Cache        *CacheSpec `yaml:"cache" jsonschema:"omitempty"`
+	}
+
+	// CacheSpec describes how to cache the decisions of the processor.
+	CacheSpec struct {
+		TTL        string `yaml:"ttl" jsonschema:"required,format=duration"`
+		MaxEntries int    `yaml:"maxEntries,omitempty" jsonschema:"omitempty,minimum=1"`
+		// KeyHeaders are the headers in the cache key besides the method,
+		// host and path of the request.
+		KeyHeaders []string `yaml:"keyHeaders" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of ExternalProcessor.
+	Status struct {
+		Requests  uint64 `yaml:"requests"`
+		Allowed   uint64 `yaml:"allowed"`
+		Denied    uint64 `yaml:"denied"`
+		Failures  uint64 `yaml:"failures"`
+		CacheHits uint64 `yaml:"cacheHits"`
+		CacheSize int    `yaml:"cacheSize"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	if spec.Cache != nil && spec.IncludeBody {
+		return fmt.Errorf("cache can't be used with includeBody, as the decisions may depend on the bodies")
+	}
+	return nil
+}
+
+// Kind returns the kind of ExternalProcessor.
+func (ep *ExternalProcessor) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ExternalProcessor.
+func (ep *ExternalProcessor) DefaultSpec() interface{} {
+	return &Spec{
+		Protocol:          ProtocolHTTP,
+		Timeout:           "200ms",
+		FailureMode:       FailureModeDeny,
+		FailureStatusCode: http.StatusForbidden,
+		MaxBodySize:       8192,
+		MaxIdleConns:      64,
+	}
+}
+
+// Description returns the description of ExternalProcessor.
+func (ep *ExternalProcessor) Description() string {
+	return "ExternalProcessor calls an external service to authorize and modify the requests."
+}
+
+// Results returns the results of ExternalProcessor.
+func (ep *ExternalProcessor) Results() []string {
+	return results
+}
+
+// Init initializes ExternalProcessor.
+func (ep *ExternalProcessor) Init(filterSpec *httppipeline.FilterSpec) {
+	ep.filterSpec, ep.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ep.reload()
+}
+
+// Inherit inherits previous generation of ExternalProcessor.
+func (ep *ExternalProcessor) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ep.Init(filterSpec)
+}
+
+func (ep *ExternalProcessor) reload() {
+	var err error
+	ep.timeout, err = time.ParseDuration(ep.spec.Timeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", ep.spec.Timeout, err)
+		ep.timeout = 200 * time.Millisecond
+	}
+
+	if ep.spec.Protocol == ProtocolGRPC {
+		ep.processor = newGRPCProcessor(ep.spec)
+	} else {
+		ep.processor = newHTTPProcessor(ep.spec)
+	}
+
+	if ep.spec.Cache == nil {
+		return
+	}
+	ttl, err := time.ParseDuration(ep.spec.Cache.TTL)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", ep.spec.Cache.TTL, err)
+		ttl = 10 * time.Second
+	}
+	ep.cache = cache.New(ttl, 2*ttl)
+}
+
+// Handle handles HTTP request.
+func (ep *ExternalProcessor) Handle(ctx context.HTTPContext) string {
+	result := ep.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ep *ExternalProcessor) handle(ctx context.HTTPContext) string {
+	atomic.AddUint64(&ep.requests, 1)
+
+	var key string
+	if ep.cache != nil {
+		key = ep.cacheKey(ctx)
+		if d, ok := ep.cache.Get(key); ok {
+			atomic.AddUint64(&ep.cacheHits, 1)
+			return ep.apply(ctx, d.(*decision))
+		}
+	}
+
+	req := ep.buildRequest(ctx)
+	timeoutCtx, cancel := stdcontext.WithTimeout(ctx, ep.timeout)
+	defer cancel()
+
+	d, err := ep.processor.process(timeoutCtx, req)
+	if err != nil {
+		atomic.AddUint64(&ep.failures, 1)
+		ctx.AddTag(fmt.Sprintf("%s: call processor failed: %v", ep.filterSpec.Name(), err))
+		if ep.spec.FailureMode == FailureModeAllow {
+			return ""
+		}
+		ctx.Response().SetStatusCode(ep.spec.FailureStatusCode)
+		return resultFailed
+	}
+
+	if ep.cache != nil {
+		// NOTE: The decision isn't cached if the cache is full, the
+		// expired entries are removed by the cleanup of the cache.
+		if ep.cache.ItemCount() < ep.cacheMaxEntries() {
+			ep.cache.SetDefault(key, d)
+		}
+	}
+
+	return ep.apply(ctx, d)
+}
+
+func (ep *ExternalProcessor) cacheMaxEntries() int {
+	if ep.spec.Cache.MaxEntries > 0 {
+		return ep.spec.Cache.MaxEntries
+	}
+	return defaultCacheMaxEntries
+}
+
+func (ep *ExternalProcessor) cacheKey(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	parts := []string{r.Method(), r.Host(), r.Path()}
+	for _, name := range ep.spec.Cache.KeyHeaders {
+		parts = append(parts, strings.Join(r.Header().GetAll(name), ","))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// buildRequest builds the request to the processor, the request body is
+// read and then set back, so it is still available to the next filters.
+func (ep *ExternalProcessor) buildRequest(ctx context.HTTPContext) *processRequest {
+	r := ctx.Request()
+	req := &processRequest{
+		Method:  r.Method(),
+		Scheme:  r.Scheme(),
+		Host:    r.Host(),
+		Path:    r.Path(),
+		Query:   r.Query(),
+		Proto:   r.Proto(),
+		RealIP:  r.RealIP(),
+		Headers: map[string]string{},
+	}
+
+	if len(ep.spec.IncludeHeaders) == 0 {
+		for name, values := range r.Header().Std() {
+			req.Headers[name] = strings.Join(values, ", ")
+		}
+	} else {
+		for _, name := range ep.spec.IncludeHeaders {
+			if values := r.Header().GetAll(name); len(values) > 0 {
+				req.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+			}
+		}
+	}
+
+	if ep.spec.IncludeBody && r.Body() != nil {
+		body := r.Body()
+		buf, err := io.ReadAll(io.LimitReader(body, ep.spec.MaxBodySize+1))
+		r.SetBody(io.MultiReader(bytes.NewReader(buf), body))
+		if err != nil {
+			logger.Warnf("%s: read request body failed: %v", ep.filterSpec.Name(), err)
+		}
+		if int64(len(buf)) > ep.spec.MaxBodySize {
+			buf, req.BodyTruncated = buf[:ep.spec.MaxBodySize], true
+		}
+		req.Body = buf
+	}
+
+	return req
+}
+
+// apply applies the decision to the request or the response.
+func (ep *ExternalProcessor) apply(ctx context.HTTPContext, d *decision) string {
+	if d.Allow {
+		atomic.AddUint64(&ep.allowed, 1)
+		h := ctx.Request().Header()
+		for _, name := range d.RemoveRequestHeaders {
+			h.Del(name)
+		}
+		for name, value := range d.SetRequestHeaders {
+			h.Set(name, value)
+		}
+		return ""
+	}
+
+	atomic.AddUint64(&ep.denied, 1)
+	ctx.AddTag(stringtool.Cat(ep.filterSpec.Name(), ": denied by processor"))
+
+	w := ctx.Response()
+	statusCode := d.StatusCode
+	if statusCode < 100 || statusCode > 599 {
+		statusCode = http.StatusForbidden
+	}
+	w.SetStatusCode(statusCode)
+	for name, value := range d.Headers {
+		w.Header().Set(name, value)
+	}
+	if d.Body != "" {
+		w.SetBody(strings.NewReader(d.Body))
+	}
+	return resultDenied
+}
+
+// Status returns Status generated by ExternalProcessor.
+func (ep *ExternalProcessor) Status() interface{} {
+	s := &Status{
+		Requests:  atomic.LoadUint64(&ep.requests),
+		Allowed:   atomic.LoadUint64(&ep.allowed),
+		Denied:    atomic.LoadUint64(&ep.denied),
+		Failures:  atomic.LoadUint64(&ep.failures),
+		CacheHits: atomic.LoadUint64(&ep.cacheHits),
+	}
+	if ep.cache != nil {
+		s.CacheSize = ep.cache.ItemCount()
+	}
+	return s
+}
+
+// Close closes ExternalProcessor.
+func (ep *ExternalProcessor) Close() {
+	ep.processor.close()
+}
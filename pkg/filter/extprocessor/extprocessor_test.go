@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extprocessor
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// decide is the logic of the fake processors, requests with the token
+// are allowed and the user is passed in a header.
+func decide(req *processRequest) *decision {
+	if req.Headers["Authorization"] != "Bearer good" {
+		return &decision{
+			StatusCode: http.StatusUnauthorized,
+			Headers:    map[string]string{"Www-Authenticate": "Bearer"},
+			Body:       "bad token",
+		}
+	}
+	return &decision{
+		Allow:                true,
+		SetRequestHeaders:    map[string]string{"X-User": "alice", "X-Body": string(req.Body)},
+		RemoveRequestHeaders: []string{"Authorization"},
+	}
+}
+
+func doRequest(ep *ExternalProcessor, token string) (string, *httptest.ResponseRecorder, http.Header) {
+	req := httptest.NewRequest("POST", "/users", strings.NewReader("hello"))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	var header http.Header
+	result, w := httppipelinetest.Handle(ep, req, func(ctx context.HTTPContext, lastResult string) string {
+		header = ctx.Request().Header().Std().Clone()
+		body, _ := io.ReadAll(ctx.Request().Body())
+		header.Set("X-Next-Body", string(body))
+		return lastResult
+	})
+	return result, w, header
+}
+
+func TestHTTPProcessor(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		req := &processRequest{}
+		json.NewDecoder(r.Body).Decode(req)
+		json.NewEncoder(w).Encode(decide(req))
+	}))
+	defer server.Close()
+
+	ep := httppipelinetest.InitFilter(t, &ExternalProcessor{}, `
+kind: ExternalProcessor
+name: ext
+url: `+server.URL+`
+includeBody: true
+maxBodySize: 3
+`).(*ExternalProcessor)
+	defer ep.Close()
+
+	result, _, header := doRequest(ep, "good")
+	if result != "" {
+		t.Fatalf("request should be allowed, got %q", result)
+	}
+	if header.Get("X-User") != "alice" || header.Get("Authorization") != "" {
+		t.Errorf("request headers should be modified, got %v", header)
+	}
+	if header.Get("X-Body") != "hel" || header.Get("X-Next-Body") != "hello" {
+		t.Errorf("body should be truncated for the processor only, got %v", header)
+	}
+
+	result, w, _ := doRequest(ep, "bad")
+	if result != resultDenied {
+		t.Fatalf("request should be denied, got %q", result)
+	}
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Www-Authenticate") != "Bearer" || w.Body.String() != "bad token" {
+		t.Errorf("unexpected response %d, %v, %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	s := ep.Status().(*Status)
+	if s.Requests != 2 || s.Allowed != 1 || s.Denied != 1 || calls != 2 {
+		t.Errorf("unexpected status %+v, calls %d", s, calls)
+	}
+}
+
+func TestGRPCProcessorAndCache(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcMethodPath || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+
+		msg, _ := decodeFrame(r.Body)
+		s := &structpb.Struct{}
+		proto.Unmarshal(msg, s)
+		req := &processRequest{}
+		fromStruct(s, req)
+
+		s, _ = toStruct(decide(req))
+		msg, _ = proto.Marshal(s)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(encodeFrame(msg))
+		w.Header().Set("Grpc-Status", "0")
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	ep := httppipelinetest.InitFilter(t, &ExternalProcessor{}, `
+kind: ExternalProcessor
+name: ext
+protocol: grpc
+url: `+server.URL+`
+cache:
+  ttl: 1m
+  keyHeaders: [Authorization]
+`).(*ExternalProcessor)
+	defer ep.Close()
+
+	for i := 0; i < 3; i++ {
+		if result, _, header := doRequest(ep, "good"); result != "" || header.Get("X-User") != "alice" {
+			t.Fatalf("request should be allowed, got %q, %v", result, header)
+		}
+	}
+	if result, w, _ := doRequest(ep, "bad"); result != resultDenied || w.Code != http.StatusUnauthorized {
+		t.Fatalf("request should be denied, got %q, %d", result, w.Code)
+	}
+	doRequest(ep, "bad")
+
+	s := ep.Status().(*Status)
+	if calls != 2 || s.CacheHits != 3 || s.CacheSize != 2 {
+		t.Errorf("decisions should be cached, calls %d, status %+v", calls, s)
+	}
+}
+
+func TestFailureMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			ioutil.ReadAll(r.Body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	ep := httppipelinetest.InitFilter(t, &ExternalProcessor{}, `
+kind: ExternalProcessor
+name: ext
+url: `+server.URL+`
+timeout: 10ms
+failureStatusCode: 503
+`).(*ExternalProcessor)
+	result, w, _ := doRequest(ep, "good")
+	if result != resultFailed || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request should fail with 503, got %q, %d", result, w.Code)
+	}
+	ep.Close()
+
+	ep = httppipelinetest.InitFilter(t, &ExternalProcessor{}, `
+kind: ExternalProcessor
+name: ext
+url: `+server.URL+`
+timeout: 10ms
+failureMode: allow
+`).(*ExternalProcessor)
+	defer ep.Close()
+	if result, _, _ := doRequest(ep, "good"); result != "" {
+		t.Errorf("request should be allowed, got %q", result)
+	}
+	if s := ep.Status().(*Status); s.Failures != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: ExternalProcessor
+name: ext
+url: http://127.0.0.1:9000
+includeBody: true
+cache:
+  ttl: 1m
+`), &rawSpec)
+	if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+		t.Error("cache with includeBody should be invalid")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extprocessor
+
+import (
+	"bytes"
+	stdcontext "context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// grpcMethodPath is the path of the gRPC method, both the request and
+	// the response of the method are google.protobuf.Struct.
+	grpcMethodPath     = "/easegress.extprocessor.v1.ExternalProcessor/Process"
+	grpcFrameHeaderLen = 5
+
+	maxDecisionSize = 1 << 20
+)
+
+type (
+	// processor calls the external processor.
+	processor interface {
+		process(ctx stdcontext.Context, req *processRequest) (*decision, error)
+		close()
+	}
+
+	// processRequest is the request sent to the processor, the body is
+	// encoded in base64.
+	processRequest struct {
+		Method        string            `json:"method"`
+		Scheme        string            `json:"scheme"`
+		Host          string            `json:"host"`
+		Path          string            `json:"path"`
+		Query         string            `json:"query"`
+		Proto         string            `json:"proto"`
+		RealIP        string            `json:"realIP"`
+		Headers       map[string]string `json:"headers"`
+		Body          []byte            `json:"body,omitempty"`
+		BodyTruncated bool              `json:"bodyTruncated,omitempty"`
+	}
+
+	// decision is the response of the processor, the status code,
+	// headers and body are used to respond the denied requests.
+	decision struct {
+		Allow                bool              `json:"allow"`
+		StatusCode           int               `json:"statusCode"`
+		Headers              map[string]string `json:"headers"`
+		Body                 string            `json:"body"`
+		SetRequestHeaders    map[string]string `json:"setRequestHeaders"`
+		RemoveRequestHeaders []string          `json:"removeRequestHeaders"`
+	}
+
+	httpProcessor struct {
+		url       string
+		transport *http.Transport
+		client    *http.Client
+	}
+
+	grpcProcessor struct {
+		url       string
+		transport *http2.Transport
+	}
+)
+
+func newHTTPProcessor(spec *Spec) *httpProcessor {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 60 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        spec.MaxIdleConns,
+		MaxIdleConnsPerHost: spec.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &httpProcessor{
+		url:       spec.URL,
+		transport: transport,
+		client:    &http.Client{Transport: transport},
+	}
+}
+
+func (p *httpProcessor) process(ctx stdcontext.Context, req *processRequest) (*decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stdr, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	stdr.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(stdr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// NOTE: Drain the body so that the connection could be reused.
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	d := &decision{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDecisionSize)).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("decode decision failed: %v", err)
+	}
+	return d, nil
+}
+
+func (p *httpProcessor) close() {
+	p.transport.CloseIdleConnections()
+}
+
+// newGRPCProcessor creates a gRPC processor, plaintext HTTP/2 is used for
+// http and TLS for https.
+func newGRPCProcessor(spec *Spec) *grpcProcessor {
+	p := &grpcProcessor{url: strings.TrimSuffix(spec.URL, "/") + grpcMethodPath}
+	if strings.HasPrefix(strings.ToLower(spec.URL), "https://") {
+		p.transport = &http2.Transport{}
+	} else {
+		p.transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}
+	}
+	return p
+}
+
+// toStruct converts v to google.protobuf.Struct by its JSON encoding.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// fromStruct converts google.protobuf.Struct to v by its JSON encoding.
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	buf, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func encodeFrame(msg []byte) []byte {
+	buf := make([]byte, grpcFrameHeaderLen+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	copy(buf[grpcFrameHeaderLen:], msg)
+	return buf
+}
+
+func decodeFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, grpcFrameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed message is not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxDecisionSize {
+		return nil, fmt.Errorf("message size %d exceeds %d", n, maxDecisionSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (p *grpcProcessor) process(ctx stdcontext.Context, req *processRequest) (*decision, error) {
+	s, err := toStruct(req)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := proto.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	stdr, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(encodeFrame(msg)))
+	if err != nil {
+		return nil, err
+	}
+	stdr.Header.Set("Content-Type", "application/grpc")
+	stdr.Header.Set("Te", "trailers")
+
+	resp, err := p.transport.RoundTrip(stdr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	msg, readErr := decodeFrame(resp.Body)
+	// NOTE: The trailers are available after the body is read to completion.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	code, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return nil, fmt.Errorf("grpc status %s: %s", code, message)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("read response failed: %v", readErr)
+	}
+
+	s = &structpb.Struct{}
+	if err = proto.Unmarshal(msg, s); err != nil {
+		return nil, fmt.Errorf("decode decision failed: %v", err)
+	}
+	d := &decision{}
+	if err = fromStruct(s, d); err != nil {
+		return nil, fmt.Errorf("decode decision failed: %v", err)
+	}
+	return d, nil
+}
+
+func (p *grpcProcessor) close() {
+	p.transport.CloseIdleConnections()
+}
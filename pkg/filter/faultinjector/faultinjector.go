@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package faultinjector
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of FaultInjector.
+	Kind = "FaultInjector"
+
+	resultAborted = "aborted"
+)
+
+var results = []string{resultAborted}
+
+func init() {
+	httppipeline.Register(&FaultInjector{})
+}
+
+type (
+	// FaultInjector injects delays and aborts to the matched requests for
+	// chaos experiments.
+	FaultInjector struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		rules []*rule
+	}
+
+	// Spec describes the FaultInjector.
+	Spec struct {
+		// Rules are matched in order, only the first matched rule is used.
+		Rules []*RuleSpec `yaml:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// RuleSpec describes the faults injected to the matched requests.
+	RuleSpec struct {
+		// URLs matches the request if any of them matches, it matches all
+		// requests if empty.
+		URLs []*urlrule.URLRule `yaml:"urls" jsonschema:"omitempty"`
+		// Headers matches the request if all of them match.
+		Headers map[string]*urlrule.StringMatch `yaml:"headers" jsonschema:"omitempty"`
+		Delay   *DelaySpec                      `yaml:"delay,omitempty" jsonschema:"omitempty"`
+		Abort   *AbortSpec                      `yaml:"abort,omitempty" jsonschema:"omitempty"`
+	}
+
+	// DelaySpec describes the delay injected before the request is
+	// passed to the next filter.
+	DelaySpec struct {
+		Duration string `yaml:"duration" jsonschema:"required,format=duration"`
+		// Percentage is the percentage of the matched requests to delay,
+		// default is 100.
+		Percentage float64 `yaml:"percentage" jsonschema:"omitempty,minimum=0,maximum=100"`
+	}
+
+	// AbortSpec describes the response of the aborted requests.
+	AbortSpec struct {
+		StatusCode int               `yaml:"statusCode" jsonschema:"required,format=httpcode"`
+		Headers    map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body       string            `yaml:"body" jsonschema:"omitempty"`
+		// Percentage is the percentage of the matched requests to abort,
+		// default is 100.
+		Percentage float64 `yaml:"percentage" jsonschema:"omitempty,minimum=0,maximum=100"`
+	}
+
+	// Status is the status of FaultInjector.
+	Status struct {
+		Rules []*RuleStatus `yaml:"rules"`
+	}
+
+	// RuleStatus is the status of a rule.
+	RuleStatus struct {
+		Matched uint64 `yaml:"matched"`
+		Delayed uint64 `yaml:"delayed"`
+		Aborted uint64 `yaml:"aborted"`
+	}
+
+	rule struct {
+		spec  *RuleSpec
+		delay time.Duration
+
+		matched uint64
+		delayed uint64
+		aborted uint64
+	}
+)
+
+// Validate validates the RuleSpec.
+func (spec RuleSpec) Validate() error {
+	if spec.Delay == nil && spec.Abort == nil {
+		return fmt.Errorf("none of delay and abort is specified")
+	}
+	return nil
+}
+
+// Kind returns the kind of FaultInjector.
+func (fi *FaultInjector) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of FaultInjector.
+func (fi *FaultInjector) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of FaultInjector.
+func (fi *FaultInjector) Description() string {
+	return "FaultInjector injects delays and aborts to requests for chaos experiments."
+}
+
+// Results returns the results of FaultInjector.
+func (fi *FaultInjector) Results() []string {
+	return results
+}
+
+// Init initializes FaultInjector.
+func (fi *FaultInjector) Init(filterSpec *httppipeline.FilterSpec) {
+	fi.filterSpec, fi.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	fi.reload()
+}
+
+// Inherit inherits previous generation of FaultInjector.
+func (fi *FaultInjector) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	fi.Init(filterSpec)
+}
+
+func (fi *FaultInjector) reload() {
+	fi.rules = nil
+	for _, spec := range fi.spec.Rules {
+		for _, u := range spec.URLs {
+			u.Init()
+		}
+		for _, sm := range spec.Headers {
+			sm.Init()
+		}
+
+		r := &rule{spec: spec}
+		if spec.Delay != nil {
+			r.delay, _ = time.ParseDuration(spec.Delay.Duration)
+		}
+		fi.rules = append(fi.rules, r)
+	}
+}
+
+func sample(percentage float64) bool {
+	return percentage == 0 || percentage >= 100 || rand.Float64()*100 < percentage
+}
+
+func (r *rule) match(ctx context.HTTPContext) bool {
+	req := ctx.Request()
+
+	if len(r.spec.URLs) > 0 {
+		matched := false
+		for _, u := range r.spec.URLs {
+			if u.Match(req) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for key, sm := range r.spec.Headers {
+		if !sm.Match(req.Header().Get(key)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Handle injects faults to HTTPContext.
+func (fi *FaultInjector) Handle(ctx context.HTTPContext) string {
+	result := fi.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (fi *FaultInjector) handle(ctx context.HTTPContext) string {
+	for _, r := range fi.rules {
+		if r.match(ctx) {
+			atomic.AddUint64(&r.matched, 1)
+			return fi.inject(ctx, r)
+		}
+	}
+	return ""
+}
+
+func (fi *FaultInjector) inject(ctx context.HTTPContext, r *rule) string {
+	if d := r.spec.Delay; d != nil && sample(d.Percentage) {
+		atomic.AddUint64(&r.delayed, 1)
+		ctx.AddTag(fmt.Sprintf("faultInjector: delay %v", r.delay))
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.delay):
+		}
+	}
+
+	a := r.spec.Abort
+	if a == nil || !sample(a.Percentage) {
+		return ""
+	}
+
+	atomic.AddUint64(&r.aborted, 1)
+	ctx.AddTag(fmt.Sprintf("faultInjector: abort %d", a.StatusCode))
+	w := ctx.Response()
+	w.SetStatusCode(a.StatusCode)
+	for key, value := range a.Headers {
+		w.Header().Set(key, value)
+	}
+	w.SetBody(strings.NewReader(a.Body))
+	return resultAborted
+}
+
+// Status returns status.
+func (fi *FaultInjector) Status() interface{} {
+	s := &Status{}
+	for _, r := range fi.rules {
+		s.Rules = append(s.Rules, &RuleStatus{
+			Matched: atomic.LoadUint64(&r.matched),
+			Delayed: atomic.LoadUint64(&r.delayed),
+			Aborted: atomic.LoadUint64(&r.aborted),
+		})
+	}
+	return s
+}
+
+// Close closes FaultInjector.
+func (fi *FaultInjector) Close() {
+}
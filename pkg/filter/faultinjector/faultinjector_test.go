@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package faultinjector
+
+import (
+	stdcontext "context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func doRequest(fi *FaultInjector, method, path string, header map[string]string) (string, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return httppipelinetest.Handle(fi, req, nil)
+}
+
+func TestFaultInjector(t *testing.T) {
+	fi := httppipelinetest.InitFilter(t, &FaultInjector{}, `
+kind: FaultInjector
+name: fault-injector
+rules:
+- urls:
+  - methods: [GET]
+    url:
+      prefix: /slow
+  delay:
+    duration: 50ms
+- urls:
+  - url:
+      exact: /fail
+  headers:
+    X-Chaos:
+      exact: "on"
+  abort:
+    statusCode: 503
+    headers:
+      X-Fault: injected
+    body: fault injected
+- urls:
+  - url:
+      exact: /never
+  abort:
+    statusCode: 500
+    percentage: 0.0001
+`).(*FaultInjector)
+	defer fi.Close()
+
+	start := time.Now()
+	result, _ := doRequest(fi, http.MethodGet, "/slow/api", nil)
+	if result != "" || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected delay, got result %q after %v", result, time.Since(start))
+	}
+
+	start = time.Now()
+	doRequest(fi, http.MethodPost, "/slow/api", nil)
+	if time.Since(start) >= 50*time.Millisecond {
+		t.Errorf("POST should not be delayed")
+	}
+
+	result, w := doRequest(fi, http.MethodGet, "/fail", map[string]string{"X-Chaos": "on"})
+	if result != resultAborted {
+		t.Fatalf("expected aborted, got %q", result)
+	}
+	body, _ := io.ReadAll(w.Result().Body)
+	if w.Code != 503 || w.Header().Get("X-Fault") != "injected" || string(body) != "fault injected" {
+		t.Errorf("unexpected response %d %v %s", w.Code, w.Header(), body)
+	}
+
+	if result, _ = doRequest(fi, http.MethodGet, "/fail", nil); result != "" {
+		t.Errorf("expected not aborted without header, got %q", result)
+	}
+
+	for i := 0; i < 100; i++ {
+		doRequest(fi, http.MethodGet, "/never", nil)
+	}
+
+	status := fi.Status().(*Status)
+	expected := []RuleStatus{
+		{Matched: 1, Delayed: 1},
+		{Matched: 1, Aborted: 1},
+		{Matched: 100},
+	}
+	for i, e := range expected {
+		if *status.Rules[i] != e {
+			t.Errorf("rule %d: expected %+v, got %+v", i, e, *status.Rules[i])
+		}
+	}
+}
+
+func TestDelayCancelled(t *testing.T) {
+	fi := httppipelinetest.InitFilter(t, &FaultInjector{}, `
+kind: FaultInjector
+name: fault-injector
+rules:
+- delay:
+    duration: 10s
+`).(*FaultInjector)
+	defer fi.Close()
+
+	reqCtx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(reqCtx)
+	start := time.Now()
+	httppipelinetest.Handle(fi, req, nil)
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("delay should be cancelled with the context")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: FaultInjector
+name: fault-injector
+rules:
+- urls:
+  - url:
+      exact: /
+`), &rawSpec)
+	if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+		t.Errorf("expected error for rule without faults")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// listSizeArgs are the arguments limiting the sizes of the lists, the
+// complexities of the children are multiplied by their values.
+var listSizeArgs = []string{"first", "last"}
+
+// maxComplexity caps the complexities, so huge list sizes don't
+// overflow them.
+const maxComplexity = math.MaxInt32
+
+type (
+	// analysis is the result of analyzing an operation.
+	analysis struct {
+		operation  *ast.OperationDefinition
+		rootFields []string
+		depth      int
+		complexity int
+	}
+
+	analyzer struct {
+		doc       *ast.QueryDocument
+		variables map[string]interface{}
+		// visiting are the fragments being expanded, to detect cycles.
+		visiting map[string]bool
+		// NOTE: The results of the fragments are cached, or a document
+		// spreading the fragments repeatedly explodes exponentially.
+		expanded     map[string]bool
+		depths       map[string]int
+		complexities map[string]int
+	}
+)
+
+// analyze analyzes the operation of the document, the operation name
+// could be empty if there is only one operation.
+func analyze(doc *ast.QueryDocument, operationName string, variables map[string]interface{}) (*analysis, error) {
+	op := doc.Operations.ForName(operationName)
+	if op == nil {
+		if operationName == "" {
+			return nil, fmt.Errorf("operationName is required for multiple operations")
+		}
+		return nil, fmt.Errorf("operation %s not found", operationName)
+	}
+
+	a := &analyzer{
+		doc:          doc,
+		variables:    variables,
+		visiting:     map[string]bool{},
+		expanded:     map[string]bool{},
+		depths:       map[string]int{},
+		complexities: map[string]int{},
+	}
+	result := &analysis{operation: op}
+
+	rootFields, err := a.rootFields(op.SelectionSet, nil)
+	if err != nil {
+		return nil, err
+	}
+	result.rootFields = rootFields
+
+	if result.depth, err = a.depth(op.SelectionSet); err != nil {
+		return nil, err
+	}
+	if result.complexity, err = a.complexity(op.SelectionSet); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (a *analyzer) fragment(name string) (*ast.FragmentDefinition, error) {
+	f := a.doc.Fragments.ForName(name)
+	if f == nil {
+		return nil, fmt.Errorf("fragment %s not found", name)
+	}
+	if a.visiting[name] {
+		return nil, fmt.Errorf("fragment %s is cyclic", name)
+	}
+	return f, nil
+}
+
+// rootFields returns the names of the top-level fields, including the
+// ones in the fragments, a fragment is expanded only once.
+func (a *analyzer) rootFields(set ast.SelectionSet, fields []string) ([]string, error) {
+	for _, s := range set {
+		switch s := s.(type) {
+		case *ast.Field:
+			fields = append(fields, s.Name)
+		case *ast.InlineFragment:
+			var err error
+			if fields, err = a.rootFields(s.SelectionSet, fields); err != nil {
+				return nil, err
+			}
+		case *ast.FragmentSpread:
+			if a.expanded[s.Name] {
+				continue
+			}
+			f, err := a.fragment(s.Name)
+			if err != nil {
+				return nil, err
+			}
+			a.expanded[s.Name] = true
+			if fields, err = a.rootFields(f.SelectionSet, fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fields, nil
+}
+
+// depth returns the depth of the selection set, the top-level fields
+// are at depth 1, and the fragments don't add depths.
+func (a *analyzer) depth(set ast.SelectionSet) (int, error) {
+	max := 0
+	for _, s := range set {
+		var d int
+		var err error
+		switch s := s.(type) {
+		case *ast.Field:
+			if d, err = a.depth(s.SelectionSet); err == nil {
+				d++
+			}
+		case *ast.InlineFragment:
+			d, err = a.depth(s.SelectionSet)
+		case *ast.FragmentSpread:
+			var ok bool
+			if d, ok = a.depths[s.Name]; ok {
+				break
+			}
+			var f *ast.FragmentDefinition
+			if f, err = a.fragment(s.Name); err == nil {
+				a.visiting[s.Name] = true
+				d, err = a.depth(f.SelectionSet)
+				delete(a.visiting, s.Name)
+				a.depths[s.Name] = d
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if d > max {
+			max = d
+		}
+	}
+	return max, nil
+}
+
+// complexity returns the complexity of the selection set, a field costs
+// 1 plus the complexity of its children, which is multiplied by the
+// value of the list size argument if the field has one.
+func (a *analyzer) complexity(set ast.SelectionSet) (int, error) {
+	total := 0
+	for _, s := range set {
+		var c int
+		var err error
+		switch s := s.(type) {
+		case *ast.Field:
+			if c, err = a.complexity(s.SelectionSet); err == nil {
+				c = saturate(1 + int64(c)*int64(a.listSize(s)))
+			}
+		case *ast.InlineFragment:
+			c, err = a.complexity(s.SelectionSet)
+		case *ast.FragmentSpread:
+			var ok bool
+			if c, ok = a.complexities[s.Name]; ok {
+				break
+			}
+			var f *ast.FragmentDefinition
+			if f, err = a.fragment(s.Name); err == nil {
+				a.visiting[s.Name] = true
+				c, err = a.complexity(f.SelectionSet)
+				delete(a.visiting, s.Name)
+				a.complexities[s.Name] = c
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		total = saturate(int64(total) + int64(c))
+	}
+	return total, nil
+}
+
+func (a *analyzer) listSize(field *ast.Field) int {
+	size := 1
+	for _, name := range listSizeArgs {
+		arg := field.Arguments.ForName(name)
+		if arg == nil || arg.Value == nil {
+			continue
+		}
+
+		var n int64
+		var err error
+		switch arg.Value.Kind {
+		case ast.IntValue:
+			n, err = strconv.ParseInt(arg.Value.Raw, 10, 64)
+		case ast.Variable:
+			n, err = variableInt(a.variables[arg.Value.Raw])
+		default:
+			continue
+		}
+		if err == nil && n > int64(size) {
+			size = saturate(n)
+		}
+	}
+	return size
+}
+
+func saturate(n int64) int {
+	if n > maxComplexity {
+		return maxComplexity
+	}
+	return int(n)
+}
+
+func variableInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Int64()
+	case float64:
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("%v is not an integer", v)
+}
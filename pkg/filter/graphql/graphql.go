@@ -0,0 +1,556 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of GraphQL.
+	Kind = "GraphQL"
+
+	resultInvalidRequest = "invalidRequest"
+	resultNotAllowed     = "notAllowed"
+	resultLimitExceeded  = "limitExceeded"
+
+	defaultRouteHeader = "X-GraphQL-Route"
+	defaultMaxBodySize = 1 << 20
+
+	// maxOperations is the maximum number of the operations having
+	// their own statistics, the others share the statistics of
+	// otherOperations, so the status isn't flooded by the clients.
+	maxOperations      = 1000
+	otherOperations    = "<others>"
+	anonymousOperation = "<anonymous>"
+)
+
+var results = []string{resultInvalidRequest, resultNotAllowed, resultLimitExceeded}
+
+func init() {
+	httppipeline.Register(&GraphQL{})
+}
+
+type (
+	// GraphQL parses the GraphQL requests, rejects the ones exceeding
+	// the limits or not allowed, and routes them by their top-level
+	// fields by setting a header for the backends.
+	GraphQL struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		// persistedQueries maps the SHA-256 hashes to the queries.
+		persistedQueries map[string]string
+		// routes maps operation types and top-level fields to routes.
+		routes map[string]string
+
+		requests uint64
+		rejected uint64
+
+		mutex      sync.Mutex
+		operations map[string]*httpstat.HTTPStat
+	}
+
+	// Spec describes the GraphQL.
+	Spec struct {
+		// MaxBodySize is the maximum size of the request bodies.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+		// MaxDepth is the maximum depth of the operations, the top-level
+		// fields are at depth 1, 0 means no limit.
+		MaxDepth int `yaml:"maxDepth" jsonschema:"omitempty,minimum=0"`
+		// MaxComplexity is the maximum complexity of the operations, a
+		// field costs 1 plus the complexity of its children, which is
+		// multiplied by the value of its argument first or last, 0
+		// means no limit.
+		MaxComplexity        int                 `yaml:"maxComplexity" jsonschema:"omitempty,minimum=0"`
+		DisableIntrospection bool                `yaml:"disableIntrospection" jsonschema:"omitempty"`
+		PersistedQueries     *PersistedQuerySpec `yaml:"persistedQueries" jsonschema:"omitempty"`
+		// RouteHeader is the request header set to the name of the route
+		// of the operation, default is X-GraphQL-Route.
+		RouteHeader string       `yaml:"routeHeader" jsonschema:"omitempty"`
+		Routes      []*RouteSpec `yaml:"routes" jsonschema:"omitempty"`
+	}
+
+	// PersistedQuerySpec describes the persisted queries, which could
+	// be requested by their SHA-256 hashes in the extension
+	// persistedQuery, as the automatic persisted queries of Apollo.
+	PersistedQuerySpec struct {
+		Queries []string `yaml:"queries" jsonschema:"required,minItems=1"`
+		// AllowListOnly rejects the queries not in Queries.
+		AllowListOnly bool `yaml:"allowListOnly" jsonschema:"omitempty"`
+	}
+
+	// RouteSpec routes the operations selecting the top-level fields to
+	// the same backend.
+	RouteSpec struct {
+		Name string `yaml:"name" jsonschema:"required,minLength=1"`
+		// Operation is the type of the operations, default is query.
+		Operation string   `yaml:"operation,omitempty" jsonschema:"omitempty,enum=query,enum=mutation,enum=subscription"`
+		Fields    []string `yaml:"fields" jsonschema:"required,minItems=1,uniqueItems=true"`
+	}
+
+	// Status is the status of GraphQL.
+	Status struct {
+		Requests uint64 `yaml:"requests"`
+		Rejected uint64 `yaml:"rejected"`
+		// Operations are the statistics of the operations, keyed by the
+		// operation types and names, e.g. query getUser.
+		Operations map[string]*httpstat.Status `yaml:"operations"`
+	}
+
+	// request is the GraphQL request over HTTP.
+	request struct {
+		Query         string                 `json:"query,omitempty"`
+		OperationName string                 `json:"operationName,omitempty"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+		Extensions    map[string]interface{} `json:"extensions,omitempty"`
+	}
+
+	rejection struct {
+		statusCode int
+		result     string
+		message    string
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	if spec.PersistedQueries != nil {
+		for i, q := range spec.PersistedQueries.Queries {
+			if _, err := parser.ParseQuery(&ast.Source{Input: q}); err != nil {
+				return fmt.Errorf("persisted query %d is invalid: %v", i, err)
+			}
+		}
+	}
+
+	// NOTE: The routes could have the same name, e.g. the queries and
+	// mutations of the same backend.
+	fields := map[string]string{}
+	for _, r := range spec.Routes {
+		for _, f := range r.Fields {
+			key := routeKey(r.Operation, f)
+			if name, ok := fields[key]; ok {
+				return fmt.Errorf("field %s is in both route %s and %s", f, name, r.Name)
+			}
+			fields[key] = r.Name
+		}
+	}
+	return nil
+}
+
+func routeKey(operation, field string) string {
+	if operation == "" {
+		operation = string(ast.Query)
+	}
+	return operation + "." + field
+}
+
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Kind returns the kind of GraphQL.
+func (g *GraphQL) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of GraphQL.
+func (g *GraphQL) DefaultSpec() interface{} {
+	return &Spec{
+		MaxBodySize: defaultMaxBodySize,
+	}
+}
+
+// Description returns the description of GraphQL.
+func (g *GraphQL) Description() string {
+	return "GraphQL enforces limits and allow-lists on GraphQL requests and routes them by fields."
+}
+
+// Results returns the results of GraphQL.
+func (g *GraphQL) Results() []string {
+	return results
+}
+
+// Init initializes GraphQL.
+func (g *GraphQL) Init(filterSpec *httppipeline.FilterSpec) {
+	g.filterSpec, g.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	g.reload()
+}
+
+// Inherit inherits previous generation of GraphQL.
+func (g *GraphQL) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	g.Init(filterSpec)
+}
+
+func (g *GraphQL) reload() {
+	if g.spec.RouteHeader == "" {
+		g.spec.RouteHeader = defaultRouteHeader
+	}
+
+	g.persistedQueries = map[string]string{}
+	if g.spec.PersistedQueries != nil {
+		for _, q := range g.spec.PersistedQueries.Queries {
+			g.persistedQueries[hashQuery(q)] = q
+		}
+	}
+
+	g.routes = map[string]string{}
+	for _, r := range g.spec.Routes {
+		for _, f := range r.Fields {
+			g.routes[routeKey(r.Operation, f)] = r.Name
+		}
+	}
+
+	g.operations = map[string]*httpstat.HTTPStat{}
+}
+
+// Handle handles the GraphQL request.
+func (g *GraphQL) Handle(ctx context.HTTPContext) string {
+	result := g.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (g *GraphQL) handle(ctx context.HTTPContext) string {
+	atomic.AddUint64(&g.requests, 1)
+
+	req, rej := g.readRequest(ctx)
+	if rej == nil {
+		rej = g.resolvePersistedQuery(ctx, req)
+	}
+	var a *analysis
+	if rej == nil {
+		a, rej = g.analyze(req)
+	}
+	var route string
+	if rej == nil {
+		route, rej = g.route(a)
+	}
+	if rej != nil {
+		atomic.AddUint64(&g.rejected, 1)
+		ctx.AddTag(stringtool.Cat("graphql: ", rej.message))
+		writeErrors(ctx, rej.statusCode, rej.message)
+		return rej.result
+	}
+
+	// NOTE: The header from the client is removed, or clients could
+	// choose the backends.
+	r := ctx.Request()
+	r.Header().Del(g.spec.RouteHeader)
+	if route != "" {
+		r.Header().Set(g.spec.RouteHeader, route)
+	}
+
+	httpStat := g.operationStat(a.operation)
+	ctx.OnFinish(func() {
+		httpStat.Stat(ctx.StatMetric())
+	})
+	return ""
+}
+
+func invalidRequest(format string, args ...interface{}) *rejection {
+	return &rejection{
+		statusCode: http.StatusBadRequest,
+		result:     resultInvalidRequest,
+		message:    fmt.Sprintf(format, args...),
+	}
+}
+
+func notAllowed(message string) *rejection {
+	return &rejection{
+		statusCode: http.StatusForbidden,
+		result:     resultNotAllowed,
+		message:    message,
+	}
+}
+
+// readRequest reads the request from the query of GET requests, or from
+// the body of POST requests, which is restored for the backends.
+func (g *GraphQL) readRequest(ctx context.HTTPContext) (*request, *rejection) {
+	r := ctx.Request()
+	req := &request{}
+
+	switch r.Method() {
+	case http.MethodGet:
+		query, err := url.ParseQuery(r.Query())
+		if err != nil {
+			return nil, invalidRequest("invalid query string: %v", err)
+		}
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		for _, p := range []struct {
+			name  string
+			value *map[string]interface{}
+		}{{"variables", &req.Variables}, {"extensions", &req.Extensions}} {
+			if s := query.Get(p.name); s != "" {
+				if err := unmarshal([]byte(s), p.value); err != nil {
+					return nil, invalidRequest("invalid %s: %v", p.name, err)
+				}
+			}
+		}
+		return req, nil
+	case http.MethodPost:
+	default:
+		return nil, &rejection{
+			statusCode: http.StatusMethodNotAllowed,
+			result:     resultInvalidRequest,
+			message:    "method must be GET or POST",
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body(), g.spec.MaxBodySize+1))
+	if err != nil {
+		return nil, invalidRequest("read body failed: %v", err)
+	}
+	if int64(len(body)) > g.spec.MaxBodySize {
+		return nil, &rejection{
+			statusCode: http.StatusRequestEntityTooLarge,
+			result:     resultInvalidRequest,
+			message:    fmt.Sprintf("body exceeds %d bytes", g.spec.MaxBodySize),
+		}
+	}
+	r.SetBody(bytes.NewReader(body))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header().Get(httpheader.KeyContentType))
+	switch mediaType {
+	case "application/graphql":
+		req.Query = string(body)
+		if query, err := url.ParseQuery(r.Query()); err == nil {
+			req.OperationName = query.Get("operationName")
+		}
+	case "application/json", "":
+		if err := unmarshal(body, req); err != nil {
+			return nil, invalidRequest("invalid json body: %v", err)
+		}
+	default:
+		return nil, &rejection{
+			statusCode: http.StatusUnsupportedMediaType,
+			result:     resultInvalidRequest,
+			message:    fmt.Sprintf("unsupported content type %s", mediaType),
+		}
+	}
+	return req, nil
+}
+
+func unmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// resolvePersistedQuery fills the query of the request by its hash in
+// the extension persistedQuery, the request to the backends is
+// rewritten with the query, so the backends don't need to support the
+// persisted queries.
+func (g *GraphQL) resolvePersistedQuery(ctx context.HTTPContext, req *request) *rejection {
+	hash := ""
+	if pq, ok := req.Extensions["persistedQuery"].(map[string]interface{}); ok {
+		hash, _ = pq["sha256Hash"].(string)
+	}
+
+	switch {
+	case req.Query != "" && hash != "" && hashQuery(req.Query) != hash:
+		return invalidRequest("provided sha does not match query")
+	case req.Query != "":
+		if g.spec.PersistedQueries != nil && g.spec.PersistedQueries.AllowListOnly {
+			if _, ok := g.persistedQueries[hashQuery(req.Query)]; !ok {
+				return notAllowed("query is not in the allow list")
+			}
+		}
+		return nil
+	case hash == "":
+		return invalidRequest("query is required")
+	}
+
+	query, ok := g.persistedQueries[strings.ToLower(hash)]
+	if !ok {
+		// NOTE: The message is recognized by the Apollo clients.
+		return &rejection{
+			statusCode: http.StatusOK,
+			result:     resultNotAllowed,
+			message:    "PersistedQueryNotFound",
+		}
+	}
+	req.Query = query
+
+	r := ctx.Request()
+	if r.Method() == http.MethodGet {
+		values, _ := url.ParseQuery(r.Query())
+		values.Set("query", query)
+		r.SetQuery(values.Encode())
+		return nil
+	}
+
+	// NOTE: Keep the other members of the body unchanged.
+	body := map[string]json.RawMessage{}
+	if err := unmarshal(bodyBytes(r), &body); err != nil {
+		return invalidRequest("invalid json body: %v", err)
+	}
+	body["query"], _ = json.Marshal(query)
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return invalidRequest("encode body failed: %v", err)
+	}
+	r.SetBody(bytes.NewReader(buf))
+	r.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(buf)))
+	return nil
+}
+
+// bodyBytes reads the body which has been buffered by readRequest.
+func bodyBytes(r context.HTTPRequest) []byte {
+	body, _ := io.ReadAll(r.Body())
+	r.SetBody(bytes.NewReader(body))
+	return body
+}
+
+func (g *GraphQL) analyze(req *request) (*analysis, *rejection) {
+	doc, gqlErr := parser.ParseQuery(&ast.Source{Input: req.Query})
+	if gqlErr != nil {
+		return nil, invalidRequest("invalid query: %s", gqlErr.Message)
+	}
+	a, err := analyze(doc, req.OperationName, req.Variables)
+	if err != nil {
+		return nil, invalidRequest("invalid query: %v", err)
+	}
+
+	if g.spec.DisableIntrospection && a.operation.Operation == ast.Query {
+		for _, f := range a.rootFields {
+			if f == "__schema" || f == "__type" {
+				return nil, notAllowed("introspection is disabled")
+			}
+		}
+	}
+
+	if g.spec.MaxDepth > 0 && a.depth > g.spec.MaxDepth {
+		return nil, &rejection{
+			statusCode: http.StatusBadRequest,
+			result:     resultLimitExceeded,
+			message:    fmt.Sprintf("depth %d exceeds %d", a.depth, g.spec.MaxDepth),
+		}
+	}
+	if g.spec.MaxComplexity > 0 && a.complexity > g.spec.MaxComplexity {
+		return nil, &rejection{
+			statusCode: http.StatusBadRequest,
+			result:     resultLimitExceeded,
+			message:    fmt.Sprintf("complexity %d exceeds %d", a.complexity, g.spec.MaxComplexity),
+		}
+	}
+	return a, nil
+}
+
+// route returns the route of the operation, the top-level fields must
+// be in the same route, or none of them is in a route, as an operation
+// can't be split to multiple backends.
+func (g *GraphQL) route(a *analysis) (string, *rejection) {
+	if len(g.routes) == 0 {
+		return "", nil
+	}
+
+	route, first := "", true
+	for _, f := range a.rootFields {
+		if f == "__typename" {
+			continue
+		}
+		r := g.routes[routeKey(string(a.operation.Operation), f)]
+		if first {
+			route, first = r, false
+			continue
+		}
+		if r != route {
+			return "", invalidRequest("top-level fields are in different routes")
+		}
+	}
+	return route, nil
+}
+
+func (g *GraphQL) operationStat(op *ast.OperationDefinition) *httpstat.HTTPStat {
+	name := op.Name
+	if name == "" {
+		name = anonymousOperation
+	}
+	key := stringtool.Cat(string(op.Operation), " ", name)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if s, ok := g.operations[key]; ok {
+		return s
+	}
+	if len(g.operations) >= maxOperations {
+		key = otherOperations
+		if s, ok := g.operations[key]; ok {
+			return s
+		}
+	}
+	s := httpstat.New()
+	g.operations[key] = s
+	return s
+}
+
+func writeErrors(ctx context.HTTPContext, statusCode int, message string) {
+	buf, _ := json.Marshal(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+
+	w := ctx.Response()
+	w.SetStatusCode(statusCode)
+	w.Header().Set(httpheader.KeyContentType, "application/json")
+	w.SetBody(bytes.NewReader(buf))
+}
+
+// Status returns status.
+func (g *GraphQL) Status() interface{} {
+	s := &Status{
+		Requests:   atomic.LoadUint64(&g.requests),
+		Rejected:   atomic.LoadUint64(&g.rejected),
+		Operations: map[string]*httpstat.Status{},
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for key, stat := range g.operations {
+		s.Operations[key] = stat.Status()
+	}
+	return s
+}
+
+// Close closes GraphQL.
+func (g *GraphQL) Close() {
+}
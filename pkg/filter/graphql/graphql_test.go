@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const specYAML = `
+kind: GraphQL
+name: graphql
+maxDepth: 3
+maxComplexity: 50
+disableIntrospection: true
+routes:
+- name: users
+  fields: [user, users]
+- name: orders
+  fields: [orders]
+- name: users
+  operation: mutation
+  fields: [createUser]
+`
+
+// doRequest sends the request, the route header and the body received by
+// the next handler are returned.
+func doRequest(g *GraphQL, req *http.Request) (string, *httptest.ResponseRecorder, string, string) {
+	route, body := "", ""
+	result, w := httppipelinetest.Handle(g, req, func(ctx context.HTTPContext, lastResult string) string {
+		route = ctx.Request().Header().Get(defaultRouteHeader)
+		buf, _ := io.ReadAll(ctx.Request().Body())
+		body = string(buf)
+		return lastResult
+	})
+	return result, w, route, body
+}
+
+func postQuery(query string, variables map[string]interface{}) *http.Request {
+	buf, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(buf)))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []string{`
+kind: GraphQL
+name: graphql
+routes:
+- name: users
+  fields: [user]
+- name: accounts
+  fields: [user]
+`, `
+kind: GraphQL
+name: graphql
+persistedQueries:
+  queries: ["{ user {"]
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid: %s", spec)
+		}
+	}
+
+	// NOTE: The same route name in different operations is allowed.
+	httppipelinetest.InitFilter(t, &GraphQL{}, specYAML)
+}
+
+func TestAnalyze(t *testing.T) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: `
+query q($n: Int) {
+  users(first: $n) { id ...userFields }
+  ... on Query { __typename }
+}
+fragment userFields on User { name friends(first: 2) { id } }
+fragment a on User { ...b }
+fragment b on User { ...a }
+query cyclic { user { ...a } }
+`})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	a, e := analyze(doc, "q", map[string]interface{}{"n": json.Number("10")})
+	if e != nil {
+		t.Fatalf("analyze failed: %v", e)
+	}
+	if strings.Join(a.rootFields, ",") != "users,__typename" {
+		t.Errorf("unexpected root fields %v", a.rootFields)
+	}
+	if a.depth != 3 {
+		t.Errorf("depth should be 3, got %d", a.depth)
+	}
+	// users: 1 + 10*(id 1 + name 1 + friends (1 + 2*1)) = 51, __typename: 1
+	if a.complexity != 52 {
+		t.Errorf("complexity should be 52, got %d", a.complexity)
+	}
+
+	if _, e = analyze(doc, "cyclic", nil); e == nil {
+		t.Error("cyclic fragments should be rejected")
+	}
+	if _, e = analyze(doc, "", nil); e == nil {
+		t.Error("operation name should be required")
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	g := httppipelinetest.InitFilter(t, &GraphQL{}, specYAML).(*GraphQL)
+	defer g.Close()
+
+	result, _, route, body := doRequest(g, postQuery(`query getUser { user(id: 1) { name } }`, nil))
+	if result != "" || route != "users" || !strings.Contains(body, "getUser") {
+		t.Errorf("unexpected result %q, route %q, body %s", result, route, body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ orders { id } }`), nil)
+	req.Header.Set(defaultRouteHeader, "users")
+	if result, _, route, _ = doRequest(g, req); result != "" || route != "orders" {
+		t.Errorf("unexpected result %q, route %q", result, route)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`mutation { createUser(name: "a") { id } }`))
+	req.Header.Set("Content-Type", "application/graphql")
+	if result, _, route, _ = doRequest(g, req); result != "" || route != "users" {
+		t.Errorf("unexpected result %q, route %q", result, route)
+	}
+
+	if result, _, route, _ = doRequest(g, postQuery(`{ products { id } }`, nil)); result != "" || route != "" {
+		t.Errorf("unexpected result %q, route %q", result, route)
+	}
+
+	result, w, _, _ := doRequest(g, postQuery(`{ user(id: 1) { name } orders { id } }`, nil))
+	if result != resultInvalidRequest || w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("fields of different routes should be rejected, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	if result, w, _, _ = doRequest(g, postQuery(`{ user(id: 1) { friends { friends { name } } } }`, nil)); result != resultLimitExceeded {
+		t.Errorf("deep query should be rejected, got %q, %s", result, w.Body.String())
+	}
+	if result, w, _, _ = doRequest(g, postQuery(`query($n: Int) { users(first: $n) { name } }`, map[string]interface{}{"n": 100})); result != resultLimitExceeded {
+		t.Errorf("complex query should be rejected, got %q, %s", result, w.Body.String())
+	}
+	if result, _, _, _ = doRequest(g, postQuery(`{ __schema { types { name } } }`, nil)); result != resultNotAllowed {
+		t.Errorf("introspection should be rejected, got %q", result)
+	}
+	if result, _, _, _ = doRequest(g, postQuery(`{ user( }`, nil)); result != resultInvalidRequest {
+		t.Errorf("invalid query should be rejected, got %q", result)
+	}
+
+	s := g.Status().(*Status)
+	if s.Requests != 9 || s.Rejected != 5 || len(s.Operations) != 3 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if op := s.Operations["query getUser"]; op == nil || op.Count != 1 {
+		t.Errorf("unexpected statistics of getUser %+v", op)
+	}
+	if op := s.Operations["query <anonymous>"]; op == nil || op.Count != 2 {
+		t.Errorf("unexpected statistics of anonymous queries %+v", op)
+	}
+}
+
+func TestPersistedQueries(t *testing.T) {
+	const query = `query getUser { user(id: 1) { name } }`
+	g := httppipelinetest.InitFilter(t, &GraphQL{}, `
+kind: GraphQL
+name: graphql
+persistedQueries:
+  allowListOnly: true
+  queries:
+  - "`+query+`"
+`).(*GraphQL)
+	defer g.Close()
+
+	hash := hashQuery(query)
+	extensions := `{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"extensions":`+extensions+`,"variables":{"a":1}}`))
+	result, _, _, body := doRequest(g, req)
+	m := map[string]interface{}{}
+	json.Unmarshal([]byte(body), &m)
+	if result != "" || m["query"] != query || m["variables"] == nil {
+		t.Errorf("query should be filled, got %q, %s", result, body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/graphql?extensions="+url.QueryEscape(extensions), nil)
+	result, _ = httppipelinetest.Handle(g, req, func(ctx context.HTTPContext, lastResult string) string {
+		q, _ := url.ParseQuery(ctx.Request().Query())
+		if q.Get("query") != query {
+			t.Errorf("query should be filled, got %s", ctx.Request().Query())
+		}
+		return lastResult
+	})
+	if result != "" {
+		t.Errorf("unexpected result %q", result)
+	}
+
+	if result, _, _, _ = doRequest(g, postQuery(query, nil)); result != "" {
+		t.Errorf("allowed query should pass, got %q", result)
+	}
+	if result, _, _, _ = doRequest(g, postQuery(`{ users { name } }`, nil)); result != resultNotAllowed {
+		t.Errorf("query not in the allow list should be rejected, got %q", result)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"abc"}}}`))
+	result, w, _, _ := doRequest(g, req)
+	if result != resultNotAllowed || w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "PersistedQueryNotFound") {
+		t.Errorf("unknown hash should be rejected, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ users { name } }","extensions":`+extensions+`}`))
+	if result, _, _, _ = doRequest(g, req); result != resultInvalidRequest {
+		t.Errorf("mismatched hash should be rejected, got %q", result)
+	}
+}
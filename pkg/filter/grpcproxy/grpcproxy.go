@@ -0,0 +1,484 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of GRPCProxy.
+	Kind = "GRPCProxy"
+
+	resultNoRoute        = "noRoute"
+	resultInvalidRequest = "invalidRequest"
+	resultServerError    = "serverError"
+
+	contentTypeGRPC = "application/grpc"
+
+	// gRPC status codes used by the proxy.
+	codeUnimplemented = 12
+	codeUnavailable   = 14
+)
+
+var results = []string{resultNoRoute, resultInvalidRequest, resultServerError}
+
+// hopHeaders are the connection specific headers which must not be
+// sent over HTTP/2, and the headers of the original body.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Te",
+	"Content-Length",
+	"Content-Type",
+	"Accept-Encoding",
+}
+
+func init() {
+	httppipeline.Register(&GRPCProxy{})
+}
+
+type (
+	// GRPCProxy is filter GRPCProxy.
+	GRPCProxy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		h2cTransport *http2.Transport
+		tlsTransport *http2.Transport
+		transcoding  []*TranscodingRule
+	}
+
+	// Spec is the spec of GRPCProxy.
+	Spec struct {
+		Routes            []*Route           `yaml:"routes" jsonschema:"required,minItems=1"`
+		DescriptorSetFile string             `yaml:"descriptorSetFile" jsonschema:"omitempty"`
+		Transcoding       []*TranscodingRule `yaml:"transcoding" jsonschema:"omitempty"`
+	}
+
+	// Route routes gRPC methods to the backend servers.
+	Route struct {
+		// Service is the full name of the service, empty means all services.
+		Service string `yaml:"service" jsonschema:"omitempty"`
+		// Method is the name of the method, empty means all methods.
+		Method string `yaml:"method" jsonschema:"omitempty"`
+		// Servers are the URLs of the backend servers, the scheme
+		// 'http' means HTTP/2 over cleartext (h2c).
+		Servers []string `yaml:"servers" jsonschema:"required,minItems=1,uniqueItems=true"`
+
+		counter uint64
+	}
+
+	// trailerBody copies the trailers of the backend response to the
+	// client after the body is read to completion.
+	trailerBody struct {
+		resp   *http.Response
+		header http.Header
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, r := range spec.Routes {
+		for _, s := range r.Servers {
+			u, err := url.Parse(s)
+			if err != nil {
+				return fmt.Errorf("invalid server %s: %v", s, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return fmt.Errorf("invalid server %s: scheme must be http or https", s)
+			}
+		}
+	}
+
+	if len(spec.Transcoding) == 0 {
+		return nil
+	}
+	if spec.DescriptorSetFile == "" {
+		return fmt.Errorf("descriptorSetFile is required by transcoding")
+	}
+
+	files, err := loadDescriptors(spec.DescriptorSetFile)
+	if err != nil {
+		return fmt.Errorf("load %s failed: %v", spec.DescriptorSetFile, err)
+	}
+	for _, r := range spec.Transcoding {
+		rule := *r
+		if err = rule.init(files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Kind returns the kind of GRPCProxy.
+func (gp *GRPCProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of GRPCProxy.
+func (gp *GRPCProxy) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of GRPCProxy.
+func (gp *GRPCProxy) Description() string {
+	return "GRPCProxy proxies gRPC requests and transcodes REST requests to gRPC backends."
+}
+
+// Results returns the results of GRPCProxy.
+func (gp *GRPCProxy) Results() []string {
+	return results
+}
+
+// Init initializes GRPCProxy.
+func (gp *GRPCProxy) Init(filterSpec *httppipeline.FilterSpec) {
+	gp.filterSpec, gp.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	gp.reload()
+}
+
+// Inherit inherits previous generation of GRPCProxy.
+func (gp *GRPCProxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	gp.Init(filterSpec)
+}
+
+func (gp *GRPCProxy) reload() {
+	gp.h2cTransport = &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	gp.tlsTransport = &http2.Transport{}
+
+	if len(gp.spec.Transcoding) == 0 {
+		return
+	}
+
+	files, err := loadDescriptors(gp.spec.DescriptorSetFile)
+	if err != nil {
+		logger.Errorf("BUG: load %s failed: %v", gp.spec.DescriptorSetFile, err)
+		return
+	}
+	gp.initTranscoding(files)
+}
+
+func (gp *GRPCProxy) initTranscoding(files *protoregistry.Files) {
+	gp.transcoding = nil
+	for _, r := range gp.spec.Transcoding {
+		if err := r.init(files); err != nil {
+			logger.Errorf("BUG: init transcoding rule %s %s failed: %v", r.Method, r.Path, err)
+			continue
+		}
+		gp.transcoding = append(gp.transcoding, r)
+	}
+}
+
+func (r *Route) match(service, method string) bool {
+	if r.Service != "" && r.Service != service {
+		return false
+	}
+	return r.Method == "" || r.Method == method
+}
+
+func (r *Route) next() string {
+	n := atomic.AddUint64(&r.counter, 1)
+	return r.Servers[n%uint64(len(r.Servers))]
+}
+
+func (gp *GRPCProxy) route(service, method string) *Route {
+	for _, r := range gp.spec.Routes {
+		if r.match(service, method) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (gp *GRPCProxy) roundTrip(server string, req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(server, "https://") {
+		return gp.tlsTransport.RoundTrip(req)
+	}
+	return gp.h2cTransport.RoundTrip(req)
+}
+
+// splitMethod splits the gRPC path /package.Service/Method.
+func splitMethod(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
+func isGRPC(contentType string) bool {
+	return strings.HasPrefix(contentType, contentTypeGRPC)
+}
+
+func newBackendRequest(ctx context.HTTPContext, server, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The headers are passed to the backend as the metadata.
+	req.Header = ctx.Request().Header().Std().Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Content-Type", contentTypeGRPC)
+	req.Header.Set("Te", "trailers")
+
+	return req, nil
+}
+
+// Handle proxies the gRPC request, or transcodes the REST request.
+func (gp *GRPCProxy) Handle(ctx context.HTTPContext) string {
+	result := gp.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (gp *GRPCProxy) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if isGRPC(r.Header().Get("Content-Type")) {
+		return gp.proxy(ctx)
+	}
+
+	for _, rule := range gp.transcoding {
+		if vars, ok := rule.match(r.Method(), r.Path()); ok {
+			return gp.transcode(ctx, rule, vars)
+		}
+	}
+
+	ctx.Response().SetStatusCode(http.StatusNotFound)
+	return resultNoRoute
+}
+
+// setGRPCStatus sets the status of a trailers-only response.
+func setGRPCStatus(ctx context.HTTPContext, code int, message string) {
+	w := ctx.Response()
+	w.SetStatusCode(http.StatusOK)
+	w.Header().Set("Content-Type", contentTypeGRPC)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+}
+
+func (gp *GRPCProxy) proxy(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	service, method := splitMethod(r.Path())
+	route := gp.route(service, method)
+	if route == nil {
+		ctx.AddTag(stringtool.Cat("grpcproxy: no route for ", r.Path()))
+		setGRPCStatus(ctx, codeUnimplemented, "unknown service "+service)
+		return resultNoRoute
+	}
+
+	server := route.next()
+	ctx.AddTag(stringtool.Cat("grpcproxy#addr: ", server))
+
+	req, err := newBackendRequest(ctx, server, r.Path(), r.Body())
+	if err != nil {
+		logger.Errorf("BUG: new request failed: %v", err)
+		setGRPCStatus(ctx, codeUnavailable, err.Error())
+		return resultServerError
+	}
+
+	resp, err := gp.roundTrip(server, req)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("grpcproxy#doRequestErr: ", err.Error()))
+		setGRPCStatus(ctx, codeUnavailable, err.Error())
+		return resultServerError
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(resp.StatusCode)
+	w.Header().AddFromStd(resp.Header)
+	w.SetBody(&trailerBody{resp: resp, header: w.Std().Header()})
+
+	return ""
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	if err == io.EOF {
+		// NOTE: Trailers are sent to the client when the key has the
+		// prefix http.TrailerPrefix, and they are set before the
+		// handler of the HTTP server returns.
+		for k, values := range b.resp.Trailer {
+			for _, v := range values {
+				b.header.Add(http.TrailerPrefix+k, v)
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *trailerBody) Close() error {
+	return b.resp.Body.Close()
+}
+
+func writeJSONError(ctx context.HTTPContext, statusCode, code int, message string) {
+	buf, _ := json.Marshal(map[string]interface{}{
+		"code":    code,
+		"message": message,
+	})
+
+	w := ctx.Response()
+	w.SetStatusCode(statusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buf))
+}
+
+func (gp *GRPCProxy) transcode(ctx context.HTTPContext, rule *TranscodingRule, vars map[string]string) string {
+	r := ctx.Request()
+
+	var body []byte
+	if r.Body() != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body(), maxMessageSize+1))
+		if err != nil || len(body) > maxMessageSize {
+			writeJSONError(ctx, http.StatusBadRequest, 3, "read request body failed")
+			return resultInvalidRequest
+		}
+	}
+
+	query, err := url.ParseQuery(r.Query())
+	if err != nil {
+		writeJSONError(ctx, http.StatusBadRequest, 3, fmt.Sprintf("invalid query: %v", err))
+		return resultInvalidRequest
+	}
+
+	msg, err := rule.buildRequest(body, vars, query)
+	if err != nil {
+		writeJSONError(ctx, http.StatusBadRequest, 3, err.Error())
+		return resultInvalidRequest
+	}
+
+	frame, err := encodeFrame(msg)
+	if err != nil {
+		writeJSONError(ctx, http.StatusBadRequest, 3, err.Error())
+		return resultInvalidRequest
+	}
+
+	service, method := splitMethod(rule.GRPCMethod)
+	route := gp.route(service, method)
+	if route == nil {
+		writeJSONError(ctx, http.StatusNotImplemented, codeUnimplemented, "no route for "+rule.GRPCMethod)
+		return resultNoRoute
+	}
+
+	server := route.next()
+	ctx.AddTag(stringtool.Cat("grpcproxy#addr: ", server))
+
+	req, err := newBackendRequest(ctx, server, "/"+rule.GRPCMethod, bytes.NewReader(frame))
+	if err != nil {
+		logger.Errorf("BUG: new request failed: %v", err)
+		writeJSONError(ctx, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+		return resultServerError
+	}
+
+	resp, err := gp.roundTrip(server, req)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("grpcproxy#doRequestErr: ", err.Error()))
+		writeJSONError(ctx, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+		return resultServerError
+	}
+	defer resp.Body.Close()
+
+	data, readErr := decodeFrame(resp.Body)
+	// NOTE: The trailers are available after the body is read to completion.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		writeJSONError(ctx, http.StatusBadGateway, 2, fmt.Sprintf("invalid grpc status %q", status))
+		return resultServerError
+	}
+	if code != 0 {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		writeJSONError(ctx, httpStatusFromCode(code), code, message)
+		return ""
+	}
+
+	if readErr != nil {
+		writeJSONError(ctx, http.StatusBadGateway, 2, fmt.Sprintf("read response failed: %v", readErr))
+		return resultServerError
+	}
+
+	out := dynamicpb.NewMessage(rule.output)
+	if err = proto.Unmarshal(data, out); err != nil {
+		writeJSONError(ctx, http.StatusBadGateway, 2, fmt.Sprintf("unmarshal response failed: %v", err))
+		return resultServerError
+	}
+	buf, err := protojson.Marshal(out)
+	if err != nil {
+		writeJSONError(ctx, http.StatusBadGateway, 2, fmt.Sprintf("marshal response failed: %v", err))
+		return resultServerError
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buf))
+
+	return ""
+}
+
+// Status returns status.
+func (gp *GRPCProxy) Status() interface{} { return nil }
+
+// Close closes GRPCProxy.
+func (gp *GRPCProxy) Close() {
+	gp.h2cTransport.CloseIdleConnections()
+	gp.tlsTransport.CloseIdleConnections()
+}
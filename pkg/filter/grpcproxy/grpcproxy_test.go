@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcproxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+	}
+}
+
+// writeDescriptors writes the descriptors of below proto file.
+//
+//	package test;
+//	message HelloRequest { string name = 1; int32 times = 2; bool loud = 3; }
+//	message HelloReply { string message = 1; }
+//	service Greeter { rpc SayHello(HelloRequest) returns (HelloReply); }
+func writeDescriptors(t *testing.T) string {
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("HelloRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					field("times", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+					field("loud", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				},
+			},
+			{
+				Name: proto.String("HelloReply"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Greeter"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:       proto.String("SayHello"),
+						InputType:  proto.String(".test.HelloRequest"),
+						OutputType: proto.String(".test.HelloReply"),
+					},
+				},
+			},
+		},
+	}
+
+	buf, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{fd},
+	})
+	if err != nil {
+		t.Fatalf("marshal descriptors failed: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "test.pb")
+	if err = ioutil.WriteFile(file, buf, 0644); err != nil {
+		t.Fatalf("write descriptors failed: %v", err)
+	}
+	return file
+}
+
+// newBackend creates a h2c server which implements the Greeter service.
+func newBackend(t *testing.T, gp *GRPCProxy) *httptest.Server {
+	rule := gp.transcoding[0]
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test.Greeter/SayHello" || r.Header.Get("X-Meta") != "meta" {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+
+		data, err := decodeFrame(r.Body)
+		if err != nil {
+			t.Errorf("decode request failed: %v", err)
+			return
+		}
+		req := dynamicpb.NewMessage(rule.input)
+		if err = proto.Unmarshal(data, req); err != nil {
+			t.Errorf("unmarshal request failed: %v", err)
+			return
+		}
+
+		fields := rule.input.Fields()
+		name := req.Get(fields.ByName("name")).String()
+		if name == "nobody" {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "user%20not%20found")
+			return
+		}
+
+		msg := strings.Repeat("hello "+name+" ", int(req.Get(fields.ByName("times")).Int()))
+		if req.Get(fields.ByName("loud")).Bool() {
+			msg = strings.ToUpper(msg)
+		}
+		reply := dynamicpb.NewMessage(rule.output)
+		reply.Set(rule.output.Fields().ByName("message"), protoreflect.ValueOfString(strings.TrimSpace(msg)))
+
+		frame, _ := encodeFrame(reply)
+		w.Header().Set("Content-Type", contentTypeGRPC)
+		w.Write(frame)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newContext(method, path, query string, header http.Header, body []byte) (*contexttest.MockedHTTPContext, *bytes.Buffer, *int, *httptest.ResponseRecorder) {
+	reqHeader := httpheader.New(header)
+	rspHeader := httpheader.New(http.Header{})
+	rspBody := &bytes.Buffer{}
+	statusCode := 0
+	recorder := httptest.NewRecorder()
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedQuery = func() string { return query }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return bytes.NewReader(body) }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return rspHeader }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return recorder }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) {
+		rspBody.Reset()
+		io.Copy(rspBody, r)
+	}
+	return ctx, rspBody, &statusCode, recorder
+}
+
+func TestTranscoding(t *testing.T) {
+	file := writeDescriptors(t)
+	gp := httppipelinetest.InitFilter(t, &GRPCProxy{}, `
+kind: GRPCProxy
+name: grpc-proxy
+routes:
+- service: test.Greeter
+  servers: [http://127.0.0.1:1]
+descriptorSetFile: `+file+`
+transcoding:
+- method: GET
+  path: /v1/hello/{name}
+  grpcMethod: test.Greeter/SayHello
+- method: POST
+  path: /v1/hello
+  grpcMethod: test.Greeter/SayHello
+`).(*GRPCProxy)
+	defer gp.Close()
+
+	if len(gp.transcoding) != 2 {
+		t.Fatalf("there should be 2 transcoding rules, got %d", len(gp.transcoding))
+	}
+	backend := newBackend(t, gp)
+	gp.spec.Routes[0].Servers = []string{backend.URL}
+
+	header := http.Header{"X-Meta": []string{"meta"}}
+
+	ctx, body, code, _ := newContext(http.MethodGet, "/v1/hello/bob", "times=2&loud=true", header, nil)
+	if result := gp.Handle(ctx); result != "" {
+		t.Fatalf("result should be empty, got %s, body %s", result, body)
+	}
+	if *code != http.StatusOK || body.String() != `{"message":"HELLO BOB HELLO BOB"}` {
+		t.Errorf("unexpected response %d %s", *code, body)
+	}
+
+	ctx, body, code, _ = newContext(http.MethodPost, "/v1/hello", "", header, []byte(`{"name": "alice", "times": 1}`))
+	gp.Handle(ctx)
+	if *code != http.StatusOK || body.String() != `{"message":"hello alice"}` {
+		t.Errorf("unexpected response %d %s", *code, body)
+	}
+
+	ctx, body, code, _ = newContext(http.MethodGet, "/v1/hello/nobody", "", header, nil)
+	gp.Handle(ctx)
+	if *code != http.StatusNotFound || body.String() != `{"code":5,"message":"user not found"}` {
+		t.Errorf("unexpected response %d %s", *code, body)
+	}
+
+	ctx, _, code, _ = newContext(http.MethodPost, "/v1/hello", "", header, []byte(`{"name": 1`))
+	if result := gp.Handle(ctx); result != resultInvalidRequest || *code != http.StatusBadRequest {
+		t.Errorf("invalid request should be rejected, got %s %d", result, *code)
+	}
+
+	ctx, _, code, _ = newContext(http.MethodGet, "/v1/other", "", header, nil)
+	if result := gp.Handle(ctx); result != resultNoRoute || *code != http.StatusNotFound {
+		t.Errorf("request without route should be rejected, got %s %d", result, *code)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	file := writeDescriptors(t)
+	gp := httppipelinetest.InitFilter(t, &GRPCProxy{}, `
+kind: GRPCProxy
+name: grpc-proxy
+routes:
+- service: test.Greeter
+  method: SayHello
+  servers: [http://127.0.0.1:1]
+descriptorSetFile: `+file+`
+transcoding:
+- method: GET
+  path: /v1/hello/{name}
+  grpcMethod: test.Greeter/SayHello
+`).(*GRPCProxy)
+	defer gp.Close()
+
+	backend := newBackend(t, gp)
+	gp.spec.Routes[0].Servers = []string{backend.URL}
+
+	rule := gp.transcoding[0]
+	req := dynamicpb.NewMessage(rule.input)
+	req.Set(rule.input.Fields().ByName("name"), protoreflect.ValueOfString("bob"))
+	req.Set(rule.input.Fields().ByName("times"), protoreflect.ValueOfInt32(1))
+	frame, _ := encodeFrame(req)
+
+	header := http.Header{"Content-Type": []string{contentTypeGRPC}, "X-Meta": []string{"meta"}}
+	ctx, body, code, recorder := newContext(http.MethodPost, "/test.Greeter/SayHello", "", header, frame)
+	if result := gp.Handle(ctx); result != "" {
+		t.Fatalf("result should be empty, got %s", result)
+	}
+	if *code != http.StatusOK {
+		t.Errorf("status code should be 200, got %d", *code)
+	}
+
+	data, err := decodeFrame(body)
+	if err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	reply := dynamicpb.NewMessage(rule.output)
+	proto.Unmarshal(data, reply)
+	if msg := reply.Get(rule.output.Fields().ByName("message")).String(); msg != "hello bob" {
+		t.Errorf("message should be 'hello bob', got %s", msg)
+	}
+	if status := recorder.Header().Get(http.TrailerPrefix + "Grpc-Status"); status != "0" {
+		t.Errorf("trailer grpc-status should be 0, got %q", status)
+	}
+
+	ctx, _, _, _ = newContext(http.MethodPost, "/test.Other/Method", "", header, frame)
+	if result := gp.Handle(ctx); result != resultNoRoute {
+		t.Errorf("result should be %s, got %s", resultNoRoute, result)
+	}
+	if status := ctx.Response().Header().Get("Grpc-Status"); status != "12" {
+		t.Errorf("grpc-status should be 12, got %q", status)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, yamlSpec := range []string{`
+kind: GRPCProxy
+name: grpc-proxy
+routes:
+- servers: [tcp://127.0.0.1:9090]
+`, `
+kind: GRPCProxy
+name: grpc-proxy
+routes:
+- servers: [http://127.0.0.1:9090]
+transcoding:
+- method: GET
+  path: /v1/hello/{name}
+  grpcMethod: test.Greeter/SayHello
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+			t.Errorf("spec should be invalid:\n%s", yamlSpec)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	r := &TranscodingRule{Method: http.MethodGet, Path: "/v1/users/{id}/books/{book.name}"}
+	r.segments = strings.Split(strings.Trim(r.Path, "/"), "/")
+
+	vars, ok := r.match(http.MethodGet, "/v1/users/7/books/go%20book")
+	if !ok || vars["id"] != "7" || vars["book.name"] != "go book" {
+		t.Errorf("unexpected match result %v %v", ok, vars)
+	}
+	if _, ok = r.match(http.MethodPost, "/v1/users/7/books/go"); ok {
+		t.Error("method should not match")
+	}
+	if _, ok = r.match(http.MethodGet, "/v1/users/7"); ok {
+		t.Error("path should not match")
+	}
+}
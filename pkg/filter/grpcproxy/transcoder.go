@@ -0,0 +1,312 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// The gRPC message is prefixed by 1 byte compressed flag
+	// and 4 bytes message length.
+	// Reference: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+	frameHeaderLen = 5
+
+	// maxMessageSize is the maximum size of the messages to transcode.
+	maxMessageSize = 4 << 20
+)
+
+type (
+	// TranscodingRule maps a REST endpoint to a gRPC method.
+	TranscodingRule struct {
+		Method string `yaml:"method" jsonschema:"required,format=httpmethod"`
+		// Path is the path template, e.g. /v1/users/{id}, the
+		// variables are the fields of the request message.
+		Path string `yaml:"path" jsonschema:"required,pattern=^/"`
+		// GRPCMethod is the full name of the gRPC method, in the
+		// form of package.Service/Method.
+		GRPCMethod string `yaml:"grpcMethod" jsonschema:"required,pattern=^[^/]+/[^/]+$"`
+
+		segments []string
+		input    protoreflect.MessageDescriptor
+		output   protoreflect.MessageDescriptor
+	}
+)
+
+// loadDescriptors loads the file descriptor set generated by
+// 'protoc --include_imports --descriptor_set_out'.
+func loadDescriptors(file string) (*protoregistry.Files, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(buf, fds); err != nil {
+		return nil, fmt.Errorf("unmarshal file descriptor set failed: %v", err)
+	}
+
+	return protodesc.NewFiles(fds)
+}
+
+func (r *TranscodingRule) init(files *protoregistry.Files) error {
+	r.segments = strings.Split(strings.Trim(r.Path, "/"), "/")
+
+	name := strings.Replace(r.GRPCMethod, "/", ".", 1)
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("find method %s failed: %v", r.GRPCMethod, err)
+	}
+
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a method", r.GRPCMethod)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return fmt.Errorf("streaming method %s can not be transcoded", r.GRPCMethod)
+	}
+
+	r.input, r.output = md.Input(), md.Output()
+	return nil
+}
+
+// match matches the request to the rule, and returns the values of
+// the path variables.
+func (r *TranscodingRule) match(method, path string) (map[string]string, bool) {
+	if method != r.Method {
+		return nil, false
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	vars := map[string]string{}
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			v, err := url.PathUnescape(segments[i])
+			if err != nil {
+				return nil, false
+			}
+			vars[s[1:len(s)-1]] = v
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+
+	return vars, true
+}
+
+// buildRequest builds the request message from the JSON body, the path
+// variables and the query parameters, the latter two take precedence.
+func (r *TranscodingRule) buildRequest(body []byte, vars map[string]string, query url.Values) (proto.Message, error) {
+	fields := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) != 0 {
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, fmt.Errorf("invalid json body: %v", err)
+		}
+	}
+
+	setField := func(path, value string) error {
+		v, err := fieldValue(r.input, path, value)
+		if err != nil {
+			return err
+		}
+		setPath(fields, path, v)
+		return nil
+	}
+
+	for k, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(k, values[0]); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range vars {
+		if err := setField(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dynamicpb.NewMessage(r.input)
+	opts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	if err = opts.Unmarshal(buf, msg); err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	return msg, nil
+}
+
+// fieldValue converts the string value to the JSON value of the field
+// at the dot separated path of the message.
+func fieldValue(md protoreflect.MessageDescriptor, path, value string) (interface{}, error) {
+	names := strings.Split(path, ".")
+
+	var fd protoreflect.FieldDescriptor
+	for i, name := range names {
+		fd = md.Fields().ByJSONName(name)
+		if fd == nil {
+			fd = md.Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("field %s not found in %s", path, md.FullName())
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil {
+				return nil, fmt.Errorf("field %s of %s is not a message", name, md.FullName())
+			}
+			md = fd.Message()
+		}
+	}
+
+	if fd.IsList() {
+		return []interface{}{scalarValue(fd, value)}, nil
+	}
+	return scalarValue(fd, value), nil
+}
+
+func scalarValue(fd protoreflect.FieldDescriptor, value string) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.FloatKind, protoreflect.DoubleKind:
+		return json.Number(value)
+	}
+
+	// NOTE: 64-bit integers, strings, bytes and enums are all encoded
+	// as JSON strings.
+	return value
+}
+
+func setPath(m map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[k] = child
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+func encodeFrame(msg proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, frameHeaderLen+len(data))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+	copy(buf[frameHeaderLen:], data)
+	return buf, nil
+}
+
+// decodeFrame reads the first message of the body.
+func decodeFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed message is not supported")
+	}
+
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("message size %d exceeds %d", n, maxMessageSize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// httpStatusFromCode converts gRPC status code to HTTP status code.
+// Reference: https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func httpStatusFromCode(code int) int {
+	switch code {
+	case 0:
+		return http.StatusOK
+	case 1:
+		return 499 // Client Closed Request
+	case 2:
+		return http.StatusInternalServerError
+	case 3:
+		return http.StatusBadRequest
+	case 4:
+		return http.StatusGatewayTimeout
+	case 5:
+		return http.StatusNotFound
+	case 6:
+		return http.StatusConflict
+	case 7:
+		return http.StatusForbidden
+	case 8:
+		return http.StatusTooManyRequests
+	case 9:
+		return http.StatusBadRequest
+	case 10:
+		return http.StatusConflict
+	case 11:
+		return http.StatusBadRequest
+	case 12:
+		return http.StatusNotImplemented
+	case 13:
+		return http.StatusInternalServerError
+	case 14:
+		return http.StatusServiceUnavailable
+	case 15:
+		return http.StatusInternalServerError
+	case 16:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
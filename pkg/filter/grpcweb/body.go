@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// trailerFlag is the flag of the frame which carries the trailers.
+	// Reference: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+	trailerFlag = 0x80
+
+	bodyChunkSize = 32 * 1024
+)
+
+// webBody converts the gRPC response body to gRPC-Web response body,
+// which encodes the trailers in the body.
+type webBody struct {
+	src    io.Reader
+	text   bool
+	header http.Header
+
+	buf  bytes.Buffer
+	done bool
+}
+
+func newWebBody(src io.Reader, text bool, header http.Header) *webBody {
+	return &webBody{src: src, text: text, header: header}
+}
+
+func (b *webBody) Read(p []byte) (int, error) {
+	for b.buf.Len() == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		b.fill()
+	}
+	return b.buf.Read(p)
+}
+
+func (b *webBody) fill() {
+	var err error
+	if b.src != nil {
+		chunk := make([]byte, bodyChunkSize)
+		var n int
+		n, err = b.src.Read(chunk)
+		if n > 0 {
+			b.write(chunk[:n])
+		}
+	} else {
+		err = io.EOF
+	}
+
+	if err == nil {
+		return
+	}
+
+	// NOTE: The trailers are available only after the body of the
+	// backend response is read to completion.
+	b.done = true
+	if frame := b.trailerFrame(); frame != nil {
+		b.write(frame)
+	}
+}
+
+func (b *webBody) write(data []byte) {
+	if !b.text {
+		b.buf.Write(data)
+		return
+	}
+
+	// NOTE: Every chunk is encoded with padding separately, which is
+	// allowed by the gRPC-Web protocol.
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(encoded, data)
+	b.buf.Write(encoded)
+}
+
+// trailerFrame moves the trailers out of the header, and encodes them
+// to a frame.
+func (b *webBody) trailerFrame() []byte {
+	var lines []string
+	for k, values := range b.header {
+		if !strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(k, http.TrailerPrefix))
+		for _, v := range values {
+			lines = append(lines, name+": "+v+"\r\n")
+		}
+		delete(b.header, k)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	sort.Strings(lines)
+	payload := strings.Join(lines, "")
+
+	frame := make([]byte, 5+len(payload))
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return frame
+}
+
+func (b *webBody) Close() error {
+	if c, ok := b.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// decodeText decodes the body of the gRPC-Web text requests, the body
+// may be concatenated by several base64 encoded chunks with padding.
+func decodeText(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+
+	var out []byte
+	for len(data) > 0 {
+		n := len(data)
+		if i := bytes.IndexByte(data, '='); i >= 0 {
+			n = i
+			for n < len(data) && data[n] == '=' {
+				n++
+			}
+		}
+
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(n))
+		m, err := base64.StdEncoding.Decode(decoded, data[:n])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, decoded[:m]...)
+		data = data[n:]
+	}
+
+	return out, nil
+}
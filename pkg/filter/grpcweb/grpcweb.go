@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rs/cors"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of GRPCWeb.
+	Kind = "GRPCWeb"
+
+	resultPreflighted    = "preflighted"
+	resultInvalidRequest = "invalidRequest"
+
+	contentTypeGRPC    = "application/grpc"
+	contentTypeWeb     = "application/grpc-web"
+	contentTypeWebText = "application/grpc-web-text"
+)
+
+var results = []string{resultPreflighted, resultInvalidRequest}
+
+var (
+	defaultAllowedHeaders = []string{
+		"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout", "Authorization",
+	}
+	defaultExposedHeaders = []string{
+		"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin",
+	}
+)
+
+func init() {
+	httppipeline.Register(&GRPCWeb{})
+}
+
+type (
+	// GRPCWeb is filter GRPCWeb.
+	GRPCWeb struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		cors *cors.Cors
+	}
+
+	// Spec is the spec of GRPCWeb.
+	Spec struct {
+		AllowedOrigins   []string `yaml:"allowedOrigins" jsonschema:"omitempty"`
+		AllowedHeaders   []string `yaml:"allowedHeaders" jsonschema:"omitempty"`
+		ExposedHeaders   []string `yaml:"exposedHeaders" jsonschema:"omitempty"`
+		AllowCredentials bool     `yaml:"allowCredentials" jsonschema:"omitempty"`
+		MaxAge           int      `yaml:"maxAge" jsonschema:"omitempty,minimum=0"`
+	}
+)
+
+// Kind returns the kind of GRPCWeb.
+func (gw *GRPCWeb) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of GRPCWeb.
+func (gw *GRPCWeb) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of GRPCWeb.
+func (gw *GRPCWeb) Description() string {
+	return "GRPCWeb translates gRPC-Web requests to gRPC requests."
+}
+
+// Results returns the results of GRPCWeb.
+func (gw *GRPCWeb) Results() []string {
+	return results
+}
+
+// Init initializes GRPCWeb.
+func (gw *GRPCWeb) Init(filterSpec *httppipeline.FilterSpec) {
+	gw.filterSpec, gw.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	gw.reload()
+}
+
+// Inherit inherits previous generation of GRPCWeb.
+func (gw *GRPCWeb) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	gw.Init(filterSpec)
+}
+
+func (gw *GRPCWeb) reload() {
+	gw.cors = cors.New(cors.Options{
+		AllowedOrigins:   gw.spec.AllowedOrigins,
+		AllowedMethods:   []string{http.MethodPost},
+		AllowedHeaders:   append(defaultAllowedHeaders, gw.spec.AllowedHeaders...),
+		ExposedHeaders:   append(defaultExposedHeaders, gw.spec.ExposedHeaders...),
+		AllowCredentials: gw.spec.AllowCredentials,
+		MaxAge:           gw.spec.MaxAge,
+	})
+}
+
+func isWebText(contentType string) bool {
+	return strings.HasPrefix(contentType, contentTypeWebText)
+}
+
+func isWeb(contentType string) bool {
+	return strings.HasPrefix(contentType, contentTypeWeb)
+}
+
+// Handle translates the gRPC-Web request to gRPC request, calls the
+// following handlers and then translates the response back.
+func (gw *GRPCWeb) Handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	if r.Method() == http.MethodOptions && r.Header().Get("Access-Control-Request-Method") != "" {
+		gw.cors.HandlerFunc(w.Std(), r.Std())
+		return ctx.CallNextHandler(resultPreflighted)
+	}
+
+	contentType := r.Header().Get(httpheader.KeyContentType)
+	if !isWeb(contentType) {
+		return ctx.CallNextHandler("")
+	}
+
+	gw.cors.HandlerFunc(w.Std(), r.Std())
+
+	text := isWebText(contentType)
+	if text {
+		body, err := ioutil.ReadAll(r.Body())
+		if err == nil {
+			body, err = decodeText(body)
+		}
+		if err != nil {
+			ctx.AddTag("grpcweb: invalid text request: " + err.Error())
+			w.SetStatusCode(http.StatusBadRequest)
+			return ctx.CallNextHandler(resultInvalidRequest)
+		}
+		r.SetBody(bytes.NewReader(body))
+		contentType = contentTypeGRPC + strings.TrimPrefix(contentType, contentTypeWebText)
+	} else {
+		contentType = contentTypeGRPC + strings.TrimPrefix(contentType, contentTypeWeb)
+	}
+
+	r.Header().Set(httpheader.KeyContentType, contentType)
+	r.Header().Del(httpheader.KeyContentLength)
+	r.Header().Set("Te", "trailers")
+
+	result := ctx.CallNextHandler("")
+
+	gw.translateResponse(ctx, text)
+
+	return result
+}
+
+func (gw *GRPCWeb) translateResponse(ctx context.HTTPContext, text bool) {
+	w := ctx.Response()
+	h := w.Header()
+
+	contentType := h.Get(httpheader.KeyContentType)
+	if !strings.HasPrefix(contentType, contentTypeGRPC) {
+		logger.Debugf("grpcweb: response of content type %q is not translated", contentType)
+		return
+	}
+
+	suffix := strings.TrimPrefix(contentType, contentTypeGRPC)
+	if text {
+		h.Set(httpheader.KeyContentType, contentTypeWebText+suffix)
+	} else {
+		h.Set(httpheader.KeyContentType, contentTypeWeb+suffix)
+	}
+	h.Del(httpheader.KeyContentLength)
+
+	w.SetBody(newWebBody(w.Body(), text, w.Std().Header()))
+}
+
+// Status returns status.
+func (gw *GRPCWeb) Status() interface{} { return nil }
+
+// Close closes GRPCWeb.
+func (gw *GRPCWeb) Close() {}
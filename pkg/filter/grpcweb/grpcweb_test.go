@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newGRPCWeb(t *testing.T) *GRPCWeb {
+	return httppipelinetest.InitFilter(t, &GRPCWeb{}, `
+kind: GRPCWeb
+name: grpc-web
+allowedOrigins: [https://example.com]
+allowedHeaders: [X-Custom]
+`).(*GRPCWeb)
+}
+
+// backendBody simulates the body of a gRPC response, the trailers are
+// set to the header after the body is read to completion.
+type backendBody struct {
+	io.Reader
+	header http.Header
+}
+
+func (b *backendBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.header.Set(http.TrailerPrefix+"Grpc-Status", "0")
+		b.header.Set(http.TrailerPrefix+"Grpc-Message", "OK")
+	}
+	return n, err
+}
+
+func newContext(t *testing.T, method, contentType string, body []byte) (*contexttest.MockedHTTPContext, *httptest.ResponseRecorder, *io.Reader) {
+	stdr, _ := http.NewRequest(method, "https://gateway.example.com/test.Greeter/SayHello", nil)
+	stdr.Header.Set("Origin", "https://example.com")
+	if contentType != "" {
+		stdr.Header.Set("Content-Type", contentType)
+	}
+	if method == http.MethodOptions {
+		stdr.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		stdr.Header.Set("Access-Control-Request-Headers", "x-grpc-web, x-custom")
+	}
+
+	recorder := httptest.NewRecorder()
+	reqHeader := httpheader.New(stdr.Header)
+	rspHeader := httpheader.New(recorder.Header())
+	var reqBody io.Reader = bytes.NewReader(body)
+	var rspBody io.Reader
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedStd = func() *http.Request { return stdr }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return reqBody }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { reqBody = r }
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return recorder }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return rspHeader }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return rspBody }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) { rspBody = r }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+
+		if ct := reqHeader.Get("Content-Type"); ct != "application/grpc+proto" {
+			t.Errorf("content type of request should be application/grpc+proto, got %s", ct)
+		}
+		buf, _ := ioutil.ReadAll(reqBody)
+		if string(buf) != "\x00\x00\x00\x00\x03bob" {
+			t.Errorf("unexpected request body %q", buf)
+		}
+
+		rspHeader.Set("Content-Type", "application/grpc+proto")
+		rspBody = &backendBody{
+			Reader: strings.NewReader("\x00\x00\x00\x00\x05hello"),
+			header: recorder.Header(),
+		}
+		return ""
+	}
+
+	return ctx, recorder, &rspBody
+}
+
+const trailers = "\x80\x00\x00\x00\x22grpc-message: OK\r\ngrpc-status: 0\r\n"
+
+func TestBinary(t *testing.T) {
+	gw := newGRPCWeb(t)
+
+	ctx, recorder, rspBody := newContext(t, http.MethodPost, "application/grpc-web+proto", []byte("\x00\x00\x00\x00\x03bob"))
+	if result := gw.Handle(ctx); result != "" {
+		t.Fatalf("result should be empty, got %s", result)
+	}
+
+	h := recorder.Header()
+	if ct := h.Get("Content-Type"); ct != "application/grpc-web+proto" {
+		t.Errorf("content type should be application/grpc-web+proto, got %s", ct)
+	}
+	if origin := h.Get("Access-Control-Allow-Origin"); origin != "https://example.com" {
+		t.Errorf("allowed origin should be https://example.com, got %s", origin)
+	}
+	if exposed := h.Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "Grpc-Status") {
+		t.Errorf("grpc-status should be exposed, got %s", exposed)
+	}
+
+	buf, _ := ioutil.ReadAll(*rspBody)
+	if expected := "\x00\x00\x00\x00\x05hello" + trailers; string(buf) != expected {
+		t.Errorf("response body should be %q, got %q", expected, buf)
+	}
+	for k := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			t.Errorf("trailer %s should be removed from header", k)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	gw := newGRPCWeb(t)
+
+	body := base64.StdEncoding.EncodeToString([]byte("\x00\x00\x00\x00\x03bob"))
+	ctx, recorder, rspBody := newContext(t, http.MethodPost, "application/grpc-web-text+proto", []byte(body))
+	gw.Handle(ctx)
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/grpc-web-text+proto" {
+		t.Errorf("content type should be application/grpc-web-text+proto, got %s", ct)
+	}
+
+	buf, _ := ioutil.ReadAll(*rspBody)
+	decoded, err := decodeText(buf)
+	if err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if expected := "\x00\x00\x00\x00\x05hello" + trailers; string(decoded) != expected {
+		t.Errorf("response body should be %q, got %q", expected, decoded)
+	}
+
+	ctx, _, _ = newContext(t, http.MethodPost, "application/grpc-web-text", []byte("!!!"))
+	if result := gw.Handle(ctx); result != resultInvalidRequest {
+		t.Errorf("result should be %s, got %s", resultInvalidRequest, result)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	gw := newGRPCWeb(t)
+
+	ctx, recorder, _ := newContext(t, http.MethodOptions, "", nil)
+	if result := gw.Handle(ctx); result != resultPreflighted {
+		t.Fatalf("result should be %s, got %s", resultPreflighted, result)
+	}
+
+	h := recorder.Header()
+	if origin := h.Get("Access-Control-Allow-Origin"); origin != "https://example.com" {
+		t.Errorf("allowed origin should be https://example.com, got %s", origin)
+	}
+	if headers := h.Get("Access-Control-Allow-Headers"); !strings.Contains(headers, "X-Custom") {
+		t.Errorf("allowed headers should contain X-Custom, got %s", headers)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	chunks := base64.StdEncoding.EncodeToString([]byte("a")) +
+		base64.StdEncoding.EncodeToString([]byte("bc")) +
+		base64.StdEncoding.EncodeToString([]byte("def"))
+
+	decoded, err := decodeText([]byte(chunks))
+	if err != nil || string(decoded) != "abcdef" {
+		t.Errorf("decoded should be abcdef, got %q %v", decoded, err)
+	}
+}
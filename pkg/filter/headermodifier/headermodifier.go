@@ -0,0 +1,301 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package headermodifier
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/expr"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+)
+
+const (
+	// Kind is the kind of HeaderModifier.
+	Kind = "HeaderModifier"
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&HeaderModifier{})
+}
+
+type (
+	// HeaderModifier is filter HeaderModifier.
+	HeaderModifier struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec is the spec of HeaderModifier.
+	Spec struct {
+		Request  *ModifySpec `yaml:"request" jsonschema:"omitempty"`
+		Response *ModifySpec `yaml:"response" jsonschema:"omitempty"`
+	}
+
+	// ModifySpec describes how to modify the headers, the headers
+	// are deleted, set, added, set by expressions and rewritten in order.
+	ModifySpec struct {
+		httpheader.AdaptSpec `yaml:",inline"`
+		// SetExpr sets the headers to the values of the expressions, the
+		// headers are removed if the values are empty.
+		SetExpr map[string]string `yaml:"setExpr" jsonschema:"omitempty"`
+		Rewrite []*RewriteRule    `yaml:"rewrite" jsonschema:"omitempty"`
+		exprs   map[string]*expr.Expr
+	}
+
+	// RewriteRule rewrites the values of a header by regular expression.
+	RewriteRule struct {
+		Name    string `yaml:"name" jsonschema:"required"`
+		Regex   string `yaml:"regex" jsonschema:"required,format=regexp"`
+		Replace string `yaml:"replace" jsonschema:"omitempty"`
+		re      *regexp.Regexp
+	}
+
+	// variables resolves the variables in the header names and values.
+	variables struct {
+		ctx      context.HTTPContext
+		response bool
+	}
+)
+
+// Validate validates the ModifySpec.
+func (spec ModifySpec) Validate() error {
+	for name, source := range spec.SetExpr {
+		if _, err := expr.Compile(source); err != nil {
+			return fmt.Errorf("invalid expression of header %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of HeaderModifier.
+func (hm *HeaderModifier) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of HeaderModifier.
+func (hm *HeaderModifier) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of HeaderModifier.
+func (hm *HeaderModifier) Description() string {
+	return "HeaderModifier modifies headers of request and response."
+}
+
+// Results returns the results of HeaderModifier.
+func (hm *HeaderModifier) Results() []string {
+	return results
+}
+
+// Init initializes HeaderModifier.
+func (hm *HeaderModifier) Init(filterSpec *httppipeline.FilterSpec) {
+	hm.filterSpec, hm.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	hm.reload()
+}
+
+// Inherit inherits previous generation of HeaderModifier.
+func (hm *HeaderModifier) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	hm.Init(filterSpec)
+}
+
+func (hm *HeaderModifier) reload() {
+	for _, spec := range []*ModifySpec{hm.spec.Request, hm.spec.Response} {
+		if spec == nil {
+			continue
+		}
+		for _, r := range spec.Rewrite {
+			// NOTE: The regular expression has been checked by the format
+			// validation of the spec.
+			r.re = regexp.MustCompile(r.Regex)
+		}
+		spec.exprs = make(map[string]*expr.Expr, len(spec.SetExpr))
+		for name, source := range spec.SetExpr {
+			spec.exprs[name] = expr.MustCompile(source)
+		}
+	}
+}
+
+// Handle modifies the request headers, calls the following handlers
+// and then modifies the response headers.
+func (hm *HeaderModifier) Handle(ctx context.HTTPContext) string {
+	if hm.spec.Request != nil {
+		v := &variables{ctx: ctx}
+		hm.spec.Request.modify(ctx.Request().Header(), v, ctx.Template())
+	}
+
+	result := ctx.CallNextHandler("")
+
+	if hm.spec.Response != nil {
+		v := &variables{ctx: ctx, response: true}
+		hm.spec.Response.modify(ctx.Response().Header(), v, ctx.Template())
+	}
+
+	return result
+}
+
+func (spec *ModifySpec) modify(h *httpheader.HTTPHeader, v *variables, hte texttemplate.TemplateEngine) {
+	render := func(s string) string {
+		s = expand(s, v.lookup)
+		if hte != nil && hte.HasTemplates(s) {
+			if rendered, err := hte.Render(s); err == nil {
+				s = rendered
+			}
+		}
+		return s
+	}
+
+	for _, key := range spec.Del {
+		h.Del(render(key))
+	}
+	for key, value := range spec.Set {
+		h.Set(render(key), render(value))
+	}
+	for key, value := range spec.Add {
+		h.Add(render(key), render(value))
+	}
+	for name, e := range spec.exprs {
+		var resp context.HTTPResponse
+		if v.response {
+			resp = v.ctx.Response()
+		}
+		value, err := e.EvalString(v.ctx.Request(), resp)
+		if err != nil {
+			logger.Warnf("evaluate expr %s of header %s failed: %v", e, name, err)
+			continue
+		}
+		if value == "" {
+			h.Del(name)
+		} else {
+			h.Set(name, value)
+		}
+	}
+
+	for _, r := range spec.Rewrite {
+		values := h.GetAll(r.Name)
+		if len(values) == 0 {
+			continue
+		}
+
+		h.Del(r.Name)
+		for _, value := range values {
+			h.Add(r.Name, r.re.ReplaceAllString(value, render(r.Replace)))
+		}
+	}
+}
+
+// expand replaces the variables in the form of '${name}' in s with
+// the values returned by lookup.
+func expand(s string, lookup func(name string) string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+
+	buf := &strings.Builder{}
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		buf.WriteString(s[:start])
+		buf.WriteString(lookup(s[start+2 : end]))
+		s = s[end+1:]
+	}
+	buf.WriteString(s)
+
+	return buf.String()
+}
+
+func (v *variables) lookup(name string) string {
+	r := v.ctx.Request()
+
+	switch name {
+	case "method":
+		return r.Method()
+	case "scheme":
+		return r.Scheme()
+	case "host":
+		return r.Host()
+	case "path":
+		return r.Path()
+	case "query":
+		return r.Query()
+	case "clientIP":
+		return r.RealIP()
+	case "statusCode":
+		if v.response {
+			return strconv.Itoa(v.ctx.Response().StatusCode())
+		}
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(name, "header."):
+		name = strings.TrimPrefix(name, "header.")
+		if v.response {
+			return v.ctx.Response().Header().Get(name)
+		}
+		return r.Header().Get(name)
+	case strings.HasPrefix(name, "req.header."):
+		return r.Header().Get(strings.TrimPrefix(name, "req.header."))
+	case strings.HasPrefix(name, "rsp.header."):
+		if v.response {
+			return v.ctx.Response().Header().Get(strings.TrimPrefix(name, "rsp.header."))
+		}
+		return ""
+	case strings.HasPrefix(name, "path."):
+		return pathSegment(r.Path(), strings.TrimPrefix(name, "path."))
+	}
+
+	return ""
+}
+
+// pathSegment returns the segment of path at the 1-based index.
+func pathSegment(path, index string) string {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 1 {
+		return ""
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if i > len(segments) {
+		return ""
+	}
+	return segments[i-1]
+}
+
+// Status returns status.
+func (hm *HeaderModifier) Status() interface{} { return nil }
+
+// Close closes HeaderModifier.
+func (hm *HeaderModifier) Close() {}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package headermodifier
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestExpand(t *testing.T) {
+	lookup := func(name string) string {
+		return "<" + name + ">"
+	}
+
+	cases := map[string]string{
+		"":                "",
+		"abc":             "abc",
+		"${a}":            "<a>",
+		"x-${a}-${b.c}-y": "x-<a>-<b.c>-y",
+		"${a":             "${a",
+		"$a}":             "$a}",
+		"${a}${":          "<a>${",
+		"{{${header.X}}}": "{{<header.X>}}",
+	}
+	for s, expected := range cases {
+		if got := expand(s, lookup); got != expected {
+			t.Errorf("expand %q should be %q, got %q", s, expected, got)
+		}
+	}
+}
+
+func TestPathSegment(t *testing.T) {
+	path := "/api/v1/users/"
+	cases := map[string]string{
+		"1": "api",
+		"3": "users",
+		"4": "",
+		"0": "",
+		"a": "",
+	}
+	for index, expected := range cases {
+		if got := pathSegment(path, index); got != expected {
+			t.Errorf("segment %s should be %q, got %q", index, expected, got)
+		}
+	}
+}
+
+func TestHeaderModifier(t *testing.T) {
+	hm := httppipelinetest.InitFilter(t, &HeaderModifier{}, `
+kind: HeaderModifier
+name: header-modifier
+request:
+  del: [X-Internal]
+  set:
+    X-User: ${header.X-Auth-User}
+    X-Tenant: ${path.2}
+    X-Route: ${method} ${path}
+  add:
+    X-Forwarded-For: ${clientIP}
+  rewrite:
+  - name: Authorization
+    regex: '^Token (.*)$'
+    replace: 'Bearer $1'
+response:
+  set:
+    X-Status: ${statusCode}
+    X-Request-User: ${req.header.X-User}
+  rewrite:
+  - name: Location
+    regex: '^http://'
+    replace: 'https://'
+`).(*HeaderModifier)
+
+	reqHeader := httpheader.New(http.Header{})
+	reqHeader.Set("X-Internal", "secret")
+	reqHeader.Set("X-Auth-User", "alice")
+	reqHeader.Set("Authorization", "Token abc")
+	reqHeader.Add("X-Forwarded-For", "10.0.0.1")
+	rspHeader := httpheader.New(http.Header{})
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedPath = func() string { return "/tenants/megaease/orders" }
+	ctx.MockedRequest.MockedRealIP = func() string { return "192.168.1.1" }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return rspHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return http.StatusFound }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		rspHeader.Set("Location", "http://example.com/login")
+		return lastResult
+	}
+
+	hm.Handle(ctx)
+
+	expected := map[string]string{
+		"X-Internal":    "",
+		"X-User":        "alice",
+		"X-Tenant":      "megaease",
+		"X-Route":       "GET /tenants/megaease/orders",
+		"Authorization": "Bearer abc",
+	}
+	for k, v := range expected {
+		if got := reqHeader.Get(k); got != v {
+			t.Errorf("request header %s should be %q, got %q", k, v, got)
+		}
+	}
+	if got := reqHeader.GetAll("X-Forwarded-For"); len(got) != 2 || got[1] != "192.168.1.1" {
+		t.Errorf("client ip should be added to X-Forwarded-For, got %v", got)
+	}
+
+	expected = map[string]string{
+		"X-Status":       "302",
+		"X-Request-User": "alice",
+		"Location":       "https://example.com/login",
+	}
+	for k, v := range expected {
+		if got := rspHeader.Get(k); got != v {
+			t.Errorf("response header %s should be %q, got %q", k, v, got)
+		}
+	}
+}
+
+func TestInvalidRegex(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: HeaderModifier
+name: header-modifier
+request:
+  rewrite:
+  - name: Location
+    regex: '^(http'
+`), &rawSpec)
+
+	if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+		t.Error("spec with invalid regex should fail")
+	}
+}
+
+func TestSetExpr(t *testing.T) {
+	hm := httppipelinetest.InitFilter(t, &HeaderModifier{}, `
+kind: HeaderModifier
+name: header-modifier
+request:
+  setExpr:
+    X-Tier: 'req.header("X-User") === "alice" ? "gold" : "silver"'
+    X-Internal: 'null'
+response:
+  setExpr:
+    X-Status-Class: 'Math.floor(resp.statusCode() / 100) + "xx"'
+`).(*HeaderModifier)
+
+	reqHeader := httpheader.New(http.Header{})
+	reqHeader.Set("X-User", "alice")
+	reqHeader.Set("X-Internal", "secret")
+	rspHeader := httpheader.New(http.Header{})
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return rspHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return http.StatusNotFound }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	hm.Handle(ctx)
+
+	if got := reqHeader.Get("X-Tier"); got != "gold" {
+		t.Errorf("X-Tier should be gold, got %q", got)
+	}
+	if got := reqHeader.GetAll("X-Internal"); len(got) != 0 {
+		t.Errorf("X-Internal should be removed, got %v", got)
+	}
+	if got := rspHeader.Get("X-Status-Class"); got != "4xx" {
+		t.Errorf("X-Status-Class should be 4xx, got %q", got)
+	}
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: HeaderModifier
+name: header-modifier
+request:
+  setExpr:
+    X-Tier: 'req.header('
+`), &rawSpec)
+	if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+		t.Error("spec with invalid expression should fail")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const geoIPCheckInterval = time.Minute
+
+type (
+	// GeoIPSpec describes the country rules by a MaxMind GeoIP2 or
+	// GeoLite2 database.
+	GeoIPSpec struct {
+		Database       string   `yaml:"database" jsonschema:"required,minLength=1"`
+		AllowCountries []string `yaml:"allowCountries" jsonschema:"omitempty,uniqueItems=true"`
+		BlockCountries []string `yaml:"blockCountries" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// countryLookup looks up the ISO 3166-1 country code of IPs.
+	countryLookup interface {
+		country(ip net.IP) string
+		close()
+	}
+
+	// geoIP looks up countries in a MaxMind database, the database is
+	// reopened when the file changes.
+	geoIP struct {
+		path string
+		done chan struct{}
+
+		mutex   sync.RWMutex
+		reader  *maxminddb.Reader
+		modTime time.Time
+	}
+
+	geoIPRecord struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+)
+
+func newGeoIP(path string) (*geoIP, error) {
+	g := &geoIP{path: path, done: make(chan struct{})}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	go g.run()
+	return g, nil
+}
+
+func (g *geoIP) load() error {
+	fi, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(g.modTime) {
+		return nil
+	}
+
+	reader, err := maxminddb.Open(g.path)
+	if err != nil {
+		return fmt.Errorf("open geoip database %s failed: %v", g.path, err)
+	}
+
+	g.mutex.Lock()
+	prev := g.reader
+	g.reader, g.modTime = reader, fi.ModTime()
+	g.mutex.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+func (g *geoIP) run() {
+	ticker := time.NewTicker(geoIPCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := g.load(); err != nil {
+				logger.Errorf("reload geoip database failed: %v", err)
+			}
+		case <-g.done:
+			return
+		}
+	}
+}
+
+func (g *geoIP) country(ip net.IP) string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	record := &geoIPRecord{}
+	if err := g.reader.Lookup(ip, record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	return record.RegisteredCountry.ISOCode
+}
+
+func (g *geoIP) close() {
+	close(g.done)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.reader.Close()
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yl2chen/cidranger"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of IPFilter.
+	Kind = "IPFilter"
+
+	resultBlocked = "blocked"
+)
+
+var results = []string{resultBlocked}
+
+func init() {
+	httppipeline.Register(&IPFilter{})
+}
+
+type (
+	// IPFilter allows or blocks requests by the IPs and the countries of
+	// the clients.
+	IPFilter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		allowIPs       cidranger.Ranger
+		blockIPs       cidranger.Ranger
+		trustedProxies cidranger.Ranger
+		allowSources   []*source
+		blockSources   []*source
+		geo            countryLookup
+
+		allowed uint64
+		blocked uint64
+	}
+
+	// Spec describes the IPFilter.
+	Spec struct {
+		// BlockByDefault decides the requests matching no list and no
+		// country rule.
+		BlockByDefault bool `yaml:"blockByDefault" jsonschema:"omitempty"`
+
+		AllowIPs     []string      `yaml:"allowIPs" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+		BlockIPs     []string      `yaml:"blockIPs" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+		AllowSources []*SourceSpec `yaml:"allowSources" jsonschema:"omitempty"`
+		BlockSources []*SourceSpec `yaml:"blockSources" jsonschema:"omitempty"`
+
+		// TrustedProxies are the proxies whose X-Forwarded-For is
+		// trusted, the real IP of the request is used if it is empty.
+		TrustedProxies []string `yaml:"trustedProxies" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+
+		GeoIP *GeoIPSpec `yaml:"geoIP,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of IPFilter.
+	Status struct {
+		Allowed uint64          `yaml:"allowed"`
+		Blocked uint64          `yaml:"blocked"`
+		Sources []*SourceStatus `yaml:"sources,omitempty"`
+	}
+)
+
+// Kind returns the kind of IPFilter.
+func (f *IPFilter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of IPFilter.
+func (f *IPFilter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of IPFilter.
+func (f *IPFilter) Description() string {
+	return "IPFilter allows or blocks requests by IPs and countries of clients."
+}
+
+// Results returns the results of IPFilter.
+func (f *IPFilter) Results() []string {
+	return results
+}
+
+// Init initializes IPFilter.
+func (f *IPFilter) Init(filterSpec *httppipeline.FilterSpec) {
+	f.filterSpec, f.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	f.reload()
+}
+
+// Inherit inherits previous generation of IPFilter.
+func (f *IPFilter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	f.Init(filterSpec)
+}
+
+func (f *IPFilter) reload() {
+	mustRanger := func(ipcidrs []string) cidranger.Ranger {
+		var nets []*net.IPNet
+		for _, s := range ipcidrs {
+			n, err := parseIPNet(s)
+			if err != nil {
+				logger.Errorf("BUG: %v", err)
+				continue
+			}
+			nets = append(nets, n)
+		}
+		return newRanger(nets)
+	}
+
+	f.allowIPs = mustRanger(f.spec.AllowIPs)
+	f.blockIPs = mustRanger(f.spec.BlockIPs)
+	if len(f.spec.TrustedProxies) > 0 {
+		f.trustedProxies = mustRanger(f.spec.TrustedProxies)
+	}
+
+	var c cluster.Cluster
+	if super := f.filterSpec.Super(); super != nil {
+		c = super.Cluster()
+	}
+	for _, spec := range f.spec.AllowSources {
+		f.allowSources = append(f.allowSources, newSource(spec, c))
+	}
+	for _, spec := range f.spec.BlockSources {
+		f.blockSources = append(f.blockSources, newSource(spec, c))
+	}
+
+	if geo := f.spec.GeoIP; geo != nil {
+		for i := range geo.AllowCountries {
+			geo.AllowCountries[i] = strings.ToUpper(geo.AllowCountries[i])
+		}
+		for i := range geo.BlockCountries {
+			geo.BlockCountries[i] = strings.ToUpper(geo.BlockCountries[i])
+		}
+		g, err := newGeoIP(geo.Database)
+		if err != nil {
+			logger.Errorf("%s: %v, country rules are disabled", f.filterSpec.Name(), err)
+		} else {
+			f.geo = g
+		}
+	}
+}
+
+// Handle allows or blocks the request.
+func (f *IPFilter) Handle(ctx context.HTTPContext) string {
+	result := f.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (f *IPFilter) handle(ctx context.HTTPContext) string {
+	ip := f.clientIP(ctx.Request())
+	if f.allow(ip) {
+		atomic.AddUint64(&f.allowed, 1)
+		return ""
+	}
+
+	atomic.AddUint64(&f.blocked, 1)
+	ctx.Response().SetStatusCode(http.StatusForbidden)
+	ctx.AddTag("ipFilter: blocked " + ip.String())
+	return resultBlocked
+}
+
+// clientIP returns the IP of the client, only the X-Forwarded-For from
+// trusted proxies is trusted, it walks the X-Forwarded-For from right to
+// left, and the first IP which isn't a trusted proxy is the client.
+func (f *IPFilter) clientIP(req context.HTTPRequest) net.IP {
+	if f.trustedProxies == nil {
+		return net.ParseIP(req.RealIP())
+	}
+
+	remoteAddr := req.Std().RemoteAddr
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !rangerContains(f.trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range req.Header().GetAll(httpheader.KeyXForwardedFor) {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// NOTE: The hops on the left of an invalid one are unreliable.
+			break
+		}
+		ip = hop
+		if !rangerContains(f.trustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// allow decides by the block lists, the allow lists, the country rules
+// and the default in order.
+func (f *IPFilter) allow(ip net.IP) bool {
+	if ip == nil {
+		return !f.spec.BlockByDefault
+	}
+
+	if rangerContains(f.blockIPs, ip) {
+		return false
+	}
+	for _, s := range f.blockSources {
+		if s.contains(ip) {
+			return false
+		}
+	}
+
+	if rangerContains(f.allowIPs, ip) {
+		return true
+	}
+	for _, s := range f.allowSources {
+		if s.contains(ip) {
+			return true
+		}
+	}
+
+	if f.geo != nil {
+		country := f.geo.country(ip)
+		if country != "" {
+			if stringtool.StrInSlice(country, f.spec.GeoIP.BlockCountries) {
+				return false
+			}
+			if stringtool.StrInSlice(country, f.spec.GeoIP.AllowCountries) {
+				return true
+			}
+		}
+	}
+
+	return !f.spec.BlockByDefault
+}
+
+// Status returns status.
+func (f *IPFilter) Status() interface{} {
+	s := &Status{
+		Allowed: atomic.LoadUint64(&f.allowed),
+		Blocked: atomic.LoadUint64(&f.blocked),
+	}
+	for _, src := range f.allowSources {
+		s.Sources = append(s.Sources, src.status())
+	}
+	for _, src := range f.blockSources {
+		s.Sources = append(s.Sources, src.status())
+	}
+	return s
+}
+
+// Close closes IPFilter.
+func (f *IPFilter) Close() {
+	for _, s := range f.allowSources {
+		s.close()
+	}
+	for _, s := range f.blockSources {
+		s.close()
+	}
+	if f.geo != nil {
+		f.geo.close()
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func do(f *IPFilter, remoteAddr string, xff ...string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for _, v := range xff {
+		req.Header.Add("X-Forwarded-For", v)
+	}
+
+	result, _ := httppipelinetest.Handle(f, req, nil)
+	return result
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition is not met in time")
+}
+
+func TestLists(t *testing.T) {
+	f := httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockByDefault: true
+allowIPs: [10.0.0.0/8, "2001:db8::/32"]
+blockIPs: [10.1.0.0/16, 10.2.3.4]
+`).(*IPFilter)
+	defer f.Close()
+
+	cases := map[string]string{
+		"10.0.0.1:1234":      "",
+		"10.1.2.3:1234":      resultBlocked,
+		"10.2.3.4:1234":      resultBlocked,
+		"10.2.3.5:1234":      "",
+		"192.168.0.1:1234":   resultBlocked,
+		"[2001:db8::1]:1234": "",
+		"[2001:db9::1]:1234": resultBlocked,
+	}
+	for addr, want := range cases {
+		if got := do(f, addr); got != want {
+			t.Errorf("%s: want %q, got %q", addr, want, got)
+		}
+	}
+
+	s := f.Status().(*Status)
+	if s.Allowed != 3 || s.Blocked != 4 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	f := httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockIPs: [1.1.1.1]
+trustedProxies: [10.0.0.0/8]
+`).(*IPFilter)
+	defer f.Close()
+
+	// NOTE: The X-Forwarded-For from an untrusted peer is ignored.
+	if do(f, "2.2.2.2:1234", "1.1.1.1") != "" {
+		t.Error("x-forwarded-for from untrusted peer should be ignored")
+	}
+	if do(f, "1.1.1.1:1234", "2.2.2.2") != resultBlocked {
+		t.Error("untrusted peer should be the client")
+	}
+	if do(f, "10.0.0.1:1234", "1.1.1.1") != resultBlocked {
+		t.Error("x-forwarded-for from trusted proxy should be used")
+	}
+	if do(f, "10.0.0.1:1234", "1.1.1.1, 10.0.0.2", "10.0.0.3") != resultBlocked {
+		t.Error("trusted proxies in x-forwarded-for should be skipped")
+	}
+	if do(f, "10.0.0.1:1234", "1.1.1.1, 2.2.2.2") != "" {
+		t.Error("the forged x-forwarded-for on the left of the client should be ignored")
+	}
+	if do(f, "10.0.0.1:1234", "1.1.1.1, bad") != "" {
+		t.Error("the hops on the left of an invalid hop should be ignored")
+	}
+
+	f = httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockIPs: [1.1.1.1]
+`).(*IPFilter)
+	defer f.Close()
+	if do(f, "2.2.2.2:1234", "1.1.1.1") != resultBlocked {
+		t.Error("real ip should be used without trusted proxies")
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "block.txt")
+	os.WriteFile(path, []byte("# bad guys\n1.1.1.1\n2.2.2.0/24 # subnet\n\n"), 0o644)
+
+	f := httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockSources:
+- file: `+path+`
+  interval: 10ms
+`).(*IPFilter)
+	defer f.Close()
+
+	if do(f, "1.1.1.1:1234") != resultBlocked || do(f, "2.2.2.2:1234") != resultBlocked {
+		t.Error("ips in the file should be blocked")
+	}
+	if do(f, "3.3.3.3:1234") != "" {
+		t.Error("ip not in the file should be allowed")
+	}
+
+	os.WriteFile(path, []byte("3.3.3.3\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	waitFor(t, func() bool { return do(f, "3.3.3.3:1234") == resultBlocked })
+	if do(f, "1.1.1.1:1234") != "" {
+		t.Error("list should be reloaded")
+	}
+
+	// NOTE: The previous list is kept if the file is invalid.
+	os.WriteFile(path, []byte("invalid\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	waitFor(t, func() bool { return f.Status().(*Status).Sources[0].Error != "" })
+	if do(f, "3.3.3.3:1234") != resultBlocked {
+		t.Error("previous list should be kept")
+	}
+	if n := f.Status().(*Status).Sources[0].Entries; n != 1 {
+		t.Errorf("there should be 1 entry, got %d", n)
+	}
+}
+
+func TestURLSource(t *testing.T) {
+	var requests, notModified int32
+	list := "1.1.1.1\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(list))
+	}))
+	defer server.Close()
+
+	f := httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockByDefault: true
+allowSources:
+- url: `+server.URL+`
+  interval: 10ms
+`).(*IPFilter)
+	defer f.Close()
+
+	waitFor(t, func() bool { return do(f, "1.1.1.1:1234") == "" })
+	if do(f, "2.2.2.2:1234") != resultBlocked {
+		t.Error("ip not in the list should be blocked")
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&notModified) > 0 })
+	if n := f.Status().(*Status).Sources[0].Entries; n != 1 {
+		t.Errorf("list should be kept when not modified, got %d entries", n)
+	}
+}
+
+type fakeGeo map[string]string
+
+func (g fakeGeo) country(ip net.IP) string { return g[ip.String()] }
+
+func (g fakeGeo) close() {}
+
+func TestGeoIP(t *testing.T) {
+	// NOTE: The database doesn't exist, so country rules are disabled
+	// until the fake one is set.
+	f := httppipelinetest.InitFilter(t, &IPFilter{}, `
+kind: IPFilter
+name: ipfilter
+blockByDefault: true
+allowIPs: [3.3.3.3]
+geoIP:
+  database: /nonexistent/GeoLite2-Country.mmdb
+  allowCountries: [us, CA]
+  blockCountries: [XX]
+`).(*IPFilter)
+	defer f.Close()
+	if f.geo != nil {
+		t.Fatal("geoip should be disabled")
+	}
+	if do(f, "1.1.1.1:1234") != resultBlocked {
+		t.Error("default should be used without geoip")
+	}
+
+	f.geo = fakeGeo{"1.1.1.1": "US", "2.2.2.2": "CN", "3.3.3.3": "XX", "4.4.4.4": "XX"}
+	cases := map[string]string{
+		"1.1.1.1:1234": "",
+		"2.2.2.2:1234": resultBlocked,
+		"3.3.3.3:1234": "",
+		"4.4.4.4:1234": resultBlocked,
+		"5.5.5.5:1234": resultBlocked,
+	}
+	for addr, want := range cases {
+		if got := do(f, addr); got != want {
+			t.Errorf("%s: want %q, got %q", addr, want, got)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	nets, err := ParseList(strings.NewReader("1.1.1.1\n  2001:db8::1 \n10.0.0.0/8\n# comment\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Errorf("there should be 3 entries, got %d", len(nets))
+	}
+
+	_, err = ParseList(strings.NewReader("1.1.1.1\n1.1.1\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error should be reported with line number, got %v", err)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []string{`
+kind: IPFilter
+name: ipfilter
+allowIPs: [1.1.1]
+`, `
+kind: IPFilter
+name: ipfilter
+allowSources:
+- file: /tmp/a
+  url: http://127.0.0.1/a
+`, `
+kind: IPFilter
+name: ipfilter
+blockSources:
+- interval: 1m
+`, `
+kind: IPFilter
+name: ipfilter
+geoIP:
+  allowCountries: [US]
+`}
+
+	for i, c := range cases {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(c), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yl2chen/cidranger"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultSourceInterval = time.Minute
+	sourceFetchTimeout    = 10 * time.Second
+)
+
+type (
+	// SourceSpec describes a list of IPs and CIDRs loaded from a file, a
+	// URL or the cluster, one and only one of them should be specified.
+	SourceSpec struct {
+		File string `yaml:"file" jsonschema:"omitempty"`
+		URL  string `yaml:"url" jsonschema:"omitempty,format=url"`
+		// Cluster is the name of the list managed by the admin API.
+		Cluster string `yaml:"cluster" jsonschema:"omitempty"`
+		// Interval is the interval to check the file or the URL.
+		Interval string `yaml:"interval" jsonschema:"omitempty,format=duration"`
+	}
+
+	// SourceStatus is the status of a source.
+	SourceStatus struct {
+		Source    string `yaml:"source"`
+		Entries   int    `yaml:"entries"`
+		UpdatedAt string `yaml:"updatedAt,omitempty"`
+		Error     string `yaml:"error,omitempty"`
+	}
+
+	// source holds the latest list of a SourceSpec, the previous list is
+	// kept if the source fails to load.
+	source struct {
+		spec     *SourceSpec
+		interval time.Duration
+		client   *http.Client
+		done     chan struct{}
+
+		mutex     sync.RWMutex
+		ranger    cidranger.Ranger
+		entries   int
+		updatedAt time.Time
+		err       error
+
+		// for file sources.
+		modTime time.Time
+		// for URL sources.
+		etag string
+	}
+)
+
+// Validate validates the SourceSpec.
+func (spec SourceSpec) Validate() error {
+	n := 0
+	for _, s := range []string{spec.File, spec.URL, spec.Cluster} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("one and only one of file, url and cluster should be specified")
+	}
+	return nil
+}
+
+func (spec *SourceSpec) String() string {
+	switch {
+	case spec.File != "":
+		return "file:" + spec.File
+	case spec.URL != "":
+		return "url:" + spec.URL
+	default:
+		return "cluster:" + spec.Cluster
+	}
+}
+
+// ParseList parses a list of IPs and CIDRs, one per line, the text after
+// '#' is ignored as comments.
+func ParseList(r io.Reader) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := scanner.Text()
+		if idx := strings.IndexByte(s, '#'); idx >= 0 {
+			s = s[:idx]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := parseIPNet(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		nets = append(nets, n)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nets, nil
+}
+
+func parseIPNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(net.IPv4len*8, net.IPv4len*8)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(net.IPv6len*8, net.IPv6len*8)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s is an invalid ip or cidr", s)
+	}
+	return n, nil
+}
+
+func newRanger(nets []*net.IPNet) cidranger.Ranger {
+	ranger := cidranger.NewPCTrieRanger()
+	for _, n := range nets {
+		ranger.Insert(cidranger.NewBasicRangerEntry(*n))
+	}
+	return ranger
+}
+
+func rangerContains(ranger cidranger.Ranger, ip net.IP) bool {
+	ok, err := ranger.Contains(ip)
+	return err == nil && ok
+}
+
+// newSource creates a source, file sources are loaded at once, URL and
+// cluster sources are loaded in background.
+func newSource(spec *SourceSpec, c cluster.Cluster) *source {
+	s := &source{
+		spec:     spec,
+		interval: defaultSourceInterval,
+		client:   &http.Client{Timeout: sourceFetchTimeout},
+		done:     make(chan struct{}),
+		ranger:   cidranger.NewPCTrieRanger(),
+	}
+	if spec.Interval != "" {
+		if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+			s.interval = d
+		}
+	}
+
+	switch {
+	case spec.File != "":
+		s.loadFile()
+		go s.poll(s.loadFile)
+	case spec.URL != "":
+		go func() {
+			s.loadURL()
+			s.poll(s.loadURL)
+		}()
+	case c != nil:
+		go s.watchCluster(c)
+	default:
+		s.setError(fmt.Errorf("cluster is unavailable"))
+	}
+
+	return s
+}
+
+func (s *source) contains(ip net.IP) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return rangerContains(s.ranger, ip)
+}
+
+func (s *source) poll(load func()) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			load()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *source) set(nets []*net.IPNet) {
+	ranger := newRanger(nets)
+	s.mutex.Lock()
+	s.ranger, s.entries, s.updatedAt, s.err = ranger, len(nets), time.Now(), nil
+	s.mutex.Unlock()
+}
+
+func (s *source) setError(err error) {
+	logger.Errorf("load ip list %s failed: %v", s.spec, err)
+	s.mutex.Lock()
+	s.err = err
+	s.mutex.Unlock()
+}
+
+func (s *source) loadFile() {
+	fi, err := os.Stat(s.spec.File)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	if fi.ModTime().Equal(s.modTime) {
+		return
+	}
+
+	f, err := os.Open(s.spec.File)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	defer f.Close()
+
+	nets, err := ParseList(f)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	s.modTime = fi.ModTime()
+	s.set(nets)
+}
+
+func (s *source) loadURL() {
+	req, err := http.NewRequest(http.MethodGet, s.spec.URL, nil)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return
+	default:
+		s.setError(fmt.Errorf("unexpected status code %d", resp.StatusCode))
+		return
+	}
+
+	nets, err := ParseList(resp.Body)
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	s.etag = resp.Header.Get("ETag")
+	s.set(nets)
+}
+
+// watchCluster watches the list managed by the admin API.
+func (s *source) watchCluster(c cluster.Cluster) {
+	var (
+		ch     <-chan *string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	for {
+		syncer, err = c.Syncer(time.Minute)
+		if err == nil {
+			ch, err = syncer.Sync(c.Layout().IPFilterListKey(s.spec.Cluster))
+			if err == nil {
+				break
+			}
+		}
+		s.setError(err)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-s.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case value := <-ch:
+			if value == nil {
+				s.set(nil)
+				continue
+			}
+			nets, err := ParseList(strings.NewReader(*value))
+			if err != nil {
+				s.setError(err)
+				continue
+			}
+			s.set(nets)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *source) status() *SourceStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := &SourceStatus{
+		Source:  s.spec.String(),
+		Entries: s.entries,
+	}
+	if !s.updatedAt.IsZero() {
+		status.UpdatedAt = s.updatedAt.Format(time.RFC3339)
+	}
+	if s.err != nil {
+		status.Error = s.err.Error()
+	}
+	return status
+}
+
+func (s *source) close() {
+	close(s.done)
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkaoutput
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+)
+
+const (
+	// Kind is the kind of KafkaOutput.
+	Kind = "KafkaOutput"
+
+	resultBuildMessageFailed = "buildMessageFailed"
+
+	partitionerHash       = "hash"
+	partitionerRandom     = "random"
+	partitionerRoundRobin = "roundRobin"
+
+	acksNone   = "none"
+	acksLeader = "leader"
+	acksAll    = "all"
+)
+
+var results = []string{resultBuildMessageFailed}
+
+// newAsyncProducer creates the Kafka producer, it is replaced in tests.
+var newAsyncProducer = sarama.NewAsyncProducer
+
+func init() {
+	httppipeline.Register(&KafkaOutput{})
+}
+
+type (
+	// KafkaOutput is filter KafkaOutput.
+	KafkaOutput struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		producer sarama.AsyncProducer
+		done     chan struct{}
+
+		sent      uint64
+		succeeded uint64
+		failed    uint64
+		lastError atomic.Value // string
+	}
+
+	// Spec is the spec of KafkaOutput.
+	Spec struct {
+		Backend []string `yaml:"backend" jsonschema:"required,uniqueItems=true"`
+		// Topic, Key, Value and the values of Headers could be templates
+		// of the pipeline.
+		Topic   string            `yaml:"topic" jsonschema:"required"`
+		Key     string            `yaml:"key" jsonschema:"omitempty"`
+		Value   string            `yaml:"value" jsonschema:"omitempty"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+
+		Partitioner  string     `yaml:"partitioner,omitempty" jsonschema:"omitempty,enum=hash,enum=random,enum=roundRobin"`
+		Compression  string     `yaml:"compression,omitempty" jsonschema:"omitempty,enum=none,enum=gzip,enum=snappy,enum=lz4,enum=zstd"`
+		RequiredAcks string     `yaml:"requiredAcks,omitempty" jsonschema:"omitempty,enum=none,enum=leader,enum=all"`
+		Flush        *FlushSpec `yaml:"flush,omitempty" jsonschema:"omitempty"`
+	}
+
+	// FlushSpec describes the batching of messages, a batch is sent when
+	// any of the conditions is reached.
+	FlushSpec struct {
+		Frequency string `yaml:"frequency" jsonschema:"omitempty,format=duration"`
+		Messages  int    `yaml:"messages" jsonschema:"omitempty,minimum=0"`
+		Bytes     int    `yaml:"bytes" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// Status is the status of KafkaOutput.
+	Status struct {
+		// Sent is the number of messages sent to the producer.
+		Sent uint64 `yaml:"sent"`
+		// Succeeded is the number of messages acknowledged by Kafka.
+		Succeeded uint64 `yaml:"succeeded"`
+		// Failed is the number of messages failed to be delivered.
+		Failed    uint64 `yaml:"failed"`
+		LastError string `yaml:"lastError,omitempty"`
+	}
+)
+
+// Kind returns the kind of KafkaOutput.
+func (ko *KafkaOutput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of KafkaOutput.
+func (ko *KafkaOutput) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of KafkaOutput.
+func (ko *KafkaOutput) Description() string {
+	return "KafkaOutput publishes messages built from the request to Kafka asynchronously."
+}
+
+// Results returns the results of KafkaOutput.
+func (ko *KafkaOutput) Results() []string {
+	return results
+}
+
+// Init initializes KafkaOutput.
+func (ko *KafkaOutput) Init(filterSpec *httppipeline.FilterSpec) {
+	ko.filterSpec, ko.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ko.reload()
+}
+
+// Inherit inherits previous generation of KafkaOutput.
+func (ko *KafkaOutput) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ko.Init(filterSpec)
+}
+
+func (ko *KafkaOutput) producerConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = ko.filterSpec.Name()
+	config.Version = sarama.V1_0_0_0
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	switch ko.spec.Partitioner {
+	case partitionerRandom:
+		config.Producer.Partitioner = sarama.NewRandomPartitioner
+	case partitionerRoundRobin:
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	}
+
+	switch ko.spec.Compression {
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		// NOTE: Zstd requires Kafka 2.1.0 at least.
+		config.Producer.Compression = sarama.CompressionZSTD
+		config.Version = sarama.V2_1_0_0
+	default:
+		config.Producer.Compression = sarama.CompressionNone
+	}
+
+	switch ko.spec.RequiredAcks {
+	case acksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	case acksAll:
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	default:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	}
+
+	if flush := ko.spec.Flush; flush != nil {
+		if flush.Frequency != "" {
+			// NOTE: The duration has been checked in validation.
+			config.Producer.Flush.Frequency, _ = time.ParseDuration(flush.Frequency)
+		}
+		config.Producer.Flush.Messages = flush.Messages
+		config.Producer.Flush.Bytes = flush.Bytes
+	}
+
+	return config
+}
+
+func (ko *KafkaOutput) reload() {
+	ko.done = make(chan struct{})
+	ko.lastError.Store("")
+
+	producer, err := newAsyncProducer(ko.spec.Backend, ko.producerConfig())
+	if err != nil {
+		logger.Errorf("%s: start kafka producer with %v failed: %v", ko.filterSpec.Name(), ko.spec.Backend, err)
+		ko.lastError.Store(err.Error())
+		return
+	}
+	ko.producer = producer
+
+	go ko.watchResults()
+}
+
+// watchResults collects the delivery results of the messages.
+func (ko *KafkaOutput) watchResults() {
+	successes, errors := ko.producer.Successes(), ko.producer.Errors()
+	for successes != nil || errors != nil {
+		select {
+		case _, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			atomic.AddUint64(&ko.succeeded, 1)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			atomic.AddUint64(&ko.failed, 1)
+			ko.lastError.Store(err.Error())
+			logger.Debugf("%s: deliver message failed: %v", ko.filterSpec.Name(), err)
+		}
+	}
+}
+
+// Handle publishes a message built from the request to Kafka.
+func (ko *KafkaOutput) Handle(ctx context.HTTPContext) string {
+	result := ko.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ko *KafkaOutput) handle(ctx context.HTTPContext) string {
+	if ko.producer == nil {
+		atomic.AddUint64(&ko.failed, 1)
+		return ""
+	}
+
+	msg, err := ko.buildMessage(ctx)
+	if err != nil {
+		ctx.AddTag(fmt.Sprintf("kafkaOutput: %v", err))
+		return resultBuildMessageFailed
+	}
+
+	atomic.AddUint64(&ko.sent, 1)
+	select {
+	case ko.producer.Input() <- msg:
+	case <-ko.done:
+	}
+	return ""
+}
+
+func render(hte texttemplate.TemplateEngine, s string) (string, error) {
+	if hte == nil || !hte.HasTemplates(s) {
+		return s, nil
+	}
+	return hte.Render(s)
+}
+
+func (ko *KafkaOutput) buildMessage(ctx context.HTTPContext) (*sarama.ProducerMessage, error) {
+	hte := ctx.Template()
+
+	topic, err := render(hte, ko.spec.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("render topic failed: %v", err)
+	}
+	msg := &sarama.ProducerMessage{Topic: topic}
+
+	if ko.spec.Key != "" {
+		key, err := render(hte, ko.spec.Key)
+		if err != nil {
+			return nil, fmt.Errorf("render key failed: %v", err)
+		}
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	if ko.spec.Value != "" {
+		value, err := render(hte, ko.spec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("render value failed: %v", err)
+		}
+		msg.Value = sarama.StringEncoder(value)
+	} else {
+		// NOTE: The request body is the value by default, it must be
+		// set back for the following filters.
+		body, err := io.ReadAll(ctx.Request().Body())
+		if err != nil {
+			return nil, fmt.Errorf("read request body failed: %v", err)
+		}
+		ctx.Request().SetBody(bytes.NewReader(body))
+		msg.Value = sarama.ByteEncoder(body)
+	}
+
+	for k, v := range ko.spec.Headers {
+		value, err := render(hte, v)
+		if err != nil {
+			return nil, fmt.Errorf("render header %s failed: %v", k, err)
+		}
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(value)})
+	}
+
+	return msg, nil
+}
+
+// Status returns status.
+func (ko *KafkaOutput) Status() interface{} {
+	return &Status{
+		Sent:      atomic.LoadUint64(&ko.sent),
+		Succeeded: atomic.LoadUint64(&ko.succeeded),
+		Failed:    atomic.LoadUint64(&ko.failed),
+		LastError: ko.lastError.Load().(string),
+	}
+}
+
+// Close closes KafkaOutput.
+func (ko *KafkaOutput) Close() {
+	close(ko.done)
+	if ko.producer == nil {
+		return
+	}
+
+	// NOTE: Close flushes the buffered messages.
+	if err := ko.producer.Close(); err != nil {
+		logger.Errorf("%s: close kafka producer failed: %v", ko.filterSpec.Name(), err)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkaoutput
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newKafkaOutput(t *testing.T, yamlSpec string, producer *mocks.AsyncProducer) *KafkaOutput {
+	newAsyncProducer = func(addrs []string, config *sarama.Config) (sarama.AsyncProducer, error) {
+		if !config.Producer.Return.Successes {
+			t.Error("successes should be returned for statistics")
+		}
+		return producer, nil
+	}
+
+	return httppipelinetest.InitFilter(t, &KafkaOutput{}, yamlSpec).(*KafkaOutput)
+}
+
+func TestKafkaOutput(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+
+	const yamlSpec = `
+kind: KafkaOutput
+name: kafka
+backend: ["127.0.0.1:9092"]
+topic: access-log
+key: user
+headers:
+  source: easegress
+compression: gzip
+requiredAcks: all
+flush:
+  frequency: 100ms
+`
+	ko := newKafkaOutput(t, yamlSpec, producer)
+	if ko.producerConfig().Producer.Compression != sarama.CompressionGZIP {
+		t.Error("compression should be gzip")
+	}
+	if ko.producerConfig().Producer.RequiredAcks != sarama.WaitForAll {
+		t.Error("required acks should be all")
+	}
+
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		value, _ := msg.Value.Encode()
+		key, _ := msg.Key.Encode()
+		if msg.Topic != "access-log" || string(key) != "user" || string(value) != "hello" {
+			return fmt.Errorf("unexpected message: %s %s %s", msg.Topic, key, value)
+		}
+		if len(msg.Headers) != 1 || string(msg.Headers[0].Value) != "easegress" {
+			return fmt.Errorf("unexpected headers: %v", msg.Headers)
+		}
+		return nil
+	})
+	producer.ExpectInputAndFail(fmt.Errorf("broker down"))
+
+	var body io.Reader = strings.NewReader("hello")
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedBody = func() io.Reader { return body }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { body = r }
+
+	ko.Handle(ctx)
+	if b, _ := io.ReadAll(body); !bytes.Equal(b, []byte("hello")) {
+		t.Errorf("request body should be kept, got %q", b)
+	}
+	body = strings.NewReader("world")
+	ko.Handle(ctx)
+
+	var s *Status
+	for i := 0; i < 100; i++ {
+		s = ko.Status().(*Status)
+		if s.Succeeded+s.Failed == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Sent != 2 || s.Succeeded != 1 || s.Failed != 1 || !strings.Contains(s.LastError, "broker down") {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	ko.Close()
+}
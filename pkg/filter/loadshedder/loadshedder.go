@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package loadshedder
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of LoadShedder.
+	Kind = "LoadShedder"
+
+	resultShed = "shed"
+
+	defaultMaxQueueTime = time.Second
+	defaultRetryAfter   = time.Second
+
+	keyRetryAfter = "Retry-After"
+)
+
+var results = []string{resultShed}
+
+func init() {
+	httppipeline.Register(&LoadShedder{})
+}
+
+type (
+	// LoadShedder protects the backends by limiting the concurrent
+	// requests, the excess requests are queued by priority and shed when
+	// the queue is full or they wait too long.
+	LoadShedder struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		queue        *queue
+		maxQueueTime time.Duration
+		retryAfter   string
+
+		admitted uint64
+		queued   uint64
+
+		mutex sync.Mutex
+		shed  map[string]uint64
+	}
+
+	// Spec describes the LoadShedder.
+	Spec struct {
+		MaxConcurrency int `yaml:"maxConcurrency" jsonschema:"required,minimum=1"`
+		// QueueDepth is the maximum number of the queued requests, the
+		// excess requests are shed immediately if it is 0.
+		QueueDepth int `yaml:"queueDepth" jsonschema:"omitempty,minimum=0"`
+		// MaxQueueTime is the latency budget of the queued requests, they
+		// are shed after waiting for it, default is 1s.
+		MaxQueueTime string `yaml:"maxQueueTime" jsonschema:"omitempty,format=duration"`
+		// RetryAfter is the value of the Retry-After header of the shed
+		// requests, default is 1s.
+		RetryAfter string        `yaml:"retryAfter" jsonschema:"omitempty,format=duration"`
+		Priority   *PrioritySpec `yaml:"priority,omitempty" jsonschema:"omitempty"`
+	}
+
+	// PrioritySpec describes how the priorities of the requests are got
+	// from the header, the higher ones are admitted first and shed last.
+	PrioritySpec struct {
+		Header string `yaml:"header" jsonschema:"required,minLength=1"`
+		// Levels maps the values of the header to the priorities, the
+		// value is parsed as an integer priority if it isn't in Levels.
+		Levels  map[string]int `yaml:"levels" jsonschema:"omitempty"`
+		Default int            `yaml:"default" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of LoadShedder.
+	Status struct {
+		Inflight int `yaml:"inflight"`
+		Queued   int `yaml:"queued"`
+		// Admitted is the total number of the admitted requests, and
+		// QueuedTotal is the number of them have been queued.
+		Admitted    uint64 `yaml:"admitted"`
+		QueuedTotal uint64 `yaml:"queuedTotal"`
+		// Shed is the number of the shed requests by reason: queueFull,
+		// timeout, evicted by requests of higher priorities, cancelled.
+		Shed map[string]uint64 `yaml:"shed"`
+	}
+)
+
+// Kind returns the kind of LoadShedder.
+func (ls *LoadShedder) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of LoadShedder.
+func (ls *LoadShedder) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of LoadShedder.
+func (ls *LoadShedder) Description() string {
+	return "LoadShedder queues excess requests by priority and sheds load beyond the queue."
+}
+
+// Results returns the results of LoadShedder.
+func (ls *LoadShedder) Results() []string {
+	return results
+}
+
+// Init initializes LoadShedder.
+func (ls *LoadShedder) Init(filterSpec *httppipeline.FilterSpec) {
+	ls.filterSpec, ls.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ls.reload()
+}
+
+// Inherit inherits previous generation of LoadShedder.
+func (ls *LoadShedder) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ls.Init(filterSpec)
+}
+
+func parseDurationOr(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		return d
+	}
+	return v
+}
+
+func (ls *LoadShedder) reload() {
+	ls.queue = newQueue(ls.spec.MaxConcurrency, ls.spec.QueueDepth)
+	ls.maxQueueTime = parseDurationOr(ls.spec.MaxQueueTime, defaultMaxQueueTime)
+
+	retryAfter := parseDurationOr(ls.spec.RetryAfter, defaultRetryAfter)
+	ls.retryAfter = strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+
+	ls.shed = map[string]uint64{}
+}
+
+func (ls *LoadShedder) priority(ctx context.HTTPContext) int {
+	p := ls.spec.Priority
+	if p == nil {
+		return 0
+	}
+
+	value := ctx.Request().Header().Get(p.Header)
+	if value == "" {
+		return p.Default
+	}
+	if priority, ok := p.Levels[value]; ok {
+		return priority
+	}
+	if priority, err := strconv.Atoi(value); err == nil {
+		return priority
+	}
+	return p.Default
+}
+
+// Handle admits, queues or sheds the request.
+func (ls *LoadShedder) Handle(ctx context.HTTPContext) string {
+	reason, queued := ls.queue.acquire(ctx, ls.priority(ctx), ls.maxQueueTime)
+	if reason != "" {
+		ls.mutex.Lock()
+		ls.shed[reason]++
+		ls.mutex.Unlock()
+
+		ctx.AddTag(stringtool.Cat("loadShedder: ", reason))
+		w := ctx.Response()
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		w.Header().Set(keyRetryAfter, ls.retryAfter)
+		return ctx.CallNextHandler(resultShed)
+	}
+
+	atomic.AddUint64(&ls.admitted, 1)
+	if queued {
+		atomic.AddUint64(&ls.queued, 1)
+	}
+	defer ls.queue.release()
+	return ctx.CallNextHandler("")
+}
+
+// Status returns status.
+func (ls *LoadShedder) Status() interface{} {
+	s := &Status{
+		Admitted:    atomic.LoadUint64(&ls.admitted),
+		QueuedTotal: atomic.LoadUint64(&ls.queued),
+		Shed:        map[string]uint64{},
+	}
+	s.Inflight, s.Queued = ls.queue.stats()
+
+	ls.mutex.Lock()
+	for reason, count := range ls.shed {
+		s.Shed[reason] = count
+	}
+	ls.mutex.Unlock()
+
+	return s
+}
+
+// Close closes LoadShedder.
+func (ls *LoadShedder) Close() {
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package loadshedder
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// waitQueued waits until n requests are in the queue.
+func waitQueued(t *testing.T, q *queue, n int) {
+	for i := 0; i < 200; i++ {
+		if _, queued := q.stats(); queued == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d queued requests", n)
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := newQueue(1, 2)
+	ctx := stdcontext.Background()
+
+	if reason, queued := q.acquire(ctx, 0, time.Second); reason != "" || queued {
+		t.Fatalf("expected admitted immediately, got %q %v", reason, queued)
+	}
+
+	var mutex sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	reasons := make(map[int]string)
+	enqueue := func(priority int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reason, _ := q.acquire(ctx, priority, time.Second)
+			mutex.Lock()
+			reasons[priority] = reason
+			if reason == "" {
+				order = append(order, priority)
+			}
+			mutex.Unlock()
+			if reason == "" {
+				q.release()
+			}
+		}()
+	}
+
+	enqueue(1)
+	waitQueued(t, q, 1)
+	enqueue(2)
+	waitQueued(t, q, 2)
+
+	// The queue is full, the request of the lowest priority is shed.
+	if reason, _ := q.acquire(ctx, 0, time.Second); reason != reasonQueueFull {
+		t.Errorf("expected queueFull, got %q", reason)
+	}
+
+	// The request of priority 1 is evicted by the one of priority 3.
+	enqueue(3)
+	for i := 0; i < 200; i++ {
+		mutex.Lock()
+		_, evicted := reasons[1]
+		mutex.Unlock()
+		if evicted {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitQueued(t, q, 2)
+	q.release()
+	wg.Wait()
+
+	if reasons[1] != reasonEvicted {
+		t.Errorf("expected priority 1 evicted, got %q", reasons[1])
+	}
+	if len(order) != 2 || order[0] != 3 || order[1] != 2 {
+		t.Errorf("expected admitted in order [3 2], got %v", order)
+	}
+	if inflight, queued := q.stats(); inflight != 0 || queued != 0 {
+		t.Errorf("expected empty queue, got %d inflight %d queued", inflight, queued)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	q := newQueue(1, 1)
+	ctx := stdcontext.Background()
+	q.acquire(ctx, 0, time.Second)
+
+	if reason, queued := q.acquire(ctx, 0, 10*time.Millisecond); reason != reasonTimeout || !queued {
+		t.Errorf("expected timeout, got %q %v", reason, queued)
+	}
+
+	cancelled, cancel := stdcontext.WithCancel(ctx)
+	cancel()
+	if reason, _ := q.acquire(cancelled, 0, time.Second); reason != reasonCancelled {
+		t.Errorf("expected cancelled, got %q", reason)
+	}
+
+	q.release()
+	if inflight, queued := q.stats(); inflight != 0 || queued != 0 {
+		t.Errorf("expected empty queue, got %d inflight %d queued", inflight, queued)
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(`
+kind: LoadShedder
+name: load-shedder
+maxConcurrency: 1
+queueDepth: 1
+maxQueueTime: 20ms
+retryAfter: 1500ms
+priority:
+  header: X-Priority
+  levels:
+    critical: 10
+`), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ls := &LoadShedder{}
+	ls.Init(spec)
+	defer ls.Close()
+
+	release := make(chan struct{})
+	do := func(priority string, block bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Priority", priority)
+		_, w := httppipelinetest.Handle(ls, req, func(ctx context.HTTPContext, lastResult string) string {
+			if lastResult == "" && block {
+				<-release
+			}
+			return lastResult
+		})
+		return w
+	}
+
+	ctx, _ := httppipelinetest.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	if p := ls.priority(ctx); p != 0 {
+		t.Errorf("expected default priority 0, got %d", p)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		do("critical", true)
+	}()
+	for i := 0; i < 200; i++ {
+		if inflight, _ := ls.queue.stats(); inflight == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Queued and timed out.
+	w := do("1", false)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 503 with Retry-After 2, got %d %v", w.Code, w.Header())
+	}
+
+	close(release)
+	wg.Wait()
+
+	if w = do("critical", false); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+
+	status := ls.Status().(*Status)
+	if status.Admitted != 2 || status.QueuedTotal != 0 || status.Shed[reasonTimeout] != 1 || status.Inflight != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
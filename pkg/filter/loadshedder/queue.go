@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package loadshedder
+
+import (
+	"container/heap"
+	stdcontext "context"
+	"sync"
+	"time"
+)
+
+const (
+	reasonQueueFull = "queueFull"
+	reasonTimeout   = "timeout"
+	reasonEvicted   = "evicted"
+	reasonCancelled = "cancelled"
+)
+
+type (
+	// queue admits at most maxConcurrency requests at a time, the others
+	// wait in a priority queue of at most depth requests.
+	queue struct {
+		mutex          sync.Mutex
+		maxConcurrency int
+		depth          int
+		inflight       int
+		seq            uint64
+		waiters        waiterHeap
+	}
+
+	waiter struct {
+		priority int
+		seq      uint64
+		// index is the index in the heap, -1 means removed.
+		index int
+		// ch receives the reason of shedding, empty means admitted.
+		ch chan string
+	}
+
+	// waiterHeap pops the waiter of the highest priority, the earliest
+	// one among the same priority.
+	waiterHeap []*waiter
+)
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
+
+func newQueue(maxConcurrency, depth int) *queue {
+	return &queue{maxConcurrency: maxConcurrency, depth: depth}
+}
+
+// lowest returns the waiter to evict first: the latest one of the lowest
+// priority.
+func (q *queue) lowest() *waiter {
+	var lowest *waiter
+	for _, w := range q.waiters {
+		if lowest == nil || w.priority < lowest.priority ||
+			(w.priority == lowest.priority && w.seq > lowest.seq) {
+			lowest = w
+		}
+	}
+	return lowest
+}
+
+// acquire waits for a slot at most maxWait, it returns the reason of
+// shedding, or an empty string if the request is admitted, which must
+// call release after it is handled.
+func (q *queue) acquire(ctx stdcontext.Context, priority int, maxWait time.Duration) (reason string, queued bool) {
+	q.mutex.Lock()
+	if q.inflight < q.maxConcurrency && len(q.waiters) == 0 {
+		q.inflight++
+		q.mutex.Unlock()
+		return "", false
+	}
+
+	if len(q.waiters) >= q.depth {
+		// NOTE: A request evicts a waiter of lower priority from the full
+		// queue, so the important requests are shed last.
+		lowest := q.lowest()
+		if lowest == nil || lowest.priority >= priority {
+			q.mutex.Unlock()
+			return reasonQueueFull, false
+		}
+		heap.Remove(&q.waiters, lowest.index)
+		lowest.ch <- reasonEvicted
+	}
+
+	q.seq++
+	w := &waiter{priority: priority, seq: q.seq, ch: make(chan string, 1)}
+	heap.Push(&q.waiters, w)
+	q.mutex.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case reason = <-w.ch:
+		return reason, true
+	case <-timer.C:
+		reason = reasonTimeout
+	case <-ctx.Done():
+		reason = reasonCancelled
+	}
+
+	q.mutex.Lock()
+	if w.index >= 0 {
+		heap.Remove(&q.waiters, w.index)
+		q.mutex.Unlock()
+		return reason, true
+	}
+	q.mutex.Unlock()
+
+	// NOTE: The waiter has been admitted or evicted concurrently, the
+	// admitted one must take the slot, or it would be leaked.
+	return <-w.ch, true
+}
+
+// release hands the slot to the waiter of the highest priority.
+func (q *queue) release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.waiters) > 0 {
+		w := heap.Pop(&q.waiters).(*waiter)
+		w.ch <- ""
+		return
+	}
+	q.inflight--
+}
+
+func (q *queue) stats() (inflight, queued int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.inflight, len(q.waiters)
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package luascript
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of LuaScript.
+	Kind = "LuaScript"
+
+	maxLuaResult = 9
+)
+
+var (
+	resultOutOfVM   = "outOfVM"
+	resultLuaError  = "luaError"
+	resultTimeout   = "timeout"
+	results         = []string{resultOutOfVM, resultLuaError, resultTimeout}
+	errScriptResult = errors.New("script must return nothing or an integer in [0, 9]")
+)
+
+func luaResultToFilterResult(r int) string {
+	if r == 0 {
+		return ""
+	}
+	return fmt.Sprintf("luaResult%d", r)
+}
+
+func init() {
+	for i := 1; i <= maxLuaResult; i++ {
+		results = append(results, luaResultToFilterResult(i))
+	}
+	httppipeline.Register(&LuaScript{})
+}
+
+type (
+	// LuaScript runs Lua scripts to manipulate the requests and responses.
+	LuaScript struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		onRequest  *lua.FunctionProto
+		onResponse *lua.FunctionProto
+		timeout    time.Duration
+		pool       *vmPool
+		dict       *sharedDict
+
+		requests  uint64
+		responses uint64
+		errors    uint64
+		timeouts  uint64
+	}
+
+	// Spec describes the LuaScript.
+	Spec struct {
+		// OnRequest is the script run before the request is passed to the
+		// next filter, its return value is the result of the filter.
+		OnRequest string `yaml:"onRequest" jsonschema:"omitempty"`
+		// OnResponse is the script run after the response is returned by
+		// the next filters, its return value is ignored.
+		OnResponse string `yaml:"onResponse" jsonschema:"omitempty"`
+
+		MaxConcurrency int `yaml:"maxConcurrency" jsonschema:"required,minimum=1"`
+		// Timeout is the maximum time of a run of a script.
+		Timeout string `yaml:"timeout" jsonschema:"required,format=duration"`
+		// MaxStackSize is the maximum number of the values in the stack.
+		MaxStackSize int `yaml:"maxStackSize" jsonschema:"required,minimum=1024"`
+		MaxCallDepth int `yaml:"maxCallDepth" jsonschema:"required,minimum=1"`
+		// MaxBodySize is the maximum size of the bodies and strings read
+		// or created by the API.
+		MaxBodySize    int64 `yaml:"maxBodySize" jsonschema:"required,minimum=1"`
+		SharedDictSize int   `yaml:"sharedDictSize" jsonschema:"required,minimum=1"`
+	}
+
+	// Status is the status of LuaScript.
+	Status struct {
+		Requests       uint64 `yaml:"requests"`
+		Responses      uint64 `yaml:"responses"`
+		Errors         uint64 `yaml:"errors"`
+		Timeouts       uint64 `yaml:"timeouts"`
+		SharedDictSize int    `yaml:"sharedDictSize"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	if spec.OnRequest == "" && spec.OnResponse == "" {
+		return fmt.Errorf("none of onRequest and onResponse is specified")
+	}
+	if spec.OnRequest != "" {
+		if _, err := compile(spec.OnRequest, "onRequest"); err != nil {
+			return fmt.Errorf("compile onRequest failed: %v", err)
+		}
+	}
+	if spec.OnResponse != "" {
+		if _, err := compile(spec.OnResponse, "onResponse"); err != nil {
+			return fmt.Errorf("compile onResponse failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of LuaScript.
+func (ls *LuaScript) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of LuaScript.
+func (ls *LuaScript) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConcurrency: 10,
+		Timeout:        "100ms",
+		MaxStackSize:   65536,
+		MaxCallDepth:   200,
+		MaxBodySize:    1 << 20,
+		SharedDictSize: 10000,
+	}
+}
+
+// Description returns the description of LuaScript.
+func (ls *LuaScript) Description() string {
+	return "LuaScript runs Lua scripts to manipulate the requests and responses."
+}
+
+// Results returns the results of LuaScript.
+func (ls *LuaScript) Results() []string {
+	return results
+}
+
+// Init initializes LuaScript.
+func (ls *LuaScript) Init(filterSpec *httppipeline.FilterSpec) {
+	ls.filterSpec, ls.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ls.dict = newSharedDict(ls.spec.SharedDictSize)
+	ls.reload()
+}
+
+// Inherit inherits previous generation of LuaScript.
+func (ls *LuaScript) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	ls.filterSpec, ls.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	// NOTE: The shared dict survives the updates of the scripts.
+	ls.dict = previousGeneration.(*LuaScript).dict
+	ls.dict.mutex.Lock()
+	ls.dict.maxSize = ls.spec.SharedDictSize
+	ls.dict.mutex.Unlock()
+	ls.reload()
+}
+
+func (ls *LuaScript) reload() {
+	var err error
+	if ls.spec.OnRequest != "" {
+		ls.onRequest, err = compile(ls.spec.OnRequest, "onRequest")
+		if err != nil {
+			logger.Errorf("BUG: compile onRequest failed: %v", err)
+		}
+	}
+	if ls.spec.OnResponse != "" {
+		ls.onResponse, err = compile(ls.spec.OnResponse, "onResponse")
+		if err != nil {
+			logger.Errorf("BUG: compile onResponse failed: %v", err)
+		}
+	}
+
+	ls.timeout, err = time.ParseDuration(ls.spec.Timeout)
+	if err != nil || ls.timeout <= 0 {
+		logger.Errorf("BUG: parse timeout %s failed: %v", ls.spec.Timeout, err)
+		ls.timeout = 100 * time.Millisecond
+	}
+
+	ls.pool = newVMPool(ls)
+}
+
+// Handle handles HTTP request.
+func (ls *LuaScript) Handle(ctx context.HTTPContext) string {
+	vars := map[string]interface{}{}
+
+	if ls.onRequest != nil {
+		atomic.AddUint64(&ls.requests, 1)
+		if result := ls.handleRequest(ctx, vars); result != "" {
+			return ctx.CallNextHandler(result)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+
+	if ls.onResponse != nil && result == "" {
+		atomic.AddUint64(&ls.responses, 1)
+		// NOTE: The response is kept as it is if the script fails.
+		ls.run(ctx, ls.onResponse, vars)
+	}
+
+	return result
+}
+
+func (ls *LuaScript) handleRequest(ctx context.HTTPContext, vars map[string]interface{}) string {
+	ret, result, err := ls.run(ctx, ls.onRequest, vars)
+	if err != nil {
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		return result
+	}
+
+	switch x := ret.(type) {
+	case *lua.LNilType:
+		return ""
+	case lua.LNumber:
+		n := int(x)
+		if lua.LNumber(n) == x && n >= 0 && n <= maxLuaResult {
+			return luaResultToFilterResult(n)
+		}
+	}
+
+	atomic.AddUint64(&ls.errors, 1)
+	ctx.AddTag(fmt.Sprintf("%s: %v", ls.filterSpec.Name(), errScriptResult))
+	ctx.Response().SetStatusCode(http.StatusInternalServerError)
+	return resultLuaError
+}
+
+// run runs the script on a VM of the pool, result is the filter result
+// if it fails.
+func (ls *LuaScript) run(ctx context.HTTPContext, proto *lua.FunctionProto,
+	vars map[string]interface{}) (ret lua.LValue, result string, err error) {
+
+	// NOTE: Save the pool to a local variable, the pool of the filter is
+	// replaced on reloading.
+	pool := ls.pool
+	v := pool.get(ctx)
+	if v == nil {
+		ctx.AddTag(fmt.Sprintf("%s: failed to get a Lua VM", ls.filterSpec.Name()))
+		return nil, resultOutOfVM, fmt.Errorf("no available VM")
+	}
+
+	ret, err = v.run(ctx, proto, vars)
+	if err == nil {
+		pool.put(v)
+		return ret, "", nil
+	}
+
+	// NOTE: The VM may be left in an inconsistent state by the error,
+	// so it is replaced by a new one.
+	v.close()
+	pool.put(nil)
+
+	if err == errTimeout {
+		atomic.AddUint64(&ls.timeouts, 1)
+		ctx.AddTag(fmt.Sprintf("%s: script timeout", ls.filterSpec.Name()))
+		return nil, resultTimeout, err
+	}
+
+	atomic.AddUint64(&ls.errors, 1)
+	ctx.AddTag(fmt.Sprintf("%s: %v", ls.filterSpec.Name(), err))
+	return nil, resultLuaError, err
+}
+
+// Status returns Status generated by LuaScript.
+func (ls *LuaScript) Status() interface{} {
+	return &Status{
+		Requests:       atomic.LoadUint64(&ls.requests),
+		Responses:      atomic.LoadUint64(&ls.responses),
+		Errors:         atomic.LoadUint64(&ls.errors),
+		Timeouts:       atomic.LoadUint64(&ls.timeouts),
+		SharedDictSize: ls.dict.size(),
+	}
+}
+
+// Close closes LuaScript.
+func (ls *LuaScript) Close() {
+	ls.pool.close()
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package luascript
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// doRequest handles a request, next is called as the next filters.
+func doRequest(ls *LuaScript, path, body string, next func(ctx context.HTTPContext)) (string, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	return httppipelinetest.Handle(ls, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult == "" && next != nil {
+			next(ctx)
+		}
+		return lastResult
+	})
+}
+
+func TestRequestAndResponse(t *testing.T) {
+	ls := httppipelinetest.InitFilter(t, &LuaScript{}, `
+kind: LuaScript
+name: lua
+onRequest: |
+  if req.header("X-Block") then
+    resp.setStatusCode(403)
+    return 1
+  end
+  req.setHeader("X-Path", req.path())
+  req.setPath("/v2" .. req.path())
+  req.setBody(string.upper(req.body()))
+  vars.start = "yes"
+onResponse: |
+  resp.setHeader("X-Vars", vars.start)
+  resp.setBody(resp.body() .. "!")
+`).(*LuaScript)
+	defer ls.Close()
+
+	var gotPath, gotHeader, gotBody string
+	result, w := doRequest(ls, "/users", "hello", func(ctx context.HTTPContext) {
+		r := ctx.Request()
+		gotPath, gotHeader = r.Path(), r.Header().Get("X-Path")
+		buf, _ := io.ReadAll(r.Body())
+		gotBody = string(buf)
+		ctx.Response().SetBody(strings.NewReader("world"))
+	})
+	if result != "" {
+		t.Fatalf("unexpected result %q", result)
+	}
+	if gotPath != "/v2/users" || gotHeader != "/users" || gotBody != "HELLO" {
+		t.Errorf("unexpected request %s, %s, %s", gotPath, gotHeader, gotBody)
+	}
+	if w.Header().Get("X-Vars") != "yes" || w.Body.String() != "world!" {
+		t.Errorf("unexpected response %v, %s", w.Header(), w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("X-Block", "1")
+	if result, w = httppipelinetest.Handle(ls, req, nil); result != "luaResult1" {
+		t.Errorf("want luaResult1, got %q", result)
+	}
+	if w.Code != 403 {
+		t.Errorf("want 403, got %d", w.Code)
+	}
+
+	s := ls.Status().(*Status)
+	if s.Requests != 2 || s.Responses != 1 || s.Errors != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestSharedDict(t *testing.T) {
+	ls := httppipelinetest.InitFilter(t, &LuaScript{}, `
+kind: LuaScript
+name: lua
+sharedDictSize: 2
+onRequest: |
+  local n = shared.incr("count")
+  req.setHeader("X-Count", tostring(n))
+  local ok = shared.set(req.path(), true)
+  req.setHeader("X-Set", tostring(ok))
+`).(*LuaScript)
+	defer ls.Close()
+
+	var count, set string
+	next := func(ctx context.HTTPContext) {
+		count, set = ctx.Request().Header().Get("X-Count"), ctx.Request().Header().Get("X-Set")
+	}
+
+	doRequest(ls, "/a", "", next)
+	if count != "1" || set != "true" {
+		t.Errorf("unexpected %s, %s", count, set)
+	}
+	doRequest(ls, "/b", "", next)
+	if count != "2" || set != "false" {
+		t.Errorf("shared dict should be full, got %s, %s", count, set)
+	}
+
+	// the shared dict survives the update of the scripts.
+	spec := httppipelinetest.NewFilterSpec(t, `
+kind: LuaScript
+name: lua
+onRequest: |
+  req.setHeader("X-Count", tostring(shared.get("count")))
+`)
+	newLS := &LuaScript{}
+	newLS.Inherit(spec, ls)
+	defer newLS.Close()
+	doRequest(newLS, "/c", "", next)
+	if count != "2" {
+		t.Errorf("want 2, got %s", count)
+	}
+
+	d := newSharedDict(1)
+	if !d.set("a", "x", 0) || d.set("b", "y", 0) {
+		t.Error("shared dict should be full")
+	}
+	if _, ok := d.incr("a", 1); ok {
+		t.Error("incr of a string should fail")
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	for _, script := range []string{
+		`os.exit(1)`,
+		`io.open("/etc/passwd")`,
+		`require("os")`,
+		`loadstring("return 1")()`,
+		`dofile("/etc/passwd")`,
+		`string.rep("x", 1024 * 1024 * 10)`,
+	} {
+		ls := httppipelinetest.InitFilter(t, &LuaScript{}, `
+kind: LuaScript
+name: lua
+maxBodySize: 1024
+onRequest: '`+script+`'
+`).(*LuaScript)
+		result, w := doRequest(ls, "/", "", nil)
+		if result != resultLuaError || w.Code != 500 {
+			t.Errorf("%s: want luaError, got %q, %d", script, result, w.Code)
+		}
+		ls.Close()
+	}
+
+	// globals set by a run are invisible to the others.
+	ls := httppipelinetest.InitFilter(t, &LuaScript{}, `
+kind: LuaScript
+name: lua
+maxConcurrency: 1
+onRequest: |
+  if seen then return 2 end
+  seen = true
+`).(*LuaScript)
+	defer ls.Close()
+	for i := 0; i < 2; i++ {
+		if result, _ := doRequest(ls, "/", "", nil); result != "" {
+			t.Errorf("unexpected result %q", result)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ls := httppipelinetest.InitFilter(t, &LuaScript{}, `
+kind: LuaScript
+name: lua
+timeout: 20ms
+onRequest: |
+  if req.path() == "/loop" then
+    while true do end
+  end
+  local function f(n) return f(n + 1) + 1 end
+  f(1)
+`).(*LuaScript)
+	defer ls.Close()
+
+	if result, _ := doRequest(ls, "/loop", "", nil); result != resultTimeout {
+		t.Errorf("want timeout, got %q", result)
+	}
+	if result, _ := doRequest(ls, "/recursion", "", nil); result != resultLuaError {
+		t.Errorf("want luaError, got %q", result)
+	}
+
+	s := ls.Status().(*Status)
+	if s.Timeouts != 1 || s.Errors != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []string{`
+kind: LuaScript
+name: lua
+`, `
+kind: LuaScript
+name: lua
+onRequest: 'if then'
+`, `
+kind: LuaScript
+name: lua
+onResponse: 'return'
+maxConcurrency: 0
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid: %s", spec)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package luascript
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// sharedDict is the dictionary shared by all VMs of a filter, its
+	// values are strings, numbers and booleans.
+	sharedDict struct {
+		mutex   sync.Mutex
+		maxSize int
+		items   map[string]*sharedItem
+	}
+
+	sharedItem struct {
+		value    interface{}
+		expireAt time.Time
+	}
+)
+
+func newSharedDict(maxSize int) *sharedDict {
+	return &sharedDict{
+		maxSize: maxSize,
+		items:   map[string]*sharedItem{},
+	}
+}
+
+func (item *sharedItem) expired(now time.Time) bool {
+	return !item.expireAt.IsZero() && !now.Before(item.expireAt)
+}
+
+func (d *sharedDict) get(key string) (interface{}, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	item, ok := d.items[key]
+	if !ok {
+		return nil, false
+	}
+	if item.expired(time.Now()) {
+		delete(d.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+// set sets the value of the key, the key never expires if ttl is 0. It
+// returns false if the dictionary is full.
+func (d *sharedDict) set(key string, value interface{}, ttl time.Duration) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.items[key]; !ok && !d.reserve() {
+		return false
+	}
+
+	item := &sharedItem{value: value}
+	if ttl > 0 {
+		item.expireAt = time.Now().Add(ttl)
+	}
+	d.items[key] = item
+	return true
+}
+
+// incr adds n to the number of the key, a missing key is regarded as 0.
+// It returns false if the value isn't a number or the dictionary is full.
+func (d *sharedDict) incr(key string, n float64) (float64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	item, ok := d.items[key]
+	if ok && item.expired(time.Now()) {
+		delete(d.items, key)
+		ok = false
+	}
+	if !ok {
+		if !d.reserve() {
+			return 0, false
+		}
+		d.items[key] = &sharedItem{value: n}
+		return n, true
+	}
+
+	v, isNumber := item.value.(float64)
+	if !isNumber {
+		return 0, false
+	}
+	item.value = v + n
+	return v + n, true
+}
+
+func (d *sharedDict) delete(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.items, key)
+}
+
+func (d *sharedDict) size() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.items)
+}
+
+// reserve makes room for a new key by removing the expired ones if the
+// dictionary is full, the caller must hold the lock.
+func (d *sharedDict) reserve() bool {
+	if len(d.items) < d.maxSize {
+		return true
+	}
+
+	now := time.Now()
+	for k, item := range d.items {
+		if item.expired(now) {
+			delete(d.items, k)
+		}
+	}
+	return len(d.items) < d.maxSize
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package luascript
+
+import (
+	"bytes"
+	stdcontext "context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const initialRegistrySize = 1024
+
+var errTimeout = errors.New("script timeout")
+
+// unsafeGlobals are the functions removed from the sandbox, they access
+// the file system, load code at runtime or print to the stdout.
+var unsafeGlobals = []string{
+	"collectgarbage", "dofile", "getfenv", "load", "loadfile", "loadstring",
+	"module", "newproxy", "print", "require", "setfenv", "_printregs",
+}
+
+type (
+	// vm is a Lua state with the sandboxed API, the API functions work
+	// on the HTTPContext attached to it.
+	vm struct {
+		host *LuaScript
+		L    *lua.LState
+		ctx  context.HTTPContext
+
+		req    *lua.LTable
+		resp   *lua.LTable
+		shared *lua.LTable
+	}
+
+	// vmPool is a pool of VMs, a nil VM in the pool is replaced by a new
+	// one when it is taken out.
+	vmPool struct {
+		host *LuaScript
+		chVM chan *vm
+	}
+)
+
+func compile(source, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+func newVM(host *LuaScript) *vm {
+	L := lua.NewState(lua.Options{
+		CallStackSize:   host.spec.MaxCallDepth,
+		RegistrySize:    initialRegistrySize,
+		RegistryMaxSize: host.spec.MaxStackSize,
+		SkipOpenLibs:    true,
+	})
+
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range unsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	v := &vm{host: host, L: L}
+
+	str := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	str.RawSetString("dump", lua.LNil)
+	str.RawSetString("rep", L.NewFunction(v.strRep))
+
+	L.SetGlobal("log", L.NewFunction(v.log))
+	L.SetGlobal("tag", L.NewFunction(v.tag))
+
+	v.req = L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"method":    v.reqMethod,
+		"scheme":    v.reqScheme,
+		"host":      v.reqHost,
+		"path":      v.reqPath,
+		"setPath":   v.reqSetPath,
+		"query":     v.reqQuery,
+		"setQuery":  v.reqSetQuery,
+		"realIP":    v.reqRealIP,
+		"header":    v.reqHeader,
+		"setHeader": v.reqSetHeader,
+		"addHeader": v.reqAddHeader,
+		"delHeader": v.reqDelHeader,
+		"cookie":    v.reqCookie,
+		"body":      v.reqBody,
+		"setBody":   v.reqSetBody,
+	})
+	v.resp = L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"statusCode":    v.respStatusCode,
+		"setStatusCode": v.respSetStatusCode,
+		"header":        v.respHeader,
+		"setHeader":     v.respSetHeader,
+		"addHeader":     v.respAddHeader,
+		"delHeader":     v.respDelHeader,
+		"body":          v.respBody,
+		"setBody":       v.respSetBody,
+	})
+	v.shared = L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get":    v.sharedGet,
+		"set":    v.sharedSet,
+		"incr":   v.sharedIncr,
+		"delete": v.sharedDelete,
+	})
+
+	return v
+}
+
+// run runs the script with the variables of the request, it returns the
+// first value returned by the script.
+func (v *vm) run(ctx context.HTTPContext, proto *lua.FunctionProto, vars map[string]interface{}) (lua.LValue, error) {
+	v.ctx = ctx
+	defer func() { v.ctx = nil }()
+
+	runCtx, cancel := stdcontext.WithTimeout(ctx, v.host.timeout)
+	defer cancel()
+	v.L.SetContext(runCtx)
+	defer v.L.RemoveContext()
+
+	// NOTE: Every run has its own environment, so that the globals set
+	// by a run are invisible to the others running on the same VM.
+	env := v.L.NewTable()
+	meta := v.L.NewTable()
+	meta.RawSetString("__index", v.L.Get(lua.GlobalsIndex))
+	v.L.SetMetatable(env, meta)
+
+	varsTable := v.toTable(vars)
+	env.RawSetString("req", v.req)
+	env.RawSetString("resp", v.resp)
+	env.RawSetString("shared", v.shared)
+	env.RawSetString("vars", varsTable)
+
+	fn := v.L.NewFunctionFromProto(proto)
+	fn.Env = env
+	v.L.Push(fn)
+	if err := v.L.PCall(0, 1, nil); err != nil {
+		if runCtx.Err() == stdcontext.DeadlineExceeded && ctx.Err() == nil {
+			return nil, errTimeout
+		}
+		return nil, err
+	}
+
+	ret := v.L.Get(-1)
+	v.L.Pop(1)
+
+	for k := range vars {
+		delete(vars, k)
+	}
+	varsTable.ForEach(func(k, val lua.LValue) {
+		if ks, ok := k.(lua.LString); ok {
+			if gv, ok := fromLValue(val); ok {
+				vars[string(ks)] = gv
+			}
+		}
+	})
+
+	return ret, nil
+}
+
+func (v *vm) close() {
+	v.L.Close()
+}
+
+func (v *vm) toTable(m map[string]interface{}) *lua.LTable {
+	t := v.L.NewTable()
+	for k, val := range m {
+		t.RawSetString(k, toLValue(val))
+	}
+	return t
+}
+
+// fromLValue converts the scalar Lua values to Go values, tables and
+// functions are not convertible.
+func fromLValue(v lua.LValue) (interface{}, bool) {
+	switch x := v.(type) {
+	case lua.LString:
+		return string(x), true
+	case lua.LNumber:
+		return float64(x), true
+	case lua.LBool:
+		return bool(x), true
+	default:
+		return nil, false
+	}
+}
+
+func toLValue(v interface{}) lua.LValue {
+	switch x := v.(type) {
+	case string:
+		return lua.LString(x)
+	case float64:
+		return lua.LNumber(x)
+	case bool:
+		return lua.LBool(x)
+	default:
+		return lua.LNil
+	}
+}
+
+func (v *vm) checkSize(n int) {
+	if int64(n) > v.host.spec.MaxBodySize {
+		v.L.RaiseError("size %d exceeds the limit %d", n, v.host.spec.MaxBodySize)
+	}
+}
+
+func (v *vm) strRep(L *lua.LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	v.checkSize(len(s) * n)
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+func (v *vm) log(L *lua.LState) int {
+	logger.Infof("%s: %s", v.host.filterSpec.Name(), L.CheckString(1))
+	return 0
+}
+
+func (v *vm) tag(L *lua.LState) int {
+	v.ctx.AddTag(v.host.filterSpec.Name() + ": " + L.CheckString(1))
+	return 0
+}
+
+func pushString(L *lua.LState, s string) int {
+	L.Push(lua.LString(s))
+	return 1
+}
+
+// pushHeader pushes the value of the header, or nil if it is absent.
+func pushHeader(L *lua.LState, h *httpheader.HTTPHeader) int {
+	name := L.CheckString(1)
+	if values := h.GetAll(name); len(values) > 0 {
+		return pushString(L, values[0])
+	}
+	L.Push(lua.LNil)
+	return 1
+}
+
+// readBody reads the body and sets it back, the body is nil if it's
+// larger than the limit.
+func (v *vm) readBody(body io.Reader, setBody func(io.Reader)) ([]byte, bool) {
+	if body == nil {
+		return nil, true
+	}
+
+	limit := v.host.spec.MaxBodySize
+	buf, err := io.ReadAll(io.LimitReader(body, limit+1))
+	setBody(io.MultiReader(bytes.NewReader(buf), body))
+	if err != nil || int64(len(buf)) > limit {
+		return nil, false
+	}
+	return buf, true
+}
+
+func (v *vm) pushBody(L *lua.LState, body io.Reader, setBody func(io.Reader)) int {
+	buf, ok := v.readBody(body, setBody)
+	if !ok {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("body too large"))
+		return 2
+	}
+	return pushString(L, string(buf))
+}
+
+func (v *vm) reqMethod(L *lua.LState) int { return pushString(L, v.ctx.Request().Method()) }
+func (v *vm) reqScheme(L *lua.LState) int { return pushString(L, v.ctx.Request().Scheme()) }
+func (v *vm) reqHost(L *lua.LState) int   { return pushString(L, v.ctx.Request().Host()) }
+func (v *vm) reqPath(L *lua.LState) int   { return pushString(L, v.ctx.Request().Path()) }
+func (v *vm) reqQuery(L *lua.LState) int  { return pushString(L, v.ctx.Request().Query()) }
+func (v *vm) reqRealIP(L *lua.LState) int { return pushString(L, v.ctx.Request().RealIP()) }
+
+func (v *vm) reqSetPath(L *lua.LState) int {
+	v.ctx.Request().SetPath(L.CheckString(1))
+	return 0
+}
+
+func (v *vm) reqSetQuery(L *lua.LState) int {
+	v.ctx.Request().SetQuery(L.CheckString(1))
+	return 0
+}
+
+func (v *vm) reqHeader(L *lua.LState) int {
+	return pushHeader(L, v.ctx.Request().Header())
+}
+
+func (v *vm) reqSetHeader(L *lua.LState) int {
+	v.ctx.Request().Header().Set(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) reqAddHeader(L *lua.LState) int {
+	v.ctx.Request().Header().Add(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) reqDelHeader(L *lua.LState) int {
+	v.ctx.Request().Header().Del(L.CheckString(1))
+	return 0
+}
+
+func (v *vm) reqCookie(L *lua.LState) int {
+	cookie, err := v.ctx.Request().Cookie(L.CheckString(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	return pushString(L, cookie.Value)
+}
+
+func (v *vm) reqBody(L *lua.LState) int {
+	r := v.ctx.Request()
+	return v.pushBody(L, r.Body(), r.SetBody)
+}
+
+func (v *vm) reqSetBody(L *lua.LState) int {
+	body := L.CheckString(1)
+	v.checkSize(len(body))
+	r := v.ctx.Request()
+	r.SetBody(strings.NewReader(body))
+	r.Header().Del(httpheader.KeyContentLength)
+	return 0
+}
+
+func (v *vm) respStatusCode(L *lua.LState) int {
+	L.Push(lua.LNumber(v.ctx.Response().StatusCode()))
+	return 1
+}
+
+func (v *vm) respSetStatusCode(L *lua.LState) int {
+	code := L.CheckInt(1)
+	if code < 100 || code > 599 {
+		L.ArgError(1, "invalid status code")
+	}
+	v.ctx.Response().SetStatusCode(code)
+	return 0
+}
+
+func (v *vm) respHeader(L *lua.LState) int {
+	return pushHeader(L, v.ctx.Response().Header())
+}
+
+func (v *vm) respSetHeader(L *lua.LState) int {
+	v.ctx.Response().Header().Set(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) respAddHeader(L *lua.LState) int {
+	v.ctx.Response().Header().Add(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) respDelHeader(L *lua.LState) int {
+	v.ctx.Response().Header().Del(L.CheckString(1))
+	return 0
+}
+
+func (v *vm) respBody(L *lua.LState) int {
+	w := v.ctx.Response()
+	return v.pushBody(L, w.Body(), w.SetBody)
+}
+
+func (v *vm) respSetBody(L *lua.LState) int {
+	body := L.CheckString(1)
+	v.checkSize(len(body))
+	w := v.ctx.Response()
+	w.SetBody(strings.NewReader(body))
+	w.Header().Del(httpheader.KeyContentLength)
+	return 0
+}
+
+func (v *vm) sharedGet(L *lua.LState) int {
+	value, ok := v.host.dict.get(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(toLValue(value))
+	return 1
+}
+
+func (v *vm) sharedSet(L *lua.LState) int {
+	key := L.CheckString(1)
+	value, ok := fromLValue(L.Get(2))
+	if !ok {
+		L.ArgError(2, "string, number or boolean expected")
+	}
+	ttl := time.Duration(float64(L.OptNumber(3, 0)) * float64(time.Second))
+
+	if !v.host.dict.set(key, value, ttl) {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("shared dict is full"))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func (v *vm) sharedIncr(L *lua.LState) int {
+	key := L.CheckString(1)
+	n := float64(L.OptNumber(2, 1))
+
+	value, ok := v.host.dict.incr(key, n)
+	if !ok {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("not a number or shared dict is full"))
+		return 2
+	}
+	L.Push(lua.LNumber(value))
+	return 1
+}
+
+func (v *vm) sharedDelete(L *lua.LState) int {
+	v.host.dict.delete(L.CheckString(1))
+	return 0
+}
+
+func newVMPool(host *LuaScript) *vmPool {
+	p := &vmPool{host: host, chVM: make(chan *vm, host.spec.MaxConcurrency)}
+	for i := 0; i < host.spec.MaxConcurrency; i++ {
+		// NOTE: The VMs are created on demand.
+		p.chVM <- nil
+	}
+	return p
+}
+
+// get gets a VM from the pool, it returns nil if the context is done
+// before a VM is available.
+func (p *vmPool) get(ctx stdcontext.Context) *vm {
+	select {
+	case v := <-p.chVM:
+		if v == nil {
+			v = newVM(p.host)
+		}
+		return v
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// put puts a VM back to the pool, a nil VM is put if the VM is broken.
+func (p *vmPool) put(v *vm) {
+	p.chVM <- v
+}
+
+func (p *vmPool) close() {
+	for i := 0; i < cap(p.chVM); i++ {
+		select {
+		case v := <-p.chVM:
+			if v != nil {
+				v.close()
+			}
+		default:
+			return
+		}
+	}
+}
@@ -18,12 +18,16 @@
 package mock
 
 import (
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+	"github.com/megaease/easegress/pkg/util/urlrule"
 )
 
 const (
@@ -55,14 +59,24 @@ type (
 
 	// Rule is the mock rule.
 	Rule struct {
-		Path       string            `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
-		PathPrefix string            `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
-		Code       int               `yaml:"code" jsonschema:"required,format=httpcode"`
-		Headers    map[string]string `yaml:"headers" jsonschema:"omitempty"`
-		Body       string            `yaml:"body" jsonschema:"omitempty"`
-		Delay      string            `yaml:"delay" jsonschema:"omitempty,format=duration"`
-
-		delay time.Duration
+		Path       string   `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix string   `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		Methods    []string `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		// MatchHeaders are the request headers must be matched, all of
+		// them must be matched if there are more than one.
+		MatchHeaders map[string]*urlrule.StringMatch `yaml:"matchHeaders,omitempty" jsonschema:"omitempty"`
+
+		Code int `yaml:"code" jsonschema:"required,format=httpcode"`
+		// Headers and Body could be templates of the pipeline, e.g.
+		// '[[filter.mock.req.header.X-Id]]'.
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body    string            `yaml:"body" jsonschema:"omitempty"`
+		Delay   string            `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		// DelayJitter is the maximum random duration added to the delay.
+		DelayJitter string `yaml:"delayJitter,omitempty" jsonschema:"omitempty,format=duration"`
+
+		delay       time.Duration
+		delayJitter time.Duration
 	}
 )
 
@@ -100,10 +114,15 @@ func (m *Mock) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration h
 
 func (m *Mock) reload() {
 	for _, r := range m.spec.Rules {
-		if r.Delay == "" {
-			continue
+		for _, sm := range r.MatchHeaders {
+			sm.Init()
+		}
+		if r.Delay != "" {
+			r.delay, _ = time.ParseDuration(r.Delay)
+		}
+		if r.DelayJitter != "" {
+			r.delayJitter, _ = time.ParseDuration(r.DelayJitter)
 		}
-		r.delay, _ = time.ParseDuration(r.Delay)
 	}
 }
 
@@ -118,43 +137,75 @@ func (m *Mock) handle(ctx context.HTTPContext) (result string) {
 	w := ctx.Response()
 
 	mock := func(rule *Rule) {
+		hte := ctx.Template()
+
 		w.SetStatusCode(rule.Code)
 		for key, value := range rule.Headers {
-			w.Header().Set(key, value)
+			w.Header().Set(key, render(value, hte))
 		}
-		w.SetBody(strings.NewReader(rule.Body))
+		w.SetBody(strings.NewReader(render(rule.Body, hte)))
 		result = resultMocked
 
-		if rule.delay <= 0 {
+		delay := rule.delay
+		if rule.delayJitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(rule.delayJitter)))
+		}
+		if delay <= 0 {
 			return
 		}
 
-		logger.Debugf("delay for %v ...", rule.delay)
+		logger.Debugf("delay for %v ...", delay)
 		select {
 		case <-ctx.Done():
 			logger.Debugf("request cancelled in the middle of delay mocking")
-		case <-time.After(rule.delay):
+		case <-time.After(delay):
 		}
 	}
 
 	for _, rule := range m.spec.Rules {
-		if rule.Path == "" && rule.PathPrefix == "" {
+		if rule.match(ctx, path) {
 			mock(rule)
 			return
 		}
+	}
 
-		if rule.Path == path {
-			mock(rule)
-			return
+	return ""
+}
+
+// match reports whether the request matches the rule, the path is
+// not checked if both path and pathPrefix are empty.
+func (rule *Rule) match(ctx context.HTTPContext, path string) bool {
+	if rule.Path != "" || rule.PathPrefix != "" {
+		if rule.Path != path && (rule.PathPrefix == "" || !strings.HasPrefix(path, rule.PathPrefix)) {
+			return false
 		}
+	}
 
-		if rule.PathPrefix != "" && strings.HasPrefix(path, rule.PathPrefix) {
-			mock(rule)
-			return
+	r := ctx.Request()
+	if len(rule.Methods) > 0 && !stringtool.StrInSlice(r.Method(), rule.Methods) {
+		return false
+	}
+
+	for name, sm := range rule.MatchHeaders {
+		if !sm.Match(r.Header().Get(name)) {
+			return false
 		}
 	}
 
-	return ""
+	return true
+}
+
+func render(s string, hte texttemplate.TemplateEngine) string {
+	if hte == nil || !hte.HasTemplates(s) {
+		return s
+	}
+
+	rendered, err := hte.Render(s)
+	if err != nil {
+		logger.Errorf("BUG mock render failed, template %s, err %v", s, err)
+		return s
+	}
+	return rendered
 }
 
 // Status returns status.
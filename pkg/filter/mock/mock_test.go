@@ -23,10 +23,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
@@ -132,3 +135,77 @@ rules:
 		t.Error("status code is not 204")
 	}
 }
+
+func TestMatchAndTemplate(t *testing.T) {
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- pathPrefix: /users/
+  methods: [POST]
+  code: 201
+  body: created
+- pathPrefix: /users/
+  matchHeaders:
+    X-Tenant:
+      regex: "^t[0-9]+$"
+  code: 200
+  headers:
+    X-Tenant: '[[filter.mock.req.header.X-Tenant]]'
+  body: '{"path": "[[filter.mock.req.path]]", "method": "[[filter.mock.req.method]]"}'
+  delay: 10ms
+  delayJitter: 10ms
+- code: 404
+  body: not found
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := &Mock{}
+	m.Init(spec)
+	defer m.Close()
+
+	ht, err := context.NewHTTPTemplate([]context.FilterBuff{{Name: "mock", Buff: []byte(yamlSpec)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	do := func(method, target, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		ctx, w := httppipelinetest.NewContext(req, nil)
+		ctx.SetTemplate(ht)
+		if err := ctx.SaveReqToTemplate("mock"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m.Handle(ctx)
+		ctx.Finish()
+		return w
+	}
+
+	if w := do(http.MethodPost, "/users/1", ""); w.Code != 201 || w.Body.String() != "created" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	start := time.Now()
+	w := do(http.MethodGet, "/users/1?name=alice", "t1")
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("response should be delayed, got %v", d)
+	}
+	if w.Code != 200 || w.Header().Get("X-Tenant") != "t1" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if want := `{"path": "/users/1", "method": "GET"}`; w.Body.String() != want {
+		t.Errorf("want body %q, got %q", want, w.Body.String())
+	}
+
+	if w := do(http.MethodGet, "/users/1", "admin"); w.Code != 404 || w.Body.String() != "not found" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsoutput
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+)
+
+const (
+	// Kind is the kind of NATSOutput.
+	Kind = "NATSOutput"
+
+	resultPublishFailed = "publishFailed"
+
+	defaultAckWait = 5 * time.Second
+)
+
+var results = []string{resultPublishFailed}
+
+func init() {
+	httppipeline.Register(&NATSOutput{})
+}
+
+type (
+	// NATSOutput is filter NATSOutput.
+	NATSOutput struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		conn    *nats.Conn
+		js      nats.JetStreamContext
+		ackWait time.Duration
+
+		published uint64
+		acked     uint64
+		failed    uint64
+		lastError atomic.Value // string
+	}
+
+	// Spec is the spec of NATSOutput.
+	Spec struct {
+		Servers []string `yaml:"servers" jsonschema:"required,uniqueItems=true"`
+		// Subject and the values of Headers could be templates of the pipeline.
+		Subject string            `yaml:"subject" jsonschema:"required"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+
+		// JetStream publishes messages to JetStream and waits for the
+		// acknowledgements, which makes at-least-once delivery.
+		JetStream bool   `yaml:"jetStream" jsonschema:"omitempty"`
+		AckWait   string `yaml:"ackWait" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of NATSOutput.
+	Status struct {
+		// Published is the number of messages published.
+		Published uint64 `yaml:"published"`
+		// Acked is the number of messages acknowledged by JetStream, it is
+		// always 0 if JetStream is disabled.
+		Acked uint64 `yaml:"acked"`
+		// Failed is the number of messages failed to be published.
+		Failed    uint64 `yaml:"failed"`
+		Connected bool   `yaml:"connected"`
+		LastError string `yaml:"lastError,omitempty"`
+	}
+)
+
+// Kind returns the kind of NATSOutput.
+func (no *NATSOutput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of NATSOutput.
+func (no *NATSOutput) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of NATSOutput.
+func (no *NATSOutput) Description() string {
+	return "NATSOutput publishes the request body to NATS or NATS JetStream."
+}
+
+// Results returns the results of NATSOutput.
+func (no *NATSOutput) Results() []string {
+	return results
+}
+
+// Init initializes NATSOutput.
+func (no *NATSOutput) Init(filterSpec *httppipeline.FilterSpec) {
+	no.filterSpec, no.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	no.reload()
+}
+
+// Inherit inherits previous generation of NATSOutput.
+func (no *NATSOutput) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	no.Init(filterSpec)
+}
+
+func (no *NATSOutput) reload() {
+	no.lastError.Store("")
+
+	no.ackWait = defaultAckWait
+	if no.spec.AckWait != "" {
+		// NOTE: The duration has been checked in validation.
+		no.ackWait, _ = time.ParseDuration(no.spec.AckWait)
+	}
+
+	// NOTE: The client reconnects the servers in background forever,
+	// and buffers the messages published during reconnecting.
+	conn, err := nats.Connect(strings.Join(no.spec.Servers, ","),
+		nats.Name(no.filterSpec.Name()),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+	)
+	if err != nil {
+		logger.Errorf("%s: connect nats servers %v failed: %v", no.filterSpec.Name(), no.spec.Servers, err)
+		no.lastError.Store(err.Error())
+		return
+	}
+	no.conn = conn
+
+	if no.spec.JetStream {
+		no.js, err = conn.JetStream()
+		if err != nil {
+			logger.Errorf("%s: create jetstream context failed: %v", no.filterSpec.Name(), err)
+			no.lastError.Store(err.Error())
+		}
+	}
+}
+
+// Handle publishes the request body to NATS.
+func (no *NATSOutput) Handle(ctx context.HTTPContext) string {
+	result := no.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (no *NATSOutput) handle(ctx context.HTTPContext) string {
+	err := no.publish(ctx)
+	if err != nil {
+		atomic.AddUint64(&no.failed, 1)
+		no.lastError.Store(err.Error())
+		ctx.AddTag(fmt.Sprintf("natsOutput: %v", err))
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		return resultPublishFailed
+	}
+	return ""
+}
+
+func render(hte texttemplate.TemplateEngine, s string) (string, error) {
+	if hte == nil || !hte.HasTemplates(s) {
+		return s, nil
+	}
+	return hte.Render(s)
+}
+
+func (no *NATSOutput) buildMessage(ctx context.HTTPContext) (*nats.Msg, error) {
+	hte := ctx.Template()
+
+	subject, err := render(hte, no.spec.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("render subject failed: %v", err)
+	}
+	msg := nats.NewMsg(subject)
+
+	for k, v := range no.spec.Headers {
+		value, err := render(hte, v)
+		if err != nil {
+			return nil, fmt.Errorf("render header %s failed: %v", k, err)
+		}
+		msg.Header.Set(k, value)
+	}
+
+	// NOTE: The request body must be set back for the following filters.
+	body, err := io.ReadAll(ctx.Request().Body())
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %v", err)
+	}
+	ctx.Request().SetBody(bytes.NewReader(body))
+	msg.Data = body
+
+	return msg, nil
+}
+
+func (no *NATSOutput) publish(ctx context.HTTPContext) error {
+	if no.conn == nil || (no.spec.JetStream && no.js == nil) {
+		return fmt.Errorf("nats not available: %s", no.lastError.Load().(string))
+	}
+
+	msg, err := no.buildMessage(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !no.spec.JetStream {
+		if err = no.conn.PublishMsg(msg); err != nil {
+			return fmt.Errorf("publish failed: %v", err)
+		}
+		atomic.AddUint64(&no.published, 1)
+		return nil
+	}
+
+	if _, err = no.js.PublishMsg(msg, nats.AckWait(no.ackWait)); err != nil {
+		return fmt.Errorf("publish to jetstream failed: %v", err)
+	}
+	atomic.AddUint64(&no.published, 1)
+	atomic.AddUint64(&no.acked, 1)
+	return nil
+}
+
+// Status returns status.
+func (no *NATSOutput) Status() interface{} {
+	return &Status{
+		Published: atomic.LoadUint64(&no.published),
+		Acked:     atomic.LoadUint64(&no.acked),
+		Failed:    atomic.LoadUint64(&no.failed),
+		Connected: no.conn != nil && no.conn.IsConnected(),
+		LastError: no.lastError.Load().(string),
+	}
+}
+
+// Close closes NATSOutput.
+func (no *NATSOutput) Close() {
+	if no.conn == nil {
+		return
+	}
+
+	// NOTE: Flush the buffered messages before closing.
+	if err := no.conn.Drain(); err != nil {
+		logger.Errorf("%s: drain nats connection failed: %v", no.filterSpec.Name(), err)
+		no.conn.Close()
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsoutput
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newMockedContext(body string, statusCode *int) *contexttest.MockedHTTPContext {
+	var reader io.Reader = strings.NewReader(body)
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedBody = func() io.Reader { return reader }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { reader = r }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { *statusCode = code }
+	return ctx
+}
+
+func TestPublishWhileReconnecting(t *testing.T) {
+	const yamlSpec = `
+kind: NATSOutput
+name: nats
+servers: [nats://127.0.0.1:1]
+subject: orders
+`
+	no := httppipelinetest.InitFilter(t, &NATSOutput{}, yamlSpec).(*NATSOutput)
+	defer no.Close()
+
+	statusCode := 0
+	ctx := newMockedContext("hello", &statusCode)
+	if result := no.handle(ctx); result != "" {
+		t.Errorf("message should be buffered during reconnecting, got result %q", result)
+	}
+	if b, _ := io.ReadAll(ctx.Request().Body()); string(b) != "hello" {
+		t.Errorf("request body should be kept, got %q", b)
+	}
+
+	s := no.Status().(*Status)
+	if s.Published != 1 || s.Failed != 0 || s.Connected {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestJetStreamNotAvailable(t *testing.T) {
+	const yamlSpec = `
+kind: NATSOutput
+name: nats
+servers: [nats://127.0.0.1:1]
+subject: orders
+jetStream: true
+ackWait: 100ms
+`
+	no := httppipelinetest.InitFilter(t, &NATSOutput{}, yamlSpec).(*NATSOutput)
+	defer no.Close()
+
+	statusCode := 0
+	ctx := newMockedContext("hello", &statusCode)
+	if result := no.handle(ctx); result != resultPublishFailed {
+		t.Errorf("result should be %s, got %q", resultPublishFailed, result)
+	}
+	if statusCode != http.StatusServiceUnavailable {
+		t.Errorf("status code should be 503, got %d", statusCode)
+	}
+
+	s := no.Status().(*Status)
+	if s.Published != 0 || s.Acked != 0 || s.Failed != 1 || s.LastError == "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
@@ -0,0 +1,508 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filter/validator"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of OIDC.
+	Kind = "OIDC"
+
+	resultUnauthenticated = "unauthenticated"
+	resultAuthFailed      = "authFailed"
+	resultRedirected      = "redirected"
+
+	keyLocation      = "Location"
+	keyAuthorization = "Authorization"
+	keyCacheControl  = "Cache-Control"
+
+	defaultCookieName       = "EG_OIDC"
+	defaultSessionTTL       = 24 * time.Hour
+	defaultTimeout          = 10 * time.Second
+	defaultIDTokenAlgorithm = "RS256"
+
+	// authStateTTL is the time a user has to finish the login at the
+	// OpenID provider.
+	authStateTTL = 10 * time.Minute
+	// expiryMargin refreshes the access token a bit earlier to avoid
+	// it expires on the way to the backends.
+	expiryMargin = 10 * time.Second
+)
+
+var (
+	results = []string{resultUnauthenticated, resultAuthFailed, resultRedirected}
+
+	defaultScopes = []string{"openid", "profile", "email"}
+)
+
+func init() {
+	httppipeline.Register(&OIDC{})
+}
+
+type (
+	// OIDC is the filter of an OpenID Connect relying party, it logs
+	// users in by the authorization code flow with PKCE, and keeps the
+	// sessions in encrypted cookies.
+	OIDC struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider     *provider
+		codec        *cookieCodec
+		redirectURL  string
+		callbackPath string
+		scopes       []string
+		sessionTTL   time.Duration
+
+		logins    uint64
+		refreshes uint64
+		failures  uint64
+	}
+
+	// Spec describes the OIDC.
+	Spec struct {
+		// Issuer is used to discover the endpoints of the provider.
+		Issuer string `yaml:"issuer" jsonschema:"omitempty,format=url"`
+		// The endpoints are used instead of the discovered ones if the
+		// authorization endpoint is specified.
+		AuthorizationEndpoint string `yaml:"authorizationEndpoint" jsonschema:"omitempty,format=url"`
+		TokenEndpoint         string `yaml:"tokenEndpoint" jsonschema:"omitempty,format=url"`
+		JWKSURI               string `yaml:"jwksUri" jsonschema:"omitempty,format=url"`
+		EndSessionEndpoint    string `yaml:"endSessionEndpoint" jsonschema:"omitempty,format=url"`
+
+		ClientID     string `yaml:"clientId" jsonschema:"required"`
+		ClientSecret string `yaml:"clientSecret" jsonschema:"omitempty"`
+		// RedirectURL is the callback URL registered at the provider,
+		// requests to its path are handled by the filter.
+		RedirectURL      string   `yaml:"redirectUrl" jsonschema:"required,format=url"`
+		Scopes           []string `yaml:"scopes" jsonschema:"omitempty,uniqueItems=true"`
+		IDTokenAlgorithm string   `yaml:"idTokenAlgorithm,omitempty" jsonschema:"omitempty,enum=RS256,enum=RS384,enum=RS512,enum=ES256,enum=ES384,enum=ES512"`
+		Leeway           string   `yaml:"leeway" jsonschema:"omitempty,format=duration"`
+
+		CookieName     string `yaml:"cookieName" jsonschema:"omitempty"`
+		CookieSecret   string `yaml:"cookieSecret" jsonschema:"required,minLength=16"`
+		CookieDomain   string `yaml:"cookieDomain" jsonschema:"omitempty"`
+		InsecureCookie bool   `yaml:"insecureCookie" jsonschema:"omitempty"`
+		SessionTTL     string `yaml:"sessionTTL" jsonschema:"omitempty,format=duration"`
+
+		LogoutPath            string `yaml:"logoutPath,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PostLogoutRedirectURL string `yaml:"postLogoutRedirectUrl" jsonschema:"omitempty,format=url"`
+
+		ClaimsToHeaders map[string]string `yaml:"claimsToHeaders" jsonschema:"omitempty"`
+		PassAccessToken bool              `yaml:"passAccessToken" jsonschema:"omitempty"`
+
+		Timeout     string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		InsecureTLS bool   `yaml:"insecureTls" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of OIDC.
+	Status struct {
+		Logins    uint64 `yaml:"logins"`
+		Refreshes uint64 `yaml:"refreshes"`
+		Failures  uint64 `yaml:"failures"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if spec.AuthorizationEndpoint != "" {
+		if spec.TokenEndpoint == "" || spec.JWKSURI == "" {
+			return fmt.Errorf("tokenEndpoint and jwksUri are required with authorizationEndpoint")
+		}
+	} else if spec.Issuer == "" {
+		return fmt.Errorf("issuer or authorizationEndpoint should be specified")
+	}
+
+	u, err := url.Parse(spec.RedirectURL)
+	if err != nil {
+		return err
+	}
+	if u.Path == "" || u.Path == spec.LogoutPath {
+		return fmt.Errorf("invalid path of redirectUrl: %q", u.Path)
+	}
+
+	return nil
+}
+
+// Kind returns the kind of OIDC.
+func (o *OIDC) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of OIDC.
+func (o *OIDC) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of OIDC.
+func (o *OIDC) Description() string {
+	return "OIDC logs users in by OpenID Connect authorization code flow."
+}
+
+// Results returns the results of OIDC.
+func (o *OIDC) Results() []string {
+	return results
+}
+
+// Init initializes OIDC.
+func (o *OIDC) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.reload()
+}
+
+// Inherit inherits previous generation of OIDC.
+func (o *OIDC) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	o.Init(filterSpec)
+}
+
+func (o *OIDC) reload() {
+	spec := o.spec
+
+	if spec.CookieName == "" {
+		spec.CookieName = defaultCookieName
+	}
+	if spec.IDTokenAlgorithm == "" {
+		spec.IDTokenAlgorithm = defaultIDTokenAlgorithm
+	}
+
+	timeout := defaultTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	o.sessionTTL = defaultSessionTTL
+	if spec.SessionTTL != "" {
+		o.sessionTTL, _ = time.ParseDuration(spec.SessionTTL)
+	}
+
+	o.scopes = spec.Scopes
+	if len(o.scopes) == 0 {
+		o.scopes = defaultScopes
+	}
+
+	// NOTE: The redirect URL has been checked by Validate.
+	u, _ := url.Parse(spec.RedirectURL)
+	o.redirectURL, o.callbackPath = spec.RedirectURL, u.Path
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: spec.InsecureTLS}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	o.provider = newProvider(spec, client)
+	o.codec = newCookieCodec(spec.CookieSecret, spec.CookieDomain, !spec.InsecureCookie)
+}
+
+func (o *OIDC) stateCookieName() string {
+	return o.spec.CookieName + "_state"
+}
+
+// Handle authenticates the request.
+func (o *OIDC) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *OIDC) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	// NOTE: Remove the headers from the client, so they can't be forged.
+	for _, header := range o.spec.ClaimsToHeaders {
+		r.Header().Del(header)
+	}
+
+	switch r.Path() {
+	case o.callbackPath:
+		return o.callback(ctx)
+	case o.spec.LogoutPath:
+		if o.spec.LogoutPath != "" {
+			return o.logout(ctx)
+		}
+	}
+
+	s := &session{}
+	if err := o.codec.read(r, o.spec.CookieName, s); err != nil {
+		if err != http.ErrNoCookie {
+			logger.Debugf("%s: read session failed: %v", o.filterSpec.Name(), err)
+		}
+		return o.authenticate(ctx)
+	}
+
+	now := time.Now()
+	if now.After(time.Unix(s.Created, 0).Add(o.sessionTTL)) {
+		return o.authenticate(ctx)
+	}
+
+	if s.Expiry != 0 && now.Add(expiryMargin).After(time.Unix(s.Expiry, 0)) {
+		if s.RefreshToken == "" || !o.refresh(ctx, s) {
+			return o.authenticate(ctx)
+		}
+	}
+
+	removeCookies(r, o.spec.CookieName, o.stateCookieName())
+	for claim, header := range o.spec.ClaimsToHeaders {
+		if value, ok := s.Claims[claim]; ok {
+			r.Header().Set(header, validator.ClaimToString(value))
+		}
+	}
+	if o.spec.PassAccessToken {
+		r.Header().Set(keyAuthorization, "Bearer "+s.AccessToken)
+	}
+
+	return ""
+}
+
+// authenticate redirects the user to the authorization endpoint, requests
+// which can't be redirected, like AJAX requests, are rejected.
+func (o *OIDC) authenticate(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	redirectable := (r.Method() == http.MethodGet || r.Method() == http.MethodHead) &&
+		r.Header().Get("X-Requested-With") != "XMLHttpRequest"
+	if !redirectable {
+		w.SetStatusCode(http.StatusUnauthorized)
+		return resultUnauthenticated
+	}
+
+	md, _, err := o.provider.get()
+	if err != nil {
+		logger.Errorf("%s: %v", o.filterSpec.Name(), err)
+		atomic.AddUint64(&o.failures, 1)
+		w.SetStatusCode(http.StatusBadGateway)
+		return resultAuthFailed
+	}
+
+	state := &authState{
+		State:    randomString(24),
+		Nonce:    randomString(24),
+		Verifier: randomString(32),
+		URL:      r.Std().URL.RequestURI(),
+		Created:  time.Now().Unix(),
+	}
+	if err = o.codec.write(ctx, o.stateCookieName(), state, authStateTTL); err != nil {
+		logger.Errorf("%s: write state cookie failed: %v", o.filterSpec.Name(), err)
+		w.SetStatusCode(http.StatusInternalServerError)
+		return resultAuthFailed
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.spec.ClientID)
+	query.Set("redirect_uri", o.redirectURL)
+	query.Set("scope", strings.Join(o.scopes, " "))
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", codeChallenge(state.Verifier))
+	query.Set("code_challenge_method", "S256")
+
+	o.redirect(ctx, addQuery(md.AuthorizationEndpoint, query))
+	return resultUnauthenticated
+}
+
+// callback exchanges the authorization code for the tokens, and creates
+// the session.
+func (o *OIDC) callback(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+	query := r.Std().URL.Query()
+
+	fail := func(code int, format string, args ...interface{}) string {
+		logger.Warnf("%s: "+format, append([]interface{}{o.filterSpec.Name()}, args...)...)
+		atomic.AddUint64(&o.failures, 1)
+		w.SetStatusCode(code)
+		return resultAuthFailed
+	}
+
+	if e := query.Get("error"); e != "" {
+		return fail(http.StatusUnauthorized, "authorization failed: %s %s", e, query.Get("error_description"))
+	}
+
+	state := &authState{}
+	if err := o.codec.read(r, o.stateCookieName(), state); err != nil {
+		return fail(http.StatusUnauthorized, "read state cookie failed: %v", err)
+	}
+	o.codec.clear(ctx, o.stateCookieName())
+
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		return fail(http.StatusUnauthorized, "state mismatch")
+	}
+	if time.Since(time.Unix(state.Created, 0)) > authStateTTL {
+		return fail(http.StatusUnauthorized, "authorization expired")
+	}
+
+	md, idTokenValidator, err := o.provider.get()
+	if err != nil {
+		return fail(http.StatusBadGateway, "%v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", query.Get("code"))
+	form.Set("redirect_uri", o.redirectURL)
+	form.Set("code_verifier", state.Verifier)
+
+	tr, err := o.provider.token(md, form)
+	if err != nil {
+		return fail(http.StatusBadGateway, "exchange code failed: %v", err)
+	}
+	if tr.IDToken == "" {
+		return fail(http.StatusBadGateway, "no id token")
+	}
+
+	claims, err := idTokenValidator.ValidateToken(tr.IDToken)
+	if err != nil {
+		return fail(http.StatusUnauthorized, "invalid id token: %v", err)
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(state.Nonce)) != 1 {
+		return fail(http.StatusUnauthorized, "nonce mismatch")
+	}
+
+	now := time.Now()
+	s := &session{
+		Claims:       claims,
+		AccessToken:  tr.AccessToken,
+		RefreshToken: tr.RefreshToken,
+		Created:      now.Unix(),
+	}
+	if expiry := tr.expiry(now); !expiry.IsZero() {
+		s.Expiry = expiry.Unix()
+	}
+	if err = o.codec.write(ctx, o.spec.CookieName, s, o.sessionTTL); err != nil {
+		return fail(http.StatusInternalServerError, "write session cookie failed: %v", err)
+	}
+
+	atomic.AddUint64(&o.logins, 1)
+	// NOTE: Only redirect to paths of this site.
+	if !strings.HasPrefix(state.URL, "/") || strings.HasPrefix(state.URL, "//") || strings.HasPrefix(state.URL, "/\\") {
+		state.URL = "/"
+	}
+	o.redirect(ctx, state.URL)
+	return resultRedirected
+}
+
+// refresh refreshes the tokens of the session, it returns false if it
+// fails.
+func (o *OIDC) refresh(ctx context.HTTPContext, s *session) bool {
+	md, idTokenValidator, err := o.provider.get()
+	if err != nil {
+		logger.Errorf("%s: %v", o.filterSpec.Name(), err)
+		return false
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", s.RefreshToken)
+
+	tr, err := o.provider.token(md, form)
+	if err != nil {
+		logger.Warnf("%s: refresh token failed: %v", o.filterSpec.Name(), err)
+		return false
+	}
+
+	if tr.IDToken != "" {
+		claims, err := idTokenValidator.ValidateToken(tr.IDToken)
+		if err != nil {
+			logger.Warnf("%s: invalid id token: %v", o.filterSpec.Name(), err)
+			return false
+		}
+		s.Claims = claims
+	}
+
+	s.AccessToken = tr.AccessToken
+	if tr.RefreshToken != "" {
+		s.RefreshToken = tr.RefreshToken
+	}
+	s.Expiry = 0
+	if expiry := tr.expiry(time.Now()); !expiry.IsZero() {
+		s.Expiry = expiry.Unix()
+	}
+
+	// NOTE: The cookies are kept until the end of the session.
+	maxAge := time.Until(time.Unix(s.Created, 0).Add(o.sessionTTL))
+	if err = o.codec.write(ctx, o.spec.CookieName, s, maxAge); err != nil {
+		logger.Errorf("%s: write session cookie failed: %v", o.filterSpec.Name(), err)
+		return false
+	}
+
+	atomic.AddUint64(&o.refreshes, 1)
+	return true
+}
+
+// logout removes the session, and redirects the user to the end session
+// endpoint of the provider if there is one.
+func (o *OIDC) logout(ctx context.HTTPContext) string {
+	o.codec.clear(ctx, o.spec.CookieName)
+
+	target := o.spec.PostLogoutRedirectURL
+	if target == "" {
+		target = "/"
+	}
+
+	md, _, err := o.provider.get()
+	if err == nil && md.EndSessionEndpoint != "" {
+		query := url.Values{}
+		query.Set("client_id", o.spec.ClientID)
+		if o.spec.PostLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", o.spec.PostLogoutRedirectURL)
+		}
+		target = addQuery(md.EndSessionEndpoint, query)
+	}
+
+	o.redirect(ctx, target)
+	return resultRedirected
+}
+
+func (o *OIDC) redirect(ctx context.HTTPContext, location string) {
+	w := ctx.Response()
+	w.Header().Set(keyLocation, location)
+	w.Header().Set(keyCacheControl, "no-store")
+	w.SetStatusCode(http.StatusFound)
+}
+
+func addQuery(endpoint string, query url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query.Encode()
+	}
+	return endpoint + "?" + query.Encode()
+}
+
+// Status returns status.
+func (o *OIDC) Status() interface{} {
+	return &Status{
+		Logins:    atomic.LoadUint64(&o.logins),
+		Refreshes: atomic.LoadUint64(&o.refreshes),
+		Failures:  atomic.LoadUint64(&o.failures),
+	}
+}
+
+// Close closes OIDC.
+func (o *OIDC) Close() {
+	o.provider.close()
+}
@@ -0,0 +1,407 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// fakeProvider is a minimal OpenID provider.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mutex      sync.Mutex
+	challenges map[string]string // code -> code challenge
+	nonces     map[string]string // code -> nonce
+	expiresIn  int64
+	refreshed  int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	fp := &fakeProvider{
+		key:        key,
+		challenges: map[string]string{},
+		nonces:     map[string]string{},
+		expiresIn:  3600,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fp.server.URL,
+			"authorization_endpoint": fp.server.URL + "/auth",
+			"token_endpoint":         fp.server.URL + "/token",
+			"jwks_uri":               fp.server.URL + "/jwks",
+			"end_session_endpoint":   fp.server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fp.mutex.Lock()
+		defer fp.mutex.Unlock()
+
+		id, secret, _ := r.BasicAuth()
+		if id != "easegress" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		nonce := ""
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			code := r.FormValue("code")
+			if codeChallenge(r.FormValue("code_verifier")) != fp.challenges[code] {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			nonce = fp.nonces[code]
+			delete(fp.challenges, code)
+		case "refresh_token":
+			if r.FormValue("refresh_token") != "refresh-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			fp.refreshed++
+		}
+
+		claims := jwt.MapClaims{
+			"iss": fp.server.URL,
+			"aud": "easegress",
+			"sub": "alice",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key1"
+		idToken, _ := token.SignedString(key)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access-token-%d", fp.refreshed),
+			"refresh_token": "refresh-token",
+			"expires_in":    fp.expiresIn,
+			"id_token":      idToken,
+		})
+	})
+	fp.server = httptest.NewServer(mux)
+	t.Cleanup(fp.server.Close)
+	return fp
+}
+
+// authorize simulates the login of the user, and returns the code.
+func (fp *fakeProvider) authorize(t *testing.T, location string) (string, string) {
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, fp.server.URL+"/auth?") {
+		t.Fatalf("unexpected location: %s", location)
+	}
+
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request: %s", location)
+	}
+
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	code := randomString(8)
+	fp.challenges[code] = query.Get("code_challenge")
+	fp.nonces[code] = query.Get("nonce")
+	return code, query.Get("state")
+}
+
+// do handles the request, and returns the response, the result and the
+// header of the request to the backend.
+func do(o *OIDC, method, target string, cookies []*http.Cookie, header http.Header) (*http.Response, string, http.Header) {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	var upstream http.Header
+	result, w := httppipelinetest.Handle(o, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult == "" {
+			upstream = ctx.Request().Std().Header.Clone()
+		}
+		return lastResult
+	})
+	return w.Result(), result, upstream
+}
+
+// login logs the user in, and returns the session cookies.
+func login(t *testing.T, o *OIDC, fp *fakeProvider) []*http.Cookie {
+	resp, result, _ := do(o, http.MethodGet, "/app/page?x=1", nil, nil)
+	if result != resultUnauthenticated || resp.StatusCode != http.StatusFound {
+		t.Fatalf("request should be redirected, got %s %d", result, resp.StatusCode)
+	}
+
+	code, state := fp.authorize(t, resp.Header.Get(keyLocation))
+	callback := fmt.Sprintf("/oauth2/callback?code=%s&state=%s", code, state)
+	resp, result, _ = do(o, http.MethodGet, callback, resp.Cookies(), nil)
+	if result != resultRedirected {
+		t.Fatalf("callback should succeed, got %s %d", result, resp.StatusCode)
+	}
+	if location := resp.Header.Get(keyLocation); location != "/app/page?x=1" {
+		t.Fatalf("user should be redirected to the original page, got %s", location)
+	}
+
+	var cookies []*http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.MaxAge > 0 && c.Value != "" {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
+
+const yamlSpecFormat = `
+kind: OIDC
+name: oidc
+issuer: %s
+clientId: easegress
+clientSecret: client-secret
+redirectUrl: https://www.example.com/oauth2/callback
+cookieSecret: 0123456789abcdef0123456789abcdef
+logoutPath: /logout
+claimsToHeaders:
+  sub: X-User
+passAccessToken: true
+`
+
+func TestLogin(t *testing.T) {
+	fp := newFakeProvider(t)
+	o := httppipelinetest.InitFilter(t, &OIDC{}, fmt.Sprintf(yamlSpecFormat, fp.server.URL)).(*OIDC)
+	defer o.Close()
+
+	cookies := login(t, o, fp)
+	if len(cookies) == 0 {
+		t.Fatal("session cookies should be set")
+	}
+	for _, c := range cookies {
+		if !c.HttpOnly || !c.Secure {
+			t.Errorf("cookie %s should be HttpOnly and Secure", c.Name)
+		}
+		if strings.Contains(c.Value, "access-token") {
+			t.Errorf("cookie %s should be encrypted", c.Name)
+		}
+	}
+
+	cookies = append(cookies, &http.Cookie{Name: "other", Value: "1"})
+	header := http.Header{"X-User": []string{"mallory"}}
+	_, result, upstream := do(o, http.MethodGet, "/app/page", cookies, header)
+	if result != "" {
+		t.Fatalf("request should be authenticated, got %s", result)
+	}
+	if upstream.Get("X-User") != "alice" {
+		t.Errorf("X-User should be alice, got %q", upstream.Get("X-User"))
+	}
+	if upstream.Get(keyAuthorization) != "Bearer access-token-0" {
+		t.Errorf("unexpected authorization header %q", upstream.Get(keyAuthorization))
+	}
+	if cookie := upstream.Get("Cookie"); cookie != "other=1" {
+		t.Errorf("session cookies should be removed, got %q", cookie)
+	}
+
+	// tampered cookies are rejected.
+	tampered := []*http.Cookie{{Name: cookies[0].Name, Value: cookies[0].Value[:20] + "x" + cookies[0].Value[21:]}}
+	_, result, _ = do(o, http.MethodGet, "/app/page", tampered, nil)
+	if result != resultUnauthenticated {
+		t.Errorf("tampered session should be unauthenticated, got %s", result)
+	}
+
+	// requests which can't be redirected are rejected.
+	resp, result, _ := do(o, http.MethodPost, "/app/page", nil, nil)
+	if result != resultUnauthenticated || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("POST should be rejected, got %s %d", result, resp.StatusCode)
+	}
+
+	resp, result, _ = do(o, http.MethodGet, "/logout", cookies, nil)
+	if result != resultRedirected || !strings.HasPrefix(resp.Header.Get(keyLocation), fp.server.URL+"/logout?") {
+		t.Errorf("user should be redirected to end session endpoint, got %s", resp.Header.Get(keyLocation))
+	}
+	for _, c := range resp.Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("cookie %s should be removed", c.Name)
+		}
+	}
+
+	s := o.Status().(*Status)
+	if s.Logins != 1 {
+		t.Errorf("logins should be 1, got %d", s.Logins)
+	}
+}
+
+func TestCallbackFailure(t *testing.T) {
+	fp := newFakeProvider(t)
+	o := httppipelinetest.InitFilter(t, &OIDC{}, fmt.Sprintf(yamlSpecFormat, fp.server.URL)).(*OIDC)
+	defer o.Close()
+
+	resp, _, _ := do(o, http.MethodGet, "/app", nil, nil)
+	code, state := fp.authorize(t, resp.Header.Get(keyLocation))
+
+	callback := fmt.Sprintf("/oauth2/callback?code=%s&state=%s", code, "forged")
+	_, result, _ := do(o, http.MethodGet, callback, resp.Cookies(), nil)
+	if result != resultAuthFailed {
+		t.Errorf("forged state should fail, got %s", result)
+	}
+
+	callback = fmt.Sprintf("/oauth2/callback?code=%s&state=%s", code, state)
+	_, result, _ = do(o, http.MethodGet, callback, nil, nil)
+	if result != resultAuthFailed {
+		t.Errorf("callback without state cookie should fail, got %s", result)
+	}
+
+	// the code challenge doesn't match the verifier of another login.
+	resp2, _, _ := do(o, http.MethodGet, "/app", nil, nil)
+	_, state2 := fp.authorize(t, resp2.Header.Get(keyLocation))
+	callback = fmt.Sprintf("/oauth2/callback?code=%s&state=%s", code, state2)
+	_, result, _ = do(o, http.MethodGet, callback, resp2.Cookies(), nil)
+	if result != resultAuthFailed {
+		t.Errorf("code with wrong verifier should fail, got %s", result)
+	}
+
+	if o.Status().(*Status).Failures != 3 {
+		t.Errorf("failures should be 3, got %d", o.Status().(*Status).Failures)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	fp := newFakeProvider(t)
+	// NOTE: The access token expires within the expiry margin.
+	fp.expiresIn = 1
+	o := httppipelinetest.InitFilter(t, &OIDC{}, fmt.Sprintf(yamlSpecFormat, fp.server.URL)).(*OIDC)
+	defer o.Close()
+
+	cookies := login(t, o, fp)
+
+	resp, result, upstream := do(o, http.MethodGet, "/app", cookies, nil)
+	if result != "" {
+		t.Fatalf("request should be authenticated, got %s", result)
+	}
+	if upstream.Get(keyAuthorization) != "Bearer access-token-1" {
+		t.Errorf("access token should be refreshed, got %q", upstream.Get(keyAuthorization))
+	}
+	if len(resp.Cookies()) == 0 {
+		t.Error("session cookies should be updated")
+	}
+	if o.Status().(*Status).Refreshes != 1 {
+		t.Errorf("refreshes should be 1")
+	}
+}
+
+func TestCookieChunks(t *testing.T) {
+	codec := newCookieCodec("0123456789abcdef", "", true)
+	value := map[string]string{"data": strings.Repeat("x", 3*maxCookieSize)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "s", Value: "stale"})
+	ctx, w := httppipelinetest.NewContext(req, nil)
+	if err := codec.write(ctx, "s", value, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx.Finish()
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	stale := false
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "s" {
+			stale = c.MaxAge < 0
+			continue
+		}
+		if len(c.Value) > maxCookieSize {
+			t.Errorf("cookie %s is too large", c.Name)
+		}
+		req.AddCookie(c)
+	}
+	if !stale {
+		t.Error("stale cookie should be removed")
+	}
+
+	ctx, _ = httppipelinetest.NewContext(req, nil)
+	got := map[string]string{}
+	if err := codec.read(ctx.Request(), "s", &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["data"] != value["data"] {
+		t.Error("value should be read from chunks")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{RedirectURL: "https://www.example.com/callback"}
+	if spec.Validate() == nil {
+		t.Error("spec without issuer should be invalid")
+	}
+
+	spec.AuthorizationEndpoint = "https://idp.example.com/auth"
+	if spec.Validate() == nil {
+		t.Error("spec without token endpoint should be invalid")
+	}
+
+	spec.TokenEndpoint = "https://idp.example.com/token"
+	spec.JWKSURI = "https://idp.example.com/jwks"
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.RedirectURL = "https://www.example.com"
+	if spec.Validate() == nil {
+		t.Error("redirect url without path should be invalid")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/filter/validator"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+type (
+	// metadata is the metadata of the OpenID provider.
+	metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+	}
+
+	tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		IDToken      string `json:"id_token"`
+
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	// provider talks to the OpenID provider, the metadata is discovered
+	// from the issuer at the first time it is required, so the filter
+	// could be created when the provider is unavailable.
+	provider struct {
+		spec   *Spec
+		client *http.Client
+
+		mutex     sync.Mutex
+		metadata  *metadata
+		validator *validator.JWTValidator
+	}
+)
+
+func newProvider(spec *Spec, client *http.Client) *provider {
+	p := &provider{spec: spec, client: client}
+
+	if spec.AuthorizationEndpoint != "" {
+		p.setMetadata(&metadata{
+			Issuer:                spec.Issuer,
+			AuthorizationEndpoint: spec.AuthorizationEndpoint,
+			TokenEndpoint:         spec.TokenEndpoint,
+			JWKSURI:               spec.JWKSURI,
+			EndSessionEndpoint:    spec.EndSessionEndpoint,
+		})
+	}
+
+	return p
+}
+
+func (p *provider) setMetadata(md *metadata) {
+	p.metadata = md
+	p.validator = validator.NewJWTValidator(&validator.JWTValidatorSpec{
+		Algorithm: p.spec.IDTokenAlgorithm,
+		JWKS: &validator.JWKSSpec{
+			URL:         md.JWKSURI,
+			Timeout:     p.spec.Timeout,
+			InsecureTLS: p.spec.InsecureTLS,
+		},
+		Issuer:     md.Issuer,
+		Audiences:  []string{p.spec.ClientID},
+		RequireExp: true,
+		Leeway:     p.spec.Leeway,
+	})
+}
+
+// get returns the metadata and the validator of ID tokens, it discovers
+// the metadata if it is not discovered yet.
+func (p *provider) get() (*metadata, *validator.JWTValidator, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.validator, nil
+	}
+
+	md, err := p.discover()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.setMetadata(md)
+	return p.metadata, p.validator, nil
+}
+
+func (p *provider) discover() (*metadata, error) {
+	u := strings.TrimSuffix(p.spec.Issuer, "/") + discoveryPath
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discover %s: unexpected status code %d", u, resp.StatusCode)
+	}
+
+	md := &metadata{}
+	if err = json.NewDecoder(resp.Body).Decode(md); err != nil {
+		return nil, fmt.Errorf("discover %s: %v", u, err)
+	}
+
+	// NOTE: The issuer must be identical to the one used for discovery.
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(p.spec.Issuer, "/") {
+		return nil, fmt.Errorf("discover %s: unexpected issuer %s", u, md.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("discover %s: missing endpoints", u)
+	}
+
+	return md, nil
+}
+
+// token requests the token endpoint with the grant.
+func (p *provider) token(md *metadata, form url.Values) (*tokenResponse, error) {
+	form.Set("client_id", p.spec.ClientID)
+
+	req, err := http.NewRequest(http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.spec.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.spec.ClientID), url.QueryEscape(p.spec.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tr := &tokenResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(tr); err != nil {
+		return nil, fmt.Errorf("decode token response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("token endpoint returns %d: %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returns no access token")
+	}
+
+	return tr, nil
+}
+
+// expiry returns the expiry of the access token.
+func (tr *tokenResponse) expiry(now time.Time) time.Time {
+	if tr.ExpiresIn > 0 {
+		return now.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return time.Time{}
+}
+
+func (p *provider) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.validator != nil {
+		p.validator.Close()
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+// maxCookieSize is the maximum size of the value of a cookie, values
+// larger than it are split into chunks, as most browsers limit the
+// size of a cookie to 4096 bytes.
+const maxCookieSize = 3800
+
+type (
+	// session is the login session stored in the encrypted cookies.
+	session struct {
+		Claims       map[string]interface{} `json:"c,omitempty"`
+		AccessToken  string                 `json:"a,omitempty"`
+		RefreshToken string                 `json:"r,omitempty"`
+		// Expiry is the expiry of the access token, zero means unknown.
+		Expiry  int64 `json:"e,omitempty"`
+		Created int64 `json:"t"`
+	}
+
+	// authState is the state of an authorization request, it is stored
+	// in a cookie until the callback.
+	authState struct {
+		State    string `json:"s"`
+		Nonce    string `json:"n"`
+		Verifier string `json:"v"`
+		URL      string `json:"u"`
+		Created  int64  `json:"t"`
+	}
+
+	// cookieCodec encrypts values into cookies by AES-GCM.
+	cookieCodec struct {
+		aead   cipher.AEAD
+		domain string
+		secure bool
+	}
+)
+
+func newCookieCodec(secret, domain string, secure bool) *cookieCodec {
+	key := sha256.Sum256([]byte(secret))
+	// NOTE: The key size is valid, so there's no error.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &cookieCodec{aead: aead, domain: domain, secure: secure}
+}
+
+// encode encrypts the value, the name of the cookie is the additional
+// data, so the value can't be moved to other cookies.
+func (c *cookieCodec) encode(name string, v interface{}) (string, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *cookieCodec) decode(name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return fmt.Errorf("invalid cookie %s", name)
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, sealed, []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, v)
+}
+
+func (c *cookieCodec) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.domain,
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge / time.Second),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// read reads the value which may be split into the chunks named by
+// name.0, name.1, ...
+func (c *cookieCodec) read(req context.HTTPRequest, name string, v interface{}) error {
+	value := ""
+	if cookie, err := req.Cookie(name); err == nil {
+		value = cookie.Value
+	} else {
+		for i := 0; ; i++ {
+			cookie, err := req.Cookie(chunkName(name, i))
+			if err != nil {
+				break
+			}
+			value += cookie.Value
+		}
+	}
+
+	if value == "" {
+		return http.ErrNoCookie
+	}
+	return c.decode(name, value, v)
+}
+
+// write writes the value, and removes the stale cookies of the value.
+func (c *cookieCodec) write(ctx context.HTTPContext, name string, v interface{}, maxAge time.Duration) error {
+	value, err := c.encode(name, v)
+	if err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	if len(value) <= maxCookieSize {
+		ctx.Response().SetCookie(c.cookie(name, value, maxAge))
+		written[name] = true
+	} else {
+		for i := 0; len(value) > 0; i++ {
+			n := maxCookieSize
+			if n > len(value) {
+				n = len(value)
+			}
+			ctx.Response().SetCookie(c.cookie(chunkName(name, i), value[:n], maxAge))
+			written[chunkName(name, i)] = true
+			value = value[n:]
+		}
+	}
+
+	for _, cookie := range ctx.Request().Cookies() {
+		if isCookieOf(cookie.Name, name) && !written[cookie.Name] {
+			ctx.Response().SetCookie(c.cookie(cookie.Name, "", -1))
+		}
+	}
+	return nil
+}
+
+// clear removes all cookies of the value.
+func (c *cookieCodec) clear(ctx context.HTTPContext, name string) {
+	for _, cookie := range ctx.Request().Cookies() {
+		if isCookieOf(cookie.Name, name) {
+			ctx.Response().SetCookie(c.cookie(cookie.Name, "", -1))
+		}
+	}
+}
+
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+func isCookieOf(cookieName, name string) bool {
+	if cookieName == name {
+		return true
+	}
+	if !strings.HasPrefix(cookieName, name+".") {
+		return false
+	}
+	_, err := strconv.Atoi(cookieName[len(name)+1:])
+	return err == nil
+}
+
+// removeCookies removes the cookies of the names from the request, so
+// they are not sent to the backends.
+func removeCookies(req context.HTTPRequest, names ...string) {
+	cookies := req.Cookies()
+	req.Header().Del("Cookie")
+	for _, cookie := range cookies {
+		own := false
+		for _, name := range names {
+			if isCookieOf(cookie.Name, name) {
+				own = true
+				break
+			}
+		}
+		if !own {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+func randomString(n int) string {
+	buff := make([]byte, n)
+	rand.Read(buff)
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+// codeChallenge returns the S256 code challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
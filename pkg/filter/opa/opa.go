@@ -0,0 +1,415 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package opa
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of OPA.
+	Kind = "OPA"
+
+	resultDenied = "denied"
+	resultFailed = "failed"
+)
+
+var results = []string{resultDenied, resultFailed}
+
+func init() {
+	httppipeline.Register(&OPA{})
+}
+
+type (
+	// OPA evaluates the requests against the policies of Open Policy
+	// Agent, by an embedded OPA or a remote OPA server.
+	OPA struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout   time.Duration
+		evaluator evaluator
+
+		requests uint64
+		allowed  uint64
+		denied   uint64
+		failures uint64
+	}
+
+	// Spec describes the OPA.
+	Spec struct {
+		// Policy is the Rego policy evaluated by the embedded OPA.
+		Policy string `yaml:"policy" jsonschema:"omitempty"`
+		// Server is the URL of the remote OPA server.
+		Server string `yaml:"server" jsonschema:"omitempty,format=url"`
+		// Query is the decision to query, e.g. data.http.authz, the result
+		// is a boolean or an object with the field allow.
+		Query       string `yaml:"query" jsonschema:"required,pattern=^data(\\.[A-Za-z_][A-Za-z0-9_]*)*$"`
+		Timeout     string `yaml:"timeout" jsonschema:"required,format=duration"`
+		IncludeBody bool   `yaml:"includeBody" jsonschema:"omitempty"`
+		MaxBodySize int64  `yaml:"maxBodySize" jsonschema:"required,minimum=1"`
+	}
+
+	// Status is the status of OPA.
+	Status struct {
+		Requests uint64 `yaml:"requests"`
+		Allowed  uint64 `yaml:"allowed"`
+		Denied   uint64 `yaml:"denied"`
+		Failures uint64 `yaml:"failures"`
+	}
+
+	// evaluator evaluates the query with the input, the result is nil if
+	// the decision is undefined.
+	evaluator interface {
+		eval(ctx stdcontext.Context, input map[string]interface{}) (interface{}, error)
+		close()
+	}
+
+	// decision is the result of the policy in the form of object.
+	decision struct {
+		Allow bool `json:"allow"`
+		// Headers are added to the request if it is allowed, or to the
+		// response otherwise.
+		Headers    map[string]string `json:"headers"`
+		StatusCode int               `json:"statusCode"`
+		Body       string            `json:"body"`
+	}
+
+	embeddedEvaluator struct {
+		query rego.PreparedEvalQuery
+	}
+
+	remoteEvaluator struct {
+		url    string
+		client *http.Client
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	if (spec.Policy == "") == (spec.Server == "") {
+		return fmt.Errorf("one and only one of policy and server must be specified")
+	}
+	if spec.Policy != "" {
+		if _, err := newEmbeddedEvaluator(&spec); err != nil {
+			return fmt.Errorf("prepare policy failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of OPA.
+func (o *OPA) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of OPA.
+func (o *OPA) DefaultSpec() interface{} {
+	return &Spec{
+		Query:       "data.http.authz",
+		Timeout:     "100ms",
+		MaxBodySize: 8192,
+	}
+}
+
+// Description returns the description of OPA.
+func (o *OPA) Description() string {
+	return "OPA evaluates the requests against the policies of Open Policy Agent."
+}
+
+// Results returns the results of OPA.
+func (o *OPA) Results() []string {
+	return results
+}
+
+// Init initializes OPA.
+func (o *OPA) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.reload()
+}
+
+// Inherit inherits previous generation of OPA.
+func (o *OPA) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	o.Init(filterSpec)
+}
+
+func (o *OPA) reload() {
+	var err error
+	o.timeout, err = time.ParseDuration(o.spec.Timeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", o.spec.Timeout, err)
+		o.timeout = 100 * time.Millisecond
+	}
+
+	if o.spec.Server != "" {
+		o.evaluator = newRemoteEvaluator(o.spec)
+		return
+	}
+
+	o.evaluator, err = newEmbeddedEvaluator(o.spec)
+	if err != nil {
+		// NOTE: The policy has been checked by Validate, every request
+		// fails if it is invalid anyway.
+		logger.Errorf("BUG: prepare policy failed: %v", err)
+		o.evaluator = nil
+	}
+}
+
+func newEmbeddedEvaluator(spec *Spec) (*embeddedEvaluator, error) {
+	query, err := rego.New(
+		rego.Query(spec.Query),
+		rego.Module("policy.rego", spec.Policy),
+	).PrepareForEval(stdcontext.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &embeddedEvaluator{query: query}, nil
+}
+
+func (e *embeddedEvaluator) eval(ctx stdcontext.Context, input map[string]interface{}) (interface{}, error) {
+	rs, err := e.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return rs[0].Expressions[0].Value, nil
+}
+
+func (e *embeddedEvaluator) close() {}
+
+// newRemoteEvaluator creates an evaluator calling the Data API of the OPA
+// server, data.http.authz is queried by POST /v1/data/http/authz.
+func newRemoteEvaluator(spec *Spec) *remoteEvaluator {
+	path := strings.ReplaceAll(strings.TrimPrefix(spec.Query, "data"), ".", "/")
+	return &remoteEvaluator{
+		url: strings.TrimSuffix(spec.Server, "/") + "/v1/data" + path,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: 64,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+func (e *remoteEvaluator) eval(ctx stdcontext.Context, input map[string]interface{}) (interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// NOTE: Drain the body so that the connection could be reused.
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	// NOTE: The result is absent if the decision is undefined.
+	r := struct {
+		Result interface{} `json:"result"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode result failed: %v", err)
+	}
+	return r.Result, nil
+}
+
+func (e *remoteEvaluator) close() {
+	e.client.CloseIdleConnections()
+}
+
+// buildInput builds the input document of the policy from the request,
+// the body is read and then set back, so it is still available to the
+// next filters.
+func (o *OPA) buildInput(ctx context.HTTPContext) map[string]interface{} {
+	r := ctx.Request()
+
+	headers := map[string]interface{}{}
+	for name, values := range r.Header().Std() {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	query := map[string]interface{}{}
+	values, _ := url.ParseQuery(r.Query())
+	for name, v := range values {
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = v[i]
+		}
+		query[name] = list
+	}
+
+	var segments []interface{}
+	for _, s := range strings.Split(strings.Trim(r.Path(), "/"), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	input := map[string]interface{}{
+		"method":     r.Method(),
+		"scheme":     r.Scheme(),
+		"host":       r.Host(),
+		"path":       r.Path(),
+		"parsedPath": segments,
+		"query":      query,
+		"headers":    headers,
+		"realIP":     r.RealIP(),
+	}
+
+	if o.spec.IncludeBody && r.Body() != nil {
+		body := r.Body()
+		buf, err := io.ReadAll(io.LimitReader(body, o.spec.MaxBodySize+1))
+		r.SetBody(io.MultiReader(bytes.NewReader(buf), body))
+		if err == nil && int64(len(buf)) <= o.spec.MaxBodySize {
+			input["body"] = string(buf)
+		}
+	}
+
+	return input
+}
+
+// toDecision converts the result of the query to a decision, the request
+// is denied if the result is undefined.
+func toDecision(result interface{}) (*decision, error) {
+	switch r := result.(type) {
+	case nil:
+		return &decision{}, nil
+	case bool:
+		return &decision{Allow: r}, nil
+	case map[string]interface{}:
+		buf, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		d := &decision{}
+		if err = json.Unmarshal(buf, d); err != nil {
+			return nil, fmt.Errorf("invalid result: %v", err)
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("result must be a boolean or an object, got %T", result)
+	}
+}
+
+// Handle handles HTTP request.
+func (o *OPA) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *OPA) handle(ctx context.HTTPContext) string {
+	atomic.AddUint64(&o.requests, 1)
+
+	d, err := o.decide(ctx)
+	if err != nil {
+		atomic.AddUint64(&o.failures, 1)
+		ctx.AddTag(fmt.Sprintf("%s: evaluate policy failed: %v", o.filterSpec.Name(), err))
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		return resultFailed
+	}
+
+	if d.Allow {
+		atomic.AddUint64(&o.allowed, 1)
+		for name, value := range d.Headers {
+			ctx.Request().Header().Set(name, value)
+		}
+		return ""
+	}
+
+	atomic.AddUint64(&o.denied, 1)
+	ctx.AddTag(fmt.Sprintf("%s: denied by policy", o.filterSpec.Name()))
+
+	w := ctx.Response()
+	statusCode := d.StatusCode
+	if statusCode < 100 || statusCode > 599 {
+		statusCode = http.StatusForbidden
+	}
+	w.SetStatusCode(statusCode)
+	for name, value := range d.Headers {
+		w.Header().Set(name, value)
+	}
+	if d.Body != "" {
+		w.SetBody(strings.NewReader(d.Body))
+	}
+	return resultDenied
+}
+
+func (o *OPA) decide(ctx context.HTTPContext) (*decision, error) {
+	if o.evaluator == nil {
+		return nil, fmt.Errorf("invalid policy")
+	}
+
+	timeoutCtx, cancel := stdcontext.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	result, err := o.evaluator.eval(timeoutCtx, o.buildInput(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return toDecision(result)
+}
+
+// Status returns Status generated by OPA.
+func (o *OPA) Status() interface{} {
+	return &Status{
+		Requests: atomic.LoadUint64(&o.requests),
+		Allowed:  atomic.LoadUint64(&o.allowed),
+		Denied:   atomic.LoadUint64(&o.denied),
+		Failures: atomic.LoadUint64(&o.failures),
+	}
+}
+
+// Close closes OPA.
+func (o *OPA) Close() {
+	if o.evaluator != nil {
+		o.evaluator.close()
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package opa
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const policy = `
+package http.authz
+
+default allow = false
+
+allow {
+	input.method == "GET"
+	input.parsedPath[0] == "public"
+}
+
+allow {
+	input.headers["x-user"] == "alice"
+	contains(input.body, "hello")
+}
+
+headers = {"X-Role": "admin"} {
+	allow
+} else = {"X-Reason": "forbidden"}
+
+statusCode = 401 {
+	not input.headers["x-user"]
+}
+`
+
+func doRequest(o *OPA, method, path, user string) (string, *httptest.ResponseRecorder, http.Header) {
+	req := httptest.NewRequest(method, path, strings.NewReader("hello"))
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	var header http.Header
+	result, w := httppipelinetest.Handle(o, req, func(ctx context.HTTPContext, lastResult string) string {
+		header = ctx.Request().Header().Std().Clone()
+		body, _ := io.ReadAll(ctx.Request().Body())
+		header.Set("X-Next-Body", string(body))
+		return lastResult
+	})
+	return result, w, header
+}
+
+func TestEmbedded(t *testing.T) {
+	spec := map[string]interface{}{
+		"kind":        "OPA",
+		"name":        "opa",
+		"query":       "data.http.authz",
+		"includeBody": true,
+		"policy":      policy,
+	}
+	buf, _ := json.Marshal(spec)
+	o := httppipelinetest.InitFilter(t, &OPA{}, string(buf)).(*OPA)
+	defer o.Close()
+
+	result, _, header := doRequest(o, "GET", "/public/index.html", "")
+	if result != "" || header.Get("X-Role") != "admin" {
+		t.Errorf("request should be allowed with header injected, got %q, %v", result, header)
+	}
+
+	result, _, header = doRequest(o, "POST", "/users", "alice")
+	if result != "" || header.Get("X-Next-Body") != "hello" {
+		t.Errorf("request should be allowed with body kept, got %q, %v", result, header)
+	}
+
+	result, w, _ := doRequest(o, "POST", "/users", "")
+	if result != resultDenied || w.Code != http.StatusUnauthorized || w.Header().Get("X-Reason") != "forbidden" {
+		t.Errorf("request should be denied, got %q, %d, %v", result, w.Code, w.Header())
+	}
+
+	result, w, _ = doRequest(o, "POST", "/users", "bob")
+	if result != resultDenied || w.Code != http.StatusForbidden {
+		t.Errorf("request should be denied with 403, got %q, %d", result, w.Code)
+	}
+
+	s := o.Status().(*Status)
+	if s.Requests != 4 || s.Allowed != 2 || s.Denied != 2 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			Input map[string]interface{} `json:"input"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch {
+		case r.URL.Path != "/v1/data/http/allow":
+			w.WriteHeader(http.StatusNotFound)
+		case body.Input["path"] == "/undefined":
+			w.Write([]byte(`{}`))
+		case body.Input["path"] == "/error":
+			w.Write([]byte(`{"result": "yes"}`))
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"result": body.Input["method"] == "GET",
+			})
+		}
+	}))
+	defer server.Close()
+
+	o := httppipelinetest.InitFilter(t, &OPA{}, `
+kind: OPA
+name: opa
+server: `+server.URL+`
+query: data.http.allow
+`).(*OPA)
+	defer o.Close()
+
+	if result, _, _ := doRequest(o, "GET", "/users", ""); result != "" {
+		t.Errorf("request should be allowed, got %q", result)
+	}
+	if result, w, _ := doRequest(o, "DELETE", "/users", ""); result != resultDenied || w.Code != http.StatusForbidden {
+		t.Errorf("request should be denied, got %q, %d", result, w.Code)
+	}
+	if result, _, _ := doRequest(o, "GET", "/undefined", ""); result != resultDenied {
+		t.Errorf("request should be denied if the decision is undefined, got %q", result)
+	}
+	if result, w, _ := doRequest(o, "GET", "/error", ""); result != resultFailed || w.Code != http.StatusInternalServerError {
+		t.Errorf("request should fail, got %q, %d", result, w.Code)
+	}
+	if s := o.Status().(*Status); s.Failures != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, yamlSpec := range []string{`
+kind: OPA
+name: opa
+query: data.http.allow
+`, `
+kind: OPA
+name: opa
+query: data.http.allow
+server: http://127.0.0.1:8181
+policy: "package http"
+`, `
+kind: OPA
+name: opa
+query: data.http.allow
+policy: "package http\nallow {"
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid: %s", yamlSpec)
+		}
+	}
+}
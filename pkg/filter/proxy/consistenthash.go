@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/hashtool"
+)
+
+const (
+	// HashOnIP hashes on the real IP of the client.
+	HashOnIP = "ip"
+	// HashOnHeader hashes on the value of a request header.
+	HashOnHeader = "header"
+	// HashOnCookie hashes on the value of a request cookie.
+	HashOnCookie = "cookie"
+
+	defaultVirtualNodes = 160
+)
+
+type (
+	// ConsistentHashSpec describes the consistent hash load balance.
+	ConsistentHashSpec struct {
+		HashOn       string `yaml:"hashOn" jsonschema:"required,enum=ip,enum=header,enum=cookie"`
+		Key          string `yaml:"key" jsonschema:"omitempty"`
+		VirtualNodes int    `yaml:"virtualNodes" jsonschema:"omitempty,minimum=1"`
+		// BoundedLoadFactor enables the bounded load variant when greater
+		// than 1, no server gets more in-flight requests than the factor
+		// times of the average.
+		BoundedLoadFactor float64 `yaml:"boundedLoadFactor" jsonschema:"omitempty,minimum=0"`
+	}
+
+	hashRing struct {
+		hashes  []uint32
+		servers []int // index of the server in staticServers.servers
+	}
+)
+
+// Validate validates ConsistentHashSpec.
+func (spec ConsistentHashSpec) Validate() error {
+	if spec.HashOn != HashOnIP && spec.Key == "" {
+		return fmt.Errorf("hashOn %s needs to specify key", spec.HashOn)
+	}
+	if spec.BoundedLoadFactor != 0 && spec.BoundedLoadFactor <= 1 {
+		return fmt.Errorf("boundedLoadFactor must be greater than 1")
+	}
+	return nil
+}
+
+func newHashRing(servers []*Server, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	ring := &hashRing{}
+	for i, server := range servers {
+		// NOTE: Servers with larger weight own more virtual nodes.
+		n := virtualNodes
+		if server.Weight > 0 {
+			n *= server.Weight
+		}
+		for j := 0; j < n; j++ {
+			ring.hashes = append(ring.hashes, hashtool.Hash32(server.URL+"#"+strconv.Itoa(j)))
+			ring.servers = append(ring.servers, i)
+		}
+	}
+
+	sort.Sort(ring)
+	return ring
+}
+
+func (r *hashRing) Len() int           { return len(r.hashes) }
+func (r *hashRing) Less(i, j int) bool { return r.hashes[i] < r.hashes[j] }
+func (r *hashRing) Swap(i, j int) {
+	r.hashes[i], r.hashes[j] = r.hashes[j], r.hashes[i]
+	r.servers[i], r.servers[j] = r.servers[j], r.servers[i]
+}
+
+// search returns the position of the first virtual node at or after hash.
+func (r *hashRing) search(hash uint32) int {
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return i
+}
+
+func (ss *staticServers) hashKey(ctx context.HTTPContext) string {
+	spec := ss.lb.ConsistentHash
+	switch spec.HashOn {
+	case HashOnHeader:
+		return ctx.Request().Header().Get(spec.Key)
+	case HashOnCookie:
+		if cookie, err := ctx.Request().Cookie(spec.Key); err == nil {
+			return cookie.Value
+		}
+		return ""
+	default:
+		return ctx.Request().RealIP()
+	}
+}
+
+func (ss *staticServers) consistentHash(ctx context.HTTPContext) *Server {
+	if ss.ring == nil || ss.ring.Len() == 0 {
+		return ss.roundRobin(ctx)
+	}
+
+	pos := ss.ring.search(hashtool.Hash32(ss.hashKey(ctx)))
+	factor := ss.lb.ConsistentHash.BoundedLoadFactor
+	if factor <= 1 {
+		return ss.servers[ss.ring.servers[pos]]
+	}
+
+	// Consistent hashing with bounded loads, the capacity of every
+	// server is the factor times of the average load, including the
+	// current request.
+	var total int64
+	for _, stat := range ss.stats {
+		total += stat.inflightCount()
+	}
+	capacity := int64(math.Ceil(factor * float64(total+1) / float64(len(ss.servers))))
+
+	for i := 0; i < ss.ring.Len(); i++ {
+		server := ss.servers[ss.ring.servers[(pos+i)%ss.ring.Len()]]
+		if ss.stats[server.URL].inflightCount() < capacity {
+			return server
+		}
+	}
+
+	return ss.servers[ss.ring.servers[pos]]
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func newHashContext(key string) *contexttest.MockedHTTPContext {
+	ctx := &contexttest.MockedHTTPContext{}
+	header := http.Header{}
+	header.Set("X-User-Id", key)
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	return ctx
+}
+
+func TestConsistentHash(t *testing.T) {
+	lb := &LoadBalance{
+		Policy: PolicyConsistentHash,
+		ConsistentHash: &ConsistentHashSpec{
+			HashOn: HashOnHeader,
+			Key:    "X-User-Id",
+		},
+	}
+	if lb.Validate() != nil {
+		t.Fatal("validate should succeed")
+	}
+
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+		{URL: "http://127.0.0.1:9094"},
+	}
+	ss := newStaticServers(servers, nil, lb)
+
+	picked := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		s := ss.next(newHashContext(key))
+		if again := ss.next(newHashContext(key)); again != s {
+			t.Fatalf("%s should always be routed to %s, got %s", key, s.URL, again.URL)
+		}
+		picked[key] = s.URL
+		counts[s.URL]++
+	}
+	for _, server := range servers {
+		if counts[server.URL] < 100 {
+			t.Errorf("%s is picked only %d times", server.URL, counts[server.URL])
+		}
+	}
+
+	// Removing a server should only remap the keys on it.
+	ss = newStaticServers(servers[:3], nil, lb)
+	for key, url := range picked {
+		if url == servers[3].URL {
+			continue
+		}
+		if s := ss.next(newHashContext(key)); s.URL != url {
+			t.Fatalf("%s should not be remapped from %s to %s", key, url, s.URL)
+		}
+	}
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	lb := &LoadBalance{
+		Policy: PolicyConsistentHash,
+		ConsistentHash: &ConsistentHashSpec{
+			HashOn:            HashOnHeader,
+			Key:               "X-User-Id",
+			BoundedLoadFactor: 1.25,
+		},
+	}
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+		{URL: "http://127.0.0.1:9094"},
+	}
+	ss := newStaticServers(servers, nil, lb)
+	ctx := newHashContext("hot-key")
+	home := ss.next(ctx)
+
+	spilled := false
+	for i := 0; i < 20; i++ {
+		s := ss.next(ctx)
+		if s != home {
+			spilled = true
+		}
+		ss.stats[s.URL].begin()
+	}
+	if !spilled {
+		t.Fatal("requests of a hot key should spill to other servers")
+	}
+
+	for _, server := range servers {
+		// capacity is ceil(1.25 * 20 / 4) = 7
+		if n := ss.stats[server.URL].inflightCount(); n > 7 {
+			t.Errorf("%s has %d in-flight requests, exceeds the bound", server.URL, n)
+		}
+	}
+}
+
+func TestConsistentHashSpecValidate(t *testing.T) {
+	spec := ConsistentHashSpec{HashOn: HashOnCookie}
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+	spec.Key = "session"
+	if spec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+	spec.BoundedLoadFactor = 0.5
+	if spec.Validate() == nil {
+		t.Error("validate should fail")
+	}
+
+	lb := LoadBalance{Policy: PolicyConsistentHash}
+	if lb.Validate() == nil {
+		t.Error("validate should fail")
+	}
+}
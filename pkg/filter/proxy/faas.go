@@ -0,0 +1,443 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// FaaSProviderKnative invokes a Knative service.
+	FaaSProviderKnative = "knative"
+	// FaaSProviderOpenFaaS invokes a function by the OpenFaaS gateway.
+	FaaSProviderOpenFaaS = "openfaas"
+	// FaaSProviderLambda invokes an AWS Lambda function.
+	FaaSProviderLambda = "lambda"
+
+	defaultFaaSTimeout          = 30 * time.Second
+	defaultFaaSColdStartTimeout = 2 * time.Minute
+	defaultFaaSIdleTimeout      = 5 * time.Minute
+)
+
+type (
+	// FaaSSpec describes the serverless function invoked as the upstream
+	// server of a pool.
+	FaaSSpec struct {
+		Provider string `yaml:"provider" jsonschema:"required,enum=knative,enum=openfaas,enum=lambda"`
+		// URL is the URL of the Knative service or the OpenFaaS gateway,
+		// for Lambda, it overrides the endpoint of the Lambda API.
+		URL string `yaml:"url" jsonschema:"omitempty,format=url"`
+		// Host overrides the host header, which routes the requests to the
+		// Knative service when the URL is the address of the ingress.
+		Host string `yaml:"host" jsonschema:"omitempty"`
+		// Function is the name of the OpenFaaS function, or the name or
+		// ARN of the Lambda function.
+		Function        string `yaml:"function" jsonschema:"omitempty"`
+		Qualifier       string `yaml:"qualifier" jsonschema:"omitempty"`
+		Region          string `yaml:"region" jsonschema:"omitempty"`
+		AccessKeyID     string `yaml:"accessKeyID" jsonschema:"omitempty"`
+		SecretAccessKey string `yaml:"secretAccessKey" jsonschema:"omitempty"`
+
+		// Timeout is the timeout of the invocations when the function is
+		// warm, ColdStartTimeout is used instead if the function has been
+		// idle for IdleTimeout, as it may be scaled to zero.
+		Timeout          string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		ColdStartTimeout string `yaml:"coldStartTimeout" jsonschema:"omitempty,format=duration"`
+		IdleTimeout      string `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		// MaxConcurrency is the maximum number of the invocations in
+		// flight, 0 means no limit. The requests exceeding it wait for at
+		// most QueueTimeout.
+		MaxConcurrency int    `yaml:"maxConcurrency" jsonschema:"omitempty,minimum=0"`
+		QueueTimeout   string `yaml:"queueTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// FaaSStatus is the status of the function.
+	FaaSStatus struct {
+		Invocations uint64 `yaml:"invocations"`
+		ColdStarts  uint64 `yaml:"coldStarts"`
+		// Throttled is the number of the requests rejected because of
+		// the concurrency limit.
+		Throttled uint64 `yaml:"throttled"`
+		Inflight  int    `yaml:"inflight"`
+	}
+
+	// faas invokes the function as if it is the only server of the pool.
+	faas struct {
+		spec   *FaaSSpec
+		server *Server
+		stat   *serverStat
+		host   string
+		lambda *lambda.Lambda
+
+		timeout          time.Duration
+		coldStartTimeout time.Duration
+		idleTimeout      time.Duration
+		queueTimeout     time.Duration
+		sem              chan struct{}
+
+		// lastActive is the time in unix nanoseconds of the latest
+		// successful invocation.
+		lastActive  int64
+		invocations uint64
+		coldStarts  uint64
+		throttled   uint64
+	}
+
+	// valuelessContext keeps the deadline and the cancellation of the
+	// context, but none of its values.
+	valuelessContext struct {
+		stdcontext.Context
+	}
+
+	// lambdaEvent is the event of Amazon API Gateway proxy integration,
+	// which is supported by most of the Lambda HTTP frameworks.
+	lambdaEvent struct {
+		HTTPMethod                      string              `json:"httpMethod"`
+		Path                            string              `json:"path"`
+		Headers                         map[string]string   `json:"headers"`
+		MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+		QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+		MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+		Body                            string              `json:"body"`
+		IsBase64Encoded                 bool                `json:"isBase64Encoded"`
+	}
+
+	// lambdaResponse is the response of Amazon API Gateway proxy integration.
+	lambdaResponse struct {
+		StatusCode        int                 `json:"statusCode"`
+		Headers           map[string]string   `json:"headers"`
+		MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+		Body              string              `json:"body"`
+		IsBase64Encoded   bool                `json:"isBase64Encoded"`
+	}
+)
+
+// Validate validates FaaSSpec.
+func (s FaaSSpec) Validate() error {
+	switch s.Provider {
+	case FaaSProviderKnative:
+		if s.URL == "" {
+			return fmt.Errorf("url is required by knative")
+		}
+	case FaaSProviderOpenFaaS:
+		if s.URL == "" || s.Function == "" {
+			return fmt.Errorf("url and function are required by openfaas")
+		}
+	case FaaSProviderLambda:
+		if s.Function == "" || s.Region == "" {
+			return fmt.Errorf("function and region are required by lambda")
+		}
+		if (s.AccessKeyID == "") != (s.SecretAccessKey == "") {
+			return fmt.Errorf("accessKeyID and secretAccessKey must be specified together")
+		}
+	}
+	return nil
+}
+
+func newFaaS(spec *FaaSSpec) *faas {
+	f := &faas{
+		spec:             spec,
+		stat:             &serverStat{},
+		timeout:          parseDurationOr(spec.Timeout, defaultFaaSTimeout),
+		coldStartTimeout: parseDurationOr(spec.ColdStartTimeout, defaultFaaSColdStartTimeout),
+		idleTimeout:      parseDurationOr(spec.IdleTimeout, defaultFaaSIdleTimeout),
+		queueTimeout:     parseDurationOr(spec.QueueTimeout, 0),
+	}
+	if f.coldStartTimeout < f.timeout {
+		f.coldStartTimeout = f.timeout
+	}
+	if spec.MaxConcurrency > 0 {
+		f.sem = make(chan struct{}, spec.MaxConcurrency)
+	}
+
+	gateway := strings.TrimSuffix(spec.URL, "/")
+	switch spec.Provider {
+	case FaaSProviderKnative:
+		f.server = &Server{URL: gateway}
+		f.host = spec.Host
+	case FaaSProviderOpenFaaS:
+		f.server = &Server{URL: gateway + "/function/" + spec.Function}
+		f.host = spec.Host
+	case FaaSProviderLambda:
+		f.server = &Server{URL: "lambda://" + spec.Function}
+		f.lambda = newLambdaClient(spec)
+	}
+	if f.host == "" && spec.Provider != FaaSProviderLambda {
+		if u, err := url.Parse(gateway); err == nil {
+			f.host = u.Host
+		}
+	}
+
+	return f
+}
+
+func newLambdaClient(spec *FaaSSpec) *lambda.Lambda {
+	// NOTE: The retries of the SDK would exceed the timeouts, the
+	// requests could be retried by the Retryer filter instead.
+	cfg := aws.NewConfig().WithRegion(spec.Region).WithMaxRetries(0)
+	if spec.URL != "" {
+		cfg = cfg.WithEndpoint(spec.URL)
+	}
+	if spec.AccessKeyID != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(spec.AccessKeyID, spec.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		logger.Errorf("create session of lambda %s failed: %v", spec.Function, err)
+		return nil
+	}
+	return lambda.New(sess)
+}
+
+// acquire acquires a slot of the concurrency limit, it returns false if
+// no slot is available in the queue timeout.
+func (f *faas) acquire(ctx context.HTTPContext) bool {
+	if f.sem == nil {
+		return true
+	}
+
+	select {
+	case f.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if f.queueTimeout > 0 {
+		timer := time.NewTimer(f.queueTimeout)
+		defer timer.Stop()
+		select {
+		case f.sem <- struct{}{}:
+			return true
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	atomic.AddUint64(&f.throttled, 1)
+	return false
+}
+
+func (f *faas) release() {
+	if f.sem != nil {
+		<-f.sem
+	}
+}
+
+// isCold reports whether the function may be scaled to zero.
+func (f *faas) isCold() bool {
+	lastActive := atomic.LoadInt64(&f.lastActive)
+	return lastActive == 0 || time.Since(time.Unix(0, lastActive)) > f.idleTimeout
+}
+
+// prepare sets the host and the timeout of the request to the function.
+func (f *faas) prepare(ctx context.HTTPContext, req *request) {
+	timeout := f.timeout
+	if f.isCold() {
+		timeout = f.coldStartTimeout
+		atomic.AddUint64(&f.coldStarts, 1)
+	}
+
+	reqCtx, cancel := stdcontext.WithTimeout(req.std.Context(), timeout)
+	req.std = req.std.WithContext(reqCtx)
+	if f.host != "" {
+		req.std.Host = f.host
+	}
+
+	ctx.Lock()
+	// NOTE: The response body is read after handling, so the
+	// context could only be cancelled when the HTTPContext finishes.
+	ctx.OnFinish(cancel)
+	ctx.AddTag(stringtool.Cat("proxy#faas#timeout: ", timeout.String()))
+	ctx.Unlock()
+}
+
+// send invokes the function.
+func (f *faas) send(req *http.Request, client *http.Client) (*http.Response, error) {
+	atomic.AddUint64(&f.invocations, 1)
+
+	var resp *http.Response
+	var err error
+	if f.spec.Provider == FaaSProviderLambda {
+		resp, err = f.invokeLambda(req)
+	} else {
+		resp, err = fnSendRequest(req, client)
+	}
+
+	if err == nil && resp.StatusCode < 500 {
+		atomic.StoreInt64(&f.lastActive, time.Now().UnixNano())
+	}
+	return resp, err
+}
+
+func (f *faas) invokeLambda(req *http.Request) (*http.Response, error) {
+	if f.lambda == nil {
+		return nil, fmt.Errorf("lambda client is not available")
+	}
+
+	event, err := newLambdaEvent(req)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &lambda.InvokeInput{
+		FunctionName: aws.String(f.spec.Function),
+		Payload:      payload,
+	}
+	if f.spec.Qualifier != "" {
+		input.Qualifier = aws.String(f.spec.Qualifier)
+	}
+
+	// NOTE: The trace of the request measures the invocation as a whole,
+	// it is not passed to the HTTP requests of the SDK.
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+	output, err := f.lambda.InvokeWithContext(valuelessContext{req.Context()}, input)
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if output.FunctionError != nil {
+		return nil, fmt.Errorf("function error %s: %s", *output.FunctionError, output.Payload)
+	}
+	return newLambdaHTTPResponse(output.Payload)
+}
+
+// Value implements context.Context.
+func (ctx valuelessContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+func newLambdaEvent(req *http.Request) (*lambdaEvent, error) {
+	event := &lambdaEvent{
+		HTTPMethod:                      req.Method,
+		Path:                            req.URL.Path,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+	}
+
+	for name, values := range req.Header {
+		event.Headers[name] = values[len(values)-1]
+		event.MultiValueHeaders[name] = values
+	}
+	if req.Host != "" {
+		event.Headers["Host"] = req.Host
+		event.MultiValueHeaders["Host"] = []string{req.Host}
+	}
+	for name, values := range req.URL.Query() {
+		event.QueryStringParameters[name] = values[len(values)-1]
+		event.MultiValueQueryStringParameters[name] = values
+	}
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read request body failed: %v", err)
+		}
+		if utf8.Valid(body) {
+			event.Body = string(body)
+		} else {
+			event.Body, event.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+		}
+	}
+
+	return event, nil
+}
+
+func newLambdaHTTPResponse(payload []byte) (*http.Response, error) {
+	lr := &lambdaResponse{}
+	if err := json.Unmarshal(payload, lr); err != nil {
+		return nil, fmt.Errorf("invalid response of function: %v", err)
+	}
+	if lr.StatusCode < 100 || lr.StatusCode > 599 {
+		return nil, fmt.Errorf("invalid status code of function: %d", lr.StatusCode)
+	}
+
+	body := []byte(lr.Body)
+	if lr.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(lr.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decode response body failed: %v", err)
+		}
+	}
+
+	header := http.Header{}
+	for name, value := range lr.Headers {
+		header.Set(name, value)
+	}
+	for name, values := range lr.MultiValueHeaders {
+		header.Del(name)
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        strconv.Itoa(lr.StatusCode) + " " + http.StatusText(lr.StatusCode),
+		StatusCode:    lr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
+
+func (f *faas) status() *FaaSStatus {
+	return &FaaSStatus{
+		Invocations: atomic.LoadUint64(&f.invocations),
+		ColdStarts:  atomic.LoadUint64(&f.coldStarts),
+		Throttled:   atomic.LoadUint64(&f.throttled),
+		Inflight:    len(f.sem),
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func newFaaSProxy(t *testing.T, faasSpec string) *Proxy {
+	yamlSpec := `
+name: proxy
+kind: Proxy
+mainPool:
+  faas:
+` + faasSpec
+	return httppipelinetest.InitFilter(t, &Proxy{}, yamlSpec).(*Proxy)
+}
+
+func doFaaSRequest(p *Proxy, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("hello"))
+	_, w := httppipelinetest.Handle(p, req, nil)
+	return w
+}
+
+func TestFaaSOpenFaaS(t *testing.T) {
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/function/echo/slow" {
+			<-release
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Host + " " + r.URL.String() + " " + string(body)))
+	}))
+	defer server.Close()
+
+	proxy := newFaaSProxy(t, `
+    provider: openfaas
+    url: `+server.URL+`
+    function: echo
+    timeout: 1s
+    coldStartTimeout: 5s
+    maxConcurrency: 1
+`)
+	defer proxy.Close()
+	f := proxy.mainPool.faas
+
+	w := doFaaSRequest(proxy, "/users?id=1")
+	want := strings.TrimPrefix(server.URL, "http://") + " /function/echo/users?id=1 hello"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	doFaaSRequest(proxy, "/users")
+	if s := f.status(); s.Invocations != 2 || s.ColdStarts != 1 {
+		t.Errorf("only the first invocation should be a cold start, got %+v", s)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		doFaaSRequest(proxy, "/slow")
+		close(done)
+	}()
+	for i := 0; i < 200 && len(f.sem) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w := doFaaSRequest(proxy, "/users"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request exceeding the concurrency limit should be rejected, got %d", w.Code)
+	}
+	close(release)
+	<-done
+
+	if s := f.status(); s.Throttled != 1 || s.Inflight != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestFaaSLambda(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2015-03-31/functions/echo/invocations" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		event := &lambdaEvent{}
+		json.Unmarshal(body, event)
+		json.NewEncoder(w).Encode(&lambdaResponse{
+			StatusCode: http.StatusCreated,
+			Headers:    map[string]string{"X-Path": event.Path},
+			Body:       event.HTTPMethod + " " + event.QueryStringParameters["id"] + " " + event.Body,
+		})
+	}))
+	defer server.Close()
+
+	proxy := newFaaSProxy(t, `
+    provider: lambda
+    url: `+server.URL+`
+    function: echo
+    region: us-east-1
+    accessKeyID: id
+    secretAccessKey: secret
+`)
+	defer proxy.Close()
+
+	w := doFaaSRequest(proxy, "/users?id=1")
+	if w.Code != http.StatusCreated || w.Header().Get("X-Path") != "/users" || w.Body.String() != "POST 1 hello" {
+		t.Errorf("unexpected response %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestFaaSSpecValidate(t *testing.T) {
+	for _, spec := range []FaaSSpec{
+		{Provider: FaaSProviderKnative},
+		{Provider: FaaSProviderOpenFaaS, URL: "http://127.0.0.1:8080"},
+		{Provider: FaaSProviderLambda, Function: "echo"},
+		{Provider: FaaSProviderLambda, Function: "echo", Region: "us-east-1", AccessKeyID: "id"},
+	} {
+		if spec.Validate() == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+
+	poolSpec := PoolSpec{
+		FaaS:    &FaaSSpec{Provider: FaaSProviderKnative, URL: "http://127.0.0.1:8080"},
+		Servers: []*Server{{URL: "http://127.0.0.1:9090"}},
+	}
+	if poolSpec.Validate() == nil {
+		t.Error("faas with servers should be invalid")
+	}
+	poolSpec.Servers = nil
+	if poolSpec.Validate() != nil {
+		t.Error("validate should succeed")
+	}
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"bytes"
+	stdcontext "context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	defaultMirrorMaxBodySize    = 1 << 20
+	defaultMirrorTimeout        = 10 * time.Second
+	defaultMirrorMaxConcurrency = 100
+)
+
+type (
+	// MirrorSpec describes how the requests are mirrored to the mirror pool.
+	MirrorSpec struct {
+		// Percentage is the percentage of the requests passing the filter
+		// of the mirror pool to mirror, default is 100.
+		Percentage float64 `yaml:"percentage" jsonschema:"omitempty,minimum=0,maximum=100"`
+		// MaxBodySize is the maximum size of the bodies to mirror, requests
+		// with larger bodies aren't mirrored, default is 1MB.
+		MaxBodySize    int64  `yaml:"maxBodySize" jsonschema:"omitempty,minimum=0"`
+		Timeout        string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		MaxConcurrency int    `yaml:"maxConcurrency" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// MirrorStatus is the status of mirroring, the statistics of the
+	// responses are in the status of the mirror pool.
+	MirrorStatus struct {
+		Mirrored uint64 `yaml:"mirrored"`
+		// Failed is the number of mirrored requests got no response.
+		Failed uint64 `yaml:"failed"`
+		// Oversized is the number of requests not mirrored because of
+		// their large bodies.
+		Oversized uint64 `yaml:"oversized"`
+		// Dropped is the number of requests not mirrored because there
+		// are too many mirrored requests in flight.
+		Dropped  uint64 `yaml:"dropped"`
+		Inflight int    `yaml:"inflight"`
+	}
+
+	// mirror sends copies of the requests to the mirror pool, it doesn't
+	// wait for the responses, which are always discarded.
+	mirror struct {
+		pool *pool
+
+		percentage  float64
+		maxBodySize int64
+		timeout     time.Duration
+		sem         chan struct{}
+
+		mirrored  uint64
+		failed    uint64
+		oversized uint64
+		dropped   uint64
+	}
+)
+
+func newMirror(spec *MirrorSpec, p *pool) *mirror {
+	if spec == nil {
+		spec = &MirrorSpec{}
+	}
+
+	m := &mirror{
+		pool:        p,
+		percentage:  spec.Percentage,
+		maxBodySize: spec.MaxBodySize,
+		timeout:     parseDurationOr(spec.Timeout, defaultMirrorTimeout),
+	}
+	if m.percentage == 0 {
+		m.percentage = 100
+	}
+	if m.maxBodySize == 0 {
+		m.maxBodySize = defaultMirrorMaxBodySize
+	}
+	maxConcurrency := spec.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = defaultMirrorMaxConcurrency
+	}
+	m.sem = make(chan struct{}, maxConcurrency)
+
+	return m
+}
+
+func (m *mirror) sample() bool {
+	return m.percentage >= 100 || rand.Float64()*100 < m.percentage
+}
+
+// readBody reads the body of the request for mirroring and sets it back
+// for the main request, ok is false if the body is too large.
+func (m *mirror) readBody(ctx context.HTTPContext) (body []byte, ok bool) {
+	r := ctx.Request()
+	if cl, err := strconv.ParseInt(r.Header().Get(httpheader.KeyContentLength), 10, 64); err == nil && cl > m.maxBodySize {
+		return nil, false
+	}
+
+	reqBody := r.Body()
+	if reqBody == nil {
+		return nil, true
+	}
+
+	buff, err := io.ReadAll(io.LimitReader(reqBody, m.maxBodySize+1))
+	r.SetBody(io.MultiReader(bytes.NewReader(buff), reqBody))
+	if err != nil || int64(len(buff)) > m.maxBodySize {
+		return nil, false
+	}
+	return buff, true
+}
+
+// handle mirrors the request if it is sampled, it returns immediately
+// after the mirrored request is created.
+func (m *mirror) handle(ctx context.HTTPContext, client *http.Client) {
+	if !m.sample() {
+		return
+	}
+
+	body, ok := m.readBody(ctx)
+	if !ok {
+		atomic.AddUint64(&m.oversized, 1)
+		ctx.AddTag("proxy#mirror: skip large body")
+		return
+	}
+
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+		ctx.AddTag("proxy#mirror: dropped")
+		return
+	}
+
+	server, stat, err := m.pool.servers.next(ctx)
+	if err != nil {
+		<-m.sem
+		atomic.AddUint64(&m.failed, 1)
+		ctx.AddTag(stringtool.Cat("proxy#mirror#serverErr: ", err.Error()))
+		return
+	}
+	ctx.AddTag(stringtool.Cat("proxy#mirror#addr: ", server.URL))
+
+	r := ctx.Request()
+	url := server.URL + r.Path()
+	if r.Query() != "" {
+		url += "?" + r.Query()
+	}
+
+	// NOTE: The mirrored request must not be cancelled by the HTTPContext,
+	// which finishes without waiting for it.
+	reqCtx, cancel := stdcontext.WithTimeout(stdcontext.Background(), m.timeout)
+	stdr, err := http.NewRequestWithContext(reqCtx, r.Method(), url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		<-m.sem
+		logger.Errorf("BUG: new mirror request failed: %v", err)
+		return
+	}
+	stdr.Header = r.Header().Std().Clone()
+	stdr.Host = r.Host()
+
+	spanName := m.pool.spec.SpanName
+	if spanName == "" {
+		spanName = server.URL
+	}
+	span := ctx.Span().NewChild(spanName)
+	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(stdr.Header))
+
+	atomic.AddUint64(&m.mirrored, 1)
+	stat.begin()
+
+	send := fnSendRequest
+	go func() {
+		defer func() { <-m.sem }()
+		defer cancel()
+		defer span.Finish()
+
+		startTime := time.Now()
+		resp, err := send(stdr, client)
+		if err != nil {
+			stat.end(0)
+			atomic.AddUint64(&m.failed, 1)
+			m.pool.servers.recordResult(server.URL, true)
+			return
+		}
+
+		// NOTE: Need to be read to completion and closed.
+		// Reference: https://golang.org/pkg/net/http/#Response
+		n, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		d := time.Since(startTime)
+		stat.end(d)
+		m.pool.servers.recordResult(server.URL, resp.StatusCode >= 500)
+		m.pool.httpStat.Stat(&httpstat.Metric{
+			StatusCode: resp.StatusCode,
+			Duration:   d,
+			ReqSize:    uint64(len(body)),
+			RespSize:   uint64(responseMetaSize(resp)) + uint64(n),
+		})
+	}()
+}
+
+func (m *mirror) status() *MirrorStatus {
+	return &MirrorStatus{
+		Mirrored:  atomic.LoadUint64(&m.mirrored),
+		Failed:    atomic.LoadUint64(&m.failed),
+		Oversized: atomic.LoadUint64(&m.oversized),
+		Dropped:   atomic.LoadUint64(&m.dropped),
+		Inflight:  len(m.sem),
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func newMirrorProxy(t *testing.T, mainURL, mirrorURL, mirrorSpec string) *Proxy {
+	yamlSpec := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: %s
+  loadBalance:
+    policy: roundRobin
+mirrorPool:
+  filter:
+    headers:
+      "X-Mirror":
+        exact: mirror
+  servers:
+  - url: %s
+  loadBalance:
+    policy: roundRobin
+%s`, mainURL, mirrorURL, mirrorSpec)
+
+	return httppipelinetest.InitFilter(t, &Proxy{}, yamlSpec).(*Proxy)
+}
+
+func doMirrorRequest(p *Proxy, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/test?a=1", strings.NewReader(body))
+	req.Header.Set("X-Mirror", "mirror")
+	_, w := httppipelinetest.Handle(p, req, nil)
+	return w
+}
+
+func waitMirror(m *mirror) {
+	for i := 0; i < 200 && len(m.sem) > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	mainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("main:" + string(body)))
+	}))
+	defer mainServer.Close()
+
+	var mutex sync.Mutex
+	var mirrored []string
+	release := make(chan struct{})
+	mirrorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		mirrored = append(mirrored, r.URL.String()+" "+string(body))
+		mutex.Unlock()
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mirrorServer.Close()
+
+	proxy := newMirrorProxy(t, mainServer.URL, mirrorServer.URL, `
+mirror:
+  maxBodySize: 8
+  maxConcurrency: 1
+`)
+	defer proxy.Close()
+
+	// The response of the main pool doesn't wait for the mirror.
+	w := doMirrorRequest(proxy, "hello")
+	if w.Body.String() != "main:hello" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+
+	w = doMirrorRequest(proxy, "world")
+	if w.Body.String() != "main:world" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+
+	w = doMirrorRequest(proxy, "large body")
+	if w.Body.String() != "main:large body" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+
+	close(release)
+	waitMirror(proxy.mirror)
+
+	mutex.Lock()
+	if len(mirrored) != 1 || mirrored[0] != "/test?a=1 hello" {
+		t.Errorf("unexpected mirrored requests %v", mirrored)
+	}
+	mutex.Unlock()
+
+	s := proxy.Status().(*Status)
+	if s.Mirror.Mirrored != 1 || s.Mirror.Dropped != 1 || s.Mirror.Oversized != 1 || s.Mirror.Inflight != 0 {
+		t.Errorf("unexpected mirror status %+v", s.Mirror)
+	}
+	if s.MirrorPool.Stat.Count != 1 || s.MirrorPool.Stat.ErrCount != 1 {
+		t.Errorf("response of the mirror should be counted in the mirror pool, got %+v", s.MirrorPool.Stat)
+	}
+	if s.MainPool.Stat.Count != 3 || s.MainPool.Stat.ErrCount != 0 {
+		t.Errorf("unexpected main pool stat %+v", s.MainPool.Stat)
+	}
+}
+
+func TestMirrorPercentage(t *testing.T) {
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	mainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mainServer.Close()
+	var count int32
+	mirrorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer mirrorServer.Close()
+
+	proxy := newMirrorProxy(t, mainServer.URL, mirrorServer.URL, `
+mirror:
+  percentage: 20
+`)
+	defer proxy.Close()
+
+	for i := 0; i < 500; i++ {
+		doMirrorRequest(proxy, "")
+	}
+	waitMirror(proxy.mirror)
+
+	if n := atomic.LoadInt32(&count); n < 50 || n > 150 {
+		t.Errorf("about 100 requests should be mirrored, got %d", n)
+	}
+}
+
+func TestMirrorFailure(t *testing.T) {
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	mainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer mainServer.Close()
+
+	// NOTE: Nothing is listening on the address of the mirror pool.
+	proxy := newMirrorProxy(t, mainServer.URL, "http://127.0.0.1:1", "")
+	defer proxy.Close()
+
+	w := doMirrorRequest(proxy, "")
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("failure of the mirror should not affect the response, got %d", w.Code)
+	}
+	waitMirror(proxy.mirror)
+
+	if s := proxy.mirror.status(); s.Failed != 1 {
+		t.Errorf("mirror should fail, got %+v", s)
+	}
+}
+
+func TestMirrorSpecValidate(t *testing.T) {
+	spec := Spec{
+		MainPool: &PoolSpec{},
+		Mirror:   &MirrorSpec{Percentage: 10},
+	}
+	if spec.Validate() == nil {
+		t.Error("mirror without mirrorPool should be invalid")
+	}
+}
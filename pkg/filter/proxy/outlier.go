@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultConsecutiveErrors  = 5
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 300 * time.Second
+	defaultMaxEjectionPercent = 50
+)
+
+type (
+	// OutlierDetectionSpec describes the passive outlier detection,
+	// a server is ejected from the pool temporarily after it responds
+	// too many consecutive errors.
+	OutlierDetectionSpec struct {
+		ConsecutiveErrors  int    `yaml:"consecutiveErrors" jsonschema:"omitempty,minimum=1"`
+		BaseEjectionTime   string `yaml:"baseEjectionTime" jsonschema:"omitempty,format=duration"`
+		MaxEjectionTime    string `yaml:"maxEjectionTime" jsonschema:"omitempty,format=duration"`
+		MaxEjectionPercent int    `yaml:"maxEjectionPercent" jsonschema:"omitempty,minimum=0,maximum=100"`
+	}
+
+	outlierDetector struct {
+		consecutiveErrors  int
+		baseEjectionTime   time.Duration
+		maxEjectionTime    time.Duration
+		maxEjectionPercent int
+
+		// onChange is called without holding the lock when
+		// a server is ejected or brought back.
+		onChange func()
+
+		mutex  sync.Mutex
+		total  int
+		states map[string]*outlierState
+		done   chan struct{}
+	}
+
+	outlierState struct {
+		errors       int
+		ejections    int
+		ejectedUntil time.Time
+		timer        *time.Timer
+	}
+)
+
+// for unit testing cases to mock 'time.Now' only
+var nowFunc = time.Now
+
+func newOutlierDetector(spec *OutlierDetectionSpec, onChange func()) *outlierDetector {
+	od := &outlierDetector{
+		consecutiveErrors:  spec.ConsecutiveErrors,
+		baseEjectionTime:   parseDurationOr(spec.BaseEjectionTime, defaultBaseEjectionTime),
+		maxEjectionTime:    parseDurationOr(spec.MaxEjectionTime, defaultMaxEjectionTime),
+		maxEjectionPercent: spec.MaxEjectionPercent,
+		onChange:           onChange,
+		states:             make(map[string]*outlierState),
+		done:               make(chan struct{}),
+	}
+
+	if od.consecutiveErrors <= 0 {
+		od.consecutiveErrors = defaultConsecutiveErrors
+	}
+	if od.maxEjectionPercent <= 0 {
+		od.maxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if od.maxEjectionTime < od.baseEjectionTime {
+		od.maxEjectionTime = od.baseEjectionTime
+	}
+
+	return od
+}
+
+// setTotal sets the number of servers which could be ejected.
+func (od *outlierDetector) setTotal(total int) {
+	od.mutex.Lock()
+	od.total = total
+	od.mutex.Unlock()
+}
+
+func (od *outlierDetector) ejectedCount(now time.Time) int {
+	count := 0
+	for _, state := range od.states {
+		if now.Before(state.ejectedUntil) {
+			count++
+		}
+	}
+	return count
+}
+
+// record records the result of a request to the server.
+func (od *outlierDetector) record(url string, failed bool) {
+	ejected, d := od.update(url, failed)
+	if !ejected {
+		return
+	}
+
+	logger.Warnf("outlier detection: eject %s for %v", url, d)
+	if od.onChange != nil {
+		od.onChange()
+	}
+}
+
+func (od *outlierDetector) update(url string, failed bool) (bool, time.Duration) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state := od.states[url]
+	if state == nil {
+		state = &outlierState{}
+		od.states[url] = state
+	}
+
+	now := nowFunc()
+	if !failed {
+		state.errors = 0
+		// NOTE: Forgive the ejection history if the server has
+		// been working well for long enough.
+		if state.ejections > 0 && now.Sub(state.ejectedUntil) > od.maxEjectionTime {
+			state.ejections = 0
+		}
+		return false, 0
+	}
+
+	state.errors++
+	if state.errors < od.consecutiveErrors || now.Before(state.ejectedUntil) {
+		return false, 0
+	}
+
+	if (od.ejectedCount(now)+1)*100 > od.total*od.maxEjectionPercent {
+		return false, 0
+	}
+
+	// The ejection time doubles every time the server is ejected.
+	d := od.baseEjectionTime << uint(state.ejections)
+	if d > od.maxEjectionTime || d <= 0 {
+		d = od.maxEjectionTime
+	}
+	state.ejections++
+	state.errors = 0
+	state.ejectedUntil = now.Add(d)
+
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	state.timer = time.AfterFunc(d, func() {
+		select {
+		case <-od.done:
+			return
+		default:
+		}
+		logger.Infof("outlier detection: bring %s back", url)
+		if od.onChange != nil {
+			od.onChange()
+		}
+	})
+
+	return true, d
+}
+
+// ejected returns whether the server is ejected.
+func (od *outlierDetector) ejected(url string) bool {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state := od.states[url]
+	return state != nil && nowFunc().Before(state.ejectedUntil)
+}
+
+func (od *outlierDetector) status() map[string]time.Time {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	now := nowFunc()
+	s := make(map[string]time.Time)
+	for url, state := range od.states {
+		if now.Before(state.ejectedUntil) {
+			s[url] = state.ejectedUntil
+		}
+	}
+	return s
+}
+
+func (od *outlierDetector) close() {
+	close(od.done)
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+	for _, state := range od.states {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierEjection(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	od := newOutlierDetector(&OutlierDetectionSpec{
+		ConsecutiveErrors: 2,
+		BaseEjectionTime:  "1m",
+		MaxEjectionTime:   "3m",
+	}, nil)
+	defer od.close()
+	od.setTotal(2)
+
+	url := "http://127.0.0.1:9091"
+	od.record(url, true)
+	od.record(url, false)
+	od.record(url, true)
+	if od.ejected(url) {
+		t.Fatal("errors are not consecutive, should not be ejected")
+	}
+
+	od.record(url, true)
+	if !od.ejected(url) {
+		t.Fatal("should be ejected")
+	}
+	if until := od.status()[url]; !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("should be ejected for 1m, until %v", until)
+	}
+
+	// The ejection time grows exponentially and is capped.
+	for _, d := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		now = od.status()[url]
+		od.record(url, true)
+		od.record(url, true)
+		if until := od.status()[url]; !until.Equal(now.Add(d)) {
+			t.Errorf("should be ejected for %v, until %v", d, until)
+		}
+	}
+
+	// The history is forgiven after working well for long enough.
+	now = od.status()[url].Add(4 * time.Minute)
+	od.record(url, false)
+	od.record(url, true)
+	od.record(url, true)
+	if until := od.status()[url]; !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("should be ejected for 1m, until %v", until)
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	od := newOutlierDetector(&OutlierDetectionSpec{ConsecutiveErrors: 1}, nil)
+	defer od.close()
+	od.setTotal(3)
+
+	od.record("http://127.0.0.1:9091", true)
+	od.record("http://127.0.0.1:9092", true)
+	if len(od.status()) != 1 {
+		t.Errorf("at most 50%% of servers could be ejected, got %d", len(od.status()))
+	}
+}
+
+func TestOutlierServers(t *testing.T) {
+	configServers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+	}
+	s := newServers(nil, &PoolSpec{
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		Servers:     configServers,
+		OutlierDetection: &OutlierDetectionSpec{
+			ConsecutiveErrors: 1,
+			BaseEjectionTime:  "20ms",
+		},
+	}, nil)
+	defer s.close()
+
+	s.recordResult(configServers[1].URL, true)
+	if s.len() != 1 {
+		t.Fatalf("ejected server should be removed")
+	}
+	if _, ok := s.ejectionStatus()[configServers[1].URL]; !ok {
+		t.Error("ejected server should be in the status")
+	}
+
+	for i := 0; i < 100 && s.len() != 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.len() != 2 {
+		t.Fatalf("ejected server should be brought back")
+	}
+}
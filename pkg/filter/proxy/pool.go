@@ -18,11 +18,15 @@
 package proxy
 
 import (
+	stdcontext "context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 
@@ -31,6 +35,7 @@ import (
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/callbackreader"
+	"github.com/megaease/easegress/pkg/util/healthcheck"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
@@ -48,30 +53,47 @@ type (
 		filter *httpfilter.HTTPFilter
 
 		servers     *servers
+		faas        *faas
 		httpStat    *httpstat.HTTPStat
 		memoryCache *memorycache.MemoryCache
 	}
 
 	// PoolSpec describes a pool of servers.
 	PoolSpec struct {
-		SpanName        string            `yaml:"spanName" jsonschema:"omitempty"`
-		Filter          *httpfilter.Spec  `yaml:"filter" jsonschema:"omitempty"`
-		ServersTags     []string          `yaml:"serversTags" jsonschema:"omitempty,uniqueItems=true"`
-		Servers         []*Server         `yaml:"servers" jsonschema:"omitempty"`
-		ServiceRegistry string            `yaml:"serviceRegistry" jsonschema:"omitempty"`
-		ServiceName     string            `yaml:"serviceName" jsonschema:"omitempty"`
-		LoadBalance     *LoadBalance      `yaml:"loadBalance" jsonschema:"required"`
-		MemoryCache     *memorycache.Spec `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+		SpanName         string                `yaml:"spanName" jsonschema:"omitempty"`
+		Filter           *httpfilter.Spec      `yaml:"filter" jsonschema:"omitempty"`
+		ServersTags      []string              `yaml:"serversTags" jsonschema:"omitempty,uniqueItems=true"`
+		Servers          []*Server             `yaml:"servers" jsonschema:"omitempty"`
+		ServiceRegistry  string                `yaml:"serviceRegistry" jsonschema:"omitempty"`
+		ServiceName      string                `yaml:"serviceName" jsonschema:"omitempty"`
+		LoadBalance      *LoadBalance          `yaml:"loadBalance" jsonschema:"omitempty"`
+		MemoryCache      *memorycache.Spec     `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+		HealthCheck      *healthcheck.Spec     `yaml:"healthCheck,omitempty" jsonschema:"omitempty"`
+		OutlierDetection *OutlierDetectionSpec `yaml:"outlierDetection,omitempty" jsonschema:"omitempty"`
+		// FaaS invokes a serverless function instead of the servers.
+		FaaS *FaaSSpec `yaml:"faas,omitempty" jsonschema:"omitempty"`
 	}
 
 	// PoolStatus is the status of Pool.
 	PoolStatus struct {
-		Stat *httpstat.Status `yaml:"stat"`
+		Stat   *httpstat.Status                     `yaml:"stat"`
+		Health map[string]*healthcheck.TargetStatus `yaml:"health,omitempty"`
+		// Ejected is the servers ejected by outlier detection and
+		// when they will be brought back.
+		Ejected map[string]time.Time `yaml:"ejected,omitempty"`
+		FaaS    *FaaSStatus          `yaml:"faas,omitempty"`
 	}
 )
 
 // Validate validates poolSpec.
 func (s PoolSpec) Validate() error {
+	if s.FaaS != nil {
+		if s.ServiceName != "" || len(s.Servers) > 0 {
+			return fmt.Errorf("faas can't be used with serviceName or servers")
+		}
+		return nil
+	}
+
 	if s.ServiceName == "" && len(s.Servers) == 0 {
 		return fmt.Errorf("both serviceName and servers are empty")
 	}
@@ -98,7 +120,7 @@ func (s PoolSpec) Validate() error {
 }
 
 func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
-	writeResponse bool, failureCodes []int) *pool {
+	writeResponse bool, failureCodes []int, tlsConfig *tls.Config) *pool {
 
 	var filter *httpfilter.HTTPFilter
 	if spec.Filter != nil {
@@ -110,6 +132,11 @@ func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
 		memoryCache = memorycache.New(spec.MemoryCache)
 	}
 
+	var f *faas
+	if spec.FaaS != nil {
+		f = newFaaS(spec.FaaS)
+	}
+
 	return &pool{
 		spec: spec,
 
@@ -117,14 +144,22 @@ func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
 		writeResponse: writeResponse,
 
 		filter:      filter,
-		servers:     newServers(super, spec),
+		servers:     newServers(super, spec, tlsConfig),
+		faas:        f,
 		httpStat:    httpstat.New(),
 		memoryCache: memoryCache,
 	}
 }
 
 func (p *pool) status() *PoolStatus {
-	s := &PoolStatus{Stat: p.httpStat.Status()}
+	s := &PoolStatus{
+		Stat:    p.httpStat.Status(),
+		Health:  p.servers.healthStatus(),
+		Ejected: p.servers.ejectionStatus(),
+	}
+	if p.faas != nil {
+		s.FaaS = p.faas.status()
+	}
 	return s
 }
 
@@ -142,7 +177,7 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 		ctx.Unlock()
 	}
 
-	server, err := p.servers.next(ctx)
+	server, stat, err := p.next(ctx)
 	if err != nil {
 		addTag("serverErr", err.Error())
 		setStatusCode(http.StatusServiceUnavailable)
@@ -150,8 +185,30 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 	}
 	addTag("addr", server.URL)
 
+	if p.faas != nil {
+		if !p.faas.acquire(ctx) {
+			addTag("faas", "concurrency limit exceeded")
+			setStatusCode(http.StatusServiceUnavailable)
+			return resultServerError
+		}
+		ctx.Lock()
+		ctx.OnFinish(p.faas.release)
+		ctx.Unlock()
+	}
+
+	done, ok := guardUpstream(ctx, server.URL)
+	if !ok {
+		addTag("guard", "rejected")
+		setStatusCode(http.StatusServiceUnavailable)
+		return resultServerError
+	}
+
+	stat.begin()
+
 	req, err := p.prepareRequest(ctx, server, reqBody)
 	if err != nil {
+		stat.end(0)
+		done(0)
 		msg := stringtool.Cat("prepare request failed: ", err.Error())
 		logger.Errorf("BUG: %s", msg)
 		addTag("bug", msg)
@@ -161,6 +218,8 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 
 	resp, span, err := p.doRequest(ctx, req, client)
 	if err != nil {
+		stat.end(0)
+
 		// NOTE: May add option to cancel the tracing if failed here.
 		// ctx.Span().Cancel()
 
@@ -169,20 +228,29 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 		if ctx.ClientDisconnected() {
 			// NOTE: The HTTPContext will set 499 by itself if client is Disconnected.
 			// w.SetStatusCode((499)
+			done(0)
 			return resultClientError
 		}
 
-		setStatusCode(http.StatusServiceUnavailable)
+		p.servers.recordResult(server.URL, true)
+		code := http.StatusServiceUnavailable
+		if ctx.UpstreamTimeout() > 0 && errors.Is(err, stdcontext.DeadlineExceeded) {
+			code = http.StatusGatewayTimeout
+		}
+		setStatusCode(code)
+		done(code)
 		return resultServerError
 	}
 
 	addTag("code", strconv.Itoa(resp.StatusCode))
+	p.servers.recordResult(server.URL, resp.StatusCode >= 500)
+	done(resp.StatusCode)
 
 	ctx.Lock()
 	defer ctx.Unlock()
 	// NOTE: The code below can't use addTag and setStatusCode in case of deadlock.
 
-	respBody := p.statRequestResponse(ctx, req, resp, span)
+	respBody := p.statRequestResponse(ctx, req, resp, span, stat)
 
 	if p.writeResponse {
 		ctx.Response().SetStatusCode(resp.StatusCode)
@@ -204,8 +272,43 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 	return ""
 }
 
+// guardUpstream calls the upstream guards of the context with the server,
+// the returned function reports the status code to all of them.
+func guardUpstream(ctx context.HTTPContext, server string) (func(statusCode int), bool) {
+	guards := ctx.UpstreamGuards()
+	dones := make([]func(statusCode int), 0, len(guards))
+	done := func(statusCode int) {
+		for _, fn := range dones {
+			fn(statusCode)
+		}
+	}
+
+	for _, guard := range guards {
+		fn, ok := guard(server)
+		if !ok {
+			done(0)
+			return nil, false
+		}
+		dones = append(dones, fn)
+	}
+	return done, true
+}
+
+// next returns the function if the pool invokes a function, or the next
+// server otherwise.
+func (p *pool) next(ctx context.HTTPContext) (*Server, *serverStat, error) {
+	if p.faas != nil {
+		return p.faas.server, p.faas.stat, nil
+	}
+	return p.servers.next(ctx)
+}
+
 func (p *pool) prepareRequest(ctx context.HTTPContext, server *Server, reqBody io.Reader) (req *request, err error) {
-	return p.newRequest(ctx, server, reqBody)
+	req, err = p.newRequest(ctx, server, reqBody)
+	if err == nil && p.faas != nil {
+		p.faas.prepare(ctx, req)
+	}
+	return req, err
 }
 
 func (p *pool) doRequest(ctx context.HTTPContext, req *request, client *http.Client) (*http.Response, tracing.Span, error) {
@@ -219,7 +322,13 @@ func (p *pool) doRequest(ctx context.HTTPContext, req *request, client *http.Cli
 	span := ctx.Span().NewChildWithStart(spanName, req.startTime())
 	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.std.Header))
 
-	resp, err := fnSendRequest(req.std, client)
+	var resp *http.Response
+	var err error
+	if p.faas != nil {
+		resp, err = p.faas.send(req.std, client)
+	} else {
+		resp, err = fnSendRequest(req.std, client)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -227,7 +336,7 @@ func (p *pool) doRequest(ctx context.HTTPContext, req *request, client *http.Cli
 }
 
 func (p *pool) statRequestResponse(ctx context.HTTPContext,
-	req *request, resp *http.Response, span tracing.Span) io.Reader {
+	req *request, resp *http.Response, span tracing.Span, stat *serverStat) io.Reader {
 
 	var count int
 
@@ -250,6 +359,8 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 
 		ctx.AddTag(stringtool.Cat(p.tagPrefix, fmt.Sprintf("#duration: %s", req.total())))
 
+		stat.end(req.total())
+
 		metric := &httpstat.Metric{
 			StatusCode: resp.StatusCode,
 			Duration:   req.total(),
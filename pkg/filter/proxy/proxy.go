@@ -22,15 +22,14 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
-	"sync"
-	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	"github.com/megaease/easegress/pkg/util/fallback"
+	"github.com/megaease/easegress/pkg/util/httpheader"
 )
 
 const (
@@ -69,21 +68,31 @@ type (
 		mainPool       *pool
 		candidatePools []*pool
 		mirrorPool     *pool
+		mirror         *mirror
+		trafficSplit   *trafficSplit
 
-		client *http.Client
+		connPool *connPool
+		client   *http.Client
 
 		compression *compression
+
+		done chan struct{}
 	}
 
 	// Spec describes the Proxy.
 	Spec struct {
-		Fallback       *FallbackSpec    `yaml:"fallback,omitempty" jsonschema:"omitempty"`
-		MainPool       *PoolSpec        `yaml:"mainPool" jsonschema:"required"`
-		CandidatePools []*PoolSpec      `yaml:"candidatePools,omitempty" jsonschema:"omitempty"`
-		MirrorPool     *PoolSpec        `yaml:"mirrorPool,omitempty" jsonschema:"omitempty"`
-		FailureCodes   []int            `yaml:"failureCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
-		Compression    *CompressionSpec `yaml:"compression,omitempty" jsonschema:"omitempty"`
-		MTLS           *MTLS            `yaml:"mtls,omitempty" jsonschema:"omitempty"`
+		Fallback       *FallbackSpec     `yaml:"fallback,omitempty" jsonschema:"omitempty"`
+		MainPool       *PoolSpec         `yaml:"mainPool" jsonschema:"required"`
+		CandidatePools []*PoolSpec       `yaml:"candidatePools,omitempty" jsonschema:"omitempty"`
+		MirrorPool     *PoolSpec         `yaml:"mirrorPool,omitempty" jsonschema:"omitempty"`
+		Mirror         *MirrorSpec       `yaml:"mirror,omitempty" jsonschema:"omitempty"`
+		TrafficSplit   *TrafficSplitSpec `yaml:"trafficSplit,omitempty" jsonschema:"omitempty"`
+		FailureCodes   []int             `yaml:"failureCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		Compression    *CompressionSpec  `yaml:"compression,omitempty" jsonschema:"omitempty"`
+		SSE            *SSESpec          `yaml:"sse,omitempty" jsonschema:"omitempty"`
+		MTLS           *MTLS             `yaml:"mtls,omitempty" jsonschema:"omitempty"`
+
+		ConnectionPool *ConnectionPoolSpec `yaml:"connectionPool,omitempty" jsonschema:"omitempty"`
 	}
 
 	// FallbackSpec describes the fallback policy.
@@ -94,9 +103,13 @@ type (
 
 	// Status is the status of Proxy.
 	Status struct {
-		MainPool       *PoolStatus   `yaml:"mainPool"`
-		CandidatePools []*PoolStatus `yaml:"candidatePools,omitempty"`
-		MirrorPool     *PoolStatus   `yaml:"mirrorPool,omitempty"`
+		MainPool       *PoolStatus         `yaml:"mainPool"`
+		CandidatePools []*PoolStatus       `yaml:"candidatePools,omitempty"`
+		MirrorPool     *PoolStatus         `yaml:"mirrorPool,omitempty"`
+		Mirror         *MirrorStatus       `yaml:"mirror,omitempty"`
+		TrafficSplit   *TrafficSplitStatus `yaml:"trafficSplit,omitempty"`
+
+		ConnectionPool *ConnectionPoolStatus `yaml:"connectionPool"`
 	}
 
 	// MTLS is the configuration for client side mTLS.
@@ -133,6 +146,12 @@ func (s Spec) Validate() error {
 		if s.MirrorPool.MemoryCache != nil {
 			return fmt.Errorf("memoryCache must be empty in mirrorPool")
 		}
+		if s.MirrorPool.FaaS != nil {
+			return fmt.Errorf("faas must be empty in mirrorPool")
+		}
+	}
+	if s.Mirror != nil && s.MirrorPool == nil {
+		return fmt.Errorf("mirror needs mirrorPool")
 	}
 
 	if len(s.FailureCodes) == 0 {
@@ -209,9 +228,11 @@ func (b *Proxy) tlsConfig() *tls.Config {
 
 func (b *Proxy) reload() {
 	super := b.filterSpec.Super()
+	// NOTE: The health checks access servers in the same way as requests.
+	tlsConfig := b.tlsConfig()
 
 	b.mainPool = newPool(super, b.spec.MainPool, "proxy#main",
-		true /*writeResponse*/, b.spec.FailureCodes)
+		true /*writeResponse*/, b.spec.FailureCodes, tlsConfig)
 
 	if b.spec.Fallback != nil {
 		b.fallback = fallback.New(&b.spec.Fallback.Spec)
@@ -222,39 +243,38 @@ func (b *Proxy) reload() {
 		for k := range b.spec.CandidatePools {
 			candidatePools = append(candidatePools,
 				newPool(super, b.spec.CandidatePools[k], fmt.Sprintf("proxy#candidate#%d", k),
-					true, b.spec.FailureCodes))
+					true, b.spec.FailureCodes, tlsConfig))
 		}
 		b.candidatePools = candidatePools
 	}
 	if b.spec.MirrorPool != nil {
 		b.mirrorPool = newPool(super, b.spec.MirrorPool, "proxy#mirror",
-			false /*writeResponse*/, b.spec.FailureCodes)
+			false /*writeResponse*/, b.spec.FailureCodes, tlsConfig)
+		b.mirror = newMirror(b.spec.Mirror, b.mirrorPool)
+	}
+
+	if b.spec.TrafficSplit != nil {
+		b.trafficSplit = newTrafficSplit(super, b.spec.TrafficSplit,
+			b.mainPool, b.spec.FailureCodes, tlsConfig)
 	}
 
 	if b.spec.Compression != nil {
 		b.compression = newCompression(b.spec.Compression)
 	}
 
+	b.done = make(chan struct{})
+	if super != nil && super.Cluster() != nil {
+		go b.watchWeights()
+		if b.trafficSplit != nil {
+			go b.watchTrafficSplit()
+		}
+	}
+
+	b.connPool = newConnPool(b.spec.ConnectionPool, tlsConfig)
 	b.client = &http.Client{
 		// NOTE: Timeout could be no limit, real client or server could cancel it.
-		Timeout: 0,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 60 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			TLSClientConfig:    b.tlsConfig(),
-			DisableCompression: false,
-			// NOTE: The large number of Idle Connections can
-			// reduce overhead of building connections.
-			MaxIdleConns:          10240,
-			MaxIdleConnsPerHost:   512,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
+		Timeout:   0,
+		Transport: b.connPool,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -264,7 +284,8 @@ func (b *Proxy) reload() {
 // Status returns Proxy status.
 func (b *Proxy) Status() interface{} {
 	s := &Status{
-		MainPool: b.mainPool.status(),
+		MainPool:       b.mainPool.status(),
+		ConnectionPool: b.connPool.status(),
 	}
 	if b.candidatePools != nil {
 		for k := range b.candidatePools {
@@ -273,12 +294,18 @@ func (b *Proxy) Status() interface{} {
 	}
 	if b.mirrorPool != nil {
 		s.MirrorPool = b.mirrorPool.status()
+		s.Mirror = b.mirror.status()
+	}
+	if b.trafficSplit != nil {
+		s.TrafficSplit = b.trafficSplit.status()
 	}
 	return s
 }
 
 // Close closes Proxy.
 func (b *Proxy) Close() {
+	close(b.done)
+
 	b.mainPool.close()
 
 	if b.candidatePools != nil {
@@ -290,6 +317,12 @@ func (b *Proxy) Close() {
 	if b.mirrorPool != nil {
 		b.mirrorPool.close()
 	}
+
+	if b.trafficSplit != nil {
+		b.trafficSplit.close()
+	}
+
+	b.connPool.close()
 }
 
 func (b *Proxy) fallbackForCodes(ctx context.HTTPContext) bool {
@@ -310,19 +343,23 @@ func (b *Proxy) Handle(ctx context.HTTPContext) (result string) {
 	return ctx.CallNextHandler(result)
 }
 
-func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
-	if b.mirrorPool != nil && b.mirrorPool.filter.Filter(ctx) {
-		master, slave := newMasterSlaveReader(ctx.Request().Body())
-		ctx.Request().SetBody(master)
+func (b *Proxy) handleSSE(ctx context.HTTPContext, p *pool) {
+	upstream, ok := ctx.Response().Body().(io.ReadCloser)
+	if !ok {
+		logger.Errorf("BUG: body of event stream is %T, not io.ReadCloser", ctx.Response().Body())
+		return
+	}
 
-		wg := &sync.WaitGroup{}
-		wg.Add(1)
-		defer wg.Wait()
+	lastEventID := ctx.Request().Header().Get(keyLastEventID)
+	body := newSSEBody(b.spec.SSE, upstream, lastEventID, func(lastEventID string) (io.ReadCloser, error) {
+		return p.reconnectSSE(ctx, b.client, lastEventID)
+	})
+	ctx.Response().SetBody(body)
+}
 
-		go func() {
-			defer wg.Done()
-			b.mirrorPool.handle(ctx, slave, b.client)
-		}()
+func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
+	if b.mirrorPool != nil && b.mirrorPool.filter.Filter(ctx) {
+		b.mirror.handle(ctx, b.client)
 	}
 
 	var p *pool
@@ -335,6 +372,10 @@ func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
 		}
 	}
 
+	if p == nil && b.trafficSplit != nil {
+		p = b.trafficSplit.choose(ctx)
+	}
+
 	if p == nil {
 		p = b.mainPool
 	}
@@ -352,6 +393,15 @@ func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
 		return resultFallback
 	}
 
+	// NOTE: Event streams are sent to the client as they arrive,
+	// so they are neither compressed nor cached.
+	if isEventStream(ctx.Response().Header().Get(httpheader.KeyContentType)) {
+		if b.spec.SSE != nil {
+			b.handleSSE(ctx, p)
+		}
+		return ""
+	}
+
 	// compression and memoryCache only work for
 	// normal traffic from real proxy servers.
 	if b.compression != nil {
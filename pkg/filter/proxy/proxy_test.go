@@ -25,6 +25,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
@@ -237,3 +238,38 @@ func TestPoolSpecValidate(t *testing.T) {
 		t.Error("validate should succeed")
 	}
 }
+
+func TestGuardUpstream(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+
+	var codes []int
+	guard := func(reject string) context.UpstreamGuard {
+		return func(server string) (func(int), bool) {
+			if server == reject {
+				return nil, false
+			}
+			return func(code int) {
+				codes = append(codes, code)
+			}, true
+		}
+	}
+	ctx.SetUpstreamGuards([]context.UpstreamGuard{guard(""), guard("http://bad")})
+
+	done, ok := guardUpstream(ctx, "http://good")
+	if !ok {
+		t.Fatal("request to the good server should be permitted")
+	}
+	done(http.StatusOK)
+	if len(codes) != 2 || codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Errorf("all guards should be done with the status code, got %v", codes)
+	}
+
+	// The guards permitted before the rejection are done with 0.
+	codes = nil
+	if _, ok = guardUpstream(ctx, "http://bad"); ok {
+		t.Fatal("request to the bad server should be rejected")
+	}
+	if len(codes) != 1 || codes[0] != 0 {
+		t.Errorf("the first guard should be done with 0, got %v", codes)
+	}
+}
@@ -19,9 +19,11 @@ package proxy
 
 import (
 	"bytes"
+	stdcontext "context"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	httpstat "github.com/tcnksm/go-httpstat"
@@ -59,7 +61,17 @@ func (p *pool) newRequest(ctx context.HTTPContext, server *Server, reqBody io.Re
 		url += "?" + r.Query()
 	}
 
-	newCtx := httpstat.WithHTTPStat(ctx, req.statResult)
+	var newCtx stdcontext.Context = ctx
+	if timeout := ctx.UpstreamTimeout(); timeout > 0 {
+		var cancel stdcontext.CancelFunc
+		newCtx, cancel = stdcontext.WithTimeout(newCtx, timeout)
+		// NOTE: The response body is read after handling, so the
+		// context could only be cancelled when the HTTPContext finishes.
+		ctx.Lock()
+		ctx.OnFinish(cancel)
+		ctx.Unlock()
+	}
+	newCtx = httpstat.WithHTTPStat(newCtx, req.statResult)
 	stdr, err := http.NewRequestWithContext(newCtx, r.Method(), url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("BUG: new request failed: %v", err)
@@ -68,6 +80,16 @@ func (p *pool) newRequest(ctx context.HTTPContext, server *Server, reqBody io.Re
 	stdr.Header = r.Header().Std()
 	stdr.Host = r.Host()
 
+	// NOTE: The remaining time is computed for every request, so each
+	// attempt of the retries sees the time left when it is sent.
+	if header, deadline := ctx.UpstreamDeadline(); header != "" {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 0 {
+			ms = 0
+		}
+		stdr.Header.Set(header, strconv.FormatInt(ms, 10))
+	}
+
 	req.std = stdr
 
 	return req, nil
@@ -20,6 +20,7 @@ package proxy
 import (
 	"bytes"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -81,6 +82,35 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestRequestUpstreamDeadline(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodGet
+	}
+	header := httpheader.New(http.Header{})
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return header
+	}
+
+	p := pool{}
+	server := Server{URL: "http://192.168.1.2"}
+
+	// The remaining time is computed when the request is sent.
+	ctx.SetUpstreamDeadline("X-Timeout-Ms", time.Now().Add(time.Second))
+	time.Sleep(200 * time.Millisecond)
+	req, _ := p.newRequest(ctx, &server, nil)
+	ms, _ := strconv.ParseInt(req.std.Header.Get("X-Timeout-Ms"), 10, 64)
+	if ms <= 0 || ms > 800 {
+		t.Errorf("expected remaining time at most 800ms, got %s", req.std.Header.Get("X-Timeout-Ms"))
+	}
+
+	ctx.SetUpstreamDeadline("X-Timeout-Ms", time.Now().Add(-time.Second))
+	req, _ = p.newRequest(ctx, &server, nil)
+	if got := req.std.Header.Get("X-Timeout-Ms"); got != "0" {
+		t.Errorf("expected remaining time 0, got %s", got)
+	}
+}
+
 func TestResultState(t *testing.T) {
 	rs := &resultState{buff: &bytes.Buffer{}}
 	if n, b := rs.Width(); n != 0 || b {
@@ -18,7 +18,9 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
+	"math"
 	"math/rand"
 	"sync"
 	"sync/atomic"
@@ -29,6 +31,7 @@ import (
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/hashtool"
+	"github.com/megaease/easegress/pkg/util/healthcheck"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
@@ -43,6 +46,18 @@ const (
 	PolicyIPHash = "ipHash"
 	// PolicyHeaderHash is the policy of header hash.
 	PolicyHeaderHash = "headerHash"
+	// PolicyWeightedRoundRobin is the policy of smooth weighted round-robin.
+	PolicyWeightedRoundRobin = "weightedRoundRobin"
+	// PolicyLeastConnections is the policy of least in-flight requests.
+	PolicyLeastConnections = "leastConnections"
+	// PolicyEWMALatency is the policy of the lowest exponentially weighted
+	// moving average of response latency.
+	PolicyEWMALatency = "ewmaLatency"
+	// PolicyConsistentHash is the policy of consistent hash.
+	PolicyConsistentHash = "consistentHash"
+
+	// ewmaDecay is the weight of the latest sample of the latency EWMA.
+	ewmaDecay = 0.1
 
 	retryTimeout = 3 * time.Second
 )
@@ -57,6 +72,15 @@ type (
 		serviceWatcher  serviceregistry.ServiceWatcher
 		static          *staticServers
 		done            chan struct{}
+
+		// candidates are the servers before applying weights,
+		// they come from either the spec or the service registry.
+		candidates []*Server
+		// weights overrides the weights of servers by URL.
+		weights map[string]int
+
+		healthCheck *healthcheck.Checker
+		outlier     *outlierDetector
 	}
 
 	staticServers struct {
@@ -64,6 +88,14 @@ type (
 		weightsSum int
 		servers    []*Server
 		lb         LoadBalance
+
+		// stats is the runtime statistics of servers by URL.
+		stats map[string]*serverStat
+
+		wrrMutex   sync.Mutex
+		wrrCurrent []int
+
+		ring *hashRing
 	}
 
 	// Server is proxy server.
@@ -73,10 +105,20 @@ type (
 		Weight int      `yaml:"weight" jsonschema:"omitempty,minimum=0,maximum=100"`
 	}
 
+	// serverStat is the runtime statistics of a server used by
+	// the load balance policies which depend on feedback.
+	serverStat struct {
+		inflight int64
+		// ewma is the EWMA of latency in nanoseconds, stored as float64 bits.
+		ewma uint64
+	}
+
 	// LoadBalance is load balance for multiple servers.
 	LoadBalance struct {
-		Policy        string `yaml:"policy" jsonschema:"required,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash"`
+		Policy        string `yaml:"policy" jsonschema:"required,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash,enum=weightedRoundRobin,enum=leastConnections,enum=ewmaLatency,enum=consistentHash"`
 		HeaderHashKey string `yaml:"headerHashKey" jsonschema:"omitempty"`
+
+		ConsistentHash *ConsistentHashSpec `yaml:"consistentHash,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -90,16 +132,27 @@ func (lb LoadBalance) Validate() error {
 		return fmt.Errorf("headerHash needs to specify headerHashKey")
 	}
 
+	if lb.Policy == PolicyConsistentHash && lb.ConsistentHash == nil {
+		return fmt.Errorf("consistentHash needs to specify consistentHash")
+	}
+
 	return nil
 }
 
-func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec) *servers {
+func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec, tlsConfig *tls.Config) *servers {
 	s := &servers{
 		poolSpec: poolSpec,
 		super:    super,
 		done:     make(chan struct{}),
 	}
 
+	if poolSpec.HealthCheck != nil {
+		s.healthCheck = healthcheck.New(poolSpec.HealthCheck, tlsConfig, s.onHealthChange)
+	}
+	if poolSpec.OutlierDetection != nil {
+		s.outlier = newOutlierDetector(poolSpec.OutlierDetection, s.onEjectionChange)
+	}
+
 	s.useStaticServers()
 
 	if poolSpec.ServiceRegistry == "" || poolSpec.ServiceName == "" {
@@ -166,19 +219,144 @@ func (s *servers) useService(serviceInstanceSpecs map[string]*serviceregistry.Se
 		logger.Warnf("%s/%s: no service instance satisfy tags: %v",
 			s.poolSpec.ServiceRegistry, s.poolSpec.ServiceName, s.poolSpec.ServersTags)
 		s.useStaticServers()
+		return
 	}
 
 	logger.Infof("use dynamic service: %s/%s", s.poolSpec.ServiceRegistry, s.poolSpec.ServiceName)
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = dynamicServers
+	s.candidates = servers
+	s._rebuild()
 }
 
 func (s *servers) useStaticServers() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = newStaticServers(s.poolSpec.Servers, s.poolSpec.ServersTags, s.poolSpec.LoadBalance)
+	s.candidates = s.poolSpec.Servers
+	s._rebuild()
+}
+
+// setWeights overrides the weights of servers by their URL, servers not
+// in weights keep their original weights. A nil weights removes all overrides.
+func (s *servers) setWeights(weights map[string]int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.weights = weights
+	s._rebuild()
+}
+
+func (s *servers) _rebuild() {
+	candidates := s.candidates
+	if len(s.weights) > 0 {
+		candidates = make([]*Server, 0, len(s.candidates))
+		for _, server := range s.candidates {
+			if w, ok := s.weights[server.URL]; ok {
+				copied := *server
+				copied.Weight = w
+				server = &copied
+			}
+			candidates = append(candidates, server)
+		}
+	}
+	candidates = s._filterHealthy(candidates)
+	candidates = s._filterEjected(candidates)
+	static := newStaticServers(candidates, s.poolSpec.ServersTags, s.poolSpec.LoadBalance)
+
+	// NOTE: Keep the statistics of servers which are still there,
+	// so that in-flight requests are counted correctly.
+	if s.static != nil {
+		for url := range static.stats {
+			if stat := s.static.stats[url]; stat != nil {
+				static.stats[url] = stat
+			}
+		}
+	}
+
+	s.static = static
+}
+
+func (s *servers) _filterHealthy(candidates []*Server) []*Server {
+	if s.healthCheck == nil {
+		return candidates
+	}
+
+	urls := make([]string, 0, len(candidates))
+	for _, server := range candidates {
+		urls = append(urls, server.URL)
+	}
+	s.healthCheck.Update(urls)
+
+	healthy := make([]*Server, 0, len(candidates))
+	for _, server := range candidates {
+		if s.healthCheck.Healthy(server.URL) {
+			healthy = append(healthy, server)
+		}
+	}
+
+	// NOTE: It's better to try unhealthy servers than to reject
+	// all requests when none of the servers is healthy.
+	if len(healthy) == 0 {
+		logger.Warnf("none of servers is healthy, use all of them")
+		return candidates
+	}
+
+	return healthy
+}
+
+func (s *servers) _filterEjected(candidates []*Server) []*Server {
+	if s.outlier == nil {
+		return candidates
+	}
+
+	s.outlier.setTotal(len(candidates))
+
+	available := make([]*Server, 0, len(candidates))
+	for _, server := range candidates {
+		if !s.outlier.ejected(server.URL) {
+			available = append(available, server)
+		}
+	}
+
+	if len(available) == 0 {
+		logger.Warnf("all servers are ejected, use all of them")
+		return candidates
+	}
+
+	return available
+}
+
+func (s *servers) onEjectionChange() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s._rebuild()
+}
+
+// recordResult records the result of a request for outlier detection.
+func (s *servers) recordResult(url string, failed bool) {
+	if s.outlier != nil {
+		s.outlier.record(url, failed)
+	}
+}
+
+func (s *servers) ejectionStatus() map[string]time.Time {
+	if s.outlier == nil {
+		return nil
+	}
+	return s.outlier.status()
+}
+
+func (s *servers) onHealthChange(url string, healthy bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s._rebuild()
+}
+
+func (s *servers) healthStatus() map[string]*healthcheck.TargetStatus {
+	if s.healthCheck == nil {
+		return nil
+	}
+	return s.healthCheck.Status()
 }
 
 func (s *servers) snapshot() *staticServers {
@@ -194,14 +372,15 @@ func (s *servers) len() int {
 	return static.len()
 }
 
-func (s *servers) next(ctx context.HTTPContext) (*Server, error) {
+func (s *servers) next(ctx context.HTTPContext) (*Server, *serverStat, error) {
 	static := s.snapshot()
 
 	if static.len() == 0 {
-		return nil, fmt.Errorf("no server available")
+		return nil, nil, fmt.Errorf("no server available")
 	}
 
-	return static.next(ctx), nil
+	server := static.next(ctx)
+	return server, static.stats[server.URL], nil
 }
 
 func (s *servers) close() {
@@ -210,6 +389,14 @@ func (s *servers) close() {
 	if s.serviceWatcher != nil {
 		s.serviceWatcher.Stop()
 	}
+
+	if s.healthCheck != nil {
+		s.healthCheck.Close()
+	}
+
+	if s.outlier != nil {
+		s.outlier.close()
+	}
 }
 
 func newStaticServers(servers []*Server, tags []string, lb *LoadBalance) *staticServers {
@@ -246,8 +433,15 @@ func newStaticServers(servers []*Server, tags []string, lb *LoadBalance) *static
 }
 
 func (ss *staticServers) prepare() {
+	ss.stats = make(map[string]*serverStat, len(ss.servers))
 	for _, server := range ss.servers {
 		ss.weightsSum += server.Weight
+		ss.stats[server.URL] = &serverStat{}
+	}
+	ss.wrrCurrent = make([]int, len(ss.servers))
+
+	if ss.lb.Policy == PolicyConsistentHash && ss.lb.ConsistentHash != nil {
+		ss.ring = newHashRing(ss.servers, ss.lb.ConsistentHash.VirtualNodes)
 	}
 }
 
@@ -267,6 +461,14 @@ func (ss *staticServers) next(ctx context.HTTPContext) *Server {
 		return ss.ipHash(ctx)
 	case PolicyHeaderHash:
 		return ss.headerHash(ctx)
+	case PolicyWeightedRoundRobin:
+		return ss.weightedRoundRobin(ctx)
+	case PolicyLeastConnections:
+		return ss.leastConnections(ctx)
+	case PolicyEWMALatency:
+		return ss.ewmaLatency(ctx)
+	case PolicyConsistentHash:
+		return ss.consistentHash(ctx)
 	}
 
 	logger.Errorf("BUG: unknown load balance policy: %s", ss.lb.Policy)
@@ -310,3 +512,98 @@ func (ss *staticServers) headerHash(ctx context.HTTPContext) *Server {
 	sum32 := int(hashtool.Hash32(value))
 	return ss.servers[sum32%len(ss.servers)]
 }
+
+// weightedRoundRobin is the smooth weighted round-robin used by Nginx,
+// it spreads the picks of a heavy server instead of picking it in a row.
+func (ss *staticServers) weightedRoundRobin(ctx context.HTTPContext) *Server {
+	if ss.weightsSum <= 0 {
+		return ss.roundRobin(ctx)
+	}
+
+	ss.wrrMutex.Lock()
+	defer ss.wrrMutex.Unlock()
+
+	best := -1
+	for i, server := range ss.servers {
+		ss.wrrCurrent[i] += server.Weight
+		if best == -1 || ss.wrrCurrent[i] > ss.wrrCurrent[best] {
+			best = i
+		}
+	}
+	ss.wrrCurrent[best] -= ss.weightsSum
+
+	return ss.servers[best]
+}
+
+func (ss *staticServers) leastConnections(ctx context.HTTPContext) *Server {
+	// NOTE: Start from a random position to spread requests between
+	// servers with the same number of connections.
+	start := rand.Intn(len(ss.servers))
+
+	var chosen *Server
+	var minInflight int64
+	for i := range ss.servers {
+		server := ss.servers[(start+i)%len(ss.servers)]
+		inflight := ss.stats[server.URL].inflightCount()
+		if chosen == nil || inflight < minInflight {
+			chosen, minInflight = server, inflight
+		}
+	}
+
+	return chosen
+}
+
+func (ss *staticServers) ewmaLatency(ctx context.HTTPContext) *Server {
+	start := rand.Intn(len(ss.servers))
+
+	var chosen *Server
+	var minCost float64
+	for i := range ss.servers {
+		server := ss.servers[(start+i)%len(ss.servers)]
+		// NOTE: A server without any sample gets cost 0, so that
+		// every server could be tried at least once.
+		stat := ss.stats[server.URL]
+		cost := stat.latency() * float64(stat.inflightCount()+1)
+		if chosen == nil || cost < minCost {
+			chosen, minCost = server, cost
+		}
+	}
+
+	return chosen
+}
+
+func (st *serverStat) inflightCount() int64 {
+	return atomic.LoadInt64(&st.inflight)
+}
+
+func (st *serverStat) latency() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&st.ewma))
+}
+
+// begin records the start of a request to the server.
+func (st *serverStat) begin() {
+	atomic.AddInt64(&st.inflight, 1)
+}
+
+// end records the end of a request to the server, d is its latency,
+// zero d means the request failed without a meaningful latency.
+func (st *serverStat) end(d time.Duration) {
+	atomic.AddInt64(&st.inflight, -1)
+	if d <= 0 {
+		return
+	}
+
+	for {
+		old := atomic.LoadUint64(&st.ewma)
+		oldValue := math.Float64frombits(old)
+
+		newValue := float64(d)
+		if oldValue > 0 {
+			newValue = oldValue*(1-ewmaDecay) + newValue*ewmaDecay
+		}
+
+		if atomic.CompareAndSwapUint64(&st.ewma, old, math.Float64bits(newValue)) {
+			return
+		}
+	}
+}
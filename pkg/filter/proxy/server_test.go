@@ -20,13 +20,17 @@ package proxy
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
 	"github.com/megaease/easegress/pkg/util/hashtool"
+	"github.com/megaease/easegress/pkg/util/healthcheck"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 )
 
@@ -403,7 +407,170 @@ func TestDynamicService(t *testing.T) {
 		return wantStatic.servers[i].URL < wantStatic.servers[j].URL
 	})
 
-	if !reflect.DeepEqual(wantStatic, s.static) {
+	if !reflect.DeepEqual(wantStatic.lb, s.static.lb) ||
+		!reflect.DeepEqual(wantStatic.servers, s.static.servers) {
 		t.Fatalf("want: %+v\ngot :%+v\n", wantStatic, s.static)
 	}
 }
+
+func TestWeightedRoundRobin(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091", Weight: 5},
+		{URL: "http://127.0.0.1:9092", Weight: 1},
+		{URL: "http://127.0.0.1:9093", Weight: 1},
+	}
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyWeightedRoundRobin})
+	ctx := &contexttest.MockedHTTPContext{}
+
+	// The smooth weighted round-robin sequence of weights 5, 1, 1.
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	for round := 0; round < 3; round++ {
+		for i, w := range want {
+			if s := ss.next(ctx); s != servers[w] {
+				t.Fatalf("round %d pick %d: want %s, got %s", round, i, servers[w].URL, s.URL)
+			}
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+	}
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyLeastConnections})
+	ctx := &contexttest.MockedHTTPContext{}
+
+	ss.stats[servers[0].URL].begin()
+	ss.stats[servers[0].URL].begin()
+	ss.stats[servers[2].URL].begin()
+	for i := 0; i < 10; i++ {
+		if s := ss.next(ctx); s != servers[1] {
+			t.Fatalf("want %s, got %s", servers[1].URL, s.URL)
+		}
+	}
+
+	ss.stats[servers[1].URL].begin()
+	ss.stats[servers[1].URL].begin()
+	ss.stats[servers[2].URL].end(0)
+	if s := ss.next(ctx); s != servers[2] {
+		t.Fatalf("want %s, got %s", servers[2].URL, s.URL)
+	}
+}
+
+func TestEWMALatency(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+	}
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyEWMALatency})
+	ctx := &contexttest.MockedHTTPContext{}
+
+	for i := 0; i < 10; i++ {
+		ss.stats[servers[0].URL].begin()
+		ss.stats[servers[0].URL].end(100 * time.Millisecond)
+		ss.stats[servers[1].URL].begin()
+		ss.stats[servers[1].URL].end(10 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		if s := ss.next(ctx); s != servers[1] {
+			t.Fatalf("want %s, got %s", servers[1].URL, s.URL)
+		}
+	}
+
+	// The faster server is overloaded, its cost is 10ms * 21.
+	for i := 0; i < 20; i++ {
+		ss.stats[servers[1].URL].begin()
+	}
+	if s := ss.next(ctx); s != servers[0] {
+		t.Fatalf("want %s, got %s", servers[0].URL, s.URL)
+	}
+}
+
+func TestSetWeights(t *testing.T) {
+	configServers := []*Server{
+		{URL: "http://127.0.0.1:9091", Weight: 1},
+		{URL: "http://127.0.0.1:9092", Weight: 1},
+	}
+	s := &servers{
+		poolSpec: &PoolSpec{
+			LoadBalance: &LoadBalance{Policy: PolicyWeightedRoundRobin},
+			Servers:     configServers,
+		},
+	}
+	s.useStaticServers()
+	stat := s.static.stats[configServers[0].URL]
+
+	s.setWeights(map[string]int{"http://127.0.0.1:9092": 0})
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 10; i++ {
+		server, _, _ := s.next(ctx)
+		if server.URL != configServers[0].URL {
+			t.Fatalf("want %s, got %s", configServers[0].URL, server.URL)
+		}
+	}
+	if configServers[1].Weight != 1 {
+		t.Error("weight of the spec should not be changed")
+	}
+	if s.static.stats[configServers[0].URL] != stat {
+		t.Error("statistics of servers should be kept")
+	}
+
+	s.setWeights(nil)
+	if s.static.weightsSum != 2 {
+		t.Errorf("weights sum should be 2, got %d", s.static.weightsSum)
+	}
+}
+
+func TestHealthCheckServers(t *testing.T) {
+	var failing int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+
+	s := newServers(nil, &PoolSpec{
+		LoadBalance: &LoadBalance{Policy: PolicyRoundRobin},
+		Servers:     []*Server{{URL: healthy.URL}, {URL: flaky.URL}},
+		HealthCheck: &healthcheck.Spec{
+			Interval:           "10ms",
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+	}, nil)
+	defer s.close()
+
+	if s.len() != 2 {
+		t.Fatalf("want 2 servers, got %d", s.len())
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 100 && s.len() != 1; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.len() != 1 {
+		t.Fatalf("unhealthy server should be removed")
+	}
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 5; i++ {
+		if server, _, _ := s.next(ctx); server.URL != healthy.URL {
+			t.Fatalf("want %s, got %s", healthy.URL, server.URL)
+		}
+	}
+	if status := s.healthStatus()[flaky.URL]; status == nil || status.Healthy {
+		t.Error("status of the unhealthy server is incorrect")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	for i := 0; i < 100 && s.len() != 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.len() != 2 {
+		t.Fatalf("recovered server should be restored")
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	keyLastEventID = "Last-Event-ID"
+
+	defaultEventType = "message"
+)
+
+var sseHeartbeat = []byte(": heartbeat\n\n")
+
+type (
+	// SSESpec describes the handling of Server-Sent Events responses.
+	SSESpec struct {
+		// AllowedEvents are the event types sent to the client, all types
+		// are allowed if it is empty.
+		AllowedEvents []string `yaml:"allowedEvents" jsonschema:"omitempty,uniqueItems=true"`
+		// DeniedEvents are the event types dropped.
+		DeniedEvents []string `yaml:"deniedEvents" jsonschema:"omitempty,uniqueItems=true"`
+		// Heartbeat sends a comment to the client if there is no event
+		// for the duration, to keep the connection alive.
+		Heartbeat string `yaml:"heartbeat" jsonschema:"omitempty,format=duration"`
+		// Retry tells the client how long to wait before reconnecting.
+		Retry string `yaml:"retry" jsonschema:"omitempty,format=duration"`
+		// MaxReconnects is the max number of times to reconnect the
+		// servers when the stream is broken, with the Last-Event-ID of
+		// the last event received, the client keeps the stream.
+		MaxReconnects int `yaml:"maxReconnects" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// reconnectFunc reconnects the event stream from the event of the ID.
+	reconnectFunc func(lastEventID string) (io.ReadCloser, error)
+
+	// sseBody is the body of an event stream, it reads events from the
+	// servers to filter them, and injects heartbeats when idle.
+	sseBody struct {
+		spec      *SSESpec
+		heartbeat time.Duration
+		reconnect reconnectFunc
+
+		mutex    sync.Mutex
+		upstream io.ReadCloser
+
+		events  chan []byte
+		pending bytes.Buffer
+		done    chan struct{}
+		once    sync.Once
+	}
+)
+
+func isEventStream(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/event-stream")
+}
+
+// newSSEBody creates the body of the event stream, lastEventID is the
+// Last-Event-ID of the client.
+func newSSEBody(spec *SSESpec, upstream io.ReadCloser, lastEventID string, reconnect reconnectFunc) *sseBody {
+	b := &sseBody{
+		spec:      spec,
+		reconnect: reconnect,
+		upstream:  upstream,
+		events:    make(chan []byte),
+		done:      make(chan struct{}),
+	}
+
+	// NOTE: The durations have been checked in validation.
+	if spec.Heartbeat != "" {
+		b.heartbeat, _ = time.ParseDuration(spec.Heartbeat)
+	}
+
+	var retry []byte
+	if spec.Retry != "" {
+		d, _ := time.ParseDuration(spec.Retry)
+		retry = []byte(fmt.Sprintf("retry: %d\n\n", d.Milliseconds()))
+	}
+
+	go b.pump(retry, lastEventID)
+	return b
+}
+
+func (b *sseBody) allowed(eventType string) bool {
+	for _, t := range b.spec.DeniedEvents {
+		if t == eventType {
+			return false
+		}
+	}
+	if len(b.spec.AllowedEvents) == 0 {
+		return true
+	}
+	for _, t := range b.spec.AllowedEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *sseBody) send(event []byte) bool {
+	select {
+	case b.events <- event:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+// pump reads events from the servers, and reconnects the servers if
+// the stream is broken.
+func (b *sseBody) pump(retry []byte, lastEventID string) {
+	defer close(b.events)
+
+	if retry != nil && !b.send(retry) {
+		return
+	}
+
+	for reconnects := 0; ; reconnects++ {
+		b.mutex.Lock()
+		upstream := b.upstream
+		b.mutex.Unlock()
+
+		err := b.readEvents(upstream, &lastEventID)
+		if err == nil || err == io.EOF || reconnects >= b.spec.MaxReconnects {
+			return
+		}
+
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+
+		upstream.Close()
+		upstream, err = b.reconnect(lastEventID)
+		if err != nil {
+			logger.Warnf("reconnect event stream from %q failed: %v", lastEventID, err)
+			return
+		}
+
+		b.mutex.Lock()
+		b.upstream = upstream
+		b.mutex.Unlock()
+	}
+}
+
+// readEvents reads and dispatches the events of the stream, an event is
+// a block of lines ended by a blank line, and the last event ID is
+// updated even the event is dropped. It returns nil if the body is
+// closed by the client.
+func (b *sseBody) readEvents(r io.Reader, lastEventID *string) error {
+	reader := bufio.NewReader(r)
+	event := bytes.NewBuffer(nil)
+	eventType := defaultEventType
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			event.Write(line)
+
+			field := bytes.TrimRight(line, "\r\n")
+			switch {
+			case len(field) == 0:
+				if b.allowed(eventType) && !b.send(append([]byte(nil), event.Bytes()...)) {
+					return nil
+				}
+				event.Reset()
+				eventType = defaultEventType
+			case bytes.HasPrefix(field, []byte("id:")):
+				*lastEventID = strings.TrimPrefix(string(field[3:]), " ")
+			case bytes.HasPrefix(field, []byte("event:")):
+				eventType = strings.TrimPrefix(string(field[6:]), " ")
+			}
+		}
+
+		if err != nil {
+			// NOTE: The incomplete event is discarded as the clients do.
+			return err
+		}
+	}
+}
+
+// Read reads the filtered events, and returns a heartbeat if no event
+// arrives for the heartbeat duration.
+func (b *sseBody) Read(p []byte) (int, error) {
+	for b.pending.Len() == 0 {
+		var timer *time.Timer
+		var heartbeat <-chan time.Time
+		if b.heartbeat > 0 {
+			timer = time.NewTimer(b.heartbeat)
+			heartbeat = timer.C
+		}
+
+		select {
+		case event, ok := <-b.events:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				return 0, io.EOF
+			}
+			b.pending.Write(event)
+		case <-heartbeat:
+			b.pending.Write(sseHeartbeat)
+		}
+	}
+
+	return b.pending.Read(p)
+}
+
+// Close closes the stream from the servers.
+func (b *sseBody) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+	})
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.upstream.Close()
+}
+
+// reconnectSSE sends the request to the servers again with the last
+// event ID.
+func (p *pool) reconnectSSE(ctx context.HTTPContext, client *http.Client, lastEventID string) (io.ReadCloser, error) {
+	server, _, err := p.servers.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := p.newRequest(ctx, server, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.std.Header = req.std.Header.Clone()
+	if lastEventID != "" {
+		req.std.Header.Set(keyLastEventID, lastEventID)
+	}
+
+	resp, err := fnSendRequest(req.std, client)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || !isEventStream(resp.Header.Get(httpheader.KeyContentType)) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s responds status code %d with content type %q", server.URL,
+			resp.StatusCode, resp.Header.Get(httpheader.KeyContentType))
+	}
+	return resp.Body, nil
+}
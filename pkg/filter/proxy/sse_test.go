@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+// brokenReader returns an error after the data is read.
+type brokenReader struct {
+	io.Reader
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		err = fmt.Errorf("connection reset")
+	}
+	return n, err
+}
+
+func (r *brokenReader) Close() error {
+	return nil
+}
+
+func TestSSEBodyFilter(t *testing.T) {
+	const stream = "id: 1\ndata: a\n\n" +
+		"event: ping\ndata: b\n\n" +
+		"event: update\nid: 2\ndata: c\n\n" +
+		"data: incomplete"
+
+	spec := &SSESpec{DeniedEvents: []string{"ping"}, Retry: "3s"}
+	body := newSSEBody(spec, io.NopCloser(strings.NewReader(stream)), "", nil)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "retry: 3000\n\nid: 1\ndata: a\n\nevent: update\nid: 2\ndata: c\n\n"
+	if string(data) != want {
+		t.Errorf("want events %q, got %q", want, data)
+	}
+
+	spec = &SSESpec{AllowedEvents: []string{"ping"}}
+	body = newSSEBody(spec, io.NopCloser(strings.NewReader(stream)), "", nil)
+	defer body.Close()
+	data, _ = io.ReadAll(body)
+	if string(data) != "event: ping\ndata: b\n\n" {
+		t.Errorf("only ping events should be sent, got %q", data)
+	}
+}
+
+func TestSSEBodyHeartbeat(t *testing.T) {
+	r, w := io.Pipe()
+	body := newSSEBody(&SSESpec{Heartbeat: "20ms"}, r, "", nil)
+	defer body.Close()
+
+	p := make([]byte, 1024)
+	n, err := body.Read(p)
+	if err != nil || string(p[:n]) != string(sseHeartbeat) {
+		t.Errorf("heartbeat should be sent, got %q, %v", p[:n], err)
+	}
+
+	go w.Write([]byte("data: a\n\n"))
+	n, _ = body.Read(p)
+	if string(p[:n]) != "data: a\n\n" {
+		t.Errorf("event should be sent, got %q", p[:n])
+	}
+}
+
+func TestSSEBodyReconnect(t *testing.T) {
+	reconnected := []string{}
+	reconnect := func(lastEventID string) (io.ReadCloser, error) {
+		reconnected = append(reconnected, lastEventID)
+		if len(reconnected) == 1 {
+			return &brokenReader{strings.NewReader("id: 3\ndata: c\n\n")}, nil
+		}
+		return io.NopCloser(strings.NewReader("id: 4\ndata: d\n\n")), nil
+	}
+
+	upstream := &brokenReader{strings.NewReader("id: 2\ndata: b\n\ndata: partial")}
+	body := newSSEBody(&SSESpec{MaxReconnects: 2}, upstream, "1", reconnect)
+	defer body.Close()
+
+	data, _ := io.ReadAll(body)
+	if string(data) != "id: 2\ndata: b\n\nid: 3\ndata: c\n\nid: 4\ndata: d\n\n" {
+		t.Errorf("events should be continued after reconnecting, got %q", data)
+	}
+	if len(reconnected) != 2 || reconnected[0] != "2" || reconnected[1] != "3" {
+		t.Errorf("should reconnect with the last event ID, got %v", reconnected)
+	}
+
+	// NOTE: The Last-Event-ID of the client is used if no event is received.
+	reconnected = nil
+	body = newSSEBody(&SSESpec{MaxReconnects: 1}, &brokenReader{strings.NewReader("")}, "1", reconnect)
+	defer body.Close()
+	io.ReadAll(body)
+	if len(reconnected) != 1 || reconnected[0] != "1" {
+		t.Errorf("should reconnect with the Last-Event-ID of the client, got %v", reconnected)
+	}
+}
+
+func TestEventStreamNotBuffered(t *testing.T) {
+	r, w := io.Pipe()
+	server := httptest.NewServer(http.HandlerFunc(func(stdw http.ResponseWriter, stdr *http.Request) {
+		ctx := context.New(stdw, stdr, tracing.NoopTracing, "sse")
+		ctx.Response().Header().Set("Content-Type", "text/event-stream")
+		ctx.Response().SetBody(r)
+		ctx.Finish()
+	}))
+	defer server.Close()
+	defer w.Close()
+
+	go w.Write([]byte("data: a\n\n"))
+
+	// NOTE: Without flushing, even the header is not sent to the client.
+	line := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			line <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+
+	select {
+	case s := <-line:
+		if s != "data: a\n" {
+			t.Errorf("want the first event, got %q", s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("the event should be flushed to the client before the stream ends")
+	}
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+// MainPoolName is the name of the main pool in traffic split, it gets
+// the traffic not assigned to the other pools.
+const MainPoolName = "main"
+
+type (
+	// TrafficSplitSpec describes how the requests are split between the
+	// main pool and the split pools by percentage weights.
+	TrafficSplitSpec struct {
+		Pools []*SplitPoolSpec `yaml:"pools" jsonschema:"required,minItems=1"`
+		// Sticky keeps a client in the pool it was assigned to by a cookie.
+		Sticky *StickySpec `yaml:"sticky,omitempty" jsonschema:"omitempty"`
+		// OverrideHeader is the header whose value is the name of the pool
+		// to use, regardless of the weights.
+		OverrideHeader string `yaml:"overrideHeader" jsonschema:"omitempty"`
+	}
+
+	// SplitPoolSpec describes a pool of traffic split.
+	SplitPoolSpec struct {
+		Name string `yaml:"name" jsonschema:"required,minLength=1"`
+		// Weight is the percentage of the requests sent to the pool.
+		Weight int       `yaml:"weight" jsonschema:"omitempty,minimum=0,maximum=100"`
+		Pool   *PoolSpec `yaml:"pool" jsonschema:"required"`
+	}
+
+	// StickySpec describes the cookie of sticky assignment.
+	StickySpec struct {
+		CookieName string `yaml:"cookieName" jsonschema:"required,minLength=1"`
+		// MaxAge is the lifetime of the cookie, it is a session cookie
+		// if empty.
+		MaxAge string `yaml:"maxAge" jsonschema:"omitempty,format=duration"`
+	}
+
+	// TrafficSplitStatus is the status of traffic split.
+	TrafficSplitStatus struct {
+		// Weights are the effective weights, including the adjustment
+		// by the admin API.
+		Weights  map[string]int         `yaml:"weights"`
+		Requests map[string]uint64      `yaml:"requests"`
+		Pools    map[string]*PoolStatus `yaml:"pools"`
+	}
+
+	trafficSplit struct {
+		spec     *TrafficSplitSpec
+		mainPool *splitPool
+		pools    []*splitPool
+		byName   map[string]*splitPool
+		maxAge   int
+
+		// weights is a splitWeights.
+		weights atomic.Value
+	}
+
+	splitPool struct {
+		index    int
+		name     string
+		pool     *pool
+		requests uint64
+	}
+
+	// splitWeights are the weights of the split pools, the last one is
+	// the weight of the main pool.
+	splitWeights struct {
+		weights []int
+		sum     int
+	}
+)
+
+// Validate validates TrafficSplitSpec.
+func (s TrafficSplitSpec) Validate() error {
+	names := map[string]bool{}
+	sum := 0
+	for _, p := range s.Pools {
+		if p.Name == MainPoolName {
+			return fmt.Errorf("pool name %s is reserved for mainPool", MainPoolName)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("pool %s is duplicated", p.Name)
+		}
+		names[p.Name] = true
+		if p.Pool.Filter != nil {
+			return fmt.Errorf("filter must be empty in pool %s", p.Name)
+		}
+		sum += p.Weight
+	}
+	if sum > 100 {
+		return fmt.Errorf("sum of weights must not be greater than 100, got %d", sum)
+	}
+	return nil
+}
+
+// ParseTrafficSplitWeights parses the traffic split weights stored in the
+// cluster, the weights is a map whose key is the pool name and value is
+// its percentage weight.
+func ParseTrafficSplitWeights(value string) (map[string]int, error) {
+	weights := map[string]int{}
+	if err := yaml.Unmarshal([]byte(value), &weights); err != nil {
+		return nil, err
+	}
+
+	sum := 0
+	for name, weight := range weights {
+		if name == MainPoolName {
+			return nil, fmt.Errorf("weight of %s is the rest of the other pools", MainPoolName)
+		}
+		if weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("weight of %s must be in [0, 100], got %d", name, weight)
+		}
+		sum += weight
+	}
+	if sum > 100 {
+		return nil, fmt.Errorf("sum of weights must not be greater than 100, got %d", sum)
+	}
+	return weights, nil
+}
+
+func newTrafficSplit(super *supervisor.Supervisor, spec *TrafficSplitSpec,
+	mainPool *pool, failureCodes []int, tlsConfig *tls.Config) *trafficSplit {
+
+	ts := &trafficSplit{
+		spec:   spec,
+		byName: map[string]*splitPool{},
+	}
+	if spec.Sticky != nil {
+		ts.maxAge = int(parseDurationOr(spec.Sticky.MaxAge, 0).Seconds())
+	}
+
+	for i, ps := range spec.Pools {
+		sp := &splitPool{
+			index: i,
+			name:  ps.Name,
+			pool: newPool(super, ps.Pool, stringtool.Cat("proxy#split#", ps.Name),
+				true /*writeResponse*/, failureCodes, tlsConfig),
+		}
+		ts.pools = append(ts.pools, sp)
+		ts.byName[sp.name] = sp
+	}
+	ts.mainPool = &splitPool{index: len(spec.Pools), name: MainPoolName, pool: mainPool}
+	ts.byName[MainPoolName] = ts.mainPool
+
+	ts.setWeights(nil)
+	return ts
+}
+
+// setWeights sets the weights adjusted by the admin API, the weights of
+// the pools not in the map are the ones in the spec.
+func (ts *trafficSplit) setWeights(weights map[string]int) {
+	for name := range weights {
+		if _, ok := ts.byName[name]; !ok {
+			logger.Warnf("traffic split pool %s not found", name)
+		}
+	}
+
+	sw := splitWeights{weights: make([]int, len(ts.pools)+1)}
+	for i, ps := range ts.spec.Pools {
+		w, ok := weights[ps.Name]
+		if !ok {
+			w = ps.Weight
+		}
+		sw.weights[i] = w
+		sw.sum += w
+	}
+
+	// NOTE: The main pool gets the rest, if the adjusted weights sum over
+	// 100, the requests are split proportionally among the other pools.
+	if sw.sum < 100 {
+		sw.weights[len(ts.pools)] = 100 - sw.sum
+		sw.sum = 100
+	}
+
+	ts.weights.Store(sw)
+}
+
+func (ts *trafficSplit) loadWeights() splitWeights {
+	return ts.weights.Load().(splitWeights)
+}
+
+func (ts *trafficSplit) weightOf(sp *splitPool) int {
+	return ts.loadWeights().weights[sp.index]
+}
+
+func (ts *trafficSplit) pick() *splitPool {
+	sw := ts.loadWeights()
+	r := rand.Intn(sw.sum)
+	for i, w := range sw.weights {
+		if r < w {
+			if i == len(ts.pools) {
+				return ts.mainPool
+			}
+			return ts.pools[i]
+		}
+		r -= w
+	}
+	return ts.mainPool
+}
+
+// choose chooses the pool of the request, by the override header, the
+// sticky cookie and the weights in order.
+func (ts *trafficSplit) choose(ctx context.HTTPContext) *pool {
+	sp := ts.chooseSplitPool(ctx)
+	atomic.AddUint64(&sp.requests, 1)
+	ctx.AddTag(stringtool.Cat("proxy#trafficSplit: ", sp.name))
+	return sp.pool
+}
+
+func (ts *trafficSplit) chooseSplitPool(ctx context.HTTPContext) *splitPool {
+	r := ctx.Request()
+
+	if ts.spec.OverrideHeader != "" {
+		if sp, ok := ts.byName[r.Header().Get(ts.spec.OverrideHeader)]; ok {
+			return sp
+		}
+	}
+
+	if ts.spec.Sticky == nil {
+		return ts.pick()
+	}
+
+	// NOTE: The client is moved out of a pool once its weight is
+	// adjusted to 0, which is how a canary is rolled back.
+	if cookie, err := r.Cookie(ts.spec.Sticky.CookieName); err == nil {
+		if sp, ok := ts.byName[cookie.Value]; ok && ts.weightOf(sp) > 0 {
+			return sp
+		}
+	}
+
+	sp := ts.pick()
+	ctx.Response().SetCookie(&http.Cookie{
+		Name:     ts.spec.Sticky.CookieName,
+		Value:    sp.name,
+		Path:     "/",
+		MaxAge:   ts.maxAge,
+		HttpOnly: true,
+	})
+	return sp
+}
+
+func (ts *trafficSplit) status() *TrafficSplitStatus {
+	sw := ts.loadWeights()
+	s := &TrafficSplitStatus{
+		Weights:  map[string]int{},
+		Requests: map[string]uint64{},
+		Pools:    map[string]*PoolStatus{},
+	}
+	for _, sp := range ts.pools {
+		s.Weights[sp.name] = sw.weights[sp.index]
+		s.Requests[sp.name] = atomic.LoadUint64(&sp.requests)
+		s.Pools[sp.name] = sp.pool.status()
+	}
+	s.Weights[MainPoolName] = sw.weights[ts.mainPool.index]
+	s.Requests[MainPoolName] = atomic.LoadUint64(&ts.mainPool.requests)
+	return s
+}
+
+func (ts *trafficSplit) close() {
+	for _, sp := range ts.pools {
+		sp.pool.close()
+	}
+}
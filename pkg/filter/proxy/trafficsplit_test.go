@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpfilter"
+)
+
+func newSplitProxy(t *testing.T, mainURL, canaryURL string, weight int) *Proxy {
+	yamlSpec := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: %s
+  loadBalance:
+    policy: roundRobin
+trafficSplit:
+  overrideHeader: X-Canary
+  sticky:
+    cookieName: EG-POOL
+    maxAge: 1h
+  pools:
+  - name: canary
+    weight: %d
+    pool:
+      servers:
+      - url: %s
+      loadBalance:
+        policy: roundRobin
+`, mainURL, weight, canaryURL)
+
+	return httppipelinetest.InitFilter(t, &Proxy{}, yamlSpec).(*Proxy)
+}
+
+func doSplitRequest(p *Proxy, header, cookie string) (string, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	if header != "" {
+		req.Header.Set("X-Canary", header)
+	}
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "EG-POOL", Value: cookie})
+	}
+	_, w := httppipelinetest.Handle(p, req, nil)
+
+	body, _ := io.ReadAll(w.Result().Body)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "EG-POOL" {
+			return string(body), c
+		}
+	}
+	return string(body), nil
+}
+
+func TestTrafficSplit(t *testing.T) {
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	mainServer, canaryServer := newServer("main"), newServer("canary")
+	defer mainServer.Close()
+	defer canaryServer.Close()
+
+	proxy := newSplitProxy(t, mainServer.URL, canaryServer.URL, 0)
+	defer proxy.Close()
+
+	body, cookie := doSplitRequest(proxy, "", "")
+	if body != "main" {
+		t.Errorf("expected main, got %s", body)
+	}
+	if cookie == nil || cookie.Value != "main" || cookie.MaxAge != 3600 {
+		t.Errorf("expected sticky cookie of main, got %v", cookie)
+	}
+
+	// The override header ignores the weights.
+	body, cookie = doSplitRequest(proxy, "canary", "")
+	if body != "canary" || cookie != nil {
+		t.Errorf("expected canary without cookie, got %s %v", body, cookie)
+	}
+
+	// The sticky cookie of a pool without weight is reassigned.
+	body, cookie = doSplitRequest(proxy, "", "canary")
+	if body != "main" || cookie == nil || cookie.Value != "main" {
+		t.Errorf("expected reassigned to main, got %s %v", body, cookie)
+	}
+
+	proxy.trafficSplit.setWeights(map[string]int{"canary": 100})
+	body, cookie = doSplitRequest(proxy, "", "")
+	if body != "canary" || cookie == nil || cookie.Value != "canary" {
+		t.Errorf("expected canary, got %s %v", body, cookie)
+	}
+	body, _ = doSplitRequest(proxy, "", "main")
+	if body != "canary" {
+		t.Errorf("expected canary, got %s", body)
+	}
+
+	proxy.trafficSplit.setWeights(map[string]int{"canary": 50})
+	body, cookie = doSplitRequest(proxy, "", "main")
+	if body != "main" || cookie != nil {
+		t.Errorf("expected sticky main, got %s %v", body, cookie)
+	}
+
+	status := proxy.Status().(*Status).TrafficSplit
+	if status.Weights["canary"] != 50 || status.Weights[MainPoolName] != 50 {
+		t.Errorf("unexpected weights %v", status.Weights)
+	}
+	if status.Requests["canary"] != 3 || status.Requests[MainPoolName] != 3 {
+		t.Errorf("unexpected requests %v", status.Requests)
+	}
+	if status.Pools["canary"] == nil {
+		t.Errorf("status of canary pool is missing")
+	}
+}
+
+func TestTrafficSplitPick(t *testing.T) {
+	ts := &trafficSplit{
+		spec: &TrafficSplitSpec{Pools: []*SplitPoolSpec{
+			{Name: "a", Weight: 20},
+			{Name: "b", Weight: 30},
+		}},
+		byName: map[string]*splitPool{},
+	}
+	for i, ps := range ts.spec.Pools {
+		sp := &splitPool{index: i, name: ps.Name}
+		ts.pools = append(ts.pools, sp)
+		ts.byName[sp.name] = sp
+	}
+	ts.mainPool = &splitPool{index: 2, name: MainPoolName}
+	ts.byName[MainPoolName] = ts.mainPool
+	ts.setWeights(nil)
+
+	count := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		count[ts.pick().name]++
+	}
+	for name, expected := range map[string]int{"a": 2000, "b": 3000, MainPoolName: 5000} {
+		if count[name] < expected-500 || count[name] > expected+500 {
+			t.Errorf("expected about %d requests to %s, got %d", expected, name, count[name])
+		}
+	}
+
+	// The adjusted weights over 100 leave nothing to the main pool.
+	ts.setWeights(map[string]int{"a": 90})
+	sw := ts.loadWeights()
+	if sw.sum != 120 || sw.weights[2] != 0 {
+		t.Errorf("unexpected weights %v", sw)
+	}
+}
+
+func TestTrafficSplitWeights(t *testing.T) {
+	weights, err := ParseTrafficSplitWeights("canary: 10\nblue: 90\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if weights["canary"] != 10 || weights["blue"] != 90 {
+		t.Errorf("unexpected weights %v", weights)
+	}
+
+	for _, value := range []string{
+		"canary: 101",
+		"canary: -1",
+		"canary: 60\nblue: 50",
+		"main: 10",
+		"[1, 2]",
+	} {
+		if _, err := ParseTrafficSplitWeights(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
+
+func TestTrafficSplitSpec(t *testing.T) {
+	pool := &PoolSpec{}
+	for _, spec := range []TrafficSplitSpec{
+		{Pools: []*SplitPoolSpec{{Name: MainPoolName, Pool: pool}}},
+		{Pools: []*SplitPoolSpec{{Name: "a", Pool: pool}, {Name: "a", Pool: pool}}},
+		{Pools: []*SplitPoolSpec{{Name: "a", Weight: 60, Pool: pool}, {Name: "b", Weight: 50, Pool: pool}}},
+		{Pools: []*SplitPoolSpec{{Name: "a", Pool: &PoolSpec{Filter: &httpfilter.Spec{}}}}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expected error for %v", spec.Pools)
+		}
+	}
+
+	spec := TrafficSplitSpec{Pools: []*SplitPoolSpec{{Name: "a", Weight: 60, Pool: pool}, {Name: "b", Weight: 40, Pool: pool}}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultMaxIdleConns        = 10240
+	defaultMaxIdleConnsPerHost = 512
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 60 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+)
+
+type (
+	// ConnectionPoolSpec describes the connection pool shared by all
+	// pools of the Proxy.
+	ConnectionPoolSpec struct {
+		MaxIdleConns        int    `yaml:"maxIdleConns" jsonschema:"omitempty,minimum=0"`
+		MaxIdleConnsPerHost int    `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty,minimum=0"`
+		MaxConnsPerHost     int    `yaml:"maxConnsPerHost" jsonschema:"omitempty,minimum=0"`
+		IdleConnTimeout     string `yaml:"idleConnTimeout" jsonschema:"omitempty,format=duration"`
+		KeepAlive           string `yaml:"keepAlive" jsonschema:"omitempty,format=duration"`
+		DialTimeout         string `yaml:"dialTimeout" jsonschema:"omitempty,format=duration"`
+		DisableKeepAlives   bool   `yaml:"disableKeepAlives" jsonschema:"omitempty"`
+
+		HTTP2 *HTTP2Spec `yaml:"http2,omitempty" jsonschema:"omitempty"`
+	}
+
+	// HTTP2Spec describes the HTTP/2 options of the upstream connections.
+	HTTP2Spec struct {
+		// H2C makes requests to servers of http scheme by HTTP/2 over
+		// cleartext TCP, the servers must support HTTP/2 with prior knowledge.
+		H2C bool `yaml:"h2c" jsonschema:"omitempty"`
+		// StrictMaxConcurrentStreams respects the concurrent streams limit
+		// of the server globally instead of opening new connections.
+		StrictMaxConcurrentStreams bool   `yaml:"strictMaxConcurrentStreams" jsonschema:"omitempty"`
+		ReadIdleTimeout            string `yaml:"readIdleTimeout" jsonschema:"omitempty,format=duration"`
+		PingTimeout                string `yaml:"pingTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// ConnectionPoolStatus is the status of the connection pool.
+	ConnectionPoolStatus struct {
+		// Open is the number of connections currently open, per host.
+		Open map[string]int64 `yaml:"open"`
+		// Dialed is the total number of connections dialed.
+		Dialed uint64 `yaml:"dialed"`
+		// MaxIdleConnsPerHost is the effective idle connection limit per host.
+		MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+		// MaxConnsPerHost is the effective connection limit per host, 0 means no limit.
+		MaxConnsPerHost int `yaml:"maxConnsPerHost"`
+		// HTTP2Requests is the total number of requests served by HTTP/2.
+		HTTP2Requests uint64 `yaml:"http2Requests"`
+	}
+
+	connPool struct {
+		spec      *ConnectionPoolSpec
+		transport *http.Transport
+		// h2cTransport is for servers of http scheme when h2c is enabled.
+		h2cTransport *http2.Transport
+
+		dialed        uint64
+		http2Requests uint64
+
+		mutex sync.Mutex
+		open  map[string]int64
+	}
+
+	trackedConn struct {
+		net.Conn
+		once sync.Once
+		pool *connPool
+		addr string
+	}
+)
+
+func parseDurationOr(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return d
+	}
+	return v
+}
+
+func newConnPool(spec *ConnectionPoolSpec, tlsConfig *tls.Config) *connPool {
+	if spec == nil {
+		spec = &ConnectionPoolSpec{}
+	}
+
+	cp := &connPool{
+		spec: spec,
+		open: make(map[string]int64),
+	}
+
+	maxIdleConns := spec.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	maxIdleConnsPerHost := spec.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   parseDurationOr(spec.DialTimeout, defaultDialTimeout),
+		KeepAlive: parseDurationOr(spec.KeepAlive, defaultKeepAlive),
+		DualStack: true,
+	}
+
+	cp.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx stdcontext.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return cp.track(conn, addr), nil
+		},
+		TLSClientConfig:    tlsConfig,
+		DisableCompression: false,
+		DisableKeepAlives:  spec.DisableKeepAlives,
+		// NOTE: The large number of Idle Connections can
+		// reduce overhead of building connections.
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxConnsPerHost:       spec.MaxConnsPerHost,
+		IdleConnTimeout:       parseDurationOr(spec.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if spec.HTTP2 != nil {
+		cp.configureHTTP2(spec.HTTP2, dialer)
+	}
+
+	return cp
+}
+
+func (cp *connPool) configureHTTP2(spec *HTTP2Spec, dialer *net.Dialer) {
+	readIdleTimeout := parseDurationOr(spec.ReadIdleTimeout, 0)
+	pingTimeout := parseDurationOr(spec.PingTimeout, 0)
+
+	t2, err := http2.ConfigureTransports(cp.transport)
+	if err != nil {
+		logger.Errorf("BUG: configure http2 transport failed: %v", err)
+	} else {
+		t2.StrictMaxConcurrentStreams = spec.StrictMaxConcurrentStreams
+		t2.ReadIdleTimeout = readIdleTimeout
+		t2.PingTimeout = pingTimeout
+	}
+
+	if !spec.H2C {
+		return
+	}
+
+	cp.h2cTransport = &http2.Transport{
+		// NOTE: Dial plain TCP connections to make HTTP/2 over cleartext.
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dialer.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return cp.track(conn, addr), nil
+		},
+		StrictMaxConcurrentStreams: spec.StrictMaxConcurrentStreams,
+		ReadIdleTimeout:            readIdleTimeout,
+		PingTimeout:                pingTimeout,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (cp *connPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	if cp.h2cTransport != nil && req.URL.Scheme == "http" {
+		resp, err = cp.h2cTransport.RoundTrip(req)
+	} else {
+		resp, err = cp.transport.RoundTrip(req)
+	}
+
+	if err == nil && resp.ProtoMajor == 2 {
+		atomic.AddUint64(&cp.http2Requests, 1)
+	}
+	return resp, err
+}
+
+func (cp *connPool) track(conn net.Conn, addr string) net.Conn {
+	atomic.AddUint64(&cp.dialed, 1)
+
+	cp.mutex.Lock()
+	cp.open[addr]++
+	cp.mutex.Unlock()
+
+	return &trackedConn{Conn: conn, pool: cp, addr: addr}
+}
+
+func (cp *connPool) release(addr string) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.open[addr]--
+	if cp.open[addr] <= 0 {
+		delete(cp.open, addr)
+	}
+}
+
+func (cp *connPool) status() *ConnectionPoolStatus {
+	s := &ConnectionPoolStatus{
+		Open:                make(map[string]int64),
+		Dialed:              atomic.LoadUint64(&cp.dialed),
+		MaxIdleConnsPerHost: cp.transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cp.transport.MaxConnsPerHost,
+		HTTP2Requests:       atomic.LoadUint64(&cp.http2Requests),
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	for k, v := range cp.open {
+		s.Open[k] = v
+	}
+
+	return s
+}
+
+func (cp *connPool) close() {
+	cp.transport.CloseIdleConnections()
+	if cp.h2cTransport != nil {
+		cp.h2cTransport.CloseIdleConnections()
+	}
+}
+
+// Close closes the underlying connection and updates the statistics
+// of the connection pool, it is safe to call Close more than once.
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.pool.release(c.addr)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestConnPoolSpec(t *testing.T) {
+	cp := newConnPool(nil, nil)
+	if cp.transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("max idle conns per host should be %d", defaultMaxIdleConnsPerHost)
+	}
+	if cp.transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("idle conn timeout should be %v", defaultIdleConnTimeout)
+	}
+
+	cp = newConnPool(&ConnectionPoolSpec{
+		MaxIdleConnsPerHost: 8,
+		MaxConnsPerHost:     16,
+		IdleConnTimeout:     "5s",
+		DisableKeepAlives:   true,
+	}, nil)
+	if cp.transport.MaxIdleConnsPerHost != 8 {
+		t.Error("max idle conns per host should be 8")
+	}
+	if cp.transport.MaxConnsPerHost != 16 {
+		t.Error("max conns per host should be 16")
+	}
+	if cp.transport.IdleConnTimeout != 5*time.Second {
+		t.Error("idle conn timeout should be 5s")
+	}
+	if !cp.transport.DisableKeepAlives {
+		t.Error("keep-alives should be disabled")
+	}
+}
+
+func TestConnPoolStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	cp := newConnPool(&ConnectionPoolSpec{}, nil)
+	client := &http.Client{Transport: cp.transport}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	s := cp.status()
+	if s.Dialed != 1 {
+		t.Errorf("connection should be reused, dialed %d", s.Dialed)
+	}
+	if len(s.Open) != 1 {
+		t.Errorf("there should be open connections to 1 host, got %d", len(s.Open))
+	}
+
+	cp.close()
+	// NOTE: Closing idle connections is asynchronous to the transport.
+	for i := 0; i < 100 && len(cp.status().Open) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(cp.status().Open) != 0 {
+		t.Error("all connections should be closed")
+	}
+}
+
+func TestConnPoolH2C(t *testing.T) {
+	h2s := &http2.Server{}
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), h2s))
+	defer server.Close()
+
+	cp := newConnPool(&ConnectionPoolSpec{HTTP2: &HTTP2Spec{H2C: true}}, nil)
+	defer cp.close()
+	client := &http.Client{Transport: cp}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("request should be served by HTTP/2, got %s", body)
+		}
+	}
+
+	s := cp.status()
+	if s.HTTP2Requests != 3 {
+		t.Errorf("there should be 3 HTTP/2 requests, got %d", s.HTTP2Requests)
+	}
+	if s.Dialed != 1 {
+		t.Errorf("streams should share one connection, dialed %d", s.Dialed)
+	}
+}
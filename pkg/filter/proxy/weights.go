@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// ParseWeights parses the server weights stored in the cluster, the
+// weights is a map whose key is the server URL and value is its weight.
+func ParseWeights(value string) (map[string]int, error) {
+	weights := map[string]int{}
+	if err := yaml.Unmarshal([]byte(value), &weights); err != nil {
+		return nil, err
+	}
+	return weights, nil
+}
+
+func (b *Proxy) pools() []*pool {
+	pools := []*pool{b.mainPool}
+	pools = append(pools, b.candidatePools...)
+	if b.mirrorPool != nil {
+		pools = append(pools, b.mirrorPool)
+	}
+	if b.trafficSplit != nil {
+		for _, sp := range b.trafficSplit.pools {
+			pools = append(pools, sp.pool)
+		}
+	}
+	return pools
+}
+
+func (b *Proxy) setWeights(weights map[string]int) {
+	for _, p := range b.pools() {
+		p.servers.setWeights(weights)
+	}
+}
+
+// watchWeights watches the server weights adjusted by the admin API.
+func (b *Proxy) watchWeights() {
+	c := b.filterSpec.Super().Cluster()
+	key := c.Layout().ProxyWeightsKey(b.filterSpec.Pipeline(), b.filterSpec.Name())
+
+	b.watch(key, "proxy weights", func(value *string) {
+		if value == nil {
+			b.setWeights(nil)
+			return
+		}
+		weights, err := ParseWeights(*value)
+		if err != nil {
+			logger.Errorf("failed to parse proxy weights %s: %v", key, err)
+			return
+		}
+		b.setWeights(weights)
+	})
+}
+
+// watchTrafficSplit watches the traffic split weights adjusted by the
+// admin API.
+func (b *Proxy) watchTrafficSplit() {
+	c := b.filterSpec.Super().Cluster()
+	key := c.Layout().ProxyTrafficSplitKey(b.filterSpec.Pipeline(), b.filterSpec.Name())
+
+	b.watch(key, "proxy traffic split", func(value *string) {
+		if value == nil {
+			b.trafficSplit.setWeights(nil)
+			return
+		}
+		weights, err := ParseTrafficSplitWeights(*value)
+		if err != nil {
+			logger.Errorf("failed to parse proxy traffic split %s: %v", key, err)
+			return
+		}
+		b.trafficSplit.setWeights(weights)
+	})
+}
+
+// watch calls fn with the value of key in the cluster whenever it is
+// changed, until the Proxy is closed.
+func (b *Proxy) watch(key string, what string, fn func(value *string)) {
+	var (
+		ch     <-chan *string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	c := b.filterSpec.Super().Cluster()
+	for {
+		syncer, err = c.Syncer(time.Minute)
+		if err == nil {
+			ch, err = syncer.Sync(key)
+			if err == nil {
+				break
+			}
+		}
+		logger.Errorf("failed to watch %s: %v", what, err)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-b.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case value := <-ch:
+			fn(value)
+		case <-b.done:
+			return
+		}
+	}
+}
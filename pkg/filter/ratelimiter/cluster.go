@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/pkg/logger"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// ScopeLocal limits requests on each member of the cluster.
+	ScopeLocal = "local"
+	// ScopeCluster limits requests on the whole cluster.
+	ScopeCluster = "cluster"
+
+	// leaseRatio is the ratio of tokens of a period a member leases
+	// from the cluster at one time.
+	leaseRatio = 0.05
+)
+
+type (
+	// clusterLimiter limits requests on the whole cluster, members
+	// lease tokens of the current period from a counter stored in the
+	// cluster in batch, so that not every request needs to access the
+	// cluster. The next batch is leased in background before the tokens
+	// run out, and the cluster is never accessed with the lock held.
+	clusterLimiter struct {
+		key    string
+		limit  int
+		period time.Duration
+		batch  int
+		stm    func(apply func(concurrency.STM) error) error
+		// fallback limits requests locally when the cluster is not
+		// available, it's better than rejecting all requests.
+		fallback librl.Limiter
+
+		mutex     sync.Mutex
+		window    int64
+		tokens    int
+		exhausted bool
+		// leasing is closed when the lease in flight finishes, it is
+		// nil if there is no lease in flight.
+		leasing chan struct{}
+		// requests are limited locally before retryAt after a failed
+		// lease, the backoff doubles on each failure.
+		retryAt time.Time
+		backoff time.Duration
+		closed  bool
+	}
+)
+
+const (
+	minLeaseBackoff = time.Second
+	maxLeaseBackoff = 30 * time.Second
+)
+
+// for unit testing cases to mock 'time.Now' only
+var nowFunc = time.Now
+
+func newClusterLimiter(key string, limit int, period time.Duration,
+	stm func(apply func(concurrency.STM) error) error) *clusterLimiter {
+	batch := int(float64(limit) * leaseRatio)
+	if batch < 1 {
+		batch = 1
+	}
+
+	return &clusterLimiter{
+		key:      key,
+		limit:    limit,
+		period:   period,
+		batch:    batch,
+		stm:      stm,
+		fallback: librl.NewTokenBucket(limit, period, limit),
+		window:   -1,
+	}
+}
+
+// AcquirePermission acquires a permission from the cluster limiter.
+func (cl *clusterLimiter) AcquirePermission() (bool, time.Duration) {
+	cl.mutex.Lock()
+	for {
+		now := nowFunc()
+		window := now.UnixNano() / int64(cl.period)
+		if window != cl.window {
+			cl.window = window
+			cl.tokens = 0
+			cl.exhausted = false
+		}
+
+		// NOTE: A closed limiter may still serve the requests in flight
+		// of the previous generation.
+		if cl.closed || now.Before(cl.retryAt) {
+			cl.mutex.Unlock()
+			return cl.fallback.AcquirePermission()
+		}
+
+		if cl.tokens > 0 {
+			cl.tokens--
+			if cl.tokens <= cl.batch/2 && !cl.exhausted {
+				cl.startLease(window)
+			}
+			cl.mutex.Unlock()
+			return true, 0
+		}
+
+		if cl.exhausted {
+			cl.mutex.Unlock()
+			next := time.Unix(0, (window+1)*int64(cl.period))
+			return false, next.Sub(now)
+		}
+
+		// NOTE: Wait for the lease without the lock, and check again
+		// as the window may have changed.
+		leasing := cl.startLease(window)
+		cl.mutex.Unlock()
+		<-leasing
+		cl.mutex.Lock()
+	}
+}
+
+// startLease starts to lease tokens of the window in background if there
+// is no lease in flight, it must be called with the lock held.
+func (cl *clusterLimiter) startLease(window int64) chan struct{} {
+	if cl.leasing != nil {
+		return cl.leasing
+	}
+
+	done := make(chan struct{})
+	cl.leasing = done
+	go func() {
+		tokens, err := cl.lease(window)
+
+		cl.mutex.Lock()
+		defer cl.mutex.Unlock()
+		defer close(done)
+		cl.leasing = nil
+
+		if err != nil {
+			cl.backoff *= 2
+			if cl.backoff < minLeaseBackoff {
+				cl.backoff = minLeaseBackoff
+			}
+			if cl.backoff > maxLeaseBackoff {
+				cl.backoff = maxLeaseBackoff
+			}
+			cl.retryAt = nowFunc().Add(cl.backoff)
+			logger.Errorf("lease tokens of %s from cluster failed, limit requests locally in %s: %v",
+				cl.key, cl.backoff, err)
+			return
+		}
+
+		cl.backoff = 0
+		// NOTE: The tokens of a passed window are useless.
+		if window != cl.window {
+			return
+		}
+		cl.tokens += tokens
+		cl.exhausted = tokens == 0
+	}()
+
+	return done
+}
+
+// close stops leasing tokens from the cluster. The counter is shared by
+// all members, so it is never deleted by a single member, and it counts
+// nothing once the window passes.
+func (cl *clusterLimiter) close() {
+	cl.mutex.Lock()
+	cl.closed = true
+	cl.mutex.Unlock()
+}
+
+// lease leases tokens of the window from the cluster, the value of the
+// counter is in format of 'window count'.
+func (cl *clusterLimiter) lease(window int64) (int, error) {
+	granted := 0
+	err := cl.stm(func(s concurrency.STM) error {
+		granted = 0
+
+		var w int64
+		var count int
+		if _, err := fmt.Sscanf(s.Get(cl.key), "%d %d", &w, &count); err != nil || w != window {
+			count = 0
+		}
+
+		left := cl.limit - count
+		if left <= 0 {
+			return nil
+		}
+
+		granted = cl.batch
+		if granted > left {
+			granted = left
+		}
+		s.Put(cl.key, fmt.Sprintf("%d %d", window, count+granted))
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return granted, nil
+}
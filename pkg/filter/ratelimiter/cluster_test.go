@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// mockedSTM embeds concurrency.STM for its unexported methods, which
+// are not called in the test cases.
+type mockedSTM struct {
+	concurrency.STM
+	mutex sync.Mutex
+	kv    map[string]string
+}
+
+// apply applies fn exclusively as a transaction.
+func (s *mockedSTM) apply(fn func(concurrency.STM) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fn(s)
+}
+
+// exists reports whether the key exists out of transactions.
+func (s *mockedSTM) exists(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.kv[key]
+	return exists
+}
+
+func (s *mockedSTM) Get(key ...string) string {
+	return s.kv[key[0]]
+}
+
+func (s *mockedSTM) Put(key, val string, opts ...clientv3.OpOption) {
+	s.kv[key] = val
+}
+
+func (s *mockedSTM) Rev(key string) int64 {
+	return 0
+}
+
+func (s *mockedSTM) Del(key string) {
+	delete(s.kv, key)
+}
+
+func TestClusterLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	stm := &mockedSTM{kv: map[string]string{}}
+
+	// two members share the counter in the cluster.
+	cl1 := newClusterLimiter("key", 40, time.Second, stm.apply)
+	cl2 := newClusterLimiter("key", 40, time.Second, stm.apply)
+
+	permitted := 0
+	for i := 0; i < 100; i++ {
+		if ok, _ := cl1.AcquirePermission(); ok {
+			permitted++
+		}
+		if ok, _ := cl2.AcquirePermission(); ok {
+			permitted++
+		}
+	}
+	if permitted != 40 {
+		t.Errorf("40 requests should be permitted in the cluster, got %d", permitted)
+	}
+
+	ok, d := cl1.AcquirePermission()
+	if ok || d != time.Second {
+		t.Errorf("request should be rejected until the next period, got %v, %v", ok, d)
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := cl1.AcquirePermission(); !ok {
+		t.Error("request should be permitted in the next period")
+	}
+}
+
+func TestClusterLimiterDegraded(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	var mutex sync.Mutex
+	calls := 0
+	apply := func(fn func(concurrency.STM) error) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return fmt.Errorf("cluster is not available")
+	}
+
+	cl := newClusterLimiter("key", 10, time.Hour, apply)
+	permitted := 0
+	for i := 0; i < 20; i++ {
+		if ok, _ := cl.AcquirePermission(); ok {
+			permitted++
+		}
+	}
+	if permitted != 10 {
+		t.Errorf("requests should be limited locally, got %d permitted", permitted)
+	}
+
+	// the cluster is not accessed again before the backoff expires.
+	if calls != 1 {
+		t.Errorf("cluster should be accessed once in the backoff, got %d", calls)
+	}
+
+	now = now.Add(minLeaseBackoff)
+	cl.AcquirePermission()
+	if calls != 2 {
+		t.Errorf("cluster should be accessed again after the backoff, got %d", calls)
+	}
+	if cl.backoff != 2*minLeaseBackoff {
+		t.Errorf("backoff should be doubled, got %s", cl.backoff)
+	}
+}
+
+func TestClusterLimiterClose(t *testing.T) {
+	stm := &mockedSTM{kv: map[string]string{}}
+	cl1 := newClusterLimiter("key", 10, time.Hour, stm.apply)
+	cl2 := newClusterLimiter("key", 10, time.Hour, stm.apply)
+	if ok, _ := cl1.AcquirePermission(); !ok {
+		t.Error("request should be permitted")
+	}
+
+	cl1.close()
+	if !stm.exists("key") {
+		t.Error("counter shared by members should not be deleted after close")
+	}
+
+	// the closed limiter limits requests locally without accessing the cluster.
+	stm.mutex.Lock()
+	counter := stm.kv["key"]
+	stm.mutex.Unlock()
+	if ok, _ := cl1.AcquirePermission(); !ok {
+		t.Error("request should be permitted by the closed limiter")
+	}
+	if ok, _ := cl2.AcquirePermission(); !ok {
+		t.Error("request should be permitted")
+	}
+	stm.mutex.Lock()
+	defer stm.mutex.Unlock()
+	if stm.kv["key"] == counter {
+		t.Error("counter should be updated by the other member")
+	}
+}
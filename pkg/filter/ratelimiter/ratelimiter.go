@@ -19,13 +19,17 @@ package ratelimiter
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/expr"
 	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
 	"github.com/megaease/easegress/pkg/util/urlrule"
 )
@@ -34,6 +38,28 @@ const (
 	// Kind is the kind of RateLimiter.
 	Kind              = "RateLimiter"
 	resultRateLimited = "rateLimited"
+
+	// AlgorithmTokenBucket is the token bucket algorithm.
+	AlgorithmTokenBucket = "tokenBucket"
+	// AlgorithmLeakyBucket is the leaky bucket algorithm.
+	AlgorithmLeakyBucket = "leakyBucket"
+
+	// KeyByIP creates a rate limiter for each client IP.
+	KeyByIP = "ip"
+	// KeyByHeader creates a rate limiter for each value of a header.
+	KeyByHeader = "header"
+	// KeyByExpression creates a rate limiter for each rendering result of
+	// a template.
+	KeyByExpression = "expression"
+	// KeyByExpr creates a rate limiter for each value of an expression of
+	// the expression language.
+	KeyByExpr = "expr"
+
+	// maxKeys is the maximum number of keyed rate limiters of a URL rule.
+	maxKeys = 10240
+	// keyIdleTimeout is the duration after which an idle keyed rate
+	// limiter could be evicted.
+	keyIdleTimeout = time.Minute
 )
 
 var results = []string{resultRateLimited}
@@ -49,13 +75,36 @@ type (
 		TimeoutDuration    string `yaml:"timeoutDuration" jsonschema:"omitempty,format=duration"`
 		LimitRefreshPeriod string `yaml:"limitRefreshPeriod" jsonschema:"omitempty,format=duration"`
 		LimitForPeriod     int    `yaml:"limitForPeriod" jsonschema:"omitempty,minimum=1"`
+		Algorithm          string `yaml:"algorithm,omitempty" jsonschema:"omitempty,enum=tokenBucket,enum=leakyBucket"`
+		Burst              int    `yaml:"burst,omitempty" jsonschema:"omitempty,minimum=1"`
+		RejectedStatusCode int    `yaml:"rejectedStatusCode,omitempty" jsonschema:"omitempty,format=httpcode"`
+		Scope              string `yaml:"scope,omitempty" jsonschema:"omitempty,enum=local,enum=cluster"`
+	}
+
+	// KeySpec defines how to key requests, a standalone rate limiter is
+	// created for each key.
+	KeySpec struct {
+		Type       string `yaml:"type" jsonschema:"required,enum=ip,enum=header,enum=expression,enum=expr"`
+		Header     string `yaml:"header" jsonschema:"omitempty"`
+		Expression string `yaml:"expression" jsonschema:"omitempty"`
+		Expr       string `yaml:"expr" jsonschema:"omitempty,format=expr"`
+		expr       *expr.Expr
 	}
 
 	// URLRule defines the rate limiter rule for a URL pattern
 	URLRule struct {
 		urlrule.URLRule `yaml:",inline"`
+		KeyBy           *KeySpec `yaml:"keyBy,omitempty" jsonschema:"omitempty"`
 		policy          *Policy
-		rl              *librl.RateLimiter
+		rl              librl.Limiter
+
+		mutex    sync.Mutex
+		limiters map[string]*keyedLimiter
+	}
+
+	keyedLimiter struct {
+		rl       librl.Limiter
+		lastUsed time.Time
 	}
 
 	// Spec is the configuration of a rate limiter
@@ -74,6 +123,16 @@ type (
 
 // Validate implements custom validation for Spec
 func (spec Spec) Validate() error {
+	for _, p := range spec.Policies {
+		if p.Scope != ScopeCluster {
+			continue
+		}
+		// NOTE: The cluster is accessed at least once per period.
+		if d, _ := time.ParseDuration(p.LimitRefreshPeriod); d < time.Second {
+			return fmt.Errorf("policy '%s' of cluster scope requires a limitRefreshPeriod of at least 1s", p.Name)
+		}
+	}
+
 URLLoop:
 	for _, u := range spec.URLs {
 		name := u.PolicyRef
@@ -82,9 +141,13 @@ URLLoop:
 		}
 
 		for _, p := range spec.Policies {
-			if p.Name == name {
-				continue URLLoop
+			if p.Name != name {
+				continue
+			}
+			if p.Scope == ScopeCluster && u.KeyBy != nil {
+				return fmt.Errorf("policy '%s' of cluster scope can't be used with keyBy", name)
 			}
+			continue URLLoop
 		}
 
 		return fmt.Errorf("policy '%s' is not defined", name)
@@ -93,7 +156,41 @@ URLLoop:
 	return nil
 }
 
+// Validate implements custom validation for KeySpec
+func (spec KeySpec) Validate() error {
+	if spec.Type == KeyByHeader && spec.Header == "" {
+		return fmt.Errorf("header is required when key by header")
+	}
+	if spec.Type == KeyByExpression && spec.Expression == "" {
+		return fmt.Errorf("expression is required when key by expression")
+	}
+	if spec.Type == KeyByExpr && spec.Expr == "" {
+		return fmt.Errorf("expr is required when key by expr")
+	}
+	return nil
+}
+
+// equal returns whether the two key specs are equal, nil key specs are
+// regarded as equal.
+func (spec *KeySpec) equal(other *KeySpec) bool {
+	if spec == nil || other == nil {
+		return spec == other
+	}
+	return spec.Type == other.Type && spec.Header == other.Header &&
+		spec.Expression == other.Expression && spec.Expr == other.Expr
+}
+
 func (url *URLRule) createRateLimiter() {
+	url.rl = url.newLimiter()
+	url.limiters = make(map[string]*keyedLimiter)
+	if url.KeyBy != nil && url.KeyBy.Type == KeyByExpr {
+		// NOTE: The expression has been checked by the format validation
+		// of the spec.
+		url.KeyBy.expr = expr.MustCompile(url.KeyBy.Expr)
+	}
+}
+
+func (url *URLRule) newLimiter() librl.Limiter {
 	policy := librl.Policy{
 		LimitForPeriod: url.policy.LimitForPeriod,
 	}
@@ -114,7 +211,76 @@ func (url *URLRule) createRateLimiter() {
 		policy.LimitRefreshPeriod = 10 * time.Millisecond
 	}
 
-	url.rl = librl.New(&policy)
+	switch url.policy.Algorithm {
+	case AlgorithmTokenBucket:
+		return librl.NewTokenBucket(policy.LimitForPeriod, policy.LimitRefreshPeriod, url.policy.Burst)
+	case AlgorithmLeakyBucket:
+		return librl.NewLeakyBucket(policy.LimitForPeriod, policy.LimitRefreshPeriod, url.policy.Burst)
+	}
+
+	return librl.New(&policy)
+}
+
+func (url *URLRule) key(ctx context.HTTPContext) string {
+	switch url.KeyBy.Type {
+	case KeyByIP:
+		return ctx.Request().RealIP()
+	case KeyByHeader:
+		return ctx.Request().Header().Get(url.KeyBy.Header)
+	case KeyByExpression:
+		key, err := ctx.Template().Render(url.KeyBy.Expression)
+		if err != nil {
+			logger.Warnf("render expression %s failed: %v", url.KeyBy.Expression, err)
+			return ""
+		}
+		return key
+	case KeyByExpr:
+		key, err := url.KeyBy.expr.EvalString(ctx.Request(), nil)
+		if err != nil {
+			logger.Warnf("evaluate expr %s failed: %v", url.KeyBy.Expr, err)
+			return ""
+		}
+		return key
+	}
+	return ""
+}
+
+// limiter returns the rate limiter for the request.
+func (url *URLRule) limiter(ctx context.HTTPContext) librl.Limiter {
+	if url.KeyBy == nil {
+		return url.rl
+	}
+
+	key := url.key(ctx)
+	if key == "" {
+		return url.rl
+	}
+
+	url.mutex.Lock()
+	defer url.mutex.Unlock()
+
+	now := time.Now()
+	if kl := url.limiters[key]; kl != nil {
+		kl.lastUsed = now
+		return kl.rl
+	}
+
+	if len(url.limiters) >= maxKeys {
+		for k, kl := range url.limiters {
+			if now.Sub(kl.lastUsed) > keyIdleTimeout {
+				delete(url.limiters, k)
+			}
+		}
+	}
+	// NOTE: Requests of new keys share the rate limiter of the URL rule
+	// if there are too many active keys.
+	if len(url.limiters) >= maxKeys {
+		return url.rl
+	}
+
+	kl := &keyedLimiter{rl: url.newLimiter(), lastUsed: now}
+	url.limiters[key] = kl
+	return kl.rl
 }
 
 // Kind returns the kind of RateLimiter.
@@ -138,7 +304,12 @@ func (rl *RateLimiter) Results() []string {
 }
 
 func (rl *RateLimiter) setStateListenerForURL(u *URLRule) {
-	u.rl.SetStateListener(func(event *librl.Event) {
+	r, ok := u.rl.(*librl.RateLimiter)
+	if !ok {
+		return
+	}
+
+	r.SetStateListener(func(event *librl.Event) {
 		logger.Infof("state of rate limiter '%s' on URL(%s) transited to %s at %d",
 			rl.filterSpec.Name(),
 			u.ID(),
@@ -162,13 +333,42 @@ func (rl *RateLimiter) bindPolicyToURL(u *URLRule) {
 	}
 }
 
-func (rl *RateLimiter) createRateLimiterForURL(u *URLRule) {
+func (rl *RateLimiter) createRateLimiterForURL(u *URLRule, index int) {
 	u.Init()
 	rl.bindPolicyToURL(u)
 	u.createRateLimiter()
+	rl.setClusterLimiterForURL(u, index)
 	rl.setStateListenerForURL(u)
 }
 
+// setClusterLimiterForURL replaces the rate limiter of the URL rule by a
+// cluster limiter if the policy is of cluster scope. The index of the URL
+// rule is a part of the key of the token counter in the cluster.
+func (rl *RateLimiter) setClusterLimiterForURL(u *URLRule, index int) {
+	if u.policy.Scope != ScopeCluster {
+		return
+	}
+
+	super := rl.filterSpec.Super()
+	if super == nil || super.Cluster() == nil {
+		logger.Warnf("rate limiter %s: cluster is not available, limit requests locally", rl.filterSpec.Name())
+		return
+	}
+
+	limit := u.policy.LimitForPeriod
+	if limit == 0 {
+		limit = 50
+	}
+	period := 10 * time.Millisecond
+	if d := u.policy.LimitRefreshPeriod; d != "" {
+		period, _ = time.ParseDuration(d)
+	}
+
+	c := super.Cluster()
+	key := c.Layout().RateLimiterKey(rl.filterSpec.Pipeline(), rl.filterSpec.Name(), index)
+	u.rl = newClusterLimiter(key, limit, period, c.STM)
+}
+
 func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
 	if policyName == "" {
 		if spec1.DefaultPolicyRef != spec2.DefaultPolicyRef {
@@ -197,16 +397,16 @@ func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
 
 func (rl *RateLimiter) reload(previousGeneration *RateLimiter) {
 	if previousGeneration == nil {
-		for _, u := range rl.spec.URLs {
-			rl.createRateLimiterForURL(u)
+		for i, u := range rl.spec.URLs {
+			rl.createRateLimiterForURL(u, i)
 		}
 		return
 	}
 
 OuterLoop:
-	for _, url := range rl.spec.URLs {
+	for i, url := range rl.spec.URLs {
 		for _, prev := range previousGeneration.spec.URLs {
-			if !url.DeepEqual(&prev.URLRule) {
+			if !url.DeepEqual(&prev.URLRule) || !url.KeyBy.equal(prev.KeyBy) {
 				continue
 			}
 			if !isSamePolicy(rl.spec, previousGeneration.spec, url.PolicyRef) {
@@ -215,12 +415,32 @@ OuterLoop:
 
 			url.Init()
 			rl.bindPolicyToURL(url)
-			url.rl = prev.rl
+			// NOTE: The key specs are equal, so the compiled expression of
+			// the previous one is reused.
+			url.KeyBy = prev.KeyBy
+			if cl, ok := prev.rl.(*clusterLimiter); ok {
+				// NOTE: The key of the cluster limiter contains the index
+				// of the URL rule, so it is always replaced.
+				cl.close()
+				url.rl = url.newLimiter()
+			} else {
+				url.rl = prev.rl
+			}
+			prev.mutex.Lock()
+			url.limiters = prev.limiters
+			prev.mutex.Unlock()
 			prev.rl = nil
+			rl.setClusterLimiterForURL(url, i)
 			rl.setStateListenerForURL(url)
 			continue OuterLoop
 		}
-		rl.createRateLimiterForURL(url)
+		rl.createRateLimiterForURL(url, i)
+	}
+
+	for _, prev := range previousGeneration.spec.URLs {
+		if cl, ok := prev.rl.(*clusterLimiter); ok {
+			cl.close()
+		}
 	}
 }
 
@@ -248,11 +468,16 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 			continue
 		}
 
-		permitted, d := u.rl.AcquirePermission()
+		permitted, d := u.limiter(ctx).AcquirePermission()
 		if !permitted {
+			code := u.policy.RejectedStatusCode
+			if code == 0 {
+				code = http.StatusTooManyRequests
+			}
 			ctx.AddTag("rateLimiter: too many requests")
-			ctx.Response().SetStatusCode(http.StatusTooManyRequests)
+			ctx.Response().SetStatusCode(code)
 			ctx.Response().Std().Header().Set("X-EG-Rate-Limiter", "too-many-requests")
+			ctx.Response().Std().Header().Set("Retry-After", retryAfter(d))
 			return resultRateLimited
 		}
 
@@ -273,6 +498,16 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 	return ""
 }
 
+// retryAfter returns the value of the Retry-After header in seconds,
+// which is at least 1.
+func retryAfter(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
 // Status returns Status generated by Runtime.
 func (rl *RateLimiter) Status() interface{} {
 	return nil
@@ -280,4 +515,9 @@ func (rl *RateLimiter) Status() interface{} {
 
 // Close closes RateLimiter.
 func (rl *RateLimiter) Close() {
+	for _, u := range rl.spec.URLs {
+		if cl, ok := u.rl.(*clusterLimiter); ok {
+			cl.close()
+		}
+	}
 }
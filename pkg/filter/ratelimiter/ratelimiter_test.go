@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestTokenBucket(t *testing.T) {
+	const yamlSpec = `
+kind: RateLimiter
+name: ratelimiter
+policies:
+- name: default
+  algorithm: tokenBucket
+  limitRefreshPeriod: 1h
+  limitForPeriod: 1
+  burst: 2
+  rejectedStatusCode: 503
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /
+  keyBy:
+    type: ip
+`
+	rl := &RateLimiter{}
+	rl.Init(httppipelinetest.NewFilterSpec(t, yamlSpec))
+
+	ip := "192.168.1.1"
+	resp := httptest.NewRecorder()
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodGet
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/limit"
+	}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return ip
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return resp
+	}
+	code := 0
+	ctx.MockedResponse.MockedSetStatusCode = func(c int) {
+		code = c
+	}
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		return lastResult
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := rl.Handle(ctx); result == resultRateLimited {
+			t.Fatalf("request %d should be permitted by burst", i)
+		}
+	}
+	if result := rl.Handle(ctx); result != resultRateLimited {
+		t.Fatal("request should be rate limited")
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status code should be 503, got %d", code)
+	}
+	if v := resp.Header().Get("Retry-After"); v != "3600" {
+		t.Errorf("Retry-After should be 3600, got %s", v)
+	}
+
+	ip = "192.168.1.2"
+	if result := rl.Handle(ctx); result == resultRateLimited {
+		t.Error("requests from another IP should not be rate limited")
+	}
+
+	newRl := &RateLimiter{}
+	newRl.Inherit(httppipelinetest.NewFilterSpec(t, yamlSpec), rl)
+	ip = "192.168.1.1"
+	if result := newRl.Handle(ctx); result != resultRateLimited {
+		t.Error("keyed rate limiters should be inherited")
+	}
+}
+
+func TestReloadClosesClusterLimiters(t *testing.T) {
+	const yamlSpec = `
+kind: RateLimiter
+name: ratelimiter
+policies:
+- name: default
+  limitRefreshPeriod: 1s
+  limitForPeriod: 10
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /a
+- url:
+    prefix: /b
+`
+	rl := &RateLimiter{}
+	rl.Init(httppipelinetest.NewFilterSpec(t, yamlSpec))
+
+	stm := &mockedSTM{kv: map[string]string{}}
+	cl1 := newClusterLimiter("key1", 10, time.Second, stm.apply)
+	cl2 := newClusterLimiter("key2", 10, time.Second, stm.apply)
+	rl.spec.URLs[0].rl, rl.spec.URLs[1].rl = cl1, cl2
+
+	newRl := &RateLimiter{}
+	newRl.Inherit(httppipelinetest.NewFilterSpec(t, strings.Replace(yamlSpec, "prefix: /b", "prefix: /c", 1)), rl)
+	if !cl1.closed {
+		t.Error("replaced cluster limiter should be closed")
+	}
+	if !cl2.closed {
+		t.Error("cluster limiter of removed URL rule should be closed")
+	}
+	if _, ok := newRl.spec.URLs[0].rl.(*clusterLimiter); ok {
+		t.Error("closed cluster limiter should not be inherited")
+	}
+}
+
+func TestKeySpecValidate(t *testing.T) {
+	if (KeySpec{Type: KeyByHeader}).Validate() == nil {
+		t.Error("header should be required")
+	}
+	if (KeySpec{Type: KeyByExpression}).Validate() == nil {
+		t.Error("expression should be required")
+	}
+	if (KeySpec{Type: KeyByExpr}).Validate() == nil {
+		t.Error("expr should be required")
+	}
+	if (KeySpec{Type: KeyByIP}).Validate() != nil {
+		t.Error("key by ip should be valid")
+	}
+}
+
+func TestClusterScopeValidate(t *testing.T) {
+	spec := Spec{
+		Policies: []*Policy{{Name: "p", Scope: ScopeCluster, LimitRefreshPeriod: "10ms"}},
+		URLs:     []*URLRule{{URLRule: urlrule.URLRule{PolicyRef: "p"}}},
+	}
+	if spec.Validate() == nil {
+		t.Error("period less than 1s should be invalid in cluster scope")
+	}
+
+	spec.Policies[0].LimitRefreshPeriod = "1s"
+	if err := spec.Validate(); err != nil {
+		t.Errorf("period of 1s should be valid in cluster scope, got %v", err)
+	}
+}
+
+func TestKeyByExpr(t *testing.T) {
+	const yamlSpec = `
+kind: RateLimiter
+name: ratelimiter
+policies:
+- name: default
+  algorithm: tokenBucket
+  limitRefreshPeriod: 1h
+  limitForPeriod: 1
+  burst: 1
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /
+  keyBy:
+    type: expr
+    expr: 'req.header("X-User") + ":" + req.path()'
+`
+	rl := &RateLimiter{}
+	rl.Init(httppipelinetest.NewFilterSpec(t, yamlSpec))
+
+	user, path := "alice", "/a"
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return http.MethodGet
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return path
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(http.Header{"X-User": []string{user}})
+	}
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter {
+		return httptest.NewRecorder()
+	}
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		return lastResult
+	}
+
+	if result := rl.Handle(ctx); result == resultRateLimited {
+		t.Fatal("first request should be permitted")
+	}
+	if result := rl.Handle(ctx); result != resultRateLimited {
+		t.Fatal("request should be rate limited")
+	}
+	path = "/b"
+	if result := rl.Handle(ctx); result == resultRateLimited {
+		t.Error("requests of another key should not be rate limited")
+	}
+
+	newRl := &RateLimiter{}
+	newRl.Inherit(httppipelinetest.NewFilterSpec(t, yamlSpec), rl)
+	if result := newRl.Handle(ctx); result != resultRateLimited {
+		t.Error("keyed rate limiters should be inherited")
+	}
+}
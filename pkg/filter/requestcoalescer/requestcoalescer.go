@@ -0,0 +1,364 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestcoalescer
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of RequestCoalescer.
+	Kind = "RequestCoalescer"
+
+	resultCoalesced = "coalesced"
+
+	defaultMaxBodySize = 1 << 20
+	defaultTimeout     = 10 * time.Second
+
+	keyAuthorization = "Authorization"
+	keyCookie        = "Cookie"
+	keySetCookie     = "Set-Cookie"
+	keyVary          = "Vary"
+)
+
+var (
+	results = []string{resultCoalesced}
+
+	defaultMethods = []string{http.MethodGet}
+)
+
+func init() {
+	httppipeline.Register(&RequestCoalescer{})
+}
+
+type (
+	// RequestCoalescer collapses the concurrent identical requests into
+	// one request to the backends, and shares its response with the
+	// others.
+	RequestCoalescer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout time.Duration
+
+		mutex   sync.Mutex
+		flights map[string]*flight
+
+		leaders   uint64
+		coalesced uint64
+		unshared  uint64
+		timeouts  uint64
+		cancelled uint64
+	}
+
+	// Spec describes the RequestCoalescer.
+	Spec struct {
+		// Methods are the methods of the requests to coalesce, which
+		// could be GET and HEAD only, as the bodies aren't in the key.
+		// Default is GET.
+		Methods []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		// KeyHeaders are the headers in the key of the requests besides
+		// the method and the URL. Requests with Authorization or Cookie
+		// headers are coalesced only if the headers are in KeyHeaders.
+		KeyHeaders []string `yaml:"keyHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		// MaxBodySize is the maximum size of the response bodies to share,
+		// default is 1MB.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=0"`
+		// Timeout is the maximum time to wait for the response of the
+		// first request, default is 10s.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of RequestCoalescer.
+	Status struct {
+		// Leaders is the number of requests sent to the backends on
+		// behalf of the concurrent identical requests.
+		Leaders   uint64 `yaml:"leaders"`
+		Coalesced uint64 `yaml:"coalesced"`
+		// Unshared is the number of the waiting requests sent to the
+		// backends because the response couldn't be shared.
+		Unshared  uint64 `yaml:"unshared"`
+		Timeouts  uint64 `yaml:"timeouts"`
+		Cancelled uint64 `yaml:"cancelled"`
+		Inflight  int    `yaml:"inflight"`
+	}
+
+	// flight is the request to the backends shared by the requests of the
+	// same key, resp is set before done is closed, nil means the response
+	// can't be shared.
+	flight struct {
+		done chan struct{}
+		resp *response
+	}
+
+	response struct {
+		statusCode int
+		header     http.Header
+		body       []byte
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	for _, m := range spec.Methods {
+		if m != http.MethodGet && m != http.MethodHead {
+			return fmt.Errorf("method %s can't be coalesced, only GET and HEAD are supported", m)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of RequestCoalescer.
+func (rc *RequestCoalescer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of RequestCoalescer.
+func (rc *RequestCoalescer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of RequestCoalescer.
+func (rc *RequestCoalescer) Description() string {
+	return "RequestCoalescer collapses concurrent identical requests into one upstream request."
+}
+
+// Results returns the results of RequestCoalescer.
+func (rc *RequestCoalescer) Results() []string {
+	return results
+}
+
+// Init initializes RequestCoalescer.
+func (rc *RequestCoalescer) Init(filterSpec *httppipeline.FilterSpec) {
+	rc.filterSpec, rc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rc.reload()
+}
+
+// Inherit inherits previous generation of RequestCoalescer.
+func (rc *RequestCoalescer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rc.Init(filterSpec)
+}
+
+func (rc *RequestCoalescer) reload() {
+	if len(rc.spec.Methods) == 0 {
+		rc.spec.Methods = defaultMethods
+	}
+	for i, h := range rc.spec.KeyHeaders {
+		rc.spec.KeyHeaders[i] = http.CanonicalHeaderKey(h)
+	}
+	if rc.spec.MaxBodySize == 0 {
+		rc.spec.MaxBodySize = defaultMaxBodySize
+	}
+
+	rc.timeout = defaultTimeout
+	if rc.spec.Timeout != "" {
+		if d, err := time.ParseDuration(rc.spec.Timeout); err == nil && d > 0 {
+			rc.timeout = d
+		}
+	}
+
+	rc.flights = make(map[string]*flight)
+}
+
+// Handle coalesces the request with the identical requests in flight.
+func (rc *RequestCoalescer) Handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	if !stringtool.StrInSlice(r.Method(), rc.spec.Methods) || !rc.coalescable(r) {
+		return ctx.CallNextHandler("")
+	}
+
+	key := rc.key(r)
+	f, leader := rc.join(key)
+	if leader {
+		return rc.lead(ctx, key, f)
+	}
+
+	timer := time.NewTimer(rc.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+	case <-timer.C:
+		atomic.AddUint64(&rc.timeouts, 1)
+		ctx.AddTag("requestCoalescer: timeout")
+		return ctx.CallNextHandler("")
+	case <-ctx.Done():
+		atomic.AddUint64(&rc.cancelled, 1)
+		return ctx.CallNextHandler("")
+	}
+
+	if f.resp == nil {
+		atomic.AddUint64(&rc.unshared, 1)
+		ctx.AddTag("requestCoalescer: unshared")
+		return ctx.CallNextHandler("")
+	}
+
+	atomic.AddUint64(&rc.coalesced, 1)
+	rc.serve(ctx, f.resp)
+	ctx.AddTag("requestCoalescer: coalesced")
+	return ctx.CallNextHandler(resultCoalesced)
+}
+
+// coalescable checks whether the responses could be shared, the
+// credentials must be in the key, or the response of a user could be
+// sent to the others.
+func (rc *RequestCoalescer) coalescable(r context.HTTPRequest) bool {
+	for _, h := range []string{keyAuthorization, keyCookie} {
+		if r.Header().Get(h) != "" && !stringtool.StrInSlice(h, rc.spec.KeyHeaders) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rc *RequestCoalescer) key(r context.HTTPRequest) string {
+	buff := &strings.Builder{}
+	buff.WriteString(stringtool.Cat(r.Method(), " ", r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query()))
+	for _, h := range rc.spec.KeyHeaders {
+		buff.WriteString("\n")
+		buff.WriteString(h)
+		buff.WriteString(": ")
+		buff.WriteString(strings.Join(r.Header().GetAll(h), ","))
+	}
+	return buff.String()
+}
+
+// join joins the flight of the key, it returns true if the caller is the
+// leader, which must call leave when its response is finished.
+func (rc *RequestCoalescer) join(key string) (*flight, bool) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if f, ok := rc.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	rc.flights[key] = f
+	return f, true
+}
+
+func (rc *RequestCoalescer) leave(key string, f *flight) {
+	rc.mutex.Lock()
+	delete(rc.flights, key)
+	rc.mutex.Unlock()
+
+	close(f.done)
+}
+
+// lead sends the request to the backends, and captures the response for
+// the waiting requests.
+func (rc *RequestCoalescer) lead(ctx context.HTTPContext, key string, f *flight) string {
+	atomic.AddUint64(&rc.leaders, 1)
+
+	// NOTE: The response body is captured when it is flushed, which is
+	// before the finish actions, so the leader isn't slowed down.
+	ctx.OnFinish(func() {
+		rc.leave(key, f)
+	})
+
+	result := ctx.CallNextHandler("")
+
+	w := ctx.Response()
+	if result != "" || isEventStream(w.Header().Get(httpheader.KeyContentType)) ||
+		w.Header().Get(keySetCookie) != "" || !rc.varyCovered(w.Header().GetAll(keyVary)) {
+		return result
+	}
+
+	resp := &response{
+		statusCode: w.StatusCode(),
+		header:     w.Header().Copy().Std(),
+	}
+	if w.Body() == nil {
+		f.resp = resp
+		return result
+	}
+
+	size := int64(0)
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		size += int64(len(body))
+		if size > rc.spec.MaxBodySize {
+			resp.body = nil
+			return body
+		}
+
+		resp.body = append(resp.body, body...)
+		if complete {
+			f.resp = resp
+		}
+		return body
+	})
+
+	return result
+}
+
+// varyCovered checks whether the headers varying the response are all in
+// the key, or the waiting requests may get a response for other headers.
+func (rc *RequestCoalescer) varyCovered(vary []string) bool {
+	for _, v := range vary {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h != "" && !stringtool.StrInSlice(h, rc.spec.KeyHeaders) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rc *RequestCoalescer) serve(ctx context.HTTPContext, resp *response) {
+	w := ctx.Response()
+	w.Header().Reset(resp.header.Clone())
+	w.SetStatusCode(resp.statusCode)
+	w.SetBody(bytes.NewReader(resp.body))
+}
+
+func isEventStream(contentType string) bool {
+	return strings.HasPrefix(strings.TrimSpace(contentType), "text/event-stream")
+}
+
+// Status returns status.
+func (rc *RequestCoalescer) Status() interface{} {
+	rc.mutex.Lock()
+	inflight := len(rc.flights)
+	rc.mutex.Unlock()
+
+	return &Status{
+		Leaders:   atomic.LoadUint64(&rc.leaders),
+		Coalesced: atomic.LoadUint64(&rc.coalesced),
+		Unshared:  atomic.LoadUint64(&rc.unshared),
+		Timeouts:  atomic.LoadUint64(&rc.timeouts),
+		Cancelled: atomic.LoadUint64(&rc.cancelled),
+		Inflight:  inflight,
+	}
+}
+
+// Close closes RequestCoalescer.
+func (rc *RequestCoalescer) Close() {
+}
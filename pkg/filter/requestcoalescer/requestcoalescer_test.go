@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestcoalescer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type backend struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+	header  map[string]string
+	body    string
+}
+
+func newBackend(body string) *backend {
+	return &backend{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+		body:    body,
+	}
+}
+
+func (b *backend) do(rc *RequestCoalescer, header map[string]string) (string, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/hot?key=1", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return httppipelinetest.Handle(rc, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		atomic.AddInt32(&b.calls, 1)
+		b.started <- struct{}{}
+		<-b.release
+		for k, v := range b.header {
+			ctx.Response().Header().Set(k, v)
+		}
+		ctx.Response().SetStatusCode(http.StatusAccepted)
+		ctx.Response().SetBody(strings.NewReader(b.body))
+		return ""
+	})
+}
+
+// run sends a request and n concurrent identical requests after it has
+// reached the backend.
+func (b *backend) run(rc *RequestCoalescer, n int, header map[string]string) []*httptest.ResponseRecorder {
+	var wg sync.WaitGroup
+	resps := make([]*httptest.ResponseRecorder, n+1)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, resps[0] = b.do(rc, header)
+	}()
+	<-b.started
+
+	for i := 1; i <= n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, resps[i] = b.do(rc, header)
+		}(i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+	return resps
+}
+
+func TestCoalesce(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+`).(*RequestCoalescer)
+	defer rc.Close()
+
+	b := newBackend("hot response")
+	b.header = map[string]string{"X-Backend": "1"}
+	resps := b.run(rc, 10, nil)
+
+	if calls := atomic.LoadInt32(&b.calls); calls != 1 {
+		t.Errorf("expected 1 call to the backend, got %d", calls)
+	}
+	for i, w := range resps {
+		body, _ := io.ReadAll(w.Result().Body)
+		if w.Code != http.StatusAccepted || string(body) != "hot response" || w.Header().Get("X-Backend") != "1" {
+			t.Errorf("response %d: unexpected %d %v %s", i, w.Code, w.Header(), body)
+		}
+	}
+
+	status := rc.Status().(*Status)
+	if status.Leaders != 1 || status.Coalesced != 10 || status.Inflight != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestUnshared(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+maxBodySize: 4
+`).(*RequestCoalescer)
+	defer rc.Close()
+
+	// The body is too large to share.
+	b := newBackend("large response")
+	b.run(rc, 3, nil)
+	if calls := atomic.LoadInt32(&b.calls); calls != 4 {
+		t.Errorf("expected 4 calls to the backend, got %d", calls)
+	}
+	if status := rc.Status().(*Status); status.Unshared != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// The responses setting cookies aren't shared.
+	b = newBackend("ok")
+	b.header = map[string]string{"Set-Cookie": "session=1"}
+	b.run(rc, 3, nil)
+	if calls := atomic.LoadInt32(&b.calls); calls != 4 {
+		t.Errorf("expected 4 calls to the backend, got %d", calls)
+	}
+
+	// The responses varying by headers not in the key aren't shared.
+	for _, vary := range []string{"Accept-Encoding", "accept-language, Accept-Encoding", "*"} {
+		b = newBackend("ok")
+		b.header = map[string]string{"Vary": vary}
+		b.run(rc, 3, nil)
+		if calls := atomic.LoadInt32(&b.calls); calls != 4 {
+			t.Errorf("vary %s: expected 4 calls to the backend, got %d", vary, calls)
+		}
+	}
+
+	rc = httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+keyHeaders: [Accept-Language]
+`).(*RequestCoalescer)
+	b = newBackend("ok")
+	b.header = map[string]string{"Vary": "accept-language"}
+	b.run(rc, 3, nil)
+	if calls := atomic.LoadInt32(&b.calls); calls != 1 {
+		t.Errorf("vary by key headers: expected 1 call to the backend, got %d", calls)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if (Spec{Methods: []string{http.MethodGet, http.MethodHead}}).Validate() != nil {
+		t.Error("GET and HEAD should be valid")
+	}
+	if (Spec{Methods: []string{http.MethodPost}}).Validate() == nil {
+		t.Error("POST should be invalid as the body isn't in the key")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+`).(*RequestCoalescer)
+	defer rc.Close()
+
+	b := newBackend("private")
+	close(b.release)
+	for i := 0; i < 3; i++ {
+		b.do(rc, map[string]string{"Authorization": "Bearer token"})
+	}
+	if calls := atomic.LoadInt32(&b.calls); calls != 3 {
+		t.Errorf("expected 3 calls to the backend, got %d", calls)
+	}
+	if status := rc.Status().(*Status); status.Leaders != 0 {
+		t.Errorf("requests with credentials should not be coalesced, got %+v", status)
+	}
+
+	rc = httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+keyHeaders: [authorization]
+`).(*RequestCoalescer)
+	a := rc.key(httptestRequest("Bearer a"))
+	if a == rc.key(httptestRequest("Bearer b")) || a != rc.key(httptestRequest("Bearer a")) {
+		t.Errorf("authorization should be in the key")
+	}
+	if !rc.coalescable(httptestRequest("Bearer a")) {
+		t.Errorf("authorization in key headers should be coalescable")
+	}
+}
+
+func httptestRequest(authorization string) context.HTTPRequest {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", authorization)
+	ctx, _ := httppipelinetest.NewContext(req, nil)
+	return ctx.Request()
+}
+
+func TestTimeout(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &RequestCoalescer{}, `
+kind: RequestCoalescer
+name: coalescer
+timeout: 10ms
+`).(*RequestCoalescer)
+	defer rc.Close()
+
+	b := newBackend("slow")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.do(rc, nil)
+	}()
+	<-b.started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.do(rc, nil)
+	}()
+	<-b.started
+	close(b.release)
+	wg.Wait()
+
+	if status := rc.Status().(*Status); status.Timeouts != 1 || status.Leaders != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultExternalTimeout = time.Second
+	defaultStaleRetention  = time.Hour
+
+	// NOTE: Memcached regards expirations longer than 30 days as
+	// absolute Unix timestamps.
+	maxMemcachedExpiration = 30 * 24 * time.Hour
+)
+
+type (
+	// RedisSpec describes the Redis backend, the servers are regarded
+	// as a Redis cluster if there are more than one addresses.
+	RedisSpec struct {
+		Addrs     []string `yaml:"addrs" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Username  string   `yaml:"username" jsonschema:"omitempty"`
+		Password  string   `yaml:"password" jsonschema:"omitempty"`
+		DB        int      `yaml:"db" jsonschema:"omitempty,minimum=0"`
+		KeyPrefix string   `yaml:"keyPrefix" jsonschema:"omitempty"`
+		Timeout   string   `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// MemcachedSpec describes the memcached backend.
+	MemcachedSpec struct {
+		Servers   []string `yaml:"servers" jsonschema:"required,minItems=1,uniqueItems=true"`
+		KeyPrefix string   `yaml:"keyPrefix" jsonschema:"omitempty"`
+		Timeout   string   `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// externalStore is the common part of the backends shared by
+	// multiple instances of Easegress, the entries expire by the
+	// servers instead of the LRU index.
+	externalStore struct {
+		prefix    string
+		timeout   time.Duration
+		retention time.Duration
+	}
+
+	redisStore struct {
+		externalStore
+		client redis.UniversalClient
+	}
+
+	memcachedStore struct {
+		externalStore
+		client *memcache.Client
+	}
+)
+
+func (s *externalStore) key(key string) string {
+	return s.prefix + hashKey(key)
+}
+
+// expiration returns how long the entry is kept by the servers, stale
+// responses are kept for the retention to be revalidated.
+func (s *externalStore) expiration(e *entry) time.Duration {
+	d := e.Lifetime
+	if e.Variants || e.hasValidators() {
+		d += s.retention
+	}
+	return d
+}
+
+func (s *externalStore) decode(key string, buff []byte) *entry {
+	e := &entry{}
+	if err := json.Unmarshal(buff, e); err != nil {
+		logger.Warnf("unmarshal cache entry failed: %v", err)
+		return nil
+	}
+	if e.Key != key {
+		return nil
+	}
+	return e
+}
+
+func newRedisStore(spec *RedisSpec, prefix string, retention time.Duration) *redisStore {
+	if spec.KeyPrefix != "" {
+		prefix = spec.KeyPrefix
+	}
+
+	return &redisStore{
+		externalStore: externalStore{
+			prefix:    prefix,
+			timeout:   parseDurationOr(spec.Timeout, defaultExternalTimeout),
+			retention: retention,
+		},
+		client: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    spec.Addrs,
+			Username: spec.Username,
+			Password: spec.Password,
+			DB:       spec.DB,
+		}),
+	}
+}
+
+func (s *redisStore) get(key string) *entry {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), s.timeout)
+	defer cancel()
+
+	buff, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logger.Warnf("get cache entry from redis failed: %v", err)
+		}
+		return nil
+	}
+	return s.decode(key, buff)
+}
+
+func (s *redisStore) set(key string, e *entry) {
+	expiration := s.expiration(e)
+	if expiration <= 0 {
+		return
+	}
+
+	buff, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("BUG: marshal cache entry failed: %v", err)
+		return
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), s.timeout)
+	defer cancel()
+	if err = s.client.Set(ctx, s.key(key), buff, expiration).Err(); err != nil {
+		logger.Warnf("set cache entry to redis failed: %v", err)
+	}
+}
+
+func (s *redisStore) del(key string) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), s.timeout)
+	defer cancel()
+	if err := s.client.Del(ctx, s.key(key)).Err(); err != nil {
+		logger.Warnf("delete cache entry from redis failed: %v", err)
+	}
+}
+
+// stat is not supported, the entries are shared with other instances.
+func (s *redisStore) stat() (int, int64) {
+	return 0, 0
+}
+
+func (s *redisStore) close() {
+	if err := s.client.Close(); err != nil {
+		logger.Warnf("close redis client failed: %v", err)
+	}
+}
+
+func newMemcachedStore(spec *MemcachedSpec, prefix string, retention time.Duration) *memcachedStore {
+	if spec.KeyPrefix != "" {
+		prefix = spec.KeyPrefix
+	}
+
+	s := &memcachedStore{
+		externalStore: externalStore{
+			prefix:    prefix,
+			timeout:   parseDurationOr(spec.Timeout, defaultExternalTimeout),
+			retention: retention,
+		},
+		client: memcache.New(spec.Servers...),
+	}
+	s.client.Timeout = s.timeout
+	return s
+}
+
+func (s *memcachedStore) get(key string) *entry {
+	item, err := s.client.Get(s.key(key))
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			logger.Warnf("get cache entry from memcached failed: %v", err)
+		}
+		return nil
+	}
+	return s.decode(key, item.Value)
+}
+
+func (s *memcachedStore) set(key string, e *entry) {
+	expiration := s.expiration(e)
+	if expiration <= 0 {
+		return
+	}
+	if expiration > maxMemcachedExpiration {
+		expiration = maxMemcachedExpiration
+	}
+
+	buff, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("BUG: marshal cache entry failed: %v", err)
+		return
+	}
+
+	err = s.client.Set(&memcache.Item{
+		Key:        s.key(key),
+		Value:      buff,
+		Expiration: int32((expiration + time.Second - 1) / time.Second),
+	})
+	if err != nil {
+		logger.Warnf("set cache entry to memcached failed: %v", err)
+	}
+}
+
+func (s *memcachedStore) del(key string) {
+	err := s.client.Delete(s.key(key))
+	if err != nil && err != memcache.ErrCacheMiss {
+		logger.Warnf("delete cache entry from memcached failed: %v", err)
+	}
+}
+
+// stat is not supported, the entries are shared with other instances.
+func (s *memcachedStore) stat() (int, int64) {
+	return 0, 0
+}
+
+func (s *memcachedStore) close() {}
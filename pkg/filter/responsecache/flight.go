@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"sync"
+)
+
+// flights coalesces the concurrent requests of the same key, only the
+// first one goes to the servers, others wait for it to store the
+// response to prevent cache stampedes.
+type flights struct {
+	mutex sync.Mutex
+	m     map[string]chan struct{}
+}
+
+func newFlights() *flights {
+	return &flights{m: make(map[string]chan struct{})}
+}
+
+// join joins the flight of the key, it returns true if the caller is
+// the leader, which must call leave after the response is stored.
+func (f *flights) join(key string) (<-chan struct{}, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if done, ok := f.m[key]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	f.m[key] = done
+	return done, true
+}
+
+func (f *flights) leave(key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if done, ok := f.m[key]; ok {
+		close(done)
+		delete(f.m, key)
+	}
+}
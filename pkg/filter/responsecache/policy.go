@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// Reference: https://tools.ietf.org/html/rfc7234
+
+const (
+	keyAge             = "Age"
+	keyDate            = "Date"
+	keyETag            = "ETag"
+	keyExpires         = "Expires"
+	keyLastModified    = "Last-Modified"
+	keyIfNoneMatch     = "If-None-Match"
+	keyIfModifiedSince = "If-Modified-Since"
+	keyAuthorization   = "Authorization"
+	keySetCookie       = "Set-Cookie"
+	keyPragma          = "Pragma"
+)
+
+type (
+	// cacheControl is the parsed directives of the Cache-Control headers,
+	// the value of a directive is empty if it has no argument.
+	cacheControl map[string]string
+
+	// entry is a cached response.
+	entry struct {
+		// Key is the primary key of the response, it is used to detect
+		// hash collisions of the disk backend.
+		Key string `json:"key"`
+
+		// Vary is the names of the request headers selecting the
+		// response, the entry is an index of the variants and has no
+		// response if Variants is true.
+		Vary     []string `json:"vary,omitempty"`
+		Variants bool     `json:"variants,omitempty"`
+
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header"`
+		Body       []byte      `json:"body"`
+
+		// ResponseTime is the time the response was received or
+		// revalidated, InitialAge is the age of the response at that
+		// time, and Lifetime is its freshness lifetime.
+		ResponseTime time.Time     `json:"responseTime"`
+		InitialAge   time.Duration `json:"initialAge"`
+		Lifetime     time.Duration `json:"lifetime"`
+	}
+)
+
+func parseCacheControl(values []string) cacheControl {
+	cc := cacheControl{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `" `)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the duration of a delta-seconds directive.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func requestCacheControl(h *httpheader.HTTPHeader) cacheControl {
+	cc := parseCacheControl(h.GetAll(httpheader.KeyCacheControl))
+	// NOTE: Pragma: no-cache is the same as Cache-Control: no-cache
+	// for HTTP/1.0 clients.
+	if !cc.has("no-cache") && strings.Contains(strings.ToLower(h.Get(keyPragma)), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func parseHTTPDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// explicitLifetime returns the freshness lifetime given by the server
+// in the order of s-maxage, max-age and Expires.
+func explicitLifetime(h http.Header, cc cacheControl) (time.Duration, bool) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+
+	if _, ok := h[keyExpires]; !ok {
+		return 0, false
+	}
+	// NOTE: An invalid Expires, e.g. "0", means already expired.
+	expires, ok := parseHTTPDate(h.Get(keyExpires))
+	if !ok {
+		return 0, true
+	}
+	date, ok := parseHTTPDate(h.Get(keyDate))
+	if !ok {
+		date = time.Now()
+	}
+	if expires.Before(date) {
+		return 0, true
+	}
+	return expires.Sub(date), true
+}
+
+// initialAge returns the corrected age of a response received at now.
+func initialAge(h http.Header, now time.Time) time.Duration {
+	var age time.Duration
+	if date, ok := parseHTTPDate(h.Get(keyDate)); ok && now.After(date) {
+		age = now.Sub(date).Truncate(time.Second)
+	}
+	if n, err := strconv.ParseInt(h.Get(keyAge), 10, 64); err == nil && n > 0 {
+		if d := time.Duration(n) * time.Second; d > age {
+			age = d
+		}
+	}
+	return age
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.ResponseTime)
+}
+
+func (e *entry) hasValidators() bool {
+	return e.Header.Get(keyETag) != "" || e.Header.Get(keyLastModified) != ""
+}
+
+// servable reports whether the entry can be served without revalidation
+// to a request of the cache control directives.
+func (e *entry) servable(reqCC cacheControl, now time.Time) bool {
+	if reqCC.has("no-cache") {
+		return false
+	}
+
+	respCC := parseCacheControl(e.Header[httpheader.KeyCacheControl])
+	if respCC.has("no-cache") {
+		return false
+	}
+
+	age := e.age(now)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		age += minFresh
+	}
+	if age <= e.Lifetime {
+		return true
+	}
+
+	// Stale responses are served only if the client accepts them and
+	// the server does not forbid it.
+	if !reqCC.has("max-stale") ||
+		respCC.has("must-revalidate") || respCC.has("proxy-revalidate") {
+		return false
+	}
+	if maxStale, ok := reqCC.seconds("max-stale"); ok {
+		return age-e.Lifetime <= maxStale
+	}
+	return true
+}
+
+// notModified reports whether the conditional request is satisfied by
+// the entry, so 304 is sent to the client instead of the entry.
+func (e *entry) notModified(h *httpheader.HTTPHeader) bool {
+	if inm := h.Get(keyIfNoneMatch); inm != "" {
+		etag := e.Header.Get(keyETag)
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || weakETag(tag) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, ok := parseHTTPDate(h.Get(keyIfModifiedSince))
+	if !ok {
+		return false
+	}
+	lm, ok := parseHTTPDate(e.Header.Get(keyLastModified))
+	return ok && !lm.After(ims)
+}
+
+// weakETag strips the weak indicator for the weak comparison.
+func weakETag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+// update updates the entry by the headers of a 304 response, it returns
+// a new entry and keeps the original one untouched.
+func (e *entry) update(h http.Header, lifetime time.Duration, now time.Time) *entry {
+	n := *e
+	n.Header = e.Header.Clone()
+	for key, values := range h {
+		switch key {
+		case httpheader.KeyContentLength, httpheader.KeyContentEncoding:
+			// NOTE: These describe the cached body.
+			continue
+		}
+		n.Header[key] = append([]string(nil), values...)
+	}
+	n.ResponseTime = now
+	n.InitialAge = initialAge(h, now)
+	n.Lifetime = lifetime
+	return &n
+}
+
+// size returns the approximate memory size of the entry.
+func (e *entry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for key, values := range e.Header {
+		size += int64(len(key))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
@@ -0,0 +1,597 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ResponseCache.
+	Kind = "ResponseCache"
+
+	resultCached   = "cached"
+	resultUncached = "uncached"
+
+	backendMemory    = "memory"
+	backendDisk      = "disk"
+	backendRedis     = "redis"
+	backendMemcached = "memcached"
+
+	defaultMaxEntryBytes = 1 << 20
+	defaultMemoryBytes   = 64 << 20
+	defaultDiskBytes     = 1 << 30
+
+	defaultCoalesceTimeout = 5 * time.Second
+)
+
+var (
+	results = []string{resultCached, resultUncached}
+
+	defaultMethods = []string{http.MethodGet, http.MethodHead}
+
+	// Reference: https://tools.ietf.org/html/rfc7231#section-6.1
+	defaultCodes = []int{200, 203, 204, 300, 301, 404, 405, 410, 414, 501}
+)
+
+func init() {
+	httppipeline.Register(&ResponseCache{})
+}
+
+type (
+	// ResponseCache is filter ResponseCache.
+	ResponseCache struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		store         store
+		maxEntryBytes int64
+		defaultTTL    time.Duration
+		methods       []string
+		codes         []int
+
+		flights         *flights
+		coalesceTimeout time.Duration
+
+		hits        uint64
+		misses      uint64
+		revalidated uint64
+		stored      uint64
+		bypassed    uint64
+		coalesced   uint64
+	}
+
+	// Spec describes the ResponseCache.
+	Spec struct {
+		Backend string `yaml:"backend" jsonschema:"omitempty,enum=,enum=memory,enum=disk,enum=redis,enum=memcached"`
+		// Dir is the directory of the disk backend.
+		Dir       string         `yaml:"dir" jsonschema:"omitempty"`
+		Redis     *RedisSpec     `yaml:"redis,omitempty" jsonschema:"omitempty"`
+		Memcached *MemcachedSpec `yaml:"memcached,omitempty" jsonschema:"omitempty"`
+		// StaleRetention is how long the redis and memcached backends
+		// keep stale responses with validators for revalidation.
+		StaleRetention string `yaml:"staleRetention" jsonschema:"omitempty,format=duration"`
+		// MaxBytes is the capacity of the backend, the least recently
+		// used responses are evicted when it is exceeded.
+		MaxBytes      int64    `yaml:"maxBytes" jsonschema:"omitempty,minimum=0"`
+		MaxEntryBytes int64    `yaml:"maxEntryBytes" jsonschema:"omitempty,minimum=0"`
+		Methods       []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Codes         []int    `yaml:"codes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		// DefaultTTL is the freshness lifetime of responses without
+		// explicit expiration, they are not fresh by default.
+		DefaultTTL   string         `yaml:"defaultTTL" jsonschema:"omitempty,format=duration"`
+		TTLOverrides []*TTLOverride `yaml:"ttlOverrides" jsonschema:"omitempty"`
+		// Coalesce makes concurrent requests of the same URL wait for
+		// the first one to store the response instead of going to the
+		// servers together.
+		Coalesce        bool   `yaml:"coalesce" jsonschema:"omitempty"`
+		CoalesceTimeout string `yaml:"coalesceTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// TTLOverride overrides the freshness lifetime given by the servers
+	// for the requests of the path.
+	TTLOverride struct {
+		Path       string `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix string `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		TTL        string `yaml:"ttl" jsonschema:"required,format=duration"`
+
+		ttl time.Duration
+	}
+
+	// Status is the status of ResponseCache.
+	Status struct {
+		Hits        uint64 `yaml:"hits"`
+		Misses      uint64 `yaml:"misses"`
+		Revalidated uint64 `yaml:"revalidated"`
+		Stored      uint64 `yaml:"stored"`
+		Bypassed    uint64 `yaml:"bypassed"`
+		Coalesced   uint64 `yaml:"coalesced"`
+		Entries     int    `yaml:"entries"`
+		Bytes       int64  `yaml:"bytes"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	switch spec.Backend {
+	case backendDisk:
+		if spec.Dir == "" {
+			return fmt.Errorf("dir is required by the disk backend")
+		}
+	case backendRedis:
+		if spec.Redis == nil {
+			return fmt.Errorf("redis is required by the redis backend")
+		}
+	case backendMemcached:
+		if spec.Memcached == nil {
+			return fmt.Errorf("memcached is required by the memcached backend")
+		}
+	}
+	return nil
+}
+
+func parseDurationOr(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return d
+	}
+	return v
+}
+
+// Kind returns the kind of ResponseCache.
+func (rc *ResponseCache) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ResponseCache.
+func (rc *ResponseCache) DefaultSpec() interface{} {
+	return &Spec{Backend: backendMemory}
+}
+
+// Description returns the description of ResponseCache.
+func (rc *ResponseCache) Description() string {
+	return "ResponseCache caches responses by the semantics of RFC 7234."
+}
+
+// Results returns the results of ResponseCache.
+func (rc *ResponseCache) Results() []string {
+	return results
+}
+
+// Init initializes ResponseCache.
+func (rc *ResponseCache) Init(filterSpec *httppipeline.FilterSpec) {
+	rc.filterSpec, rc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rc.reload()
+}
+
+// Inherit inherits previous generation of ResponseCache.
+func (rc *ResponseCache) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rc.Init(filterSpec)
+}
+
+func (rc *ResponseCache) reload() {
+	spec := rc.spec
+
+	rc.maxEntryBytes = spec.MaxEntryBytes
+	if rc.maxEntryBytes == 0 {
+		rc.maxEntryBytes = defaultMaxEntryBytes
+	}
+	rc.methods = spec.Methods
+	if len(rc.methods) == 0 {
+		rc.methods = defaultMethods
+	}
+	rc.codes = spec.Codes
+	if len(rc.codes) == 0 {
+		rc.codes = defaultCodes
+	}
+	if spec.DefaultTTL != "" {
+		rc.defaultTTL, _ = time.ParseDuration(spec.DefaultTTL)
+	}
+	for _, o := range spec.TTLOverrides {
+		o.ttl, _ = time.ParseDuration(o.TTL)
+	}
+	if spec.Coalesce {
+		rc.flights = newFlights()
+		rc.coalesceTimeout = parseDurationOr(spec.CoalesceTimeout, defaultCoalesceTimeout)
+	}
+
+	// NOTE: The filter name is in the default key prefix, so instances
+	// of Easegress share the cache of the same filter.
+	prefix := "easegress:responsecache:" + rc.filterSpec.Name() + ":"
+	retention := parseDurationOr(spec.StaleRetention, defaultStaleRetention)
+	switch spec.Backend {
+	case backendRedis:
+		rc.store = newRedisStore(spec.Redis, prefix, retention)
+		return
+	case backendMemcached:
+		rc.store = newMemcachedStore(spec.Memcached, prefix, retention)
+		return
+	}
+
+	if spec.Backend == backendDisk {
+		capacity := spec.MaxBytes
+		if capacity == 0 {
+			capacity = defaultDiskBytes
+		}
+		s, err := newDiskStore(spec.Dir, capacity)
+		if err == nil {
+			rc.store = s
+			return
+		}
+		logger.Errorf("%s: open disk backend %s failed, fallback to memory: %v",
+			rc.filterSpec.Name(), spec.Dir, err)
+	}
+
+	capacity := spec.MaxBytes
+	if capacity == 0 {
+		capacity = defaultMemoryBytes
+	}
+	rc.store = newMemoryStore(capacity)
+}
+
+// Handle serves the request from the cache if possible, otherwise it
+// calls the following handlers and caches the response.
+func (rc *ResponseCache) Handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	if !stringtool.StrInSlice(r.Method(), rc.methods) {
+		result := ctx.CallNextHandler("")
+		rc.invalidate(ctx)
+		return result
+	}
+
+	reqCC := requestCacheControl(r.Header())
+	if reqCC.has("no-store") {
+		atomic.AddUint64(&rc.bypassed, 1)
+		return ctx.CallNextHandler("")
+	}
+
+	key := rc.primaryKey(r)
+	e := rc.lookup(key, r.Header())
+	now := time.Now()
+
+	if e != nil && e.servable(reqCC, now) {
+		atomic.AddUint64(&rc.hits, 1)
+		rc.serve(ctx, e, now)
+		ctx.AddTag("cacheHit")
+		return ctx.CallNextHandler(resultCached)
+	}
+
+	if e == nil && reqCC.has("only-if-cached") {
+		atomic.AddUint64(&rc.misses, 1)
+		w.SetStatusCode(http.StatusGatewayTimeout)
+		return ctx.CallNextHandler(resultUncached)
+	}
+
+	if rc.flights != nil {
+		done, leader := rc.flights.join(key)
+		if leader {
+			// NOTE: The response is stored when its body is flushed,
+			// which is before the finish actions.
+			ctx.OnFinish(func() {
+				rc.flights.leave(key)
+			})
+		} else if rc.wait(ctx, done) {
+			e = rc.lookup(key, r.Header())
+			now = time.Now()
+			if e != nil && e.servable(reqCC, now) {
+				atomic.AddUint64(&rc.coalesced, 1)
+				rc.serve(ctx, e, now)
+				ctx.AddTag("cacheCoalesced")
+				return ctx.CallNextHandler(resultCached)
+			}
+		}
+	}
+
+	// NOTE: The stale response is revalidated only if the client does
+	// not make the request conditional, otherwise the 304 response of
+	// the servers belongs to the client.
+	revalidating := e != nil && e.hasValidators() &&
+		r.Header().Get(keyIfNoneMatch) == "" && r.Header().Get(keyIfModifiedSince) == ""
+	if revalidating {
+		if etag := e.Header.Get(keyETag); etag != "" {
+			r.Header().Set(keyIfNoneMatch, etag)
+		}
+		if lm := e.Header.Get(keyLastModified); lm != "" {
+			r.Header().Set(keyIfModifiedSince, lm)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	now = time.Now()
+
+	if revalidating {
+		r.Header().Del(keyIfNoneMatch)
+		r.Header().Del(keyIfModifiedSince)
+
+		if w.StatusCode() == http.StatusNotModified {
+			respCC := parseCacheControl(w.Header().GetAll(httpheader.KeyCacheControl))
+			lifetime := rc.lifetime(r, w.Header().Std(), respCC)
+			e = e.update(w.Header().Std(), lifetime, now)
+			rc.store.set(rc.variantKey(key, e.Vary, r.Header()), e)
+
+			atomic.AddUint64(&rc.revalidated, 1)
+			rc.serve(ctx, e, now)
+			ctx.AddTag("cacheRevalidated")
+			return result
+		}
+	}
+
+	atomic.AddUint64(&rc.misses, 1)
+	rc.storeResponse(ctx, key, now)
+	return result
+}
+
+// wait waits for the leader of the flight, it returns false if the
+// request is cancelled.
+func (rc *ResponseCache) wait(ctx context.HTTPContext, done <-chan struct{}) bool {
+	timer := time.NewTimer(rc.coalesceTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (rc *ResponseCache) primaryKey(r context.HTTPRequest) string {
+	return stringtool.Cat(r.Method(), " ", r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query())
+}
+
+// variantKey returns the key of the response selected by the values of
+// the vary headers of the request.
+func (rc *ResponseCache) variantKey(key string, vary []string, h *httpheader.HTTPHeader) string {
+	if len(vary) == 0 {
+		return key
+	}
+
+	buff := &strings.Builder{}
+	buff.WriteString(key)
+	for _, name := range vary {
+		buff.WriteString("\n")
+		buff.WriteString(name)
+		buff.WriteString(": ")
+		buff.WriteString(strings.Join(h.GetAll(name), ","))
+	}
+	return buff.String()
+}
+
+func (rc *ResponseCache) lookup(key string, h *httpheader.HTTPHeader) *entry {
+	e := rc.store.get(key)
+	if e == nil || !e.Variants {
+		return e
+	}
+	return rc.store.get(rc.variantKey(key, e.Vary, h))
+}
+
+// invalidate removes the responses of the URL after it is changed by an
+// unsafe method successfully.
+// Reference: https://tools.ietf.org/html/rfc7234#section-4.4
+func (rc *ResponseCache) invalidate(ctx context.HTTPContext) {
+	switch ctx.Request().Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return
+	}
+	if code := ctx.Response().StatusCode(); code < 200 || code >= 400 {
+		return
+	}
+
+	r := ctx.Request()
+	for _, method := range rc.methods {
+		key := stringtool.Cat(method, " ", r.Scheme(), "://", r.Host(), r.Path(), "?", r.Query())
+		rc.store.del(key)
+	}
+}
+
+func (rc *ResponseCache) serve(ctx context.HTTPContext, e *entry, now time.Time) {
+	r, w := ctx.Request(), ctx.Response()
+
+	w.Header().Reset(e.Header.Clone())
+	w.Header().Set(keyAge, strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+
+	if e.notModified(r.Header()) {
+		w.Header().Del(httpheader.KeyContentLength)
+		w.SetStatusCode(http.StatusNotModified)
+		w.SetBody(nil)
+		return
+	}
+
+	w.SetStatusCode(e.StatusCode)
+	w.SetBody(bytes.NewReader(e.Body))
+}
+
+// lifetime returns the freshness lifetime of the response.
+func (rc *ResponseCache) lifetime(r context.HTTPRequest, h http.Header, cc cacheControl) time.Duration {
+	path := r.Path()
+	for _, o := range rc.spec.TTLOverrides {
+		if o.Path != "" && o.Path == path {
+			return o.ttl
+		}
+		if o.PathPrefix != "" && strings.HasPrefix(path, o.PathPrefix) {
+			return o.ttl
+		}
+	}
+
+	if d, ok := explicitLifetime(h, cc); ok {
+		return d
+	}
+	return rc.defaultTTL
+}
+
+// storable checks the response by the rules of storing responses.
+// Reference: https://tools.ietf.org/html/rfc7234#section-3
+func (rc *ResponseCache) storable(ctx context.HTTPContext, respCC cacheControl) bool {
+	r, w := ctx.Request(), ctx.Response()
+
+	code := w.StatusCode()
+	found := false
+	for _, c := range rc.codes {
+		if c == code {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	if respCC.has("no-store") || respCC.has("private") {
+		return false
+	}
+	// NOTE: The cookies are for the client of this request only, they
+	// must not be replayed to other clients.
+	if w.Header().Get(keySetCookie) != "" {
+		return false
+	}
+	if r.Header().Get(keyAuthorization) != "" &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+
+	for _, value := range w.Header().GetAll(httpheader.KeyVary) {
+		if strings.Contains(value, "*") {
+			return false
+		}
+	}
+
+	if strings.HasPrefix(w.Header().Get(httpheader.KeyContentType), "text/event-stream") {
+		return false
+	}
+
+	if cl, err := strconv.ParseInt(w.Header().Get(httpheader.KeyContentLength), 10, 64); err == nil && cl > rc.maxEntryBytes {
+		return false
+	}
+
+	return true
+}
+
+func (rc *ResponseCache) storeResponse(ctx context.HTTPContext, key string, now time.Time) {
+	r, w := ctx.Request(), ctx.Response()
+
+	respCC := parseCacheControl(w.Header().GetAll(httpheader.KeyCacheControl))
+	if !rc.storable(ctx, respCC) {
+		return
+	}
+
+	e := &entry{
+		Key:          key,
+		Vary:         varyHeaders(w.Header()),
+		StatusCode:   w.StatusCode(),
+		Header:       w.Header().Copy().Std(),
+		ResponseTime: now,
+		InitialAge:   initialAge(w.Header().Std(), now),
+		Lifetime:     rc.lifetime(r, w.Header().Std(), respCC),
+	}
+	// NOTE: Responses neither fresh nor revalidatable are useless.
+	if e.Lifetime <= 0 && !e.hasValidators() {
+		return
+	}
+
+	variantKey := rc.variantKey(key, e.Vary, r.Header())
+	var index *entry
+	if len(e.Vary) > 0 {
+		index = &entry{Key: key, Vary: e.Vary, Variants: true}
+	}
+	e.Key = variantKey
+
+	set := func() {
+		if index != nil {
+			rc.store.set(key, index)
+		}
+		rc.store.set(variantKey, e)
+		atomic.AddUint64(&rc.stored, 1)
+		ctx.AddTag("cacheStore")
+	}
+
+	// NOTE: The body flushing handlers are not called without body.
+	if w.Body() == nil {
+		set()
+		return
+	}
+
+	bodyLength := int64(0)
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		bodyLength += int64(len(body))
+		if bodyLength > rc.maxEntryBytes {
+			return body
+		}
+
+		e.Body = append(e.Body, body...)
+		if complete {
+			set()
+		}
+
+		return body
+	})
+}
+
+// varyHeaders returns the sorted canonical names of the Vary headers.
+func varyHeaders(h *httpheader.HTTPHeader) []string {
+	var names []string
+	for _, value := range h.GetAll(httpheader.KeyVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !stringtool.StrInSlice(name, names) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Status returns status.
+func (rc *ResponseCache) Status() interface{} {
+	entries, bytes := rc.store.stat()
+	return &Status{
+		Hits:        atomic.LoadUint64(&rc.hits),
+		Misses:      atomic.LoadUint64(&rc.misses),
+		Revalidated: atomic.LoadUint64(&rc.revalidated),
+		Stored:      atomic.LoadUint64(&rc.stored),
+		Bypassed:    atomic.LoadUint64(&rc.bypassed),
+		Coalesced:   atomic.LoadUint64(&rc.coalesced),
+		Entries:     entries,
+		Bytes:       bytes,
+	}
+}
+
+// Close closes ResponseCache.
+func (rc *ResponseCache) Close() {
+	rc.store.close()
+}
@@ -0,0 +1,497 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type upstream struct {
+	calls   int32
+	handler func(req *http.Request, w context.HTTPResponse)
+}
+
+func (u *upstream) do(rc *ResponseCache, method, url string, header http.Header) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	result, w := httppipelinetest.Handle(rc, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult == "" {
+			atomic.AddInt32(&u.calls, 1)
+			u.handler(ctx.Request().Std(), ctx.Response())
+		}
+		return lastResult
+	})
+	return w, result
+}
+
+func TestCacheHit(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	w, result := u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if result != "" || w.Body.String() != "hello" {
+		t.Fatalf("unexpected response: %s %s", result, w.Body.String())
+	}
+
+	w, result = u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if result != resultCached || w.Body.String() != "hello" || w.Header().Get("Age") == "" {
+		t.Fatalf("response should be cached: %s %s", result, w.Body.String())
+	}
+	if u.calls != 1 {
+		t.Errorf("upstream should be called once, got %d", u.calls)
+	}
+
+	// Requests of other URLs are not served by the cache.
+	u.do(rc, http.MethodGet, "http://example.com/a?x=1", nil)
+	if u.calls != 2 {
+		t.Errorf("upstream should be called twice, got %d", u.calls)
+	}
+
+	// The client requires revalidation but there are no validators.
+	u.do(rc, http.MethodGet, "http://example.com/a", http.Header{"Cache-Control": {"no-cache"}})
+	if u.calls != 3 {
+		t.Errorf("upstream should be called 3 times, got %d", u.calls)
+	}
+
+	// Unsafe methods invalidate the cache.
+	u.do(rc, http.MethodPost, "http://example.com/a", nil)
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if u.calls != 5 {
+		t.Errorf("upstream should be called 5 times, got %d", u.calls)
+	}
+
+	s := rc.Status().(*Status)
+	if s.Hits != 1 || s.Misses != 4 || s.Stored != 4 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestNotStored(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+maxEntryBytes: 4
+`).(*ResponseCache)
+	defer rc.Close()
+
+	cases := []struct {
+		header http.Header
+		body   string
+		reqCC  string
+	}{
+		{header: http.Header{"Cache-Control": {"no-store"}}, body: "a"},
+		{header: http.Header{"Cache-Control": {"private, max-age=60"}}, body: "a"},
+		{header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, body: "a"},
+		{header: http.Header{"Cache-Control": {"public, max-age=60"}, "Set-Cookie": {"session=1"}}, body: "a"},
+		{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "too large"},
+		{header: http.Header{}, body: "a"},
+		{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "a", reqCC: "no-store"},
+	}
+
+	for i, c := range cases {
+		u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+			for k, v := range c.header {
+				w.Header().Std()[k] = v
+			}
+			w.SetBody(strings.NewReader(c.body))
+		}}
+		header := http.Header{}
+		if c.reqCC != "" {
+			header.Set("Cache-Control", c.reqCC)
+		}
+		u.do(rc, http.MethodGet, "http://example.com/a", header)
+		u.do(rc, http.MethodGet, "http://example.com/a", header)
+		if u.calls != 2 {
+			t.Errorf("case %d: response should not be cached", i)
+		}
+	}
+}
+
+func TestVary(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.SetBody(strings.NewReader(req.Header.Get("Accept-Language")))
+	}}
+
+	for i := 0; i < 2; i++ {
+		for _, lang := range []string{"en", "zh"} {
+			w, _ := u.do(rc, http.MethodGet, "http://example.com/a", http.Header{"Accept-Language": {lang}})
+			if w.Body.String() != lang {
+				t.Errorf("response should be %s, got %s", lang, w.Body.String())
+			}
+		}
+	}
+	if u.calls != 2 {
+		t.Errorf("upstream should be called twice, got %d", u.calls)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.SetStatusCode(http.StatusNotModified)
+			return
+		}
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	w, _ := u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("revalidated response should be served, got %d %s", w.Code, w.Body.String())
+	}
+	if s := rc.Status().(*Status); s.Revalidated != 1 {
+		t.Errorf("response should be revalidated: %+v", s)
+	}
+
+	// The conditional request of the client is for the client itself.
+	w, _ = u.do(rc, http.MethodGet, "http://example.com/a", http.Header{"If-None-Match": {`"v1"`}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status code should be 304, got %d", w.Code)
+	}
+	if u.calls != 3 {
+		t.Errorf("upstream should be called 3 times, got %d", u.calls)
+	}
+}
+
+func TestNotModifiedHit(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `W/"v1"`)
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	w, result := u.do(rc, http.MethodGet, "http://example.com/a", http.Header{"If-None-Match": {`"v0", "v1"`}})
+	if result != resultCached || w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("status code should be 304, got %d", w.Code)
+	}
+}
+
+func TestOnlyIfCached(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {}}
+	w, result := u.do(rc, http.MethodGet, "http://example.com/a", http.Header{"Cache-Control": {"only-if-cached"}})
+	if result != resultUncached || w.Code != http.StatusGatewayTimeout || u.calls != 0 {
+		t.Errorf("status code should be 504, got %d", w.Code)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+defaultTTL: 1m
+ttlOverrides:
+- pathPrefix: /short/
+  ttl: 0s
+`).(*ResponseCache)
+	defer rc.Close()
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Last-Modified", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		if req.Header.Get("If-Modified-Since") != "" {
+			w.SetStatusCode(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if u.calls != 1 {
+		t.Errorf("upstream should be called once, got %d", u.calls)
+	}
+
+	u.calls = 0
+	u.do(rc, http.MethodGet, "http://example.com/short/a", nil)
+	w, _ := u.do(rc, http.MethodGet, "http://example.com/short/a", nil)
+	if u.calls != 2 || w.Body.String() != "hello" {
+		t.Errorf("response should be revalidated, got %d calls", u.calls)
+	}
+}
+
+func TestEntryServable(t *testing.T) {
+	now := time.Now()
+	e := &entry{
+		Header:       http.Header{},
+		ResponseTime: now.Add(-30 * time.Second),
+		Lifetime:     20 * time.Second,
+	}
+
+	cases := []struct {
+		reqCC    string
+		servable bool
+	}{
+		{"", false},
+		{"max-stale", true},
+		{"max-stale=5", false},
+		{"max-stale=20", true},
+		{"max-age=10, max-stale", false},
+	}
+	for _, c := range cases {
+		cc := parseCacheControl([]string{c.reqCC})
+		if e.servable(cc, now) != c.servable {
+			t.Errorf("servable of %q should be %v", c.reqCC, c.servable)
+		}
+	}
+
+	e.Header.Set("Cache-Control", "must-revalidate")
+	if e.servable(parseCacheControl([]string{"max-stale"}), now) {
+		t.Error("stale response should not be served after must-revalidate")
+	}
+
+	e.Lifetime = time.Minute
+	if e.servable(parseCacheControl([]string{"min-fresh=40"}), now) {
+		t.Error("response should not be fresh enough")
+	}
+}
+
+func TestExplicitLifetime(t *testing.T) {
+	now := time.Now().UTC()
+	h := http.Header{
+		"Date":    {now.Format(http.TimeFormat)},
+		"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+	}
+	if d, ok := explicitLifetime(h, cacheControl{}); !ok || d != time.Hour {
+		t.Errorf("lifetime should be 1h, got %v", d)
+	}
+
+	cc := parseCacheControl([]string{"max-age=10, s-maxage=20"})
+	if d, _ := explicitLifetime(h, cc); d != 20*time.Second {
+		t.Errorf("lifetime should be 20s, got %v", d)
+	}
+
+	h.Set("Expires", "0")
+	if d, ok := explicitLifetime(h, cacheControl{}); !ok || d != 0 {
+		t.Errorf("lifetime should be 0, got %v", d)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	s := newMemoryStore(100)
+	for _, key := range []string{"a", "b", "c"} {
+		s.set(key, &entry{Key: key, Body: make([]byte, 40)})
+	}
+	if s.get("a") != nil {
+		t.Error("a should be evicted")
+	}
+	if n, _ := s.stat(); n != 2 {
+		t.Errorf("there should be 2 entries, got %d", n)
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+backend: disk
+dir: `+dir+`
+`).(*ResponseCache)
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.SetBody(strings.NewReader("hello"))
+	}}
+	u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	rc.Close()
+
+	// The entries are kept for the next generation.
+	rc = httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+backend: disk
+dir: `+dir+`
+`).(*ResponseCache)
+	defer rc.Close()
+
+	w, result := u.do(rc, http.MethodGet, "http://example.com/a", nil)
+	if result != resultCached || w.Body.String() != "hello" || u.calls != 1 {
+		t.Errorf("response should be loaded from disk: %s %s", result, w.Body.String())
+	}
+
+	s, err := newDiskStore(dir, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.set("b", &entry{Key: "b"})
+	if s.get("a") != nil || s.get("b") == nil {
+		t.Error("a should be evicted")
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("there should be 1 file, got %d", len(files))
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer mr.Close()
+
+	yamlSpec := `
+kind: ResponseCache
+name: cache
+backend: redis
+redis:
+  addrs: [` + mr.Addr() + `]
+`
+
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	// Responses are shared by the instances of Easegress.
+	rc1 := httppipelinetest.InitFilter(t, &ResponseCache{}, yamlSpec).(*ResponseCache)
+	defer rc1.Close()
+	rc2 := httppipelinetest.InitFilter(t, &ResponseCache{}, yamlSpec).(*ResponseCache)
+	defer rc2.Close()
+
+	u.do(rc1, http.MethodGet, "http://example.com/a", nil)
+	w, result := u.do(rc2, http.MethodGet, "http://example.com/a", nil)
+	if result != resultCached || w.Body.String() != "hello" || u.calls != 1 {
+		t.Errorf("response should be loaded from redis: %s %s", result, w.Body.String())
+	}
+
+	keys := mr.Keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "easegress:responsecache:cache:") {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if ttl := mr.TTL(keys[0]); ttl != time.Minute {
+		t.Errorf("ttl should be 1m, got %v", ttl)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	u.do(rc2, http.MethodGet, "http://example.com/a", nil)
+	if u.calls != 2 {
+		t.Errorf("response should be expired")
+	}
+}
+
+func TestExternalExpiration(t *testing.T) {
+	s := &externalStore{retention: time.Hour}
+	if d := s.expiration(&entry{Lifetime: time.Minute}); d != time.Minute {
+		t.Errorf("expiration should be 1m, got %v", d)
+	}
+	e := &entry{Lifetime: time.Minute, Header: http.Header{"Etag": {`"v1"`}}}
+	if d := s.expiration(e); d != time.Hour+time.Minute {
+		t.Errorf("expiration should be 1h1m, got %v", d)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	rc := httppipelinetest.InitFilter(t, &ResponseCache{}, `
+kind: ResponseCache
+name: cache
+coalesce: true
+`).(*ResponseCache)
+	defer rc.Close()
+
+	release := make(chan struct{})
+	u := &upstream{handler: func(req *http.Request, w context.HTTPResponse) {
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.SetBody(strings.NewReader("hello"))
+	}}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, _ := u.do(rc, http.MethodGet, "http://example.com/a", nil)
+			if w.Body.String() != "hello" {
+				t.Errorf("unexpected body: %s", w.Body.String())
+			}
+		}()
+	}
+
+	for i := 0; i < 100 && atomic.LoadInt32(&u.calls) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if u.calls != 1 {
+		t.Errorf("upstream should be called once, got %d", u.calls)
+	}
+	if s := rc.Status().(*Status); s.Coalesced != 4 {
+		t.Errorf("4 requests should be coalesced: %+v", s)
+	}
+}
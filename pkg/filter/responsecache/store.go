@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responsecache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const tempFilePrefix = ".tmp-"
+
+type (
+	// store is the storage backend of cached responses.
+	store interface {
+		get(key string) *entry
+		set(key string, e *entry)
+		del(key string)
+		// stat returns the number of entries and the bytes they take.
+		stat() (int, int64)
+		close()
+	}
+
+	// lru is an index of entries, it evicts the least recently used
+	// entries when the total size exceeds the capacity.
+	lru struct {
+		mutex    sync.Mutex
+		capacity int64
+		size     int64
+		list     *list.List
+		items    map[string]*list.Element
+		onEvict  func(item *lruItem)
+	}
+
+	lruItem struct {
+		key   string
+		size  int64
+		value *entry
+	}
+
+	memoryStore struct {
+		index *lru
+	}
+
+	diskStore struct {
+		dir   string
+		index *lru
+	}
+)
+
+// hashKey returns the fixed length hash of the key, which is safe to
+// be a file name or the key of external backends.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newLRU(capacity int64, onEvict func(item *lruItem)) *lru {
+	return &lru{
+		capacity: capacity,
+		list:     list.New(),
+		items:    make(map[string]*list.Element),
+		onEvict:  onEvict,
+	}
+}
+
+func (l *lru) get(key string) (*lruItem, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.list.MoveToFront(elem)
+	return elem.Value.(*lruItem), true
+}
+
+func (l *lru) add(item *lruItem) {
+	l.mutex.Lock()
+	var evicted []*lruItem
+	if elem, ok := l.items[item.key]; ok {
+		l.size -= elem.Value.(*lruItem).size
+		elem.Value = item
+		l.list.MoveToFront(elem)
+	} else {
+		l.items[item.key] = l.list.PushFront(item)
+	}
+	l.size += item.size
+
+	for l.size > l.capacity && l.list.Len() > 1 {
+		elem := l.list.Back()
+		evicted = append(evicted, l.removeElement(elem))
+	}
+	l.mutex.Unlock()
+
+	if l.onEvict != nil {
+		for _, item := range evicted {
+			l.onEvict(item)
+		}
+	}
+}
+
+func (l *lru) remove(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elem, ok := l.items[key]; ok {
+		l.removeElement(elem)
+	}
+}
+
+func (l *lru) removeElement(elem *list.Element) *lruItem {
+	item := elem.Value.(*lruItem)
+	l.list.Remove(elem)
+	delete(l.items, item.key)
+	l.size -= item.size
+	return item
+}
+
+func (l *lru) stat() (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.list.Len(), l.size
+}
+
+func newMemoryStore(capacity int64) *memoryStore {
+	return &memoryStore{index: newLRU(capacity, nil)}
+}
+
+func (s *memoryStore) get(key string) *entry {
+	item, ok := s.index.get(key)
+	if !ok {
+		return nil
+	}
+	return item.value
+}
+
+func (s *memoryStore) set(key string, e *entry) {
+	s.index.add(&lruItem{key: key, size: e.size(), value: e})
+}
+
+func (s *memoryStore) del(key string) {
+	s.index.remove(key)
+}
+
+func (s *memoryStore) stat() (int, int64) {
+	return s.index.stat()
+}
+
+func (s *memoryStore) close() {}
+
+// newDiskStore creates a disk store, the entries left in the directory
+// by the previous generation are loaded to the index.
+func newDiskStore(dir string, capacity int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	s := &diskStore{dir: dir}
+	s.index = newLRU(capacity, func(item *lruItem) {
+		s.remove(item.key)
+	})
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type fileInfo struct {
+		name string
+		info os.FileInfo
+	}
+	infos := make([]*fileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(file.Name(), tempFilePrefix) {
+			s.remove(file.Name())
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, &fileInfo{name: file.Name(), info: info})
+	}
+
+	// NOTE: The least recently written entries are evicted first.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].info.ModTime().Before(infos[j].info.ModTime())
+	})
+	for _, fi := range infos {
+		s.index.add(&lruItem{key: fi.name, size: fi.info.Size()})
+	}
+
+	return s, nil
+}
+
+func (s *diskStore) remove(name string) {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("remove cache file %s failed: %v", name, err)
+	}
+}
+
+func (s *diskStore) get(key string) *entry {
+	name := hashKey(key)
+	if _, ok := s.index.get(name); !ok {
+		return nil
+	}
+
+	buff, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		s.index.remove(name)
+		return nil
+	}
+
+	e := &entry{}
+	if err := json.Unmarshal(buff, e); err != nil || e.Key != key {
+		if err != nil {
+			logger.Warnf("unmarshal cache file %s failed: %v", name, err)
+		}
+		return nil
+	}
+	return e
+}
+
+func (s *diskStore) set(key string, e *entry) {
+	buff, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("BUG: marshal cache entry failed: %v", err)
+		return
+	}
+
+	f, err := os.CreateTemp(s.dir, tempFilePrefix)
+	if err != nil {
+		logger.Warnf("create cache file failed: %v", err)
+		return
+	}
+	_, err = f.Write(buff)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logger.Warnf("write cache file failed: %v", err)
+		os.Remove(f.Name())
+		return
+	}
+
+	// NOTE: Rename is atomic, readers never see a partial entry.
+	name := hashKey(key)
+	if err = os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		logger.Warnf("rename cache file failed: %v", err)
+		os.Remove(f.Name())
+		return
+	}
+	s.index.add(&lruItem{key: name, size: int64(len(buff))})
+}
+
+func (s *diskStore) del(key string) {
+	name := hashKey(key)
+	s.index.remove(name)
+	s.remove(name)
+}
+
+func (s *diskStore) stat() (int, int64) {
+	return s.index.stat()
+}
+
+func (s *diskStore) close() {}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package retryer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultBudgetRatio               = 0.2
+	defaultBudgetMinRetriesPerSecond = 10
+	defaultBudgetWindow              = 10 * time.Second
+)
+
+type (
+	// BudgetSpec is the spec of the retry budget, which limits the
+	// number of retries to a ratio of the number of requests in a
+	// sliding window, so that retries could not amplify an outage.
+	BudgetSpec struct {
+		Ratio               float64 `yaml:"ratio" jsonschema:"omitempty,minimum=0,maximum=1"`
+		MinRetriesPerSecond int     `yaml:"minRetriesPerSecond" jsonschema:"omitempty,minimum=0"`
+		Window              string  `yaml:"window" jsonschema:"omitempty,format=duration"`
+	}
+
+	budget struct {
+		ratio      float64
+		minRetries int
+
+		mutex   sync.Mutex
+		buckets []budgetBucket
+	}
+
+	// budgetBucket records the requests and retries in one second.
+	budgetBucket struct {
+		second   int64
+		requests int
+		retries  int
+	}
+)
+
+// for unit testing cases to mock 'time.Now' only
+var nowFunc = time.Now
+
+func newBudget(spec *BudgetSpec) *budget {
+	ratio := spec.Ratio
+	if ratio <= 0 {
+		ratio = defaultBudgetRatio
+	}
+
+	minRetriesPerSecond := spec.MinRetriesPerSecond
+	if minRetriesPerSecond <= 0 {
+		minRetriesPerSecond = defaultBudgetMinRetriesPerSecond
+	}
+
+	window := defaultBudgetWindow
+	if spec.Window != "" {
+		if d, err := time.ParseDuration(spec.Window); err == nil && d >= time.Second {
+			window = d
+		}
+	}
+
+	seconds := int(window / time.Second)
+	return &budget{
+		ratio:      ratio,
+		minRetries: minRetriesPerSecond * seconds,
+		buckets:    make([]budgetBucket, seconds),
+	}
+}
+
+func (b *budget) _bucket(second int64) *budgetBucket {
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+// onRequest records a new request.
+func (b *budget) onRequest() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b._bucket(nowFunc().Unix()).requests++
+}
+
+// tryRetry records a retry and returns true if there's budget left,
+// or returns false if the budget is exhausted.
+func (b *budget) tryRetry() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := nowFunc().Unix()
+	requests, retries := 0, 0
+	for i := range b.buckets {
+		bucket := &b.buckets[i]
+		if now-bucket.second < int64(len(b.buckets)) {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := int(float64(requests) * b.ratio)
+	if allowed < b.minRetries {
+		allowed = b.minRetries
+	}
+	if retries >= allowed {
+		return false
+	}
+
+	b._bucket(now).retries++
+	return true
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"reflect"
 	"strings"
 	"time"
 
@@ -54,8 +56,13 @@ type (
 		BackOffPolicy        string  `yaml:"backOffPolicy" jsonschema:"omitempty,enum=random,enum=exponential"`
 		RandomizationFactor  float64 `yaml:"randomizationFactor" jsonschema:"omitempty,minimum=0,maximum=1"`
 		backOffPolicy        backOffPolicy
-		CountingNetworkError bool  `yaml:"countingNetworkError" jsonschema:"omitempty"`
-		FailureStatusCodes   []int `yaml:"failureStatusCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		CountingNetworkError bool   `yaml:"countingNetworkError" jsonschema:"omitempty"`
+		FailureStatusCodes   []int  `yaml:"failureStatusCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		MaxWaitDuration      string `yaml:"maxWaitDuration" jsonschema:"omitempty,format=duration"`
+		maxWaitDuration      time.Duration
+		PerTryTimeout        string `yaml:"perTryTimeout" jsonschema:"omitempty,format=duration"`
+		perTryTimeout        time.Duration
+		RetryableMethods     []string `yaml:"retryableMethods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
 	}
 
 	// URLRule is the URL rule
@@ -66,15 +73,17 @@ type (
 
 	// Spec is the spec of retryer
 	Spec struct {
-		Policies         []*Policy  `yaml:"policies" jsonschema:"required"`
-		DefaultPolicyRef string     `yaml:"defaultPolicyRef" jsonschema:"omitempty"`
-		URLs             []*URLRule `yaml:"urls" jsonschema:"required"`
+		Policies         []*Policy   `yaml:"policies" jsonschema:"required"`
+		DefaultPolicyRef string      `yaml:"defaultPolicyRef" jsonschema:"omitempty"`
+		URLs             []*URLRule  `yaml:"urls" jsonschema:"required"`
+		Budget           *BudgetSpec `yaml:"budget,omitempty" jsonschema:"omitempty"`
 	}
 
 	// Retryer is the struct of retryer
 	Retryer struct {
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
+		budget     *budget
 	}
 )
 
@@ -156,6 +165,14 @@ func (r *Retryer) initURL(u *URLRule) {
 	} else {
 		u.policy.waitDuration = time.Millisecond * 500
 	}
+
+	if d := u.policy.MaxWaitDuration; d != "" {
+		u.policy.maxWaitDuration, _ = time.ParseDuration(d)
+	}
+
+	if d := u.policy.PerTryTimeout; d != "" {
+		u.policy.perTryTimeout, _ = time.ParseDuration(d)
+	}
 }
 
 // Init initializes Retryer.
@@ -165,14 +182,70 @@ func (r *Retryer) Init(filterSpec *httppipeline.FilterSpec) {
 	for _, url := range r.spec.URLs {
 		r.initURL(url)
 	}
+
+	if r.spec.Budget != nil {
+		r.budget = newBudget(r.spec.Budget)
+	}
 }
 
 // Inherit inherits previous generation of Retryer.
 func (r *Retryer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
 	r.Init(filterSpec)
+
+	// NOTE: Keep the sliding window of the budget if it's not changed,
+	// otherwise an update allows a burst of retries in an outage.
+	prev := previousGeneration.(*Retryer)
+	if prev.budget != nil && reflect.DeepEqual(r.spec.Budget, prev.spec.Budget) {
+		r.budget = prev.budget
+	}
+}
+
+func (p *Policy) isRetryableMethod(method string) bool {
+	if len(p.RetryableMethods) == 0 {
+		return true
+	}
+	for _, m := range p.RetryableMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) isFailure(statusCode int) bool {
+	if p.CountingNetworkError && context.IsNetworkError(statusCode) {
+		return true
+	}
+
+	// NOTE: The proxy responds 504 when an attempt times out.
+	if p.perTryTimeout > 0 && statusCode == http.StatusGatewayTimeout {
+		return true
+	}
+
+	for _, c := range p.FailureStatusCodes {
+		if statusCode == c {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (r *Retryer) handle(ctx context.HTTPContext, u *URLRule) string {
+	if !u.policy.isRetryableMethod(ctx.Request().Method()) {
+		return ctx.CallNextHandler("")
+	}
+
+	if r.budget != nil {
+		r.budget.onRequest()
+	}
+
+	if u.policy.perTryTimeout > 0 {
+		timeout := ctx.UpstreamTimeout()
+		ctx.SetUpstreamTimeout(u.policy.perTryTimeout)
+		defer ctx.SetUpstreamTimeout(timeout)
+	}
+
 	attempt := 0
 	base := float64(u.policy.waitDuration)
 
@@ -183,18 +256,7 @@ func (r *Retryer) handle(ctx context.HTTPContext, u *URLRule) string {
 
 		result := ctx.CallNextHandler("")
 
-		statusCode := ctx.Response().StatusCode()
-		hasErr := u.policy.CountingNetworkError && context.IsNetworkError(statusCode)
-		if !hasErr {
-			for _, c := range u.policy.FailureStatusCodes {
-				if statusCode == c {
-					hasErr = true
-					break
-				}
-			}
-		}
-
-		if !hasErr {
+		if !u.policy.isFailure(ctx.Response().StatusCode()) {
 			ctx.AddTag(fmt.Sprintf("retryer: succeeded after %d attempts", attempt))
 			ctx.Response().Std().Header().Set("X-Mesh-Retryer", fmt.Sprintf("Succeeded-after-%d-attempts", attempt))
 			return result
@@ -214,6 +276,12 @@ func (r *Retryer) handle(ctx context.HTTPContext, u *URLRule) string {
 			return result
 		}
 
+		if r.budget != nil && !r.budget.tryRetry() {
+			ctx.AddTag(fmt.Sprintf("retryer: retry budget exhausted after %d attempts", attempt))
+			ctx.Response().Std().Header().Set("X-EG-Retryer", "Budget-exhausted")
+			return result
+		}
+
 		delta := base * u.policy.RandomizationFactor
 		d := base - delta + float64(rand.Intn(int(delta*2+1)))
 		timer := time.NewTimer(time.Duration(d))
@@ -228,6 +296,9 @@ func (r *Retryer) handle(ctx context.HTTPContext, u *URLRule) string {
 		if u.policy.backOffPolicy == exponentiallyBackOff {
 			base *= 1.5
 		}
+		if max := float64(u.policy.maxWaitDuration); max > 0 && base > max {
+			base = max
+		}
 	}
 }
 
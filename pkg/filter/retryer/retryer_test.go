@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package retryer
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newContext(method string, statusCodes ...int) (*contexttest.MockedHTTPContext, *int) {
+	attempts := 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string {
+		return method
+	}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/retry"
+	}
+	ctx.MockedRequest.MockedBody = func() io.Reader {
+		return strings.NewReader("body")
+	}
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		attempts++
+		return ""
+	}
+	ctx.MockedResponse.MockedStatusCode = func() int {
+		if attempts <= len(statusCodes) {
+			return statusCodes[attempts-1]
+		}
+		return http.StatusOK
+	}
+	return ctx, &attempts
+}
+
+func TestRetryer(t *testing.T) {
+	r := httppipelinetest.InitFilter(t, &Retryer{}, `
+kind: Retryer
+name: retryer
+policies:
+- name: default
+  maxAttempts: 3
+  waitDuration: 1ms
+  maxWaitDuration: 2ms
+  backOffPolicy: exponential
+  randomizationFactor: 0.5
+  failureStatusCodes: [500]
+  retryableMethods: [GET]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /retry
+`).(*Retryer)
+
+	if r.spec.URLs[0].policy.maxWaitDuration != 2*time.Millisecond {
+		t.Error("max wait duration is not the value in spec")
+	}
+
+	ctx, attempts := newContext(http.MethodGet, 500, 500)
+	r.Handle(ctx)
+	if *attempts != 3 {
+		t.Errorf("should succeed after 3 attempts, got %d", *attempts)
+	}
+
+	ctx, attempts = newContext(http.MethodGet, 500, 500, 500, 500)
+	r.Handle(ctx)
+	if *attempts != 3 {
+		t.Errorf("should fail after max attempts, got %d", *attempts)
+	}
+
+	ctx, attempts = newContext(http.MethodPost, 500, 500)
+	r.Handle(ctx)
+	if *attempts != 1 {
+		t.Errorf("POST is not retryable, got %d attempts", *attempts)
+	}
+}
+
+func TestPerTryTimeout(t *testing.T) {
+	r := httppipelinetest.InitFilter(t, &Retryer{}, `
+kind: Retryer
+name: retryer
+policies:
+- name: default
+  maxAttempts: 2
+  waitDuration: 1ms
+  backOffPolicy: random
+  perTryTimeout: 100ms
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /retry
+`).(*Retryer)
+
+	ctx, attempts := newContext(http.MethodGet, http.StatusGatewayTimeout)
+	var timeout time.Duration
+	next := ctx.MockedCallNextHandler
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		timeout = ctx.UpstreamTimeout()
+		return next(lastResult)
+	}
+
+	r.Handle(ctx)
+	if timeout != 100*time.Millisecond {
+		t.Errorf("upstream timeout should be 100ms, got %v", timeout)
+	}
+	if *attempts != 2 {
+		t.Errorf("timed out attempt should be retried, got %d attempts", *attempts)
+	}
+	if ctx.UpstreamTimeout() != 0 {
+		t.Error("upstream timeout should be restored")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	b := newBudget(&BudgetSpec{Ratio: 0.5, MinRetriesPerSecond: 1, Window: "2s"})
+	for i := 0; i < 10; i++ {
+		b.onRequest()
+	}
+
+	retries := 0
+	for b.tryRetry() {
+		retries++
+	}
+	if retries != 5 {
+		t.Errorf("should allow 5 retries, got %d", retries)
+	}
+
+	// the minimum retries are allowed after the window slides.
+	now = now.Add(2 * time.Second)
+	retries = 0
+	for b.tryRetry() {
+		retries++
+	}
+	if retries != 2 {
+		t.Errorf("should allow 2 retries, got %d", retries)
+	}
+}
+
+func TestBudgetExhausted(t *testing.T) {
+	r := httppipelinetest.InitFilter(t, &Retryer{}, `
+kind: Retryer
+name: retryer
+policies:
+- name: default
+  maxAttempts: 3
+  waitDuration: 1ms
+  backOffPolicy: random
+  failureStatusCodes: [500]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /retry
+budget:
+  ratio: 0.1
+  minRetriesPerSecond: 1
+  window: 1s
+`).(*Retryer)
+
+	ctx, attempts := newContext(http.MethodGet, 500, 500, 500)
+	r.Handle(ctx)
+	if *attempts != 2 {
+		t.Errorf("only 1 retry is allowed by the budget, got %d attempts", *attempts)
+	}
+}
+
+func TestInheritBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	const yamlSpec = `
+kind: Retryer
+name: retryer
+policies:
+- name: default
+  maxAttempts: 2
+  waitDuration: 1ms
+  backOffPolicy: random
+  failureStatusCodes: [500]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /retry
+budget:
+  ratio: 0.1
+  minRetriesPerSecond: 1
+  window: 1s
+`
+	r := httppipelinetest.InitFilter(t, &Retryer{}, yamlSpec).(*Retryer)
+	ctx, attempts := newContext(http.MethodGet, 500, 500)
+	r.Handle(ctx)
+	if *attempts != 2 {
+		t.Fatalf("1 retry is allowed by the budget, got %d attempts", *attempts)
+	}
+
+	// the budget is kept, so it's still exhausted.
+	r1 := &Retryer{}
+	r1.Inherit(httppipelinetest.NewFilterSpec(t, yamlSpec), r)
+	ctx, attempts = newContext(http.MethodGet, 500, 500)
+	r1.Handle(ctx)
+	if *attempts != 1 {
+		t.Errorf("budget should be inherited, got %d attempts", *attempts)
+	}
+
+	// the budget is recreated as it's changed.
+	r2 := &Retryer{}
+	r2.Inherit(httppipelinetest.NewFilterSpec(t, strings.Replace(yamlSpec, "ratio: 0.1", "ratio: 0.2", 1)), r1)
+	ctx, attempts = newContext(http.MethodGet, 500, 500)
+	r2.Handle(ctx)
+	if *attempts != 2 {
+		t.Errorf("budget should be recreated, got %d attempts", *attempts)
+	}
+}
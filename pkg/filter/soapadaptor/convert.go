@@ -0,0 +1,470 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package soapadaptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+	xsiNamespace    = "http://www.w3.org/2001/XMLSchema-instance"
+
+	// attrPrefix and textKey are the conventions to represent the
+	// attributes and the text of the elements having attributes in JSON.
+	attrPrefix = "@"
+	textKey    = "#text"
+
+	maxDepth = 64
+
+	envelopePrefix    = "soap"
+	unqualifiedPrefix = "ns"
+)
+
+type (
+	// element is a simplified XML element, the namespaces of the child
+	// elements are decided by the schema when they are written.
+	element struct {
+		name     string
+		attrs    []xml.Attr
+		text     string
+		nil      bool
+		children []*element
+	}
+
+	// envelope is the parsed SOAP envelope.
+	envelope struct {
+		version string
+		// body is the first element of the SOAP body.
+		body *xmlquery.Node
+	}
+
+	fault struct {
+		code   string
+		reason string
+		detail string
+	}
+)
+
+// fromJSON converts the JSON value to the child elements of parent, the
+// order of the members of the objects is kept.
+func fromJSON(data []byte, parent *element) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	t, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("json body must be an object")
+	}
+	return decodeObject(decoder, parent, 0)
+}
+
+func decodeObject(decoder *json.Decoder, e *element, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("depth exceeds %d", maxDepth)
+	}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := t.(string)
+		if err = decodeMember(decoder, e, key, depth); err != nil {
+			return err
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+func decodeMember(decoder *json.Decoder, e *element, key string, depth int) error {
+	t, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(key, attrPrefix):
+		if _, ok := t.(json.Delim); ok {
+			return fmt.Errorf("attribute %s must be a primitive value", key)
+		}
+		e.attrs = append(e.attrs, xml.Attr{Name: xml.Name{Local: key[len(attrPrefix):]}, Value: primitive(t)})
+		return nil
+	case key == textKey:
+		if _, ok := t.(json.Delim); ok {
+			return fmt.Errorf("%s must be a primitive value", textKey)
+		}
+		e.text = primitive(t)
+		return nil
+	}
+
+	if t == json.Delim('[') {
+		for decoder.More() {
+			t, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			if err = decodeValue(decoder, e, key, t, depth); err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+		return err
+	}
+	return decodeValue(decoder, e, key, t, depth)
+}
+
+func decodeValue(decoder *json.Decoder, parent *element, name string, t json.Token, depth int) error {
+	if t == nil {
+		return nil
+	}
+	c := &element{name: name}
+	parent.children = append(parent.children, c)
+
+	switch t {
+	case json.Delim('{'):
+		return decodeObject(decoder, c, depth+1)
+	case json.Delim('['):
+		return fmt.Errorf("nested array %s is not supported", name)
+	}
+	c.text = primitive(t)
+	return nil
+}
+
+func primitive(t json.Token) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprint(t)
+}
+
+// fromNode converts the XML node to an element.
+func fromNode(n *xmlquery.Node) *element {
+	e := &element{name: n.Data}
+	for _, a := range n.Attr {
+		switch {
+		case a.Name.Space == "xmlns" || a.Name.Local == "xmlns":
+		case a.NamespaceURI == xsiNamespace && a.Name.Local == "nil":
+			e.nil = a.Value == "true" || a.Value == "1"
+		default:
+			e.attrs = append(e.attrs, xml.Attr{Name: xml.Name{Local: a.Name.Local}, Value: a.Value})
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case xmlquery.ElementNode:
+			e.children = append(e.children, fromNode(c))
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			e.text += c.Data
+		}
+	}
+	if len(e.children) > 0 {
+		e.text = ""
+	}
+	return e
+}
+
+// toJSON converts the element to a JSON value, the declaration of the
+// element, which could be nil, decides the types of the values and
+// whether the child elements are arrays.
+func (s *schema) toJSON(e *element, decl *xsdElement) interface{} {
+	if e.nil {
+		return nil
+	}
+
+	if len(e.children) == 0 && len(e.attrs) == 0 {
+		if decl == nil || s.children(decl) == nil {
+			return typedValue(e.text, decl)
+		}
+	}
+
+	m := map[string]interface{}{}
+	for _, a := range e.attrs {
+		m[attrPrefix+a.Name.Local] = a.Value
+	}
+	if len(e.children) == 0 && strings.TrimSpace(e.text) != "" {
+		m[textKey] = typedValue(e.text, decl)
+	}
+
+	for _, c := range e.children {
+		var cdecl *xsdElement
+		if decl != nil {
+			cdecl = s.child(decl, c.name)
+		}
+		v := s.toJSON(c, cdecl)
+
+		existing, ok := m[c.name]
+		switch {
+		case cdecl != nil && isRepeated(cdecl) && !ok:
+			m[c.name] = []interface{}{v}
+		case !ok:
+			m[c.name] = v
+		default:
+			if l, isList := existing.([]interface{}); isList {
+				m[c.name] = append(l, v)
+			} else {
+				m[c.name] = []interface{}{existing, v}
+			}
+		}
+	}
+
+	// NOTE: The repeated elements absent are empty arrays, so the
+	// clients don't need to check the types.
+	if decl != nil {
+		for _, c := range s.children(decl) {
+			if _, ok := m[c.Name]; !ok && isRepeated(c) {
+				m[c.Name] = []interface{}{}
+			}
+		}
+	}
+	return m
+}
+
+func typedValue(text string, decl *xsdElement) interface{} {
+	if decl == nil {
+		return text
+	}
+	v := strings.TrimSpace(text)
+	switch {
+	case isNumber(decl.Type):
+		if v != "" {
+			return json.Number(v)
+		}
+	case isBoolean(decl.Type):
+		return v == "true" || v == "1"
+	}
+	return text
+}
+
+// sortChildren sorts the child elements in the order of the sequence
+// of the declaration recursively, the undeclared elements are put last.
+func (s *schema) sortChildren(e *element, decl *xsdElement) {
+	children := s.children(decl)
+	if len(children) == 0 {
+		return
+	}
+
+	index := map[string]int{}
+	for i, c := range children {
+		index[c.Name] = i
+	}
+	sort.SliceStable(e.children, func(i, j int) bool {
+		a, ok := index[e.children[i].name]
+		if !ok {
+			a = len(children)
+		}
+		b, ok := index[e.children[j].name]
+		if !ok {
+			b = len(children)
+		}
+		return a < b
+	})
+
+	for _, c := range e.children {
+		if cdecl := s.child(decl, c.name); cdecl != nil {
+			s.sortChildren(c, cdecl)
+		}
+	}
+}
+
+// writeElement writes the element in the namespace. The child elements
+// inherit the namespace as the default one if they are qualified, or the
+// element is prefixed, so the child elements are in no namespace.
+func writeElement(encoder *xml.Encoder, e *element, namespace string, qualified bool) error {
+	start := xml.StartElement{Name: xml.Name{Local: e.name}}
+	switch {
+	case namespace == "":
+	case qualified:
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: namespace})
+	default:
+		start.Name.Local = unqualifiedPrefix + ":" + e.name
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:" + unqualifiedPrefix}, Value: namespace})
+	}
+	start.Attr = append(start.Attr, e.attrs...)
+	return writeTree(encoder, e, start)
+}
+
+func writeTree(encoder *xml.Encoder, e *element, start xml.StartElement) error {
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if e.text != "" {
+		if err := encoder.EncodeToken(xml.CharData(e.text)); err != nil {
+			return err
+		}
+	}
+	for _, c := range e.children {
+		cstart := xml.StartElement{Name: xml.Name{Local: c.name}, Attr: c.attrs}
+		if err := writeTree(encoder, c, cstart); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func envelopeNamespace(version string) string {
+	if version == soap12 {
+		return soap12Namespace
+	}
+	return soap11Namespace
+}
+
+// marshalEnvelope writes the SOAP envelope with the body element, the
+// envelope is prefixed by soap, so the default namespace is not taken.
+func marshalEnvelope(version string, write func(encoder *xml.Encoder) error) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(buf)
+
+	envelope := xml.StartElement{
+		Name: xml.Name{Local: envelopePrefix + ":Envelope"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:" + envelopePrefix}, Value: envelopeNamespace(version)}},
+	}
+	body := xml.StartElement{Name: xml.Name{Local: envelopePrefix + ":Body"}}
+	for _, t := range []xml.Token{envelope, body} {
+		if err := encoder.EncodeToken(t); err != nil {
+			return nil, err
+		}
+	}
+	if err := write(encoder); err != nil {
+		return nil, err
+	}
+	for _, t := range []xml.Token{body.End(), envelope.End()} {
+		if err := encoder.EncodeToken(t); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalFault writes the envelope of the fault, the child elements of
+// the fault are qualified in SOAP 1.2, and unqualified in SOAP 1.1.
+func marshalFault(version string, f *fault) []byte {
+	prefix := func(name string) string {
+		return envelopePrefix + ":" + name
+	}
+
+	var e *element
+	if version == soap12 {
+		e = &element{children: []*element{
+			{name: prefix("Code"), children: []*element{{name: prefix("Value"), text: prefix(f.code)}}},
+			{name: prefix("Reason"), children: []*element{{name: prefix("Text"), text: f.reason}}},
+		}}
+		if f.detail != "" {
+			e.children = append(e.children, &element{name: prefix("Detail"), text: f.detail})
+		}
+	} else {
+		e = &element{children: []*element{
+			{name: "faultcode", text: prefix(f.code)},
+			{name: "faultstring", text: f.reason},
+		}}
+		if f.detail != "" {
+			e.children = append(e.children, &element{name: "detail", text: f.detail})
+		}
+	}
+
+	buf, _ := marshalEnvelope(version, func(encoder *xml.Encoder) error {
+		return writeTree(encoder, e, xml.StartElement{Name: xml.Name{Local: prefix("Fault")}})
+	})
+	return buf
+}
+
+// parseEnvelope parses the SOAP envelope, the version is decided by the
+// namespace of the envelope.
+func parseEnvelope(r io.Reader) (*envelope, error) {
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xml: %v", err)
+	}
+
+	var env *xmlquery.Node
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode {
+			env = n
+			break
+		}
+	}
+	if env == nil || env.Data != "Envelope" {
+		return nil, fmt.Errorf("not a soap envelope")
+	}
+
+	result := &envelope{}
+	switch env.NamespaceURI {
+	case soap11Namespace:
+		result.version = soap11
+	case soap12Namespace:
+		result.version = soap12
+	default:
+		return nil, fmt.Errorf("unknown soap envelope namespace %q", env.NamespaceURI)
+	}
+
+	for n := env.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != xmlquery.ElementNode || n.Data != "Body" {
+			continue
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == xmlquery.ElementNode {
+				result.body = c
+				return result, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("soap body is empty")
+}
+
+// fault returns the fault of the envelope, or nil if the body is not a
+// fault.
+func (env *envelope) fault() *fault {
+	if env.body.Data != "Fault" || env.body.NamespaceURI != envelopeNamespace(env.version) {
+		return nil
+	}
+
+	text := func(expr string) string {
+		if n := xmlquery.FindOne(env.body, expr); n != nil {
+			return strings.TrimSpace(n.InnerText())
+		}
+		return ""
+	}
+	if env.version == soap12 {
+		return &fault{
+			code:   localName(text("*[local-name()='Code']/*[local-name()='Value']")),
+			reason: text("*[local-name()='Reason']/*[local-name()='Text']"),
+			detail: text("*[local-name()='Detail']"),
+		}
+	}
+	return &fault{
+		code:   localName(text("faultcode")),
+		reason: text("faultstring"),
+		detail: text("detail"),
+	}
+}
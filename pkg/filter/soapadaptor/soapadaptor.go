@@ -0,0 +1,722 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package soapadaptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/rpcbridge"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of SOAPAdaptor.
+	Kind = "SOAPAdaptor"
+
+	resultInvalidRequest = "invalidRequest"
+
+	modeJSONToSOAP = "jsonToSoap"
+	modeSOAPToJSON = "soapToJson"
+
+	soap11 = "1.1"
+	soap12 = "1.2"
+
+	typeString  = "string"
+	typeNumber  = "number"
+	typeBoolean = "boolean"
+	typeObject  = "object"
+
+	headerSOAPAction = "SOAPAction"
+
+	defaultMaxBodySize = 4 << 20
+)
+
+var results = []string{resultInvalidRequest}
+
+func init() {
+	httppipeline.Register(&SOAPAdaptor{})
+}
+
+type (
+	// SOAPAdaptor mediates between the JSON clients and the SOAP services,
+	// or between the SOAP clients and the JSON services.
+	SOAPAdaptor struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		operations []*operation
+
+		requests uint64
+		invalid  uint64
+		faults   uint64
+	}
+
+	// Spec describes the SOAPAdaptor.
+	Spec struct {
+		// Mode is jsonToSoap to call SOAP services from JSON clients, or
+		// soapToJson to call JSON services from SOAP clients.
+		Mode string `yaml:"mode" jsonschema:"required,enum=jsonToSoap,enum=soapToJson"`
+		// SOAPVersion is the version of the SOAP envelopes sent to the
+		// services in jsonToSoap, and of the faults which can't be
+		// decided from the requests in soapToJson, default is 1.1.
+		SOAPVersion string `yaml:"soapVersion,omitempty" jsonschema:"omitempty,enum=1.1,enum=1.2"`
+		// WSDL is the WSDL document of the SOAP service, only the
+		// document/literal style is supported. The namespaces, SOAP
+		// actions, the order of the elements and the types of the values
+		// are decided by it, and the SOAP requests are validated with it.
+		WSDL string `yaml:"wsdl" jsonschema:"omitempty"`
+		// SOAPPath is the path of the SOAP service in jsonToSoap.
+		SOAPPath    string       `yaml:"soapPath" jsonschema:"omitempty"`
+		MaxBodySize int64        `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+		Operations  []*Operation `yaml:"operations" jsonschema:"required,minItems=1"`
+	}
+
+	// Operation maps a SOAP operation to a RESTful endpoint.
+	Operation struct {
+		// Name is the name of the operation, which is the name of the
+		// request element if there is no WSDL.
+		Name string `yaml:"name" jsonschema:"required,minLength=1"`
+		// Method and Path are the endpoint of the JSON requests in
+		// jsonToSoap, the path could be a template like /users/{id}.
+		// They are the endpoint of the JSON service in soapToJson,
+		// default method is POST.
+		Method string `yaml:"method" jsonschema:"omitempty,format=httpmethod"`
+		Path   string `yaml:"path" jsonschema:"required,pattern=^/"`
+		// SOAPAction and Namespace override the ones in the WSDL.
+		SOAPAction string `yaml:"soapAction" jsonschema:"omitempty"`
+		Namespace  string `yaml:"namespace" jsonschema:"omitempty"`
+		// Fields extract the JSON fields from the SOAP responses in
+		// jsonToSoap, or from the SOAP requests in soapToJson. All the
+		// elements are converted if it is empty.
+		Fields []*Field `yaml:"fields" jsonschema:"omitempty"`
+	}
+
+	// Field is a JSON field extracted from the SOAP body.
+	Field struct {
+		// Name is the name of the field, nested fields are joined by
+		// dots, e.g. user.name.
+		Name string `yaml:"name" jsonschema:"required,minLength=1"`
+		// XPath selects the nodes relative to the first element of the
+		// SOAP body, the names are matched with the prefixes in the
+		// documents, use local-name() if the prefixes vary.
+		XPath string `yaml:"xpath" jsonschema:"required,minLength=1"`
+		// Type is the type of the value, default is string, the nodes of
+		// type object are converted entirely.
+		Type string `yaml:"type,omitempty" jsonschema:"omitempty,enum=string,enum=number,enum=boolean,enum=object"`
+		// Multiple makes the value an array of all the selected nodes,
+		// or the value is of the first selected node.
+		Multiple bool `yaml:"multiple" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of SOAPAdaptor.
+	Status struct {
+		Requests uint64 `yaml:"requests"`
+		Invalid  uint64 `yaml:"invalid"`
+		Faults   uint64 `yaml:"faults"`
+	}
+
+	operation struct {
+		spec     *Operation
+		endpoint *rpcbridge.Endpoint
+		fields   []*field
+
+		// schema is empty if there is no WSDL, input and output are nil
+		// then.
+		schema     *schema
+		input      *xsdElement
+		output     *xsdElement
+		namespace  string
+		qualified  bool
+		soapAction string
+	}
+
+	field struct {
+		spec *Field
+		expr *xpath.Expr
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	var w *wsdl
+	if spec.WSDL != "" {
+		var err error
+		if w, err = parseWSDL(spec.WSDL); err != nil {
+			return err
+		}
+	}
+
+	if spec.Mode == modeJSONToSOAP && !strings.HasPrefix(spec.SOAPPath, "/") {
+		return fmt.Errorf("soapPath must be an absolute path in mode %s", modeJSONToSOAP)
+	}
+
+	names := map[string]bool{}
+	for _, op := range spec.Operations {
+		if names[op.Name] {
+			return fmt.Errorf("operation %s is duplicated", op.Name)
+		}
+		names[op.Name] = true
+
+		if spec.Mode == modeJSONToSOAP && op.Method == "" {
+			return fmt.Errorf("operation %s: method is required in mode %s", op.Name, modeJSONToSOAP)
+		}
+		if w != nil {
+			if _, err := w.operation(op.Name); err != nil {
+				return err
+			}
+		}
+		for _, f := range op.Fields {
+			if _, err := xpath.Compile(f.XPath); err != nil {
+				return fmt.Errorf("operation %s: invalid xpath %s: %v", op.Name, f.XPath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of SOAPAdaptor.
+func (a *SOAPAdaptor) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of SOAPAdaptor.
+func (a *SOAPAdaptor) DefaultSpec() interface{} {
+	return &Spec{
+		SOAPVersion: soap11,
+		MaxBodySize: defaultMaxBodySize,
+	}
+}
+
+// Description returns the description of SOAPAdaptor.
+func (a *SOAPAdaptor) Description() string {
+	return "SOAPAdaptor converts between SOAP envelopes and JSON for the requests and responses."
+}
+
+// Results returns the results of SOAPAdaptor.
+func (a *SOAPAdaptor) Results() []string {
+	return results
+}
+
+// Init initializes SOAPAdaptor.
+func (a *SOAPAdaptor) Init(filterSpec *httppipeline.FilterSpec) {
+	a.filterSpec, a.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	a.reload()
+}
+
+// Inherit inherits previous generation of SOAPAdaptor.
+func (a *SOAPAdaptor) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	a.Init(filterSpec)
+}
+
+func (a *SOAPAdaptor) reload() {
+	if a.spec.SOAPVersion == "" {
+		a.spec.SOAPVersion = soap11
+	}
+
+	var w *wsdl
+	if a.spec.WSDL != "" {
+		var err error
+		if w, err = parseWSDL(a.spec.WSDL); err != nil {
+			logger.Errorf("BUG: parse wsdl failed: %v", err)
+		}
+	}
+
+	a.operations = nil
+	for _, spec := range a.spec.Operations {
+		op := &operation{
+			spec:   spec,
+			schema: &schema{types: map[string]*xsdComplexType{}},
+		}
+		if a.spec.Mode == modeJSONToSOAP {
+			op.endpoint = rpcbridge.NewEndpoint(spec.Method, spec.Path)
+		}
+
+		if w != nil {
+			resolved, err := w.operation(spec.Name)
+			if err != nil {
+				logger.Errorf("BUG: resolve operation %s failed: %v", spec.Name, err)
+				continue
+			}
+			op.schema, op.input, op.output = resolved.schema, resolved.input, resolved.output
+			op.namespace, op.qualified, op.soapAction = resolved.namespace, resolved.qualified, resolved.soapAction
+		}
+		if spec.Namespace != "" {
+			op.namespace = spec.Namespace
+		}
+		if spec.SOAPAction != "" {
+			op.soapAction = spec.SOAPAction
+		}
+
+		for _, f := range spec.Fields {
+			expr, err := xpath.Compile(f.XPath)
+			if err != nil {
+				logger.Errorf("BUG: compile xpath %s failed: %v", f.XPath, err)
+				continue
+			}
+			op.fields = append(op.fields, &field{spec: f, expr: expr})
+		}
+		a.operations = append(a.operations, op)
+	}
+}
+
+// requestName returns the name of the request element.
+func (op *operation) requestName() string {
+	if op.input != nil {
+		return op.input.Name
+	}
+	return op.spec.Name
+}
+
+// responseName returns the name of the response element.
+func (op *operation) responseName() string {
+	if op.output != nil {
+		return op.output.Name
+	}
+	return op.spec.Name + "Response"
+}
+
+// Handle converts the request and the response.
+func (a *SOAPAdaptor) Handle(ctx context.HTTPContext) string {
+	if a.spec.Mode == modeJSONToSOAP {
+		return a.handleJSONToSOAP(ctx)
+	}
+	return a.handleSOAPToJSON(ctx)
+}
+
+func (a *SOAPAdaptor) readBody(body io.Reader) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(body, a.spec.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if int64(len(buf)) > a.spec.MaxBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", a.spec.MaxBodySize)
+	}
+	return buf, nil
+}
+
+func setBody(h *httpheader.HTTPHeader, setBody func(io.Reader), contentType string, body []byte) {
+	h.Set(httpheader.KeyContentType, contentType)
+	h.Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	setBody(bytes.NewReader(body))
+}
+
+func soapContentType(version, action string) string {
+	if version == soap12 {
+		if action == "" {
+			return "application/soap+xml; charset=utf-8"
+		}
+		return mime.FormatMediaType("application/soap+xml", map[string]string{"charset": "utf-8", "action": action})
+	}
+	return "text/xml; charset=utf-8"
+}
+
+// handleJSONToSOAP converts the JSON request to the SOAP request, and
+// the SOAP response to the JSON response, the requests not matching
+// any operation are passed through.
+func (a *SOAPAdaptor) handleJSONToSOAP(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	var op *operation
+	var vars map[string]string
+	for _, o := range a.operations {
+		if v, ok := o.endpoint.Match(r.Method(), r.Path()); ok {
+			op, vars = o, v
+			break
+		}
+	}
+	if op == nil {
+		return ctx.CallNextHandler("")
+	}
+
+	atomic.AddUint64(&a.requests, 1)
+	body, err := a.buildSOAPRequest(ctx, op, vars)
+	if err != nil {
+		atomic.AddUint64(&a.invalid, 1)
+		ctx.AddTag(stringtool.Cat("soapAdaptor: ", err.Error()))
+		rpcbridge.WriteError(ctx, http.StatusBadRequest, err.Error())
+		return ctx.CallNextHandler(resultInvalidRequest)
+	}
+
+	r.SetMethod(http.MethodPost)
+	r.SetPath(a.spec.SOAPPath)
+	r.SetQuery("")
+	r.Header().Del(headerSOAPAction)
+	if a.spec.SOAPVersion == soap11 {
+		r.Header().Set(headerSOAPAction, strconv.Quote(op.soapAction))
+	}
+	setBody(r.Header(), r.SetBody, soapContentType(a.spec.SOAPVersion, op.soapAction), body)
+
+	result := ctx.CallNextHandler("")
+	if result != "" {
+		return result
+	}
+
+	a.convertSOAPResponse(ctx, op)
+	return result
+}
+
+// buildSOAPRequest builds the SOAP envelope from the JSON body, the path
+// variables and the query parameters.
+func (a *SOAPAdaptor) buildSOAPRequest(ctx context.HTTPContext, op *operation, vars map[string]string) ([]byte, error) {
+	r := ctx.Request()
+	req := &element{name: op.requestName()}
+
+	body, err := a.readBody(r.Body())
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = fromJSON(body, req); err != nil {
+			return nil, fmt.Errorf("invalid json body: %v", err)
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.children = append(req.children, &element{name: name, text: vars[name]})
+	}
+
+	query, err := url.ParseQuery(r.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	names = names[:0]
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range query[name] {
+			req.children = append(req.children, &element{name: name, text: v})
+		}
+	}
+
+	if op.input != nil {
+		op.schema.sortChildren(req, op.input)
+		if err = op.schema.validate(req, op.input, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return marshalEnvelope(a.spec.SOAPVersion, func(encoder *xml.Encoder) error {
+		return writeElement(encoder, req, op.namespace, op.qualified)
+	})
+}
+
+// convertSOAPResponse converts the SOAP response to JSON, the faults are
+// converted to objects of faultCode, faultString and detail.
+func (a *SOAPAdaptor) convertSOAPResponse(ctx context.HTTPContext, op *operation) {
+	w := ctx.Response()
+
+	body, err := a.readBody(w.Body())
+	if err == nil {
+		var env *envelope
+		if env, err = parseEnvelope(bytes.NewReader(body)); err == nil {
+			if f := env.fault(); f != nil {
+				atomic.AddUint64(&a.faults, 1)
+				rpcbridge.WriteJSON(ctx, w.StatusCode(), map[string]string{
+					"faultCode":   f.code,
+					"faultString": f.reason,
+					"detail":      f.detail,
+				})
+				return
+			}
+
+			var v interface{}
+			if v, err = op.toJSON(env.body, op.output); err == nil {
+				err = rpcbridge.WriteJSON(ctx, w.StatusCode(), v)
+			}
+		}
+	}
+
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("soapAdaptor: invalid soap response: ", err.Error()))
+		rpcbridge.WriteError(ctx, http.StatusBadGateway, stringtool.Cat("invalid soap response: ", err.Error()))
+	}
+}
+
+// handleSOAPToJSON converts the SOAP request to the JSON request, and the
+// JSON response to the SOAP response.
+func (a *SOAPAdaptor) handleSOAPToJSON(ctx context.HTTPContext) string {
+	atomic.AddUint64(&a.requests, 1)
+	r := ctx.Request()
+
+	version := a.spec.SOAPVersion
+	op, body, err := a.buildJSONRequest(ctx, &version)
+	if err != nil {
+		atomic.AddUint64(&a.invalid, 1)
+		ctx.AddTag(stringtool.Cat("soapAdaptor: ", err.Error()))
+		a.writeFault(ctx, version, &fault{code: "Client", reason: err.Error()})
+		return ctx.CallNextHandler(resultInvalidRequest)
+	}
+
+	method := op.spec.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	r.SetMethod(method)
+	r.SetPath(op.spec.Path)
+	r.SetQuery("")
+	r.Header().Del(headerSOAPAction)
+	setBody(r.Header(), r.SetBody, "application/json", body)
+
+	result := ctx.CallNextHandler("")
+	if result != "" {
+		return result
+	}
+
+	a.convertJSONResponse(ctx, op, version)
+	return result
+}
+
+// buildJSONRequest finds the operation of the SOAP request, validates
+// it, and converts it to JSON, the version is set to the one of the
+// envelope.
+func (a *SOAPAdaptor) buildJSONRequest(ctx context.HTTPContext, version *string) (*operation, []byte, error) {
+	r := ctx.Request()
+
+	body, err := a.readBody(r.Body())
+	if err != nil {
+		return nil, nil, err
+	}
+	env, err := parseEnvelope(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	*version = env.version
+
+	op := a.findOperation(env.body.Data, soapAction(r.Header(), env.version))
+	if op == nil {
+		return nil, nil, fmt.Errorf("unknown operation %s", env.body.Data)
+	}
+
+	if op.input != nil {
+		if env.body.Data != op.input.Name {
+			return nil, nil, fmt.Errorf("element %s is not the request of operation %s", env.body.Data, op.spec.Name)
+		}
+		if err = op.schema.validate(fromNode(env.body), op.input, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	v, err := op.toJSON(env.body, op.input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		v = map[string]interface{}{}
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return op, buf, nil
+}
+
+// soapAction returns the SOAP action of the request, which is in the
+// header SOAPAction of SOAP 1.1, or the parameter action of the content
+// type of SOAP 1.2.
+func soapAction(h *httpheader.HTTPHeader, version string) string {
+	if version == soap12 {
+		_, params, _ := mime.ParseMediaType(h.Get(httpheader.KeyContentType))
+		return params["action"]
+	}
+	return strings.Trim(h.Get(headerSOAPAction), `"`)
+}
+
+// findOperation finds the operation by the request element, or by the
+// SOAP action if no request element matches.
+func (a *SOAPAdaptor) findOperation(name, action string) *operation {
+	for _, op := range a.operations {
+		if op.requestName() == name {
+			return op
+		}
+	}
+	if action == "" {
+		return nil
+	}
+	for _, op := range a.operations {
+		if op.soapAction == action {
+			return op
+		}
+	}
+	return nil
+}
+
+// convertJSONResponse converts the JSON response to the SOAP response,
+// the error responses are converted to server faults.
+func (a *SOAPAdaptor) convertJSONResponse(ctx context.HTTPContext, op *operation, version string) {
+	w := ctx.Response()
+
+	body, err := a.readBody(w.Body())
+	if err != nil {
+		a.writeFault(ctx, version, &fault{code: "Server", reason: err.Error()})
+		return
+	}
+	if w.StatusCode() >= http.StatusBadRequest {
+		atomic.AddUint64(&a.faults, 1)
+		a.writeFault(ctx, version, &fault{
+			code:   "Server",
+			reason: http.StatusText(w.StatusCode()),
+			detail: string(bytes.TrimSpace(body)),
+		})
+		return
+	}
+
+	resp := &element{name: op.responseName()}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = fromJSON(body, resp); err != nil {
+			a.writeFault(ctx, version, &fault{code: "Server", reason: stringtool.Cat("invalid json response: ", err.Error())})
+			return
+		}
+	}
+	if op.output != nil {
+		op.schema.sortChildren(resp, op.output)
+	}
+
+	buf, err := marshalEnvelope(version, func(encoder *xml.Encoder) error {
+		return writeElement(encoder, resp, op.namespace, op.qualified)
+	})
+	if err != nil {
+		a.writeFault(ctx, version, &fault{code: "Server", reason: err.Error()})
+		return
+	}
+	w.SetStatusCode(http.StatusOK)
+	setBody(w.Header(), w.SetBody, soapContentType(version, ""), buf)
+}
+
+// writeFault writes the fault, the codes Client and Server are the
+// Sender and Receiver in SOAP 1.2.
+func (a *SOAPAdaptor) writeFault(ctx context.HTTPContext, version string, f *fault) {
+	statusCode := http.StatusInternalServerError
+	if version == soap12 {
+		switch f.code {
+		case "Client":
+			f.code = "Sender"
+			statusCode = http.StatusBadRequest
+		case "Server":
+			f.code = "Receiver"
+		}
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(statusCode)
+	setBody(w.Header(), w.SetBody, soapContentType(version, ""), marshalFault(version, f))
+}
+
+// toJSON converts the element to JSON, by the fields of the operation if
+// there are, or entirely.
+func (op *operation) toJSON(n *xmlquery.Node, decl *xsdElement) (interface{}, error) {
+	if len(op.fields) == 0 {
+		return op.schema.toJSON(fromNode(n), decl), nil
+	}
+
+	result := map[string]interface{}{}
+	for _, f := range op.fields {
+		nodes := xmlquery.QuerySelectorAll(n, f.expr)
+
+		values := make([]interface{}, 0, len(nodes))
+		for _, node := range nodes {
+			v, err := f.value(node)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+
+		switch {
+		case f.spec.Multiple:
+			setField(result, f.spec.Name, values)
+		case len(values) > 0:
+			setField(result, f.spec.Name, values[0])
+		}
+	}
+	return result, nil
+}
+
+func (f *field) value(n *xmlquery.Node) (interface{}, error) {
+	if f.spec.Type == typeObject {
+		return (&schema{}).toJSON(fromNode(n), nil), nil
+	}
+
+	text := strings.TrimSpace(n.InnerText())
+	switch f.spec.Type {
+	case typeNumber:
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("field %s: %q is not a number", f.spec.Name, text)
+		}
+		return json.Number(text), nil
+	case typeBoolean:
+		return text == "true" || text == "1", nil
+	}
+	return text, nil
+}
+
+// setField sets the value of the field, the objects of the nested fields
+// are created if they don't exist.
+func setField(m map[string]interface{}, name string, v interface{}) {
+	keys := strings.Split(name, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = v
+}
+
+// Status returns status.
+func (a *SOAPAdaptor) Status() interface{} {
+	return &Status{
+		Requests: atomic.LoadUint64(&a.requests),
+		Invalid:  atomic.LoadUint64(&a.invalid),
+		Faults:   atomic.LoadUint64(&a.faults),
+	}
+}
+
+// Close closes SOAPAdaptor.
+func (a *SOAPAdaptor) Close() {
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package soapadaptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xmlquery"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const wsdlDoc = `<?xml version="1.0"?>
+<definitions xmlns="http://schemas.xmlsoap.org/wsdl/"
+    xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"
+    xmlns:xs="http://www.w3.org/2001/XMLSchema"
+    xmlns:tns="urn:users" targetNamespace="urn:users">
+  <types>
+    <xs:schema targetNamespace="urn:users" elementFormDefault="qualified">
+      <xs:element name="GetUser">
+        <xs:complexType><xs:sequence>
+          <xs:element name="id" type="xs:int"/>
+          <xs:element name="verbose" type="xs:boolean" minOccurs="0"/>
+        </xs:sequence></xs:complexType>
+      </xs:element>
+      <xs:element name="GetUserResponse">
+        <xs:complexType><xs:sequence>
+          <xs:element name="user" type="tns:User"/>
+        </xs:sequence></xs:complexType>
+      </xs:element>
+      <xs:complexType name="User"><xs:sequence>
+        <xs:element name="name" type="xs:string"/>
+        <xs:element name="age" type="xs:int"/>
+        <xs:element name="tag" type="xs:string" minOccurs="0" maxOccurs="unbounded"/>
+      </xs:sequence></xs:complexType>
+    </xs:schema>
+  </types>
+  <message name="GetUserInput"><part name="body" element="tns:GetUser"/></message>
+  <message name="GetUserOutput"><part name="body" element="tns:GetUserResponse"/></message>
+  <portType name="UserPort">
+    <operation name="GetUser">
+      <input message="tns:GetUserInput"/>
+      <output message="tns:GetUserOutput"/>
+    </operation>
+  </portType>
+  <binding name="UserBinding" type="tns:UserPort">
+    <operation name="GetUser"><soap:operation soapAction="urn:users#GetUser"/></operation>
+  </binding>
+</definitions>`
+
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
+
+// doRequest sends the request, the next handler records the request it
+// receives and responds with the status code and the body.
+func doRequest(a *SOAPAdaptor, req *http.Request, statusCode int, respBody string) (string, *httptest.ResponseRecorder, *http.Request, string) {
+	var upstream *http.Request
+	upstreamBody := ""
+	result, w := httppipelinetest.Handle(a, req, func(ctx context.HTTPContext, lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		r := ctx.Request()
+		upstream, _ = http.NewRequest(r.Method(), r.Path()+"?"+r.Query(), nil)
+		upstream.Header = r.Header().Std().Clone()
+		buf, _ := io.ReadAll(r.Body())
+		upstreamBody = string(buf)
+
+		ctx.Response().SetStatusCode(statusCode)
+		ctx.Response().SetBody(bytes.NewReader([]byte(respBody)))
+		return ""
+	})
+	return result, w, upstream, upstreamBody
+}
+
+func TestValidate(t *testing.T) {
+	for _, spec := range []string{`
+kind: SOAPAdaptor
+name: soap
+mode: jsonToSoap
+operations:
+- name: GetUser
+  method: GET
+  path: /users/{id}
+`, `
+kind: SOAPAdaptor
+name: soap
+mode: soapToJson
+wsdl: |
+` + indent(wsdlDoc) + `
+operations:
+- name: DeleteUser
+  path: /users
+`, `
+kind: SOAPAdaptor
+name: soap
+mode: soapToJson
+operations:
+- name: GetUser
+  path: /users
+  fields:
+  - name: id
+    xpath: "id["
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(spec), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("spec should be invalid: %s", spec)
+		}
+	}
+}
+
+func TestJSONToSOAP(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &SOAPAdaptor{}, `
+kind: SOAPAdaptor
+name: soap
+mode: jsonToSoap
+soapPath: /soap
+wsdl: |
+`+indent(wsdlDoc)+`
+operations:
+- name: GetUser
+  method: GET
+  path: /users/{id}
+`).(*SOAPAdaptor)
+	defer a.Close()
+
+	const response = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>
+<GetUserResponse xmlns="urn:users"><user><name>alice</name><age>30</age><tag>admin</tag></user></GetUserResponse>
+</soap:Body></soap:Envelope>`
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1?verbose=true", nil)
+	result, w, upstream, body := doRequest(a, req, http.StatusOK, response)
+	if result != "" {
+		t.Fatalf("unexpected result %q", result)
+	}
+	if upstream.Method != http.MethodPost || upstream.URL.Path != "/soap" {
+		t.Errorf("unexpected upstream request %s %s", upstream.Method, upstream.URL)
+	}
+	if upstream.Header.Get(headerSOAPAction) != `"urn:users#GetUser"` || !strings.HasPrefix(upstream.Header.Get("Content-Type"), "text/xml") {
+		t.Errorf("unexpected upstream headers %v", upstream.Header)
+	}
+
+	doc, err := xmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("invalid soap request %s: %v", body, err)
+	}
+	op := xmlquery.FindOne(doc, "//*[local-name()='GetUser']")
+	if op == nil || op.NamespaceURI != "urn:users" {
+		t.Fatalf("unexpected soap request %s", body)
+	}
+	if op.FirstChild.Data != "id" || op.FirstChild.InnerText() != "1" || op.LastChild.Data != "verbose" {
+		t.Errorf("elements should be in the order of the wsdl: %s", body)
+	}
+
+	m := map[string]interface{}{}
+	if err = json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid json response %s: %v", w.Body.String(), err)
+	}
+	user := m["user"].(map[string]interface{})
+	if user["name"] != "alice" || user["age"] != float64(30) || len(user["tag"].([]interface{})) != 1 {
+		t.Errorf("unexpected json response %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users/abc", nil)
+	if result, w, _, _ = doRequest(a, req, http.StatusOK, response); result != resultInvalidRequest || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid request should be rejected, got %q, %d", result, w.Code)
+	}
+
+	const fault = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>
+<soap:Fault><faultcode>soap:Server</faultcode><faultstring>no such user</faultstring></soap:Fault>
+</soap:Body></soap:Envelope>`
+	req = httptest.NewRequest(http.MethodGet, "/users/2", nil)
+	result, w, _, _ = doRequest(a, req, http.StatusInternalServerError, fault)
+	if result != "" || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"faultString":"no such user"`) {
+		t.Errorf("unexpected fault response %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	if _, _, upstream, _ = doRequest(a, req, http.StatusOK, "{}"); upstream.URL.Path != "/orders/1" {
+		t.Errorf("unmatched request should be passed through, got %s", upstream.URL)
+	}
+
+	s := a.Status().(*Status)
+	if s.Requests != 3 || s.Invalid != 1 || s.Faults != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestSOAPToJSON(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &SOAPAdaptor{}, `
+kind: SOAPAdaptor
+name: soap
+mode: soapToJson
+operations:
+- name: GetUser
+  path: /api/users
+  namespace: urn:users
+  fields:
+  - name: user.id
+    xpath: "u:id"
+    type: number
+  - name: tags
+    xpath: "u:tag"
+    multiple: true
+`).(*SOAPAdaptor)
+	defer a.Close()
+
+	const request = `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body>
+<u:GetUser xmlns:u="urn:users"><u:id>7</u:id><u:tag>a</u:tag><u:tag>b</u:tag></u:GetUser>
+</soap:Body></soap:Envelope>`
+
+	req := httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(request))
+	result, w, upstream, body := doRequest(a, req, http.StatusOK, `{"name":"bob","roles":["x","y"]}`)
+	if result != "" || upstream.Method != http.MethodPost || upstream.URL.Path != "/api/users" {
+		t.Fatalf("unexpected result %q, upstream %v", result, upstream)
+	}
+	if body != `{"tags":["a","b"],"user":{"id":7}}` {
+		t.Errorf("unexpected json request %s", body)
+	}
+
+	doc, err := xmlquery.Parse(w.Body)
+	if err != nil {
+		t.Fatalf("invalid soap response: %v", err)
+	}
+	if n := xmlquery.FindOne(doc, "//*[local-name()='GetUserResponse']/name"); n == nil || n.InnerText() != "bob" {
+		t.Errorf("unexpected soap response")
+	}
+	if n := xmlquery.Find(doc, "//roles"); len(n) != 2 {
+		t.Errorf("arrays should be repeated elements")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(request))
+	result, w, _, _ = doRequest(a, req, http.StatusNotFound, `{"error":"not found"}`)
+	if result != "" || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "soap:Receiver") {
+		t.Errorf("error response should be a fault, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(`<a/>`))
+	result, w, _, _ = doRequest(a, req, http.StatusOK, "{}")
+	if result != resultInvalidRequest || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "soap:Client") {
+		t.Errorf("invalid request should be a fault, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+}
+
+func TestWSDLValidation(t *testing.T) {
+	a := httppipelinetest.InitFilter(t, &SOAPAdaptor{}, `
+kind: SOAPAdaptor
+name: soap
+mode: soapToJson
+wsdl: |
+`+indent(wsdlDoc)+`
+operations:
+- name: GetUser
+  path: /api/users
+`).(*SOAPAdaptor)
+	defer a.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/"><Body>
+<GetUser xmlns="urn:users"><id>3</id><verbose>1</verbose></GetUser></Body></Envelope>`))
+	result, w, _, body := doRequest(a, req, http.StatusOK, `{"user":{"name":"carol","age":20}}`)
+	if result != "" || body != `{"id":3,"verbose":true}` {
+		t.Errorf("unexpected result %q, json request %s", result, body)
+	}
+	if !strings.Contains(w.Body.String(), `<GetUserResponse xmlns="urn:users"><user><name>carol</name><age>20</age></user></GetUserResponse>`) {
+		t.Errorf("unexpected soap response %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/"><Body>
+<GetUser xmlns="urn:users"><id>x</id></GetUser></Body></Envelope>`))
+	if result, w, _, _ = doRequest(a, req, http.StatusOK, "{}"); result != resultInvalidRequest || !strings.Contains(w.Body.String(), "not a valid int") {
+		t.Errorf("invalid request should be rejected, got %q, %s", result, w.Body.String())
+	}
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package soapadaptor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unbounded = -1
+
+type (
+	// wsdl is the subset of WSDL 1.1 used by SOAPAdaptor, only the
+	// document/literal style is supported, so the parts of the messages
+	// refer to the elements declared in the schemas.
+	wsdl struct {
+		TargetNamespace string          `xml:"targetNamespace,attr"`
+		Schemas         []*xsdSchema    `xml:"types>schema"`
+		Messages        []*wsdlMessage  `xml:"message"`
+		PortTypes       []*wsdlPortType `xml:"portType"`
+		Bindings        []*wsdlBinding  `xml:"binding"`
+	}
+
+	wsdlMessage struct {
+		Name  string `xml:"name,attr"`
+		Parts []struct {
+			Element string `xml:"element,attr"`
+		} `xml:"part"`
+	}
+
+	wsdlPortType struct {
+		Operations []*struct {
+			Name   string `xml:"name,attr"`
+			Input  wsdlIO `xml:"input"`
+			Output wsdlIO `xml:"output"`
+		} `xml:"operation"`
+	}
+
+	wsdlIO struct {
+		Message string `xml:"message,attr"`
+	}
+
+	wsdlBinding struct {
+		Operations []*struct {
+			Name string `xml:"name,attr"`
+			// SOAP is the soap:operation or soap12:operation.
+			SOAP struct {
+				SOAPAction string `xml:"soapAction,attr"`
+			} `xml:"operation"`
+		} `xml:"operation"`
+	}
+
+	xsdSchema struct {
+		TargetNamespace    string            `xml:"targetNamespace,attr"`
+		ElementFormDefault string            `xml:"elementFormDefault,attr"`
+		Elements           []*xsdElement     `xml:"element"`
+		ComplexTypes       []*xsdComplexType `xml:"complexType"`
+	}
+
+	xsdElement struct {
+		Name        string          `xml:"name,attr"`
+		Type        string          `xml:"type,attr"`
+		MinOccurs   string          `xml:"minOccurs,attr"`
+		MaxOccurs   string          `xml:"maxOccurs,attr"`
+		Nillable    bool            `xml:"nillable,attr"`
+		ComplexType *xsdComplexType `xml:"complexType"`
+	}
+
+	xsdComplexType struct {
+		Name     string        `xml:"name,attr"`
+		Sequence []*xsdElement `xml:"sequence>element"`
+		All      []*xsdElement `xml:"all>element"`
+	}
+
+	// schema is the resolved schema of an operation.
+	schema struct {
+		types     map[string]*xsdComplexType
+		namespace string
+		qualified bool
+	}
+
+	// operationSchema is the schema of the input and output elements of
+	// an operation.
+	operationSchema struct {
+		*schema
+		soapAction string
+		input      *xsdElement
+		output     *xsdElement
+	}
+)
+
+func parseWSDL(data string) (*wsdl, error) {
+	w := &wsdl{}
+	if err := xml.Unmarshal([]byte(data), w); err != nil {
+		return nil, fmt.Errorf("invalid wsdl: %v", err)
+	}
+	return w, nil
+}
+
+// localName removes the namespace prefix of a qualified name.
+func localName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// operation resolves the schema of the operation.
+func (w *wsdl) operation(name string) (*operationSchema, error) {
+	s := &schema{types: map[string]*xsdComplexType{}, namespace: w.TargetNamespace}
+	elements := map[string]*xsdElement{}
+	for _, sc := range w.Schemas {
+		for _, e := range sc.Elements {
+			elements[e.Name] = e
+		}
+		for _, t := range sc.ComplexTypes {
+			s.types[t.Name] = t
+		}
+	}
+
+	op := &operationSchema{schema: s}
+	for _, pt := range w.PortTypes {
+		for _, o := range pt.Operations {
+			if o.Name != name {
+				continue
+			}
+			var err error
+			if op.input, err = w.messageElement(o.Input.Message, elements); err != nil {
+				return nil, err
+			}
+			if op.output, err = w.messageElement(o.Output.Message, elements); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if op.input == nil {
+		return nil, fmt.Errorf("operation %s not found in wsdl", name)
+	}
+
+	for _, sc := range w.Schemas {
+		for _, e := range sc.Elements {
+			if e == op.input {
+				s.namespace = sc.TargetNamespace
+				s.qualified = sc.ElementFormDefault == "qualified"
+			}
+		}
+	}
+
+	for _, b := range w.Bindings {
+		for _, o := range b.Operations {
+			if o.Name == name {
+				op.soapAction = o.SOAP.SOAPAction
+			}
+		}
+	}
+	return op, nil
+}
+
+func (w *wsdl) messageElement(message string, elements map[string]*xsdElement) (*xsdElement, error) {
+	if message == "" {
+		return nil, nil
+	}
+	for _, m := range w.Messages {
+		if m.Name != localName(message) {
+			continue
+		}
+		if len(m.Parts) != 1 || m.Parts[0].Element == "" {
+			return nil, fmt.Errorf("message %s must have one part referring to an element", m.Name)
+		}
+		e, ok := elements[localName(m.Parts[0].Element)]
+		if !ok {
+			return nil, fmt.Errorf("element %s not found in wsdl", m.Parts[0].Element)
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("message %s not found in wsdl", message)
+}
+
+func occurs(s string, defaultValue int) int {
+	if s == "unbounded" {
+		return unbounded
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return defaultValue
+}
+
+// children returns the declarations of the child elements, it returns
+// nil if the element is of a simple type.
+func (s *schema) children(decl *xsdElement) []*xsdElement {
+	t := decl.ComplexType
+	if t == nil && decl.Type != "" {
+		t = s.types[localName(decl.Type)]
+	}
+	if t == nil {
+		return nil
+	}
+	if len(t.Sequence) > 0 {
+		return t.Sequence
+	}
+	if len(t.All) > 0 {
+		return t.All
+	}
+	return []*xsdElement{}
+}
+
+func (s *schema) child(decl *xsdElement, name string) *xsdElement {
+	for _, c := range s.children(decl) {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// validate validates the element against the declaration, the order of
+// the child elements is not checked.
+func (s *schema) validate(e *element, decl *xsdElement, path string) error {
+	path = path + "/" + e.name
+
+	children := s.children(decl)
+	if children == nil {
+		if len(e.children) > 0 {
+			return fmt.Errorf("%s: unexpected child elements", path)
+		}
+		if e.nil && decl.Nillable {
+			return nil
+		}
+		if err := validateSimple(localName(decl.Type), e.text); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	}
+
+	counts := map[string]int{}
+	for _, c := range e.children {
+		cdecl := s.child(decl, c.name)
+		if cdecl == nil {
+			return fmt.Errorf("%s: unexpected element %s", path, c.name)
+		}
+		counts[c.name]++
+		if err := s.validate(c, cdecl, path); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range children {
+		n := counts[c.Name]
+		if min := occurs(c.MinOccurs, 1); n < min {
+			return fmt.Errorf("%s: element %s is required", path, c.Name)
+		}
+		if max := occurs(c.MaxOccurs, 1); max != unbounded && n > max {
+			return fmt.Errorf("%s: element %s occurs more than %d times", path, c.Name, max)
+		}
+	}
+	return nil
+}
+
+// validateSimple validates the value of the built-in simple types, the
+// other types are not validated.
+func validateSimple(typ, value string) error {
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch typ {
+	case "int", "integer", "long", "short", "byte":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "unsignedInt", "unsignedLong", "unsignedShort", "unsignedByte", "nonNegativeInteger":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "float", "double", "decimal":
+		_, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		if value != "true" && value != "false" && value != "1" && value != "0" {
+			err = fmt.Errorf("invalid boolean")
+		}
+	case "dateTime":
+		_, err = time.Parse(time.RFC3339, value)
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, typ)
+	}
+	return nil
+}
+
+// isNumber reports whether the simple type is a number type, whose
+// values are converted to JSON numbers.
+func isNumber(typ string) bool {
+	switch localName(typ) {
+	case "int", "integer", "long", "short", "byte", "unsignedInt", "unsignedLong",
+		"unsignedShort", "unsignedByte", "nonNegativeInteger", "float", "double", "decimal":
+		return true
+	}
+	return false
+}
+
+func isBoolean(typ string) bool {
+	return localName(typ) == "boolean"
+}
+
+func isRepeated(decl *xsdElement) bool {
+	max := occurs(decl.MaxOccurs, 1)
+	return max == unbounded || max > 1
+}
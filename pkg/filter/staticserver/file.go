@@ -0,0 +1,326 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package staticserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// sniffLen is the number of bytes to detect the content type.
+const sniffLen = 512
+
+type (
+	// fileBody is the body of a file, it closes the file when the body
+	// is closed.
+	fileBody struct {
+		io.Reader
+		f fs.File
+	}
+
+	// httpRange is a byte range of the file, [start, start+length).
+	httpRange struct {
+		start  int64
+		length int64
+	}
+
+	// cachedETag is the ETag of a file computed from the content.
+	cachedETag struct {
+		size int64
+		etag string
+	}
+)
+
+// Close closes the file.
+func (fb *fileBody) Close() error {
+	return fb.f.Close()
+}
+
+func (s *StaticServer) serveFile(ctx context.HTTPContext, name string, info fs.FileInfo, cacheControl string) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		w.SetStatusCode(http.StatusNotFound)
+		return resultNotFound
+	}
+	closeFile := true
+	defer func() {
+		if closeFile {
+			f.Close()
+		}
+	}()
+
+	size, mt := info.Size(), modTime(info)
+	etag := s.etag(name, info)
+
+	h := w.Header()
+	if etag != "" {
+		h.Set(keyETag, etag)
+	}
+	if !isZeroTime(mt) {
+		h.Set(keyLastModified, mt.Format(http.TimeFormat))
+	}
+	if cacheControl != "" {
+		h.Set(httpheader.KeyCacheControl, cacheControl)
+	}
+
+	if notModified(r.Header(), etag, mt) {
+		w.SetStatusCode(http.StatusNotModified)
+		return ""
+	}
+
+	seeker, seekable := f.(io.Seeker)
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" && seekable {
+		buff := make([]byte, sniffLen)
+		n, _ := io.ReadFull(f, buff)
+		contentType = http.DetectContentType(buff[:n])
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			w.SetStatusCode(http.StatusInternalServerError)
+			return ""
+		}
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set(httpheader.KeyContentType, contentType)
+
+	code, start, length := http.StatusOK, int64(0), size
+	if seekable {
+		h.Set(keyAcceptRanges, "bytes")
+
+		rangeHeader := r.Header().Get(keyRange)
+		if rangeHeader != "" && ifRangeMatches(r.Header(), etag, mt) {
+			ranges, err := parseRange(rangeHeader, size)
+			if err != nil {
+				h.Set(keyContentRange, fmt.Sprintf("bytes */%d", size))
+				w.SetStatusCode(http.StatusRequestedRangeNotSatisfiable)
+				return ""
+			}
+			// NOTE: Multiple ranges are ignored, the whole file is sent.
+			if len(ranges) == 1 {
+				code, start, length = http.StatusPartialContent, ranges[0].start, ranges[0].length
+				h.Set(keyContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+			}
+		}
+	}
+
+	h.Set(httpheader.KeyContentLength, strconv.FormatInt(length, 10))
+	w.SetStatusCode(code)
+	if r.Method() == http.MethodHead {
+		return ""
+	}
+
+	if start > 0 {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			w.SetStatusCode(http.StatusInternalServerError)
+			return ""
+		}
+	}
+
+	closeFile = false
+	w.SetBody(&fileBody{Reader: io.LimitReader(f, length), f: f})
+	return ""
+}
+
+// etag returns the ETag of the file by its modification time and size,
+// or by its content if there is no modification time.
+func (s *StaticServer) etag(name string, info fs.FileInfo) string {
+	mt := modTime(info)
+	if !isZeroTime(mt) {
+		return fmt.Sprintf(`"%x-%x"`, mt.Unix(), info.Size())
+	}
+
+	if v, ok := s.etags.Load(name); ok {
+		if ce := v.(*cachedETag); ce.size == info.Size() {
+			return ce.etag
+		}
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return ""
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, &cachedETag{size: info.Size(), etag: etag})
+	return etag
+}
+
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Unix() <= 0
+}
+
+// notModified checks the conditional request.
+// Reference: https://tools.ietf.org/html/rfc7232#section-6
+func notModified(h *httpheader.HTTPHeader, etag string, mt time.Time) bool {
+	if inm := h.Get(keyIfNoneMatch); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if isZeroTime(mt) {
+		return false
+	}
+	ims, err := http.ParseTime(h.Get(keyIfModifiedSince))
+	return err == nil && !mt.After(ims)
+}
+
+// ifRangeMatches reports whether the range should be sent, the whole
+// file is sent if the validator of If-Range doesn't match.
+func ifRangeMatches(h *httpheader.HTTPHeader, etag string, mt time.Time) bool {
+	ir := h.Get(keyIfRange)
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		// NOTE: Strong comparison is required by If-Range.
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !isZeroTime(mt) && t.Equal(mt)
+}
+
+// parseRange parses the Range header of bytes.
+// Reference: https://tools.ietf.org/html/rfc7233#section-2.1
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("invalid range %s", s)
+	}
+
+	var ranges []httpRange
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.IndexByte(spec, '-')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid range %s", s)
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var r httpRange
+		if first == "" {
+			// The suffix range, the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid range %s", s)
+			}
+			if n > size {
+				n = size
+			}
+			r = httpRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range %s", s)
+			}
+			if start >= size {
+				// NOTE: Unsatisfiable ranges are skipped.
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %s", s)
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("unsatisfiable range %s", s)
+	}
+	return ranges, nil
+}
+
+func (s *StaticServer) serveDir(ctx context.HTTPContext, name string) string {
+	w := ctx.Response()
+
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		w.SetStatusCode(http.StatusNotFound)
+		return resultNotFound
+	}
+
+	title := html.EscapeString(ctx.Request().Path())
+	buff := &strings.Builder{}
+	fmt.Fprintf(buff, "<!doctype html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<pre>\n", title, title)
+	if name != "." {
+		buff.WriteString("<a href=\"../\">../</a>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if !s.spec.AllowHidden && strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: entryName}).String()
+		fmt.Fprintf(buff, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(entryName))
+	}
+	buff.WriteString("</pre>\n</body>\n</html>\n")
+
+	w.Header().Set(httpheader.KeyContentType, "text/html; charset=utf-8")
+	if s.spec.IndexCacheControl != "" {
+		w.Header().Set(httpheader.KeyCacheControl, s.spec.IndexCacheControl)
+	}
+	w.SetStatusCode(http.StatusOK)
+	if ctx.Request().Method() != http.MethodHead {
+		w.SetBody(strings.NewReader(buff.String()))
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package staticserver
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of StaticServer.
+	Kind = "StaticServer"
+
+	resultNotFound         = "notFound"
+	resultMethodNotAllowed = "methodNotAllowed"
+
+	keyAllow           = "Allow"
+	keyLocation        = "Location"
+	keyLastModified    = "Last-Modified"
+	keyETag            = "ETag"
+	keyIfNoneMatch     = "If-None-Match"
+	keyIfModifiedSince = "If-Modified-Since"
+	keyIfRange         = "If-Range"
+	keyRange           = "Range"
+	keyAcceptRanges    = "Accept-Ranges"
+	keyContentRange    = "Content-Range"
+)
+
+var (
+	results = []string{resultNotFound, resultMethodNotAllowed}
+
+	defaultIndexes = []string{"index.html"}
+
+	fsMutex    sync.RWMutex
+	fsRegistry = map[string]fs.FS{}
+)
+
+func init() {
+	httppipeline.Register(&StaticServer{})
+}
+
+// RegisterFS registers a file system, e.g. an embed.FS, which could be
+// served by the StaticServer whose fs is the name.
+func RegisterFS(name string, fsys fs.FS) {
+	fsMutex.Lock()
+	defer fsMutex.Unlock()
+
+	if _, ok := fsRegistry[name]; ok {
+		panic(fmt.Errorf("file system %s registered already", name))
+	}
+	fsRegistry[name] = fsys
+}
+
+func getFS(name string) fs.FS {
+	fsMutex.RLock()
+	defer fsMutex.RUnlock()
+	return fsRegistry[name]
+}
+
+type (
+	// StaticServer is filter StaticServer.
+	StaticServer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		fsys    fs.FS
+		indexes []string
+
+		// etags caches the content hashes of files without modification
+		// time, such as files of embed.FS.
+		etags sync.Map
+	}
+
+	// Spec describes the StaticServer.
+	Spec struct {
+		// Root is the local directory to serve.
+		Root string `yaml:"root" jsonschema:"omitempty"`
+		// FS is the name of a registered file system to serve.
+		FS string `yaml:"fs" jsonschema:"omitempty"`
+		// PathPrefix is stripped from the request path to get the name
+		// of the file.
+		PathPrefix string   `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		Indexes    []string `yaml:"indexes" jsonschema:"omitempty,uniqueItems=true"`
+		Browse     bool     `yaml:"browse" jsonschema:"omitempty"`
+		// SPAFallback is the file served for the paths not found, so the
+		// routes of single page applications work.
+		SPAFallback  string `yaml:"spaFallback,omitempty" jsonschema:"omitempty,pattern=^/"`
+		CacheControl string `yaml:"cacheControl" jsonschema:"omitempty"`
+		// IndexCacheControl is the Cache-Control of index and fallback
+		// files, which usually should not be cached long.
+		IndexCacheControl string `yaml:"indexCacheControl" jsonschema:"omitempty"`
+		AllowHidden       bool   `yaml:"allowHidden" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if (spec.Root == "") == (spec.FS == "") {
+		return fmt.Errorf("one and only one of root and fs should be specified")
+	}
+	if spec.FS != "" && getFS(spec.FS) == nil {
+		return fmt.Errorf("file system %s not found", spec.FS)
+	}
+	for _, index := range spec.Indexes {
+		if strings.Contains(index, "/") {
+			return fmt.Errorf("index %s should be a file name", index)
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of StaticServer.
+func (s *StaticServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of StaticServer.
+func (s *StaticServer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of StaticServer.
+func (s *StaticServer) Description() string {
+	return "StaticServer serves files of a directory."
+}
+
+// Results returns the results of StaticServer.
+func (s *StaticServer) Results() []string {
+	return results
+}
+
+// Init initializes StaticServer.
+func (s *StaticServer) Init(filterSpec *httppipeline.FilterSpec) {
+	s.filterSpec, s.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of StaticServer.
+func (s *StaticServer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	s.Init(filterSpec)
+}
+
+func (s *StaticServer) reload() {
+	if s.spec.FS != "" {
+		s.fsys = getFS(s.spec.FS)
+	} else {
+		s.fsys = os.DirFS(s.spec.Root)
+	}
+
+	s.indexes = s.spec.Indexes
+	if len(s.indexes) == 0 {
+		s.indexes = defaultIndexes
+	}
+}
+
+// Handle serves the file of the request.
+func (s *StaticServer) Handle(ctx context.HTTPContext) string {
+	result := s.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (s *StaticServer) handle(ctx context.HTTPContext) string {
+	r, w := ctx.Request(), ctx.Response()
+
+	if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+		w.Header().Set(keyAllow, "GET, HEAD")
+		w.SetStatusCode(http.StatusMethodNotAllowed)
+		return resultMethodNotAllowed
+	}
+
+	reqPath := r.Path()
+	if s.spec.PathPrefix != "" {
+		if !hasPathPrefix(reqPath, s.spec.PathPrefix) {
+			w.SetStatusCode(http.StatusNotFound)
+			return resultNotFound
+		}
+		reqPath = "/" + strings.TrimPrefix(reqPath[len(s.spec.PathPrefix):], "/")
+	}
+
+	name, ok := s.fileName(reqPath)
+	if !ok {
+		return s.notFound(ctx)
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return s.notFound(ctx)
+	}
+
+	if info.IsDir() {
+		// NOTE: Redirect to the path with the trailing slash, so the
+		// relative links in the index work. The location is relative to
+		// the last segment, a location built from the raw path would be
+		// an open redirect for paths like '//example.com'.
+		if !strings.HasSuffix(r.Path(), "/") {
+			location := "./" + url.PathEscape(path.Base(r.Path())) + "/"
+			if r.Query() != "" {
+				location += "?" + r.Query()
+			}
+			w.Header().Set(keyLocation, location)
+			w.SetStatusCode(http.StatusMovedPermanently)
+			return ""
+		}
+
+		for _, index := range s.indexes {
+			indexName := path.Join(name, index)
+			if info, err := fs.Stat(s.fsys, indexName); err == nil && !info.IsDir() {
+				return s.serveFile(ctx, indexName, info, s.spec.IndexCacheControl)
+			}
+		}
+
+		if s.spec.Browse {
+			return s.serveDir(ctx, name)
+		}
+		return s.notFound(ctx)
+	}
+
+	return s.serveFile(ctx, name, info, s.spec.CacheControl)
+}
+
+// hasPathPrefix reports whether the request path is under the prefix,
+// which matches whole path segments only.
+func hasPathPrefix(reqPath, prefix string) bool {
+	if !strings.HasPrefix(reqPath, prefix) {
+		return false
+	}
+	return len(reqPath) == len(prefix) || strings.HasSuffix(prefix, "/") || reqPath[len(prefix)] == '/'
+}
+
+// fileName converts the request path to the name of the file system,
+// it returns false if the path is not allowed.
+func (s *StaticServer) fileName(reqPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+reqPath), "/")
+	if name == "" {
+		return ".", true
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+
+	if !s.spec.AllowHidden {
+		for _, elem := range strings.Split(name, "/") {
+			if strings.HasPrefix(elem, ".") {
+				return "", false
+			}
+		}
+	}
+	return name, true
+}
+
+func (s *StaticServer) notFound(ctx context.HTTPContext) string {
+	if s.spec.SPAFallback != "" {
+		name, _ := s.fileName(s.spec.SPAFallback)
+		if info, err := fs.Stat(s.fsys, name); err == nil && !info.IsDir() {
+			return s.serveFile(ctx, name, info, s.spec.IndexCacheControl)
+		}
+		logger.Warnf("%s: spa fallback %s not found", s.filterSpec.Name(), s.spec.SPAFallback)
+	}
+
+	ctx.Response().SetStatusCode(http.StatusNotFound)
+	return resultNotFound
+}
+
+// modTime returns the modification time of the file by the precision
+// of HTTP date.
+func modTime(info fs.FileInfo) time.Time {
+	return info.ModTime().UTC().Truncate(time.Second)
+}
+
+// Status returns status.
+func (s *StaticServer) Status() interface{} {
+	return nil
+}
+
+// Close closes StaticServer.
+func (s *StaticServer) Close() {}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package staticserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	RegisterFS("test", fstest.MapFS{
+		"index.html":    {Data: []byte("<html>index</html>")},
+		"assets/app.js": {Data: []byte("console.log('app')")},
+	})
+	code := m.Run()
+	os.Exit(code)
+}
+
+func do(s *StaticServer, method, url string, header http.Header) (*httptest.ResponseRecorder, string) {
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	result, w := httppipelinetest.Handle(s, req, nil)
+	return w, result
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0o755)
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestServeFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.html":     "<html>index</html>",
+		"css/style.css":  "body {}",
+		"data.bin":       "0123456789",
+		".env":           "SECRET=1",
+		"docs/readme.md": "readme",
+	})
+	s := httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+root: `+dir+`
+pathPrefix: /web/
+cacheControl: public, max-age=3600
+indexCacheControl: no-cache
+`).(*StaticServer)
+
+	w, _ := do(s, http.MethodGet, "http://example.com/web/css/style.css", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "body {}" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("unexpected cache control %s", w.Header().Get("Cache-Control"))
+	}
+
+	etag := w.Header().Get("ETag")
+	w, _ = do(s, http.MethodGet, "http://example.com/web/css/style.css", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("status code should be 304, got %d", w.Code)
+	}
+
+	lastModified := w.Header().Get("Last-Modified")
+	w, _ = do(s, http.MethodGet, "http://example.com/web/css/style.css", http.Header{"If-Modified-Since": {lastModified}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status code should be 304, got %d", w.Code)
+	}
+
+	w, _ = do(s, http.MethodGet, "http://example.com/web/", nil)
+	if w.Body.String() != "<html>index</html>" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("index should be served, got %s", w.Body.String())
+	}
+
+	w, _ = do(s, http.MethodGet, "http://example.com/web/css?a=1", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "./css/?a=1" {
+		t.Errorf("should redirect to the directory, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	for _, path := range []string{"/web/.env", "/web/../web/.env", "/web/docs/", "/web/missing", "/other/index.html"} {
+		w, result := do(s, http.MethodGet, "http://example.com"+path, nil)
+		if w.Code != http.StatusNotFound || result != resultNotFound {
+			t.Errorf("%s should not be found, got %d", path, w.Code)
+		}
+	}
+
+	w, result := do(s, http.MethodPost, "http://example.com/web/", nil)
+	if w.Code != http.StatusMethodNotAllowed || result != resultMethodNotAllowed {
+		t.Errorf("status code should be 405, got %d", w.Code)
+	}
+
+	w, _ = do(s, http.MethodHead, "http://example.com/web/data.bin", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("unexpected response of HEAD: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRedirectAndPathPrefix(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"evil.com/index.html": "evil",
+		"static/index.html":   "static",
+	})
+	s := httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+root: `+dir+`
+`).(*StaticServer)
+
+	for _, path := range []string{"//evil.com", "///evil.com", "/static/../evil.com"} {
+		w, _ := do(s, http.MethodGet, "http://example.com"+path, nil)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "./evil.com/" {
+			t.Errorf("%s should redirect to the relative location, got %d %s", path, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	s = httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+root: `+dir+`
+pathPrefix: /static
+`).(*StaticServer)
+	w, _ := do(s, http.MethodGet, "http://example.com/static", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "./static/" {
+		t.Errorf("should redirect to the root directory, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w, _ = do(s, http.MethodGet, "http://example.com/static/static/", nil)
+	if w.Body.String() != "static" {
+		t.Errorf("index should be served, got %d %s", w.Code, w.Body.String())
+	}
+	w, _ = do(s, http.MethodGet, "http://example.com/staticevil.com/", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("prefix should match whole path segments, got %d", w.Code)
+	}
+}
+
+func TestRange(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"data.bin": "0123456789"})
+	s := httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+root: `+dir+`
+`).(*StaticServer)
+
+	cases := []struct {
+		rangeHeader  string
+		code         int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-4", http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-2", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"bytes=5-100", http.StatusPartialContent, "56789", "bytes 5-9/10"},
+		{"bytes=0-1,5-6", http.StatusOK, "0123456789", ""},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"items=1-2", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, c := range cases {
+		w, _ := do(s, http.MethodGet, "http://example.com/data.bin", http.Header{"Range": {c.rangeHeader}})
+		if w.Code != c.code || w.Body.String() != c.body || w.Header().Get("Content-Range") != c.contentRange {
+			t.Errorf("%s: unexpected response %d %q %q", c.rangeHeader, w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+	}
+
+	w, _ := do(s, http.MethodGet, "http://example.com/data.bin", http.Header{
+		"Range":    {"bytes=2-4"},
+		"If-Range": {`"stale"`},
+	})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Errorf("whole file should be sent if If-Range doesn't match, got %d", w.Code)
+	}
+}
+
+func TestBrowseAndFallback(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.html":    "<html>app</html>",
+		"files/a<b>":  "a",
+		"files/sub/c": "c",
+	})
+	s := httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+root: `+dir+`
+browse: true
+spaFallback: /app.html
+`).(*StaticServer)
+
+	w, _ := do(s, http.MethodGet, "http://example.com/files/", nil)
+	body := w.Body.String()
+	if !strings.Contains(body, `href="a%3Cb%3E"`) || !strings.Contains(body, "a&lt;b&gt;") || !strings.Contains(body, `href="sub/"`) {
+		t.Errorf("unexpected listing: %s", body)
+	}
+
+	w, result := do(s, http.MethodGet, "http://example.com/users/1", nil)
+	if result != "" || w.Code != http.StatusOK || w.Body.String() != "<html>app</html>" {
+		t.Errorf("spa fallback should be served, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEmbeddedFS(t *testing.T) {
+	s := httppipelinetest.InitFilter(t, &StaticServer{}, `
+kind: StaticServer
+name: static
+fs: test
+`).(*StaticServer)
+
+	w, _ := do(s, http.MethodGet, "http://example.com/assets/app.js", nil)
+	if w.Body.String() != "console.log('app')" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("etag should be computed from the content")
+	}
+	w, _ = do(s, http.MethodGet, "http://example.com/assets/app.js", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status code should be 304, got %d", w.Code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if (Spec{}).Validate() == nil {
+		t.Error("root or fs is required")
+	}
+	if (Spec{Root: "/tmp", FS: "test"}).Validate() == nil {
+		t.Error("only one of root and fs is allowed")
+	}
+	if (Spec{FS: "missing"}).Validate() == nil {
+		t.Error("fs should be registered")
+	}
+	if (Spec{FS: "test"}).Validate() != nil {
+		t.Error("spec should be valid")
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package thriftproxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apache/thrift/lib/go/thrift"
+
+	"github.com/megaease/easegress/pkg/util/rpcbridge"
+)
+
+const (
+	transportFramed   = "framed"
+	transportBuffered = "buffered"
+
+	protocolBinary  = "binary"
+	protocolCompact = "compact"
+
+	bufferSize   = 4096
+	maxFrameSize = 8 << 20
+	successField = "0"
+)
+
+type (
+	// invocation is a call of a Thrift method, the args are the fields
+	// of the args struct.
+	invocation struct {
+		name string
+		args []*arg
+	}
+
+	arg struct {
+		id    int16
+		typ   *thriftType
+		value interface{}
+	}
+
+	// reply is the result struct of the method, the success value is in
+	// field 0, and the declared exceptions are in the other fields.
+	reply struct {
+		success   interface{}
+		exception map[string]interface{}
+	}
+
+	client struct {
+		pool      *rpcbridge.ConnPool
+		transport string
+		protocol  string
+		counter   int32
+		conf      *thrift.TConfiguration
+	}
+)
+
+func newClient(pool *rpcbridge.ConnPool, transport, protocol string) *client {
+	return &client{
+		pool:      pool,
+		transport: transport,
+		protocol:  protocol,
+		conf: &thrift.TConfiguration{
+			MaxFrameSize:       maxFrameSize,
+			MaxMessageSize:     maxFrameSize,
+			TBinaryStrictRead:  thrift.BoolPtr(false),
+			TBinaryStrictWrite: thrift.BoolPtr(true),
+		},
+	}
+}
+
+func (c *client) newProtocol(rw io.ReadWriter) (thrift.TProtocol, thrift.TTransport) {
+	var trans thrift.TTransport = thrift.NewStreamTransportRW(rw)
+	if c.transport == transportFramed {
+		trans = thrift.NewTFramedTransportConf(trans, c.conf)
+	}
+
+	if c.protocol == protocolCompact {
+		return thrift.NewTCompactProtocolConf(trans, c.conf), trans
+	}
+	return thrift.NewTBinaryProtocolConf(trans, c.conf), trans
+}
+
+// encode encodes the call message, it returns error if the args are
+// not valid for their types.
+func (c *client) encode(ctx stdcontext.Context, seqID int32, inv *invocation) ([]byte, error) {
+	buf := thrift.NewTMemoryBufferLen(bufferSize)
+	p, trans := c.newProtocol(buf)
+
+	if err := p.WriteMessageBegin(ctx, inv.name, thrift.CALL, seqID); err != nil {
+		return nil, err
+	}
+	if err := p.WriteStructBegin(ctx, "args"); err != nil {
+		return nil, err
+	}
+	for _, a := range inv.args {
+		if a.value == nil {
+			continue
+		}
+		if err := p.WriteFieldBegin(ctx, "", a.typ.ttype, a.id); err != nil {
+			return nil, err
+		}
+		if err := writeValue(ctx, p, a.typ, a.value); err != nil {
+			return nil, fmt.Errorf("field %d: %v", a.id, err)
+		}
+		if err := p.WriteFieldEnd(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.WriteFieldStop(ctx); err != nil {
+		return nil, err
+	}
+	if err := p.WriteStructEnd(ctx); err != nil {
+		return nil, err
+	}
+	if err := p.WriteMessageEnd(ctx); err != nil {
+		return nil, err
+	}
+	if err := trans.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// invoke calls the method, the args must be encoded by encode first.
+// It returns the exception message if the server replies with an
+// application exception, and returns error if the call fails.
+func (c *client) invoke(ctx stdcontext.Context, seqID int32, name string, packet []byte) (*reply, string, error) {
+	conn, err := c.pool.Get(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	rep, exception, err := c.roundTrip(ctx, conn, seqID, name, packet)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("%s: %v", conn.Server, err)
+	}
+	c.pool.Put(conn)
+	return rep, exception, nil
+}
+
+func (c *client) nextSeqID() int32 {
+	return atomic.AddInt32(&c.counter, 1)
+}
+
+func (c *client) roundTrip(ctx stdcontext.Context, conn *rpcbridge.Conn, seqID int32, name string, packet []byte) (*reply, string, error) {
+	if _, err := conn.Write(packet); err != nil {
+		return nil, "", err
+	}
+
+	p, _ := c.newProtocol(conn)
+	rname, mtype, rseqID, err := p.ReadMessageBegin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	// NOTE: The multiplexed servers reply the method name without the
+	// service name.
+	if rseqID != seqID || (rname != name && !strings.HasSuffix(name, thrift.MULTIPLEXED_SEPARATOR+rname)) {
+		return nil, "", fmt.Errorf("unexpected reply %s(%d) to %s(%d)", rname, rseqID, name, seqID)
+	}
+
+	switch mtype {
+	case thrift.EXCEPTION:
+		ex := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "")
+		if err = ex.Read(ctx, p); err != nil {
+			return nil, "", err
+		}
+		if err = p.ReadMessageEnd(ctx); err != nil {
+			return nil, "", err
+		}
+		return nil, ex.Error(), nil
+	case thrift.REPLY:
+	default:
+		return nil, "", fmt.Errorf("unexpected message type %d", mtype)
+	}
+
+	result, err := readStruct(ctx, p, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = p.ReadMessageEnd(ctx); err != nil {
+		return nil, "", err
+	}
+
+	rep := &reply{success: result[successField]}
+	delete(result, successField)
+	if len(result) > 0 {
+		rep.exception = result
+	}
+	return rep, "", nil
+}
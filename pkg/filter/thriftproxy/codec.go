@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package thriftproxy
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const maxDepth = 64
+
+type (
+	// thriftType is the parsed type of a parameter or a field.
+	thriftType struct {
+		ttype  thrift.TType
+		binary bool
+		key    *thriftType
+		elem   *thriftType
+		fields []*thriftField
+	}
+
+	thriftField struct {
+		id   int16
+		name string
+		typ  *thriftType
+	}
+)
+
+var primitiveTypes = map[string]thrift.TType{
+	"bool":   thrift.BOOL,
+	"byte":   thrift.BYTE,
+	"i8":     thrift.I08,
+	"i16":    thrift.I16,
+	"i32":    thrift.I32,
+	"i64":    thrift.I64,
+	"double": thrift.DOUBLE,
+	"string": thrift.STRING,
+	"binary": thrift.STRING,
+}
+
+// parseType parses the type expression, which is a primitive type,
+// list<T>, set<T>, map<K,V> or struct, the fields are the fields of
+// the innermost struct.
+func parseType(expr string, fields []*Field) (*thriftType, error) {
+	expr = strings.TrimSpace(expr)
+
+	if ttype, ok := primitiveTypes[expr]; ok {
+		return &thriftType{ttype: ttype, binary: expr == "binary"}, nil
+	}
+
+	if expr == "struct" {
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("struct requires fields")
+		}
+		t := &thriftType{ttype: thrift.STRUCT}
+		for _, f := range fields {
+			ft, err := parseType(f.Type, nil)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", f.Name, err)
+			}
+			t.fields = append(t.fields, &thriftField{id: f.ID, name: f.Name, typ: ft})
+		}
+		return t, nil
+	}
+
+	lt, gt := strings.IndexByte(expr, '<'), strings.LastIndexByte(expr, '>')
+	if lt < 0 || gt != len(expr)-1 {
+		return nil, fmt.Errorf("invalid type %q", expr)
+	}
+	inner := expr[lt+1 : gt]
+
+	switch strings.TrimSpace(expr[:lt]) {
+	case "list", "set":
+		elem, err := parseType(inner, fields)
+		if err != nil {
+			return nil, err
+		}
+		var ttype thrift.TType = thrift.LIST
+		if strings.HasPrefix(expr, "set") {
+			ttype = thrift.SET
+		}
+		return &thriftType{ttype: ttype, elem: elem}, nil
+	case "map":
+		comma := strings.IndexByte(inner, ',')
+		if comma < 0 {
+			return nil, fmt.Errorf("invalid type %q", expr)
+		}
+		key, err := parseType(inner[:comma], nil)
+		if err != nil {
+			return nil, err
+		}
+		switch key.ttype {
+		case thrift.STRUCT, thrift.LIST, thrift.SET, thrift.MAP:
+			return nil, fmt.Errorf("invalid map key type %q", inner[:comma])
+		}
+		elem, err := parseType(inner[comma+1:], fields)
+		if err != nil {
+			return nil, err
+		}
+		return &thriftType{ttype: thrift.MAP, key: key, elem: elem}, nil
+	}
+
+	return nil, fmt.Errorf("invalid type %q", expr)
+}
+
+// writeValue writes the value from the request as the type, the values
+// of the primitive types could be strings, and the binaries are base64
+// encoded strings.
+func writeValue(ctx stdcontext.Context, p thrift.TProtocol, t *thriftType, v interface{}) error {
+	s, isString := v.(string)
+	if n, ok := v.(json.Number); ok {
+		s, isString = n.String(), true
+	}
+
+	switch t.ttype {
+	case thrift.BOOL:
+		if b, ok := v.(bool); ok {
+			return p.WriteBool(ctx, b)
+		}
+		if isString {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			return p.WriteBool(ctx, b)
+		}
+	case thrift.BYTE, thrift.I16, thrift.I32, thrift.I64:
+		if isString {
+			bits := map[thrift.TType]int{thrift.BYTE: 8, thrift.I16: 16, thrift.I32: 32, thrift.I64: 64}[t.ttype]
+			i, err := strconv.ParseInt(s, 10, bits)
+			if err != nil {
+				return err
+			}
+			switch t.ttype {
+			case thrift.BYTE:
+				return p.WriteByte(ctx, int8(i))
+			case thrift.I16:
+				return p.WriteI16(ctx, int16(i))
+			case thrift.I32:
+				return p.WriteI32(ctx, int32(i))
+			default:
+				return p.WriteI64(ctx, i)
+			}
+		}
+	case thrift.DOUBLE:
+		if isString {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			return p.WriteDouble(ctx, f)
+		}
+	case thrift.STRING:
+		if !isString {
+			return fmt.Errorf("%v is not a string", v)
+		}
+		if !t.binary {
+			return p.WriteString(ctx, s)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		return p.WriteBinary(ctx, b)
+	case thrift.LIST, thrift.SET:
+		l, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an array", v)
+		}
+		return writeList(ctx, p, t, l)
+	case thrift.MAP:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an object", v)
+		}
+		return writeMap(ctx, p, t, m)
+	case thrift.STRUCT:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not an object", v)
+		}
+		return writeStruct(ctx, p, t, m)
+	}
+
+	return fmt.Errorf("%v is not a %s", v, t.ttype)
+}
+
+func writeList(ctx stdcontext.Context, p thrift.TProtocol, t *thriftType, l []interface{}) error {
+	var err error
+	if t.ttype == thrift.SET {
+		err = p.WriteSetBegin(ctx, t.elem.ttype, len(l))
+	} else {
+		err = p.WriteListBegin(ctx, t.elem.ttype, len(l))
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, e := range l {
+		if e == nil {
+			return fmt.Errorf("element %d is null", i)
+		}
+		if err = writeValue(ctx, p, t.elem, e); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+
+	if t.ttype == thrift.SET {
+		return p.WriteSetEnd(ctx)
+	}
+	return p.WriteListEnd(ctx)
+}
+
+func writeMap(ctx stdcontext.Context, p thrift.TProtocol, t *thriftType, m map[string]interface{}) error {
+	if err := p.WriteMapBegin(ctx, t.key.ttype, t.elem.ttype, len(m)); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if v == nil {
+			return fmt.Errorf("value of %s is null", k)
+		}
+		if err := writeValue(ctx, p, t.key, k); err != nil {
+			return fmt.Errorf("key %s: %v", k, err)
+		}
+		if err := writeValue(ctx, p, t.elem, v); err != nil {
+			return fmt.Errorf("value of %s: %v", k, err)
+		}
+	}
+	return p.WriteMapEnd(ctx)
+}
+
+// writeStruct writes the struct, the absent fields are omitted, so they
+// are treated as unset optional fields by the servers.
+func writeStruct(ctx stdcontext.Context, p thrift.TProtocol, t *thriftType, m map[string]interface{}) error {
+	if err := p.WriteStructBegin(ctx, ""); err != nil {
+		return err
+	}
+	for _, f := range t.fields {
+		v := m[f.name]
+		if v == nil {
+			continue
+		}
+		if err := p.WriteFieldBegin(ctx, f.name, f.typ.ttype, f.id); err != nil {
+			return err
+		}
+		if err := writeValue(ctx, p, f.typ, v); err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		if err := p.WriteFieldEnd(ctx); err != nil {
+			return err
+		}
+	}
+	if err := p.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	return p.WriteStructEnd(ctx)
+}
+
+// readValue reads a value which could be encoded as JSON, as there is
+// no IDL, the fields of the structs are keyed by their ids, and the
+// binaries are read as strings.
+func readValue(ctx stdcontext.Context, p thrift.TProtocol, ttype thrift.TType, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("depth exceeds %d", maxDepth)
+	}
+
+	switch ttype {
+	case thrift.BOOL:
+		return p.ReadBool(ctx)
+	case thrift.BYTE:
+		return p.ReadByte(ctx)
+	case thrift.I16:
+		return p.ReadI16(ctx)
+	case thrift.I32:
+		return p.ReadI32(ctx)
+	case thrift.I64:
+		return p.ReadI64(ctx)
+	case thrift.DOUBLE:
+		f, err := p.ReadDouble(ctx)
+		if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return fmt.Sprint(f), nil
+		}
+		return f, err
+	case thrift.STRING:
+		return p.ReadString(ctx)
+	case thrift.LIST, thrift.SET:
+		var elemType thrift.TType
+		var size int
+		var err error
+		if ttype == thrift.SET {
+			elemType, size, err = p.ReadSetBegin(ctx)
+		} else {
+			elemType, size, err = p.ReadListBegin(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		l := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			e, err := readValue(ctx, p, elemType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, e)
+		}
+		if ttype == thrift.SET {
+			return l, p.ReadSetEnd(ctx)
+		}
+		return l, p.ReadListEnd(ctx)
+	case thrift.MAP:
+		keyType, valueType, size, err := p.ReadMapBegin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, err := readValue(ctx, p, keyType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readValue(ctx, p, valueType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+		}
+		return m, p.ReadMapEnd(ctx)
+	case thrift.STRUCT:
+		return readStruct(ctx, p, depth)
+	}
+
+	return nil, fmt.Errorf("unknown type %d", ttype)
+}
+
+func readStruct(ctx stdcontext.Context, p thrift.TProtocol, depth int) (map[string]interface{}, error) {
+	if _, err := p.ReadStructBegin(ctx); err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	for {
+		_, ttype, id, err := p.ReadFieldBegin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if ttype == thrift.STOP {
+			break
+		}
+		v, err := readValue(ctx, p, ttype, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m[strconv.Itoa(int(id))] = v
+		if err = p.ReadFieldEnd(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return m, p.ReadStructEnd(ctx)
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package thriftproxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/rpcbridge"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ThriftProxy.
+	Kind = "ThriftProxy"
+
+	resultNoRoute        = "noRoute"
+	resultInvalidRequest = "invalidRequest"
+	resultServerError    = "serverError"
+)
+
+var results = []string{resultNoRoute, resultInvalidRequest, resultServerError}
+
+func init() {
+	httppipeline.Register(&ThriftProxy{})
+}
+
+type (
+	// ThriftProxy translates RESTful HTTP requests into Thrift calls.
+	ThriftProxy struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		timeout time.Duration
+		pool    *rpcbridge.ConnPool
+		client  *client
+
+		calls      uint64
+		exceptions uint64
+		failures   uint64
+	}
+
+	// Spec is the spec of ThriftProxy.
+	Spec struct {
+		// Servers are the addresses of the Thrift servers in the form
+		// of host:port, they are chosen by round-robin.
+		Servers      []string  `yaml:"servers" jsonschema:"required,minItems=1,uniqueItems=true"`
+		Timeout      string    `yaml:"timeout" jsonschema:"required,format=duration"`
+		MaxIdleConns int       `yaml:"maxIdleConns" jsonschema:"required,minimum=1"`
+		Transport    string    `yaml:"transport" jsonschema:"required,enum=framed,enum=buffered"`
+		Protocol     string    `yaml:"protocol" jsonschema:"required,enum=binary,enum=compact"`
+		Methods      []*Method `yaml:"methods" jsonschema:"required,minItems=1"`
+	}
+
+	// Method maps a RESTful endpoint to a Thrift method. As there is no
+	// IDL, the types of the parameters are declared in the spec, and the
+	// fields of the structs in the result are keyed by their ids.
+	Method struct {
+		Method string `yaml:"method" jsonschema:"required,format=httpmethod"`
+		// Path is the path template, e.g. /v1/users/{id}.
+		Path string `yaml:"path" jsonschema:"required,pattern=^/"`
+		// Service is the service name of the multiplexed servers.
+		Service   string   `yaml:"service" jsonschema:"omitempty"`
+		RPCMethod string   `yaml:"rpcMethod" jsonschema:"required"`
+		Params    []*Param `yaml:"params" jsonschema:"omitempty"`
+
+		endpoint *rpcbridge.Endpoint
+		name     string
+		types    []*thriftType
+	}
+
+	// Param is a parameter of the Thrift method.
+	Param struct {
+		ID int16 `yaml:"id" jsonschema:"required,minimum=1"`
+		// Type is the Thrift type of the parameter, one of bool, byte,
+		// i8, i16, i32, i64, double, string, binary, list<T>, set<T>,
+		// map<K,V> and struct.
+		Type string `yaml:"type" jsonschema:"required"`
+		// From is the source of the value, one of path.NAME, query.NAME,
+		// header.NAME, body and body.FIELD.
+		From string `yaml:"from" jsonschema:"required"`
+		// Fields are the fields of the struct, which is the parameter
+		// itself or the innermost element of the containers.
+		Fields []*Field `yaml:"fields" jsonschema:"omitempty"`
+	}
+
+	// Field is a field of the struct, its value is the member of the
+	// JSON object with the same name. The nested structs are not
+	// supported, so its type must not be or contain a struct.
+	Field struct {
+		ID   int16  `yaml:"id" jsonschema:"required,minimum=1"`
+		Name string `yaml:"name" jsonschema:"required"`
+		Type string `yaml:"type" jsonschema:"required"`
+	}
+
+	// Status is the status of ThriftProxy.
+	Status struct {
+		Calls      uint64 `yaml:"calls"`
+		Exceptions uint64 `yaml:"exceptions"`
+		Failures   uint64 `yaml:"failures"`
+	}
+)
+
+// Validate validates Param.
+func (p Param) Validate() error {
+	if err := rpcbridge.ValidateSource(p.From); err != nil {
+		return err
+	}
+	_, err := parseType(p.Type, p.Fields)
+	return err
+}
+
+// Validate validates Method.
+func (m Method) Validate() error {
+	ids := map[int16]bool{}
+	for _, p := range m.Params {
+		if ids[p.ID] {
+			return fmt.Errorf("duplicated param id %d", p.ID)
+		}
+		ids[p.ID] = true
+	}
+	return nil
+}
+
+// Kind returns the kind of ThriftProxy.
+func (tp *ThriftProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ThriftProxy.
+func (tp *ThriftProxy) DefaultSpec() interface{} {
+	return &Spec{
+		Timeout:      "3s",
+		MaxIdleConns: 16,
+		Transport:    transportFramed,
+		Protocol:     protocolBinary,
+	}
+}
+
+// Description returns the description of ThriftProxy.
+func (tp *ThriftProxy) Description() string {
+	return "ThriftProxy translates RESTful HTTP requests into Thrift calls."
+}
+
+// Results returns the results of ThriftProxy.
+func (tp *ThriftProxy) Results() []string {
+	return results
+}
+
+// Init initializes ThriftProxy.
+func (tp *ThriftProxy) Init(filterSpec *httppipeline.FilterSpec) {
+	tp.filterSpec, tp.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	tp.reload()
+}
+
+// Inherit inherits previous generation of ThriftProxy.
+func (tp *ThriftProxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	tp.Init(filterSpec)
+}
+
+func (tp *ThriftProxy) reload() {
+	var err error
+	tp.timeout, err = time.ParseDuration(tp.spec.Timeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", tp.spec.Timeout, err)
+		tp.timeout = 3 * time.Second
+	}
+
+	for _, m := range tp.spec.Methods {
+		m.endpoint = rpcbridge.NewEndpoint(m.Method, m.Path)
+		m.name = m.RPCMethod
+		if m.Service != "" {
+			m.name = m.Service + thrift.MULTIPLEXED_SEPARATOR + m.RPCMethod
+		}
+		m.types = make([]*thriftType, len(m.Params))
+		for i, p := range m.Params {
+			m.types[i], err = parseType(p.Type, p.Fields)
+			if err != nil {
+				logger.Errorf("BUG: parse type %s failed: %v", p.Type, err)
+			}
+		}
+	}
+
+	tp.pool = rpcbridge.NewConnPool(tp.spec.Servers, tp.spec.MaxIdleConns)
+	tp.client = newClient(tp.pool, tp.spec.Transport, tp.spec.Protocol)
+}
+
+// Handle handles HTTP request.
+func (tp *ThriftProxy) Handle(ctx context.HTTPContext) string {
+	result := tp.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (tp *ThriftProxy) handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	var method *Method
+	var vars map[string]string
+	for _, m := range tp.spec.Methods {
+		if v, ok := m.endpoint.Match(r.Method(), r.Path()); ok {
+			method, vars = m, v
+			break
+		}
+	}
+	if method == nil {
+		rpcbridge.WriteError(ctx, http.StatusNotFound, "no method matches the request")
+		return resultNoRoute
+	}
+
+	req, err := rpcbridge.NewRequest(ctx, vars)
+	if err != nil {
+		rpcbridge.WriteError(ctx, http.StatusBadRequest, err.Error())
+		return resultInvalidRequest
+	}
+
+	inv := &invocation{name: method.name, args: make([]*arg, 0, len(method.Params))}
+	for i, p := range method.Params {
+		inv.args = append(inv.args, &arg{id: p.ID, typ: method.types[i], value: req.Value(p.From)})
+	}
+
+	seqID := tp.client.nextSeqID()
+	packet, err := tp.client.encode(ctx, seqID, inv)
+	if err != nil {
+		rpcbridge.WriteError(ctx, http.StatusBadRequest, fmt.Sprintf("invalid params: %v", err))
+		return resultInvalidRequest
+	}
+
+	timeoutCtx, cancel := stdcontext.WithTimeout(ctx, tp.timeout)
+	defer cancel()
+
+	atomic.AddUint64(&tp.calls, 1)
+	rep, exception, err := tp.client.invoke(timeoutCtx, seqID, method.name, packet)
+	if err != nil {
+		atomic.AddUint64(&tp.failures, 1)
+		ctx.AddTag(stringtool.Cat(tp.filterSpec.Name(), ": call thrift failed: ", err.Error()))
+		rpcbridge.WriteError(ctx, http.StatusBadGateway, err.Error())
+		return resultServerError
+	}
+	if exception != "" {
+		atomic.AddUint64(&tp.exceptions, 1)
+		rpcbridge.WriteError(ctx, http.StatusInternalServerError, exception)
+		return resultServerError
+	}
+	if rep.exception != nil {
+		atomic.AddUint64(&tp.exceptions, 1)
+		rpcbridge.WriteJSON(ctx, http.StatusInternalServerError, map[string]interface{}{
+			"error":     "method throws an exception",
+			"exception": rep.exception,
+		})
+		return resultServerError
+	}
+
+	if err = rpcbridge.WriteJSON(ctx, http.StatusOK, rep.success); err != nil {
+		rpcbridge.WriteError(ctx, http.StatusInternalServerError, fmt.Sprintf("encode result failed: %v", err))
+		return resultServerError
+	}
+	return ""
+}
+
+// Status returns Status generated by ThriftProxy.
+func (tp *ThriftProxy) Status() interface{} {
+	return &Status{
+		Calls:      atomic.LoadUint64(&tp.calls),
+		Exceptions: atomic.LoadUint64(&tp.exceptions),
+		Failures:   atomic.LoadUint64(&tp.failures),
+	}
+}
+
+// Close closes ThriftProxy.
+func (tp *ThriftProxy) Close() {
+	tp.pool.Close()
+}
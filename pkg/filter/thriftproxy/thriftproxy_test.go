@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package thriftproxy
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// serveUserService serves a fake UserService with the framed transport
+// and the binary protocol.
+func serveUserService(t *testing.T, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			ctx := stdcontext.Background()
+			c := newClient(nil, transportFramed, protocolBinary)
+			p, trans := c.newProtocol(conn)
+			for {
+				name, _, seqID, err := p.ReadMessageBegin(ctx)
+				if err != nil {
+					return
+				}
+				args, err := readStruct(ctx, p, 0)
+				if err != nil {
+					t.Errorf("decode args failed: %v", err)
+					return
+				}
+				p.ReadMessageEnd(ctx)
+
+				var result map[string]interface{}
+				switch {
+				case name == "getUser" && args["1"] == int64(7):
+					result = map[string]interface{}{"0": map[string]interface{}{"id": "7", "name": "alice"}}
+				case name == "getUser":
+					result = map[string]interface{}{"1": map[string]interface{}{"message": "user not found"}}
+				case name == "UserService:createUser":
+					user := args["1"].(map[string]interface{})
+					result = map[string]interface{}{"0": map[string]interface{}{
+						"name": user["1"].(string),
+						"tag":  args["2"].([]interface{})[0].(string),
+					}}
+				default:
+					ex := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method "+name)
+					p.WriteMessageBegin(ctx, name, thrift.EXCEPTION, seqID)
+					ex.Write(ctx, p)
+					p.WriteMessageEnd(ctx)
+					trans.Flush(ctx)
+					continue
+				}
+
+				if strings.Contains(name, thrift.MULTIPLEXED_SEPARATOR) {
+					name = strings.SplitN(name, thrift.MULTIPLEXED_SEPARATOR, 2)[1]
+				}
+				p.WriteMessageBegin(ctx, name, thrift.REPLY, seqID)
+				p.WriteStructBegin(ctx, "result")
+				for id, v := range result {
+					fid := int16(0)
+					if id == "1" {
+						fid = 1
+					}
+					p.WriteFieldBegin(ctx, "", thrift.STRUCT, fid)
+					p.WriteStructBegin(ctx, "")
+					i := int16(1)
+					for _, value := range v.(map[string]interface{}) {
+						p.WriteFieldBegin(ctx, "", thrift.STRING, i)
+						p.WriteString(ctx, value.(string))
+						p.WriteFieldEnd(ctx)
+						i++
+					}
+					p.WriteFieldStop(ctx)
+					p.WriteStructEnd(ctx)
+					p.WriteFieldEnd(ctx)
+				}
+				p.WriteFieldStop(ctx)
+				p.WriteStructEnd(ctx)
+				p.WriteMessageEnd(ctx)
+				trans.Flush(ctx)
+			}
+		}()
+	}
+}
+
+func doRequest(tp *ThriftProxy, method, path, body string) (string, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	return httppipelinetest.Handle(tp, req, nil)
+}
+
+func TestThriftProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	go serveUserService(t, ln)
+
+	tp := httppipelinetest.InitFilter(t, &ThriftProxy{}, `
+kind: ThriftProxy
+name: thrift
+servers: [`+ln.Addr().String()+`]
+methods:
+- method: GET
+  path: /users/{id}
+  rpcMethod: getUser
+  params:
+  - id: 1
+    type: i64
+    from: path.id
+- method: POST
+  path: /users
+  service: UserService
+  rpcMethod: createUser
+  params:
+  - id: 1
+    type: struct
+    from: body
+    fields:
+    - id: 1
+      name: name
+      type: string
+    - id: 2
+      name: age
+      type: i32
+  - id: 2
+    type: list<string>
+    from: body.tags
+- method: DELETE
+  path: /users/{id}
+  rpcMethod: deleteUser
+  params:
+  - id: 1
+    type: i64
+    from: path.id
+`).(*ThriftProxy)
+	defer tp.Close()
+
+	result, w := doRequest(tp, http.MethodGet, "/users/7", "")
+	if result != "" || w.Code != http.StatusOK {
+		t.Fatalf("unexpected result %q, %d, %s", result, w.Code, w.Body.String())
+	}
+	user := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &user)
+	if len(user) != 2 || (user["1"] != "alice" && user["2"] != "alice") {
+		t.Errorf("unexpected user %s", w.Body.String())
+	}
+
+	result, w = doRequest(tp, http.MethodPost, "/users", `{"name": "bob", "age": 20, "tags": ["admin"]}`)
+	if result != "" || !strings.Contains(w.Body.String(), `"bob"`) || !strings.Contains(w.Body.String(), `"admin"`) {
+		t.Errorf("unexpected result %q, %s", result, w.Body.String())
+	}
+
+	result, w = doRequest(tp, http.MethodGet, "/users/8", "")
+	if result != resultServerError || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "user not found") {
+		t.Errorf("exception should be returned, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	result, w = doRequest(tp, http.MethodDelete, "/users/7", "")
+	if result != resultServerError || w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "unknown method") {
+		t.Errorf("application exception should be returned, got %q, %d, %s", result, w.Code, w.Body.String())
+	}
+
+	if result, w = doRequest(tp, http.MethodGet, "/users/abc", ""); result != resultInvalidRequest || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid id should be rejected, got %q, %d", result, w.Code)
+	}
+	if result, w = doRequest(tp, http.MethodPost, "/users", `{"name": "bob", "age": "old"}`); result != resultInvalidRequest || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid age should be rejected, got %q, %d", result, w.Code)
+	}
+	if result, w = doRequest(tp, http.MethodPut, "/users/7", ""); result != resultNoRoute || w.Code != http.StatusNotFound {
+		t.Errorf("request should not be routed, got %q, %d", result, w.Code)
+	}
+
+	if s := tp.Status().(*Status); s.Calls != 4 || s.Exceptions != 2 || s.Failures != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tp := httppipelinetest.InitFilter(t, &ThriftProxy{}, `
+kind: ThriftProxy
+name: thrift
+servers: [`+addr+`]
+methods:
+- method: GET
+  path: /users/{id}
+  rpcMethod: getUser
+  params:
+  - id: 1
+    type: i64
+    from: path.id
+`).(*ThriftProxy)
+	defer tp.Close()
+
+	if result, w := doRequest(tp, http.MethodGet, "/users/7", ""); result != resultServerError || w.Code != http.StatusBadGateway {
+		t.Errorf("unexpected result %q, %d", result, w.Code)
+	}
+	if s := tp.Status().(*Status); s.Failures != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for _, expr := range []string{"i32", "binary", "list<string>", "map<string, list<i64>>", "set<double>"} {
+		if _, err := parseType(expr, nil); err != nil {
+			t.Errorf("%s should be valid: %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"int", "list<>", "map<string>", "map<list<i32>,string>", "struct", "list<string"} {
+		if _, err := parseType(expr, nil); err == nil {
+			t.Errorf("%s should be invalid", expr)
+		}
+	}
+
+	typ, err := parseType("list<struct>", []*Field{{ID: 1, Name: "name", Type: "string"}})
+	if err != nil || typ.elem.ttype != thrift.STRUCT || len(typ.elem.fields) != 1 {
+		t.Errorf("unexpected type %+v, %v", typ, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, params := range []string{`
+  - id: 1
+    type: i64
+    from: cookie.id`, `
+  - id: 1
+    type: long
+    from: path.id`, `
+  - id: 1
+    type: i64
+    from: path.id
+  - id: 1
+    type: string
+    from: query.name`,
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(`
+kind: ThriftProxy
+name: thrift
+servers: [127.0.0.1:9090]
+methods:
+- method: GET
+  path: /users/{id}
+  rpcMethod: getUser
+  params:`+params), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("invalid params should be rejected: %s", params)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package timelimiter
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errIdleTimeout = fmt.Errorf("idle timeout")
+
+// idleTimeoutReader closes the body if a read waits for the next bytes
+// longer than the timeout, the time between reads isn't counted, so a
+// slow client doesn't time out the backend.
+type idleTimeoutReader struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut int32
+}
+
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration, onTimeout func()) *idleTimeoutReader {
+	r := &idleTimeoutReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&r.timedOut, 1)
+		onTimeout()
+		body.Close()
+	})
+	r.timer.Stop()
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.timedOut) == 1 {
+		return 0, errIdleTimeout
+	}
+
+	r.timer.Reset(r.timeout)
+	n, err := r.body.Read(p)
+	r.timer.Stop()
+
+	if atomic.LoadInt32(&r.timedOut) == 1 {
+		return n, errIdleTimeout
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
@@ -19,7 +19,9 @@ package timelimiter
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -49,13 +51,30 @@ type (
 		urlrule.URLRule `yaml:",inline"`
 		TimeoutDuration string `yaml:"timeoutDuration" jsonschema:"omitempty,format=duration"`
 		timeout         time.Duration
+		// PerTryTimeout limits each request to the backends, e.g. each
+		// attempt of the retries.
+		PerTryTimeout string `yaml:"perTryTimeout" jsonschema:"omitempty,format=duration"`
+		perTryTimeout time.Duration
+		// IdleTimeout limits the time waiting for the next bytes of the
+		// response body, for streaming responses.
+		IdleTimeout string `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		idleTimeout time.Duration
 	}
 
 	// Spec is the spec of time limiter
 	Spec struct {
 		DefaultTimeoutDuration string `yaml:"defaultTimeoutDuration" jsonschema:"omitempty,format=duration"`
 		defaultTimeout         time.Duration
-		URLs                   []*URLRule `yaml:"urls" jsonschema:"required"`
+		DefaultPerTryTimeout   string `yaml:"defaultPerTryTimeout" jsonschema:"omitempty,format=duration"`
+		defaultPerTryTimeout   time.Duration
+		DefaultIdleTimeout     string `yaml:"defaultIdleTimeout" jsonschema:"omitempty,format=duration"`
+		defaultIdleTimeout     time.Duration
+		// BudgetHeader is the header of the remaining time budget in
+		// milliseconds, the budget from the clients shortens the timeout,
+		// and the remaining budget when forwarding is set to it for the
+		// backends.
+		BudgetHeader string     `yaml:"budgetHeader" jsonschema:"omitempty"`
+		URLs         []*URLRule `yaml:"urls" jsonschema:"required"`
 	}
 
 	// TimeLimiter is the time limiter struct
@@ -95,6 +114,9 @@ func (tl *TimeLimiter) Init(filterSpec *httppipeline.FilterSpec) {
 		tl.spec.defaultTimeout = 500 * time.Millisecond
 	}
 
+	tl.spec.defaultPerTryTimeout, _ = time.ParseDuration(tl.spec.DefaultPerTryTimeout)
+	tl.spec.defaultIdleTimeout, _ = time.ParseDuration(tl.spec.DefaultIdleTimeout)
+
 	for _, url := range tl.spec.URLs {
 		url.Init()
 		if d := url.TimeoutDuration; d != "" {
@@ -102,6 +124,16 @@ func (tl *TimeLimiter) Init(filterSpec *httppipeline.FilterSpec) {
 		} else {
 			url.timeout = tl.spec.defaultTimeout
 		}
+		if d := url.PerTryTimeout; d != "" {
+			url.perTryTimeout, _ = time.ParseDuration(d)
+		} else {
+			url.perTryTimeout = tl.spec.defaultPerTryTimeout
+		}
+		if d := url.IdleTimeout; d != "" {
+			url.idleTimeout, _ = time.ParseDuration(d)
+		} else {
+			url.idleTimeout = tl.spec.defaultIdleTimeout
+		}
 	}
 }
 
@@ -110,12 +142,51 @@ func (tl *TimeLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGene
 	tl.Init(filterSpec)
 }
 
+// budget returns the total timeout of the request, which is shortened by
+// the remaining budget from the client.
+func (tl *TimeLimiter) budget(ctx context.HTTPContext, u *URLRule) time.Duration {
+	if tl.spec.BudgetHeader == "" {
+		return u.timeout
+	}
+
+	timeout := u.timeout
+	if ms, err := strconv.ParseInt(ctx.Request().Header().Get(tl.spec.BudgetHeader), 10, 64); err == nil && ms > 0 {
+		if d := time.Duration(ms) * time.Millisecond; d < timeout {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
 func (tl *TimeLimiter) handle(ctx context.HTTPContext, u *URLRule) string {
-	timer := time.AfterFunc(u.timeout, func() {
+	timeout := tl.budget(ctx, u)
+	timer := time.AfterFunc(timeout, func() {
 		ctx.Cancel(errTimeout)
 	})
 
+	// The remaining budget is set to the header by the proxy just before
+	// forwarding, as the following filters take part of the budget.
+	if tl.spec.BudgetHeader != "" {
+		header, deadline := ctx.UpstreamDeadline()
+		ctx.SetUpstreamDeadline(tl.spec.BudgetHeader, time.Now().Add(timeout))
+		defer ctx.SetUpstreamDeadline(header, deadline)
+	}
+
+	if u.perTryTimeout > 0 {
+		timeout := ctx.UpstreamTimeout()
+		ctx.SetUpstreamTimeout(u.perTryTimeout)
+		defer ctx.SetUpstreamTimeout(timeout)
+	}
+
 	result := ctx.CallNextHandler("")
+	if u.idleTimeout > 0 {
+		if body, ok := ctx.Response().Body().(io.ReadCloser); ok {
+			ctx.Response().SetBody(newIdleTimeoutReader(body, u.idleTimeout, func() {
+				logger.Infof("time limiter %s idle timed out on URL(%s)", tl.filterSpec.Name(), u.ID())
+			}))
+		}
+	}
+
 	if !timer.Stop() {
 		ctx.AddTag("timeLimiter: timed out")
 		logger.Infof("time limiter %s timed out on URL(%s)", tl.filterSpec.Name(), u.ID())
@@ -18,15 +18,18 @@
 package timelimiter
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
 
@@ -123,3 +126,95 @@ urls:
 		t.Error("request path doesn't match, timeout should not happen")
 	}
 }
+
+func TestTimeoutHierarchy(t *testing.T) {
+	const yamlSpec = `
+kind: TimeLimiter
+name: timelimiter
+defaultTimeoutDuration: 2s
+defaultPerTryTimeout: 300ms
+defaultIdleTimeout: 20ms
+budgetHeader: X-Timeout-Ms
+urls:
+  - url:
+      prefix: /stream
+    perTryTimeout: 100ms
+  - url:
+      prefix: /
+    idleTimeout: 1s
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	tl := &TimeLimiter{}
+	tl.Init(spec)
+	defer tl.Close()
+
+	u0, u1 := tl.spec.URLs[0], tl.spec.URLs[1]
+	if u0.timeout != 2*time.Second || u0.perTryTimeout != 100*time.Millisecond || u0.idleTimeout != 20*time.Millisecond {
+		t.Errorf("unexpected timeouts of url 0: %v %v %v", u0.timeout, u0.perTryTimeout, u0.idleTimeout)
+	}
+	if u1.perTryTimeout != 300*time.Millisecond || u1.idleTimeout != time.Second {
+		t.Errorf("unexpected timeouts of url 1: %v %v", u1.perTryTimeout, u1.idleTimeout)
+	}
+
+	do := func(path, budget string, body io.ReadCloser) (*httptest.ResponseRecorder, time.Duration, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if budget != "" {
+			req.Header.Set("X-Timeout-Ms", budget)
+		}
+		var perTry, remaining time.Duration
+		ctx, w := httppipelinetest.NewContext(req, func(ctx context.HTTPContext, lastResult string) string {
+			perTry = ctx.UpstreamTimeout()
+			if header, deadline := ctx.UpstreamDeadline(); header == "X-Timeout-Ms" {
+				remaining = time.Until(deadline)
+			}
+			ctx.Response().SetBody(body)
+			return lastResult
+		})
+		tl.Handle(ctx)
+		if ctx.UpstreamTimeout() != 0 {
+			t.Errorf("upstream timeout should be restored")
+		}
+		if header, _ := ctx.UpstreamDeadline(); header != "" {
+			t.Errorf("upstream deadline should be restored")
+		}
+		if req.Header.Get("X-Timeout-Ms") != budget {
+			t.Errorf("budget header should be set when forwarding only")
+		}
+		ctx.Finish()
+		return w, perTry, remaining
+	}
+
+	within := func(d, expected time.Duration) bool {
+		return d <= expected && d > expected-100*time.Millisecond
+	}
+
+	// The budget of the client shortens the timeout.
+	_, perTry, remaining := do("/api", "1500", nil)
+	if !within(remaining, 1500*time.Millisecond) {
+		t.Errorf("expected budget 1500ms, got %v", remaining)
+	}
+	if perTry != 300*time.Millisecond {
+		t.Errorf("expected per try timeout 300ms, got %v", perTry)
+	}
+	if _, _, remaining = do("/api", "5000", nil); !within(remaining, 2*time.Second) {
+		t.Errorf("expected budget 2s, got %v", remaining)
+	}
+
+	// The stream stalls after the first bytes.
+	pr, pw := io.Pipe()
+	go pw.Write([]byte("first"))
+	start := time.Now()
+	w, _, _ := do("/stream", "", pr)
+	if time.Since(start) > time.Second {
+		t.Errorf("idle timeout didn't happen")
+	}
+	if w.Body.String() != "first" {
+		t.Errorf("expected the bytes before idle timeout, got %q", w.Body.String())
+	}
+}
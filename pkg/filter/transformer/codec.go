@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The XML documents are converted to/from generic values with below
+// conventions:
+//   - an element is converted to a map, the key is the element name,
+//   - attributes are stored with key '-' + attribute name,
+//   - the text of an element which has attributes or children is
+//     stored with key '#text', otherwise the element is the text,
+//   - repeated child elements are converted to an array.
+const (
+	xmlAttrPrefix  = "-"
+	xmlTextKey     = "#text"
+	defaultXMLRoot = "root"
+)
+
+func decodeXML(data []byte) (map[string]interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := t.(xml.StartElement); ok {
+			v, err := decodeXMLElement(d, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: v}, nil
+		}
+	}
+}
+
+func decodeXMLElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		m[xmlAttrPrefix+attr.Name.Local] = attr.Value
+	}
+
+	text := &strings.Builder{}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			v, err := decodeXMLElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+			switch old := m[name].(type) {
+			case nil:
+				m[name] = v
+			case []interface{}:
+				m[name] = append(old, v)
+			default:
+				m[name] = []interface{}{old, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m[xmlTextKey] = s
+			}
+			return m, nil
+		}
+	}
+}
+
+// encodeXML encodes v to XML, if root is empty and v is a map with only
+// one key, the key is used as the name of the root element.
+func encodeXML(root string, v interface{}) ([]byte, error) {
+	if m, ok := v.(map[string]interface{}); ok && root == "" && len(m) == 1 {
+		for k, child := range m {
+			root, v = k, child
+		}
+	}
+	if root == "" {
+		root = defaultXMLRoot
+	}
+
+	buf := &bytes.Buffer{}
+	e := xml.NewEncoder(buf)
+	if err := encodeXMLElement(e, root, v); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeXMLElement(e *xml.Encoder, name string, v interface{}) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := encodeXMLElement(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return e.EncodeElement(xmlText(v), start)
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	children := keys[:0]
+	for _, k := range keys {
+		if strings.HasPrefix(k, xmlAttrPrefix) {
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: strings.TrimPrefix(k, xmlAttrPrefix)},
+				Value: xmlText(m[k]),
+			})
+		} else if k != xmlTextKey {
+			children = append(children, k)
+		}
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if text, ok := m[xmlTextKey]; ok {
+		if err := e.EncodeToken(xml.CharData(xmlText(text))); err != nil {
+			return err
+		}
+	}
+	for _, k := range children {
+		if err := encodeXMLElement(e, k, m[k]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func xmlText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(buf)
+	}
+}
+
+// setPath sets value to m at the dot separated path, the intermediate
+// maps are created if not exist.
+func setPath(m map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[k] = child
+		}
+		m = child
+	}
+	m[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transformer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXMLCodec(t *testing.T) {
+	v, err := decodeXML([]byte(`<a x="1"><b>2</b><b>3</b><c y="4">5</c><d/></a>`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{
+			"-x": "1",
+			"b":  []interface{}{"2", "3"},
+			"c":  map[string]interface{}{"-y": "4", "#text": "5"},
+			"d":  "",
+		},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("decoded value should be %v, got %v", expected, v)
+	}
+
+	buf, err := encodeXML("", v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := `<a x="1"><b>2</b><b>3</b><c y="4">5</c><d></d></a>`; string(buf) != s {
+		t.Errorf("encoded xml should be %s, got %s", s, buf)
+	}
+
+	if _, err = decodeXML([]byte(`<a><b></a>`)); err == nil {
+		t.Error("decode invalid xml should fail")
+	}
+
+	buf, _ = encodeXML("", map[string]interface{}{"a": 1.5, "b": true})
+	if s := `<root><a>1.5</a><b>true</b></root>`; string(buf) != s {
+		t.Errorf("encoded xml should be %s, got %s", s, buf)
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	m := map[string]interface{}{"a": 1}
+	setPath(m, "a.b", 2)
+	setPath(m, "a.c.d", 3)
+	setPath(m, "e", 4)
+
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": 2,
+			"c": map[string]interface{}{"d": 3},
+		},
+		"e": 4,
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("map should be %v, got %v", expected, m)
+	}
+}
@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of Transformer.
+	Kind = "Transformer"
+
+	resultTransformFailed = "transformFailed"
+
+	// FormatJSON is the JSON format.
+	FormatJSON = "json"
+	// FormatXML is the XML format.
+	FormatXML = "xml"
+)
+
+var results = []string{resultTransformFailed}
+
+func init() {
+	httppipeline.Register(&Transformer{})
+}
+
+type (
+	// Transformer is filter Transformer.
+	Transformer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		request  *transform
+		response *transform
+	}
+
+	// Spec is the spec of Transformer.
+	Spec struct {
+		Request  *TransformSpec `yaml:"request" jsonschema:"omitempty"`
+		Response *TransformSpec `yaml:"response" jsonschema:"omitempty"`
+	}
+
+	// TransformSpec describes how to transform a body.
+	TransformSpec struct {
+		Format       string     `yaml:"format,omitempty" jsonschema:"omitempty,enum=json,enum=xml"`
+		OutputFormat string     `yaml:"outputFormat,omitempty" jsonschema:"omitempty,enum=json,enum=xml"`
+		XMLRoot      string     `yaml:"xmlRoot" jsonschema:"omitempty"`
+		ContentType  string     `yaml:"contentType" jsonschema:"omitempty"`
+		Template     string     `yaml:"template" jsonschema:"omitempty"`
+		Mappings     []*Mapping `yaml:"mappings" jsonschema:"omitempty"`
+	}
+
+	// Mapping maps a field of the original body to the new body.
+	Mapping struct {
+		// From is a GJSON path, see https://github.com/tidwall/gjson/blob/master/SYNTAX.md
+		From string `yaml:"from" jsonschema:"required"`
+		// To is a dot separated path of the field in the new body.
+		To string `yaml:"to" jsonschema:"required"`
+	}
+
+	transform struct {
+		spec *TransformSpec
+		tmpl *template.Template
+	}
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+}
+
+// Validate validates TransformSpec.
+func (spec TransformSpec) Validate() error {
+	if spec.Template == "" && len(spec.Mappings) == 0 {
+		return fmt.Errorf("one of template and mappings must be specified")
+	}
+	if spec.Template != "" && len(spec.Mappings) != 0 {
+		return fmt.Errorf("template and mappings can not be specified at the same time")
+	}
+	if spec.Template != "" {
+		if _, err := parseTemplate(spec.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	}
+	return nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).Parse(text)
+}
+
+func newTransform(spec *TransformSpec) *transform {
+	if spec == nil {
+		return nil
+	}
+
+	t := &transform{spec: spec}
+	if spec.Template != "" {
+		// NOTE: The template has been checked in Validate.
+		t.tmpl, _ = parseTemplate(spec.Template)
+	}
+	return t
+}
+
+func (t *transform) format() string {
+	if t.spec.Format == "" {
+		return FormatJSON
+	}
+	return t.spec.Format
+}
+
+func (t *transform) outputFormat() string {
+	if t.spec.OutputFormat == "" {
+		return t.format()
+	}
+	return t.spec.OutputFormat
+}
+
+func (t *transform) contentType() string {
+	if t.spec.ContentType != "" || t.tmpl != nil {
+		return t.spec.ContentType
+	}
+	if t.outputFormat() == FormatXML {
+		return "application/xml"
+	}
+	return "application/json"
+}
+
+func (t *transform) decode(body []byte) (interface{}, error) {
+	if t.format() == FormatXML {
+		return decodeXML(body)
+	}
+
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	return v, err
+}
+
+// do transforms the body, data is the extra data for the template.
+func (t *transform) do(body []byte, data map[string]interface{}) ([]byte, error) {
+	v, err := t.decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s failed: %v", t.format(), err)
+	}
+
+	if t.tmpl != nil {
+		data["body"] = v
+		buf := &bytes.Buffer{}
+		if err = t.tmpl.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("execute template failed: %v", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	src := body
+	if t.format() != FormatJSON {
+		if src, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	out := map[string]interface{}{}
+	for _, m := range t.spec.Mappings {
+		if r := gjson.GetBytes(src, m.From); r.Exists() {
+			setPath(out, m.To, r.Value())
+		}
+	}
+
+	if t.outputFormat() == FormatXML {
+		return encodeXML(t.spec.XMLRoot, out)
+	}
+	return json.Marshal(out)
+}
+
+// Kind returns the kind of Transformer.
+func (tf *Transformer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of Transformer.
+func (tf *Transformer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Transformer.
+func (tf *Transformer) Description() string {
+	return "Transformer transforms JSON/XML request and response bodies."
+}
+
+// Results returns the results of Transformer.
+func (tf *Transformer) Results() []string {
+	return results
+}
+
+// Init initializes Transformer.
+func (tf *Transformer) Init(filterSpec *httppipeline.FilterSpec) {
+	tf.filterSpec, tf.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	tf.reload()
+}
+
+// Inherit inherits previous generation of Transformer.
+func (tf *Transformer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	tf.Init(filterSpec)
+}
+
+func (tf *Transformer) reload() {
+	tf.request = newTransform(tf.spec.Request)
+	tf.response = newTransform(tf.spec.Response)
+}
+
+// Handle transforms the request body, calls the following handlers
+// and then transforms the response body.
+func (tf *Transformer) Handle(ctx context.HTTPContext) string {
+	if tf.request != nil {
+		if err := tf.transformRequest(ctx); err != nil {
+			ctx.AddTag(fmt.Sprintf("transformer: %v", err))
+			ctx.Response().SetStatusCode(http.StatusBadRequest)
+			return ctx.CallNextHandler(resultTransformFailed)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+
+	if tf.response != nil && result == "" {
+		// NOTE: The response is not changed if it fails to transform,
+		// because it is usually an error message of the backend.
+		if err := tf.transformResponse(ctx); err != nil {
+			ctx.AddTag(fmt.Sprintf("transformer: %v", err))
+		}
+	}
+
+	return result
+}
+
+func readBody(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (tf *Transformer) transformRequest(ctx context.HTTPContext) error {
+	r := ctx.Request()
+	body, err := readBody(r.Body())
+	if err != nil {
+		return fmt.Errorf("read request body failed: %v", err)
+	}
+
+	// NOTE: The body has been consumed, it must be set back even if
+	// the transformation fails, for the filters after it.
+	r.SetBody(bytes.NewReader(body))
+	if len(body) == 0 {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"method": r.Method(),
+		"path":   r.Path(),
+		"query":  r.Query(),
+		"header": r.Header().Std(),
+	}
+	transformed, err := tf.request.do(body, data)
+	if err != nil {
+		return fmt.Errorf("transform request failed: %v", err)
+	}
+
+	r.SetBody(bytes.NewReader(transformed))
+	setHeader(r.Header(), tf.request.contentType())
+	return nil
+}
+
+func (tf *Transformer) transformResponse(ctx context.HTTPContext) error {
+	w := ctx.Response()
+	body, err := readBody(w.Body())
+	if err != nil {
+		return fmt.Errorf("read response body failed: %v", err)
+	}
+	if len(body) == 0 {
+		return nil
+	}
+
+	// NOTE: The body has been consumed, it must be set back even if
+	// the transformation fails.
+	w.SetBody(bytes.NewReader(body))
+
+	data := map[string]interface{}{
+		"statusCode": w.StatusCode(),
+		"header":     w.Header().Std(),
+	}
+	body, err = tf.response.do(body, data)
+	if err != nil {
+		return fmt.Errorf("transform response failed: %v", err)
+	}
+
+	w.SetBody(bytes.NewReader(body))
+	setHeader(w.Header(), tf.response.contentType())
+	return nil
+}
+
+func setHeader(h *httpheader.HTTPHeader, contentType string) {
+	h.Del(httpheader.KeyContentLength)
+	if contentType != "" {
+		h.Set(httpheader.KeyContentType, contentType)
+	}
+}
+
+// Status returns status.
+func (tf *Transformer) Status() interface{} { return nil }
+
+// Close closes Transformer.
+func (tf *Transformer) Close() {}
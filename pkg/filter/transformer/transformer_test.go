@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transformer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestValidate(t *testing.T) {
+	for _, yamlSpec := range []string{`
+kind: Transformer
+name: transformer
+request:
+  format: json
+`, `
+kind: Transformer
+name: transformer
+request:
+  template: '{{.body}}'
+  mappings:
+  - from: a
+    to: b
+`, `
+kind: Transformer
+name: transformer
+response:
+  template: '{{.body'
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+			t.Errorf("spec should be invalid:\n%s", yamlSpec)
+		}
+	}
+}
+
+func TestRequestMappings(t *testing.T) {
+	tf := httppipelinetest.InitFilter(t, &Transformer{}, `
+kind: Transformer
+name: transformer
+request:
+  format: json
+  outputFormat: xml
+  xmlRoot: order
+  mappings:
+  - from: id
+    to: -id
+  - from: customer.name
+    to: customer.name
+  - from: items.#.sku
+    to: item
+  - from: notExist
+    to: nothing
+`).(*Transformer)
+
+	var body io.Reader = strings.NewReader(`{"id": 7, "customer": {"name": "bob"}, "items": [{"sku": "a"}, {"sku": "b"}]}`)
+	header := httpheader.New(http.Header{})
+	header.Set(httpheader.KeyContentLength, "85")
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedBody = func() io.Reader { return body }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { body = r }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	if result := tf.Handle(ctx); result != "" {
+		t.Fatalf("result should be empty, got %s", result)
+	}
+
+	buf, _ := ioutil.ReadAll(body)
+	expected := `<order id="7"><customer><name>bob</name></customer><item>a</item><item>b</item></order>`
+	if string(buf) != expected {
+		t.Errorf("request body should be %s, got %s", expected, buf)
+	}
+	if v := header.Get(httpheader.KeyContentType); v != "application/xml" {
+		t.Errorf("content type should be application/xml, got %s", v)
+	}
+	if v := header.Get(httpheader.KeyContentLength); v != "" {
+		t.Errorf("content length should be removed, got %s", v)
+	}
+}
+
+func TestRequestInvalidBody(t *testing.T) {
+	tf := httppipelinetest.InitFilter(t, &Transformer{}, `
+kind: Transformer
+name: transformer
+request:
+  format: json
+  mappings:
+  - from: id
+    to: id
+`).(*Transformer)
+
+	statusCode := 0
+	var body io.Reader = strings.NewReader("<xml/>")
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedBody = func() io.Reader { return body }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { body = r }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(http.Header{}) }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+
+	if result := tf.Handle(ctx); result != resultTransformFailed {
+		t.Errorf("result should be %s, got %s", resultTransformFailed, result)
+	}
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("status code should be 400, got %d", statusCode)
+	}
+
+	// the body is kept for the filters after it.
+	if buf, _ := ioutil.ReadAll(body); string(buf) != "<xml/>" {
+		t.Errorf("request body should be restored, got %q", buf)
+	}
+
+	// empty body is not transformed
+	called := false
+	ctx.MockedRequest.MockedBody = func() io.Reader { return &bytes.Buffer{} }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		called = true
+		return lastResult
+	}
+	if result := tf.Handle(ctx); result != "" || !called {
+		t.Errorf("request with empty body should be passed through")
+	}
+}
+
+func TestResponseTemplate(t *testing.T) {
+	tf := httppipelinetest.InitFilter(t, &Transformer{}, `
+kind: Transformer
+name: transformer
+response:
+  format: xml
+  contentType: application/json
+  template: '{"user": {{json .body.result.user.name}}, "roles": [{{range $i, $r := .body.result.role}}{{if $i}}, {{end}}{{json $r}}{{end}}], "status": {{.statusCode}}}'
+`).(*Transformer)
+
+	var body io.Reader = strings.NewReader(`<?xml version="1.0"?>
+<result version="1"><user><name>alice</name></user><role>admin</role><role>dev</role></result>`)
+	header := httpheader.New(http.Header{})
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedResponse.MockedBody = func() io.Reader { return body }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) { body = r }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedResponse.MockedStatusCode = func() int { return http.StatusOK }
+
+	tf.Handle(ctx)
+
+	buf, _ := ioutil.ReadAll(body)
+	expected := `{"user": "alice", "roles": ["admin", "dev"], "status": 200}`
+	if string(buf) != expected {
+		t.Errorf("response body should be %s, got %s", expected, buf)
+	}
+	if v := header.Get(httpheader.KeyContentType); v != "application/json" {
+		t.Errorf("content type should be application/json, got %s", v)
+	}
+
+	// the original response is kept if fails to transform
+	body = strings.NewReader("internal error")
+	tf.Handle(ctx)
+	buf, _ = ioutil.ReadAll(body)
+	if string(buf) != "internal error" {
+		t.Errorf("response body should not be changed, got %s", buf)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package urlrewriter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/pathadaptor"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+const (
+	// Kind is the kind of URLRewriter.
+	Kind = "URLRewriter"
+
+	resultRedirected = "redirected"
+)
+
+var (
+	results = []string{resultRedirected}
+
+	// bracketEscaper escapes the square brackets, which are the
+	// delimiters of the templates.
+	bracketEscaper = strings.NewReplacer("[", "%5B", "]", "%5D")
+)
+
+func init() {
+	httppipeline.Register(&URLRewriter{})
+}
+
+type (
+	// URLRewriter is filter URLRewriter.
+	URLRewriter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec is the spec of URLRewriter.
+	Spec struct {
+		Rules []*Rule `yaml:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// Rule rewrites or redirects the matched requests, only the first
+	// matched rule is applied.
+	Rule struct {
+		Methods  []string            `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		URL      urlrule.StringMatch `yaml:"url" jsonschema:"required"`
+		Path     *pathadaptor.Spec   `yaml:"path,omitempty" jsonschema:"omitempty"`
+		Host     string              `yaml:"host" jsonschema:"omitempty"`
+		Redirect *RedirectSpec       `yaml:"redirect,omitempty" jsonschema:"omitempty"`
+
+		re          *regexp.Regexp
+		pathAdaptor *pathadaptor.PathAdaptor
+	}
+
+	// RedirectSpec describes the redirection.
+	RedirectSpec struct {
+		StatusCode int `yaml:"statusCode,omitempty" jsonschema:"omitempty,enum=301,enum=302,enum=303,enum=307,enum=308"`
+		// Location could reference the capture groups of the regular
+		// expression of the URL, e.g. $1 or ${name}.
+		Location      string `yaml:"location" jsonschema:"required"`
+		PreserveQuery bool   `yaml:"preserveQuery" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Rule.
+func (r Rule) Validate() error {
+	if r.Redirect != nil && (r.Path != nil || r.Host != "") {
+		return fmt.Errorf("redirect can not be used with path or host")
+	}
+	if r.Redirect == nil && r.Path == nil && r.Host == "" {
+		return fmt.Errorf("one of path, host and redirect must be specified")
+	}
+	return nil
+}
+
+// Kind returns the kind of URLRewriter.
+func (ur *URLRewriter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of URLRewriter.
+func (ur *URLRewriter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of URLRewriter.
+func (ur *URLRewriter) Description() string {
+	return "URLRewriter rewrites path and host of requests or redirects them."
+}
+
+// Results returns the results of URLRewriter.
+func (ur *URLRewriter) Results() []string {
+	return results
+}
+
+// Init initializes URLRewriter.
+func (ur *URLRewriter) Init(filterSpec *httppipeline.FilterSpec) {
+	ur.filterSpec, ur.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ur.reload()
+}
+
+// Inherit inherits previous generation of URLRewriter.
+func (ur *URLRewriter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ur.Init(filterSpec)
+}
+
+func (ur *URLRewriter) reload() {
+	for _, r := range ur.spec.Rules {
+		r.URL.Init()
+		if r.URL.RegEx != "" {
+			r.re = regexp.MustCompile(r.URL.RegEx)
+		}
+		if r.Path != nil {
+			r.pathAdaptor = pathadaptor.New(r.Path)
+		}
+	}
+}
+
+func (r *Rule) match(req context.HTTPRequest) bool {
+	if len(r.Methods) > 0 && !stringtool.StrInSlice(req.Method(), r.Methods) {
+		return false
+	}
+	return r.URL.Match(req.Path())
+}
+
+// Handle rewrites or redirects the request.
+func (ur *URLRewriter) Handle(ctx context.HTTPContext) string {
+	result := ur.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ur *URLRewriter) handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+	for _, r := range ur.spec.Rules {
+		if !r.match(req) {
+			continue
+		}
+
+		if r.Redirect != nil {
+			return r.redirect(ctx)
+		}
+		r.rewrite(ctx)
+		return ""
+	}
+
+	return ""
+}
+
+func (r *Rule) rewrite(ctx context.HTTPContext) {
+	req := ctx.Request()
+
+	if r.pathAdaptor != nil {
+		path := req.Path()
+		adaptedPath := r.pathAdaptor.Adapt(path)
+		if adaptedPath != path {
+			ctx.AddTag(stringtool.Cat("path: ", path, " rewritten to ", adaptedPath))
+			req.SetPath(adaptedPath)
+		}
+	}
+
+	if r.Host != "" {
+		req.SetHost(render(r.Host, ctx.Template()))
+	}
+}
+
+func (r *Rule) redirect(ctx context.HTTPContext) string {
+	req := ctx.Request()
+
+	location := r.Redirect.Location
+	if r.re != nil {
+		path := req.Path()
+		if m := r.re.FindStringSubmatchIndex(path); m != nil {
+			location = r.expand(location, path, m)
+		}
+	}
+	location = render(location, ctx.Template())
+
+	if r.Redirect.PreserveQuery && req.Query() != "" {
+		if strings.Contains(location, "?") {
+			location += "&" + req.Query()
+		} else {
+			location += "?" + req.Query()
+		}
+	}
+
+	statusCode := r.Redirect.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusFound
+	}
+
+	ctx.Response().SetStatusCode(statusCode)
+	ctx.Response().Header().Set("Location", location)
+	ctx.AddTag(stringtool.Cat("redirected to ", location))
+
+	return resultRedirected
+}
+
+// expand expands the captures of the path in the location, the square
+// brackets in the captures are escaped, so that the request can't inject
+// templates into the location.
+func (r *Rule) expand(location, path string, m []int) string {
+	var src strings.Builder
+	indexes := make([]int, len(m))
+	for i := 0; i < len(m); i += 2 {
+		if m[i] < 0 {
+			indexes[i], indexes[i+1] = -1, -1
+			continue
+		}
+		indexes[i] = src.Len()
+		src.WriteString(bracketEscaper.Replace(path[m[i]:m[i+1]]))
+		indexes[i+1] = src.Len()
+	}
+	return string(r.re.ExpandString(nil, location, src.String(), indexes))
+}
+
+func render(s string, hte texttemplate.TemplateEngine) string {
+	if hte == nil || !hte.HasTemplates(s) {
+		return s
+	}
+
+	rendered, err := hte.Render(s)
+	if err != nil {
+		logger.Errorf("BUG urlrewriter render failed, template %s, err %v", s, err)
+		return s
+	}
+	return rendered
+}
+
+// Status returns status.
+func (ur *URLRewriter) Status() interface{} { return nil }
+
+// Close closes URLRewriter.
+func (ur *URLRewriter) Close() {}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package urlrewriter
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/texttemplate"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const yamlSpec = `
+kind: URLRewriter
+name: url-rewriter
+rules:
+- url:
+    prefix: /api/v1/
+  path:
+    trimPrefix: /api/v1
+  host: v1.example.com
+- methods: [GET]
+  url:
+    regex: ^/users/(?P<id>\d+)/profile$
+  path:
+    regexpReplace:
+      regexp: ^/users/(\d+)/profile$
+      replace: /profiles/$1
+- url:
+    regex: ^/old/(.*)$
+  redirect:
+    statusCode: 301
+    location: https://example.com/new/$1?from=old
+    preserveQuery: true
+- url:
+    prefix: /login
+  redirect:
+    location: https://sso.example.com/login
+`
+
+func TestURLRewriter(t *testing.T) {
+	ur := httppipelinetest.InitFilter(t, &URLRewriter{}, yamlSpec).(*URLRewriter)
+
+	cases := []struct {
+		method     string
+		path       string
+		query      string
+		newPath    string
+		newHost    string
+		result     string
+		statusCode int
+		location   string
+	}{
+		{method: http.MethodGet, path: "/api/v1/orders", newPath: "/orders", newHost: "v1.example.com"},
+		{method: http.MethodGet, path: "/users/7/profile", newPath: "/profiles/7"},
+		{method: http.MethodPost, path: "/users/7/profile", newPath: "/users/7/profile"},
+		{method: http.MethodGet, path: "/old/a/b", query: "x=1", newPath: "/old/a/b", result: resultRedirected,
+			statusCode: http.StatusMovedPermanently, location: "https://example.com/new/a/b?from=old&x=1"},
+		{method: http.MethodGet, path: "/login", query: "x=1", newPath: "/login", result: resultRedirected,
+			statusCode: http.StatusFound, location: "https://sso.example.com/login"},
+		{method: http.MethodGet, path: "/other", newPath: "/other"},
+	}
+
+	for _, c := range cases {
+		path, host, statusCode := c.path, "", 0
+		header := httpheader.New(http.Header{})
+
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedMethod = func() string { return c.method }
+		ctx.MockedRequest.MockedPath = func() string { return path }
+		ctx.MockedRequest.MockedSetPath = func(p string) { path = p }
+		ctx.MockedRequest.MockedQuery = func() string { return c.query }
+		ctx.MockedRequest.MockedSetHost = func(h string) { host = h }
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+		result := ur.Handle(ctx)
+		if result != c.result {
+			t.Errorf("%s %s: result should be %q, got %q", c.method, c.path, c.result, result)
+		}
+		if path != c.newPath {
+			t.Errorf("%s %s: path should be %s, got %s", c.method, c.path, c.newPath, path)
+		}
+		if host != c.newHost {
+			t.Errorf("%s %s: host should be %s, got %s", c.method, c.path, c.newHost, host)
+		}
+		if statusCode != c.statusCode {
+			t.Errorf("%s %s: status code should be %d, got %d", c.method, c.path, c.statusCode, statusCode)
+		}
+		if location := header.Get("Location"); location != c.location {
+			t.Errorf("%s %s: location should be %s, got %s", c.method, c.path, c.location, location)
+		}
+	}
+}
+
+// fakeTemplate renders any template to "evil".
+type fakeTemplate struct {
+	texttemplate.DummyTemplate
+}
+
+func (fakeTemplate) HasTemplates(input string) bool {
+	return strings.Contains(input, "[[")
+}
+
+func (fakeTemplate) Render(input string) (string, error) {
+	return "evil", nil
+}
+
+func TestRedirectTemplateInjection(t *testing.T) {
+	ur := httppipelinetest.InitFilter(t, &URLRewriter{}, yamlSpec).(*URLRewriter)
+
+	header := httpheader.New(http.Header{})
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedPath = func() string { return "/old/[[filter.x.req.header.Cookie]]" }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedTemplate = func() texttemplate.TemplateEngine { return fakeTemplate{} }
+
+	if result := ur.Handle(ctx); result != resultRedirected {
+		t.Fatalf("result should be %q, got %q", resultRedirected, result)
+	}
+	want := "https://example.com/new/%5B%5Bfilter.x.req.header.Cookie%5D%5D?from=old"
+	if location := header.Get("Location"); location != want {
+		t.Errorf("location should be %s, got %s", want, location)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, yamlSpec := range []string{`
+kind: URLRewriter
+name: url-rewriter
+rules:
+- url:
+    prefix: /
+`, `
+kind: URLRewriter
+name: url-rewriter
+rules:
+- url:
+    prefix: /
+  host: example.com
+  redirect:
+    location: https://example.com
+`} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		if _, e := httppipeline.NewFilterSpec(rawSpec, nil); e == nil {
+			t.Errorf("spec should be invalid:\n%s", yamlSpec)
+		}
+	}
+}
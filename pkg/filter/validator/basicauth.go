@@ -0,0 +1,440 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultBasicAuthRealm = "Easegress"
+	defaultLDAPUID        = "uid"
+	defaultLDAPTimeout    = 5 * time.Second
+	// userFileCheckInterval is the minimum interval to check if the user
+	// file has changed.
+	userFileCheckInterval  = 5 * time.Second
+	maxCredentialCacheSize = 10000
+)
+
+type (
+	// BasicAuthValidatorSpec defines the configuration of Basic Auth validator,
+	// the credentials are verified by a htpasswd file or a LDAP server.
+	BasicAuthValidatorSpec struct {
+		Realm string `yaml:"realm" jsonschema:"omitempty"`
+		// UserFile is a htpasswd file, passwords hashed by bcrypt, apr1 and
+		// SHA1 are supported, it is reloaded when it changes.
+		UserFile string    `yaml:"userFile" jsonschema:"omitempty"`
+		LDAP     *LDAPSpec `yaml:"ldap,omitempty" jsonschema:"omitempty"`
+		// CacheTTL caches the verified credentials, so the expensive hashing
+		// or binding isn't done for every request.
+		CacheTTL string `yaml:"cacheTTL" jsonschema:"omitempty,format=duration"`
+		// UsernameHeader passes the username to the backends.
+		UsernameHeader string `yaml:"usernameHeader" jsonschema:"omitempty"`
+	}
+
+	// LDAPSpec defines the LDAP server, users are authenticated by binding
+	// with DN {uid}={username},{baseDN}.
+	LDAPSpec struct {
+		URL         string `yaml:"url" jsonschema:"required,format=uri"`
+		BaseDN      string `yaml:"baseDN" jsonschema:"required"`
+		UID         string `yaml:"uid" jsonschema:"omitempty"`
+		StartTLS    bool   `yaml:"startTls" jsonschema:"omitempty"`
+		InsecureTLS bool   `yaml:"insecureTls" jsonschema:"omitempty"`
+		Timeout     string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// BasicAuthValidator defines the Basic Auth validator
+	BasicAuthValidator struct {
+		spec  *BasicAuthValidatorSpec
+		store credentialStore
+		cache *credentialCache
+	}
+
+	credentialStore interface {
+		authenticate(username, password string) error
+	}
+
+	htpasswdStore struct {
+		path string
+
+		mutex     sync.RWMutex
+		users     map[string]string
+		modTime   time.Time
+		checkedAt time.Time
+	}
+
+	ldapStore struct {
+		spec    *LDAPSpec
+		timeout time.Duration
+	}
+
+	credentialCache struct {
+		ttl     time.Duration
+		mutex   sync.Mutex
+		entries map[[sha256.Size]byte]time.Time
+	}
+)
+
+// Validate validates BasicAuthValidatorSpec.
+func (spec BasicAuthValidatorSpec) Validate() error {
+	if (spec.UserFile == "") == (spec.LDAP == nil) {
+		return fmt.Errorf("one and only one of userFile and ldap should be specified")
+	}
+	return nil
+}
+
+// NewBasicAuthValidator creates a new Basic Auth validator
+func NewBasicAuthValidator(spec *BasicAuthValidatorSpec) *BasicAuthValidator {
+	v := &BasicAuthValidator{spec: spec}
+
+	if spec.UserFile != "" {
+		v.store = newHtpasswdStore(spec.UserFile)
+	} else {
+		v.store = newLDAPStore(spec.LDAP)
+	}
+
+	if ttl, err := time.ParseDuration(spec.CacheTTL); err == nil && ttl > 0 {
+		v.cache = &credentialCache{ttl: ttl, entries: map[[sha256.Size]byte]time.Time{}}
+	}
+
+	return v
+}
+
+// Realm returns the realm of the validator.
+func (v *BasicAuthValidator) Realm() string {
+	if v.spec.Realm != "" {
+		return v.spec.Realm
+	}
+	return defaultBasicAuthRealm
+}
+
+// Validate validates the credentials of a http request
+func (v *BasicAuthValidator) Validate(req context.HTTPRequest) error {
+	if v.spec.UsernameHeader != "" {
+		req.Header().Del(v.spec.UsernameHeader)
+	}
+
+	username, password, ok := req.Std().BasicAuth()
+	if !ok {
+		return fmt.Errorf("missing basic auth credentials")
+	}
+	if username == "" || password == "" {
+		return fmt.Errorf("empty username or password")
+	}
+
+	if v.cache == nil || !v.cache.get(username, password) {
+		if err := v.store.authenticate(username, password); err != nil {
+			return err
+		}
+		if v.cache != nil {
+			v.cache.set(username, password)
+		}
+	}
+
+	if v.spec.UsernameHeader != "" {
+		req.Header().Set(v.spec.UsernameHeader, username)
+	}
+	return nil
+}
+
+func (c *credentialCache) key(username, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(username + ":" + password))
+}
+
+func (c *credentialCache) get(username, password string) bool {
+	key := c.key(username, password)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expires, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+func (c *credentialCache) set(username, password string) {
+	key := c.key(username, password)
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= maxCredentialCacheSize {
+		for k, expires := range c.entries {
+			if now.After(expires) || len(c.entries) >= maxCredentialCacheSize {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = now.Add(c.ttl)
+}
+
+func newHtpasswdStore(path string) *htpasswdStore {
+	s := &htpasswdStore{path: path, users: map[string]string{}}
+	s.reload()
+	return s
+}
+
+// reload reloads the users if the file has changed, the old users are
+// kept if the file can't be loaded.
+func (s *htpasswdStore) reload() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.checkedAt = time.Now()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		logger.Errorf("stat user file %s failed: %v", s.path, err)
+		return
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		logger.Errorf("read user file %s failed: %v", s.path, err)
+		return
+	}
+
+	s.users, s.modTime = parseHtpasswd(data), info.ModTime()
+}
+
+func parseHtpasswd(data []byte) map[string]string {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx <= 0 {
+			continue
+		}
+		users[line[:idx]] = line[idx+1:]
+	}
+	return users
+}
+
+func (s *htpasswdStore) authenticate(username, password string) error {
+	s.mutex.RLock()
+	stale := time.Since(s.checkedAt) > userFileCheckInterval
+	s.mutex.RUnlock()
+
+	if stale {
+		s.reload()
+	}
+
+	s.mutex.RLock()
+	hash, ok := s.users[username]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown user %s", username)
+	}
+	if !verifyHtpasswd(hash, password) {
+		return fmt.Errorf("invalid password of user %s", username)
+	}
+	return nil
+}
+
+func verifyHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(hash[len(apr1Magic):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+
+	// NOTE: Plain text and crypt(3) passwords are not supported.
+	return false
+}
+
+const (
+	apr1Magic = "$apr1$"
+	itoa64    = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// apr1 is the Apache variant of the MD5 based crypt.
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(apr1Magic))
+	h.Write(s)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(s)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(altSum)
+		} else {
+			h.Write(altSum[:i])
+		}
+	}
+
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var buf strings.Builder
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			buf.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	to64(uint32(sum[0])<<16|uint32(sum[6])<<8|uint32(sum[12]), 4)
+	to64(uint32(sum[1])<<16|uint32(sum[7])<<8|uint32(sum[13]), 4)
+	to64(uint32(sum[2])<<16|uint32(sum[8])<<8|uint32(sum[14]), 4)
+	to64(uint32(sum[3])<<16|uint32(sum[9])<<8|uint32(sum[15]), 4)
+	to64(uint32(sum[4])<<16|uint32(sum[10])<<8|uint32(sum[5]), 4)
+	to64(uint32(sum[11]), 2)
+
+	return apr1Magic + salt + "$" + buf.String()
+}
+
+func newLDAPStore(spec *LDAPSpec) *ldapStore {
+	timeout := defaultLDAPTimeout
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	return &ldapStore{spec: spec, timeout: timeout}
+}
+
+func (s *ldapStore) authenticate(username, password string) error {
+	u, err := url.Parse(s.spec.URL)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: s.spec.InsecureTLS,
+		ServerName:         u.Hostname(),
+	}
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+	conn, err := ldap.DialURL(s.spec.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return fmt.Errorf("connect ldap server failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(s.timeout)
+
+	if s.spec.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("start tls failed: %v", err)
+		}
+	}
+
+	uid := s.spec.UID
+	if uid == "" {
+		uid = defaultLDAPUID
+	}
+	dn := uid + "=" + escapeDN(username) + "," + s.spec.BaseDN
+
+	// NOTE: The password is never empty, or it is an unauthenticated bind
+	// which always succeeds.
+	return conn.Bind(dn, password)
+}
+
+// escapeDN escapes the special characters of an attribute value in a DN,
+// as RFC 4514.
+func escapeDN(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			(c == ' ' || c == '#') && i == 0,
+			c == ' ' && i == len(s)-1:
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == 0:
+			buf.WriteString("\\00")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
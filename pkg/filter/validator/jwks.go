@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+	defaultJWKSTimeout         = 5 * time.Second
+	// minJWKSRefreshInterval limits the refreshing triggered by tokens
+	// of unknown key IDs.
+	minJWKSRefreshInterval = 10 * time.Second
+)
+
+type (
+	// JWKSSpec defines where to get the JSON Web Key Set.
+	JWKSSpec struct {
+		URL             string `yaml:"url" jsonschema:"required,format=url"`
+		RefreshInterval string `yaml:"refreshInterval" jsonschema:"omitempty,format=duration"`
+		Timeout         string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		InsecureTLS     bool   `yaml:"insecureTls" jsonschema:"omitempty"`
+	}
+
+	// jwks caches the keys of a JSON Web Key Set, it refreshes the keys
+	// periodically and when it meets an unknown key ID, so the keys
+	// could be rotated by the issuer.
+	jwks struct {
+		spec            *JWKSSpec
+		client          *http.Client
+		refreshInterval time.Duration
+
+		mutex     sync.RWMutex
+		keys      map[string]interface{}
+		fetchedAt time.Time
+
+		refreshMutex sync.Mutex
+		done         chan struct{}
+	}
+
+	jsonWebKey struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		// RSA
+		N string `json:"n"`
+		E string `json:"e"`
+		// EC
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		// Symmetric
+		K string `json:"k"`
+	}
+)
+
+func newJWKS(spec *JWKSSpec) *jwks {
+	timeout := defaultJWKSTimeout
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	refreshInterval := defaultJWKSRefreshInterval
+	if d, err := time.ParseDuration(spec.RefreshInterval); err == nil && d > 0 {
+		refreshInterval = d
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: spec.InsecureTLS}
+
+	ks := &jwks{
+		spec:            spec,
+		client:          &http.Client{Transport: transport, Timeout: timeout},
+		refreshInterval: refreshInterval,
+		keys:            map[string]interface{}{},
+		done:            make(chan struct{}),
+	}
+	go ks.run()
+	return ks
+}
+
+// run refreshes the keys periodically, the first fetching is triggered
+// by the first token.
+func (ks *jwks) run() {
+	ticker := time.NewTicker(ks.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ks.done:
+			return
+		case <-ticker.C:
+			ks.refresh(false)
+		}
+	}
+}
+
+func (ks *jwks) close() {
+	close(ks.done)
+}
+
+// refresh fetches the keys, the limited refreshing is skipped if the
+// keys are fetched recently.
+func (ks *jwks) refresh(limited bool) {
+	ks.refreshMutex.Lock()
+	defer ks.refreshMutex.Unlock()
+
+	if limited {
+		ks.mutex.RLock()
+		recent := time.Since(ks.fetchedAt) < minJWKSRefreshInterval
+		ks.mutex.RUnlock()
+		if recent {
+			return
+		}
+	}
+
+	keys, err := ks.fetch()
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.fetchedAt = time.Now()
+	if err != nil {
+		// NOTE: Keep the old keys, the issuer may be temporarily unavailable.
+		logger.Warnf("fetch jwks from %s failed: %v", ks.spec.URL, err)
+		return
+	}
+	ks.keys = keys
+}
+
+func (ks *jwks) fetch() (map[string]interface{}, error) {
+	resp, err := ks.client.Get(ks.spec.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	set := struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}{}
+	if err = json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.Warnf("ignore key %s of jwks %s: %v", jwk.Kid, ks.spec.URL, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// key returns the key of the ID, the keys are refreshed if the ID is
+// unknown.
+func (ks *jwks) key(kid string) (interface{}, error) {
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	ks.refresh(true)
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key %q not found in jwks", kid)
+}
+
+func (ks *jwks) lookup(kid string) (interface{}, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, true
+	}
+	// NOTE: The only key is used for tokens without key ID.
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBase64URL(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %v", err)
+		}
+		e, err := decodeBase64URL(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %v", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+		y, err := decodeBase64URL(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %v", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	case "oct":
+		return decodeBase64URL(jwk.K)
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
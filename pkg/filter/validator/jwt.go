@@ -19,8 +19,11 @@ package validator
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
@@ -29,32 +32,102 @@ import (
 
 // JWTValidatorSpec defines the configuration of JWT validator
 type JWTValidatorSpec struct {
-	Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512"`
-	// Secret is in hex encoding
-	Secret string `yaml:"secret" jsonschema:"required,pattern=^[A-Fa-f0-9]+$"`
+	Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512,enum=RS256,enum=RS384,enum=RS512,enum=ES256,enum=ES384,enum=ES512"`
+	// Secret is in hex encoding, it is required by the HMAC algorithms
+	// if JWKS is not used.
+	Secret string `yaml:"secret,omitempty" jsonschema:"omitempty,pattern=^[A-Fa-f0-9]+$"`
+	// PublicKey is the PEM encoded public key of the RSA and ECDSA
+	// algorithms.
+	PublicKey string `yaml:"publicKey" jsonschema:"omitempty"`
+	// JWKS gets the keys from a JSON Web Key Set, the key is selected
+	// by the key ID of the token.
+	JWKS *JWKSSpec `yaml:"jwks,omitempty" jsonschema:"omitempty"`
 	// CookieName specifies the name of a cookie, if not empty, and the cookie with
 	// this name both exists and has a non-empty value, its value is used as token
 	// string, the Authorization header is used to get the token string otherwise.
 	CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+
+	// Issuer is the expected iss claim.
+	Issuer string `yaml:"issuer" jsonschema:"omitempty"`
+	// Audiences are the accepted aud claims, one of them must be in
+	// the aud claim of the token.
+	Audiences []string `yaml:"audiences" jsonschema:"omitempty,uniqueItems=true"`
+	// RequireExp rejects tokens without the exp claim.
+	RequireExp bool `yaml:"requireExp" jsonschema:"omitempty"`
+	// Leeway is the tolerance of the clock skew for exp, nbf and iat.
+	Leeway string `yaml:"leeway" jsonschema:"omitempty,format=duration"`
+	// ClaimsToHeaders sets the claims to the headers of the request,
+	// the keys are claim names and the values are header names.
+	ClaimsToHeaders map[string]string `yaml:"claimsToHeaders" jsonschema:"omitempty"`
+}
+
+// Validate validates JWTValidatorSpec.
+func (spec JWTValidatorSpec) Validate() error {
+	hmac := strings.HasPrefix(spec.Algorithm, "HS")
+	switch {
+	case spec.JWKS != nil:
+		if spec.Secret != "" || spec.PublicKey != "" {
+			return fmt.Errorf("secret and publicKey can't be used with jwks")
+		}
+	case hmac && spec.Secret == "":
+		return fmt.Errorf("secret is required by %s", spec.Algorithm)
+	case !hmac && spec.PublicKey == "":
+		return fmt.Errorf("publicKey or jwks is required by %s", spec.Algorithm)
+	case !hmac:
+		if _, err := parsePublicKey(spec.Algorithm, spec.PublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parsePublicKey(algorithm, pem string) (interface{}, error) {
+	switch algorithm[:2] {
+	case "RS":
+		return jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
+	case "ES":
+		return jwt.ParseECPublicKeyFromPEM([]byte(pem))
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
 }
 
 // NewJWTValidator creates a new JWT validator
 func NewJWTValidator(spec *JWTValidatorSpec) *JWTValidator {
-	secret, _ := hex.DecodeString(spec.Secret)
-	return &JWTValidator{
-		spec:        spec,
-		secretBytes: secret,
+	v := &JWTValidator{spec: spec}
+
+	switch {
+	case spec.JWKS != nil:
+		v.jwks = newJWKS(spec.JWKS)
+	case strings.HasPrefix(spec.Algorithm, "HS"):
+		v.key, _ = hex.DecodeString(spec.Secret)
+	default:
+		// NOTE: The public key has been checked by Validate.
+		v.key, _ = parsePublicKey(spec.Algorithm, spec.PublicKey)
+	}
+
+	if spec.Leeway != "" {
+		v.leeway, _ = time.ParseDuration(spec.Leeway)
 	}
+
+	return v
 }
 
 // JWTValidator defines the JWT validator
 type JWTValidator struct {
-	spec        *JWTValidatorSpec
-	secretBytes []byte
+	spec   *JWTValidatorSpec
+	key    interface{}
+	jwks   *jwks
+	leeway time.Duration
 }
 
 // Validate validates the JWT token of a http request
 func (v *JWTValidator) Validate(req context.HTTPRequest) error {
+	// NOTE: Remove the headers of claims from the client, so they
+	// can't be forged.
+	for _, header := range v.spec.ClaimsToHeaders {
+		req.Header().Del(header)
+	}
+
 	var token string
 
 	if v.spec.CookieName != "" {
@@ -72,13 +145,165 @@ func (v *JWTValidator) Validate(req context.HTTPRequest) error {
 		token = authHdr[len(prefix):]
 	}
 
-	// jwt.Parse does everything including parsing and verification
-	_, e := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+	claims, e := v.ValidateToken(token)
+	if e != nil {
+		return e
+	}
+
+	for claim, header := range v.spec.ClaimsToHeaders {
+		if value, ok := claims[claim]; ok {
+			req.Header().Set(header, ClaimToString(value))
+		}
+	}
+
+	return nil
+}
+
+// ValidateToken validates the signature and claims of the token string,
+// and returns the claims of the token.
+func (v *JWTValidator) ValidateToken(token string) (jwt.MapClaims, error) {
+	// NOTE: The claims are validated by validateClaims for the leeway.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	t, e := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if alg := token.Method.Alg(); alg != v.spec.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", alg)
 		}
-		return v.secretBytes, nil
+		if v.jwks != nil {
+			kid, _ := token.Header["kid"].(string)
+			return v.jwks.key(kid)
+		}
+		return v.key, nil
 	})
+	if e != nil {
+		return nil, e
+	}
+
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unexpected claims")
+	}
+	if e = v.validateClaims(claims); e != nil {
+		return nil, e
+	}
+	return claims, nil
+}
+
+func (v *JWTValidator) validateClaims(claims jwt.MapClaims) error {
+	now := time.Now()
+
+	numericDate := func(name string) (time.Time, bool, error) {
+		value, ok := claims[name]
+		if !ok {
+			return time.Time{}, false, nil
+		}
+		switch n := value.(type) {
+		case float64:
+			return time.Unix(int64(n), 0), true, nil
+		case json.Number:
+			i, err := n.Int64()
+			if err != nil {
+				return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+			}
+			return time.Unix(i, 0), true, nil
+		}
+		return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+	}
+
+	exp, ok, err := numericDate("exp")
+	if err != nil {
+		return err
+	}
+	if !ok && v.spec.RequireExp {
+		return fmt.Errorf("exp claim is required")
+	}
+	if ok && now.After(exp.Add(v.leeway)) {
+		return fmt.Errorf("token is expired")
+	}
+
+	nbf, ok, err := numericDate("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	iat, ok, err := numericDate("iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(iat) {
+		return fmt.Errorf("token is used before issued")
+	}
 
-	return e
+	if v.spec.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.spec.Issuer {
+			return fmt.Errorf("unexpected issuer: %s", iss)
+		}
+	}
+
+	if len(v.spec.Audiences) > 0 && !v.matchAudience(claims["aud"]) {
+		return fmt.Errorf("unexpected audience: %v", claims["aud"])
+	}
+
+	return nil
+}
+
+// matchAudience reports whether one of the audiences is in the aud
+// claim, which is a string or an array of strings.
+func (v *JWTValidator) matchAudience(aud interface{}) bool {
+	var auds []string
+	switch a := aud.(type) {
+	case string:
+		auds = []string{a}
+	case []interface{}:
+		for _, item := range a {
+			if s, ok := item.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+
+	for _, expected := range v.spec.Audiences {
+		for _, a := range auds {
+			if a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClaimToString converts the claim to the value of a header, arrays of
+// strings are joined by commas, and objects are in JSON.
+func ClaimToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				buff, _ := json.Marshal(v)
+				return string(buff)
+			}
+			strs = append(strs, s)
+		}
+		return strings.Join(strs, ",")
+	default:
+		buff, _ := json.Marshal(v)
+		return string(buff)
+	}
+}
+
+// Close closes the validator.
+func (v *JWTValidator) Close() {
+	if v.jwks != nil {
+		v.jwks.close()
+	}
 }
@@ -19,12 +19,17 @@ package validator
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
@@ -39,6 +44,16 @@ type (
 		ClientID     string `yaml:"clientId" jsonschema:"omitempty"`
 		ClientSecret string `yaml:"clientSecret" jsonschema:"omitempty"`
 		InsecureTLS  bool   `yaml:"insecureTls"`
+		Timeout      string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// CacheTTL caches the introspection results, so the introspection
+		// server isn't requested for every request. The results are never
+		// cached after the expiry of the tokens.
+		CacheTTL  string `yaml:"cacheTTL" jsonschema:"omitempty,format=duration"`
+		CacheSize int    `yaml:"cacheSize" jsonschema:"omitempty,minimum=0"`
+		// FailureMode is what to do when the introspection server fails,
+		// deny rejects the request, passThrough passes the request with
+		// header X-Token-Unverified.
+		FailureMode string `yaml:"failureMode,omitempty" jsonschema:"omitempty,enum=deny,enum=passThrough"`
 	}
 
 	// OAuth2JWT defines the validator configuration for OAuth2 self encoded access token
@@ -49,16 +64,42 @@ type (
 		secretBytes []byte
 	}
 
+	// OAuth2ScopeRule defines the scopes required by the requests of a
+	// route, the route is matched by path or path prefix, and methods.
+	OAuth2ScopeRule struct {
+		Path       string   `yaml:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		PathPrefix string   `yaml:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+		Methods    []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Scopes     []string `yaml:"scopes" jsonschema:"required,minItems=1,uniqueItems=true"`
+	}
+
 	// OAuth2ValidatorSpec defines the configuration of OAuth2 validator
 	OAuth2ValidatorSpec struct {
 		TokenIntrospect *OAuth2TokenIntrospect `yaml:"tokenIntrospect" jsonschema:"omitempty"`
 		JWT             *OAuth2JWT             `yaml:"jwt" jsonschema:"omitempty"`
+		// ScopeRules are checked in order, the first matched rule applies,
+		// requests match no rule require no scope.
+		ScopeRules []*OAuth2ScopeRule `yaml:"scopeRules" jsonschema:"omitempty"`
 	}
 
 	// OAuth2Validator defines the OAuth2 validator
 	OAuth2Validator struct {
 		spec   *OAuth2ValidatorSpec
 		client *http.Client
+		cache  *tokenCache
+	}
+
+	// tokenCache caches token infos by the hash of tokens.
+	tokenCache struct {
+		ttl     time.Duration
+		size    int
+		mutex   sync.Mutex
+		entries map[[sha256.Size]byte]*tokenCacheEntry
+	}
+
+	tokenCacheEntry struct {
+		info    *tokenInfo
+		expires time.Time
 	}
 
 	tokenInfo struct {
@@ -76,18 +117,134 @@ type (
 	}
 )
 
+const (
+	failureModeDeny        = "deny"
+	failureModePassThrough = "passThrough"
+
+	defaultTokenCacheSize       = 10000
+	defaultIntrospectTimeout    = 5 * time.Second
+	keyHeaderTokenUnverified    = "X-Token-Unverified"
+	keyHeaderAuthenticatedUser  = "X-Authenticated-Userid"
+	keyHeaderAuthenticatedScope = "X-Authenticated-Scope"
+)
+
+// errIntrospectionFailed means the token can't be introspected, rather
+// than the token is invalid.
+var errIntrospectionFailed = errors.New("token introspection failed")
+
+// Validate validates OAuth2ValidatorSpec.
+func (spec OAuth2ValidatorSpec) Validate() error {
+	if spec.TokenIntrospect == nil && spec.JWT == nil {
+		return fmt.Errorf("one of tokenIntrospect and jwt should be specified")
+	}
+	for _, rule := range spec.ScopeRules {
+		if rule.Path != "" && rule.PathPrefix != "" {
+			return fmt.Errorf("path and pathPrefix of scope rule can't be both specified")
+		}
+	}
+	return nil
+}
+
+func (rule *OAuth2ScopeRule) match(req context.HTTPRequest) bool {
+	if rule.Path != "" && req.Path() != rule.Path {
+		return false
+	}
+	if rule.PathPrefix != "" && !strings.HasPrefix(req.Path(), rule.PathPrefix) {
+		return false
+	}
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, m := range rule.Methods {
+		if m == req.Method() {
+			return true
+		}
+	}
+	return false
+}
+
+func newTokenCache(ttl time.Duration, size int) *tokenCache {
+	if size <= 0 {
+		size = defaultTokenCacheSize
+	}
+	return &tokenCache{
+		ttl:     ttl,
+		size:    size,
+		entries: map[[sha256.Size]byte]*tokenCacheEntry{},
+	}
+}
+
+func (c *tokenCache) get(token string) *tokenInfo {
+	key := sha256.Sum256([]byte(token))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := c.entries[key]
+	if entry == nil {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.info
+}
+
+func (c *tokenCache) set(token string, info *tokenInfo) {
+	now := time.Now()
+	expires := now.Add(c.ttl)
+	if info.ExpiresAt > 0 {
+		if exp := time.Unix(info.ExpiresAt, 0); exp.Before(expires) {
+			expires = exp
+		}
+	}
+	if !expires.After(now) {
+		return
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= c.size {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// NOTE: Evict arbitrary entries if there are still too many.
+	for k := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		delete(c.entries, k)
+	}
+
+	c.entries[key] = &tokenCacheEntry{info: info, expires: expires}
+}
+
 // NewOAuth2Validator creates a new OAuth2 validator
 func NewOAuth2Validator(spec *OAuth2ValidatorSpec) *OAuth2Validator {
 	if spec.JWT != nil {
 		spec.JWT.secretBytes, _ = hex.DecodeString(spec.JWT.Secret)
 	}
 	v := &OAuth2Validator{spec: spec}
-	if spec.TokenIntrospect != nil {
-		if spec.TokenIntrospect.InsecureTLS {
+	if ti := spec.TokenIntrospect; ti != nil {
+		timeout := defaultIntrospectTimeout
+		if d, err := time.ParseDuration(ti.Timeout); err == nil && d > 0 {
+			timeout = d
+		}
+		if ti.InsecureTLS {
 			cfg := tls.Config{InsecureSkipVerify: true}
-			v.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &cfg}}
+			v.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &cfg}, Timeout: timeout}
 		} else {
-			v.client = http.DefaultClient
+			v.client = &http.Client{Timeout: timeout}
+		}
+		if ttl, err := time.ParseDuration(ti.CacheTTL); err == nil && ttl > 0 {
+			v.cache = newTokenCache(ttl, ti.CacheSize)
 		}
 	}
 	return v
@@ -101,12 +258,12 @@ var fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response,
 func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 	var body bytes.Buffer
 	body.WriteString("token=")
-	body.WriteString(tokenStr)
+	body.WriteString(url.QueryEscape(tokenStr))
 	if v.spec.TokenIntrospect.ClientID != "" {
 		body.WriteString("&client_id=")
-		body.WriteString(v.spec.TokenIntrospect.ClientID)
+		body.WriteString(url.QueryEscape(v.spec.TokenIntrospect.ClientID))
 		body.WriteString("&client_secret=")
-		body.WriteString(v.spec.TokenIntrospect.ClientSecret)
+		body.WriteString(url.QueryEscape(v.spec.TokenIntrospect.ClientSecret))
 	}
 
 	r, _ := http.NewRequest(http.MethodPost, v.spec.TokenIntrospect.EndPoint, &body)
@@ -118,7 +275,12 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 
 	resp, e := fnSendRequest(v.client, r)
 	if e != nil {
-		return nil, e
+		return nil, fmt.Errorf("%w: %v", errIntrospectionFailed, e)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status code %d", errIntrospectionFailed, resp.StatusCode)
 	}
 
 	var ti struct {
@@ -128,20 +290,68 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 	}
 
 	if e = json.NewDecoder(resp.Body).Decode(&ti); e != nil {
-		return nil, e
+		return nil, fmt.Errorf("%w: %v", errIntrospectionFailed, e)
 	}
 	if ti.Error != "" {
-		return nil, fmt.Errorf("%s: %s", ti.Error, ti.ErrorDesc)
+		return nil, fmt.Errorf("%w: %s: %s", errIntrospectionFailed, ti.Error, ti.ErrorDesc)
 	}
 
 	return &ti.tokenInfo, nil
 }
 
+func (v *OAuth2Validator) introspectTokenCached(tokenStr string) (*tokenInfo, error) {
+	if v.cache == nil {
+		return v.introspectToken(tokenStr)
+	}
+
+	if ti := v.cache.get(tokenStr); ti != nil {
+		return ti, nil
+	}
+
+	ti, e := v.introspectToken(tokenStr)
+	if e != nil {
+		return nil, e
+	}
+	v.cache.set(tokenStr, ti)
+	return ti, nil
+}
+
+// checkScopes checks the scopes of the token against the first matched
+// scope rule.
+func (v *OAuth2Validator) checkScopes(req context.HTTPRequest, scope string) error {
+	for _, rule := range v.spec.ScopeRules {
+		if !rule.match(req) {
+			continue
+		}
+
+		granted := strings.Fields(scope)
+		for _, required := range rule.Scopes {
+			found := false
+			for _, s := range granted {
+				if s == required {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("insufficient scope, %s is required", required)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
 // Validate validates the access token of a http request
 func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 	const prefix = "Bearer "
 
 	hdr := req.Header()
+	// NOTE: Remove the headers from the client, so they can't be forged.
+	hdr.Del(keyHeaderAuthenticatedUser)
+	hdr.Del(keyHeaderAuthenticatedScope)
+	hdr.Del(keyHeaderTokenUnverified)
+
 	tokenStr := hdr.Get("Authorization")
 	if !strings.HasPrefix(tokenStr, prefix) {
 		return fmt.Errorf("unexpected authorization header: %s", tokenStr)
@@ -150,7 +360,12 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 
 	var subject, scope string
 	if v.spec.TokenIntrospect != nil {
-		ti, e := v.introspectToken(tokenStr)
+		ti, e := v.introspectTokenCached(tokenStr)
+		if errors.Is(e, errIntrospectionFailed) && v.spec.TokenIntrospect.FailureMode == failureModePassThrough {
+			// NOTE: The backends decide what to do with unverified tokens.
+			hdr.Set(keyHeaderTokenUnverified, "true")
+			return nil
+		}
 		if e != nil {
 			return e
 		}
@@ -175,12 +390,16 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 		scope, _ = claims["scope"].(string)
 	}
 
+	if e := v.checkScopes(req, scope); e != nil {
+		return e
+	}
+
 	if subject != "" {
-		hdr.Set("X-Authenticated-Userid", subject)
+		hdr.Set(keyHeaderAuthenticatedUser, subject)
 	}
 
 	if scope != "" {
-		hdr.Set("X-Authenticated-Scope", scope)
+		hdr.Set(keyHeaderAuthenticatedScope, scope)
 	}
 
 	return nil
@@ -0,0 +1,617 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/ghodss/yaml"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultMaxSchemaBodySize = 1 << 20
+
+	// The locations of the parameters are path, query, header and
+	// cookie, the same as OpenAPI.
+	locationBody     = "body"
+	locationRoute    = "route"
+	locationSecurity = "security"
+)
+
+var templateParamRegexp = regexp.MustCompile(`\{[^{}/]+\}`)
+
+type (
+	// SchemaValidatorSpec defines the configuration of the schema validator,
+	// the requests are validated against an OpenAPI 3 document, or their
+	// bodies are validated against a JSON Schema.
+	SchemaValidatorSpec struct {
+		OpenAPI    *SchemaSourceSpec `yaml:"openAPI,omitempty" jsonschema:"omitempty"`
+		JSONSchema *SchemaSourceSpec `yaml:"jsonSchema,omitempty" jsonschema:"omitempty"`
+		// MaxBodySize is the maximum size of the body to validate, larger
+		// requests are rejected, default is 1MB.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=0"`
+		// AllowUnknownRoutes passes the requests matching no operation of
+		// the OpenAPI document instead of rejecting them.
+		AllowUnknownRoutes bool `yaml:"allowUnknownRoutes" jsonschema:"omitempty"`
+	}
+
+	// SchemaSourceSpec defines where the schema is loaded from, it is in
+	// JSON or YAML.
+	SchemaSourceSpec struct {
+		File    string `yaml:"file" jsonschema:"omitempty"`
+		Content string `yaml:"content" jsonschema:"omitempty"`
+	}
+
+	// SchemaValidator defines the schema validator.
+	SchemaValidator struct {
+		spec        *SchemaValidatorSpec
+		maxBodySize int64
+
+		doc    *openapi3.T
+		router *openAPIRouter
+		schema *gojsonschema.Schema
+
+		requests uint64
+		invalid  uint64
+
+		mutex      sync.Mutex
+		failures   map[string]uint64
+		operations map[string]uint64
+	}
+
+	// SchemaValidatorStatus is the status of the schema validator.
+	SchemaValidatorStatus struct {
+		Requests uint64 `yaml:"requests"`
+		Invalid  uint64 `yaml:"invalid"`
+		// Failures is the number of failures by location: path, query,
+		// header, cookie, body, route and security.
+		Failures map[string]uint64 `yaml:"failures,omitempty"`
+		// Operations is the number of invalid requests by operation.
+		Operations map[string]uint64 `yaml:"operations,omitempty"`
+	}
+
+	// SchemaValidationError is the error of an invalid request, it is
+	// returned to the client in JSON.
+	SchemaValidationError struct {
+		StatusCode int          `json:"-"`
+		Message    string       `json:"message"`
+		Operation  string       `json:"operation,omitempty"`
+		Errors     []FieldError `json:"errors,omitempty"`
+	}
+
+	// FieldError describes a failure of the request validation.
+	FieldError struct {
+		// In is the location of the failure: path, query, header, cookie,
+		// body, route or security.
+		In string `json:"in"`
+		// Name is the name of the parameter.
+		Name string `json:"name,omitempty"`
+		// Field is the JSON pointer to the invalid field of the body.
+		Field  string `json:"field,omitempty"`
+		Reason string `json:"reason"`
+	}
+
+	openAPIRouter struct {
+		doc    *openapi3.T
+		bases  []string
+		routes []*openAPIRoute
+	}
+
+	openAPIRoute struct {
+		path   string
+		item   *openapi3.PathItem
+		re     *regexp.Regexp
+		names  []string
+		params int
+	}
+)
+
+// Validate validates SchemaSourceSpec.
+func (spec SchemaSourceSpec) Validate() error {
+	if (spec.File == "") == (spec.Content == "") {
+		return fmt.Errorf("one and only one of file and content should be specified")
+	}
+	return nil
+}
+
+func (spec *SchemaSourceSpec) load() ([]byte, error) {
+	if spec.Content != "" {
+		return []byte(spec.Content), nil
+	}
+	return os.ReadFile(spec.File)
+}
+
+// Validate validates SchemaValidatorSpec, the schema is loaded and
+// compiled, so errors of it are reported at config time.
+func (spec SchemaValidatorSpec) Validate() error {
+	if (spec.OpenAPI == nil) == (spec.JSONSchema == nil) {
+		return fmt.Errorf("one and only one of openAPI and jsonSchema should be specified")
+	}
+
+	if spec.OpenAPI != nil {
+		_, err := loadOpenAPI(spec.OpenAPI)
+		return err
+	}
+	_, err := loadJSONSchema(spec.JSONSchema)
+	return err
+}
+
+func loadOpenAPI(spec *SchemaSourceSpec) (*openapi3.T, error) {
+	data, err := spec.load()
+	if err != nil {
+		return nil, fmt.Errorf("load OpenAPI document failed: %v", err)
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("load OpenAPI document failed: %v", err)
+	}
+	if err = doc.Validate(stdcontext.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+	return doc, nil
+}
+
+func loadJSONSchema(spec *SchemaSourceSpec) (*gojsonschema.Schema, error) {
+	data, err := spec.load()
+	if err != nil {
+		return nil, fmt.Errorf("load JSON Schema failed: %v", err)
+	}
+
+	// NOTE: JSON is valid YAML, so both of them are accepted.
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("load JSON Schema failed: %v", err)
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %v", err)
+	}
+	return schema, nil
+}
+
+// NewSchemaValidator creates a new schema validator.
+func NewSchemaValidator(spec *SchemaValidatorSpec) *SchemaValidator {
+	v := &SchemaValidator{
+		spec:        spec,
+		maxBodySize: spec.MaxBodySize,
+		failures:    map[string]uint64{},
+		operations:  map[string]uint64{},
+	}
+	if v.maxBodySize == 0 {
+		v.maxBodySize = defaultMaxSchemaBodySize
+	}
+
+	var err error
+	if spec.OpenAPI != nil {
+		v.doc, err = loadOpenAPI(spec.OpenAPI)
+		if err == nil {
+			v.router = newOpenAPIRouter(v.doc)
+		}
+	} else {
+		v.schema, err = loadJSONSchema(spec.JSONSchema)
+	}
+	if err != nil {
+		// NOTE: The spec has been validated, so it only happens when the
+		// file is changed or removed afterwards, all requests are
+		// rejected until the spec is updated.
+		logger.Errorf("create schema validator failed: %v", err)
+	}
+
+	return v
+}
+
+// Validate validates the http request against the schema, the returned
+// error is a *SchemaValidationError if the request is invalid.
+func (v *SchemaValidator) Validate(req context.HTTPRequest) error {
+	atomic.AddUint64(&v.requests, 1)
+
+	var err *SchemaValidationError
+	switch {
+	case v.router != nil:
+		err = v.validateOpenAPI(req)
+	case v.schema != nil:
+		err = v.validateJSONSchema(req)
+	default:
+		err = &SchemaValidationError{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "schema unavailable",
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+	v.recordFailure(err)
+	return err
+}
+
+func (v *SchemaValidator) recordFailure(err *SchemaValidationError) {
+	atomic.AddUint64(&v.invalid, 1)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if err.Operation != "" {
+		v.operations[err.Operation]++
+	}
+	counted := map[string]bool{}
+	for _, fe := range err.Errors {
+		if !counted[fe.In] {
+			counted[fe.In] = true
+			v.failures[fe.In]++
+		}
+	}
+}
+
+// readBody reads the body for validation, and sets it back for the
+// following filters.
+func (v *SchemaValidator) readBody(req context.HTTPRequest) ([]byte, *SchemaValidationError) {
+	body := req.Body()
+	buff, err := io.ReadAll(io.LimitReader(body, v.maxBodySize+1))
+	req.SetBody(io.MultiReader(bytes.NewReader(buff), body))
+	if err != nil {
+		return nil, &SchemaValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "read request body failed",
+			Errors:     []FieldError{{In: locationBody, Reason: err.Error()}},
+		}
+	}
+	if int64(len(buff)) > v.maxBodySize {
+		return nil, &SchemaValidationError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    "request body too large",
+			Errors: []FieldError{{
+				In:     locationBody,
+				Reason: fmt.Sprintf("body is larger than %d bytes", v.maxBodySize),
+			}},
+		}
+	}
+	return buff, nil
+}
+
+func (v *SchemaValidator) validateOpenAPI(req context.HTTPRequest) *SchemaValidationError {
+	route, params, status := v.router.find(req.Method(), req.Path())
+	if route == nil {
+		if v.spec.AllowUnknownRoutes {
+			return nil
+		}
+		message := "no matching operation was found"
+		if status == http.StatusMethodNotAllowed {
+			message = "method not allowed"
+		}
+		return &SchemaValidationError{
+			StatusCode: status,
+			Message:    message,
+			Errors: []FieldError{{
+				In:     locationRoute,
+				Reason: fmt.Sprintf("%s %s: %s", req.Method(), req.Path(), message),
+			}},
+		}
+	}
+
+	operation := route.Operation.OperationID
+	if operation == "" {
+		operation = route.Method + " " + route.Path
+	}
+
+	stdr := &http.Request{
+		Method: req.Method(),
+		URL:    &url.URL{Path: req.Path(), RawQuery: req.Query()},
+		Header: req.Header().Std(),
+		Host:   req.Host(),
+		Body:   http.NoBody,
+	}
+	if route.Operation.RequestBody != nil {
+		body, verr := v.readBody(req)
+		if verr != nil {
+			verr.Operation = operation
+			return verr
+		}
+		if len(body) > 0 {
+			stdr.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    stdr,
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// NOTE: Authentication is the job of the other validators.
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	err := openapi3filter.ValidateRequest(stdcontext.Background(), input)
+	if err == nil {
+		return nil
+	}
+
+	return &SchemaValidationError{
+		StatusCode: http.StatusBadRequest,
+		Message:    "request validation failed",
+		Operation:  operation,
+		Errors:     openAPIFieldErrors(err, nil),
+	}
+}
+
+func openAPIFieldErrors(err error, result []FieldError) []FieldError {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, err := range e {
+			result = openAPIFieldErrors(err, result)
+		}
+
+	case *openapi3filter.RequestError:
+		fe := FieldError{In: locationBody}
+		if e.Parameter != nil {
+			fe.In, fe.Name = e.Parameter.In, e.Parameter.Name
+		}
+		if e.Err == nil {
+			fe.Reason = e.Reason
+			return append(result, fe)
+		}
+		for _, se := range schemaErrors(e.Err, nil) {
+			fe.Field, fe.Reason = se.Field, se.Reason
+			if fe.Reason == "" {
+				fe.Reason = e.Reason
+			}
+			result = append(result, fe)
+		}
+
+	case *openapi3filter.SecurityRequirementsError:
+		result = append(result, FieldError{In: locationSecurity, Reason: e.Error()})
+
+	default:
+		result = append(result, FieldError{In: locationRoute, Reason: err.Error()})
+	}
+
+	return result
+}
+
+// schemaErrors flattens the schema errors, only Field and Reason of the
+// returned errors are set.
+func schemaErrors(err error, result []FieldError) []FieldError {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, err := range e {
+			result = schemaErrors(err, result)
+		}
+	case *openapi3.SchemaError:
+		field := ""
+		if pointer := e.JSONPointer(); len(pointer) > 0 {
+			field = "/" + strings.Join(pointer, "/")
+		}
+		result = append(result, FieldError{Field: field, Reason: e.Reason})
+	default:
+		result = append(result, FieldError{Reason: err.Error()})
+	}
+	return result
+}
+
+func (v *SchemaValidator) validateJSONSchema(req context.HTTPRequest) *SchemaValidationError {
+	body, verr := v.readBody(req)
+	if verr != nil {
+		return verr
+	}
+
+	invalid := func(fe ...FieldError) *SchemaValidationError {
+		return &SchemaValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "request validation failed",
+			Errors:     fe,
+		}
+	}
+
+	if !json.Valid(body) {
+		return invalid(FieldError{In: locationBody, Reason: "body is not valid JSON"})
+	}
+
+	result, err := v.schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return invalid(FieldError{In: locationBody, Reason: err.Error()})
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	errs := make([]FieldError, 0, len(result.Errors()))
+	for _, re := range result.Errors() {
+		field := strings.TrimPrefix(re.Context().String("/"), gojsonschema.STRING_CONTEXT_ROOT)
+		errs = append(errs, FieldError{In: locationBody, Field: field, Reason: re.Description()})
+	}
+	return invalid(errs...)
+}
+
+// Status returns the status of the schema validator.
+func (v *SchemaValidator) Status() *SchemaValidatorStatus {
+	s := &SchemaValidatorStatus{
+		Requests:   atomic.LoadUint64(&v.requests),
+		Invalid:    atomic.LoadUint64(&v.invalid),
+		Failures:   map[string]uint64{},
+		Operations: map[string]uint64{},
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for k, n := range v.failures {
+		s.Failures[k] = n
+	}
+	for k, n := range v.operations {
+		s.Operations[k] = n
+	}
+	return s
+}
+
+// Error implements error.
+func (e *SchemaValidationError) Error() string {
+	if len(e.Errors) == 0 {
+		return e.Message
+	}
+
+	reasons := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		where := fe.In
+		if fe.Name != "" {
+			where += " " + fe.Name
+		}
+		if fe.Field != "" {
+			where += " " + fe.Field
+		}
+		reasons = append(reasons, where+": "+fe.Reason)
+	}
+	return e.Message + ": " + strings.Join(reasons, "; ")
+}
+
+func newOpenAPIRouter(doc *openapi3.T) *openAPIRouter {
+	r := &openAPIRouter{doc: doc}
+
+	// NOTE: Only the base paths of the servers are used, the hosts and
+	// schemes are decided by the HTTPServer in front of the pipeline.
+	bases := map[string]bool{}
+	for _, server := range doc.Servers {
+		base := serverBasePath(server)
+		if !bases[base] {
+			bases[base] = true
+			r.bases = append(r.bases, base)
+		}
+	}
+	if len(r.bases) == 0 {
+		r.bases = []string{""}
+	}
+	// Longer base paths are matched first.
+	sort.Slice(r.bases, func(i, j int) bool {
+		return len(r.bases[i]) > len(r.bases[j])
+	})
+
+	for path, item := range doc.Paths {
+		r.routes = append(r.routes, newOpenAPIRoute(path, item))
+	}
+	// NOTE: Concrete paths are matched before their templated counterparts.
+	sort.Slice(r.routes, func(i, j int) bool {
+		ri, rj := r.routes[i], r.routes[j]
+		if ri.params != rj.params {
+			return ri.params < rj.params
+		}
+		return ri.path < rj.path
+	})
+
+	return r
+}
+
+func serverBasePath(server *openapi3.Server) string {
+	u := templateParamRegexp.ReplaceAllStringFunc(server.URL, func(s string) string {
+		if v := server.Variables[s[1:len(s)-1]]; v != nil {
+			return v.Default
+		}
+		return s
+	})
+
+	if strings.Contains(u, "://") {
+		if parsed, err := url.Parse(u); err == nil {
+			u = parsed.Path
+		} else {
+			u = ""
+		}
+	}
+	return strings.TrimRight(u, "/")
+}
+
+func newOpenAPIRoute(path string, item *openapi3.PathItem) *openAPIRoute {
+	route := &openAPIRoute{path: path, item: item}
+
+	pattern := &strings.Builder{}
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range templateParamRegexp.FindAllStringIndex(path, -1) {
+		pattern.WriteString(regexp.QuoteMeta(path[last:loc[0]]))
+		pattern.WriteString("([^/]+)")
+		route.names = append(route.names, path[loc[0]+1:loc[1]-1])
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(path[last:]))
+	pattern.WriteString("$")
+
+	route.re = regexp.MustCompile(pattern.String())
+	route.params = len(route.names)
+	return route
+}
+
+// find finds the route of the request, the status code is 404 or 405 if
+// there isn't one.
+func (r *openAPIRouter) find(method, path string) (*routers.Route, map[string]string, int) {
+	status := http.StatusNotFound
+
+	for _, base := range r.bases {
+		if !strings.HasPrefix(path, base) {
+			continue
+		}
+		p := path[len(base):]
+		if !strings.HasPrefix(p, "/") {
+			continue
+		}
+
+		for _, route := range r.routes {
+			match := route.re.FindStringSubmatch(p)
+			if match == nil {
+				continue
+			}
+			operation := route.item.GetOperation(method)
+			if operation == nil {
+				status = http.StatusMethodNotAllowed
+				continue
+			}
+
+			params := make(map[string]string, len(route.names))
+			for i, name := range route.names {
+				params[name] = match[i+1]
+			}
+			return &routers.Route{
+				Spec:      r.doc,
+				Path:      route.path,
+				PathItem:  route.item,
+				Method:    method,
+				Operation: operation,
+			}, params, 0
+		}
+	}
+
+	return nil, nil, status
+}
@@ -18,6 +18,8 @@
 package validator
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -50,6 +52,8 @@ type (
 		jwt     *JWTValidator
 		signer  *signer.Signer
 		oauth2  *OAuth2Validator
+		basic   *BasicAuthValidator
+		schema  *SchemaValidator
 	}
 
 	// Spec describes the Validator.
@@ -58,6 +62,13 @@ type (
 		JWT       *JWTValidatorSpec         `yaml:"jwt,omitempty" jsonschema:"omitempty"`
 		Signature *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
 		OAuth2    *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
+		BasicAuth *BasicAuthValidatorSpec   `yaml:"basicAuth,omitempty" jsonschema:"omitempty"`
+		Schema    *SchemaValidatorSpec      `yaml:"schema,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of Validator.
+	Status struct {
+		Schema *SchemaValidatorStatus `yaml:"schema,omitempty"`
 	}
 )
 
@@ -109,6 +120,14 @@ func (v *Validator) reload() {
 	if v.spec.OAuth2 != nil {
 		v.oauth2 = NewOAuth2Validator(v.spec.OAuth2)
 	}
+
+	if v.spec.BasicAuth != nil {
+		v.basic = NewBasicAuthValidator(v.spec.BasicAuth)
+	}
+
+	if v.spec.Schema != nil {
+		v.schema = NewSchemaValidator(v.spec.Schema)
+	}
 }
 
 // Handle validates HTTPContext.
@@ -156,11 +175,54 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 		}
 	}
 
+	if v.basic != nil {
+		err := v.basic.Validate(req)
+		if err != nil {
+			ctx.Response().SetStatusCode(http.StatusUnauthorized)
+			ctx.Response().Header().Set("WWW-Authenticate",
+				stringtool.Cat(`Basic realm="`, v.basic.Realm(), `", charset="UTF-8"`))
+			ctx.AddTag(stringtool.Cat("basic auth validator: ", err.Error()))
+			return resultInvalid
+		}
+	}
+
+	if v.schema != nil {
+		err := v.schema.Validate(req)
+		if err != nil {
+			v.writeSchemaError(ctx, err.(*SchemaValidationError))
+			ctx.AddTag(stringtool.Cat("schema validator: ", err.Error()))
+			return resultInvalid
+		}
+	}
+
 	return ""
 }
 
+// writeSchemaError writes the details of the schema validation failure
+// to the response, so the clients know what's wrong with the request.
+func (v *Validator) writeSchemaError(ctx context.HTTPContext, err *SchemaValidationError) {
+	w := ctx.Response()
+	w.SetStatusCode(err.StatusCode)
+
+	buf, e := json.Marshal(err)
+	if e != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buf))
+}
+
 // Status returns status.
-func (v *Validator) Status() interface{} { return nil }
+func (v *Validator) Status() interface{} {
+	if v.schema == nil {
+		return nil
+	}
+	return &Status{Schema: v.schema.Status()}
+}
 
 // Close closes Validator.
-func (v *Validator) Close() {}
+func (v *Validator) Close() {
+	if v.jwt != nil {
+		v.jwt.Close()
+	}
+}
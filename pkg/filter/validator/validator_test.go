@@ -18,16 +18,32 @@
 package validator
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
@@ -160,6 +176,203 @@ jwt:
 	v.Description()
 }
 
+func jwkOfRSA(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token failed: %v", err)
+	}
+	return s
+}
+
+func TestJWTJWKS(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetched int32
+	var rotated atomic.Value
+	rotated.Store(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		keys := []map[string]string{jwkOfRSA("key1", &key1.PublicKey)}
+		if rotated.Load().(bool) {
+			keys = []map[string]string{jwkOfRSA("key2", &key2.PublicKey)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	yamlSpec := fmt.Sprintf(`
+kind: Validator
+name: validator
+jwt:
+  algorithm: RS256
+  jwks:
+    url: %s
+  issuer: https://issuer.example.com
+  audiences: [api]
+  claimsToHeaders:
+    sub: X-User
+    roles: X-Roles
+`, server.URL)
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		return nil, fmt.Errorf("not exist")
+	}
+	header := http.Header{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+
+	claims := jwt.MapClaims{
+		"sub":   "alice",
+		"roles": []string{"admin", "dev"},
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"web", "api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "key1", key1, claims))
+	header.Set("X-User", "mallory")
+	if v.Handle(ctx) == resultInvalid {
+		t.Fatalf("the jwt token signed by key1 should be valid")
+	}
+	if header.Get("X-User") != "alice" {
+		t.Errorf("X-User should be alice, got %q", header.Get("X-User"))
+	}
+	if header.Get("X-Roles") != "admin,dev" {
+		t.Errorf("X-Roles should be admin,dev, got %q", header.Get("X-Roles"))
+	}
+
+	// the key is rotated, the token of the unknown key ID triggers refreshing.
+	rotated.Store(true)
+	v.jwt.jwks.mutex.Lock()
+	v.jwt.jwks.fetchedAt = time.Time{}
+	v.jwt.jwks.mutex.Unlock()
+	n := atomic.LoadInt32(&fetched)
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "key2", key2, claims))
+	if v.Handle(ctx) == resultInvalid {
+		t.Fatalf("the jwt token signed by key2 should be valid")
+	}
+	if atomic.LoadInt32(&fetched) != n+1 {
+		t.Errorf("jwks should be fetched once more")
+	}
+
+	// refreshing is rate limited.
+	n = atomic.LoadInt32(&fetched)
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "key3", key2, claims))
+	if v.Handle(ctx) != resultInvalid {
+		t.Errorf("the jwt token of unknown key should be invalid")
+	}
+	if atomic.LoadInt32(&fetched) != n {
+		t.Errorf("jwks should not be fetched again")
+	}
+
+	// forged headers are removed even if the token is invalid.
+	header.Set("X-User", "mallory")
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, "key2", []byte("secret"), claims))
+	if v.Handle(ctx) != resultInvalid {
+		t.Errorf("the jwt token of unexpected algorithm should be invalid")
+	}
+	if header.Get("X-User") != "" {
+		t.Errorf("X-User should be removed")
+	}
+
+	for name, value := range map[string]interface{}{
+		"iss": "https://other.example.com",
+		"aud": "web",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	} {
+		c := jwt.MapClaims{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		c[name] = value
+		header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "key2", key2, c))
+		if v.Handle(ctx) != resultInvalid {
+			t.Errorf("the jwt token of unexpected %s should be invalid", name)
+		}
+	}
+}
+
+func TestJWTPublicKey(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	yamlSpec := fmt.Sprintf(`
+kind: Validator
+name: validator
+jwt:
+  algorithm: ES256
+  publicKey: |
+    %s
+  requireExp: true
+  leeway: 1m
+`, strings.ReplaceAll(strings.TrimSpace(string(pemKey)), "\n", "\n    "))
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		return nil, fmt.Errorf("not exist")
+	}
+	header := http.Header{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+
+	claims := jwt.MapClaims{"exp": time.Now().Add(-30 * time.Second).Unix()}
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodES256, "", key, claims))
+	if v.Handle(ctx) == resultInvalid {
+		t.Errorf("the jwt token expired within leeway should be valid")
+	}
+
+	claims = jwt.MapClaims{"exp": time.Now().Add(-2 * time.Minute).Unix()}
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodES256, "", key, claims))
+	if v.Handle(ctx) != resultInvalid {
+		t.Errorf("the expired jwt token should be invalid")
+	}
+
+	claims = jwt.MapClaims{"sub": "alice"}
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodES256, "", key, claims))
+	if v.Handle(ctx) != resultInvalid {
+		t.Errorf("the jwt token without exp should be invalid")
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	claims = jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}
+	header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodES256, "", other, claims))
+	if v.Handle(ctx) != resultInvalid {
+		t.Errorf("the jwt token signed by other key should be invalid")
+	}
+
+	spec := &JWTValidatorSpec{Algorithm: "RS256"}
+	if spec.Validate() == nil {
+		t.Errorf("RS256 without key should be invalid")
+	}
+	spec = &JWTValidatorSpec{Algorithm: "HS256"}
+	if spec.Validate() == nil {
+		t.Errorf("HS256 without secret should be invalid")
+	}
+}
+
 func TestOAuth2JWT(t *testing.T) {
 	const yamlSpec = `
 kind: Validator
@@ -235,7 +448,8 @@ oauth2:
 	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
 		reader := strings.NewReader(body)
 		return &http.Response{
-			Body: io.NopCloser(reader),
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(reader),
 		}, nil
 	}
 	result := v.Handle(ctx)
@@ -266,6 +480,111 @@ oauth2:
 	}
 }
 
+func TestOAuth2TokenIntrospectCacheAndScopes(t *testing.T) {
+	yamlSpec := `
+kind: Validator
+name: validator
+oauth2:
+  tokenIntrospect:
+    endPoint: http://oauth2.megaease.com/
+    clientId: megaease
+    clientSecret: secret
+    cacheTTL: 1m
+  scopeRules:
+  - pathPrefix: /admin/
+    scopes: [admin]
+  - methods: [POST, PUT, DELETE]
+    scopes: [write]
+`
+	v := createValidator(yamlSpec, nil)
+
+	var requests int
+	status, body := http.StatusOK, `{"active": true, "sub": "alice", "scope": "read write"}`
+	fnSendRequest = func(client *http.Client, r *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	}
+
+	validate := func(method, path, token string) (string, http.Header) {
+		ctx := &contexttest.MockedHTTPContext{}
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+token)
+		header.Set("X-Authenticated-Userid", "mallory")
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(header)
+		}
+		ctx.MockedRequest.MockedMethod = func() string { return method }
+		ctx.MockedRequest.MockedPath = func() string { return path }
+		return v.Handle(ctx), header
+	}
+
+	result, header := validate(http.MethodPost, "/orders", "token1")
+	if result == resultInvalid {
+		t.Errorf("token with write scope should be valid")
+	}
+	if header.Get("X-Authenticated-Userid") != "alice" {
+		t.Errorf("X-Authenticated-Userid should be alice, got %q", header.Get("X-Authenticated-Userid"))
+	}
+
+	if result, _ = validate(http.MethodGet, "/admin/users", "token1"); result != resultInvalid {
+		t.Errorf("token without admin scope should be invalid")
+	}
+	if result, _ = validate(http.MethodGet, "/orders", "token1"); result == resultInvalid {
+		t.Errorf("token should be valid for routes without rules")
+	}
+	if requests != 1 {
+		t.Errorf("the introspection result should be cached, requested %d times", requests)
+	}
+
+	// the introspection server fails, the request is denied by default.
+	status = http.StatusServiceUnavailable
+	if result, _ = validate(http.MethodGet, "/orders", "token2"); result != resultInvalid {
+		t.Errorf("token should be invalid when the introspection fails")
+	}
+
+	yamlSpec = `
+kind: Validator
+name: validator
+oauth2:
+  tokenIntrospect:
+    endPoint: http://oauth2.megaease.com/
+    failureMode: passThrough
+`
+	v = createValidator(yamlSpec, nil)
+	result, header = validate(http.MethodGet, "/orders", "token2")
+	if result == resultInvalid {
+		t.Errorf("request should be passed through when the introspection fails")
+	}
+	if header.Get("X-Token-Unverified") != "true" || header.Get("X-Authenticated-Userid") != "" {
+		t.Errorf("request should be tagged as unverified")
+	}
+
+	// inactive tokens are always invalid.
+	status, body = http.StatusOK, `{"active": false}`
+	if result, _ = validate(http.MethodGet, "/orders", "token3"); result != resultInvalid {
+		t.Errorf("inactive token should be invalid")
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	c := newTokenCache(time.Minute, 2)
+
+	c.set("expired", &tokenInfo{Active: true, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if c.get("expired") != nil {
+		t.Error("expired token should not be cached")
+	}
+
+	c.set("a", &tokenInfo{Active: true})
+	c.set("b", &tokenInfo{Active: true})
+	c.set("c", &tokenInfo{Active: true})
+	if len(c.entries) != 2 || c.get("c") == nil {
+		t.Errorf("cache should be limited to 2 entries")
+	}
+}
+
 func TestSignature(t *testing.T) {
 	// This test is almost covered by signer
 
@@ -289,3 +608,366 @@ signature:
 		t.Errorf("OAuth/2 Authorization should fail")
 	}
 }
+
+func TestApr1(t *testing.T) {
+	if got := apr1("myPassword", "qHDFfhPC"); got != "$apr1$qHDFfhPC$nITSVHgYbDAK1Y0acGRnY0" {
+		t.Errorf("unexpected apr1 hash %s", got)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	cases := map[string]string{
+		"alice":      "alice",
+		"a,b=c":      `a\,b\=c`,
+		" #x ":       `\ #x\ `,
+		"#x":         `\#x`,
+		"a\x00b":     `a\00b`,
+		`x+"y"<z>;\`: `x\+\"y\"\<z\>\;\\`,
+	}
+	for in, want := range cases {
+		if got := escapeDN(in); got != want {
+			t.Errorf("escapeDN(%q) should be %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+
+	f, err := os.CreateTemp("", "htpasswd")
+	if err != nil {
+		t.Fatalf("create user file failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "# comment\nbob:%s\nalice:$apr1$qHDFfhPC$nITSVHgYbDAK1Y0acGRnY0\ncarol:{SHA}GpHWL3ymc5liWkNopqtdSjuqYHM=\ndave:plain\n", bcryptHash)
+	f.Close()
+
+	yamlSpec := `
+kind: Validator
+name: validator
+basicAuth:
+  realm: test
+  userFile: ` + f.Name() + `
+  cacheTTL: 1m
+  usernameHeader: X-Auth-User
+`
+	v := createValidator(yamlSpec, nil)
+
+	check := func(username, password string, valid bool) {
+		header := http.Header{}
+		header.Set("X-Auth-User", "spoofed")
+		respHeader := http.Header{}
+		statusCode := 0
+
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(header)
+		}
+		ctx.MockedRequest.MockedStd = func() *http.Request {
+			r, _ := http.NewRequest(http.MethodGet, "http://megaease.com", nil)
+			if username != "" {
+				r.SetBasicAuth(username, password)
+			}
+			return r
+		}
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader {
+			return httpheader.New(respHeader)
+		}
+		ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+			statusCode = code
+		}
+
+		result := v.Handle(ctx)
+		if valid {
+			if result == resultInvalid {
+				t.Errorf("user %s should be valid", username)
+			}
+			if header.Get("X-Auth-User") != username {
+				t.Errorf("username header should be %s, got %s", username, header.Get("X-Auth-User"))
+			}
+			return
+		}
+
+		if result != resultInvalid {
+			t.Errorf("user %s should be invalid", username)
+		}
+		if statusCode != http.StatusUnauthorized {
+			t.Errorf("status code should be 401, got %d", statusCode)
+		}
+		if respHeader.Get("WWW-Authenticate") != `Basic realm="test", charset="UTF-8"` {
+			t.Errorf("unexpected WWW-Authenticate header %s", respHeader.Get("WWW-Authenticate"))
+		}
+		if header.Get("X-Auth-User") != "" {
+			t.Errorf("username header should be removed")
+		}
+	}
+
+	check("", "", false)
+	check("bob", "bcrypt-pw", true)
+	check("bob", "wrong", false)
+	check("alice", "myPassword", true)
+	check("alice", "wrong", false)
+	check("carol", "pw", true)
+	check("dave", "plain", false)
+	check("eve", "pw", false)
+	check("bob", "", false)
+
+	// verified credentials are cached, so they are still valid after the
+	// removal, while the new users are loaded from the changed file.
+	os.WriteFile(f.Name(), []byte("frank:{SHA}u1VWhsWW1Ya76ZVBmCSYkjlTPik=\n"), 0o600)
+	v.basic.store.(*htpasswdStore).checkedAt = time.Time{}
+	v.basic.store.(*htpasswdStore).modTime = time.Time{}
+	check("bob", "bcrypt-pw", true)
+	check("alice", "wrong", false)
+	check("frank", "frank-pw", true)
+
+	v.Close()
+}
+
+func TestBasicAuthSpec(t *testing.T) {
+	spec := BasicAuthValidatorSpec{}
+	if spec.Validate() == nil {
+		t.Errorf("spec without userFile and ldap should be invalid")
+	}
+	spec.UserFile = "/etc/htpasswd"
+	spec.LDAP = &LDAPSpec{URL: "ldap://localhost", BaseDN: "dc=example,dc=com"}
+	if spec.Validate() == nil {
+		t.Errorf("spec with both userFile and ldap should be invalid")
+	}
+	spec.UserFile = ""
+	if spec.Validate() != nil {
+		t.Errorf("spec with ldap should be valid")
+	}
+}
+
+func handleSchema(v *Validator, method, target, body string) (string, *httptest.ResponseRecorder, *SchemaValidationError) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	result, w := httppipelinetest.Handle(v, req, nil)
+
+	if w.Code < 400 {
+		return result, w, nil
+	}
+	err := &SchemaValidationError{}
+	json.Unmarshal(w.Body.Bytes(), err)
+	return result, w, err
+}
+
+func TestSchemaOpenAPI(t *testing.T) {
+	const yamlSpec = `
+kind: Validator
+name: validator
+schema:
+  openAPI:
+    content: |
+      openapi: 3.0.0
+      info:
+        title: pets
+        version: 1.0.0
+      servers:
+      - url: https://{host}/api/v1
+        variables:
+          host:
+            default: example.com
+      paths:
+        /pets:
+          post:
+            operationId: createPet
+            requestBody:
+              required: true
+              content:
+                application/json:
+                  schema:
+                    type: object
+                    required: [name]
+                    properties:
+                      name:
+                        type: string
+                        minLength: 1
+                      age:
+                        type: integer
+                        minimum: 0
+            responses:
+              "200":
+                description: ok
+        /pets/{id}:
+          get:
+            parameters:
+            - name: id
+              in: path
+              required: true
+              schema:
+                type: integer
+            - name: verbose
+              in: query
+              schema:
+                type: boolean
+            - name: X-Request-Id
+              in: header
+              required: true
+              schema:
+                type: string
+            responses:
+              "200":
+                description: ok
+        /pets/mine:
+          get:
+            responses:
+              "200":
+                description: ok
+`
+	v := createValidator(yamlSpec, nil)
+
+	result, _, _ := handleSchema(v, http.MethodPost, "/api/v1/pets", `{"name":"kitty","age":1}`)
+	if result != "" {
+		t.Errorf("valid request should pass")
+	}
+
+	result, w, err := handleSchema(v, http.MethodPost, "/api/v1/pets", `{"name":"","age":-1}`)
+	if result != resultInvalid || w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid body should be rejected by 400, got %d", w.Code)
+	}
+	if err.Operation != "createPet" || len(err.Errors) != 2 {
+		t.Fatalf("there should be 2 errors of operation createPet, got %+v", err)
+	}
+	fields := map[string]bool{}
+	for _, fe := range err.Errors {
+		if fe.In != "body" || fe.Reason == "" {
+			t.Errorf("unexpected error %+v", fe)
+		}
+		fields[fe.Field] = true
+	}
+	if !fields["/name"] || !fields["/age"] {
+		t.Errorf("fields /name and /age should be reported, got %+v", err.Errors)
+	}
+
+	result, _, err = handleSchema(v, http.MethodPost, "/api/v1/pets", "")
+	if result != resultInvalid || len(err.Errors) != 1 || err.Errors[0].In != "body" {
+		t.Errorf("missing body should be rejected, got %+v", err)
+	}
+
+	result, w, err = handleSchema(v, http.MethodGet, "/api/v1/pets/abc?verbose=maybe", "")
+	if result != resultInvalid || w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid parameters should be rejected by 400, got %d", w.Code)
+	}
+	got := map[string]bool{}
+	for _, fe := range err.Errors {
+		got[fe.In+" "+fe.Name] = true
+	}
+	if !got["path id"] || !got["query verbose"] || !got["header X-Request-Id"] {
+		t.Errorf("path, query and header errors should be reported, got %+v", err.Errors)
+	}
+
+	result, _, _ = handleSchema(v, http.MethodGet, "/api/v1/pets/mine", "")
+	if result != "" {
+		t.Errorf("concrete path should be matched before the templated one")
+	}
+
+	_, w, _ = handleSchema(v, http.MethodGet, "/api/v1/users", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown path should be rejected by 404, got %d", w.Code)
+	}
+	_, w, _ = handleSchema(v, http.MethodDelete, "/api/v1/pets", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unknown method should be rejected by 405, got %d", w.Code)
+	}
+
+	s := v.Status().(*Status).Schema
+	if s.Requests != 7 || s.Invalid != 5 {
+		t.Errorf("there should be 7 requests and 5 invalid ones, got %+v", s)
+	}
+	if s.Failures["body"] != 2 || s.Failures["route"] != 2 || s.Failures["path"] != 1 {
+		t.Errorf("unexpected failures %+v", s.Failures)
+	}
+	if s.Operations["createPet"] != 2 || s.Operations["GET /pets/{id}"] != 1 {
+		t.Errorf("unexpected operations %+v", s.Operations)
+	}
+
+	v = createValidator(yamlSpec+"  allowUnknownRoutes: true\n", v)
+	result, _, _ = handleSchema(v, http.MethodGet, "/api/v1/users", "")
+	if result != "" {
+		t.Errorf("unknown path should pass if allowUnknownRoutes is true")
+	}
+	v.Close()
+}
+
+func TestSchemaJSONSchema(t *testing.T) {
+	const yamlSpec = `
+kind: Validator
+name: validator
+schema:
+  maxBodySize: 64
+  jsonSchema:
+    content: |
+      type: object
+      required: [items]
+      properties:
+        items:
+          type: array
+          items:
+            type: string
+`
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	result, _, _ := handleSchema(v, http.MethodPost, "/", `{"items":["a"]}`)
+	if result != "" {
+		t.Errorf("valid body should pass")
+	}
+
+	result, w, err := handleSchema(v, http.MethodPost, "/", `{"items":["a", 1]}`)
+	if result != resultInvalid || w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid body should be rejected by 400, got %d", w.Code)
+	}
+	if len(err.Errors) != 1 || err.Errors[0].Field != "/items/1" {
+		t.Errorf("field /items/1 should be reported, got %+v", err.Errors)
+	}
+
+	_, _, err = handleSchema(v, http.MethodPost, "/", `{"items":`)
+	if err == nil || err.Errors[0].Reason != "body is not valid JSON" {
+		t.Errorf("malformed JSON should be rejected, got %+v", err)
+	}
+
+	_, w, _ = handleSchema(v, http.MethodPost, "/", `{"items":["`+strings.Repeat("a", 64)+`"]}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body should be rejected by 413, got %d", w.Code)
+	}
+}
+
+func TestSchemaSpec(t *testing.T) {
+	spec := SchemaValidatorSpec{}
+	if spec.Validate() == nil {
+		t.Errorf("spec without openAPI and jsonSchema should be invalid")
+	}
+
+	source := SchemaSourceSpec{}
+	if source.Validate() == nil {
+		t.Errorf("source without file and content should be invalid")
+	}
+
+	spec.JSONSchema = &SchemaSourceSpec{Content: `{"type": "object"}`}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("spec should be valid, got %v", err)
+	}
+	spec.JSONSchema.Content = `{"type": "unknown"}`
+	if spec.Validate() == nil {
+		t.Errorf("invalid JSON Schema should be reported")
+	}
+
+	spec.JSONSchema = nil
+	spec.OpenAPI = &SchemaSourceSpec{Content: "openapi: 3.0.0\npaths: {}\n"}
+	if spec.Validate() == nil {
+		t.Errorf("OpenAPI document without info should be invalid")
+	}
+	spec.OpenAPI = &SchemaSourceSpec{File: "/not/exist.yaml"}
+	if spec.Validate() == nil {
+		t.Errorf("missing OpenAPI document should be reported")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+// The built-in rule sets are inspired by the OWASP ModSecurity Core Rule
+// Set at paranoia level 1, the IDs follow the ranges of CRS, but the rules
+// are simplified and not identical.
+
+var (
+	argsVariables = []string{"ARGS", "ARGS_NAMES", "REQUEST_COOKIES", "REQUEST_COOKIES_NAMES"}
+
+	decodeTransforms = []string{"urlDecodeUni", "htmlEntityDecode", "removeNulls"}
+
+	builtinRuleSets = map[string][]*RuleSpec{
+		"scanner": {
+			{
+				ID:        913100,
+				Msg:       "Found User-Agent associated with security scanner",
+				Variables: []string{"REQUEST_HEADERS:User-Agent"},
+				Operator:  "@pm nikto sqlmap nmap masscan dirbuster wpscan acunetix nessus openvas w3af zgrab nuclei",
+				Severity:  "critical",
+			},
+		},
+		"lfi": {
+			{
+				ID:         930100,
+				Msg:        "Path Traversal Attack (/../)",
+				Variables:  append([]string{"REQUEST_URI"}, argsVariables...),
+				Operator:   `@rx (?:^|[\\/])\.\.(?:[\\/]|$)`,
+				Transforms: []string{"urlDecodeUni", "urlDecodeUni", "removeNulls"},
+				Severity:   "critical",
+			},
+			{
+				ID:         930120,
+				Msg:        "OS File Access Attempt",
+				Variables:  append([]string{"REQUEST_FILENAME"}, argsVariables...),
+				Operator:   `@rx (?i)(?:/etc/(?:passwd|shadow|group|hosts)\b|/proc/self/|\b(?:boot|win)\.ini\b|/\.(?:git|svn|htaccess|htpasswd|env)\b)`,
+				Transforms: append([]string{"normalizePath"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+		},
+		"rce": {
+			{
+				ID:         932100,
+				Msg:        "Remote Command Execution: Unix Command Injection",
+				Variables:  argsVariables,
+				Operator:   "@rx (?i)(?:[;|`]|&&|\\$\\()\\s*(?:cat|ls|id|whoami|uname|wget|curl|nc|ncat|bash|sh|zsh|python[23]?|perl|ruby|php|ping|rm|chmod|nslookup)\\b",
+				Transforms: append([]string{"compressWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         932110,
+				Msg:        "Remote Command Execution: Windows Command Injection",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)(?:[;|&]|\bcmd(?:\.exe)?\s*/[ck])\s*(?:powershell|cmd|net\s+user|ipconfig|certutil|bitsadmin|reg\s+(?:add|query))\b`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+			{
+				ID:         944150,
+				Msg:        "Potential Remote Command Execution: Log4j / Log4shell",
+				Variables:  append([]string{"REQUEST_URI", "REQUEST_HEADERS"}, argsVariables...),
+				Operator:   `@rx (?i)\$\{[^}]*?(?:jndi|lower|upper|env|sys|java|date)\s*:`,
+				Transforms: append([]string{"urlDecodeUni"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+		},
+		"xss": {
+			{
+				ID:         941110,
+				Msg:        "XSS Filter - Category 1: Script Tag Vector",
+				Variables:  append([]string{"REQUEST_HEADERS:Referer"}, argsVariables...),
+				Operator:   `@rx (?i)<script[^>]*>[\s\S]*?`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+			{
+				ID:         941120,
+				Msg:        "XSS Filter - Category 2: Event Handler Vector",
+				Variables:  append([]string{"REQUEST_HEADERS:Referer"}, argsVariables...),
+				Operator:   `@rx (?i)[\s"'/;]on(?:abort|blur|change|click|dblclick|error|focus|input|key(?:down|press|up)|load|mouse(?:down|enter|leave|move|out|over|up)|pageshow|resize|scroll|select|submit|toggle|unload|wheel)\s*=`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+			{
+				ID:         941170,
+				Msg:        "NoScript XSS InjectionChecker: Attribute Injection",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)(?:\b(?:java|vb)script\s*:|\bdata\s*:\s*text/html)`,
+				Transforms: append([]string{"removeWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         941160,
+				Msg:        "NoScript XSS InjectionChecker: HTML Injection",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)<(?:iframe|object|embed|applet|frameset|base|form|svg|math)\b`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+			{
+				ID:         941180,
+				Msg:        "Node-Validator Deny List Keywords",
+				Variables:  argsVariables,
+				Operator:   "@pm document.cookie document.write document.domain .parentnode .innerhtml window.location -moz-binding <!-- -->",
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+		},
+		"sqli": {
+			{
+				ID:         942100,
+				Msg:        "SQL Injection Attack: UNION Based",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)\bunion\b[\s(]+(?:all\s+|distinct\s+)?select\b`,
+				Transforms: append([]string{"replaceComments", "compressWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         942130,
+				Msg:        "SQL Injection Attack: SQL Tautology Detected",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)['"\d)]\s*\b(?:or|and|xor)\b\s*['"(]?\s*(?:\d+|'[^']*|"[^"]*|true|false)\s*['")]?\s*(?:=|<>|!=|<=?|>=?|\blike\b|\bis\b)`,
+				Transforms: append([]string{"replaceComments", "compressWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         942140,
+				Msg:        "SQL Injection Attack: Common DB Names Detected",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)\b(?:information_schema|pg_catalog|mysql\.(?:user|db)|sys\.(?:objects|tables|columns)|sysobjects|syscolumns|sqlite_master)\b`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+			{
+				ID:         942160,
+				Msg:        "Detects blind sqli tests using sleep() or benchmark()",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)(?:\b(?:sleep|benchmark|pg_sleep)\s*\(|\bwaitfor\s+delay\b)`,
+				Transforms: append([]string{"replaceComments", "compressWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         942190,
+				Msg:        "Detects MSSQL code execution and information gathering attempts",
+				Variables:  argsVariables,
+				Operator:   `@rx (?i)(?:\bexec(?:ute)?\s+(?:xp_|sp_)\w+|;\s*(?:drop|truncate|alter|create|insert|update|delete)\s+\w+)`,
+				Transforms: append([]string{"replaceComments", "compressWhitespace"}, decodeTransforms...),
+				Severity:   "critical",
+			},
+			{
+				ID:         942440,
+				Msg:        "SQL Comment Sequence Detected",
+				Variables:  argsVariables,
+				Operator:   `@rx ['"` + "`" + `)]\s*(?:--|#|/\*)`,
+				Transforms: decodeTransforms,
+				Severity:   "critical",
+			},
+		},
+	}
+)
@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type (
+	// RuleSpec describes a rule, the semantics is a subset of ModSecurity
+	// SecRule: the values of the variables are transformed and checked by
+	// the operator, the rule matches if any value matches.
+	RuleSpec struct {
+		ID  int    `yaml:"id" jsonschema:"required,minimum=1"`
+		Msg string `yaml:"msg" jsonschema:"omitempty"`
+		// Variables are like ARGS, REQUEST_HEADERS:User-Agent.
+		Variables []string `yaml:"variables" jsonschema:"required,minItems=1"`
+		// Operator is like "@rx ^\d+$", "!@pm foo bar", it is a regular
+		// expression if there is no operator name.
+		Operator   string   `yaml:"operator" jsonschema:"required"`
+		Transforms []string `yaml:"transforms" jsonschema:"omitempty"`
+		// Severity decides the anomaly score: critical 5, error 4,
+		// warning 3 and notice 2.
+		Severity string `yaml:"severity,omitempty" jsonschema:"omitempty,enum=critical,enum=error,enum=warning,enum=notice"`
+		// Action is score (default) or deny, deny blocks the request at
+		// once instead of adding the anomaly score.
+		Action string `yaml:"action,omitempty" jsonschema:"omitempty,enum=score,enum=deny"`
+	}
+
+	rule struct {
+		spec       *RuleSpec
+		variables  []*variable
+		transforms []transform
+		op         operator
+		negate     bool
+		score      int
+	}
+
+	variable struct {
+		collection string
+		key        string
+	}
+
+	transform func(string) string
+
+	operator func(string) bool
+)
+
+var (
+	severityScores = map[string]int{
+		"":         5,
+		"critical": 5,
+		"error":    4,
+		"warning":  3,
+		"notice":   2,
+	}
+
+	// collections are the supported variables, the ones with true
+	// support a key after the colon.
+	collections = map[string]bool{
+		"ARGS":                  true,
+		"ARGS_GET":              true,
+		"ARGS_POST":             true,
+		"ARGS_NAMES":            false,
+		"QUERY_STRING":          false,
+		"REQUEST_URI":           false,
+		"REQUEST_FILENAME":      false,
+		"REQUEST_METHOD":        false,
+		"REQUEST_PROTOCOL":      false,
+		"REQUEST_HEADERS":       true,
+		"REQUEST_HEADERS_NAMES": false,
+		"REQUEST_COOKIES":       true,
+		"REQUEST_COOKIES_NAMES": false,
+		"REQUEST_BODY":          false,
+		"REMOTE_ADDR":           false,
+	}
+
+	whitespaceRE = regexp.MustCompile(`\s+`)
+	commentRE    = regexp.MustCompile(`/\*[\s\S]*?(?:\*/|$)`)
+
+	transforms = map[string]transform{
+		"none":      func(s string) string { return s },
+		"lowercase": strings.ToLower,
+		"urlDecode": urlDecode,
+		// NOTE: The %uXXXX encoding is not supported by urlDecodeUni.
+		"urlDecodeUni":       urlDecode,
+		"htmlEntityDecode":   html.UnescapeString,
+		"compressWhitespace": func(s string) string { return whitespaceRE.ReplaceAllString(s, " ") },
+		"removeWhitespace":   func(s string) string { return whitespaceRE.ReplaceAllString(s, "") },
+		"removeNulls":        func(s string) string { return strings.ReplaceAll(s, "\x00", "") },
+		"replaceComments":    func(s string) string { return commentRE.ReplaceAllString(s, " ") },
+		"normalizePath":      normalizePath,
+		"base64Decode":       base64Decode,
+		"trim":               strings.TrimSpace,
+	}
+)
+
+func urlDecode(s string) string {
+	if v, err := url.QueryUnescape(s); err == nil {
+		return v
+	}
+	return s
+}
+
+func base64Decode(s string) string {
+	if v, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return string(v)
+	}
+	return s
+}
+
+// normalizePath removes the self references and back references, but
+// back references beyond the root are kept, so they could be detected.
+func normalizePath(s string) string {
+	s = strings.ReplaceAll(s, "\\", "/")
+	var parts []string
+	for _, p := range strings.Split(s, "/") {
+		switch p {
+		case ".":
+		case "..":
+			if len(parts) > 0 && parts[len(parts)-1] != ".." && parts[len(parts)-1] != "" {
+				parts = parts[:len(parts)-1]
+			} else {
+				parts = append(parts, p)
+			}
+		default:
+			if p == "" && len(parts) > 0 && parts[len(parts)-1] == "" {
+				continue
+			}
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func parseVariable(s string) (*variable, error) {
+	name, key := s, ""
+	if idx := strings.IndexByte(s, ':'); idx >= 0 {
+		name, key = s[:idx], s[idx+1:]
+	}
+
+	keyed, ok := collections[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %s", name)
+	}
+	if key != "" && !keyed {
+		return nil, fmt.Errorf("variable %s doesn't support key", name)
+	}
+	if name == "REQUEST_HEADERS" {
+		key = strings.ToLower(key)
+	}
+	return &variable{collection: name, key: key}, nil
+}
+
+func parseOperator(s string) (operator, bool, error) {
+	negate := false
+	if strings.HasPrefix(s, "!") {
+		negate, s = true, s[1:]
+	}
+
+	if !strings.HasPrefix(s, "@") {
+		s = "@rx " + s
+	}
+	name, arg := s, ""
+	if idx := strings.IndexByte(s, ' '); idx >= 0 {
+		name, arg = s[:idx], s[idx+1:]
+	}
+
+	switch name {
+	case "@rx":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, false, err
+		}
+		return re.MatchString, negate, nil
+	case "@pm":
+		// NOTE: @pm is case insensitive.
+		phrases := strings.Fields(strings.ToLower(arg))
+		if len(phrases) == 0 {
+			return nil, false, fmt.Errorf("@pm requires phrases")
+		}
+		return func(v string) bool {
+			v = strings.ToLower(v)
+			for _, p := range phrases {
+				if strings.Contains(v, p) {
+					return true
+				}
+			}
+			return false
+		}, negate, nil
+	case "@contains":
+		return func(v string) bool { return strings.Contains(v, arg) }, negate, nil
+	case "@streq":
+		return func(v string) bool { return v == arg }, negate, nil
+	case "@beginsWith":
+		return func(v string) bool { return strings.HasPrefix(v, arg) }, negate, nil
+	case "@endsWith":
+		return func(v string) bool { return strings.HasSuffix(v, arg) }, negate, nil
+	case "@within":
+		return func(v string) bool { return strings.Contains(arg, v) }, negate, nil
+	case "@ipMatch":
+		var nets []*net.IPNet
+		for _, s := range strings.Split(arg, ",") {
+			s = strings.TrimSpace(s)
+			if !strings.Contains(s, "/") {
+				if strings.Contains(s, ":") {
+					s += "/128"
+				} else {
+					s += "/32"
+				}
+			}
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, false, err
+			}
+			nets = append(nets, n)
+		}
+		return func(v string) bool {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return false
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}, negate, nil
+	}
+
+	return nil, false, fmt.Errorf("unknown operator %s", name)
+}
+
+func compileRule(spec *RuleSpec) (*rule, error) {
+	r := &rule{spec: spec, score: severityScores[spec.Severity]}
+
+	for _, s := range spec.Variables {
+		v, err := parseVariable(s)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", spec.ID, err)
+		}
+		r.variables = append(r.variables, v)
+	}
+
+	for _, s := range spec.Transforms {
+		t, ok := transforms[s]
+		if !ok {
+			return nil, fmt.Errorf("rule %d: unknown transform %s", spec.ID, s)
+		}
+		r.transforms = append(r.transforms, t)
+	}
+
+	op, negate, err := parseOperator(spec.Operator)
+	if err != nil {
+		return nil, fmt.Errorf("rule %d: %v", spec.ID, err)
+	}
+	r.op, r.negate = op, negate
+
+	return r, nil
+}
+
+func (r *rule) needBody() bool {
+	for _, v := range r.variables {
+		switch v.collection {
+		case "ARGS", "ARGS_POST", "ARGS_NAMES", "REQUEST_BODY":
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the first matched value, a negated rule matches a value
+// which doesn't match the operator.
+func (r *rule) match(tx *transaction) (string, bool) {
+	for _, v := range r.variables {
+		for _, value := range tx.values(v) {
+			for _, t := range r.transforms {
+				value = t(value)
+			}
+			if r.op(value) != r.negate {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
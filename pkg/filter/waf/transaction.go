@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// transaction collects the values of variables of a request lazily.
+	transaction struct {
+		req context.HTTPRequest
+
+		argsGet  url.Values
+		argsPost url.Values
+		body     []byte
+		cookies  url.Values
+	}
+)
+
+func newTransaction(req context.HTTPRequest, needBody bool, maxBodySize int64) *transaction {
+	tx := &transaction{req: req}
+	tx.argsGet = parseArgs(req.Query())
+	if needBody {
+		tx.readBody(maxBodySize)
+	}
+	return tx
+}
+
+// readBody reads at most maxBodySize bytes of the body, so only the
+// beginning of large bodies is inspected.
+func (tx *transaction) readBody(maxBodySize int64) {
+	tx.argsPost = url.Values{}
+
+	// NOTE: The request body must be set back for the following filters.
+	body := tx.req.Body()
+	buff, err := io.ReadAll(io.LimitReader(body, maxBodySize))
+	tx.req.SetBody(io.MultiReader(bytes.NewReader(buff), body))
+	if err != nil {
+		return
+	}
+	tx.body = buff
+
+	mediaType, _, _ := mime.ParseMediaType(tx.req.Header().Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		tx.argsPost = parseArgs(string(buff))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if json.Unmarshal(buff, &v) == nil {
+			flattenJSON("json", v, tx.argsPost)
+		}
+	}
+}
+
+// parseArgs parses the query or form, unlike url.ParseQuery, it doesn't
+// skip the pairs containing semicolons or invalid escapes, which would
+// let attacks bypass the rules.
+func parseArgs(s string) url.Values {
+	args := url.Values{}
+	for _, pair := range strings.Split(s, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if idx := strings.IndexByte(pair, '='); idx >= 0 {
+			key, value = pair[:idx], pair[idx+1:]
+		}
+		args.Add(urlDecode(key), urlDecode(value))
+	}
+	return args
+}
+
+// flattenJSON flattens the JSON value into args, the names of the args
+// are like json.a.b.
+func flattenJSON(prefix string, v interface{}, args url.Values) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			flattenJSON(prefix+"."+k, child, args)
+		}
+	case []interface{}:
+		for _, child := range v {
+			flattenJSON(prefix, child, args)
+		}
+	case string:
+		args.Add(prefix, v)
+	case nil:
+	default:
+		buff, _ := json.Marshal(v)
+		args.Add(prefix, string(buff))
+	}
+}
+
+func (tx *transaction) getCookies() url.Values {
+	if tx.cookies == nil {
+		tx.cookies = url.Values{}
+		for _, c := range tx.req.Cookies() {
+			tx.cookies.Add(c.Name, c.Value)
+		}
+	}
+	return tx.cookies
+}
+
+func collectValues(values url.Values, key string) []string {
+	if key != "" {
+		return values[key]
+	}
+	var result []string
+	for _, vs := range values {
+		result = append(result, vs...)
+	}
+	return result
+}
+
+func collectNames(values url.Values) []string {
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	return names
+}
+
+func (tx *transaction) values(v *variable) []string {
+	switch v.collection {
+	case "ARGS":
+		return append(collectValues(tx.argsGet, v.key), collectValues(tx.argsPost, v.key)...)
+	case "ARGS_GET":
+		return collectValues(tx.argsGet, v.key)
+	case "ARGS_POST":
+		return collectValues(tx.argsPost, v.key)
+	case "ARGS_NAMES":
+		return append(collectNames(tx.argsGet), collectNames(tx.argsPost)...)
+	case "QUERY_STRING":
+		return []string{tx.req.Query()}
+	case "REQUEST_URI":
+		return []string{tx.req.Std().URL.RequestURI()}
+	case "REQUEST_FILENAME":
+		return []string{tx.req.Path()}
+	case "REQUEST_METHOD":
+		return []string{tx.req.Method()}
+	case "REQUEST_PROTOCOL":
+		return []string{tx.req.Proto()}
+	case "REQUEST_HEADERS":
+		if v.key != "" {
+			return tx.req.Header().GetAll(v.key)
+		}
+		var result []string
+		tx.req.Header().VisitAll(func(key, value string) {
+			result = append(result, value)
+		})
+		return result
+	case "REQUEST_HEADERS_NAMES":
+		var result []string
+		tx.req.Header().VisitAll(func(key, value string) {
+			result = append(result, key)
+		})
+		return result
+	case "REQUEST_COOKIES":
+		return collectValues(tx.getCookies(), v.key)
+	case "REQUEST_COOKIES_NAMES":
+		return collectNames(tx.getCookies())
+	case "REQUEST_BODY":
+		if len(tx.body) == 0 {
+			return nil
+		}
+		return []string{string(tx.body)}
+	case "REMOTE_ADDR":
+		return []string{tx.req.RealIP()}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of WAF.
+	Kind = "WAF"
+
+	resultBlocked = "blocked"
+
+	modeBlock  = "block"
+	modeDetect = "detect"
+
+	actionDeny = "deny"
+
+	defaultAnomalyThreshold = 5
+	defaultMaxBodySize      = 64 * 1024
+)
+
+var results = []string{resultBlocked}
+
+func init() {
+	httppipeline.Register(&WAF{})
+}
+
+type (
+	// WAF is a web application firewall checking requests by rules
+	// compatible with a subset of ModSecurity and OWASP CRS.
+	WAF struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		ruleSets     map[string][]*rule
+		defaultRules []*rule
+		routes       []*route
+
+		requests uint64
+		blocked  uint64
+		detected uint64
+
+		mutex    sync.Mutex
+		ruleHits map[int]uint64
+	}
+
+	// Spec describes the WAF.
+	Spec struct {
+		// Mode is block (default) or detect, the requests are only
+		// logged in detect mode.
+		Mode string `yaml:"mode,omitempty" jsonschema:"omitempty,enum=block,enum=detect"`
+		// AnomalyThreshold is the total anomaly score to block a
+		// request, default is 5, which blocks a request at one critical
+		// rule like CRS.
+		AnomalyThreshold int `yaml:"anomalyThreshold" jsonschema:"omitempty,minimum=0"`
+		// MaxBodySize is the max bytes of the body to inspect.
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"omitempty,minimum=0"`
+
+		RuleSets        []*RuleSetSpec `yaml:"ruleSets" jsonschema:"required,minItems=1"`
+		DefaultRuleSets []string       `yaml:"defaultRuleSets" jsonschema:"omitempty"`
+		Routes          []*RouteSpec   `yaml:"routes" jsonschema:"omitempty"`
+	}
+
+	// RuleSetSpec describes a rule set.
+	RuleSetSpec struct {
+		Name          string      `yaml:"name" jsonschema:"required"`
+		Builtin       []string    `yaml:"builtin" jsonschema:"omitempty,uniqueItems=true"`
+		Rules         []*RuleSpec `yaml:"rules" jsonschema:"omitempty"`
+		DisabledRules []int       `yaml:"disabledRules" jsonschema:"omitempty"`
+	}
+
+	// RouteSpec selects the rule sets of the requests, the first
+	// matched route is used.
+	RouteSpec struct {
+		Path       string   `yaml:"path" jsonschema:"omitempty"`
+		PathPrefix string   `yaml:"pathPrefix" jsonschema:"omitempty"`
+		Methods    []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		RuleSets   []string `yaml:"ruleSets" jsonschema:"omitempty"`
+	}
+
+	route struct {
+		spec  *RouteSpec
+		rules []*rule
+	}
+
+	// Status is the status of WAF.
+	Status struct {
+		Requests uint64         `yaml:"requests"`
+		Blocked  uint64         `yaml:"blocked"`
+		Detected uint64         `yaml:"detected"`
+		RuleHits map[int]uint64 `yaml:"ruleHits"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec Spec) Validate() error {
+	_, _, _, err := spec.compile()
+	return err
+}
+
+// compile compiles the rule sets, and returns the rule sets, the default
+// rules and the routes.
+func (spec *Spec) compile() (map[string][]*rule, []*rule, []*route, error) {
+	ruleSets := map[string][]*rule{}
+	for _, rs := range spec.RuleSets {
+		if _, ok := ruleSets[rs.Name]; ok {
+			return nil, nil, nil, fmt.Errorf("rule set %s is duplicated", rs.Name)
+		}
+		rules, err := rs.compile()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("rule set %s: %v", rs.Name, err)
+		}
+		ruleSets[rs.Name] = rules
+	}
+
+	selectRules := func(names []string) ([]*rule, error) {
+		var rules []*rule
+		ids := map[int]bool{}
+		for _, name := range names {
+			rs, ok := ruleSets[name]
+			if !ok {
+				return nil, fmt.Errorf("rule set %s not found", name)
+			}
+			for _, r := range rs {
+				// NOTE: Rules shared by the rule sets are checked once.
+				if !ids[r.spec.ID] {
+					ids[r.spec.ID] = true
+					rules = append(rules, r)
+				}
+			}
+		}
+		return rules, nil
+	}
+
+	defaultRuleSets := spec.DefaultRuleSets
+	if len(defaultRuleSets) == 0 {
+		for _, rs := range spec.RuleSets {
+			defaultRuleSets = append(defaultRuleSets, rs.Name)
+		}
+	}
+	defaultRules, err := selectRules(defaultRuleSets)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("default rule sets: %v", err)
+	}
+
+	var routes []*route
+	for i, rs := range spec.Routes {
+		if rs.Path != "" && rs.PathPrefix != "" {
+			return nil, nil, nil, fmt.Errorf("route %d: both path and pathPrefix are specified", i)
+		}
+		rules, err := selectRules(rs.RuleSets)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("route %d: %v", i, err)
+		}
+		routes = append(routes, &route{spec: rs, rules: rules})
+	}
+
+	return ruleSets, defaultRules, routes, nil
+}
+
+func (rs *RuleSetSpec) compile() ([]*rule, error) {
+	var specs []*RuleSpec
+	for _, name := range rs.Builtin {
+		builtin, ok := builtinRuleSets[name]
+		if !ok {
+			return nil, fmt.Errorf("builtin rule set %s not found", name)
+		}
+		specs = append(specs, builtin...)
+	}
+	specs = append(specs, rs.Rules...)
+
+	disabled := map[int]bool{}
+	for _, id := range rs.DisabledRules {
+		disabled[id] = true
+	}
+
+	var rules []*rule
+	ids := map[int]bool{}
+	for _, spec := range specs {
+		if ids[spec.ID] {
+			return nil, fmt.Errorf("rule %d is duplicated", spec.ID)
+		}
+		ids[spec.ID] = true
+		if disabled[spec.ID] {
+			continue
+		}
+		r, err := compileRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func (r *route) match(req context.HTTPRequest) bool {
+	if r.spec.Path != "" && r.spec.Path != req.Path() {
+		return false
+	}
+	if r.spec.PathPrefix != "" && !strings.HasPrefix(req.Path(), r.spec.PathPrefix) {
+		return false
+	}
+	if len(r.spec.Methods) > 0 && !stringtool.StrInSlice(req.Method(), r.spec.Methods) {
+		return false
+	}
+	return true
+}
+
+// Kind returns the kind of WAF.
+func (w *WAF) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of WAF.
+func (w *WAF) DefaultSpec() interface{} {
+	return &Spec{
+		Mode:             modeBlock,
+		AnomalyThreshold: defaultAnomalyThreshold,
+		MaxBodySize:      defaultMaxBodySize,
+	}
+}
+
+// Description returns the description of WAF.
+func (w *WAF) Description() string {
+	return "WAF checks requests by CRS-compatible rules and blocks attacks."
+}
+
+// Results returns the results of WAF.
+func (w *WAF) Results() []string {
+	return results
+}
+
+// Init initializes WAF.
+func (w *WAF) Init(filterSpec *httppipeline.FilterSpec) {
+	w.filterSpec, w.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	w.reload()
+}
+
+// Inherit inherits previous generation of WAF.
+func (w *WAF) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	w.Init(filterSpec)
+}
+
+func (w *WAF) reload() {
+	if w.spec.Mode == "" {
+		w.spec.Mode = modeBlock
+	}
+	if w.spec.AnomalyThreshold == 0 {
+		w.spec.AnomalyThreshold = defaultAnomalyThreshold
+	}
+	if w.spec.MaxBodySize == 0 {
+		w.spec.MaxBodySize = defaultMaxBodySize
+	}
+
+	var err error
+	w.ruleSets, w.defaultRules, w.routes, err = w.spec.compile()
+	if err != nil {
+		// NOTE: The spec has been validated.
+		logger.Errorf("BUG: compile rules of %s failed: %v", w.filterSpec.Name(), err)
+	}
+	w.ruleHits = map[int]uint64{}
+}
+
+// Handle checks the request by the rules.
+func (w *WAF) Handle(ctx context.HTTPContext) string {
+	result := w.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (w *WAF) handle(ctx context.HTTPContext) string {
+	atomic.AddUint64(&w.requests, 1)
+
+	req := ctx.Request()
+	rules := w.defaultRules
+	for _, r := range w.routes {
+		if r.match(req) {
+			rules = r.rules
+			break
+		}
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+
+	needBody := false
+	for _, r := range rules {
+		if r.needBody() {
+			needBody = true
+			break
+		}
+	}
+	tx := newTransaction(req, needBody, w.spec.MaxBodySize)
+
+	score, deny := 0, false
+	var hits []string
+	for _, r := range rules {
+		value, ok := r.match(tx)
+		if !ok {
+			continue
+		}
+		w.hit(r.spec.ID)
+		hits = append(hits, fmt.Sprintf("%d (%s) matched %q", r.spec.ID, r.spec.Msg, value))
+		score += r.score
+		if r.spec.Action == actionDeny {
+			deny = true
+			break
+		}
+	}
+
+	if !deny && score < w.spec.AnomalyThreshold {
+		return ""
+	}
+
+	atomic.AddUint64(&w.detected, 1)
+	if w.spec.Mode == modeDetect {
+		logger.Warnf("waf %s detected attack from %s to %s %s, anomaly score %d: %s",
+			w.filterSpec.Name(), req.RealIP(), req.Method(), req.Path(), score, strings.Join(hits, ", "))
+		return ""
+	}
+
+	atomic.AddUint64(&w.blocked, 1)
+	ctx.Response().SetStatusCode(http.StatusForbidden)
+	ctx.AddTag(fmt.Sprintf("waf: blocked, anomaly score %d: %s", score, strings.Join(hits, ", ")))
+	return resultBlocked
+}
+
+func (w *WAF) hit(id int) {
+	w.mutex.Lock()
+	w.ruleHits[id]++
+	w.mutex.Unlock()
+}
+
+// Status returns status.
+func (w *WAF) Status() interface{} {
+	s := &Status{
+		Requests: atomic.LoadUint64(&w.requests),
+		Blocked:  atomic.LoadUint64(&w.blocked),
+		Detected: atomic.LoadUint64(&w.detected),
+		RuleHits: map[int]uint64{},
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for id, n := range w.ruleHits {
+		s.RuleHits[id] = n
+	}
+
+	return s
+}
+
+// Close closes WAF.
+func (w *WAF) Close() {}
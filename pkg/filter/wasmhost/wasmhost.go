@@ -22,7 +22,9 @@ package wasmhost
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -81,6 +83,8 @@ type (
 		spec       *Spec
 
 		code       []byte
+		codeDigest atomic.Value
+		loadError  atomic.Value
 		dataPrefix string
 		data       atomic.Value
 		vmPool     atomic.Value
@@ -93,6 +97,8 @@ type (
 	// Status is the status of WasmHost
 	Status struct {
 		Health         string `yaml:"health"`
+		CodeDigest     string `yaml:"codeDigest"`
+		LoadError      string `yaml:"loadError"`
 		NumOfRequest   int64  `yaml:"numOfRequest"`
 		NumOfWasmError int64  `yaml:"numOfWasmError"`
 	}
@@ -167,7 +173,20 @@ func (wh *WasmHost) readWasmCode() ([]byte, error) {
 	return base64.StdEncoding.DecodeString(wh.spec.Code)
 }
 
+// loadWasmCode loads the wasm code and replaces the VM pool if the code
+// was modified, the error of the latest load is kept for Status, so that
+// users could check whether a hot update succeeded.
 func (wh *WasmHost) loadWasmCode() error {
+	e := wh.doLoadWasmCode()
+	if e != nil {
+		wh.loadError.Store(e.Error())
+	} else {
+		wh.loadError.Store("")
+	}
+	return e
+}
+
+func (wh *WasmHost) doLoadWasmCode() error {
 	code, e := wh.readWasmCode()
 	if e != nil {
 		logger.Errorf("failed to load wasm code: %v", e)
@@ -185,6 +204,8 @@ func (wh *WasmHost) loadWasmCode() error {
 	}
 	wh.code = code
 
+	digest := sha256.Sum256(code)
+	wh.codeDigest.Store(hex.EncodeToString(digest[:]))
 	wh.vmPool.Store(p)
 	return nil
 }
@@ -376,6 +397,12 @@ func (wh *WasmHost) Status() interface{} {
 	} else {
 		s.Health = "ready"
 	}
+	if d := wh.codeDigest.Load(); d != nil {
+		s.CodeDigest = d.(string)
+	}
+	if e := wh.loadError.Load(); e != nil {
+		s.LoadError = e.(string)
+	}
 
 	s.NumOfRequest = atomic.LoadInt64(&wh.numOfRequest)
 	s.NumOfWasmError = atomic.LoadInt64(&wh.numOfWasmError)
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package amqpinput
+
+import (
+	stdcontext "context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/amqptool"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// Category is the category of AMQPInput.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of AMQPInput.
+	Kind = "AMQPInput"
+
+	defaultPrefetch = 10
+	retryInterval   = time.Second
+)
+
+func init() {
+	supervisor.Register(&AMQPInput{})
+}
+
+type (
+	// AMQPInput consumes messages from an AMQP 0.9.1 queue and feeds
+	// them into an HTTPPipeline.
+	AMQPInput struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		handler *deliveryHandler
+		cancel  stdcontext.CancelFunc
+		done    chan struct{}
+	}
+
+	// Spec describes the AMQPInput.
+	Spec struct {
+		URL      string              `yaml:"url" jsonschema:"required"`
+		Queue    *amqptool.QueueSpec `yaml:"queue" jsonschema:"required"`
+		Pipeline string              `yaml:"pipeline" jsonschema:"required"`
+
+		// Prefetch is the number of unacknowledged messages delivered by
+		// the broker, they are handled concurrently.
+		Prefetch    int    `yaml:"prefetch,omitempty" jsonschema:"omitempty,minimum=1"`
+		Requeue     bool   `yaml:"requeue" jsonschema:"omitempty"`
+		ConsumerTag string `yaml:"consumerTag" jsonschema:"omitempty"`
+	}
+)
+
+// Category returns the category of AMQPInput.
+func (ai *AMQPInput) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of AMQPInput.
+func (ai *AMQPInput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AMQPInput.
+func (ai *AMQPInput) DefaultSpec() interface{} {
+	return &Spec{
+		Prefetch: defaultPrefetch,
+	}
+}
+
+// Init initializes AMQPInput.
+func (ai *AMQPInput) Init(superSpec *supervisor.Spec) {
+	ai.superSpec, ai.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+
+	ai.reload(pipelinetool.NewGetter(superSpec.Super()))
+}
+
+// Inherit inherits previous generation of AMQPInput.
+func (ai *AMQPInput) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	ai.Init(superSpec)
+}
+
+func (ai *AMQPInput) reload(getPipeline pipelinetool.Getter) {
+	ai.handler = newDeliveryHandler(ai.spec.Pipeline, ai.spec.Requeue, getPipeline)
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	ai.cancel, ai.done = cancel, make(chan struct{})
+	go ai.run(ctx)
+}
+
+// run consumes the queue until the context is canceled, it reconnects
+// the broker if the connection is closed.
+func (ai *AMQPInput) run(ctx stdcontext.Context) {
+	defer close(ai.done)
+
+	for {
+		err := ai.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Errorf("%s: consume queue %s failed: %v", ai.superSpec.Name(), ai.spec.Queue.Name, err)
+		ai.handler.setLastError(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (ai *AMQPInput) consume(ctx stdcontext.Context) error {
+	conn, err := amqp.Dial(ai.spec.URL)
+	if err != nil {
+		return fmt.Errorf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open channel failed: %v", err)
+	}
+	if err = ai.spec.Queue.Declare(ch); err != nil {
+		return err
+	}
+
+	prefetch := ai.spec.Prefetch
+	if prefetch <= 0 {
+		prefetch = defaultPrefetch
+	}
+	if err = ch.Qos(prefetch, 0, false); err != nil {
+		return fmt.Errorf("set prefetch failed: %v", err)
+	}
+
+	tag := ai.spec.ConsumerTag
+	if tag == "" {
+		tag = ai.superSpec.Name()
+	}
+	deliveries, err := ch.Consume(ai.spec.Queue.Name, tag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume failed: %v", err)
+	}
+
+	ai.handler.setConnected(true)
+	defer ai.handler.setConnected(false)
+
+	var wg sync.WaitGroup
+	wg.Add(prefetch)
+	for i := 0; i < prefetch; i++ {
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				ai.handler.handleDelivery(&d)
+			}
+		}()
+	}
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	select {
+	case <-ctx.Done():
+		// NOTE: Cancel the consumer to stop deliveries, and wait for the
+		// delivered messages to be handled and acknowledged.
+		if err := ch.Cancel(tag, false); err != nil {
+			logger.Errorf("%s: cancel consumer %s failed: %v", ai.superSpec.Name(), tag, err)
+		}
+		wg.Wait()
+		return nil
+	case err := <-closed:
+		wg.Wait()
+		return fmt.Errorf("connection closed: %v", err)
+	}
+}
+
+// Status returns the status of AMQPInput.
+func (ai *AMQPInput) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: ai.handler.status(),
+	}
+}
+
+// Close closes AMQPInput.
+func (ai *AMQPInput) Close() {
+	ai.cancel()
+	<-ai.done
+}
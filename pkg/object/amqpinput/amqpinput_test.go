@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package amqpinput
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocol"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type mockPipeline func(ctx context.HTTPContext)
+
+func (p mockPipeline) Handle(ctx context.HTTPContext) {
+	p(ctx)
+}
+
+type mockAcknowledger struct {
+	acked   []uint64
+	nacked  []uint64
+	requeue bool
+}
+
+func (a *mockAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *mockAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked = append(a.nacked, tag)
+	a.requeue = requeue
+	return nil
+}
+
+func (a *mockAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestHandleDelivery(t *testing.T) {
+	pipelines := map[string]protocol.HTTPHandler{
+		"consumer": mockPipeline(func(ctx context.HTTPContext) {
+			r := ctx.Request()
+			body, _ := io.ReadAll(r.Body())
+			if r.Path() != "/amqp/orders.created" || r.Header().Get(headerExchange) != "orders" ||
+				r.Header().Get(headerMessageID) != "m1" || r.Header().Get("Trace-Id") != "abc" ||
+				r.Header().Get("Content-Type") != "application/json" || string(body) != "{}" {
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+			}
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	ack := &mockAcknowledger{}
+	newDelivery := func(tag uint64, body string) *amqp.Delivery {
+		return &amqp.Delivery{
+			Acknowledger: ack,
+			DeliveryTag:  tag,
+			Exchange:     "orders",
+			RoutingKey:   "orders.created",
+			MessageId:    "m1",
+			ContentType:  "application/json",
+			Headers:      amqp.Table{"Trace-Id": "abc", "Retries": int32(1)},
+			Body:         []byte(body),
+		}
+	}
+
+	h := newDeliveryHandler("consumer", true, getPipeline)
+	h.handleDelivery(newDelivery(1, "{}"))
+	h.handleDelivery(newDelivery(2, "invalid"))
+
+	if len(ack.acked) != 1 || ack.acked[0] != 1 {
+		t.Errorf("message 1 should be acked, got %v", ack.acked)
+	}
+	if len(ack.nacked) != 1 || ack.nacked[0] != 2 || !ack.requeue {
+		t.Errorf("message 2 should be nacked and requeued, got %v", ack.nacked)
+	}
+
+	s := h.status()
+	if s.Consumed != 2 || s.Succeeded != 1 || s.Failed != 1 || s.LastError == "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	h = newDeliveryHandler("not-exist", false, getPipeline)
+	ack.nacked = nil
+	h.handleDelivery(newDelivery(3, "{}"))
+	if len(ack.nacked) != 1 || ack.requeue {
+		t.Errorf("message 3 should be nacked without requeue, got %v", ack.nacked)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package amqpinput
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// headerExchange is the header of AMQP exchange in requests to the pipeline.
+	headerExchange = "X-Amqp-Exchange"
+	// headerRoutingKey is the header of AMQP routing key in requests to the pipeline.
+	headerRoutingKey = "X-Amqp-Routing-Key"
+	// headerMessageID is the header of AMQP message ID in requests to the pipeline.
+	headerMessageID = "X-Amqp-Message-Id"
+	// headerRedelivered is the header of AMQP redelivered flag in requests to the pipeline.
+	headerRedelivered = "X-Amqp-Redelivered"
+
+	consumePath = "/amqp/"
+)
+
+type (
+	// deliveryHandler feeds the messages into the pipeline, and
+	// acknowledges them according to the responses.
+	deliveryHandler struct {
+		pipeline    string
+		requeue     bool
+		getPipeline pipelinetool.Getter
+
+		consumed  uint64
+		succeeded uint64
+		failed    uint64
+		connected int32
+		lastError atomic.Value // string
+	}
+
+	// Status is the status of AMQPInput.
+	Status struct {
+		// Consumed is the number of messages consumed from the queue.
+		Consumed uint64 `yaml:"consumed"`
+		// Succeeded is the number of messages the pipeline responds 2xx,
+		// they are acknowledged.
+		Succeeded uint64 `yaml:"succeeded"`
+		// Failed is the number of messages failed to be handled by the
+		// pipeline, they are rejected.
+		Failed    uint64 `yaml:"failed"`
+		Connected bool   `yaml:"connected"`
+		LastError string `yaml:"lastError,omitempty"`
+	}
+)
+
+func newDeliveryHandler(pipeline string, requeue bool, getPipeline pipelinetool.Getter) *deliveryHandler {
+	h := &deliveryHandler{
+		pipeline:    pipeline,
+		requeue:     requeue,
+		getPipeline: getPipeline,
+	}
+	h.lastError.Store("")
+	return h
+}
+
+// handleDelivery handles the message by a request to the pipeline, the
+// path of the request is the routing key under /amqp/, the body is the message
+// body, and the string headers of the message are copied to the request.
+func (h *deliveryHandler) handleDelivery(d *amqp.Delivery) {
+	atomic.AddUint64(&h.consumed, 1)
+
+	err := h.feedPipeline(d)
+	if err == nil {
+		atomic.AddUint64(&h.succeeded, 1)
+		if err = d.Ack(false); err != nil {
+			h.setLastError(fmt.Errorf("ack message failed: %v", err))
+		}
+		return
+	}
+
+	logger.Errorf("handle message %d from %s failed: %v", d.DeliveryTag, d.RoutingKey, err)
+	atomic.AddUint64(&h.failed, 1)
+	h.setLastError(err)
+	if err = d.Nack(false, h.requeue); err != nil {
+		h.setLastError(fmt.Errorf("nack message failed: %v", err))
+	}
+}
+
+func (h *deliveryHandler) feedPipeline(d *amqp.Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, consumePath+d.RoutingKey, bytes.NewReader(d.Body))
+	if err != nil {
+		return fmt.Errorf("new request failed: %v", err)
+	}
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			req.Header.Set(k, s)
+		}
+	}
+	if d.ContentType != "" {
+		req.Header.Set("Content-Type", d.ContentType)
+	}
+	req.Header.Set(headerExchange, d.Exchange)
+	req.Header.Set(headerRoutingKey, d.RoutingKey)
+	req.Header.Set(headerRedelivered, strconv.FormatBool(d.Redelivered))
+	if d.MessageId != "" {
+		req.Header.Set(headerMessageID, d.MessageId)
+	}
+
+	ctx, err := pipelinetool.Handle(h.getPipeline, h.pipeline, req, "amqpinput")
+	if err != nil {
+		return err
+	}
+
+	code := ctx.Response().StatusCode()
+	if code < 200 || code >= 300 {
+		return fmt.Errorf("pipeline %s responds status code %d", h.pipeline, code)
+	}
+	return nil
+}
+
+func (h *deliveryHandler) setLastError(err error) {
+	h.lastError.Store(err.Error())
+}
+
+func (h *deliveryHandler) setConnected(connected bool) {
+	var v int32
+	if connected {
+		v = 1
+	}
+	atomic.StoreInt32(&h.connected, v)
+}
+
+func (h *deliveryHandler) status() *Status {
+	return &Status{
+		Consumed:  atomic.LoadUint64(&h.consumed),
+		Succeeded: atomic.LoadUint64(&h.succeeded),
+		Failed:    atomic.LoadUint64(&h.failed),
+		Connected: atomic.LoadInt32(&h.connected) == 1,
+		LastError: h.lastError.Load().(string),
+	}
+}
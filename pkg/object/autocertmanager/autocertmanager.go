@@ -0,0 +1,599 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	stdcontext "context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of AutoCertManager.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of AutoCertManager.
+	Kind = "AutoCertManager"
+
+	defaultRenewBefore   = 30 * 24 * time.Hour
+	defaultIssueTimeout  = 10 * time.Minute
+	defaultCheckInterval = time.Hour
+	// firstCheckDelay waits for the cluster to be ready before the first check.
+	firstCheckDelay = 10 * time.Second
+
+	http01PathPrefix = "/.well-known/acme-challenge/"
+)
+
+var (
+	// globalACM is the running AutoCertManager, there should be at most one
+	// AutoCertManager in a cluster.
+	globalACM      *AutoCertManager
+	globalACMMutex sync.RWMutex
+)
+
+func init() {
+	supervisor.Register(&AutoCertManager{})
+}
+
+type (
+	// AutoCertManager is a business controller which obtains and renews
+	// certificates from an ACME server like Let's Encrypt automatically.
+	AutoCertManager struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		renewBefore   time.Duration
+		issueTimeout  time.Duration
+		checkInterval time.Duration
+
+		client *acme.Client
+
+		mutex    sync.RWMutex
+		certs    map[string]*certificate
+		statuses map[string]*DomainStatus
+
+		done chan struct{}
+	}
+
+	// Spec describes AutoCertManager.
+	Spec struct {
+		Email        string `yaml:"email" jsonschema:"required,format=email"`
+		DirectoryURL string `yaml:"directoryURL" jsonschema:"omitempty,format=uri"`
+		// RenewBefore is the time before the expiration to renew a certificate.
+		RenewBefore   string        `yaml:"renewBefore" jsonschema:"omitempty,format=duration"`
+		IssueTimeout  string        `yaml:"issueTimeout" jsonschema:"omitempty,format=duration"`
+		CheckInterval string        `yaml:"checkInterval" jsonschema:"omitempty,format=duration"`
+		Domains       []*DomainSpec `yaml:"domains" jsonschema:"required,minItems=1"`
+	}
+
+	// DomainSpec describes a domain to manage certificate for, the HTTP-01
+	// challenge is used if DNSProvider is empty, otherwise the DNS-01
+	// challenge is used.
+	DomainSpec struct {
+		Name        string           `yaml:"name" jsonschema:"required"`
+		DNSProvider *DNSProviderSpec `yaml:"dnsProvider,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of AutoCertManager.
+	Status struct {
+		Domains []*DomainStatus `yaml:"domains"`
+	}
+
+	// DomainStatus is the status of a domain.
+	DomainStatus struct {
+		Name      string `yaml:"name"`
+		NotBefore string `yaml:"notBefore,omitempty"`
+		NotAfter  string `yaml:"notAfter,omitempty"`
+		LastIssue string `yaml:"lastIssue,omitempty"`
+		Error     string `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	names := map[string]struct{}{}
+	for _, d := range spec.Domains {
+		if _, ok := names[d.Name]; ok {
+			return fmt.Errorf("domain %s is duplicated", d.Name)
+		}
+		names[d.Name] = struct{}{}
+
+		if d.Name != strings.ToLower(d.Name) {
+			return fmt.Errorf("domain %s should be in lower case", d.Name)
+		}
+
+		if strings.HasPrefix(d.Name, "*.") && d.DNSProvider == nil {
+			return fmt.Errorf("wildcard domain %s requires dnsProvider", d.Name)
+		}
+	}
+	return nil
+}
+
+// Category returns the category of AutoCertManager.
+func (acm *AutoCertManager) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind return the kind of AutoCertManager.
+func (acm *AutoCertManager) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of AutoCertManager.
+func (acm *AutoCertManager) DefaultSpec() interface{} {
+	return &Spec{
+		DirectoryURL:  acme.LetsEncryptURL,
+		RenewBefore:   "720h",
+		IssueTimeout:  "10m",
+		CheckInterval: "1h",
+	}
+}
+
+// Init initializes AutoCertManager.
+func (acm *AutoCertManager) Init(superSpec *supervisor.Spec) {
+	acm.superSpec, acm.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	acm.reload()
+}
+
+// Inherit inherits previous generation of AutoCertManager.
+func (acm *AutoCertManager) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	acm.Init(superSpec)
+}
+
+func (acm *AutoCertManager) reload() {
+	acm.renewBefore = parseDurationOr(acm.spec.RenewBefore, defaultRenewBefore)
+	acm.issueTimeout = parseDurationOr(acm.spec.IssueTimeout, defaultIssueTimeout)
+	acm.checkInterval = parseDurationOr(acm.spec.CheckInterval, defaultCheckInterval)
+	acm.certs = map[string]*certificate{}
+	acm.statuses = map[string]*DomainStatus{}
+	for _, d := range acm.spec.Domains {
+		acm.statuses[d.Name] = &DomainStatus{Name: d.Name}
+	}
+	acm.done = make(chan struct{})
+
+	globalACMMutex.Lock()
+	if globalACM != nil {
+		logger.Warnf("there are more than one %s, %s is replaced by %s",
+			Kind, globalACM.superSpec.Name(), acm.superSpec.Name())
+	}
+	globalACM = acm
+	globalACMMutex.Unlock()
+
+	if acm.superSpec.Super() != nil && acm.superSpec.Super().Cluster() != nil {
+		go acm.watchCerts()
+		go acm.run()
+	}
+}
+
+// watchCerts syncs the certificates from the cluster, so the certificates
+// obtained by any member are available to all members.
+func (acm *AutoCertManager) watchCerts() {
+	c := acm.superSpec.Super().Cluster()
+
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan map[string]string
+	)
+
+	for {
+		syncer, err = c.Syncer(time.Minute)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.SyncPrefix(c.Layout().AutoCertCertPrefix()); err != nil {
+			logger.Errorf("failed to sync certificates: %v", err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-acm.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-acm.done:
+			return
+		case m := <-ch:
+			acm.setCerts(m)
+		}
+	}
+}
+
+func (acm *AutoCertManager) setCerts(m map[string]string) {
+	certs := map[string]*certificate{}
+	for k, v := range m {
+		c, err := parseCertificate(v)
+		if err != nil {
+			logger.Errorf("parse certificate %s failed: %v", k, err)
+			continue
+		}
+		certs[c.Domain] = c
+	}
+
+	acm.mutex.Lock()
+	acm.certs = certs
+	acm.mutex.Unlock()
+}
+
+func (acm *AutoCertManager) run() {
+	timer := time.NewTimer(firstCheckDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-acm.done:
+			return
+		case <-timer.C:
+			// NOTE: Only the leader obtains certificates, to avoid all members
+			// issuing the same certificates.
+			if acm.superSpec.Super().Cluster().IsLeader() {
+				acm.checkCerts()
+			}
+			timer.Reset(acm.checkInterval)
+		}
+	}
+}
+
+func (acm *AutoCertManager) checkCerts() {
+	for _, d := range acm.spec.Domains {
+		select {
+		case <-acm.done:
+			return
+		default:
+		}
+
+		c := acm.getCert(d.Name)
+		if c != nil && !c.needRenew(acm.renewBefore) {
+			continue
+		}
+
+		logger.Infof("obtain certificate for %s", d.Name)
+
+		err := acm.issue(d)
+
+		acm.mutex.Lock()
+		status := acm.statuses[d.Name]
+		status.LastIssue = time.Now().Format(time.RFC3339)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Error = ""
+		}
+		acm.mutex.Unlock()
+
+		if err != nil {
+			logger.Errorf("obtain certificate for %s failed: %v", d.Name, err)
+		}
+	}
+}
+
+func (acm *AutoCertManager) getCert(domain string) *certificate {
+	acm.mutex.RLock()
+	defer acm.mutex.RUnlock()
+	return acm.certs[domain]
+}
+
+// acmeClient creates the ACME client and registers the account if needed,
+// the account key is saved in the cluster and shared by all members.
+func (acm *AutoCertManager) acmeClient(ctx stdcontext.Context) (*acme.Client, error) {
+	if acm.client != nil {
+		return acm.client, nil
+	}
+
+	c := acm.superSpec.Super().Cluster()
+	accountKey := c.Layout().AutoCertAccountKey()
+
+	value, err := c.Get(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var key *ecdsa.PrivateKey
+	if value != nil {
+		block, _ := pem.Decode([]byte(*value))
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key")
+		}
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parse account key failed: %v", err)
+		}
+	} else {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		buff := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err = c.Put(accountKey, string(buff)); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: acm.spec.DirectoryURL,
+		UserAgent:    "Easegress",
+	}
+	account := &acme.Account{Contact: []string{"mailto:" + acm.spec.Email}}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("register account failed: %v", err)
+	}
+
+	acm.client = client
+	return client, nil
+}
+
+func (acm *AutoCertManager) issue(d *DomainSpec) error {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), acm.issueTimeout)
+	defer cancel()
+
+	client, err := acm.acmeClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(d.Name))
+	if err != nil {
+		return fmt.Errorf("create order failed: %v", err)
+	}
+
+	for _, url := range order.AuthzURLs {
+		if err = acm.authorize(ctx, client, d, url); err != nil {
+			return err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("wait order failed: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{d.Name},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalize order failed: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	cert, err := newCertificate(d.Name, der, keyDER)
+	if err != nil {
+		return err
+	}
+
+	c := acm.superSpec.Super().Cluster()
+	if err = c.Put(c.Layout().AutoCertCertKey(d.Name), cert.marshal()); err != nil {
+		return fmt.Errorf("save certificate failed: %v", err)
+	}
+
+	// NOTE: Update the local copy at once, instead of waiting for the syncer.
+	acm.mutex.Lock()
+	certs := make(map[string]*certificate, len(acm.certs)+1)
+	for k, v := range acm.certs {
+		certs[k] = v
+	}
+	certs[d.Name] = cert
+	acm.certs = certs
+	acm.mutex.Unlock()
+
+	return nil
+}
+
+func (acm *AutoCertManager) authorize(ctx stdcontext.Context, client *acme.Client, d *DomainSpec, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("get authorization failed: %v", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	typ := "http-01"
+	if d.DNSProvider != nil {
+		typ = "dns-01"
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == typ {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("challenge %s is not offered by the server", typ)
+	}
+
+	if d.DNSProvider != nil {
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		p := newDNSProvider(d.DNSProvider)
+		if err = p.present(d.Name, value); err != nil {
+			return err
+		}
+		defer func() {
+			if err := p.cleanup(d.Name, value); err != nil {
+				logger.Errorf("clean up dns-01 challenge of %s failed: %v", d.Name, err)
+			}
+		}()
+
+		select {
+		case <-time.After(p.propagationTimeout):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		value, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		c := acm.superSpec.Super().Cluster()
+		key := c.Layout().AutoCertChallengeKey(chal.Token)
+		if err = c.Put(key, value); err != nil {
+			return err
+		}
+		defer c.Delete(key)
+	}
+
+	if _, err = client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge failed: %v", err)
+	}
+	if _, err = client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("wait authorization failed: %v", err)
+	}
+	return nil
+}
+
+// getCertificate returns the certificate matches the server name, the
+// certificate of an exact domain is preferred to a wildcard one.
+func (acm *AutoCertManager) getCertificate(serverName string) *tls.Certificate {
+	acm.mutex.RLock()
+	defer acm.mutex.RUnlock()
+
+	if c := acm.certs[serverName]; c != nil {
+		return c.cert
+	}
+	for domain, c := range acm.certs {
+		if matchDomain(domain, serverName) {
+			return c.cert
+		}
+	}
+	return nil
+}
+
+func (acm *AutoCertManager) handleHTTP01Challenge(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, http01PathPrefix)
+
+	c := acm.superSpec.Super().Cluster()
+	value, err := c.Get(c.Layout().AutoCertChallengeKey(token))
+	if err != nil {
+		logger.Errorf("get http-01 challenge %s failed: %v", token, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if value == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(*value))
+}
+
+// Status returns the status of AutoCertManager.
+func (acm *AutoCertManager) Status() *supervisor.Status {
+	s := &Status{}
+
+	acm.mutex.RLock()
+	defer acm.mutex.RUnlock()
+
+	for _, d := range acm.spec.Domains {
+		ds := *acm.statuses[d.Name]
+		if c := acm.certs[d.Name]; c != nil {
+			ds.NotBefore = c.cert.Leaf.NotBefore.Format(time.RFC3339)
+			ds.NotAfter = c.cert.Leaf.NotAfter.Format(time.RFC3339)
+		}
+		s.Domains = append(s.Domains, &ds)
+	}
+
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Close closes AutoCertManager.
+func (acm *AutoCertManager) Close() {
+	close(acm.done)
+
+	globalACMMutex.Lock()
+	if globalACM == acm {
+		globalACM = nil
+	}
+	globalACMMutex.Unlock()
+}
+
+// GetCertificate returns the certificate for the TLS handshake from the
+// running AutoCertManager, it returns nil if there is no AutoCertManager or
+// no certificate matches.
+func GetCertificate(hello *tls.ClientHelloInfo) *tls.Certificate {
+	globalACMMutex.RLock()
+	acm := globalACM
+	globalACMMutex.RUnlock()
+
+	if acm == nil || hello.ServerName == "" {
+		return nil
+	}
+	return acm.getCertificate(strings.ToLower(hello.ServerName))
+}
+
+// HandleHTTP01Challenge handles the ACME HTTP-01 challenge request, it
+// returns false if the request is not a challenge or there is no running
+// AutoCertManager.
+func HandleHTTP01Challenge(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, http01PathPrefix) {
+		return false
+	}
+
+	globalACMMutex.RLock()
+	acm := globalACM
+	globalACMMutex.RUnlock()
+
+	if acm == nil || acm.superSpec.Super() == nil || acm.superSpec.Super().Cluster() == nil {
+		return false
+	}
+
+	acm.handleHTTP01Challenge(w, r)
+	return true
+}
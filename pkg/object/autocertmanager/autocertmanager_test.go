@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createCertificate(t *testing.T, domain string, notAfter time.Time) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	c, err := newCertificate(domain, [][]byte{der}, keyDER)
+	if err != nil {
+		t.Fatalf("new certificate failed: %v", err)
+	}
+	return c
+}
+
+func TestMatchDomain(t *testing.T) {
+	cases := []struct {
+		domain     string
+		serverName string
+		match      bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", ".example.com", false},
+	}
+	for _, c := range cases {
+		if matchDomain(c.domain, c.serverName) != c.match {
+			t.Errorf("match %s against %s should be %v", c.serverName, c.domain, c.match)
+		}
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	c := createCertificate(t, "example.com", time.Now().Add(24*time.Hour))
+
+	c2, err := parseCertificate(c.marshal())
+	if err != nil {
+		t.Fatalf("parse certificate failed: %v", err)
+	}
+	if c2.Domain != "example.com" || !c2.cert.Leaf.NotAfter.Equal(c.cert.Leaf.NotAfter) {
+		t.Errorf("parsed certificate should be the same as the original one")
+	}
+
+	if c.needRenew(time.Hour) {
+		t.Errorf("certificate should not need renewal")
+	}
+	if !c.needRenew(48 * time.Hour) {
+		t.Errorf("certificate should need renewal")
+	}
+
+	if _, err = parseCertificate("domain: example.com\ncertificate: invalid\n"); err == nil {
+		t.Errorf("invalid certificate should fail")
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	exact := createCertificate(t, "www.example.com", time.Now().Add(24*time.Hour))
+	wildcard := createCertificate(t, "*.example.com", time.Now().Add(24*time.Hour))
+
+	acm := &AutoCertManager{certs: map[string]*certificate{}}
+	acm.setCerts(map[string]string{
+		"/autocert/certs/www.example.com": exact.marshal(),
+		"/autocert/certs/*.example.com":   wildcard.marshal(),
+		"/autocert/certs/invalid":         "invalid",
+	})
+
+	if len(acm.certs) != 2 {
+		t.Fatalf("there should be 2 certificates, got %d", len(acm.certs))
+	}
+	if acm.getCertificate("www.example.com").Leaf.Subject.CommonName != "www.example.com" {
+		t.Errorf("the certificate of the exact domain should be preferred")
+	}
+	if acm.getCertificate("api.example.com").Leaf.Subject.CommonName != "*.example.com" {
+		t.Errorf("the wildcard certificate should match")
+	}
+	if acm.getCertificate("www.megaease.com") != nil {
+		t.Errorf("no certificate should match")
+	}
+
+	globalACM = acm
+	defer func() { globalACM = nil }()
+	if GetCertificate(&tls.ClientHelloInfo{ServerName: "API.example.com"}) == nil {
+		t.Errorf("server name should be case insensitive")
+	}
+	if GetCertificate(&tls.ClientHelloInfo{}) != nil {
+		t.Errorf("no certificate should match empty server name")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	spec := Spec{Domains: []*DomainSpec{{Name: "example.com"}, {Name: "*.example.com"}}}
+	if spec.Validate() == nil {
+		t.Errorf("wildcard domain without dns provider should be invalid")
+	}
+
+	spec.Domains[1].DNSProvider = &DNSProviderSpec{Nameserver: "127.0.0.1:53", Zone: "example.com"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("spec should be valid, got %v", err)
+	}
+
+	spec.Domains = append(spec.Domains, &DomainSpec{Name: "example.com"})
+	if spec.Validate() == nil {
+		t.Errorf("duplicated domain should be invalid")
+	}
+
+	spec.Domains = []*DomainSpec{{Name: "Example.com"}}
+	if spec.Validate() == nil {
+		t.Errorf("domain not in lower case should be invalid")
+	}
+}
+
+func TestDNSProvider(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+	var mutex sync.Mutex
+	records := map[string]bool{}
+	hasRecord := func(key string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return records[key]
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(r)
+		if r.IsTsig() == nil || w.TsigStatus() != nil || r.Question[0].Name != "example.com." {
+			m.Rcode = dns.RcodeRefused
+		} else {
+			mutex.Lock()
+			for _, rr := range r.Ns {
+				txt := rr.(*dns.TXT)
+				key := txt.Hdr.Name + " " + txt.Txt[0]
+				// NOTE: Records to delete are of class NONE.
+				records[key] = txt.Hdr.Class == dns.ClassINET
+			}
+			mutex.Unlock()
+			m.SetTsig(r.Extra[len(r.Extra)-1].(*dns.TSIG).Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		w.WriteMsg(m)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	server := &dns.Server{
+		Listener:   l,
+		Handler:    handler,
+		TsigSecret: map[string]string{"easegress.": secret},
+		// NOTE: The default accept function rejects updates.
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	p := newDNSProvider(&DNSProviderSpec{
+		Nameserver: l.Addr().String(),
+		Zone:       "example.com",
+		TSIGKey:    "easegress",
+		TSIGSecret: secret,
+	})
+
+	if err = p.present("*.example.com", "token"); err != nil {
+		t.Fatalf("present failed: %v", err)
+	}
+	if !hasRecord("_acme-challenge.example.com. token") {
+		t.Errorf("the record should be inserted")
+	}
+
+	if err = p.cleanup("*.example.com", "token"); err != nil {
+		t.Fatalf("clean up failed: %v", err)
+	}
+	if hasRecord("_acme-challenge.example.com. token") {
+		t.Errorf("the record should be removed")
+	}
+
+	p.spec.Zone = "megaease.com"
+	if err = p.present("www.megaease.com", "token"); err == nil {
+		t.Errorf("update of refused zone should fail")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// certificate is a certificate issued by the ACME server, it is saved
+	// in the cluster so all members share it.
+	certificate struct {
+		Domain      string `yaml:"domain"`
+		Certificate string `yaml:"certificate"`
+		PrivateKey  string `yaml:"privateKey"`
+
+		cert *tls.Certificate
+	}
+)
+
+func newCertificate(domain string, der [][]byte, keyDER []byte) (*certificate, error) {
+	var certPEM strings.Builder
+	for _, b := range der {
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	c := &certificate{
+		Domain:      domain,
+		Certificate: certPEM.String(),
+		PrivateKey:  string(keyPEM),
+	}
+	if err := c.parse(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseCertificate(value string) (*certificate, error) {
+	c := &certificate{}
+	if err := yaml.Unmarshal([]byte(value), c); err != nil {
+		return nil, err
+	}
+	if err := c.parse(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certificate) parse() error {
+	cert, err := tls.X509KeyPair([]byte(c.Certificate), []byte(c.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("generate x509 key pair for %s failed: %v", c.Domain, err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse certificate of %s failed: %v", c.Domain, err)
+	}
+	c.cert = &cert
+	return nil
+}
+
+func (c *certificate) marshal() string {
+	buff, err := yaml.Marshal(c)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", c, err))
+	}
+	return string(buff)
+}
+
+// needRenew returns whether the certificate expires within renewBefore.
+func (c *certificate) needRenew(renewBefore time.Duration) bool {
+	return time.Now().Add(renewBefore).After(c.cert.Leaf.NotAfter)
+}
+
+// matchDomain returns whether the server name matches the domain, the domain
+// could be a wildcard one, which matches exactly one label.
+func matchDomain(domain, serverName string) bool {
+	if strings.EqualFold(domain, serverName) {
+		return true
+	}
+	if !strings.HasPrefix(domain, "*.") {
+		return false
+	}
+	idx := strings.IndexByte(serverName, '.')
+	return idx > 0 && strings.EqualFold(domain[1:], serverName[idx:])
+}
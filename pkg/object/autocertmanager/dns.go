@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSTTL             = 60
+	defaultDNSTimeout         = 10 * time.Second
+	defaultPropagationTimeout = 30 * time.Second
+)
+
+type (
+	// DNSProviderSpec describes the DNS server to fulfill DNS-01 challenges,
+	// the TXT records are updated by RFC 2136 dynamic updates.
+	DNSProviderSpec struct {
+		Nameserver string `yaml:"nameserver" jsonschema:"required"`
+		Zone       string `yaml:"zone" jsonschema:"required"`
+		TSIGKey    string `yaml:"tsigKey" jsonschema:"omitempty"`
+		TSIGSecret string `yaml:"tsigSecret" jsonschema:"omitempty,format=base64"`
+		// TSIGAlgorithm is one of hmac-sha1, hmac-sha256 and hmac-sha512.
+		TSIGAlgorithm string `yaml:"tsigAlgorithm,omitempty" jsonschema:"omitempty,enum=hmac-sha1,enum=hmac-sha256,enum=hmac-sha512"`
+		TTL           uint32 `yaml:"ttl" jsonschema:"omitempty"`
+		Timeout       string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// PropagationTimeout is the time to wait for the record to be
+		// visible to the ACME server before accepting the challenge.
+		PropagationTimeout string `yaml:"propagationTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	dnsProvider struct {
+		spec               *DNSProviderSpec
+		client             *dns.Client
+		ttl                uint32
+		propagationTimeout time.Duration
+	}
+)
+
+// Validate validates DNSProviderSpec.
+func (spec DNSProviderSpec) Validate() error {
+	if (spec.TSIGKey == "") != (spec.TSIGSecret == "") {
+		return fmt.Errorf("tsigKey and tsigSecret must be specified together")
+	}
+	return nil
+}
+
+func parseDurationOr(s string, d time.Duration) time.Duration {
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		return d
+	}
+	return v
+}
+
+func newDNSProvider(spec *DNSProviderSpec) *dnsProvider {
+	p := &dnsProvider{
+		spec:               spec,
+		client:             &dns.Client{Net: "tcp", Timeout: parseDurationOr(spec.Timeout, defaultDNSTimeout)},
+		ttl:                spec.TTL,
+		propagationTimeout: parseDurationOr(spec.PropagationTimeout, defaultPropagationTimeout),
+	}
+	if p.ttl == 0 {
+		p.ttl = defaultDNSTTL
+	}
+	if spec.TSIGKey != "" {
+		p.client.TsigSecret = map[string]string{dns.Fqdn(spec.TSIGKey): spec.TSIGSecret}
+	}
+	return p
+}
+
+func (p *dnsProvider) tsigAlgorithm() string {
+	switch p.spec.TSIGAlgorithm {
+	case "hmac-sha1":
+		return dns.HmacSHA1
+	case "hmac-sha512":
+		return dns.HmacSHA512
+	default:
+		return dns.HmacSHA256
+	}
+}
+
+// challengeRecordName returns the name of the TXT record for a domain, the
+// leading '*.' of wildcard domains is removed.
+func challengeRecordName(domain string) string {
+	return dns.Fqdn("_acme-challenge." + strings.TrimPrefix(domain, "*."))
+}
+
+func (p *dnsProvider) present(domain, value string) error {
+	return p.update(domain, value, true)
+}
+
+func (p *dnsProvider) cleanup(domain, value string) error {
+	return p.update(domain, value, false)
+}
+
+func (p *dnsProvider) update(domain, value string, insert bool) error {
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   challengeRecordName(domain),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    p.ttl,
+		},
+		Txt: []string{value},
+	}
+
+	m := &dns.Msg{}
+	m.SetUpdate(dns.Fqdn(p.spec.Zone))
+	if insert {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+	if p.spec.TSIGKey != "" {
+		m.SetTsig(dns.Fqdn(p.spec.TSIGKey), p.tsigAlgorithm(), 300, time.Now().Unix())
+	}
+
+	reply, _, err := p.client.Exchange(m, p.spec.Nameserver)
+	if err != nil {
+		return fmt.Errorf("update dns record %s failed: %v", rr.Hdr.Name, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update dns record %s failed: %s", rr.Hdr.Name, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
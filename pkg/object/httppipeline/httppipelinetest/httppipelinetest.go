@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httppipelinetest provides the helpers to test filters.
+package httppipelinetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+// NextFunc acts as the following filters of the pipeline, it is called
+// with the result of the filter and returns the final result.
+type NextFunc func(ctx context.HTTPContext, lastResult string) string
+
+// NewFilterSpec creates the filter spec from the YAML spec without the
+// supervisor, the test fails if the spec is invalid.
+func NewFilterSpec(t testing.TB, yamlSpec string) *httppipeline.FilterSpec {
+	t.Helper()
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return spec
+}
+
+// InitFilter initializes the filter by the YAML spec and returns it.
+func InitFilter(t testing.TB, filter httppipeline.Filter, yamlSpec string) httppipeline.Filter {
+	t.Helper()
+
+	filter.Init(NewFilterSpec(t, yamlSpec))
+	return filter
+}
+
+// NewContext creates the HTTP context of the request, whose response is
+// recorded. The last result is returned as the final result if next is nil.
+func NewContext(req *http.Request, next NextFunc) (context.HTTPContext, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	ctx := context.New(w, req, tracing.NoopTracing, "test")
+	ctx.SetHandlerCaller(func(lastResult string) string {
+		if next == nil {
+			return lastResult
+		}
+		return next(ctx, lastResult)
+	})
+	return ctx, w
+}
+
+// Handle handles the request by the filter, and returns the result and
+// the recorded response after the context is finished.
+func Handle(filter httppipeline.Filter, req *http.Request, next NextFunc) (string, *httptest.ResponseRecorder) {
+	ctx, w := NewContext(req, next)
+	result := filter.Handle(ctx)
+	ctx.Finish()
+	return result, w
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type (
+	// HTTP2Spec describes the HTTP/2 options of HTTPServer.
+	HTTP2Spec struct {
+		// H2C enables HTTP/2 over cleartext TCP, it is only available
+		// when https is disabled.
+		H2C                  bool   `yaml:"h2c" jsonschema:"omitempty"`
+		MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams" jsonschema:"omitempty"`
+		MaxReadFrameSize     uint32 `yaml:"maxReadFrameSize,omitempty" jsonschema:"omitempty,minimum=16384,maximum=16777215"`
+		IdleTimeout          string `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// HTTP2Status is the HTTP/2 statistics of HTTPServer.
+	HTTP2Status struct {
+		// Requests is the total number of HTTP/2 requests.
+		Requests uint64 `yaml:"requests"`
+		// ActiveStreams is the number of HTTP/2 streams being served.
+		ActiveStreams int64 `yaml:"activeStreams"`
+	}
+
+	http2Stat struct {
+		requests      uint64
+		activeStreams int64
+	}
+)
+
+// configureHTTP2 configures HTTP/2 for the server, the handler of the
+// server must have been set.
+func configureHTTP2(srv *http.Server, spec *HTTP2Spec, https bool) error {
+	h2s := &http2.Server{
+		MaxConcurrentStreams: spec.MaxConcurrentStreams,
+		MaxReadFrameSize:     spec.MaxReadFrameSize,
+		IdleTimeout:          srv.IdleTimeout,
+	}
+	if spec.IdleTimeout != "" {
+		// NOTE: The duration has been checked in validation.
+		h2s.IdleTimeout, _ = time.ParseDuration(spec.IdleTimeout)
+	}
+
+	if https {
+		return http2.ConfigureServer(srv, h2s)
+	}
+
+	if spec.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return nil
+}
+
+// wrap wraps the handler to collect the statistics of HTTP/2 requests.
+func (s *http2Stat) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		atomic.AddUint64(&s.requests, 1)
+		atomic.AddInt64(&s.activeStreams, 1)
+		defer atomic.AddInt64(&s.activeStreams, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *http2Stat) status() *HTTP2Status {
+	return &HTTP2Status{
+		Requests:      atomic.LoadUint64(&s.requests),
+		ActiveStreams: atomic.LoadInt64(&s.activeStreams),
+	}
+}
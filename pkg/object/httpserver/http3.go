@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// HTTP3Spec describes the HTTP/3 options of HTTPServer.
+type HTTP3Spec struct {
+	// Disable0RTT rejects 0-RTT data which could be replayed by attackers,
+	// it disables TLS session resumption of QUIC too.
+	Disable0RTT bool `yaml:"disable0RTT" jsonschema:"omitempty"`
+	// DisableAltSvc stops advertising HTTP/3 by the Alt-Svc header from
+	// the HTTP/1.1 and HTTP/2 listener.
+	DisableAltSvc      bool   `yaml:"disableAltSvc" jsonschema:"omitempty"`
+	MaxIdleTimeout     string `yaml:"maxIdleTimeout" jsonschema:"omitempty,format=duration"`
+	MaxIncomingStreams int64  `yaml:"maxIncomingStreams" jsonschema:"omitempty,minimum=0"`
+}
+
+// newHTTP3Server creates the HTTP/3 server sharing the address, handler
+// and certificates with srv, which must have been configured.
+func newHTTP3Server(srv *http.Server, spec *HTTP3Spec) *http3.Server {
+	if spec == nil {
+		spec = &HTTP3Spec{}
+	}
+
+	// NOTE: The http.Server is copied, because the TLS config of QUIC
+	// may be different.
+	srv3 := &http.Server{
+		Addr:        srv.Addr,
+		Handler:     srv.Handler,
+		IdleTimeout: srv.IdleTimeout,
+		TLSConfig:   srv.TLSConfig,
+	}
+	if spec.Disable0RTT && srv.TLSConfig != nil {
+		// NOTE: QUIC accepts 0-RTT data only on resumed sessions.
+		srv3.TLSConfig = srv.TLSConfig.Clone()
+		srv3.TLSConfig.SessionTicketsDisabled = true
+	}
+
+	quicConfig := &quic.Config{
+		MaxIncomingStreams: spec.MaxIncomingStreams,
+	}
+	if spec.MaxIdleTimeout != "" {
+		// NOTE: The duration has been checked in validation.
+		quicConfig.MaxIdleTimeout, _ = time.ParseDuration(spec.MaxIdleTimeout)
+	}
+
+	return &http3.Server{
+		Server:     srv3,
+		QuicConfig: quicConfig,
+	}
+}
+
+// altSvcHandler wraps the handler to advertise HTTP/3 by the Alt-Svc header.
+func altSvcHandler(h http.Handler, srv3 *http3.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := srv3.SetQuicHeaders(w.Header()); err != nil {
+			logger.Errorf("set Alt-Svc header failed: %v", err)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
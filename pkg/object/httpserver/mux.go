@@ -27,6 +27,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/autocertmanager"
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
@@ -350,6 +351,12 @@ func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapp
 }
 
 func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
+	// NOTE: The ACME HTTP-01 challenges are served before routing, so the
+	// challenges of domains served by any HTTPServer could pass.
+	if autocertmanager.HandleHTTP01Challenge(stdw, stdr) {
+		return
+	}
+
 	rules := m.rules.Load().(*muxRules)
 
 	ctx := context.New(stdw, stdr, rules.tracer, rules.superSpec.Name())
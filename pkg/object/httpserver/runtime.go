@@ -81,6 +81,8 @@ type (
 		err   atomic.Value // error
 
 		httpStat      *httpstat.HTTPStat
+		http2Stat     *http2Stat
+		certManager   *certManager
 		topN          *topn.TopN
 		limitListener *limitlistener.LimitListener
 	}
@@ -93,16 +95,20 @@ type (
 		Error string    `yaml:"error,omitempty"`
 
 		*httpstat.Status
-		TopN *topn.Status `yaml:"topN"`
+		TopN  *topn.Status `yaml:"topN"`
+		HTTP2 *HTTP2Status `yaml:"http2"`
+		TLS   *TLSStatus   `yaml:"tls,omitempty"`
 	}
 )
 
 func newRuntime(superSpec *supervisor.Spec, muxMapper protocol.MuxMapper) *runtime {
 	r := &runtime{
-		superSpec: superSpec,
-		eventChan: make(chan interface{}, 10),
-		httpStat:  httpstat.New(),
-		topN:      topn.New(topNum),
+		superSpec:   superSpec,
+		eventChan:   make(chan interface{}, 10),
+		httpStat:    httpstat.New(),
+		http2Stat:   &http2Stat{},
+		certManager: newCertManager(),
+		topN:        topn.New(topNum),
 	}
 
 	r.mux = newMux(r.httpStat, r.topN, muxMapper)
@@ -132,6 +138,8 @@ func (r *runtime) Status() *Status {
 		Error:  r.getError().Error(),
 		Status: r.httpStat.Status(),
 		TopN:   r.topN.Status(),
+		HTTP2:  r.http2Stat.status(),
+		TLS:    r.certManager.status(),
 	}
 }
 
@@ -188,6 +196,9 @@ func (r *runtime) reload(nextSuperSpec *supervisor.Spec, muxMapper protocol.MuxM
 			r.startServer()
 		} else {
 			r.spec = nextSpec
+			// NOTE: The certificates are replaced without restarting
+			// the server.
+			r.certManager.reload(nextSpec)
 		}
 	}
 }
@@ -229,6 +240,14 @@ func (r *runtime) needRestartServer(nextSpec *Spec) bool {
 	x.IPFilter, y.IPFilter = nil, nil
 	x.Rules, y.Rules = nil, nil
 
+	// The certificates are reloaded by the certManager.
+	x.CertBase64, y.CertBase64 = "", ""
+	x.KeyBase64, y.KeyBase64 = "", ""
+	x.Certs, y.Certs = nil, nil
+	x.Keys, y.Keys = nil, nil
+	x.AutoCert, y.AutoCert = false, false
+	x.TLS, y.TLS = tlsSpecWithoutCertFiles(x.TLS), tlsSpecWithoutCertFiles(y.TLS)
+
 	// The update of rules need not to shutdown server.
 	return !reflect.DeepEqual(x, y)
 }
@@ -247,39 +266,56 @@ func (r *runtime) startServer() {
 
 	srv := &http.Server{
 		Addr:        fmt.Sprintf(":%d", r.spec.Port),
-		Handler:     r.mux,
+		Handler:     r.http2Stat.wrap(r.mux),
 		IdleTimeout: keepAliveTimeout,
 	}
 	srv.SetKeepAlivesEnabled(r.spec.KeepAlive)
 
+	r.certManager.reload(r.spec)
 	if r.spec.HTTPS {
 		tlsConfig, _ := r.spec.tlsConfig()
+		r.certManager.setup(tlsConfig)
 		srv.TLSConfig = tlsConfig
 	}
 
+	if r.spec.HTTP2 != nil {
+		err := configureHTTP2(srv, r.spec.HTTP2, r.spec.HTTPS)
+		if err != nil {
+			r.setState(stateFailed)
+			r.setError(err)
+
+			return
+		}
+	}
+
+	r.server3 = nil
+	if r.spec.HTTP3 {
+		r.server3 = newHTTP3Server(srv, r.spec.HTTP3Options)
+		if r.spec.HTTP3Options == nil || !r.spec.HTTP3Options.DisableAltSvc {
+			srv.Handler = altSvcHandler(srv.Handler, r.server3)
+		}
+	}
+
 	r.server = srv
 	r.startNum++
 	r.setState(stateRunning)
 	r.setError(nil)
 
-	if r.spec.HTTP3 {
-		r.server3 = &http3.Server{
-			Server: r.server,
-		}
+	if r.server3 != nil {
 		go r.runHTTP3Server(r.startNum)
-	} else {
-		listener, err := gnet.Listen("tcp", fmt.Sprintf(":%d", r.spec.Port))
-		if err != nil {
-			r.setState(stateFailed)
-			r.setError(err)
+	}
 
-			return
-		}
+	listener, err := gnet.Listen("tcp", fmt.Sprintf(":%d", r.spec.Port))
+	if err != nil {
+		r.setState(stateFailed)
+		r.setError(err)
 
-		limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
-		r.limitListener = limitListener
-		go r.runHTTP1And2Server(limitListener, r.spec.HTTPS, r.startNum)
+		return
 	}
+
+	limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
+	r.limitListener = limitListener
+	go r.runHTTP1And2Server(limitListener, r.spec.HTTPS, r.startNum)
 }
 
 func (r *runtime) runHTTP3Server(startNum uint64) {
@@ -318,15 +354,15 @@ func (r *runtime) closeServer() {
 			logger.Warnf("shutdown http3 server %s failed: %v",
 				r.superSpec.Name(), err)
 		}
-	} else {
-		// NOTE: It's safe to shutdown serve failed server.
-		ctx, cancelFunc := serverShutdownContext()
-		defer cancelFunc()
-		err := r.server.Shutdown(ctx)
-		if err != nil {
-			logger.Warnf("shutdown http1/2 server %s failed: %v",
-				r.superSpec.Name(), err)
-		}
+	}
+
+	// NOTE: It's safe to shutdown serve failed server.
+	ctx, cancelFunc := serverShutdownContext()
+	defer cancelFunc()
+	err := r.server.Shutdown(ctx)
+	if err != nil {
+		logger.Warnf("shutdown http1/2 server %s failed: %v",
+			r.superSpec.Name(), err)
 	}
 }
 
@@ -364,5 +400,6 @@ func (r *runtime) handleEventReload(e *eventReload) {
 func (r *runtime) handleEventClose(e *eventClose) {
 	r.closeServer()
 	r.mux.close()
+	r.certManager.close()
 	close(e.done)
 }
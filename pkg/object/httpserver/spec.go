@@ -32,6 +32,8 @@ type (
 	// Spec describes the HTTPServer.
 	Spec struct {
 		HTTP3            bool          `yaml:"http3" jsonschema:"omitempty"`
+		HTTP3Options     *HTTP3Spec    `yaml:"http3Options,omitempty" jsonschema:"omitempty"`
+		HTTP2            *HTTP2Spec    `yaml:"http2,omitempty" jsonschema:"omitempty"`
 		Port             uint16        `yaml:"port" jsonschema:"required,minimum=1"`
 		KeepAlive        bool          `yaml:"keepAlive" jsonschema:"required"`
 		KeepAliveTimeout string        `yaml:"keepAliveTimeout" jsonschema:"omitempty,format=duration"`
@@ -51,6 +53,11 @@ type (
 		Certs map[string]string `yaml:"certs" jsonschema:"omitempty"`
 		// Keys saved as map, key is domain name, value is secret
 		Keys map[string]string `yaml:"keys" jsonschema:"omitempty"`
+		// AutoCert uses the certificates managed by AutoCertManager, the
+		// certs/keys above are used if no managed certificate matches.
+		AutoCert bool `yaml:"autoCert" jsonschema:"omitempty"`
+
+		TLS *TLSSpec `yaml:"tls,omitempty" jsonschema:"omitempty"`
 
 		IPFilter *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
 		Rules    []*Rule        `yaml:"rules" jsonschema:"omitempty"`
@@ -102,9 +109,13 @@ func (spec *Spec) Validate() error {
 		return fmt.Errorf("https is disabled when http3 enabled")
 	}
 
+	if spec.HTTP2 != nil && spec.HTTP2.H2C && spec.HTTPS {
+		return fmt.Errorf("h2c is only available when https disabled")
+	}
+
 	if spec.HTTPS {
-		if spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 {
-			return fmt.Errorf("certBase64/keyBase64, certs/keys are both empty when https enabled")
+		if !spec.AutoCert && !spec.hasCertFiles() && spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 {
+			return fmt.Errorf("certBase64/keyBase64, certs/keys, tls.certFiles are all empty when https enabled and autoCert disabled")
 		}
 		_, err := spec.tlsConfig()
 		if err != nil {
@@ -115,8 +126,14 @@ func (spec *Spec) Validate() error {
 	return nil
 }
 
-func (spec *Spec) tlsConfig() (*tls.Config, error) {
-	var certificates []tls.Certificate
+func (spec *Spec) hasCertFiles() bool {
+	return spec.TLS != nil && len(spec.TLS.CertFiles) > 0
+}
+
+// certificates returns the certificates in the spec, the certificate files
+// are not included.
+func (spec *Spec) certificates() ([]*tls.Certificate, error) {
+	var certificates []*tls.Certificate
 	if spec.CertBase64 != "" && spec.KeyBase64 != "" {
 		// Prefer add CertBase64 and KeyBase64
 		certPem, _ := base64.StdEncoding.DecodeString(spec.CertBase64)
@@ -125,27 +142,50 @@ func (spec *Spec) tlsConfig() (*tls.Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("generate x509 key pair failed: %v", err)
 		}
-		certificates = append(certificates, cert)
+		certificates = append(certificates, &cert)
 	}
 
-	for k, v := range spec.Certs {
+	// NOTE: Sort the names to make the selection of duplicated names stable.
+	for _, k := range sortedKeys(spec.Certs) {
 		if secret, exists := spec.Keys[k]; exists {
-			cert, err := tls.X509KeyPair([]byte(v), []byte(secret))
+			cert, err := tls.X509KeyPair([]byte(spec.Certs[k]), []byte(secret))
 			if err != nil {
 				return nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
 			}
-			certificates = append(certificates, cert)
+			certificates = append(certificates, &cert)
 		} else {
 			return nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
 		}
 	}
 
-	if len(certificates) == 0 {
+	for _, cert := range certificates {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate failed: %v", err)
+		}
+		cert.Leaf = leaf
+	}
+
+	return certificates, nil
+}
+
+func (spec *Spec) tlsConfig() (*tls.Config, error) {
+	certificates, err := spec.certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certificates) == 0 && !spec.AutoCert && !spec.hasCertFiles() {
 		return nil, fmt.Errorf("none valid certs and secret")
 	}
 
-	tlsConf := &tls.Config{
-		Certificates: certificates,
+	tlsConf := &tls.Config{}
+	for _, cert := range certificates {
+		tlsConf.Certificates = append(tlsConf.Certificates, *cert)
+	}
+
+	if spec.TLS != nil {
+		spec.TLS.apply(tlsConf)
 	}
 
 	// if caCertBase64 configuration is provided, should enable tls.ClientAuth and
@@ -0,0 +1,439 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/autocertmanager"
+)
+
+const (
+	// certFileCheckInterval is the interval to check if the certificate
+	// files have changed.
+	certFileCheckInterval = 5 * time.Second
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}
+)
+
+type (
+	// TLSSpec describes the TLS options of HTTPServer.
+	TLSSpec struct {
+		MinVersion string `yaml:"minVersion,omitempty" jsonschema:"omitempty,enum=1.0,enum=1.1,enum=1.2,enum=1.3"`
+		MaxVersion string `yaml:"maxVersion,omitempty" jsonschema:"omitempty,enum=1.0,enum=1.1,enum=1.2,enum=1.3"`
+		// CipherSuites are the names of the cipher suites of TLS 1.0-1.2,
+		// the cipher suites of TLS 1.3 are not configurable.
+		CipherSuites []string `yaml:"cipherSuites" jsonschema:"omitempty,uniqueItems=true"`
+		// CertFiles are the certificate files, they are reloaded when
+		// they change.
+		CertFiles []*CertFileSpec `yaml:"certFiles" jsonschema:"omitempty"`
+	}
+
+	// CertFileSpec describes a pair of PEM encoded certificate and key files.
+	CertFileSpec struct {
+		Cert string `yaml:"cert" jsonschema:"required"`
+		Key  string `yaml:"key" jsonschema:"required"`
+	}
+
+	// TLSStatus is the TLS statistics of HTTPServer.
+	TLSStatus struct {
+		// Handshakes is the total number of received client hellos.
+		Handshakes uint64 `yaml:"handshakes"`
+		// Succeeded is the total number of established connections.
+		Succeeded uint64 `yaml:"succeeded"`
+		// Failed is the total number of handshakes failed after the client hello.
+		Failed uint64 `yaml:"failed"`
+		// Resumed is the total number of resumed sessions.
+		Resumed      uint64               `yaml:"resumed"`
+		Versions     map[string]uint64    `yaml:"versions"`
+		CipherSuites map[string]uint64    `yaml:"cipherSuites"`
+		Certificates []*CertificateStatus `yaml:"certificates"`
+	}
+
+	// CertificateStatus is the status of a certificate in use.
+	CertificateStatus struct {
+		Names    []string `yaml:"names"`
+		NotAfter string   `yaml:"notAfter"`
+	}
+
+	// certManager selects the certificate by the server name of the TLS
+	// handshake, the certificates could be replaced without restarting
+	// the server.
+	certManager struct {
+		mutex     sync.RWMutex
+		spec      *Spec
+		certs     []*tls.Certificate
+		names     map[string]*tls.Certificate
+		fileCerts map[CertFileSpec]*fileCert
+
+		handshakes uint64
+		succeeded  uint64
+		resumed    uint64
+
+		statMutex    sync.Mutex
+		versions     map[uint16]uint64
+		cipherSuites map[uint16]uint64
+
+		done chan struct{}
+	}
+
+	fileCert struct {
+		cert        *tls.Certificate
+		certModTime time.Time
+		keyModTime  time.Time
+	}
+)
+
+// Validate validates TLSSpec.
+func (spec TLSSpec) Validate() error {
+	if spec.MinVersion != "" && spec.MaxVersion != "" &&
+		tlsVersions[spec.MinVersion] > tlsVersions[spec.MaxVersion] {
+		return fmt.Errorf("minVersion %s is greater than maxVersion %s", spec.MinVersion, spec.MaxVersion)
+	}
+	_, err := parseCipherSuites(spec.CipherSuites)
+	return err
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		suites[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (spec *TLSSpec) apply(tlsConf *tls.Config) {
+	tlsConf.MinVersion = tlsVersions[spec.MinVersion]
+	tlsConf.MaxVersion = tlsVersions[spec.MaxVersion]
+	// NOTE: The cipher suites have been checked in validation.
+	tlsConf.CipherSuites, _ = parseCipherSuites(spec.CipherSuites)
+}
+
+func newCertManager() *certManager {
+	cm := &certManager{
+		names:        map[string]*tls.Certificate{},
+		fileCerts:    map[CertFileSpec]*fileCert{},
+		versions:     map[uint16]uint64{},
+		cipherSuites: map[uint16]uint64{},
+		done:         make(chan struct{}),
+	}
+	go cm.run()
+	return cm
+}
+
+func (cm *certManager) run() {
+	ticker := time.NewTicker(certFileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.done:
+			return
+		case <-ticker.C:
+			if cm.filesChanged() {
+				cm.load()
+			}
+		}
+	}
+}
+
+func (cm *certManager) close() {
+	close(cm.done)
+}
+
+// setup makes the TLS config to get certificates from the manager, and
+// collect statistics of handshakes.
+func (cm *certManager) setup(tlsConf *tls.Config) {
+	tlsConf.Certificates = nil
+	tlsConf.GetCertificate = cm.getCertificate
+	tlsConf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		atomic.AddUint64(&cm.handshakes, 1)
+		return nil, nil
+	}
+	tlsConf.VerifyConnection = func(cs tls.ConnectionState) error {
+		cm.stat(&cs)
+		return nil
+	}
+}
+
+// reload replaces the certificates by the ones in the spec, there is no
+// certificate if https is disabled.
+func (cm *certManager) reload(spec *Spec) {
+	cm.mutex.Lock()
+	cm.spec = spec
+	cm.mutex.Unlock()
+
+	cm.load()
+}
+
+func (cm *certManager) filesChanged() bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if cm.spec == nil || !cm.spec.HTTPS || cm.spec.TLS == nil {
+		return false
+	}
+
+	for _, f := range cm.spec.TLS.CertFiles {
+		fc := cm.fileCerts[*f]
+		if fc == nil {
+			return true
+		}
+		certInfo, err1 := os.Stat(f.Cert)
+		keyInfo, err2 := os.Stat(f.Key)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if !certInfo.ModTime().Equal(fc.certModTime) || !keyInfo.ModTime().Equal(fc.keyModTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cm *certManager) load() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cm.spec == nil || !cm.spec.HTTPS {
+		cm.certs, cm.names = nil, map[string]*tls.Certificate{}
+		cm.fileCerts = map[CertFileSpec]*fileCert{}
+		return
+	}
+
+	// NOTE: The certificates in the spec have been checked in validation.
+	certs, _ := cm.spec.certificates()
+
+	fileCerts := map[CertFileSpec]*fileCert{}
+	if cm.spec.TLS != nil {
+		for _, f := range cm.spec.TLS.CertFiles {
+			fc, err := loadCertFile(f)
+			if err != nil {
+				logger.Errorf("load certificate file %s failed: %v", f.Cert, err)
+				// NOTE: Keep the previous one, the files may be being written.
+				fc = cm.fileCerts[*f]
+			}
+			if fc != nil {
+				fileCerts[*f] = fc
+				certs = append(certs, fc.cert)
+			}
+		}
+	}
+
+	names := map[string]*tls.Certificate{}
+	for _, cert := range certs {
+		for _, name := range certNames(cert) {
+			// NOTE: The first certificate is used if names are duplicated.
+			if _, ok := names[name]; !ok {
+				names[name] = cert
+			}
+		}
+	}
+
+	cm.certs, cm.names, cm.fileCerts = certs, names, fileCerts
+}
+
+func loadCertFile(f *CertFileSpec) (*fileCert, error) {
+	certInfo, err := os.Stat(f.Cert)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(f.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileCert{
+		cert:        &cert,
+		certModTime: certInfo.ModTime(),
+		keyModTime:  keyInfo.ModTime(),
+	}, nil
+}
+
+func certNames(cert *tls.Certificate) []string {
+	if cert.Leaf == nil {
+		return nil
+	}
+	if len(cert.Leaf.DNSNames) > 0 {
+		names := make([]string, len(cert.Leaf.DNSNames))
+		for i, name := range cert.Leaf.DNSNames {
+			names[i] = strings.ToLower(name)
+		}
+		return names
+	}
+	if cert.Leaf.Subject.CommonName != "" {
+		return []string{strings.ToLower(cert.Leaf.Subject.CommonName)}
+	}
+	return nil
+}
+
+// getCertificate selects the certificate by the server name, the managed
+// certificates of AutoCertManager come first, and then the certificate of
+// the exact name, the wildcard name, and the first certificate at last.
+func (cm *certManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if cm.spec != nil && cm.spec.AutoCert {
+		if cert := autocertmanager.GetCertificate(hello); cert != nil {
+			return cert, nil
+		}
+	}
+
+	name := strings.ToLower(hello.ServerName)
+	if cert := cm.names[name]; cert != nil {
+		return cert, nil
+	}
+	if idx := strings.IndexByte(name, '.'); idx > 0 {
+		if cert := cm.names["*"+name[idx:]]; cert != nil {
+			return cert, nil
+		}
+	}
+
+	if len(cm.certs) > 0 {
+		return cm.certs[0], nil
+	}
+	return nil, fmt.Errorf("no certificate for %s", hello.ServerName)
+}
+
+func (cm *certManager) stat(cs *tls.ConnectionState) {
+	atomic.AddUint64(&cm.succeeded, 1)
+	if cs.DidResume {
+		atomic.AddUint64(&cm.resumed, 1)
+	}
+
+	cm.statMutex.Lock()
+	cm.versions[cs.Version]++
+	cm.cipherSuites[cs.CipherSuite]++
+	cm.statMutex.Unlock()
+}
+
+// status returns the TLS statistics, it returns nil if https is disabled.
+func (cm *certManager) status() *TLSStatus {
+	cm.mutex.RLock()
+	https := cm.spec != nil && cm.spec.HTTPS
+	cm.mutex.RUnlock()
+	if !https {
+		return nil
+	}
+
+	s := &TLSStatus{
+		Handshakes:   atomic.LoadUint64(&cm.handshakes),
+		Succeeded:    atomic.LoadUint64(&cm.succeeded),
+		Resumed:      atomic.LoadUint64(&cm.resumed),
+		Versions:     map[string]uint64{},
+		CipherSuites: map[string]uint64{},
+	}
+	if s.Handshakes > s.Succeeded {
+		s.Failed = s.Handshakes - s.Succeeded
+	}
+
+	cm.statMutex.Lock()
+	for k, v := range cm.versions {
+		name, ok := tlsVersionNames[k]
+		if !ok {
+			name = fmt.Sprintf("0x%04x", k)
+		}
+		s.Versions[name] = v
+	}
+	for k, v := range cm.cipherSuites {
+		s.CipherSuites[tls.CipherSuiteName(k)] = v
+	}
+	cm.statMutex.Unlock()
+
+	cm.mutex.RLock()
+	for _, cert := range cm.certs {
+		if cert.Leaf == nil {
+			continue
+		}
+		s.Certificates = append(s.Certificates, &CertificateStatus{
+			Names:    certNames(cert),
+			NotAfter: cert.Leaf.NotAfter.Format(time.RFC3339),
+		})
+	}
+	cm.mutex.RUnlock()
+
+	return s
+}
+
+func tlsSpecWithoutCertFiles(spec *TLSSpec) *TLSSpec {
+	if spec == nil {
+		return nil
+	}
+	s := *spec
+	s.CertFiles = nil
+	return &s
+}
+
+// sortedKeys returns the keys of the map in ascending order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
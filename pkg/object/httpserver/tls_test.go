@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createCertPEM(t *testing.T, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func selectedName(t *testing.T, cm *certManager, serverName string) string {
+	cert, err := cm.getCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("get certificate for %s failed: %v", serverName, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertManagerSNI(t *testing.T) {
+	cert1, key1 := createCertPEM(t, "default.example.com")
+	cert2, key2 := createCertPEM(t, "www.example.com", "api.example.com")
+	cert3, key3 := createCertPEM(t, "*.example.com")
+
+	spec := &Spec{
+		HTTPS: true,
+		Certs: map[string]string{"a": cert1, "b": cert2, "c": cert3},
+		Keys:  map[string]string{"a": key1, "b": key2, "c": key3},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("spec should be valid: %v", err)
+	}
+
+	cm := newCertManager()
+	defer cm.close()
+	cm.reload(spec)
+
+	cases := map[string]string{
+		"www.example.com":  "www.example.com",
+		"API.example.com":  "www.example.com",
+		"blog.example.com": "*.example.com",
+		"a.b.example.com":  "default.example.com",
+		"":                 "default.example.com",
+	}
+	for serverName, want := range cases {
+		if got := selectedName(t, cm, serverName); got != want {
+			t.Errorf("certificate for %q should be %s, got %s", serverName, want, got)
+		}
+	}
+
+	cm.reload(&Spec{HTTPS: false})
+	if _, err := cm.getCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"}); err == nil {
+		t.Errorf("there should be no certificate when https disabled")
+	}
+	if cm.status() != nil {
+		t.Errorf("there should be no tls status when https disabled")
+	}
+}
+
+func TestCertManagerFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	write := func(name string) {
+		cert, key := createCertPEM(t, name)
+		os.WriteFile(certFile, []byte(cert), 0o600)
+		os.WriteFile(keyFile, []byte(key), 0o600)
+	}
+	write("v1.example.com")
+
+	spec := &Spec{
+		HTTPS: true,
+		TLS:   &TLSSpec{CertFiles: []*CertFileSpec{{Cert: certFile, Key: keyFile}}},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("spec should be valid: %v", err)
+	}
+
+	cm := newCertManager()
+	defer cm.close()
+	cm.reload(spec)
+
+	if got := selectedName(t, cm, "v1.example.com"); got != "v1.example.com" {
+		t.Errorf("certificate should be v1.example.com, got %s", got)
+	}
+	if cm.filesChanged() {
+		t.Errorf("files should not be changed")
+	}
+
+	write("v2.example.com")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if !cm.filesChanged() {
+		t.Fatalf("files should be changed")
+	}
+	cm.load()
+	if got := selectedName(t, cm, "v2.example.com"); got != "v2.example.com" {
+		t.Errorf("certificate should be v2.example.com, got %s", got)
+	}
+
+	// NOTE: The previous certificate is kept if the files are broken.
+	os.WriteFile(keyFile, []byte("broken"), 0o600)
+	cm.load()
+	if got := selectedName(t, cm, "v2.example.com"); got != "v2.example.com" {
+		t.Errorf("certificate should be v2.example.com, got %s", got)
+	}
+}
+
+func TestTLSSpec(t *testing.T) {
+	spec := TLSSpec{MinVersion: "1.3", MaxVersion: "1.2"}
+	if spec.Validate() == nil {
+		t.Errorf("minVersion greater than maxVersion should be invalid")
+	}
+
+	spec = TLSSpec{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("spec should be valid: %v", err)
+	}
+	tlsConf := &tls.Config{}
+	spec.apply(tlsConf)
+	if tlsConf.MinVersion != tls.VersionTLS12 || tlsConf.MaxVersion != 0 {
+		t.Errorf("versions are not applied")
+	}
+	if len(tlsConf.CipherSuites) != 1 || tlsConf.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("cipher suites are not applied")
+	}
+
+	spec.CipherSuites = []string{"TLS_UNKNOWN"}
+	if spec.Validate() == nil {
+		t.Errorf("unknown cipher suite should be invalid")
+	}
+}
+
+func TestCertManagerStatus(t *testing.T) {
+	cert, key := createCertPEM(t, "www.example.com")
+	spec := &Spec{
+		HTTPS: true,
+		Certs: map[string]string{"a": cert},
+		Keys:  map[string]string{"a": key},
+		TLS:   &TLSSpec{MaxVersion: "1.2"},
+	}
+
+	cm := newCertManager()
+	defer cm.close()
+	cm.reload(spec)
+
+	serverConf, err := spec.tlsConfig()
+	if err != nil {
+		t.Fatalf("create tls config failed: %v", err)
+	}
+	cm.setup(serverConf)
+
+	handshake := func(clientConf *tls.Config) error {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- tls.Server(c1, serverConf).Handshake()
+		}()
+		err := tls.Client(c2, clientConf).Handshake()
+		if err != nil {
+			c2.Close()
+		}
+		<-errCh
+		return err
+	}
+
+	if err := handshake(&tls.Config{ServerName: "www.example.com", InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	if err := handshake(&tls.Config{ServerName: "www.example.com", MinVersion: tls.VersionTLS13}); err == nil {
+		t.Fatalf("handshake of TLS 1.3 should fail")
+	}
+
+	s := cm.status()
+	if s.Handshakes != 2 || s.Succeeded != 1 || s.Failed != 1 {
+		t.Errorf("unexpected statistics: %+v", s)
+	}
+	if s.Versions["TLS 1.2"] != 1 {
+		t.Errorf("there should be 1 TLS 1.2 connection, got %v", s.Versions)
+	}
+	if len(s.Certificates) != 1 || s.Certificates[0].Names[0] != "www.example.com" {
+		t.Errorf("unexpected certificates: %+v", s.Certificates)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkainput
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// headerTopic is the header of Kafka topic in requests to the pipeline.
+	headerTopic = "X-Kafka-Topic"
+	// headerPartition is the header of Kafka partition in requests to the pipeline.
+	headerPartition = "X-Kafka-Partition"
+	// headerOffset is the header of Kafka offset in requests to the pipeline.
+	headerOffset = "X-Kafka-Offset"
+	// headerKey is the header of Kafka message key in requests to the pipeline.
+	headerKey = "X-Kafka-Key"
+
+	consumePath = "/kafka/"
+)
+
+type (
+	// consumerHandler implements sarama.ConsumerGroupHandler, it feeds
+	// the messages into the pipeline.
+	consumerHandler struct {
+		pipeline    string
+		concurrency int
+		getPipeline pipelinetool.Getter
+
+		consumed  uint64
+		succeeded uint64
+		failed    uint64
+		lastError atomic.Value // string
+
+		mutex sync.Mutex
+		lag   map[string]int64
+	}
+
+	// Status is the status of KafkaInput.
+	Status struct {
+		// Consumed is the number of messages consumed from Kafka.
+		Consumed uint64 `yaml:"consumed"`
+		// Succeeded is the number of messages the pipeline responds 2xx.
+		Succeeded uint64 `yaml:"succeeded"`
+		// Failed is the number of messages failed to be handled by the pipeline.
+		Failed uint64 `yaml:"failed"`
+		// Lag is the number of messages waiting to be consumed, per
+		// partition of the topics in the format of topic/partition.
+		Lag map[string]int64 `yaml:"lag"`
+		// QueueLength is the sum of all lags, it could be used to scale
+		// the consumers.
+		QueueLength int64  `yaml:"queueLength"`
+		LastError   string `yaml:"lastError,omitempty"`
+	}
+)
+
+func newConsumerHandler(pipeline string, concurrency int, getPipeline pipelinetool.Getter) *consumerHandler {
+	h := &consumerHandler{
+		pipeline:    pipeline,
+		concurrency: concurrency,
+		getPipeline: getPipeline,
+		lag:         make(map[string]int64),
+	}
+	h.lastError.Store("")
+	return h
+}
+
+func partitionKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
+
+// Setup is run at the beginning of a new session.
+func (h *consumerHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session.
+func (h *consumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim consumes the messages of a partition. Up to concurrency
+// messages are handled concurrently as a batch, and the offset is marked
+// after the whole batch is handled, so no message is skipped when the
+// consumer restarts.
+func (h *consumerHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	key := partitionKey(claim.Topic(), claim.Partition())
+	defer h.removeLag(key)
+
+	batch := make([]*sarama.ConsumerMessage, 0, h.concurrency)
+	for msg := range claim.Messages() {
+		batch = append(batch[:0], msg)
+	fill:
+		for len(batch) < h.concurrency {
+			select {
+			case msg, ok := <-claim.Messages():
+				if !ok {
+					break fill
+				}
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		h.handleBatch(batch)
+
+		last := batch[len(batch)-1]
+		sess.MarkMessage(last, "")
+		h.setLag(key, claim.HighWaterMarkOffset()-last.Offset-1)
+	}
+
+	return nil
+}
+
+func (h *consumerHandler) handleBatch(batch []*sarama.ConsumerMessage) {
+	if len(batch) == 1 {
+		h.handleMessage(batch[0])
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(batch))
+	for _, msg := range batch {
+		go func(msg *sarama.ConsumerMessage) {
+			defer wg.Done()
+			h.handleMessage(msg)
+		}(msg)
+	}
+	wg.Wait()
+}
+
+// handleMessage handles the message by a request to the pipeline, the
+// path of the request is the topic under /kafka/, the body is the value,
+// and the headers of the message are copied to the request.
+func (h *consumerHandler) handleMessage(msg *sarama.ConsumerMessage) {
+	atomic.AddUint64(&h.consumed, 1)
+
+	err := h.feedPipeline(msg)
+	if err != nil {
+		logger.Errorf("handle message %s at offset %d failed: %v",
+			partitionKey(msg.Topic, msg.Partition), msg.Offset, err)
+		atomic.AddUint64(&h.failed, 1)
+		h.setLastError(err)
+		return
+	}
+	atomic.AddUint64(&h.succeeded, 1)
+}
+
+func (h *consumerHandler) feedPipeline(msg *sarama.ConsumerMessage) error {
+	req, err := http.NewRequest(http.MethodPost, consumePath+msg.Topic, bytes.NewReader(msg.Value))
+	if err != nil {
+		return fmt.Errorf("new request failed: %v", err)
+	}
+	for _, header := range msg.Headers {
+		if header != nil {
+			req.Header.Add(string(header.Key), string(header.Value))
+		}
+	}
+	req.Header.Set(headerTopic, msg.Topic)
+	req.Header.Set(headerPartition, strconv.Itoa(int(msg.Partition)))
+	req.Header.Set(headerOffset, strconv.FormatInt(msg.Offset, 10))
+	if msg.Key != nil {
+		req.Header.Set(headerKey, string(msg.Key))
+	}
+
+	ctx, err := pipelinetool.Handle(h.getPipeline, h.pipeline, req, "kafkainput")
+	if err != nil {
+		return err
+	}
+
+	code := ctx.Response().StatusCode()
+	if code < 200 || code >= 300 {
+		return fmt.Errorf("pipeline %s responds status code %d", h.pipeline, code)
+	}
+	return nil
+}
+
+func (h *consumerHandler) setLastError(err error) {
+	h.lastError.Store(err.Error())
+}
+
+func (h *consumerHandler) setLag(key string, lag int64) {
+	if lag < 0 {
+		lag = 0
+	}
+
+	h.mutex.Lock()
+	h.lag[key] = lag
+	h.mutex.Unlock()
+}
+
+func (h *consumerHandler) removeLag(key string) {
+	h.mutex.Lock()
+	delete(h.lag, key)
+	h.mutex.Unlock()
+}
+
+func (h *consumerHandler) status() *Status {
+	s := &Status{
+		Consumed:  atomic.LoadUint64(&h.consumed),
+		Succeeded: atomic.LoadUint64(&h.succeeded),
+		Failed:    atomic.LoadUint64(&h.failed),
+		Lag:       make(map[string]int64),
+		LastError: h.lastError.Load().(string),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for k, v := range h.lag {
+		s.Lag[k] = v
+		s.QueueLength += v
+	}
+
+	return s
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkainput
+
+import (
+	stdcontext "context"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// Category is the category of KafkaInput.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of KafkaInput.
+	Kind = "KafkaInput"
+
+	offsetOldest = "oldest"
+	offsetNewest = "newest"
+
+	defaultConcurrency = 1
+	retryInterval      = time.Second
+)
+
+// newConsumerGroup is for replacing the consumer group in testing.
+var newConsumerGroup = sarama.NewConsumerGroup
+
+func init() {
+	supervisor.Register(&KafkaInput{})
+}
+
+type (
+	// KafkaInput consumes messages from Kafka and feeds them into an HTTPPipeline.
+	KafkaInput struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		group   sarama.ConsumerGroup
+		handler *consumerHandler
+		cancel  stdcontext.CancelFunc
+		wg      sync.WaitGroup
+	}
+
+	// Spec describes the KafkaInput.
+	Spec struct {
+		Backend  []string `yaml:"backend" jsonschema:"required,uniqueItems=true"`
+		Topics   []string `yaml:"topics" jsonschema:"required,uniqueItems=true"`
+		GroupID  string   `yaml:"groupID" jsonschema:"required"`
+		Pipeline string   `yaml:"pipeline" jsonschema:"required"`
+
+		// InitialOffset is used when the group has no committed offset.
+		InitialOffset string `yaml:"initialOffset,omitempty" jsonschema:"omitempty,enum=oldest,enum=newest"`
+		// Concurrency is the number of messages handled concurrently
+		// for each partition.
+		Concurrency    int    `yaml:"concurrency,omitempty" jsonschema:"omitempty,minimum=1"`
+		CommitInterval string `yaml:"commitInterval" jsonschema:"omitempty,format=duration"`
+		SessionTimeout string `yaml:"sessionTimeout" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Category returns the category of KafkaInput.
+func (ki *KafkaInput) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of KafkaInput.
+func (ki *KafkaInput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of KafkaInput.
+func (ki *KafkaInput) DefaultSpec() interface{} {
+	return &Spec{
+		InitialOffset: offsetNewest,
+		Concurrency:   defaultConcurrency,
+	}
+}
+
+// Init initializes KafkaInput.
+func (ki *KafkaInput) Init(superSpec *supervisor.Spec) {
+	ki.superSpec, ki.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+
+	ki.reload(pipelinetool.NewGetter(superSpec.Super()))
+}
+
+// Inherit inherits previous generation of KafkaInput.
+func (ki *KafkaInput) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	ki.Init(superSpec)
+}
+
+func (ki *KafkaInput) consumerConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = ki.superSpec.Name()
+	config.Version = sarama.V1_0_0_0
+	config.Consumer.Return.Errors = true
+
+	if ki.spec.InitialOffset == offsetOldest {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	} else {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+
+	// NOTE: The durations have been checked in validation.
+	if ki.spec.CommitInterval != "" {
+		config.Consumer.Offsets.AutoCommit.Interval, _ = time.ParseDuration(ki.spec.CommitInterval)
+	}
+	if ki.spec.SessionTimeout != "" {
+		config.Consumer.Group.Session.Timeout, _ = time.ParseDuration(ki.spec.SessionTimeout)
+	}
+
+	return config
+}
+
+func (ki *KafkaInput) reload(getPipeline pipelinetool.Getter) {
+	concurrency := ki.spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	ki.handler = newConsumerHandler(ki.spec.Pipeline, concurrency, getPipeline)
+
+	group, err := newConsumerGroup(ki.spec.Backend, ki.spec.GroupID, ki.consumerConfig())
+	if err != nil {
+		logger.Errorf("%s: start kafka consumer group %s with %v failed: %v",
+			ki.superSpec.Name(), ki.spec.GroupID, ki.spec.Backend, err)
+		ki.handler.setLastError(err)
+		return
+	}
+	ki.group = group
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	ki.cancel = cancel
+
+	ki.wg.Add(2)
+	go ki.consume(ctx)
+	go ki.watchErrors()
+}
+
+// consume joins the consumer group and consumes messages until the
+// context is canceled, Consume returns when the group rebalances, so
+// it is called in a loop.
+func (ki *KafkaInput) consume(ctx stdcontext.Context) {
+	defer ki.wg.Done()
+
+	for {
+		err := ki.group.Consume(ctx, ki.spec.Topics, ki.handler)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("%s: consume topics %v failed: %v", ki.superSpec.Name(), ki.spec.Topics, err)
+			ki.handler.setLastError(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+func (ki *KafkaInput) watchErrors() {
+	defer ki.wg.Done()
+
+	for err := range ki.group.Errors() {
+		logger.Errorf("%s: kafka consumer group %s: %v", ki.superSpec.Name(), ki.spec.GroupID, err)
+		ki.handler.setLastError(err)
+	}
+}
+
+// Status returns the status of KafkaInput.
+func (ki *KafkaInput) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: ki.handler.status(),
+	}
+}
+
+// Close closes KafkaInput.
+func (ki *KafkaInput) Close() {
+	if ki.group == nil {
+		return
+	}
+
+	ki.cancel()
+	if err := ki.group.Close(); err != nil {
+		logger.Errorf("%s: close kafka consumer group failed: %v", ki.superSpec.Name(), err)
+	}
+	ki.wg.Wait()
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkainput
+
+import (
+	stdcontext "context"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocol"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type mockPipeline func(ctx context.HTTPContext)
+
+func (p mockPipeline) Handle(ctx context.HTTPContext) {
+	p(ctx)
+}
+
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	mutex  sync.Mutex
+	marked []int64
+}
+
+func (s *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mutex.Lock()
+	s.marked = append(s.marked, msg.Offset)
+	s.mutex.Unlock()
+}
+
+func (s *mockSession) Context() stdcontext.Context {
+	return stdcontext.Background()
+}
+
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+	hwm      int64
+}
+
+func (c *mockClaim) Topic() string                            { return "orders" }
+func (c *mockClaim) Partition() int32                         { return 3 }
+func (c *mockClaim) HighWaterMarkOffset() int64               { return c.hwm }
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeClaim(t *testing.T) {
+	var mutex sync.Mutex
+	bodies := map[string]string{}
+	pipelines := map[string]protocol.HTTPHandler{
+		"consumer": mockPipeline(func(ctx context.HTTPContext) {
+			r := ctx.Request()
+			if r.Path() != "/kafka/orders" || r.Header().Get(headerTopic) != "orders" ||
+				r.Header().Get(headerPartition) != "3" || r.Header().Get("Trace-Id") != "abc" {
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+				return
+			}
+			body, _ := io.ReadAll(r.Body())
+			if string(body) == "bad" {
+				ctx.Response().SetStatusCode(http.StatusInternalServerError)
+				return
+			}
+			mutex.Lock()
+			bodies[r.Header().Get(headerOffset)] = string(body)
+			mutex.Unlock()
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	claim := &mockClaim{messages: make(chan *sarama.ConsumerMessage, 10), hwm: 10}
+	values := []string{"a", "b", "bad", "c", "d"}
+	for i, v := range values {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:     "orders",
+			Partition: 3,
+			Offset:    int64(i),
+			Key:       []byte("key"),
+			Value:     []byte(v),
+			Headers:   []*sarama.RecordHeader{{Key: []byte("Trace-Id"), Value: []byte("abc")}},
+		}
+	}
+	close(claim.messages)
+
+	h := newConsumerHandler("consumer", 2, getPipeline)
+	sess := &mockSession{}
+	if err := h.ConsumeClaim(sess, claim); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(bodies) != 4 || bodies["0"] != "a" || bodies["4"] != "d" {
+		t.Errorf("unexpected messages handled by pipeline: %v", bodies)
+	}
+	if len(sess.marked) == 0 || sess.marked[len(sess.marked)-1] != 4 {
+		t.Errorf("offset of the last message should be marked, got %v", sess.marked)
+	}
+
+	s := h.status()
+	if s.Consumed != 5 || s.Succeeded != 4 || s.Failed != 1 || s.LastError == "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	if len(s.Lag) != 0 {
+		t.Errorf("lag of the partition should be removed, got %v", s.Lag)
+	}
+}
+
+func TestLag(t *testing.T) {
+	h := newConsumerHandler("consumer", 1, nil)
+	claim := &mockClaim{messages: make(chan *sarama.ConsumerMessage, 1), hwm: 100}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Partition: 3, Offset: 89}
+
+	sess := &mockSession{}
+	done := make(chan struct{})
+	go func() {
+		h.ConsumeClaim(sess, claim)
+		close(done)
+	}()
+
+	var s *Status
+	for i := 0; i < 100; i++ {
+		if s = h.status(); s.QueueLength == 10 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Lag[partitionKey("orders", 3)] != 10 || s.Consumed != 1 || s.Failed != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	close(claim.messages)
+	<-done
+}
@@ -18,11 +18,15 @@
 package mqttproxy
 
 import (
+	"encoding/base64"
 	"fmt"
+	"time"
 
 	"github.com/Shopify/sarama"
+	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
 )
 
 type (
@@ -39,20 +43,34 @@ type (
 		done     chan struct{}
 	}
 
+	// mqttMQ is backend message queue for MQTT proxy by forwarding the
+	// messages to an MQTT broker
+	mqttMQ struct {
+		client paho.Client
+	}
+
 	testMQ struct {
 		ch chan *packets.PublishPacket
 	}
 )
 
 const (
-	kafkaType  = "Kafka"
-	testMQType = "TestMQ"
+	kafkaType    = "Kafka"
+	mqttType     = "MQTT"
+	pipelineType = "Pipeline"
+	testMQType   = "TestMQ"
+
+	mqttPublishTimeout = 10 * time.Second
 )
 
-func newBackendMQ(spec *Spec) backendMQ {
+func newBackendMQ(spec *Spec, getPipeline pipelinetool.Getter) backendMQ {
 	switch spec.BackendType {
 	case kafkaType:
 		return newKafkaMQ(spec)
+	case mqttType:
+		return newMQTTMQ(spec)
+	case pipelineType:
+		return newPipelineMQ(spec, getPipeline)
 	case testMQType:
 		t := &testMQ{}
 		t.ch = make(chan *packets.PublishPacket, 100)
@@ -132,6 +150,50 @@ func (k *KafkaMQ) close() {
 	}
 }
 
+func newMQTTMQ(spec *Spec) *mqttMQ {
+	opts := paho.NewClientOptions()
+	for _, s := range spec.MQTTBroker.Servers {
+		opts.AddBroker(s)
+	}
+	clientID := spec.MQTTBroker.ClientID
+	if clientID == "" {
+		clientID = spec.EGName + "-" + spec.Name
+	}
+	opts.SetClientID(clientID)
+	opts.SetUsername(spec.MQTTBroker.UserName)
+	if spec.MQTTBroker.PassBase64 != "" {
+		passwd, err := base64.StdEncoding.DecodeString(spec.MQTTBroker.PassBase64)
+		if err != nil {
+			logger.Errorf("decode base64 password of mqtt broker failed: %v", err)
+			return nil
+		}
+		opts.SetPassword(string(passwd))
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+
+	client := paho.NewClient(opts)
+	// NOTE: The client keeps retrying to connect in background.
+	client.Connect()
+	return &mqttMQ{client: client}
+}
+
+func (m *mqttMQ) publish(p *packets.PublishPacket) error {
+	logger.Debugf("forward msg with topic %s to mqtt broker", p.TopicName)
+	token := m.client.Publish(p.TopicName, p.Qos, p.Retain, p.Payload)
+	if p.Qos == QoS0 {
+		return nil
+	}
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("publish to mqtt broker timeout")
+	}
+	return token.Error()
+}
+
+func (m *mqttMQ) close() {
+	m.client.Disconnect(250)
+}
+
 func (t *testMQ) publish(p *packets.PublishPacket) error {
 	t.ch <- p
 	return nil
@@ -32,6 +32,7 @@ import (
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/api"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
 )
 
 type (
@@ -52,6 +53,10 @@ type (
 		topicMgr  *TopicManager
 		memberURL func(string, string) ([]string, error)
 
+		// getPipeline is for authenticating clients and publishing
+		// messages by pipelines, it could be nil.
+		getPipeline pipelinetool.Getter
+
 		// done is the channel for shutdowning this proxy.
 		done chan struct{}
 	}
@@ -72,15 +77,23 @@ func sha256Sum(data []byte) string {
 }
 
 func newBroker(spec *Spec, store storage, memberURL func(string, string) ([]string, error)) *Broker {
+	return newBrokerWithPipelines(spec, store, memberURL, nil)
+}
+
+// newBrokerWithPipelines creates a broker which could authenticate
+// clients by pipelines and publish messages into pipelines.
+func newBrokerWithPipelines(spec *Spec, store storage, memberURL func(string, string) ([]string, error),
+	getPipeline pipelinetool.Getter) *Broker {
 	broker := &Broker{
-		egName:     spec.EGName,
-		name:       spec.Name,
-		spec:       spec,
-		backend:    newBackendMQ(spec),
-		clients:    make(map[string]*Client),
-		sha256Auth: make(map[string]string),
-		memberURL:  memberURL,
-		done:       make(chan struct{}),
+		egName:      spec.EGName,
+		name:        spec.Name,
+		spec:        spec,
+		backend:     newBackendMQ(spec, getPipeline),
+		getPipeline: getPipeline,
+		clients:     make(map[string]*Client),
+		sha256Auth:  make(map[string]string),
+		memberURL:   memberURL,
+		done:        make(chan struct{}),
 	}
 
 	for _, a := range spec.Auth {
@@ -91,7 +104,7 @@ func newBroker(spec *Spec, store storage, memberURL func(string, string) ([]stri
 		}
 		broker.sha256Auth[a.UserName] = sha256Sum(passwd)
 	}
-	if len(broker.sha256Auth) == 0 {
+	if len(broker.sha256Auth) == 0 && spec.AuthPipeline == "" {
 		logger.Errorf("empty valid auth for mqtt proxy")
 		return nil
 	}
@@ -151,7 +164,11 @@ func (b *Broker) run() {
 	}
 }
 
-func (b *Broker) checkClientAuth(connect *packets.ConnectPacket) bool {
+func (b *Broker) checkClientAuth(connect *packets.ConnectPacket, remoteAddr net.Addr) bool {
+	if b.spec.AuthPipeline != "" {
+		return b.checkClientAuthByPipeline(connect, remoteAddr)
+	}
+
 	cid := connect.ClientIdentifier
 	name := connect.Username
 	sha256Passwd := sha256Sum(connect.Password)
@@ -184,7 +201,7 @@ func (b *Broker) handleConn(conn net.Conn) {
 		return
 	}
 
-	if !b.checkClientAuth(connect) {
+	if !b.checkClientAuth(connect, conn.RemoteAddr()) {
 		connack.ReturnCode = packets.ErrRefusedNotAuthorised
 		err = connack.Write(conn)
 		logger.Errorf("invalid connection %v, connack back failed: %s", connack.ReturnCode, err)
@@ -151,13 +151,13 @@ func TestKafka(t *testing.T) {
 		Kafka: &KafkaSpec{
 			Backend: []string{"localhost:1234"},
 		},
-	})
+	}, nil)
 	if k.(*KafkaMQ) != nil {
 		t.Errorf("should return nil for invalid broker address, %v", k)
 	}
 	k = newBackendMQ(&Spec{
 		BackendType: "FakeType",
-	})
+	}, nil)
 	if k != nil {
 		t.Errorf("should return nil for invalid wrong type")
 	}
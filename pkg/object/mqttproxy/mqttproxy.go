@@ -25,6 +25,7 @@ import (
 	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
 	"gopkg.in/yaml.v2"
 )
 
@@ -132,7 +133,8 @@ func (mp *MQTTProxy) Init(superSpec *supervisor.Spec) {
 	mp.superSpec, mp.spec = superSpec, spec
 
 	store := newStorage(superSpec.Super().Cluster())
-	mp.broker = newBroker(spec, store, memberURLFunc(superSpec))
+	getPipeline := pipelinetool.NewGetter(superSpec.Super())
+	mp.broker = newBrokerWithPipelines(spec, store, memberURLFunc(superSpec), getPipeline)
 	if mp.broker != nil {
 		mp.broker.registerAPIs()
 	}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// headerClientID is the header of MQTT client ID in requests to pipelines.
+	headerClientID = "X-Mqtt-Client-Id"
+	// headerTopic is the header of MQTT topic in requests to pipelines.
+	headerTopic = "X-Mqtt-Topic"
+	// headerQoS is the header of MQTT QoS in requests to pipelines.
+	headerQoS = "X-Mqtt-Qos"
+	// headerRetain is the header of MQTT retain flag in requests to pipelines.
+	headerRetain = "X-Mqtt-Retain"
+
+	authPath    = "/mqtt/connect"
+	publishPath = "/mqtt/publish/"
+)
+
+type (
+	// pipelineMQ is backend of MQTT proxy which publishes the messages
+	// into an HTTPPipeline.
+	pipelineMQ struct {
+		name        string
+		getPipeline pipelinetool.Getter
+	}
+)
+
+// handleByPipeline handles the request by the pipeline, and returns
+// the status code of the response.
+func handleByPipeline(getPipeline pipelinetool.Getter, name string, req *http.Request) (int, error) {
+	ctx, err := pipelinetool.Handle(getPipeline, name, req, "mqttproxy")
+	if err != nil {
+		return 0, err
+	}
+	return ctx.Response().StatusCode(), nil
+}
+
+func isSuccess(code int) bool {
+	return code >= 200 && code < 300
+}
+
+// checkClientAuthByPipeline authenticates the client by the auth pipeline,
+// the username and password are sent by basic auth, and the client is
+// authenticated if the pipeline responds 2xx status code.
+func (b *Broker) checkClientAuthByPipeline(connect *packets.ConnectPacket, remoteAddr net.Addr) bool {
+	if connect.ClientIdentifier == "" {
+		return false
+	}
+
+	req, err := http.NewRequest(http.MethodPost, authPath, nil)
+	if err != nil {
+		logger.Errorf("BUG: new auth request failed: %v", err)
+		return false
+	}
+	req.RemoteAddr = remoteAddr.String()
+	req.SetBasicAuth(connect.Username, string(connect.Password))
+	req.Header.Set(headerClientID, connect.ClientIdentifier)
+
+	code, err := handleByPipeline(b.getPipeline, b.spec.AuthPipeline, req)
+	if err != nil {
+		logger.Errorf("auth client %s failed: %v", connect.ClientIdentifier, err)
+		return false
+	}
+	return isSuccess(code)
+}
+
+func newPipelineMQ(spec *Spec, getPipeline pipelinetool.Getter) *pipelineMQ {
+	return &pipelineMQ{
+		name:        spec.Pipeline,
+		getPipeline: getPipeline,
+	}
+}
+
+// publish publishes the message by a request to the pipeline, the path
+// of the request is the topic under /mqtt/publish/, and the body is the
+// payload.
+func (p *pipelineMQ) publish(packet *packets.PublishPacket) error {
+	req, err := http.NewRequest(http.MethodPost, publishPath+packet.TopicName, bytes.NewReader(packet.Payload))
+	if err != nil {
+		return fmt.Errorf("new publish request failed: %v", err)
+	}
+	req.Header.Set(headerTopic, packet.TopicName)
+	req.Header.Set(headerQoS, strconv.Itoa(int(packet.Qos)))
+	req.Header.Set(headerRetain, strconv.FormatBool(packet.Retain))
+
+	code, err := handleByPipeline(p.getPipeline, p.name, req)
+	if err != nil {
+		return err
+	}
+	if !isSuccess(code) {
+		return fmt.Errorf("pipeline %s responds status code %d", p.name, code)
+	}
+	return nil
+}
+
+func (p *pipelineMQ) close() {
+}
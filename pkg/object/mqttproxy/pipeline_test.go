@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
+)
+
+type mockPipeline func(ctx context.HTTPContext)
+
+func (p mockPipeline) Handle(ctx context.HTTPContext) {
+	p(ctx)
+}
+
+func TestPipelineAuth(t *testing.T) {
+	pipelines := map[string]protocol.HTTPHandler{
+		"auth": mockPipeline(func(ctx context.HTTPContext) {
+			user, passwd, ok := ctx.Request().Std().BasicAuth()
+			cid := ctx.Request().Header().Get(headerClientID)
+			if !ok || user != "test" || passwd != "secret" || cid == "" {
+				ctx.Response().SetStatusCode(http.StatusUnauthorized)
+			}
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	spec := &Spec{
+		Name:         "test",
+		EGName:       "test",
+		Port:         1883,
+		BackendType:  testMQType,
+		AuthPipeline: "auth",
+	}
+	broker := newBrokerWithPipelines(spec, newStorage(nil), nil, getPipeline)
+	if broker == nil {
+		t.Fatal("broker should be created without static auth")
+	}
+	defer broker.close()
+
+	c1 := getMQTTClient(t, "test", "test", "secret", true)
+	c1.Disconnect(200)
+
+	o2 := paho.NewClientOptions().AddBroker("tcp://0.0.0.0:1883").SetClientID("test").SetUsername("test").SetPassword("wrong")
+	c2 := paho.NewClient(o2)
+	if token := c2.Connect(); token.Wait() && token.Error() == nil {
+		t.Error("client with wrong password should fail")
+	}
+	c2.Disconnect(200)
+}
+
+func TestPipelineMQ(t *testing.T) {
+	var payload, topic, path string
+	pipelines := map[string]protocol.HTTPHandler{
+		"ingest": mockPipeline(func(ctx context.HTTPContext) {
+			body, _ := io.ReadAll(ctx.Request().Body())
+			payload = string(body)
+			topic = ctx.Request().Header().Get(headerTopic)
+			path = ctx.Request().Path()
+			if topic == "reject" {
+				ctx.Response().SetStatusCode(http.StatusForbidden)
+			}
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	mq := newBackendMQ(&Spec{BackendType: pipelineType, Pipeline: "ingest"}, getPipeline)
+
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = "device/1/temperature"
+	p.Payload = []byte("25.5")
+	if err := mq.publish(p); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	if payload != "25.5" || topic != p.TopicName || path != publishPath+p.TopicName {
+		t.Errorf("unexpected request: %s, %s, %s", payload, topic, path)
+	}
+
+	p.TopicName = "reject"
+	if err := mq.publish(p); err == nil {
+		t.Error("publish should fail for non 2xx status code")
+	}
+
+	mq = newBackendMQ(&Spec{BackendType: pipelineType, Pipeline: "none"}, getPipeline)
+	if err := mq.publish(p); err == nil {
+		t.Error("publish should fail for non-existing pipeline")
+	}
+	mq.close()
+}
@@ -34,10 +34,13 @@ type (
 		EGName         string        `yaml:"-"`
 		Name           string        `yaml:"-"`
 		Port           uint16        `yaml:"port" jsonschema:"required"`
-		BackendType    string        `yaml:"backendType" jsonschema:"required"`
-		Auth           []Auth        `yaml:"auth" jsonschema:"required"`
+		BackendType    string        `yaml:"backendType" jsonschema:"required,enum=Kafka,enum=MQTT,enum=Pipeline,enum=TestMQ"`
+		Auth           []Auth        `yaml:"auth" jsonschema:"omitempty"`
+		AuthPipeline   string        `yaml:"authPipeline" jsonschema:"omitempty"`
 		TopicMapper    *TopicMapper  `yaml:"topicMapper" jsonschema:"omitempty"`
 		Kafka          *KafkaSpec    `yaml:"kafkaBroker" jsonschema:"omitempty"`
+		MQTTBroker     *MQTTBroker   `yaml:"mqttBroker,omitempty" jsonschema:"omitempty"`
+		Pipeline       string        `yaml:"pipeline" jsonschema:"omitempty"`
 		UseTLS         bool          `yaml:"useTLS" jsonschema:"omitempty"`
 		Certificate    []Certificate `yaml:"certificate" jsonschema:"omitempty"`
 		TopicCacheSize int           `yaml:"topicCacheSize" jsonschema:"omitempty"`
@@ -85,8 +88,41 @@ type (
 	KafkaSpec struct {
 		Backend []string `yaml:"backend" jsonschema:"required,uniqueItems=true"`
 	}
+
+	// MQTTBroker describes the backend MQTT broker which messages are
+	// forwarded to
+	MQTTBroker struct {
+		Servers    []string `yaml:"servers" jsonschema:"required,uniqueItems=true"`
+		ClientID   string   `yaml:"clientID" jsonschema:"omitempty"`
+		UserName   string   `yaml:"userName" jsonschema:"omitempty"`
+		PassBase64 string   `yaml:"passBase64" jsonschema:"omitempty,format=base64"`
+	}
 )
 
+// Validate validates Spec.
+func (spec Spec) Validate() error {
+	if len(spec.Auth) == 0 && spec.AuthPipeline == "" {
+		return fmt.Errorf("auth or authPipeline is required")
+	}
+
+	switch spec.BackendType {
+	case kafkaType:
+		if spec.Kafka == nil {
+			return fmt.Errorf("kafkaBroker is required for backend type %s", kafkaType)
+		}
+	case mqttType:
+		if spec.MQTTBroker == nil {
+			return fmt.Errorf("mqttBroker is required for backend type %s", mqttType)
+		}
+	case pipelineType:
+		if spec.Pipeline == "" {
+			return fmt.Errorf("pipeline is required for backend type %s", pipelineType)
+		}
+	}
+
+	return nil
+}
+
 func (spec *Spec) tlsConfig() (*tls.Config, error) {
 	var certificates []tls.Certificate
 
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsinput
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// headerSubject is the header of NATS subject in requests to the pipeline.
+	headerSubject = "X-Nats-Subject"
+
+	consumePath = "/nats/"
+)
+
+type (
+	// msgHandler feeds the messages into the pipeline.
+	msgHandler struct {
+		pipeline    string
+		jetStream   bool
+		getPipeline pipelinetool.Getter
+
+		consumed  uint64
+		succeeded uint64
+		failed    uint64
+		lastError atomic.Value // string
+	}
+
+	// Status is the status of NATSInput.
+	Status struct {
+		// Consumed is the number of messages received.
+		Consumed uint64 `yaml:"consumed"`
+		// Succeeded is the number of messages the pipeline responds 2xx.
+		Succeeded uint64 `yaml:"succeeded"`
+		// Failed is the number of messages failed to be handled by the pipeline.
+		Failed uint64 `yaml:"failed"`
+		// QueueLength is the number of messages waiting to be handled,
+		// it could be used to scale the subscribers.
+		QueueLength int64  `yaml:"queueLength"`
+		Connected   bool   `yaml:"connected"`
+		LastError   string `yaml:"lastError,omitempty"`
+	}
+)
+
+func newMsgHandler(pipeline string, jetStream bool, getPipeline pipelinetool.Getter) *msgHandler {
+	h := &msgHandler{
+		pipeline:    pipeline,
+		jetStream:   jetStream,
+		getPipeline: getPipeline,
+	}
+	h.lastError.Store("")
+	return h
+}
+
+// handleMsg handles the message by a request to the pipeline. Messages
+// of JetStream are acknowledged if the pipeline responds 2xx, otherwise
+// they are redelivered. For requests of core NATS, the response body of
+// the pipeline is the reply.
+func (h *msgHandler) handleMsg(msg *nats.Msg) {
+	atomic.AddUint64(&h.consumed, 1)
+
+	reply, err := h.feedPipeline(msg)
+	if err != nil {
+		logger.Errorf("handle message of %s failed: %v", msg.Subject, err)
+		atomic.AddUint64(&h.failed, 1)
+		h.setLastError(err)
+		if h.jetStream {
+			if err = msg.Nak(); err != nil {
+				h.setLastError(fmt.Errorf("nak message failed: %v", err))
+			}
+		}
+		return
+	}
+
+	atomic.AddUint64(&h.succeeded, 1)
+	if h.jetStream {
+		err = msg.Ack()
+	} else if msg.Reply != "" {
+		err = msg.Respond(reply)
+	}
+	if err != nil {
+		h.setLastError(fmt.Errorf("ack or reply message failed: %v", err))
+	}
+}
+
+// feedPipeline sends a request to the pipeline, the path of the request
+// is the subject under /nats/, the body is the data and the headers of
+// the message are copied to the request.
+func (h *msgHandler) feedPipeline(msg *nats.Msg) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, consumePath+msg.Subject, bytes.NewReader(msg.Data))
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %v", err)
+	}
+	for k, vs := range msg.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set(headerSubject, msg.Subject)
+
+	ctx, err := pipelinetool.Handle(h.getPipeline, h.pipeline, req, "natsinput")
+	if err != nil {
+		return nil, err
+	}
+
+	code := ctx.Response().StatusCode()
+	if code < 200 || code >= 300 {
+		return nil, fmt.Errorf("pipeline %s responds status code %d", h.pipeline, code)
+	}
+
+	body := ctx.Response().Body()
+	if body == nil {
+		return nil, nil
+	}
+	return io.ReadAll(body)
+}
+
+func (h *msgHandler) setLastError(err error) {
+	h.lastError.Store(err.Error())
+}
+
+func (h *msgHandler) status() *Status {
+	return &Status{
+		Consumed:  atomic.LoadUint64(&h.consumed),
+		Succeeded: atomic.LoadUint64(&h.succeeded),
+		Failed:    atomic.LoadUint64(&h.failed),
+		LastError: h.lastError.Load().(string),
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsinput
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/pipelinetool"
+)
+
+const (
+	// Category is the category of NATSInput.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of NATSInput.
+	Kind = "NATSInput"
+
+	deliverAll = "all"
+	deliverNew = "new"
+
+	defaultConcurrency = 1
+	// bufferSize is the number of messages buffered for each worker.
+	bufferSize    = 64
+	retryInterval = time.Second
+)
+
+func init() {
+	supervisor.Register(&NATSInput{})
+}
+
+type (
+	// NATSInput subscribes to NATS or NATS JetStream, and feeds the
+	// messages into an HTTPPipeline.
+	NATSInput struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		conn    *nats.Conn
+		mutex   sync.Mutex
+		sub     *nats.Subscription
+		msgs    chan *nats.Msg
+		handler *msgHandler
+		done    chan struct{}
+		wg      sync.WaitGroup
+	}
+
+	// Spec describes the NATSInput.
+	Spec struct {
+		Servers  []string `yaml:"servers" jsonschema:"required,uniqueItems=true"`
+		Subject  string   `yaml:"subject" jsonschema:"required"`
+		Pipeline string   `yaml:"pipeline" jsonschema:"required"`
+		// QueueGroup distributes the messages among the subscribers of
+		// the same group, instead of delivering to all of them.
+		QueueGroup  string         `yaml:"queueGroup" jsonschema:"omitempty"`
+		Concurrency int            `yaml:"concurrency,omitempty" jsonschema:"omitempty,minimum=1"`
+		JetStream   *JetStreamSpec `yaml:"jetStream,omitempty" jsonschema:"omitempty"`
+	}
+
+	// JetStreamSpec describes the JetStream consumer, the messages are
+	// acknowledged after they are handled, which makes at-least-once
+	// delivery.
+	JetStreamSpec struct {
+		Durable       string `yaml:"durable" jsonschema:"omitempty"`
+		DeliverPolicy string `yaml:"deliverPolicy,omitempty" jsonschema:"omitempty,enum=all,enum=new"`
+		AckWait       string `yaml:"ackWait" jsonschema:"omitempty,format=duration"`
+		MaxDeliver    int    `yaml:"maxDeliver" jsonschema:"omitempty,minimum=0"`
+		MaxAckPending int    `yaml:"maxAckPending" jsonschema:"omitempty,minimum=0"`
+	}
+)
+
+// Category returns the category of NATSInput.
+func (ni *NATSInput) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of NATSInput.
+func (ni *NATSInput) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of NATSInput.
+func (ni *NATSInput) DefaultSpec() interface{} {
+	return &Spec{
+		Concurrency: defaultConcurrency,
+	}
+}
+
+// Init initializes NATSInput.
+func (ni *NATSInput) Init(superSpec *supervisor.Spec) {
+	ni.superSpec, ni.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+
+	ni.reload(pipelinetool.NewGetter(superSpec.Super()))
+}
+
+// Inherit inherits previous generation of NATSInput.
+func (ni *NATSInput) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	ni.Init(superSpec)
+}
+
+func (ni *NATSInput) subOptions() []nats.SubOpt {
+	spec := ni.spec.JetStream
+	opts := []nats.SubOpt{nats.ManualAck()}
+	if spec.Durable != "" {
+		opts = append(opts, nats.Durable(spec.Durable))
+	}
+	if spec.DeliverPolicy == deliverAll {
+		opts = append(opts, nats.DeliverAll())
+	} else {
+		opts = append(opts, nats.DeliverNew())
+	}
+	if spec.AckWait != "" {
+		// NOTE: The duration has been checked in validation.
+		d, _ := time.ParseDuration(spec.AckWait)
+		opts = append(opts, nats.AckWait(d))
+	}
+	if spec.MaxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(spec.MaxDeliver))
+	}
+	if spec.MaxAckPending > 0 {
+		opts = append(opts, nats.MaxAckPending(spec.MaxAckPending))
+	}
+	return opts
+}
+
+func (ni *NATSInput) reload(getPipeline pipelinetool.Getter) {
+	concurrency := ni.spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	ni.handler = newMsgHandler(ni.spec.Pipeline, ni.spec.JetStream != nil, getPipeline)
+	ni.msgs = make(chan *nats.Msg, concurrency*bufferSize)
+	ni.done = make(chan struct{})
+
+	ni.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go ni.work()
+	}
+
+	// NOTE: The client reconnects the servers in background forever,
+	// and resubscribes the subject after reconnecting.
+	conn, err := nats.Connect(strings.Join(ni.spec.Servers, ","),
+		nats.Name(ni.superSpec.Name()),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+	)
+	if err != nil {
+		ni.failed("connect nats servers %v failed: %v", ni.spec.Servers, err)
+		return
+	}
+	ni.conn = conn
+
+	ni.wg.Add(1)
+	go ni.subscribe()
+}
+
+// subscribe subscribes the subject until success, subscribing JetStream
+// fails if the servers are not connected.
+func (ni *NATSInput) subscribe() {
+	defer ni.wg.Done()
+
+	for {
+		sub, err := ni.trySubscribe()
+		if err == nil {
+			ni.mutex.Lock()
+			ni.sub = sub
+			ni.mutex.Unlock()
+			return
+		}
+
+		ni.failed("subscribe %s failed: %v", ni.spec.Subject, err)
+		select {
+		case <-ni.done:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (ni *NATSInput) trySubscribe() (*nats.Subscription, error) {
+	if ni.spec.JetStream == nil {
+		if ni.spec.QueueGroup == "" {
+			return ni.conn.ChanSubscribe(ni.spec.Subject, ni.msgs)
+		}
+		return ni.conn.ChanQueueSubscribe(ni.spec.Subject, ni.spec.QueueGroup, ni.msgs)
+	}
+
+	js, err := ni.conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("create jetstream context failed: %v", err)
+	}
+	if ni.spec.QueueGroup == "" {
+		return js.ChanSubscribe(ni.spec.Subject, ni.msgs, ni.subOptions()...)
+	}
+	return js.ChanQueueSubscribe(ni.spec.Subject, ni.spec.QueueGroup, ni.msgs, ni.subOptions()...)
+}
+
+func (ni *NATSInput) failed(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	logger.Errorf("%s: %v", ni.superSpec.Name(), err)
+	ni.handler.setLastError(err)
+}
+
+func (ni *NATSInput) work() {
+	defer ni.wg.Done()
+
+	for {
+		select {
+		case <-ni.done:
+			return
+		case msg := <-ni.msgs:
+			ni.handler.handleMsg(msg)
+		}
+	}
+}
+
+// queueLength returns the number of messages buffered in the client,
+// and the messages not delivered yet by JetStream.
+func (ni *NATSInput) queueLength() int64 {
+	n := int64(len(ni.msgs))
+
+	ni.mutex.Lock()
+	sub := ni.sub
+	ni.mutex.Unlock()
+	if ni.spec.JetStream == nil || sub == nil {
+		return n
+	}
+
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return n
+	}
+	return n + int64(info.NumPending)
+}
+
+// Status returns the status of NATSInput.
+func (ni *NATSInput) Status() *supervisor.Status {
+	s := ni.handler.status()
+	s.QueueLength = ni.queueLength()
+	s.Connected = ni.conn != nil && ni.conn.IsConnected()
+	return &supervisor.Status{
+		ObjectStatus: s,
+	}
+}
+
+// Close closes NATSInput.
+func (ni *NATSInput) Close() {
+	// NOTE: The buffered messages are left, JetStream redelivers them
+	// after the ack wait since they are not acknowledged.
+	close(ni.done)
+	ni.wg.Wait()
+
+	if ni.sub != nil {
+		if err := ni.sub.Unsubscribe(); err != nil {
+			logger.Errorf("%s: unsubscribe %s failed: %v", ni.superSpec.Name(), ni.spec.Subject, err)
+		}
+	}
+
+	if ni.conn != nil {
+		ni.conn.Close()
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsinput
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocol"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type mockPipeline func(ctx context.HTTPContext)
+
+func (p mockPipeline) Handle(ctx context.HTTPContext) {
+	p(ctx)
+}
+
+func TestHandleMsg(t *testing.T) {
+	pipelines := map[string]protocol.HTTPHandler{
+		"consumer": mockPipeline(func(ctx context.HTTPContext) {
+			r := ctx.Request()
+			body, _ := io.ReadAll(r.Body())
+			if r.Path() != "/nats/orders.created" || r.Header().Get(headerSubject) != "orders.created" ||
+				r.Header().Get("Trace-Id") != "abc" || string(body) != "{}" {
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+			}
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	newMsg := func(data string) *nats.Msg {
+		msg := nats.NewMsg("orders.created")
+		msg.Header.Set("Trace-Id", "abc")
+		msg.Data = []byte(data)
+		return msg
+	}
+
+	h := newMsgHandler("consumer", false, getPipeline)
+	h.handleMsg(newMsg("{}"))
+	h.handleMsg(newMsg("invalid"))
+
+	s := h.status()
+	if s.Consumed != 2 || s.Succeeded != 1 || s.Failed != 1 || s.LastError == "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	h = newMsgHandler("not-exist", false, getPipeline)
+	h.handleMsg(newMsg("{}"))
+	if s = h.status(); s.Failed != 1 {
+		t.Errorf("message should fail without pipeline, got %+v", s)
+	}
+}
+
+func TestSubOptions(t *testing.T) {
+	ni := &NATSInput{spec: &Spec{
+		JetStream: &JetStreamSpec{},
+	}}
+	if n := len(ni.subOptions()); n != 2 {
+		t.Errorf("there should be 2 options by default, got %d", n)
+	}
+
+	ni.spec.JetStream = &JetStreamSpec{
+		Durable:       "easegress",
+		DeliverPolicy: deliverAll,
+		AckWait:       "30s",
+		MaxDeliver:    5,
+		MaxAckPending: 100,
+	}
+	if n := len(ni.subOptions()); n != 6 {
+		t.Errorf("there should be 6 options, got %d", n)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// proxyProtocolV2Signature is the signature of PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolHeader builds the PROXY protocol header of the version
+// for the connection from src to dst.
+func proxyProtocolHeader(version string, src, dst net.Addr) []byte {
+	srcAddr, ok1 := src.(*net.TCPAddr)
+	dstAddr, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		srcAddr, dstAddr = nil, nil
+	}
+
+	if version == ProxyProtocolV2 {
+		return proxyProtocolV2Header(srcAddr, dstAddr)
+	}
+	return proxyProtocolV1Header(srcAddr, dstAddr)
+}
+
+func proxyProtocolV1Header(src, dst *net.TCPAddr) []byte {
+	if src == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	proto := "TCP6"
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		proto = "TCP4"
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.IP, dst.IP, src.Port, dst.Port))
+}
+
+func proxyProtocolV2Header(src, dst *net.TCPAddr) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(proxyProtocolV2Signature)
+
+	if src == nil {
+		// Version 2 and command LOCAL, the address family is UNSPEC.
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return buf.Bytes()
+	}
+
+	// Version 2 and command PROXY.
+	buf.WriteByte(0x21)
+
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP != nil && dstIP != nil {
+		// TCP over IPv4.
+		buf.WriteByte(0x11)
+		binary.Write(buf, binary.BigEndian, uint16(12))
+	} else {
+		// TCP over IPv6.
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		buf.WriteByte(0x21)
+		binary.Write(buf, binary.BigEndian, uint16(36))
+	}
+
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(buf, binary.BigEndian, uint16(src.Port))
+	binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+
+	return buf.Bytes()
+}
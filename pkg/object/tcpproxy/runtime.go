@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpproxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/graceupdate"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const copyBufferSize = 32 * 1024
+
+type (
+	runtime struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		connectTimeout time.Duration
+		idleTimeout    time.Duration
+
+		listener *limitlistener.LimitListener
+		servers  []*server
+		counter  uint64
+
+		activeConnections int64
+		totalConnections  uint64
+		failedConnections uint64
+		bytesIn           uint64
+		bytesOut          uint64
+	}
+
+	server struct {
+		addr        string
+		connections int64
+	}
+
+	// Status is the status of TCPProxy.
+	Status struct {
+		// ActiveConnections is the number of client connections being proxied.
+		ActiveConnections int64 `yaml:"activeConnections"`
+		// TotalConnections is the total number of accepted client connections.
+		TotalConnections uint64 `yaml:"totalConnections"`
+		// FailedConnections is the number of client connections failed to
+		// connect to the servers.
+		FailedConnections uint64 `yaml:"failedConnections"`
+		// BytesIn is the number of bytes from the clients to the servers.
+		BytesIn uint64 `yaml:"bytesIn"`
+		// BytesOut is the number of bytes from the servers to the clients.
+		BytesOut uint64 `yaml:"bytesOut"`
+		// Servers is the number of active connections of each server.
+		Servers map[string]int64 `yaml:"servers"`
+	}
+)
+
+func newRuntime(superSpec *supervisor.Spec) *runtime {
+	r := newRuntimeWithSpec(superSpec.ObjectSpec().(*Spec))
+	r.superSpec = superSpec
+
+	listener, err := graceupdate.Global.Listen("tcp", fmt.Sprintf(":%d", r.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listens on port %d failed: %v", superSpec.Name(), r.spec.Port, err)
+		return r
+	}
+
+	r.listener = limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
+	go r.serve()
+
+	return r
+}
+
+func newRuntimeWithSpec(spec *Spec) *runtime {
+	r := &runtime{
+		spec:           spec,
+		connectTimeout: spec.connectTimeout(),
+		idleTimeout:    spec.idleTimeout(),
+	}
+	for _, s := range spec.Servers {
+		r.servers = append(r.servers, &server{addr: s.Addr})
+	}
+	return r
+}
+
+func (r *runtime) name() string {
+	if r.superSpec == nil {
+		return Kind
+	}
+	return r.superSpec.Name()
+}
+
+func (r *runtime) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			// NOTE: The error is caused by closing the listener in most cases.
+			logger.Debugf("%s accepts connection failed: %v", r.name(), err)
+			return
+		}
+
+		atomic.AddUint64(&r.totalConnections, 1)
+		go r.handleConn(conn)
+	}
+}
+
+// pickServer picks a server for the client according to the load
+// balance policy.
+func (r *runtime) pickServer(clientAddr net.Addr) *server {
+	n := len(r.servers)
+	if n == 1 {
+		return r.servers[0]
+	}
+
+	switch r.spec.LoadBalance {
+	case LoadBalanceRandom:
+		return r.servers[rand.Intn(n)]
+	case LoadBalanceIPHash:
+		ip := clientAddr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		h := fnv.New32()
+		h.Write([]byte(ip))
+		return r.servers[h.Sum32()%uint32(n)]
+	case LoadBalanceLeastConnections:
+		s := r.servers[0]
+		for _, v := range r.servers[1:] {
+			if atomic.LoadInt64(&v.connections) < atomic.LoadInt64(&s.connections) {
+				s = v
+			}
+		}
+		return s
+	default:
+		i := atomic.AddUint64(&r.counter, 1)
+		return r.servers[i%uint64(n)]
+	}
+}
+
+func (r *runtime) handleConn(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	s := r.pickServer(clientConn.RemoteAddr())
+	serverConn, err := net.DialTimeout("tcp", s.addr, r.connectTimeout)
+	if err != nil {
+		atomic.AddUint64(&r.failedConnections, 1)
+		logger.Errorf("%s connects to server %s failed: %v", r.name(), s.addr, err)
+		return
+	}
+	defer serverConn.Close()
+
+	atomic.AddInt64(&r.activeConnections, 1)
+	atomic.AddInt64(&s.connections, 1)
+	defer atomic.AddInt64(&r.activeConnections, -1)
+	defer atomic.AddInt64(&s.connections, -1)
+
+	if r.spec.ProxyProtocol != "" {
+		header := proxyProtocolHeader(r.spec.ProxyProtocol, clientConn.RemoteAddr(), clientConn.LocalAddr())
+		if _, err := serverConn.Write(header); err != nil {
+			logger.Errorf("%s writes proxy protocol header to server %s failed: %v", r.name(), s.addr, err)
+			return
+		}
+	}
+
+	lastActive := time.Now().UnixNano()
+	errc := make(chan error, 2)
+	go func() {
+		errc <- r.pipe(serverConn, clientConn, &lastActive, &r.bytesIn)
+	}()
+	go func() {
+		errc <- r.pipe(clientConn, serverConn, &lastActive, &r.bytesOut)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			// NOTE: Closing the connections stops the other direction.
+			clientConn.Close()
+			serverConn.Close()
+		}
+	}
+}
+
+// pipe copies data from src to dst until EOF, and then closes the write
+// side of dst. It returns nil on EOF, or the error encountered.
+func (r *runtime) pipe(dst, src net.Conn, lastActive *int64, bytes *uint64) error {
+	buf := make([]byte, copyBufferSize)
+	for {
+		if r.idleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(lastActive, time.Now().UnixNano())
+			atomic.AddUint64(bytes, uint64(n))
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			return closeWrite(dst)
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			// NOTE: The connection is not idle if the other direction is active.
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(lastActive)))
+			if idle < r.idleTimeout {
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// closeWrite shuts down the write side of the connection, or closes it
+// if half-close is not supported.
+func closeWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return conn.Close()
+}
+
+func (r *runtime) status() *Status {
+	s := &Status{
+		ActiveConnections: atomic.LoadInt64(&r.activeConnections),
+		TotalConnections:  atomic.LoadUint64(&r.totalConnections),
+		FailedConnections: atomic.LoadUint64(&r.failedConnections),
+		BytesIn:           atomic.LoadUint64(&r.bytesIn),
+		BytesOut:          atomic.LoadUint64(&r.bytesOut),
+		Servers:           make(map[string]int64, len(r.servers)),
+	}
+	for _, v := range r.servers {
+		s.Servers[v.addr] = atomic.LoadInt64(&v.connections)
+	}
+	return s
+}
+
+func (r *runtime) close() {
+	if r.listener == nil {
+		return
+	}
+	if err := r.listener.Close(); err != nil {
+		logger.Warnf("%s closes listener failed: %v", r.name(), err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpproxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// LoadBalanceRoundRobin sends connections to the servers in turn.
+	LoadBalanceRoundRobin = "roundRobin"
+	// LoadBalanceRandom sends connections to a random server.
+	LoadBalanceRandom = "random"
+	// LoadBalanceIPHash sends connections from the same client IP to
+	// the same server.
+	LoadBalanceIPHash = "ipHash"
+	// LoadBalanceLeastConnections sends connections to the server with
+	// the least active connections.
+	LoadBalanceLeastConnections = "leastConnections"
+
+	// ProxyProtocolV1 is the human-readable PROXY protocol header.
+	ProxyProtocolV1 = "v1"
+	// ProxyProtocolV2 is the binary PROXY protocol header.
+	ProxyProtocolV2 = "v2"
+
+	defaultConnectTimeout = 5 * time.Second
+)
+
+type (
+	// Spec describes the TCPProxy.
+	Spec struct {
+		Port           uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		MaxConnections uint32 `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		ConnectTimeout string `yaml:"connectTimeout" jsonschema:"omitempty,format=duration"`
+		IdleTimeout    string `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+
+		// ProxyProtocol sends the PROXY protocol header of the version
+		// to the servers, so that they know the real client address.
+		ProxyProtocol string    `yaml:"proxyProtocol,omitempty" jsonschema:"omitempty,enum=v1,enum=v2"`
+		LoadBalance   string    `yaml:"loadBalance,omitempty" jsonschema:"omitempty,enum=roundRobin,enum=random,enum=ipHash,enum=leastConnections"`
+		Servers       []*Server `yaml:"servers" jsonschema:"required,minItems=1"`
+	}
+
+	// Server is the backend server.
+	Server struct {
+		Addr string `yaml:"addr" jsonschema:"required"`
+	}
+)
+
+// Validate validates Server.
+func (s Server) Validate() error {
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		return fmt.Errorf("invalid server address %s: %v", s.Addr, err)
+	}
+	return nil
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	// NOTE: The duration has been checked in validation.
+	v, _ := time.ParseDuration(s)
+	return v
+}
+
+func (spec *Spec) connectTimeout() time.Duration {
+	return parseDuration(spec.ConnectTimeout, defaultConnectTimeout)
+}
+
+func (spec *Spec) idleTimeout() time.Duration {
+	return parseDuration(spec.IdleTimeout, 0)
+}
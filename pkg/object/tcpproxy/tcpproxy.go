@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpproxy
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of TCPProxy.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of TCPProxy.
+	Kind = "TCPProxy"
+)
+
+func init() {
+	supervisor.Register(&TCPProxy{})
+}
+
+type (
+	// TCPProxy is a layer-4 proxy which forwards TCP connections to
+	// the backend servers.
+	TCPProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		runtime   *runtime
+	}
+)
+
+// Category returns the category of TCPProxy.
+func (tp *TCPProxy) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of TCPProxy.
+func (tp *TCPProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of TCPProxy.
+func (tp *TCPProxy) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections: 10240,
+		ConnectTimeout: "5s",
+	}
+}
+
+// Init initializes TCPProxy.
+func (tp *TCPProxy) Init(superSpec *supervisor.Spec) {
+	tp.superSpec, tp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	tp.reload()
+}
+
+// Inherit inherits previous generation of TCPProxy.
+func (tp *TCPProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: The established connections of the previous generation are
+	// kept until they are closed by the peers or idle timeout.
+	previousGeneration.Close()
+	tp.Init(superSpec)
+}
+
+func (tp *TCPProxy) reload() {
+	tp.runtime = newRuntime(tp.superSpec)
+}
+
+// Status returns the status of TCPProxy.
+func (tp *TCPProxy) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: tp.runtime.status(),
+	}
+}
+
+// Close closes TCPProxy.
+func (tp *TCPProxy) Close() {
+	tp.runtime.close()
+}
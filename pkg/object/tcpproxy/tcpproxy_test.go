@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	m.Run()
+}
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+
+	header := proxyProtocolHeader(ProxyProtocolV1, src, dst)
+	if string(header) != "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n" {
+		t.Errorf("unexpected v1 header: %q", header)
+	}
+
+	header = proxyProtocolHeader(ProxyProtocolV2, src, dst)
+	expected := append([]byte{}, proxyProtocolV2Signature...)
+	expected = append(expected, 0x21, 0x11, 0x00, 0x0c,
+		192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x01, 0xbb)
+	if !bytes.Equal(header, expected) {
+		t.Errorf("unexpected v2 header: %v", header)
+	}
+
+	src.IP = net.ParseIP("2001:db8::1")
+	header = proxyProtocolHeader(ProxyProtocolV1, src, dst)
+	if string(header) != "PROXY TCP6 2001:db8::1 192.168.0.11 56324 443\r\n" {
+		t.Errorf("unexpected v1 header: %q", header)
+	}
+	header = proxyProtocolHeader(ProxyProtocolV2, src, dst)
+	if len(header) != 16+36 || header[13] != 0x21 {
+		t.Errorf("unexpected v2 header: %v", header)
+	}
+
+	header = proxyProtocolHeader(ProxyProtocolV2, &net.UnixAddr{}, dst)
+	if len(header) != 16 || header[12] != 0x20 {
+		t.Errorf("unexpected v2 header: %v", header)
+	}
+}
+
+func TestPickServer(t *testing.T) {
+	r := newRuntimeWithSpec(&Spec{
+		Servers: []*Server{{Addr: "127.0.0.1:8001"}, {Addr: "127.0.0.1:8002"}},
+	})
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	if r.pickServer(addr) == r.pickServer(addr) {
+		t.Error("round robin should pick different servers")
+	}
+
+	r.spec.LoadBalance = LoadBalanceIPHash
+	first := r.pickServer(addr)
+	addr.Port = 4321
+	if r.pickServer(addr) != first {
+		t.Error("ip hash should pick the same server for the same ip")
+	}
+
+	r.spec.LoadBalance = LoadBalanceLeastConnections
+	r.servers[0].connections = 3
+	if r.pickServer(addr) != r.servers[1] {
+		t.Error("server with least connections should be picked")
+	}
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func startProxy(t *testing.T, spec *Spec) *runtime {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	r := newRuntimeWithSpec(spec)
+	r.listener = limitlistener.NewLimitListener(l, 10)
+	go r.serve()
+	return r
+}
+
+func TestProxy(t *testing.T) {
+	backend := startEchoServer(t)
+	defer backend.Close()
+
+	r := startProxy(t, &Spec{
+		ProxyProtocol: ProxyProtocolV1,
+		Servers:       []*Server{{Addr: backend.Addr().String()}},
+	})
+	defer r.close()
+
+	conn, err := net.Dial("tcp", r.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello\n"))
+	reader := bufio.NewReader(conn)
+	line, _ := reader.ReadString('\n')
+	if line != "PROXY TCP4 127.0.0.1 127.0.0.1 "+portOf(conn.LocalAddr())+" "+portOf(r.listener.Addr())+"\r\n" {
+		t.Errorf("unexpected proxy protocol header: %q", line)
+	}
+	line, _ = reader.ReadString('\n')
+	if line != "hello\n" {
+		t.Errorf("unexpected echo: %q", line)
+	}
+
+	conn.(*net.TCPConn).CloseWrite()
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection should be closed after half-close, got %v", err)
+	}
+
+	s := r.status()
+	if s.TotalConnections != 1 || s.BytesIn != 6 || s.BytesOut == 0 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	backend := startEchoServer(t)
+	defer backend.Close()
+
+	r := startProxy(t, &Spec{
+		IdleTimeout: "50ms",
+		Servers:     []*Server{{Addr: backend.Addr().String()}},
+	})
+	defer r.close()
+
+	conn, err := net.Dial("tcp", r.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection should be closed, got %v", err)
+	}
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpproxy
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+// maxDatagramSize is the max size of UDP datagrams.
+const maxDatagramSize = 64 * 1024
+
+type (
+	runtime struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		idleTimeout time.Duration
+
+		conn    *net.UDPConn
+		servers []*server
+		counter uint64
+
+		mutex    sync.Mutex
+		sessions map[string]*session
+		closed   bool
+
+		totalSessions  uint64
+		droppedPackets uint64
+		packetsIn      uint64
+		packetsOut     uint64
+		bytesIn        uint64
+		bytesOut       uint64
+	}
+
+	server struct {
+		addr     string
+		udpAddr  *net.UDPAddr
+		sessions int64
+	}
+
+	// session is identified by the 5-tuple, as the protocol and the
+	// local address are the same for a runtime, the client address is
+	// used as the key.
+	session struct {
+		clientAddr *net.UDPAddr
+		server     *server
+		conn       net.Conn
+		lastActive int64
+	}
+
+	// Status is the status of UDPProxy.
+	Status struct {
+		// ActiveSessions is the number of sessions not timed out.
+		ActiveSessions int `yaml:"activeSessions"`
+		// TotalSessions is the total number of created sessions.
+		TotalSessions uint64 `yaml:"totalSessions"`
+		// DroppedPackets is the number of datagrams from clients dropped
+		// for exceeding max sessions or failing to be sent to servers.
+		DroppedPackets uint64 `yaml:"droppedPackets"`
+		// PacketsIn is the number of datagrams from the clients to the servers.
+		PacketsIn uint64 `yaml:"packetsIn"`
+		// PacketsOut is the number of datagrams from the servers to the clients.
+		PacketsOut uint64 `yaml:"packetsOut"`
+		// BytesIn is the number of bytes from the clients to the servers.
+		BytesIn uint64 `yaml:"bytesIn"`
+		// BytesOut is the number of bytes from the servers to the clients.
+		BytesOut uint64 `yaml:"bytesOut"`
+		// Servers is the number of active sessions of each server.
+		Servers map[string]int64 `yaml:"servers"`
+	}
+)
+
+func newRuntime(superSpec *supervisor.Spec) *runtime {
+	r := newRuntimeWithSpec(superSpec.ObjectSpec().(*Spec))
+	r.superSpec = superSpec
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(r.spec.Port)})
+	if err != nil {
+		logger.Errorf("%s listens on port %d failed: %v", superSpec.Name(), r.spec.Port, err)
+		return r
+	}
+
+	r.conn = conn
+	go r.serve()
+
+	return r
+}
+
+func newRuntimeWithSpec(spec *Spec) *runtime {
+	r := &runtime{
+		spec:        spec,
+		idleTimeout: spec.idleTimeout(),
+		sessions:    make(map[string]*session),
+	}
+	// NOTE: The addresses are resolved once here, so creating sessions
+	// in the read loop never waits for DNS.
+	for _, s := range spec.Servers {
+		udpAddr, err := net.ResolveUDPAddr("udp", s.Addr)
+		if err != nil {
+			logger.Errorf("%s resolves server %s failed: %v", Kind, s.Addr, err)
+			continue
+		}
+		r.servers = append(r.servers, &server{addr: s.Addr, udpAddr: udpAddr})
+	}
+	return r
+}
+
+func (r *runtime) name() string {
+	if r.superSpec == nil {
+		return Kind
+	}
+	return r.superSpec.Name()
+}
+
+func (r *runtime) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, clientAddr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			// NOTE: The error is caused by closing the socket in most cases.
+			logger.Debugf("%s reads from udp failed: %v", r.name(), err)
+			return
+		}
+
+		s := r.getSession(clientAddr)
+		if s == nil {
+			atomic.AddUint64(&r.droppedPackets, 1)
+			continue
+		}
+
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+		if _, err := s.conn.Write(buf[:n]); err != nil {
+			atomic.AddUint64(&r.droppedPackets, 1)
+			logger.Debugf("%s writes to server %s failed: %v", r.name(), s.server.addr, err)
+			continue
+		}
+		atomic.AddUint64(&r.packetsIn, 1)
+		atomic.AddUint64(&r.bytesIn, uint64(n))
+	}
+}
+
+// pickServer picks a server for the client according to the load
+// balance policy.
+func (r *runtime) pickServer(clientAddr *net.UDPAddr) *server {
+	n := len(r.servers)
+	if n == 1 {
+		return r.servers[0]
+	}
+
+	switch r.spec.LoadBalance {
+	case LoadBalanceRandom:
+		return r.servers[rand.Intn(n)]
+	case LoadBalanceIPHash:
+		h := fnv.New32()
+		h.Write(clientAddr.IP)
+		return r.servers[h.Sum32()%uint32(n)]
+	default:
+		i := atomic.AddUint64(&r.counter, 1)
+		return r.servers[i%uint64(n)]
+	}
+}
+
+// getSession gets the session of the client, a new session is created
+// if not exists, nil is returned if failed to create it.
+func (r *runtime) getSession(clientAddr *net.UDPAddr) *session {
+	key := clientAddr.String()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s := r.sessions[key]; s != nil {
+		return s
+	}
+	if !r.canAddSession() || len(r.servers) == 0 {
+		return nil
+	}
+
+	server := r.pickServer(clientAddr)
+	conn, err := net.DialUDP("udp", nil, server.udpAddr)
+	if err != nil {
+		logger.Errorf("%s dials server %s failed: %v", r.name(), server.addr, err)
+		return nil
+	}
+
+	s := &session{
+		clientAddr: clientAddr,
+		server:     server,
+		conn:       conn,
+		lastActive: time.Now().UnixNano(),
+	}
+	r.sessions[key] = s
+	atomic.AddInt64(&server.sessions, 1)
+	atomic.AddUint64(&r.totalSessions, 1)
+
+	go r.serveSession(key, s)
+
+	return s
+}
+
+// canAddSession reports whether a new session could be added, it must be
+// called with the lock held.
+func (r *runtime) canAddSession() bool {
+	if r.closed {
+		return false
+	}
+	return r.spec.MaxSessions == 0 || len(r.sessions) < int(r.spec.MaxSessions)
+}
+
+// serveSession sends the datagrams from the server back to the client
+// until the session is idle or closed.
+func (r *runtime) serveSession(key string, s *session) {
+	defer r.removeSession(key, s)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// NOTE: The session is not idle if the client is active.
+				idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+				if idle < r.idleTimeout {
+					continue
+				}
+				return
+			}
+			// NOTE: ICMP errors like port unreachable are reported here,
+			// the session is kept until idle timeout.
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Debugf("%s reads from server %s failed: %v", r.name(), s.server.addr, err)
+				continue
+			}
+			return
+		}
+
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+		if _, err := r.conn.WriteToUDP(buf[:n], s.clientAddr); err != nil {
+			logger.Debugf("%s writes to client %s failed: %v", r.name(), s.clientAddr, err)
+			continue
+		}
+		atomic.AddUint64(&r.packetsOut, 1)
+		atomic.AddUint64(&r.bytesOut, uint64(n))
+	}
+}
+
+func (r *runtime) removeSession(key string, s *session) {
+	s.conn.Close()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.sessions[key] == s {
+		delete(r.sessions, key)
+		atomic.AddInt64(&s.server.sessions, -1)
+	}
+}
+
+func (r *runtime) status() *Status {
+	s := &Status{
+		TotalSessions:  atomic.LoadUint64(&r.totalSessions),
+		DroppedPackets: atomic.LoadUint64(&r.droppedPackets),
+		PacketsIn:      atomic.LoadUint64(&r.packetsIn),
+		PacketsOut:     atomic.LoadUint64(&r.packetsOut),
+		BytesIn:        atomic.LoadUint64(&r.bytesIn),
+		BytesOut:       atomic.LoadUint64(&r.bytesOut),
+		Servers:        make(map[string]int64, len(r.servers)),
+	}
+	for _, v := range r.servers {
+		s.Servers[v.addr] = atomic.LoadInt64(&v.sessions)
+	}
+
+	r.mutex.Lock()
+	s.ActiveSessions = len(r.sessions)
+	r.mutex.Unlock()
+
+	return s
+}
+
+func (r *runtime) close() {
+	if r.conn == nil {
+		return
+	}
+	if err := r.conn.Close(); err != nil {
+		logger.Warnf("%s closes udp socket failed: %v", r.name(), err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	for _, s := range r.sessions {
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpproxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// LoadBalanceRoundRobin sends sessions to the servers in turn.
+	LoadBalanceRoundRobin = "roundRobin"
+	// LoadBalanceRandom sends sessions to a random server.
+	LoadBalanceRandom = "random"
+	// LoadBalanceIPHash sends sessions from the same client IP to the
+	// same server.
+	LoadBalanceIPHash = "ipHash"
+
+	defaultIdleTimeout = 60 * time.Second
+)
+
+type (
+	// Spec describes the UDPProxy.
+	Spec struct {
+		Port uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		// MaxSessions is the max number of sessions, datagrams from new
+		// clients are dropped when it's exceeded.
+		MaxSessions uint32 `yaml:"maxSessions" jsonschema:"omitempty,minimum=1"`
+		// IdleTimeout is the idle timeout of each session.
+		IdleTimeout string    `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		LoadBalance string    `yaml:"loadBalance,omitempty" jsonschema:"omitempty,enum=roundRobin,enum=random,enum=ipHash"`
+		Servers     []*Server `yaml:"servers" jsonschema:"required,minItems=1"`
+	}
+
+	// Server is the backend server.
+	Server struct {
+		Addr string `yaml:"addr" jsonschema:"required"`
+	}
+)
+
+// Validate validates Server.
+func (s Server) Validate() error {
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		return fmt.Errorf("invalid server address %s: %v", s.Addr, err)
+	}
+	return nil
+}
+
+func (spec *Spec) idleTimeout() time.Duration {
+	if spec.IdleTimeout == "" {
+		return defaultIdleTimeout
+	}
+	// NOTE: The duration has been checked in validation.
+	d, _ := time.ParseDuration(spec.IdleTimeout)
+	return d
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpproxy
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of UDPProxy.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of UDPProxy.
+	Kind = "UDPProxy"
+)
+
+func init() {
+	supervisor.Register(&UDPProxy{})
+}
+
+type (
+	// UDPProxy is a layer-4 proxy which forwards UDP datagrams to the
+	// backend servers by sessions.
+	UDPProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		runtime   *runtime
+	}
+)
+
+// Category returns the category of UDPProxy.
+func (up *UDPProxy) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of UDPProxy.
+func (up *UDPProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of UDPProxy.
+func (up *UDPProxy) DefaultSpec() interface{} {
+	return &Spec{
+		MaxSessions: 10240,
+		IdleTimeout: "60s",
+	}
+}
+
+// Init initializes UDPProxy.
+func (up *UDPProxy) Init(superSpec *supervisor.Spec) {
+	up.superSpec, up.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	up.reload()
+}
+
+// Inherit inherits previous generation of UDPProxy.
+func (up *UDPProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: All sessions of the previous generation are closed, because
+	// they share the same listening socket.
+	previousGeneration.Close()
+	up.Init(superSpec)
+}
+
+func (up *UDPProxy) reload() {
+	up.runtime = newRuntime(up.superSpec)
+}
+
+// Status returns the status of UDPProxy.
+func (up *UDPProxy) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: up.runtime.status(),
+	}
+}
+
+// Close closes UDPProxy.
+func (up *UDPProxy) Close() {
+	up.runtime.close()
+}
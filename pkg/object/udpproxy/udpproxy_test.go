@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	m.Run()
+}
+
+func startEchoServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func startProxy(t *testing.T, spec *Spec) *runtime {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	r := newRuntimeWithSpec(spec)
+	r.conn = conn
+	go r.serve()
+	return r
+}
+
+func dial(t *testing.T, r *runtime) net.Conn {
+	conn, err := net.Dial("udp", r.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	return conn
+}
+
+func TestProxy(t *testing.T) {
+	backend := startEchoServer(t)
+	defer backend.Close()
+
+	r := startProxy(t, &Spec{
+		MaxSessions: 1,
+		IdleTimeout: "100ms",
+		Servers:     []*Server{{Addr: backend.LocalAddr().String()}},
+	})
+	defer r.close()
+
+	conn := dial(t, r)
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("hello"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("unexpected echo: %q, %v", buf[:n], err)
+		}
+	}
+
+	s := r.status()
+	if s.ActiveSessions != 1 || s.TotalSessions != 1 || s.PacketsIn != 2 || s.PacketsOut != 2 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	// The datagrams of another client are dropped for max sessions.
+	conn2 := dial(t, r)
+	defer conn2.Close()
+	conn2.Write([]byte("hello"))
+	conn2.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn2.Read(buf); err == nil {
+		t.Error("datagram should be dropped")
+	}
+
+	// The session is removed after idle timeout.
+	for i := 0; i < 50 && r.status().ActiveSessions > 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	s = r.status()
+	if s.ActiveSessions != 0 || s.DroppedPackets != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestPickServer(t *testing.T) {
+	r := newRuntimeWithSpec(&Spec{
+		Servers: []*Server{{Addr: "127.0.0.1:53"}, {Addr: "127.0.0.1:54"}},
+	})
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	if r.pickServer(addr) == r.pickServer(addr) {
+		t.Error("round robin should pick different servers")
+	}
+
+	r.spec.LoadBalance = LoadBalanceIPHash
+	first := r.pickServer(addr)
+	addr.Port = 4321
+	if r.pickServer(addr) != first {
+		t.Error("ip hash should pick the same server for the same ip")
+	}
+}
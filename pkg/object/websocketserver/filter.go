@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const (
+	// DirectionClientToBackend is the direction of messages from the
+	// client to the backend.
+	DirectionClientToBackend = "clientToBackend"
+	// DirectionBackendToClient is the direction of messages from the
+	// backend to the client.
+	DirectionBackendToClient = "backendToClient"
+	// DirectionBoth is both directions.
+	DirectionBoth = "both"
+
+	// ActionDrop drops the message.
+	ActionDrop = "drop"
+	// ActionClose closes the connection.
+	ActionClose = "close"
+)
+
+type (
+	// MessageFilterSpec describes a filter for each message, only one
+	// of RateLimit and Replace could be specified.
+	MessageFilterSpec struct {
+		Direction string            `yaml:"direction,omitempty" jsonschema:"omitempty,enum=clientToBackend,enum=backendToClient,enum=both"`
+		RateLimit *MessageRateLimit `yaml:"rateLimit,omitempty" jsonschema:"omitempty"`
+		Replace   *MessageReplace   `yaml:"replace,omitempty" jsonschema:"omitempty"`
+	}
+
+	// MessageRateLimit limits the rate of messages of a connection.
+	MessageRateLimit struct {
+		MessagesPerSecond int    `yaml:"messagesPerSecond" jsonschema:"required,minimum=1"`
+		Burst             int    `yaml:"burst,omitempty" jsonschema:"omitempty,minimum=1"`
+		Action            string `yaml:"action,omitempty" jsonschema:"omitempty,enum=drop,enum=close"`
+	}
+
+	// MessageReplace replaces the text messages by regular expression.
+	MessageReplace struct {
+		Regexp  string `yaml:"regexp" jsonschema:"required,format=regexp"`
+		Replace string `yaml:"replace" jsonschema:"omitempty"`
+	}
+
+	// messageFilter filters a message of a connection, it returns the
+	// new message and whether to drop the message or close the
+	// connection.
+	messageFilter func(msgType int, msg []byte) ([]byte, string)
+)
+
+// Validate validates MessageFilterSpec.
+func (spec MessageFilterSpec) Validate() error {
+	if (spec.RateLimit == nil) == (spec.Replace == nil) {
+		return fmt.Errorf("one and only one of rateLimit and replace must be specified")
+	}
+	return nil
+}
+
+func (spec *MessageFilterSpec) match(direction string) bool {
+	return spec.Direction == "" || spec.Direction == DirectionBoth || spec.Direction == direction
+}
+
+// newMessageFilters creates the message filters of a connection for
+// the direction.
+func newMessageFilters(specs []*MessageFilterSpec, direction string) []messageFilter {
+	var filters []messageFilter
+	for _, spec := range specs {
+		if !spec.match(direction) {
+			continue
+		}
+
+		if spec.RateLimit != nil {
+			filters = append(filters, newRateLimitFilter(spec.RateLimit))
+		} else {
+			filters = append(filters, newReplaceFilter(spec.Replace))
+		}
+	}
+	return filters
+}
+
+func newRateLimitFilter(spec *MessageRateLimit) messageFilter {
+	limiter := ratelimiter.NewTokenBucket(spec.MessagesPerSecond, time.Second, spec.Burst)
+	action := spec.Action
+	if action == "" {
+		action = ActionDrop
+	}
+
+	return func(msgType int, msg []byte) ([]byte, string) {
+		if permitted, _ := limiter.AcquirePermission(); !permitted {
+			return nil, action
+		}
+		return msg, ""
+	}
+}
+
+func newReplaceFilter(spec *MessageReplace) messageFilter {
+	// NOTE: The regular expression has been checked in validation.
+	re := regexp.MustCompile(spec.Regexp)
+	replace := []byte(spec.Replace)
+
+	return func(msgType int, msg []byte) ([]byte, string) {
+		if msgType != websocket.TextMessage {
+			return msg, ""
+		}
+		return re.ReplaceAll(msg, replace), ""
+	}
+}
+
+// filterMessage runs the filters on the message in order.
+func filterMessage(filters []messageFilter, msgType int, msg []byte) ([]byte, string) {
+	for _, f := range filters {
+		var action string
+		if msg, action = f(msgType, msg); action != "" {
+			return nil, action
+		}
+	}
+	return msg, ""
+}
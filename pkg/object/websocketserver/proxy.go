@@ -20,14 +20,19 @@ package websocketserver
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/tomasen/realip"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/supervisor"
@@ -41,23 +46,26 @@ var (
 		WriteBufferSize: 1024,
 	}
 
-	// defaultDialer is a dialer with all fields set to the default zero values.
-	defaultDialer = websocket.DefaultDialer
+	// defaultIdleCheckInterval is the interval to check whether the
+	// connections are idle, the actual idle timeout is the configured
+	// value plus this interval at most.
+	defaultIdleCheckInterval = time.Second
 
-	// defaultInterval is the default interval for polling websocket client and server which is
-	//  200ms right now.
-	defaultInterval = 200 * time.Millisecond
+	// defaultCloseTimeout is the timeout of writing close messages.
+	defaultCloseTimeout = time.Second
 )
 
 // Proxy is a handler that takes an incoming WebSocket
 // connection and proxies it to the backend server.
 type Proxy struct {
 	// server is the HTTPServer
-	server    http.Server
+	server    *http.Server
 	superSpec *supervisor.Spec
+	spec      *Spec
 
-	// backendURL URL is the URL of target websocket server.
-	backendURL *url.URL
+	// backendURLs are the URLs of target websocket servers.
+	backendURLs []*url.URL
+	counter     uint64
 
 	// upgrader specifies the parameters for upgrading an incoming HTTP
 	// connection to a WebSocket connection.
@@ -68,30 +76,125 @@ type Proxy struct {
 
 	// done is the channel for shutdowning this proxy.
 	done chan struct{}
+
+	mutex       sync.Mutex
+	connections map[string]int64
+}
+
+// Status is the status of WebSocketServer.
+type Status struct {
+	// Connections is the number of active connections of each backend.
+	Connections map[string]int64 `yaml:"connections"`
 }
 
 // NewProxy returns a new Websocket proxy.
 func newProxy(superSpec *supervisor.Spec) *Proxy {
 	proxy := &Proxy{
-		superSpec: superSpec,
-		done:      make(chan struct{}),
+		superSpec:   superSpec,
+		spec:        superSpec.ObjectSpec().(*Spec),
+		done:        make(chan struct{}),
+		connections: make(map[string]int64),
+	}
+	if err := proxy.init(); err != nil {
+		logger.Errorf("%s init websocketserver failed: %v", superSpec.Name(), err)
+		return proxy
 	}
 	go proxy.run()
 	return proxy
 }
 
+func (p *Proxy) init() error {
+	spec := p.spec
+	for _, backend := range spec.backends() {
+		backendURL, err := url.Parse(backend)
+		if err != nil {
+			return fmt.Errorf("BUG: invalid websocketserver backend URL %s: %v", backend, err)
+		}
+		p.backendURLs = append(p.backendURLs, backendURL)
+	}
+
+	// NOTE: Copy the default dialer to avoid changing it.
+	dialer := *websocket.DefaultDialer
+	tlsConfig, err := spec.wssTLSConfig()
+	if err != nil {
+		return fmt.Errorf("gen websocketserver backend tls failed: %v", err)
+	}
+	dialer.TLSClientConfig = tlsConfig
+	p.dialer = &dialer
+	p.upgrader = defaultUpgrader
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", p.handle)
+	p.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", spec.Port),
+		Handler: mux,
+	}
+
+	if spec.HTTPS {
+		tlsConfig, err := spec.tlsConfig()
+		if err != nil {
+			return fmt.Errorf("gen websocketserver's httpserver tlsConfig failed: %v", err)
+		}
+		p.server.TLSConfig = tlsConfig
+	}
+
+	return nil
+}
+
 // buildRequestURL builds an URL with backend in spec and original HTTP request.
-func (p *Proxy) buildRequestURL(r *http.Request) *url.URL {
-	u := *p.backendURL
+func (p *Proxy) buildRequestURL(backendURL *url.URL, r *http.Request) *url.URL {
+	u := *backendURL
 	u.Fragment = r.URL.Fragment
 	u.Path = r.URL.Path
 	u.RawQuery = r.URL.RawQuery
 	return &u
 }
 
+// pickBackend picks a backend for the request according to the load
+// balance policy.
+func (p *Proxy) pickBackend(r *http.Request) *url.URL {
+	n := len(p.backendURLs)
+	if n == 1 {
+		return p.backendURLs[0]
+	}
+
+	switch p.spec.LoadBalance {
+	case LoadBalanceRandom:
+		return p.backendURLs[rand.Intn(n)]
+	case LoadBalanceIPHash:
+		h := fnv.New32()
+		h.Write([]byte(realip.FromRequest(r)))
+		return p.backendURLs[h.Sum32()%uint32(n)]
+	default:
+		i := atomic.AddUint64(&p.counter, 1)
+		return p.backendURLs[i%uint64(n)]
+	}
+}
+
+func (p *Proxy) addConnection(backend string, delta int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.connections[backend] += delta
+	if p.connections[backend] <= 0 {
+		delete(p.connections, backend)
+	}
+}
+
+func (p *Proxy) status() *Status {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := &Status{Connections: make(map[string]int64, len(p.connections))}
+	for k, v := range p.connections {
+		s.Connections[k] = v
+	}
+	return s
+}
+
 // passMsg passes websocket message from src to dst.
-func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan struct{}) {
-	handle := func() bool {
+func (p *Proxy) passMsg(src, dst *websocket.Conn, filters []messageFilter, lastActive *int64, errc chan error) {
+	for {
 		msgType, msg, err := src.ReadMessage()
 		if err != nil {
 			m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("%v", err))
@@ -99,73 +202,46 @@ func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan str
 				if e.Code != websocket.CloseNoStatusReceived {
 					m = websocket.FormatCloseMessage(e.Code, e.Text)
 				}
+			} else if err == websocket.ErrReadLimit {
+				m = websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "message too big")
+				writeClose(src, m)
 			}
-			dst.WriteMessage(websocket.CloseMessage, m)
+			writeClose(dst, m)
 			errc <- err
-			return false
+			return
+		}
+		atomic.StoreInt64(lastActive, time.Now().UnixNano())
+
+		msg, action := filterMessage(filters, msgType, msg)
+		switch action {
+		case ActionDrop:
+			continue
+		case ActionClose:
+			m := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "message rejected")
+			writeClose(src, m)
+			writeClose(dst, m)
+			errc <- &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "message rejected"}
+			return
 		}
+
 		err = dst.WriteMessage(msgType, msg)
 		if err != nil {
 			errc <- err
-			return false
-		}
-		return true
-	}
-
-	for {
-		select {
-		// this request handling is stopped due to some error or websocketserver shutdown.
-		case <-stop:
 			return
-		case <-time.After(defaultInterval):
-			if !handle() {
-				return
-			}
 		}
 	}
 }
 
 // run runs the websocket proxy.
 func (p *Proxy) run() {
-	spec := p.superSpec.ObjectSpec().(*Spec)
-	backendURL, err := url.Parse(spec.Backend)
-	if err != nil {
-		logger.Errorf("BUG: %s get invalid websocketserver backend URL: %s",
-			p.superSpec.Name(), spec.Backend)
-		return
-	}
-
-	p.backendURL = backendURL
-	dialer := defaultDialer
-	if strings.HasPrefix(spec.Backend, "wss") {
-		tlsConfig, err := spec.wssTLSConfig()
-		if err != nil {
-			logger.Errorf("%s gen websocketserver backend tls failed: %v, spec :%#v",
-				p.superSpec.Name(), spec)
-			return
-		}
-		dialer.TLSClientConfig = tlsConfig
-	}
-	p.dialer = dialer
-	p.upgrader = defaultUpgrader
-
-	http.HandleFunc("/", p.handle)
-	addr := fmt.Sprintf(":%d", spec.Port)
-	svr := &http.Server{
-		Addr:    addr,
-		Handler: nil,
-	}
-
-	if spec.HTTPS {
-		tlsConfig, err := spec.tlsConfig()
-		if err != nil {
-			logger.Errorf("%s gen websocketserver's httpserver tlsConfig: %#v, failed: %v",
-				p.superSpec.Name(), spec, err)
-		}
-		svr.TLSConfig = tlsConfig
+	var err error
+	if p.spec.HTTPS {
+		err = p.server.ListenAndServeTLS("", "")
+	} else {
+		err = p.server.ListenAndServe()
 	}
 
-	if err := svr.ListenAndServe(); err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		logger.Errorf("%s websocketserver ListenAndServe failed: %v", p.superSpec.Name(), err)
 	}
 }
@@ -217,16 +293,19 @@ func (p *Proxy) upgradeRspHeader(resp *http.Response) http.Header {
 
 // handle implements the http.Handler that proxies WebSocket connections.
 func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
-	connBackend, resp, err := p.dialer.Dial(p.buildRequestURL(req).String(), p.copyHeader(req))
+	backendURL := p.pickBackend(req)
+	backend := backendURL.String()
+
+	connBackend, resp, err := p.dialer.Dial(p.buildRequestURL(backendURL, req).String(), p.copyHeader(req))
 	if err != nil {
-		logger.Errorf("%s dials %s failed: %v", p.superSpec.Name(), p.backendURL.String(), err)
+		logger.Errorf("%s dials %s failed: %v", p.superSpec.Name(), backend, err)
 		if resp != nil {
 			// Handle WebSocket handshake failed scenario.
 			// Should send back a non-nil *http.Response for callers to handle
 			// `redirects`, `authentication` operations and so on.
 			if err := copyResponse(rw, resp); err != nil {
 				logger.Errorf("%s writes response failed at remote backend: %s handshake: %v",
-					p.superSpec.Name(), p.backendURL.String(), err)
+					p.superSpec.Name(), backend, err)
 			}
 		} else {
 			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
@@ -239,35 +318,66 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 	// Also pass the header from the Dial handshake.
 	connClient, err := p.upgrader.Upgrade(rw, req, p.upgradeRspHeader(resp))
 	if err != nil {
-		logger.Errorf("%s upgrades req: %#v failed: %s", p.superSpec.Name(), err)
+		logger.Errorf("%s upgrades req failed: %v", p.superSpec.Name(), err)
 		return
 	}
 	defer connClient.Close()
 
+	if p.spec.MaxMessageSize > 0 {
+		connClient.SetReadLimit(p.spec.MaxMessageSize)
+		connBackend.SetReadLimit(p.spec.MaxMessageSize)
+	}
+
+	p.addConnection(backend, 1)
+	defer p.addConnection(backend, -1)
+
 	errClient := make(chan error, 1)
 	errBackend := make(chan error, 1)
-	stop := make(chan struct{})
+	lastActive := time.Now().UnixNano()
 
-	defer close(stop)
+	// NOTE: The message passing goroutines exit after the connections
+	// are closed by the deferred functions.
 
 	// pass msg from backend to client via WebSocket protocol.
-	go p.passMsg(connBackend, connClient, errBackend, stop)
+	go p.passMsg(connBackend, connClient, newMessageFilters(p.spec.MessageFilters, DirectionBackendToClient),
+		&lastActive, errBackend)
 	// pass msg from client to backend via WebSocket protocol.
-	go p.passMsg(connClient, connBackend, errClient, stop)
+	go p.passMsg(connClient, connBackend, newMessageFilters(p.spec.MessageFilters, DirectionClientToBackend),
+		&lastActive, errClient)
+
+	var idleCheck <-chan time.Time
+	idleTimeout := p.spec.idleTimeout()
+	if idleTimeout > 0 {
+		ticker := time.NewTicker(defaultIdleCheckInterval)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
 
 	var errMsg string
-	select {
-	case err = <-errBackend:
-		errMsg = "%s passes msg from backend: %s to client failed: %v"
-	case err = <-errClient:
-		errMsg = "%s passes msg client to backend: %s failed: %v"
-	case <-p.done:
-		logger.Debugf("shutdown websocketserver in request handling")
-		return
+	for errMsg == "" {
+		select {
+		case err = <-errBackend:
+			errMsg = "%s passes msg from backend: %s to client failed: %v"
+		case err = <-errClient:
+			errMsg = "%s passes msg client to backend: %s failed: %v"
+		case <-idleCheck:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&lastActive)))
+			if idle < idleTimeout {
+				continue
+			}
+			m := websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout")
+			writeClose(connClient, m)
+			writeClose(connBackend, m)
+			logger.Debugf("%s closes idle connection to backend: %s", p.superSpec.Name(), backend)
+			return
+		case <-p.done:
+			logger.Debugf("shutdown websocketserver in request handling")
+			return
+		}
 	}
 
 	if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
-		logger.Errorf(errMsg, p.superSpec.Name(), p.backendURL.String(), err)
+		logger.Errorf(errMsg, p.superSpec.Name(), backend, err)
 	}
 	// other error type is expected, not need to log
 }
@@ -275,6 +385,9 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 // Close closes websocket proxy.
 func (p *Proxy) Close() {
 	close(p.done)
+	if p.server == nil {
+		return
+	}
 
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancelFunc()
@@ -285,6 +398,12 @@ func (p *Proxy) Close() {
 	}
 }
 
+// writeClose writes the close message, it is safe to be called
+// concurrently with other write methods.
+func writeClose(conn *websocket.Conn, m []byte) {
+	conn.WriteControl(websocket.CloseMessage, m, time.Now().Add(defaultCloseTimeout))
+}
+
 func copyResponse(rw http.ResponseWriter, resp *http.Response) error {
 	for k, vv := range resp.Header {
 		for _, v := range vv {
@@ -22,32 +22,60 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/url"
-	"strings"
+	"time"
+)
+
+const (
+	// LoadBalanceRoundRobin sends connections to the backends in turn.
+	LoadBalanceRoundRobin = "roundRobin"
+	// LoadBalanceRandom sends connections to a random backend.
+	LoadBalanceRandom = "random"
+	// LoadBalanceIPHash sends connections from the same client IP to
+	// the same backend.
+	LoadBalanceIPHash = "ipHash"
 )
 
 type (
 	// Spec describes the WebSocketServer.
 	Spec struct {
-		Port    uint16 `yaml:"port" jsonschema:"required,minimum=1"`
-		HTTPS   bool   `yaml:"https" jsonschema:"required"`
-		Backend string `yaml:"backend" jsonschema:"required"`
+		Port  uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		HTTPS bool   `yaml:"https" jsonschema:"required"`
+
+		// Backend is kept for compatibility, it is the same as a
+		// Backends with only one element.
+		Backend     string   `yaml:"backend" jsonschema:"omitempty"`
+		Backends    []string `yaml:"backends" jsonschema:"omitempty,uniqueItems=true"`
+		LoadBalance string   `yaml:"loadBalance,omitempty" jsonschema:"omitempty,enum=roundRobin,enum=random,enum=ipHash"`
 
 		CertBase64 string `yaml:"certBase64" jsonschema:"omitempty,format=base64"`
 		KeyBase64  string `yaml:"keyBase64" jsonschema:"omitempty,format=base64"`
 
-		wssCertBase64 string `yaml:"certBase64" jsonschema:"omitempty,format=base64"`
-		wssKeyBase64  string `yaml:"keyBase64" jsonschema:"omitempty,format=base64"`
+		// WssCertBase64 and WssKeyBase64 are the client certificate
+		// for the wss backends, they are optional.
+		WssCertBase64 string `yaml:"wssCertBase64" jsonschema:"omitempty,format=base64"`
+		WssKeyBase64  string `yaml:"wssKeyBase64" jsonschema:"omitempty,format=base64"`
+
+		IdleTimeout    string               `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		MaxMessageSize int64                `yaml:"maxMessageSize" jsonschema:"omitempty,minimum=0"`
+		MessageFilters []*MessageFilterSpec `yaml:"messageFilters" jsonschema:"omitempty"`
 	}
 )
 
 // Validate validates WebSocketServerSpec.
 func (spec *Spec) Validate() error {
-	wsURL, err := url.Parse(spec.Backend)
-	if err != nil {
-		return err
+	backends := spec.backends()
+	if len(backends) == 0 {
+		return fmt.Errorf("backend or backends must be specified")
 	}
-	if wsURL.Scheme != "ws" && wsURL.Scheme != "wss" {
-		return fmt.Errorf("invalid ws backend url, spec: %#v", spec)
+
+	for _, backend := range backends {
+		wsURL, err := url.Parse(backend)
+		if err != nil {
+			return err
+		}
+		if wsURL.Scheme != "ws" && wsURL.Scheme != "wss" {
+			return fmt.Errorf("invalid ws backend url: %s", backend)
+		}
 	}
 
 	if spec.HTTPS {
@@ -56,14 +84,28 @@ func (spec *Spec) Validate() error {
 		}
 	}
 
-	if strings.HasPrefix(spec.Backend, "wss") {
-		if len(spec.wssCertBase64) == 0 || len(spec.wssKeyBase64) == 0 {
-			return fmt.Errorf("invalid wssCertbase64 or wssKeybase64 with wss enable, spec: %#v", spec)
-		}
+	if (spec.WssCertBase64 == "") != (spec.WssKeyBase64 == "") {
+		return fmt.Errorf("wssCertBase64 and wssKeyBase64 must be specified together")
 	}
 	return nil
 }
 
+func (spec *Spec) backends() []string {
+	if spec.Backend == "" {
+		return spec.Backends
+	}
+	return append([]string{spec.Backend}, spec.Backends...)
+}
+
+func (spec *Spec) idleTimeout() time.Duration {
+	if spec.IdleTimeout == "" {
+		return 0
+	}
+	// NOTE: The duration has been checked in validation.
+	d, _ := time.ParseDuration(spec.IdleTimeout)
+	return d
+}
+
 func validateTLS(certBas64, keyBase64 string) (*tls.Config, error) {
 	var certificates []tls.Certificate
 	if len(certBas64) != 0 && len(keyBase64) != 0 {
@@ -81,7 +123,10 @@ func validateTLS(certBas64, keyBase64 string) (*tls.Config, error) {
 }
 
 func (spec *Spec) wssTLSConfig() (*tls.Config, error) {
-	return validateTLS(spec.wssCertBase64, spec.wssKeyBase64)
+	if spec.WssCertBase64 == "" {
+		return &tls.Config{}, nil
+	}
+	return validateTLS(spec.WssCertBase64, spec.WssKeyBase64)
 }
 
 func (spec *Spec) tlsConfig() (*tls.Config, error) {
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	m.Run()
+}
+
+func TestMessageFilters(t *testing.T) {
+	specs := []*MessageFilterSpec{
+		{
+			Direction: DirectionClientToBackend,
+			Replace:   &MessageReplace{Regexp: `\d{4}`, Replace: "****"},
+		},
+		{
+			Direction: DirectionBoth,
+			RateLimit: &MessageRateLimit{MessagesPerSecond: 1, Burst: 2},
+		},
+	}
+
+	filters := newMessageFilters(specs, DirectionClientToBackend)
+	if len(filters) != 2 {
+		t.Fatalf("there should be 2 filters, got %d", len(filters))
+	}
+
+	msg, action := filterMessage(filters, websocket.TextMessage, []byte("card 1234"))
+	if action != "" || string(msg) != "card ****" {
+		t.Errorf("unexpected result: %q, %q", msg, action)
+	}
+	msg, action = filterMessage(filters, websocket.BinaryMessage, []byte("1234"))
+	if action != "" || string(msg) != "1234" {
+		t.Errorf("binary message should not be replaced, got %q, %q", msg, action)
+	}
+	if _, action = filterMessage(filters, websocket.TextMessage, []byte("x")); action != ActionDrop {
+		t.Errorf("message should be dropped, got %q", action)
+	}
+
+	filters = newMessageFilters(specs, DirectionBackendToClient)
+	if len(filters) != 1 {
+		t.Fatalf("there should be 1 filter, got %d", len(filters))
+	}
+
+	if (MessageFilterSpec{}).Validate() == nil {
+		t.Error("validation should fail without rateLimit and replace")
+	}
+}
+
+func TestPickBackend(t *testing.T) {
+	p := &Proxy{spec: &Spec{}}
+	for _, s := range []string{"ws://127.0.0.1:8001", "ws://127.0.0.1:8002"} {
+		u, _ := url.Parse(s)
+		p.backendURLs = append(p.backendURLs, u)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if p.pickBackend(req) == p.pickBackend(req) {
+		t.Error("round robin should pick different backends")
+	}
+
+	p.spec.LoadBalance = LoadBalanceIPHash
+	first := p.pickBackend(req)
+	for i := 0; i < 10; i++ {
+		if p.pickBackend(req) != first {
+			t.Fatal("ip hash should always pick the same backend")
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(msgType, append([]byte("echo "), msg...))
+		}
+	}))
+	defer backend.Close()
+
+	p := &Proxy{
+		spec: &Spec{
+			Backends:       []string{"ws" + strings.TrimPrefix(backend.URL, "http")},
+			MaxMessageSize: 16,
+			MessageFilters: []*MessageFilterSpec{
+				{
+					Direction: DirectionBackendToClient,
+					Replace:   &MessageReplace{Regexp: "hello", Replace: "hi"},
+				},
+			},
+		},
+		done:        make(chan struct{}),
+		connections: make(map[string]int64),
+	}
+	if err := p.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(p.handle))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(msg) != "echo hi" {
+		t.Errorf("message should be %q, got %q", "echo hi", msg)
+	}
+	if n := p.status().Connections[p.spec.Backends[0]]; n != 1 {
+		t.Errorf("there should be 1 active connection, got %d", n)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 32)))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("connection should be closed for message too big, got %v", err)
+	}
+}
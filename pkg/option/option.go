@@ -65,6 +65,7 @@ type Options struct {
 	APIAddr                         string            `yaml:"api-addr"`
 	Debug                           bool              `yaml:"debug"`
 	InitialObjectConfigFiles        []string          `yaml:"initial-object-config-files"`
+	ExpressionTimeout               string            `yaml:"expression-timeout"`
 
 	// Path.
 	HomeDir   string `yaml:"home-dir"`
@@ -111,6 +112,7 @@ func New() *Options {
 	opt.flags.StringVar(&opt.APIAddr, "api-addr", "localhost:2381", "Address([host]:port) to listen on for administration traffic.")
 	opt.flags.BoolVar(&opt.Debug, "debug", false, "Flag to set lowest log level from INFO downgrade DEBUG.")
 	opt.flags.StringSliceVar(&opt.InitialObjectConfigFiles, "initial-object-config-files", nil, "List of configuration files for initial objects, these objects will be created at startup if not already exist.")
+	opt.flags.StringVar(&opt.ExpressionTimeout, "expression-timeout", "1s", "Maximum time of evaluating an expression, e.g. a routing condition.")
 
 	opt.flags.StringVar(&opt.HomeDir, "home-dir", "./", "Path to the home directory.")
 	opt.flags.StringVar(&opt.DataDir, "data-dir", "data", "Path to the data directory.")
@@ -304,6 +306,10 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid cluster-request-timeout: %v", err)
 	}
 
+	if d, err := time.ParseDuration(opt.ExpressionTimeout); err != nil || d <= 0 {
+		return fmt.Errorf("invalid expression-timeout: %s", opt.ExpressionTimeout)
+	}
+
 	_, _, err = net.SplitHostPort(opt.APIAddr)
 	if err != nil {
 		return fmt.Errorf("invalid api-addr: %v", err)
@@ -20,23 +20,55 @@ package registry
 import (
 
 	// Filters
+	_ "github.com/megaease/easegress/pkg/filter/abtest"
+	_ "github.com/megaease/easegress/pkg/filter/adaptivelimiter"
+	_ "github.com/megaease/easegress/pkg/filter/amqpoutput"
 	_ "github.com/megaease/easegress/pkg/filter/apiaggregator"
+	_ "github.com/megaease/easegress/pkg/filter/apikeyauth"
+	_ "github.com/megaease/easegress/pkg/filter/botdetector"
 	_ "github.com/megaease/easegress/pkg/filter/bridge"
 	_ "github.com/megaease/easegress/pkg/filter/circuitbreaker"
+	_ "github.com/megaease/easegress/pkg/filter/compressor"
 	_ "github.com/megaease/easegress/pkg/filter/corsadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/csrf"
+	_ "github.com/megaease/easegress/pkg/filter/datamasker"
+	_ "github.com/megaease/easegress/pkg/filter/dubboproxy"
 	_ "github.com/megaease/easegress/pkg/filter/fallback"
+	_ "github.com/megaease/easegress/pkg/filter/extprocessor"
+	_ "github.com/megaease/easegress/pkg/filter/faultinjector"
+	_ "github.com/megaease/easegress/pkg/filter/graphql"
+	_ "github.com/megaease/easegress/pkg/filter/grpcproxy"
+	_ "github.com/megaease/easegress/pkg/filter/grpcweb"
+	_ "github.com/megaease/easegress/pkg/filter/headermodifier"
+	_ "github.com/megaease/easegress/pkg/filter/ipfilter"
+	_ "github.com/megaease/easegress/pkg/filter/kafkaoutput"
+	_ "github.com/megaease/easegress/pkg/filter/loadshedder"
+	_ "github.com/megaease/easegress/pkg/filter/luascript"
 	_ "github.com/megaease/easegress/pkg/filter/mock"
+	_ "github.com/megaease/easegress/pkg/filter/natsoutput"
+	_ "github.com/megaease/easegress/pkg/filter/oidc"
+	_ "github.com/megaease/easegress/pkg/filter/opa"
 	_ "github.com/megaease/easegress/pkg/filter/proxy"
 	_ "github.com/megaease/easegress/pkg/filter/ratelimiter"
 	_ "github.com/megaease/easegress/pkg/filter/remotefilter"
 	_ "github.com/megaease/easegress/pkg/filter/requestadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/requestcoalescer"
 	_ "github.com/megaease/easegress/pkg/filter/responseadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/responsecache"
 	_ "github.com/megaease/easegress/pkg/filter/retryer"
+	_ "github.com/megaease/easegress/pkg/filter/soapadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/staticserver"
+	_ "github.com/megaease/easegress/pkg/filter/thriftproxy"
 	_ "github.com/megaease/easegress/pkg/filter/timelimiter"
+	_ "github.com/megaease/easegress/pkg/filter/transformer"
+	_ "github.com/megaease/easegress/pkg/filter/urlrewriter"
 	_ "github.com/megaease/easegress/pkg/filter/validator"
+	_ "github.com/megaease/easegress/pkg/filter/waf"
 	_ "github.com/megaease/easegress/pkg/filter/wasmhost"
 
 	// Objects
+	_ "github.com/megaease/easegress/pkg/object/amqpinput"
+	_ "github.com/megaease/easegress/pkg/object/autocertmanager"
 	_ "github.com/megaease/easegress/pkg/object/consulserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/easemonitormetrics"
 	_ "github.com/megaease/easegress/pkg/object/etcdserviceregistry"
@@ -45,11 +77,15 @@ import (
 	_ "github.com/megaease/easegress/pkg/object/httppipeline"
 	_ "github.com/megaease/easegress/pkg/object/httpserver"
 	_ "github.com/megaease/easegress/pkg/object/ingresscontroller"
+	_ "github.com/megaease/easegress/pkg/object/kafkainput"
 	_ "github.com/megaease/easegress/pkg/object/meshcontroller"
 	_ "github.com/megaease/easegress/pkg/object/mqttproxy"
 	_ "github.com/megaease/easegress/pkg/object/nacosserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/natsinput"
 	_ "github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/tcpproxy"
 	_ "github.com/megaease/easegress/pkg/object/trafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/udpproxy"
 	_ "github.com/megaease/easegress/pkg/object/websocketserver"
 	_ "github.com/megaease/easegress/pkg/object/zookeeperserviceregistry"
 )
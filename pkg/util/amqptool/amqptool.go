@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package amqptool provides the common specs and helpers of AMQP 0.9.1.
+package amqptool
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type (
+	// QueueSpec describes the queue to declare, and the exchanges it binds to.
+	QueueSpec struct {
+		Name       string `yaml:"name" jsonschema:"required"`
+		Durable    bool   `yaml:"durable" jsonschema:"omitempty"`
+		AutoDelete bool   `yaml:"autoDelete" jsonschema:"omitempty"`
+		Exclusive  bool   `yaml:"exclusive" jsonschema:"omitempty"`
+		// Arguments are the optional arguments of the queue, such as
+		// x-message-ttl and x-max-length.
+		Arguments map[string]interface{} `yaml:"arguments" jsonschema:"omitempty"`
+		Bindings  []*BindingSpec         `yaml:"bindings" jsonschema:"omitempty"`
+	}
+
+	// BindingSpec describes a binding from an exchange to the queue.
+	BindingSpec struct {
+		Exchange   string `yaml:"exchange" jsonschema:"required"`
+		RoutingKey string `yaml:"routingKey" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates QueueSpec.
+func (s QueueSpec) Validate() error {
+	return amqp.Table(s.Arguments).Validate()
+}
+
+// Declare declares the queue and its bindings on the channel.
+func (s *QueueSpec) Declare(ch *amqp.Channel) error {
+	_, err := ch.QueueDeclare(s.Name, s.Durable, s.AutoDelete, s.Exclusive, false, amqp.Table(s.Arguments))
+	if err != nil {
+		return fmt.Errorf("declare queue %s failed: %v", s.Name, err)
+	}
+
+	for _, b := range s.Bindings {
+		err = ch.QueueBind(s.Name, b.RoutingKey, b.Exchange, false, nil)
+		if err != nil {
+			return fmt.Errorf("bind queue %s to exchange %s failed: %v", s.Name, b.Exchange, err)
+		}
+	}
+
+	return nil
+}
@@ -306,6 +306,14 @@ var stateStrings = []string{
 	"ForceOpen",
 }
 
+// String returns the string representation of the state
+func (s State) String() string {
+	if int(s) < len(stateStrings) {
+		return stateStrings[s]
+	}
+	return "Unknown"
+}
+
 // NewPolicy create and initialize a policy
 func NewPolicy(failureRateThreshold, slowCallRateThreshold, slidingWindowType uint8,
 	slidingWindowSize, permittedNumberOfCallsInHalfOpen, minimumNumberOfCalls uint32,
@@ -388,6 +396,8 @@ func (cb *CircuitBreaker) transitTo(state State, reason string) {
 
 // State returns the state of the circuit breaker
 func (cb *CircuitBreaker) State() State {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
 	return cb.state
 }
 
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package concurrencylimiter implements adaptive concurrency limiters,
+// which adjust the limit of in-flight requests automatically according
+// to the latency of requests.
+package concurrencylimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Gradient is the gradient algorithm, it compares the short term
+	// latency with the long term latency, and decreases the limit when
+	// the latency is getting higher.
+	Gradient = iota
+	// Vegas is the TCP Vegas like algorithm, it estimates the queue
+	// size by comparing the latency with the minimum latency.
+	Vegas
+)
+
+const (
+	// longWindow is the number of samples of the long term latency.
+	longWindow = 600
+	// backoffRatio is the ratio the limit decreases to on drops.
+	backoffRatio = 0.9
+)
+
+type (
+	// Policy defines the policy of a concurrency limiter
+	Policy struct {
+		Algorithm    uint8
+		InitialLimit int
+		MinLimit     int
+		MaxLimit     int
+		// Smoothing is the factor of smoothing the change of the limit
+		// of the gradient algorithm, in range (0, 1].
+		Smoothing float64
+		// Tolerance is the tolerance of the latency increase of the
+		// gradient algorithm before reducing the limit, at least 1.
+		Tolerance float64
+	}
+
+	// Limiter is an adaptive concurrency limiter
+	Limiter struct {
+		lock     sync.Mutex
+		policy   *Policy
+		limit    float64
+		inflight int
+
+		// longRTT is the long term latency of the gradient algorithm.
+		longRTT float64
+		// minRTT is the minimum latency of the vegas algorithm.
+		minRTT float64
+	}
+
+	// Status is the status of a concurrency limiter
+	Status struct {
+		Limit    int `yaml:"limit"`
+		Inflight int `yaml:"inflight"`
+	}
+)
+
+// NewDefaultPolicy create and initialize a policy with default configuration
+func NewDefaultPolicy() *Policy {
+	return &Policy{
+		Algorithm:    Gradient,
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     1000,
+		Smoothing:    0.2,
+		Tolerance:    1.5,
+	}
+}
+
+// New creates a concurrency limiter based on `policy`
+func New(policy *Policy) *Limiter {
+	return &Limiter{
+		policy: policy,
+		limit:  float64(policy.InitialLimit),
+	}
+}
+
+// AcquirePermission acquires a permission from the limiter, returns
+// true if the request is permitted. Release must be called when a
+// permitted request completes.
+func (l *Limiter) AcquirePermission() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// Release releases a permission with the latency of the request, dropped
+// means the request failed because of overload, e.g. timed out.
+func (l *Limiter) Release(rtt time.Duration, dropped bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+
+	if dropped {
+		l.setLimit(l.limit * backoffRatio)
+		return
+	}
+
+	if rtt <= 0 {
+		return
+	}
+
+	if l.policy.Algorithm == Vegas {
+		l.vegas(float64(rtt), inflight)
+	} else {
+		l.gradient(float64(rtt), inflight)
+	}
+}
+
+func (l *Limiter) gradient(rtt float64, inflight int) {
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / longWindow
+	}
+
+	// NOTE: Recover faster when the latency drops a lot, e.g. after
+	// an outage of the upstream.
+	if l.longRTT/rtt > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// The limit is not the bottleneck, don't grow it.
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1.0, l.policy.Tolerance*l.longRTT/rtt))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	l.setLimit(l.limit*(1-l.policy.Smoothing) + newLimit*l.policy.Smoothing)
+}
+
+func (l *Limiter) vegas(rtt float64, inflight int) {
+	if l.minRTT == 0 || rtt < l.minRTT {
+		l.minRTT = rtt
+	}
+
+	if float64(inflight)*2 < l.limit {
+		return
+	}
+
+	queue := math.Ceil(l.limit * (1 - l.minRTT/rtt))
+	step := math.Max(1, math.Log10(l.limit))
+	alpha, beta := 3*step, 6*step
+
+	switch {
+	case queue <= step:
+		l.setLimit(l.limit + beta)
+	case queue < alpha:
+		l.setLimit(l.limit + step)
+	case queue > beta:
+		l.setLimit(l.limit - step)
+	}
+}
+
+func (l *Limiter) setLimit(limit float64) {
+	limit = math.Max(float64(l.policy.MinLimit), limit)
+	limit = math.Min(float64(l.policy.MaxLimit), limit)
+	l.limit = limit
+}
+
+// Status returns the status of the limiter
+func (l *Limiter) Status() *Status {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return &Status{
+		Limit:    int(l.limit),
+		Inflight: l.inflight,
+	}
+}
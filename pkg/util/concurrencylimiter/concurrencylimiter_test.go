@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package concurrencylimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAcquirePermission(t *testing.T) {
+	policy := NewDefaultPolicy()
+	policy.InitialLimit = 2
+	l := New(policy)
+
+	if !l.AcquirePermission() || !l.AcquirePermission() {
+		t.Fatal("requests should be permitted")
+	}
+	if l.AcquirePermission() {
+		t.Fatal("request should be rejected")
+	}
+
+	l.Release(0, false)
+	if !l.AcquirePermission() {
+		t.Error("request should be permitted after release")
+	}
+}
+
+func TestDropped(t *testing.T) {
+	l := New(NewDefaultPolicy())
+	l.AcquirePermission()
+	l.Release(time.Millisecond, true)
+
+	if s := l.Status(); s.Limit != 18 || s.Inflight != 0 {
+		t.Errorf("limit should back off to 18, got %+v", s)
+	}
+}
+
+func saturate(l *Limiter, rtt time.Duration, rounds int) {
+	for i := 0; i < rounds; i++ {
+		n := 0
+		for l.AcquirePermission() {
+			n++
+		}
+		for j := 0; j < n; j++ {
+			l.Release(rtt, false)
+		}
+	}
+}
+
+func TestGradient(t *testing.T) {
+	l := New(NewDefaultPolicy())
+
+	saturate(l, 10*time.Millisecond, 20)
+	grown := l.Status().Limit
+	if grown <= 20 {
+		t.Fatalf("limit should grow when latency is stable, got %d", grown)
+	}
+
+	saturate(l, 100*time.Millisecond, 1)
+	if l.Status().Limit >= grown {
+		t.Errorf("limit should decrease when latency increases, got %d", l.Status().Limit)
+	}
+}
+
+func TestVegas(t *testing.T) {
+	policy := NewDefaultPolicy()
+	policy.Algorithm = Vegas
+	l := New(policy)
+
+	saturate(l, 10*time.Millisecond, 5)
+	grown := l.Status().Limit
+	if grown <= 20 {
+		t.Fatalf("limit should grow when latency is stable, got %d", grown)
+	}
+
+	saturate(l, 100*time.Millisecond, 5)
+	if l.Status().Limit >= grown {
+		t.Errorf("limit should decrease when latency increases, got %d", l.Status().Limit)
+	}
+}
+
+func TestLimitBounds(t *testing.T) {
+	policy := NewDefaultPolicy()
+	policy.MaxLimit = 30
+	l := New(policy)
+
+	saturate(l, 10*time.Millisecond, 100)
+	if l.Status().Limit != 30 {
+		t.Errorf("limit should not exceed max limit, got %d", l.Status().Limit)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.AcquirePermission()
+		l.Release(0, true)
+	}
+	if l.Status().Limit != 1 {
+		t.Errorf("limit should not be less than min limit, got %d", l.Status().Limit)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package expr implements the expression language of the configurations,
+// the expressions are JavaScript expressions evaluated on the requests
+// and responses.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+// DefaultTimeout is the default maximum time of an evaluation.
+const DefaultTimeout = time.Second
+
+var (
+	errTimeout = errors.New("expression evaluation timeout")
+
+	// timeout is the maximum time of an evaluation, which stops the
+	// expressions running into an endless loop. It is wall-clock time,
+	// so it must be far longer than any normal evaluation, to tolerate
+	// the scheduling and GC pauses under load.
+	timeout = int64(DefaultTimeout)
+
+	runtimePool = sync.Pool{
+		New: func() interface{} {
+			return newRuntime()
+		},
+	}
+)
+
+type (
+	// Expr is a compiled expression, it's safe for concurrent use.
+	Expr struct {
+		source  string
+		program *goja.Program
+	}
+
+	// runtime is a JavaScript VM with the API, the API functions work on
+	// the request and response bound to it.
+	runtime struct {
+		vm   *goja.Runtime
+		req  context.HTTPRequest
+		resp context.HTTPResponse
+	}
+)
+
+// SetTimeout sets the maximum time of an evaluation, a non-positive
+// duration restores DefaultTimeout.
+func SetTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultTimeout
+	}
+	atomic.StoreInt64(&timeout, int64(d))
+}
+
+// Compile compiles the expression.
+func Compile(source string) (*Expr, error) {
+	if source == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	prg, err := parser.ParseFile(nil, "expr", source, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(prg.Body) != 1 {
+		return nil, fmt.Errorf("only one expression is allowed")
+	}
+	if _, ok := prg.Body[0].(*ast.ExpressionStatement); !ok {
+		return nil, fmt.Errorf("not an expression")
+	}
+
+	// NOTE: The expression is wrapped in a strict mode function, so that
+	// it can't declare or assign globals which are visible to the other
+	// evaluations.
+	wrapped := "(function() {\n'use strict';\nreturn (\n" + source + "\n);\n})()"
+	program, err := goja.Compile("expr", wrapped, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expr{source: source, program: program}, nil
+}
+
+// MustCompile is like Compile but panics if the expression can't be
+// compiled.
+func MustCompile(source string) *Expr {
+	e, err := Compile(source)
+	if err != nil {
+		panic(fmt.Errorf("compile expression %s failed: %v", source, err))
+	}
+	return e
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.source
+}
+
+// EvalBool evaluates the expression and converts the value to a boolean
+// by the rules of JavaScript, resp could be nil if the response isn't
+// available yet.
+func (e *Expr) EvalBool(req context.HTTPRequest, resp context.HTTPResponse) (bool, error) {
+	var result bool
+	err := e.eval(req, resp, func(v goja.Value) {
+		result = v.ToBoolean()
+	})
+	return result, err
+}
+
+// EvalString evaluates the expression and converts the value to a string,
+// null and undefined are converted to the empty string.
+func (e *Expr) EvalString(req context.HTTPRequest, resp context.HTTPResponse) (string, error) {
+	var result string
+	err := e.eval(req, resp, func(v goja.Value) {
+		if !goja.IsUndefined(v) && !goja.IsNull(v) {
+			result = v.String()
+		}
+	})
+	return result, err
+}
+
+func (e *Expr) eval(req context.HTTPRequest, resp context.HTTPResponse, convert func(goja.Value)) error {
+	rt := runtimePool.Get().(*runtime)
+	rt.req, rt.resp = req, resp
+
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(time.Duration(atomic.LoadInt64(&timeout)), func() {
+		rt.vm.Interrupt(errTimeout)
+		close(interrupted)
+	})
+	v, err := rt.vm.RunProgram(e.program)
+	if err == nil {
+		// NOTE: The value must be converted before the runtime is put back
+		// to the pool.
+		convert(v)
+	}
+
+	rt.req, rt.resp = nil, nil
+	// NOTE: If the timer has fired, wait for the interruption before
+	// clearing it, so that the runtime can be reused safely.
+	if !timer.Stop() {
+		<-interrupted
+	}
+	rt.vm.ClearInterrupt()
+	runtimePool.Put(rt)
+
+	if ie, ok := err.(*goja.InterruptedError); ok && ie.Value() == errTimeout {
+		return errTimeout
+	}
+	return err
+}
+
+func newRuntime() *runtime {
+	rt := &runtime{vm: goja.New()}
+
+	req := rt.vm.NewObject()
+	req.Set("method", func() string { return rt.req.Method() })
+	req.Set("scheme", func() string { return rt.req.Scheme() })
+	req.Set("host", func() string { return rt.req.Host() })
+	req.Set("path", func() string { return rt.req.Path() })
+	req.Set("proto", func() string { return rt.req.Proto() })
+	req.Set("realIP", func() string { return rt.req.RealIP() })
+	req.Set("header", func(name string) goja.Value {
+		return rt.header(rt.req.Header(), name)
+	})
+	req.Set("query", func(name string) goja.Value {
+		values, err := url.ParseQuery(rt.req.Query())
+		if err != nil {
+			return goja.Null()
+		}
+		if v, ok := values[name]; ok && len(v) > 0 {
+			return rt.vm.ToValue(v[0])
+		}
+		return goja.Null()
+	})
+	req.Set("cookie", func(name string) goja.Value {
+		cookie, err := rt.req.Cookie(name)
+		if err != nil {
+			return goja.Null()
+		}
+		return rt.vm.ToValue(cookie.Value)
+	})
+
+	resp := rt.vm.NewObject()
+	resp.Set("statusCode", func() goja.Value {
+		if rt.resp == nil {
+			return goja.Null()
+		}
+		return rt.vm.ToValue(rt.resp.StatusCode())
+	})
+	resp.Set("header", func(name string) goja.Value {
+		if rt.resp == nil {
+			return goja.Null()
+		}
+		return rt.header(rt.resp.Header(), name)
+	})
+
+	// NOTE: The API objects are frozen and read-only, so they can't be
+	// changed by an evaluation.
+	freeze, _ := goja.AssertFunction(rt.vm.Get("Object").ToObject(rt.vm).Get("freeze"))
+	global := rt.vm.GlobalObject()
+	for name, obj := range map[string]*goja.Object{"req": req, "resp": resp} {
+		freeze(goja.Undefined(), obj)
+		global.DefineDataProperty(name, obj, goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	}
+
+	return rt
+}
+
+// header returns the first value of the header, or null if it is absent.
+func (rt *runtime) header(h *httpheader.HTTPHeader, name string) goja.Value {
+	if values := h.GetAll(name); len(values) > 0 {
+		return rt.vm.ToValue(values[0])
+	}
+	return goja.Null()
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package expr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+func newContext() context.HTTPContext {
+	req := httptest.NewRequest("GET", "http://example.com/users/1?id=1&name=foo", nil)
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	w := httptest.NewRecorder()
+	return context.New(w, req, tracing.NoopTracing, "test")
+}
+
+func TestEval(t *testing.T) {
+	ctx := newContext()
+	ctx.Response().SetStatusCode(404)
+	ctx.Response().Header().Set("X-Cache", "hit")
+
+	for source, want := range map[string]string{
+		`req.method() + " " + req.path()`:       "GET /users/1",
+		`req.header("X-User")`:                  "alice",
+		`req.header("X-Absent")`:                "",
+		`req.query("name") + req.query("none")`: "foonull",
+		`req.cookie("session")`:                 "abc",
+		`req.host()`:                            "example.com",
+		`resp.statusCode() + 1`:                 "405",
+		`resp.header("X-Cache")`:                "hit",
+		`// comment
+		req.path().split("/")[1]`: "users",
+	} {
+		e, err := Compile(source)
+		if err != nil {
+			t.Fatalf("compile %s failed: %v", source, err)
+		}
+		got, err := e.EvalString(ctx.Request(), ctx.Response())
+		if err != nil {
+			t.Fatalf("eval %s failed: %v", source, err)
+		}
+		if got != want {
+			t.Errorf("%s: want %q, got %q", source, want, got)
+		}
+	}
+
+	e := MustCompile(`req.header("X-User") === "alice" && resp.statusCode() === null`)
+	if ok, err := e.EvalBool(ctx.Request(), nil); !ok || err != nil {
+		t.Errorf("want true, got %v, %v", ok, err)
+	}
+}
+
+func TestCompileAndEvalError(t *testing.T) {
+	for _, source := range []string{``, `req.path(`, `1); (2`, `var x = 1`} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("compile %q should fail", source)
+		}
+	}
+
+	ctx := newContext()
+	for _, source := range []string{
+		`x = 1`,
+		`req.method = 1`,
+		`req.nothing()`,
+	} {
+		e := MustCompile(source)
+		if _, err := e.EvalBool(ctx.Request(), nil); err == nil {
+			t.Errorf("eval %s should fail", source)
+		}
+	}
+
+	// the runtimes still work after the errors.
+	e := MustCompile(`req.method()`)
+	if s, err := e.EvalString(ctx.Request(), nil); s != "GET" || err != nil {
+		t.Errorf("want GET, got %q, %v", s, err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	SetTimeout(10 * time.Millisecond)
+	defer SetTimeout(0)
+
+	ctx := newContext()
+	e := MustCompile(`(function() { while (true) {} })()`)
+	if _, err := e.EvalBool(ctx.Request(), nil); err != errTimeout {
+		t.Errorf("want timeout error, got %v", err)
+	}
+
+	// the interrupted runtimes are reused.
+	e = MustCompile(`req.method()`)
+	for i := 0; i < 10; i++ {
+		if s, err := e.EvalString(ctx.Request(), nil); s != "GET" || err != nil {
+			t.Fatalf("want GET, got %q, %v", s, err)
+		}
+	}
+}
+
+func TestConcurrentEval(t *testing.T) {
+	e := MustCompile(`req.header("X-User") + req.path()`)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := newContext()
+			for j := 0; j < 100; j++ {
+				if s, err := e.EvalString(ctx.Request(), nil); s != "alice/users/1" || err != nil {
+					t.Errorf("unexpected value %q, %v", s, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
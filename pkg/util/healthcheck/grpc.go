@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"bytes"
+	stdcontext "context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	grpcFrameHeaderLen  = 5
+	grpcMaxMessageSize  = 4 << 10
+)
+
+// grpcServingStatus is HealthCheckResponse.ServingStatus of
+// grpc.health.v1.
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+const grpcServing = 1
+
+type grpcProber struct {
+	service      string
+	h2cTransport *http2.Transport
+	tlsTransport *http2.Transport
+}
+
+func newGRPCProber(spec *Spec, tlsConfig *tls.Config) Prober {
+	tlsTransport := &http2.Transport{}
+	if tlsConfig != nil {
+		tlsTransport.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	return &grpcProber{
+		service: spec.Service,
+		h2cTransport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+		tlsTransport: tlsTransport,
+	}
+}
+
+// encodeHealthCheckRequest encodes the gRPC frame of HealthCheckRequest,
+// whose only field is the service name.
+func encodeHealthCheckRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, service)
+	}
+
+	buf := make([]byte, grpcFrameHeaderLen+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	copy(buf[grpcFrameHeaderLen:], msg)
+	return buf
+}
+
+// decodeHealthCheckResponse decodes the serving status from the gRPC frame
+// of HealthCheckResponse.
+func decodeHealthCheckResponse(r io.Reader) (uint64, error) {
+	header := make([]byte, grpcFrameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if header[0] != 0 {
+		return 0, fmt.Errorf("compressed message is not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > grpcMaxMessageSize {
+		return 0, fmt.Errorf("message size %d exceeds %d", n, grpcMaxMessageSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return 0, err
+	}
+
+	// NOTE: The status is UNKNOWN if the field is absent, which is how
+	// a zero value is encoded in proto3.
+	status := uint64(0)
+	for len(msg) > 0 {
+		num, typ, l := protowire.ConsumeTag(msg)
+		if l < 0 {
+			return 0, protowire.ParseError(l)
+		}
+		msg = msg[l:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(msg)
+			if l < 0 {
+				return 0, protowire.ParseError(l)
+			}
+			status, msg = v, msg[l:]
+			continue
+		}
+
+		l = protowire.ConsumeFieldValue(num, typ, msg)
+		if l < 0 {
+			return 0, protowire.ParseError(l)
+		}
+		msg = msg[l:]
+	}
+	return status, nil
+}
+
+// Probe calls grpc.health.v1.Health/Check of the target, the target is a
+// URL, plaintext HTTP/2 is used for http and TLS for https.
+func (p *grpcProber) Probe(ctx stdcontext.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid target %s", target)
+	}
+
+	transport := p.h2cTransport
+	if u.Scheme == "https" {
+		transport = p.tlsTransport
+	} else {
+		u.Scheme = "http"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + grpcHealthCheckPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(),
+		bytes.NewReader(encodeHealthCheckRequest(p.service)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	status, readErr := decodeHealthCheckResponse(resp.Body)
+	// NOTE: The trailers are available after the body is read to completion.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	code, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return fmt.Errorf("grpc status %s: %s", code, message)
+	}
+
+	if readErr != nil {
+		return fmt.Errorf("read response failed: %v", readErr)
+	}
+	if status != grpcServing {
+		name, ok := grpcServingStatus[status]
+		if !ok {
+			name = fmt.Sprintf("%d", status)
+		}
+		return fmt.Errorf("serving status %s", name)
+	}
+	return nil
+}
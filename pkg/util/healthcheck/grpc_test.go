@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// healthServer is a fake grpc.health.v1.Health service.
+type healthServer struct {
+	mutex    sync.Mutex
+	statuses map[string]uint64
+}
+
+func (s *healthServer) setStatus(service string, status uint64) {
+	s.mutex.Lock()
+	s.statuses[service] = status
+	s.mutex.Unlock()
+}
+
+func (s *healthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != grpcHealthCheckPath || r.ProtoMajor != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	service := ""
+	if msg := body[grpcFrameHeaderLen:]; len(msg) > 0 {
+		_, _, n := protowire.ConsumeTag(msg)
+		service, _ = protowire.ConsumeString(msg[n:])
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	s.mutex.Lock()
+	status, ok := s.statuses[service]
+	s.mutex.Unlock()
+	if !ok {
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "unknown%20service")
+		return
+	}
+
+	var msg []byte
+	if status != 0 {
+		msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, status)
+	}
+	frame := make([]byte, grpcFrameHeaderLen+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	copy(frame[grpcFrameHeaderLen:], msg)
+	w.Write(frame)
+
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	hs := &healthServer{statuses: map[string]uint64{"": 1, "echo.Echo": 1}}
+	server := httptest.NewServer(h2c.NewHandler(hs, &http2.Server{}))
+	defer server.Close()
+
+	c := New(&Spec{
+		Protocol:           ProtocolGRPC,
+		Service:            "echo.Echo",
+		Interval:           "10ms",
+		Timeout:            "100ms",
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}, nil, nil)
+	defer c.Close()
+
+	c.Update([]string{server.URL})
+	time.Sleep(30 * time.Millisecond)
+	if !c.Healthy(server.URL) {
+		t.Fatalf("target should be healthy: %s", c.Status()[server.URL].LastError)
+	}
+
+	hs.setStatus("echo.Echo", 2)
+	waitFor(t, func() bool { return !c.Healthy(server.URL) })
+	if e := c.Status()[server.URL].LastError; e != "serving status NOT_SERVING" {
+		t.Errorf("unexpected error %q", e)
+	}
+
+	hs.setStatus("echo.Echo", 1)
+	waitFor(t, func() bool { return c.Healthy(server.URL) })
+}
+
+func TestGRPCProbe(t *testing.T) {
+	hs := &healthServer{statuses: map[string]uint64{"": 1, "unknown": 0}}
+	server := httptest.NewServer(h2c.NewHandler(hs, &http2.Server{}))
+	defer server.Close()
+
+	probe := func(service string) error {
+		ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), time.Second)
+		defer cancel()
+		return newGRPCProber(&Spec{Service: service}, nil).Probe(ctx, server.URL)
+	}
+
+	if err := probe(""); err != nil {
+		t.Errorf("server should be serving: %v", err)
+	}
+	if err := probe("unknown"); err == nil || err.Error() != "serving status UNKNOWN" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := probe("missing"); err == nil || !strings.Contains(err.Error(), "unknown service") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	server.Close()
+	if err := probe(""); err == nil {
+		t.Error("closed server should be unhealthy")
+	}
+}
+
+func TestEncodeHealthCheckRequest(t *testing.T) {
+	if frame := encodeHealthCheckRequest(""); !bytes.Equal(frame, make([]byte, grpcFrameHeaderLen)) {
+		t.Errorf("unexpected frame %v", frame)
+	}
+
+	frame := encodeHealthCheckRequest("a")
+	want := []byte{0, 0, 0, 0, 3, 0x0a, 1, 'a'}
+	if !bytes.Equal(frame, want) {
+		t.Errorf("want %v, got %v", want, frame)
+	}
+
+	status, err := decodeHealthCheckResponse(bytes.NewReader([]byte{0, 0, 0, 0, 2, 0x08, 2}))
+	if err != nil || status != 2 {
+		t.Errorf("want 2, got %d, %v", status, err)
+	}
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	stdcontext "context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	// ProtocolHTTP probes targets by sending HTTP requests.
+	ProtocolHTTP = "http"
+	// ProtocolTCP probes targets by establishing TCP connections.
+	ProtocolTCP = "tcp"
+	// ProtocolGRPC probes targets by the gRPC health checking protocol,
+	// that is grpc.health.v1.Health/Check.
+	ProtocolGRPC = "grpc"
+
+	defaultInterval           = 10 * time.Second
+	defaultTimeout            = 3 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+)
+
+type (
+	// Spec describes the active health check.
+	Spec struct {
+		Protocol      string `yaml:"protocol" jsonschema:"omitempty,enum=http,enum=tcp,enum=grpc"`
+		Path          string `yaml:"path" jsonschema:"omitempty,pattern=^/"`
+		Method        string `yaml:"method" jsonschema:"omitempty,format=httpmethod"`
+		ExpectedCodes []int  `yaml:"expectedCodes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+		Interval      string `yaml:"interval" jsonschema:"omitempty,format=duration"`
+		Timeout       string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// Service is the service to check by the gRPC protocol, the
+		// health of the whole server is checked if it is empty.
+		Service            string `yaml:"service" jsonschema:"omitempty"`
+		HealthyThreshold   int    `yaml:"healthyThreshold" jsonschema:"omitempty,minimum=1"`
+		UnhealthyThreshold int    `yaml:"unhealthyThreshold" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// Prober probes a target, a nil error means the target is healthy.
+	Prober interface {
+		Probe(ctx stdcontext.Context, target string) error
+	}
+
+	// OnChangeFunc is called when the health of a target is changed.
+	OnChangeFunc func(target string, healthy bool)
+
+	// TargetStatus is the health status of a target.
+	TargetStatus struct {
+		Healthy   bool      `yaml:"healthy"`
+		LastCheck time.Time `yaml:"lastCheck"`
+		LastError string    `yaml:"lastError,omitempty"`
+	}
+
+	// Checker checks the health of targets periodically.
+	Checker struct {
+		spec               *Spec
+		prober             Prober
+		interval           time.Duration
+		timeout            time.Duration
+		healthyThreshold   int
+		unhealthyThreshold int
+		onChange           OnChangeFunc
+
+		mutex   sync.Mutex
+		targets map[string]*target
+
+		done chan struct{}
+	}
+
+	target struct {
+		status    TargetStatus
+		successes int
+		failures  int
+	}
+
+	httpProber struct {
+		client        *http.Client
+		method        string
+		path          string
+		expectedCodes []int
+	}
+
+	tcpProber struct{}
+)
+
+// NewProberFunc creates a prober, tlsConfig is for the targets of https,
+// which is nil if the default one should be used.
+type NewProberFunc func(spec *Spec, tlsConfig *tls.Config) Prober
+
+var probers = map[string]NewProberFunc{
+	ProtocolHTTP: newHTTPProber,
+	ProtocolTCP:  func(spec *Spec, tlsConfig *tls.Config) Prober { return &tcpProber{} },
+	ProtocolGRPC: newGRPCProber,
+}
+
+// RegisterProber registers a prober for the protocol, so that extra
+// protocols could be supported without changing this package.
+func RegisterProber(protocol string, fn NewProberFunc) {
+	if _, exists := probers[protocol]; exists {
+		panic(fmt.Errorf("prober of protocol %s existed", protocol))
+	}
+	probers[protocol] = fn
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v <= 0 {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
+
+// New creates a Checker, it starts probing targets after Update. The
+// tlsConfig is used to probe the targets of https, it should be the same
+// as the one to access them, e.g. to skip verification or to use mTLS, and
+// the default one is used if it is nil.
+func New(spec *Spec, tlsConfig *tls.Config, onChange OnChangeFunc) *Checker {
+	protocol := spec.Protocol
+	if protocol == "" {
+		protocol = ProtocolHTTP
+	}
+	newProber, exists := probers[protocol]
+	if !exists {
+		logger.Errorf("BUG: unsupported health check protocol %s", protocol)
+		newProber = probers[ProtocolTCP]
+	}
+
+	c := &Checker{
+		spec:               spec,
+		prober:             newProber(spec, tlsConfig),
+		interval:           parseDuration(spec.Interval, defaultInterval),
+		timeout:            parseDuration(spec.Timeout, defaultTimeout),
+		healthyThreshold:   spec.HealthyThreshold,
+		unhealthyThreshold: spec.UnhealthyThreshold,
+		onChange:           onChange,
+		targets:            make(map[string]*target),
+		done:               make(chan struct{}),
+	}
+
+	if c.healthyThreshold <= 0 {
+		c.healthyThreshold = defaultHealthyThreshold
+	}
+	if c.unhealthyThreshold <= 0 {
+		c.unhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Update updates the targets to check, new targets are regarded as
+// healthy until they fail the check.
+func (c *Checker) Update(targets []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	newTargets := make(map[string]*target, len(targets))
+	for _, t := range targets {
+		if old, exists := c.targets[t]; exists {
+			newTargets[t] = old
+			continue
+		}
+		newTargets[t] = &target{status: TargetStatus{Healthy: true}}
+	}
+	c.targets = newTargets
+}
+
+// Healthy returns whether the target is healthy, unknown targets are healthy.
+func (c *Checker) Healthy(t string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if tt, exists := c.targets[t]; exists {
+		return tt.status.Healthy
+	}
+	return true
+}
+
+// Status returns the health status of all targets.
+func (c *Checker) Status() map[string]*TargetStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := make(map[string]*TargetStatus, len(c.targets))
+	for k, v := range c.targets {
+		status := v.status
+		s[k] = &status
+	}
+	return s
+}
+
+// Close stops the Checker.
+func (c *Checker) Close() {
+	close(c.done)
+}
+
+func (c *Checker) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.check()
+		}
+	}
+}
+
+func (c *Checker) check() {
+	c.mutex.Lock()
+	targets := make([]string, 0, len(c.targets))
+	for t := range c.targets {
+		targets = append(targets, t)
+	}
+	c.mutex.Unlock()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(targets))
+	for _, t := range targets {
+		go func(t string) {
+			defer wg.Done()
+
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), c.timeout)
+			defer cancel()
+			c.record(t, c.prober.Probe(ctx, t))
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (c *Checker) record(t string, err error) {
+	changed, healthy := c.update(t, err)
+	if !changed {
+		return
+	}
+
+	if healthy {
+		logger.Infof("health check: %s is healthy now", t)
+	} else {
+		logger.Warnf("health check: %s is unhealthy now: %v", t, err)
+	}
+
+	// NOTE: Call onChange without holding the lock, so that it's free
+	// to call other methods of the Checker.
+	if c.onChange != nil {
+		c.onChange(t, healthy)
+	}
+}
+
+func (c *Checker) update(t string, err error) (changed bool, healthy bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	tt, exists := c.targets[t]
+	if !exists {
+		// The target has been removed during the check.
+		return false, false
+	}
+
+	tt.status.LastCheck = time.Now()
+	if err == nil {
+		tt.status.LastError = ""
+		tt.successes++
+		tt.failures = 0
+		if !tt.status.Healthy && tt.successes >= c.healthyThreshold {
+			tt.status.Healthy = true
+			return true, true
+		}
+		return false, tt.status.Healthy
+	}
+
+	tt.status.LastError = err.Error()
+	tt.failures++
+	tt.successes = 0
+	if tt.status.Healthy && tt.failures >= c.unhealthyThreshold {
+		tt.status.Healthy = false
+		return true, false
+	}
+	return false, tt.status.Healthy
+}
+
+func newHTTPProber(spec *Spec, tlsConfig *tls.Config) Prober {
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	return &httpProber{
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		method:        method,
+		path:          spec.Path,
+		expectedCodes: spec.ExpectedCodes,
+	}
+}
+
+// Probe sends a request to the target, the target is a URL.
+func (p *httpProber) Probe(ctx stdcontext.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, p.method, target+p.path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if len(p.expectedCodes) == 0 {
+		if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+			return nil
+		}
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	for _, code := range p.expectedCodes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
+// HostPort returns the host:port of the target URL, the port is
+// derived from the scheme if it is absent.
+func HostPort(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid target %s", target)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+// Probe dials the target, the target is a URL.
+func (p *tcpProber) Probe(ctx stdcontext.Context, target string) error {
+	addr, err := HostPort(target)
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition is not satisfied in time")
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	var failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var changes []bool
+	c := New(&Spec{
+		Path:               "/healthz",
+		Interval:           "10ms",
+		Timeout:            "100ms",
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}, nil, func(target string, healthy bool) {
+		mutex.Lock()
+		changes = append(changes, healthy)
+		mutex.Unlock()
+	})
+	defer c.Close()
+
+	c.Update([]string{server.URL})
+	if !c.Healthy(server.URL) {
+		t.Fatal("new target should be healthy")
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	waitFor(t, func() bool { return !c.Healthy(server.URL) })
+	if c.Status()[server.URL].LastError == "" {
+		t.Error("last error should be recorded")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	waitFor(t, func() bool { return c.Healthy(server.URL) })
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("want changes [false true], got %v", changes)
+	}
+}
+
+func TestHTTPSHealthCheck(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	spec := &Spec{Interval: "10ms", Timeout: "100ms", HealthyThreshold: 1, UnhealthyThreshold: 1}
+
+	// the certificate of the server is self-signed.
+	c := New(spec, nil, nil)
+	defer c.Close()
+	c.Update([]string{server.URL})
+	waitFor(t, func() bool { return !c.Healthy(server.URL) })
+
+	c1 := New(spec, &tls.Config{InsecureSkipVerify: true}, nil)
+	defer c1.Close()
+	c1.Update([]string{server.URL})
+	time.Sleep(30 * time.Millisecond)
+	if !c1.Healthy(server.URL) {
+		t.Fatalf("target should be healthy with the tls config: %s", c1.Status()[server.URL].LastError)
+	}
+}
+
+func TestTCPHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+
+	c := New(&Spec{
+		Protocol:           ProtocolTCP,
+		Interval:           "10ms",
+		UnhealthyThreshold: 1,
+	}, nil, nil)
+	defer c.Close()
+
+	c.Update([]string{url})
+	time.Sleep(30 * time.Millisecond)
+	if !c.Healthy(url) {
+		t.Fatal("target should be healthy")
+	}
+
+	server.Close()
+	waitFor(t, func() bool { return !c.Healthy(url) })
+
+	c.Update(nil)
+	if len(c.Status()) != 0 {
+		t.Error("removed targets should not be checked")
+	}
+	if !c.Healthy(url) {
+		t.Error("unknown target should be healthy")
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	cases := map[string]string{
+		"http://127.0.0.1:8080": "127.0.0.1:8080",
+		"http://example.com":    "example.com:80",
+		"https://example.com/a": "example.com:443",
+	}
+	for target, want := range cases {
+		got, err := HostPort(target)
+		if err != nil || got != want {
+			t.Errorf("HostPort(%s): want %s, got %s, %v", target, want, got, err)
+		}
+	}
+
+	if _, err := HostPort("127.0.0.1"); err == nil {
+		t.Error("HostPort should fail")
+	}
+}
@@ -26,6 +26,8 @@ const (
 	KeyContentEncoding = "Content-Encoding"
 	// KeyContentLength is the key of Content-Length.
 	KeyContentLength = "Content-Length"
+	// KeyContentType is the key of Content-Type.
+	KeyContentType = "Content-Type"
 	// KeyVary is the key of Vary.
 	KeyVary = "Vary"
 
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pipelinetool provides the helpers for objects which feed their
+// messages into the HTTPPipelines as HTTP requests.
+package pipelinetool
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+// NOTE: The kind is not imported from package rawconfigtrafficcontroller,
+// to avoid depending on all kinds of traffic objects.
+const rawConfigTrafficControllerKind = "RawConfigTrafficController"
+
+type (
+	// Getter gets HTTPPipeline by its name.
+	Getter func(name string) (protocol.HTTPHandler, bool)
+
+	// mapper is implemented by RawConfigTrafficController.
+	mapper interface {
+		GetHTTPPipeline(name string) (protocol.HTTPHandler, bool)
+	}
+)
+
+// NewGetter returns the Getter of the HTTPPipelines in
+// RawConfigTrafficController, it returns nil if the controller doesn't exist.
+func NewGetter(super *supervisor.Supervisor) Getter {
+	entity, exists := super.GetSystemController(rawConfigTrafficControllerKind)
+	if !exists {
+		return nil
+	}
+
+	m, ok := entity.Instance().(mapper)
+	if !ok {
+		panic(fmt.Errorf("BUG: want mapper, got %T", entity.Instance()))
+	}
+	return m.GetHTTPPipeline
+}
+
+// Handle handles the request by the pipeline, and returns the context
+// for the caller to check the response.
+func Handle(getPipeline Getter, name string, req *http.Request, spanName string) (context.HTTPContext, error) {
+	if getPipeline == nil {
+		return nil, fmt.Errorf("pipelines are not available")
+	}
+	handler, exists := getPipeline(name)
+	if !exists {
+		return nil, fmt.Errorf("pipeline %s not found", name)
+	}
+
+	w := httptest.NewRecorder()
+	ctx := context.New(w, req, tracing.NoopTracing, spanName)
+	handler.Handle(ctx)
+	return ctx, nil
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipelinetool
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
+)
+
+type mockPipeline func(ctx context.HTTPContext)
+
+func (p mockPipeline) Handle(ctx context.HTTPContext) {
+	p(ctx)
+}
+
+func TestHandle(t *testing.T) {
+	pipelines := map[string]protocol.HTTPHandler{
+		"pipeline": mockPipeline(func(ctx context.HTTPContext) {
+			if ctx.Request().Header().Get("X-Test") != "test" {
+				ctx.Response().SetStatusCode(http.StatusBadRequest)
+				return
+			}
+			ctx.Response().SetStatusCode(http.StatusAccepted)
+		}),
+	}
+	getPipeline := func(name string) (protocol.HTTPHandler, bool) {
+		p, ok := pipelines[name]
+		return p, ok
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/test", nil)
+	req.Header.Set("X-Test", "test")
+
+	if _, err := Handle(nil, "pipeline", req, "test"); err == nil {
+		t.Errorf("expected error when pipelines are not available")
+	}
+	if _, err := Handle(getPipeline, "unknown", req, "test"); err == nil {
+		t.Errorf("expected error when pipeline is not found")
+	}
+
+	ctx, err := Handle(getPipeline, "pipeline", req, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code := ctx.Response().StatusCode(); code != http.StatusAccepted {
+		t.Errorf("expected status code %d, got %d", http.StatusAccepted, code)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Limiter is the interface of rate limiters.
+	Limiter interface {
+		// AcquirePermission acquires a permission from the limiter.
+		// When permitted, the caller should wait the returned duration
+		// before action; when rejected, the returned duration is a hint
+		// of when to retry.
+		AcquirePermission() (bool, time.Duration)
+	}
+
+	// TokenBucket is a token bucket rate limiter, tokens are added to
+	// the bucket at a constant rate, a request is permitted if there's
+	// a token in the bucket, and the size of the bucket is the burst.
+	TokenBucket struct {
+		lock   sync.Mutex
+		rate   float64 // tokens per nanosecond
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+
+	// LeakyBucket is a leaky bucket rate limiter, requests are queued in
+	// the bucket and leak out at a constant rate, requests overflowing
+	// the bucket are rejected.
+	LeakyBucket struct {
+		lock     sync.Mutex
+		interval time.Duration
+		capacity int
+		next     time.Time
+	}
+)
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+)
+
+// NewTokenBucket creates a token bucket which adds `limit` tokens
+// every `period`, and the size of the bucket is `burst`.
+func NewTokenBucket(limit int, period time.Duration, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = limit
+	}
+	return &TokenBucket{
+		rate:   float64(limit) / float64(period),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   nowFunc(),
+	}
+}
+
+// AcquirePermission acquires a permission from the token bucket.
+func (tb *TokenBucket) AcquirePermission() (bool, time.Duration) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	now := nowFunc()
+	tb.tokens += float64(now.Sub(tb.last)) * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - tb.tokens) / tb.rate)
+}
+
+// NewLeakyBucket creates a leaky bucket which leaks `limit` requests
+// every `period`, and at most `capacity` requests could be queued.
+func NewLeakyBucket(limit int, period time.Duration, capacity int) *LeakyBucket {
+	if capacity <= 0 {
+		capacity = limit
+	}
+	return &LeakyBucket{
+		interval: period / time.Duration(limit),
+		capacity: capacity,
+	}
+}
+
+// AcquirePermission acquires a permission from the leaky bucket, the
+// returned duration is the time the request needs to wait in the queue.
+func (lb *LeakyBucket) AcquirePermission() (bool, time.Duration) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	now := nowFunc()
+	if lb.next.Before(now) {
+		lb.next = now
+	}
+
+	wait := lb.next.Sub(now)
+	full := lb.interval * time.Duration(lb.capacity)
+	if wait >= full {
+		return false, wait - full + lb.interval
+	}
+
+	lb.next = lb.next.Add(lb.interval)
+	return true, wait
+}
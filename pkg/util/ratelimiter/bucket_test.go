@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(10, time.Second, 3)
+
+	for i := 0; i < 3; i++ {
+		if permitted, _ := tb.AcquirePermission(); !permitted {
+			t.Fatalf("request %d should be permitted by burst", i)
+		}
+	}
+
+	permitted, d := tb.AcquirePermission()
+	if permitted {
+		t.Fatal("request should be rejected after burst")
+	}
+	if d != 100*time.Millisecond {
+		t.Errorf("retry after should be 100ms, got %v", d)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if permitted, _ := tb.AcquirePermission(); !permitted {
+		t.Error("request should be permitted after refill")
+	}
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		tb.AcquirePermission()
+	}
+	if permitted, _ := tb.AcquirePermission(); permitted {
+		t.Error("tokens should not exceed burst")
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	lb := NewLeakyBucket(10, time.Second, 2)
+
+	if permitted, d := lb.AcquirePermission(); !permitted || d != 0 {
+		t.Fatalf("first request should be permitted without waiting, got %v, %v", permitted, d)
+	}
+	if permitted, d := lb.AcquirePermission(); !permitted || d != 100*time.Millisecond {
+		t.Fatalf("second request should wait 100ms, got %v, %v", permitted, d)
+	}
+
+	permitted, d := lb.AcquirePermission()
+	if permitted {
+		t.Fatal("request overflowing the bucket should be rejected")
+	}
+	if d != 100*time.Millisecond {
+		t.Errorf("retry after should be 100ms, got %v", d)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if permitted, d := lb.AcquirePermission(); !permitted || d != 100*time.Millisecond {
+		t.Errorf("request should be queued after leaking, got %v, %v", permitted, d)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpcbridge
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const dialTimeout = 3 * time.Second
+
+type (
+	// ConnPool is a pool of the connections to the RPC servers, the
+	// servers are chosen by round-robin. A connection is used by one call
+	// at a time, and is put back to the pool after the call.
+	ConnPool struct {
+		servers []string
+		maxIdle int
+		counter uint64
+
+		mutex  sync.Mutex
+		idle   map[string][]net.Conn
+		closed bool
+	}
+
+	// Conn is a connection got from the pool.
+	Conn struct {
+		net.Conn
+		Server string
+	}
+)
+
+// NewConnPool creates a ConnPool, which keeps at most maxIdle idle
+// connections to each server.
+func NewConnPool(servers []string, maxIdle int) *ConnPool {
+	return &ConnPool{
+		servers: servers,
+		maxIdle: maxIdle,
+		idle:    map[string][]net.Conn{},
+	}
+}
+
+// Get gets an idle connection to the next server, or dials a new one.
+func (p *ConnPool) Get(ctx stdcontext.Context) (*Conn, error) {
+	n := atomic.AddUint64(&p.counter, 1)
+	server := p.servers[n%uint64(len(p.servers))]
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("connection pool closed")
+	}
+	if conns := p.idle[server]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		p.idle[server] = conns[:len(conns)-1]
+		p.mutex.Unlock()
+		return &Conn{Conn: conn, Server: server}, nil
+	}
+	p.mutex.Unlock()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, Server: server}, nil
+}
+
+// Put puts the connection back to the pool, broken connections, like
+// the ones failed in the middle of a call, must be closed instead.
+func (p *ConnPool) Put(conn *Conn) {
+	conn.SetDeadline(time.Time{})
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed || len(p.idle[conn.Server]) >= p.maxIdle {
+		conn.Close()
+		return
+	}
+	p.idle[conn.Server] = append(p.idle[conn.Server], conn.Conn)
+}
+
+// Close closes the idle connections, and the connections put back later.
+func (p *ConnPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+	for _, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	p.idle = nil
+}
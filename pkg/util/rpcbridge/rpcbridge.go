@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rpcbridge provides the common parts of the filters which
+// translate RESTful HTTP requests into RPC calls.
+package rpcbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+// MaxBodySize is the maximum size of the request bodies to translate.
+const MaxBodySize = 4 << 20
+
+type (
+	// Endpoint is the RESTful endpoint mapped to an RPC method.
+	Endpoint struct {
+		method   string
+		segments []string
+	}
+
+	// Request holds the values of a request which could be passed to the
+	// RPC method as the parameters.
+	Request struct {
+		vars   map[string]string
+		query  url.Values
+		header func(name string) string
+		body   interface{}
+	}
+)
+
+// NewEndpoint creates an Endpoint, the path is a template like
+// /v1/users/{id}, and the values of the variables are referred as
+// path.NAME in the sources of the parameters.
+func NewEndpoint(method, path string) *Endpoint {
+	return &Endpoint{
+		method:   method,
+		segments: strings.Split(strings.Trim(path, "/"), "/"),
+	}
+}
+
+// Match matches the request to the endpoint, and returns the values of
+// the path variables.
+func (e *Endpoint) Match(method, path string) (map[string]string, bool) {
+	if method != e.method {
+		return nil, false
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != len(e.segments) {
+		return nil, false
+	}
+
+	vars := map[string]string{}
+	for i, s := range e.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			v, err := url.PathUnescape(segments[i])
+			if err != nil {
+				return nil, false
+			}
+			vars[s[1:len(s)-1]] = v
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+
+	return vars, true
+}
+
+// ValidateSource validates the source of a parameter, which is one of
+// path.NAME, query.NAME, header.NAME, body and body.FIELD.
+func ValidateSource(source string) error {
+	if source == "body" {
+		return nil
+	}
+	for _, prefix := range []string{"path.", "query.", "header.", "body."} {
+		if strings.HasPrefix(source, prefix) && len(source) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid source %q, must be path.NAME, query.NAME, header.NAME, body or body.FIELD", source)
+}
+
+// NewRequest creates a Request, the body is decoded as JSON if it is
+// not empty, the numbers in the body are json.Number.
+func NewRequest(ctx context.HTTPContext, vars map[string]string) (*Request, error) {
+	r := ctx.Request()
+
+	query, err := url.ParseQuery(r.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+
+	req := &Request{
+		vars:   vars,
+		query:  query,
+		header: r.Header().Get,
+	}
+
+	if r.Body() == nil {
+		return req, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body(), MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", MaxBodySize)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return req, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&req.body); err != nil {
+		return nil, fmt.Errorf("invalid json body: %v", err)
+	}
+	return req, nil
+}
+
+// Value returns the value of the source, the values of path variables,
+// query parameters and headers are strings, and the values from the
+// body are JSON values. It returns nil if the value is absent.
+func (r *Request) Value(source string) interface{} {
+	switch {
+	case source == "body":
+		return r.body
+	case strings.HasPrefix(source, "path."):
+		if v, ok := r.vars[strings.TrimPrefix(source, "path.")]; ok {
+			return v
+		}
+	case strings.HasPrefix(source, "query."):
+		if values, ok := r.query[strings.TrimPrefix(source, "query.")]; ok && len(values) > 0 {
+			return values[0]
+		}
+	case strings.HasPrefix(source, "header."):
+		if v := r.header(strings.TrimPrefix(source, "header.")); v != "" {
+			return v
+		}
+	case strings.HasPrefix(source, "body."):
+		v := r.body
+		for _, key := range strings.Split(strings.TrimPrefix(source, "body."), ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[key]
+		}
+		return v
+	}
+	return nil
+}
+
+// WriteJSON writes v as the JSON body of the response.
+func WriteJSON(ctx context.HTTPContext, statusCode int, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(statusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buf))
+	return nil
+}
+
+// WriteError writes the error message as the JSON body of the response.
+func WriteError(ctx context.HTTPContext, statusCode int, message string) {
+	WriteJSON(ctx, statusCode, map[string]string{"error": message})
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package signer
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// fileCheckInterval is the minimum interval to check if the file of
+// access keys has changed
+const fileCheckInterval = 5 * time.Second
+
+// FileAccessKeyStore is an access key store which loads the keys from a
+// YAML file of access key id to secret, the file is reloaded when its
+// modification time changes
+type FileAccessKeyStore struct {
+	path string
+
+	mutex     sync.RWMutex
+	keys      map[string]string
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewFileAccessKeyStore creates a FileAccessKeyStore
+func NewFileAccessKeyStore(path string) *FileAccessKeyStore {
+	store := &FileAccessKeyStore{path: path}
+	store.reload()
+	return store
+}
+
+// reload reloads the keys if the file has changed, the old keys are kept
+// if the file can't be loaded
+func (store *FileAccessKeyStore) reload() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.checkedAt = time.Now()
+
+	info, e := os.Stat(store.path)
+	if e != nil {
+		logger.Errorf("stat access key file %s failed: %v", store.path, e)
+		return
+	}
+	if info.ModTime().Equal(store.modTime) {
+		return
+	}
+
+	data, e := os.ReadFile(store.path)
+	if e != nil {
+		logger.Errorf("read access key file %s failed: %v", store.path, e)
+		return
+	}
+
+	keys := map[string]string{}
+	if e = yaml.Unmarshal(data, &keys); e != nil {
+		logger.Errorf("unmarshal access key file %s failed: %v", store.path, e)
+		return
+	}
+
+	store.keys, store.modTime = keys, info.ModTime()
+}
+
+// GetSecret returns the secret of the access key id
+func (store *FileAccessKeyStore) GetSecret(id string) (string, bool) {
+	store.mutex.RLock()
+	stale := time.Since(store.checkedAt) > fileCheckInterval
+	store.mutex.RUnlock()
+
+	if stale {
+		store.reload()
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	s, ok := store.keys[id]
+	return s, ok
+}
@@ -111,7 +111,10 @@ type (
 		ignoredHeaders map[string]bool
 		headerHoisting *HeaderHoisting
 		ttl            time.Duration
+		clockSkew      time.Duration
 		excludeBody    bool
+		// requiredHeaders must be signed in the requests to verify
+		requiredHeaders []string
 		// accessKeyID & accessKeySecret are for signing
 		accessKeyID     string
 		accessKeySecret string
@@ -239,6 +242,22 @@ func (signer *Signer) SetTTL(d time.Duration) *Signer {
 	return signer
 }
 
+// SetClockSkew is an option function for Signer to set the tolerance of the
+// clock difference between the signer and the verifier
+func (signer *Signer) SetClockSkew(d time.Duration) *Signer {
+	signer.clockSkew = d
+	return signer
+}
+
+// RequireSignedHeader is an option function for Signer to add headers which
+// must be signed in the requests to verify
+func (signer *Signer) RequireSignedHeader(headers ...string) *Signer {
+	for _, h := range headers {
+		signer.requiredHeaders = append(signer.requiredHeaders, strings.ToLower(h))
+	}
+	return signer
+}
+
 // SetAccessKeyStore is an option function for Signer to set access key store
 func (signer *Signer) SetAccessKeyStore(store AccessKeyStore) *Signer {
 	signer.accessKeyStore = store
@@ -749,14 +768,33 @@ func (signer *Signer) Verify(req *http.Request) error {
 		return e
 	}
 
+	signed := strings.Split(ctx.SignedHeaders, ";")
+	for _, required := range signer.requiredHeaders {
+		found := false
+		for _, name := range signed {
+			if name == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("header %s is not signed", required)
+		}
+	}
+
 	age := time.Now().Sub(ctx.Time)
+	if ctx.clockSkew > 0 && age < -ctx.clockSkew {
+		return fmt.Errorf("signature timestamp is in the future")
+	}
 	if ctx.ttl > 0 {
-		if age < -ctx.ttl || age > ctx.ttl {
+		// NOTE: Without clock skew, ttl is also the tolerance of future
+		// timestamps, which is the original behavior.
+		if (ctx.clockSkew == 0 && age < -ctx.ttl) || age > ctx.ttl+ctx.clockSkew {
 			return fmt.Errorf("signature expired")
 		}
 	}
 	if ctx.isPresign {
-		if age > ctx.ExpireTime {
+		if age > ctx.ExpireTime+ctx.clockSkew {
 			return fmt.Errorf("signature expired")
 		}
 	}
@@ -773,7 +811,7 @@ func (signer *Signer) Verify(req *http.Request) error {
 	}
 
 	ctx.sign(req)
-	if sig != ctx.Signature {
+	if !hmac.Equal([]byte(sig), []byte(ctx.Signature)) {
 		return fmt.Errorf("signature verification failed")
 	}
 
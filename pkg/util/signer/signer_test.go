@@ -21,12 +21,22 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
 )
 
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
 // We are trying best to be compatible with Amazon Signature Version 4,
 // so we use Amazon literals here, and some test cases are also revised
 // versions of Amazon's.
@@ -321,6 +331,78 @@ func TestPresignVerify(t *testing.T) {
 	}
 }
 
+func TestVerifyClockSkew(t *testing.T) {
+	signer := CreateFromSpec(awsSpec)
+	signer.SetTTL(15 * time.Minute)
+
+	req := buildRequest("dynamodb", "us-east-1", "{}")
+	signer.NewContext(time.Now().Add(10*time.Minute), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	signer.SetClockSkew(time.Minute)
+	if e := signer.Verify(req); e == nil {
+		t.Errorf("verification should failed, but didn't")
+	}
+
+	req = buildRequest("dynamodb", "us-east-1", "{}")
+	signer.NewContext(time.Now().Add(-15*time.Minute-30*time.Second), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+}
+
+func TestVerifyRequiredHeaders(t *testing.T) {
+	signer := CreateFromSpec(awsSpec)
+	signer.RequireSignedHeader("Host", "X-Amz-Date", "Content-Type")
+
+	req := buildRequest("dynamodb", "us-east-1", "{}")
+	signer.NewContext(time.Now(), "us-east-1", "dynamodb").Sign(req)
+	if e := signer.Verify(req); e != nil {
+		t.Errorf("verification failed: %s", e.Error())
+	}
+
+	signer.RequireSignedHeader("X-Request-Id")
+	if e := signer.Verify(req); e == nil {
+		t.Errorf("verification should failed, but didn't")
+	}
+}
+
+func TestFileAccessKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	if e := os.WriteFile(path, []byte("AKID: SECRET1\n"), 0o600); e != nil {
+		t.Fatalf("write file failed: %v", e)
+	}
+
+	store := NewFileAccessKeyStore(path)
+	if s, ok := store.GetSecret("AKID"); !ok || s != "SECRET1" {
+		t.Errorf("secret should be SECRET1, got %s", s)
+	}
+
+	os.WriteFile(path, []byte("AKID: SECRET2\n"), 0o600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	store.checkedAt = time.Time{}
+	if s, _ := store.GetSecret("AKID"); s != "SECRET2" {
+		t.Errorf("secret should be reloaded, got %s", s)
+	}
+
+	os.Remove(path)
+	store.checkedAt = time.Time{}
+	if s, _ := store.GetSecret("AKID"); s != "SECRET2" {
+		t.Errorf("old secrets should be kept, got %s", s)
+	}
+
+	spec := *awsSpec
+	spec.AccessKeys = map[string]string{"AKID2": "SECRET3"}
+	spec.AccessKeyFile = path
+	signer := CreateFromSpec(&spec)
+	if s, ok := signer.accessKeyStore.GetSecret("AKID2"); !ok || s != "SECRET3" {
+		t.Errorf("secret should be SECRET3, got %s", s)
+	}
+}
+
 func BenchmarkPresignRequest(b *testing.B) {
 	req := buildRequest("dynamodb", "us-east-1", "{}")
 
@@ -26,10 +26,15 @@ type Spec struct {
 	IgnoredHeaders  []string          `yaml:"ignoredHeaders" json:"ignoredHeaders" jsonschema:"omitempty,uniqueItems=true"`
 	ExcludeBody     bool              `yaml:"excludeBody" json:"excludeBody" jsonschema:"omitempty"`
 	TTL             string            `yaml:"ttl" json:"ttl" jsonschema:"omitempty,format=duration"`
+	ClockSkew       string            `yaml:"clockSkew" json:"clockSkew" jsonschema:"omitempty,format=duration"`
+	RequiredHeaders []string          `yaml:"requiredHeaders" json:"requiredHeaders" jsonschema:"omitempty,uniqueItems=true"`
 	AccessKeyID     string            `yaml:"accessKeyId" json:"accessKeyId" jsonschema:"omitempty"`
 	AccessKeySecret string            `yaml:"accessKeySecret" json:"accessKeySecret" jsonschema:"omitempty"`
 	AccessKeys      map[string]string `yaml:"accessKeys" json:"accessKeys" jsonschema:"omitempty"`
-	// TODO: AccessKeys is used as an internal access key store, but an external store is also needed
+	// AccessKeyFile is a YAML file of access key id to secret, it is an
+	// external store reloaded when the file changes, so keys could be
+	// rotated without updating the configuration.
+	AccessKeyFile string `yaml:"accessKeyFile" json:"accessKeyFile" jsonschema:"omitempty"`
 }
 
 type idSecretMap map[string]string
@@ -39,6 +44,18 @@ func (m idSecretMap) GetSecret(id string) (string, bool) {
 	return s, ok
 }
 
+// chainedStore looks up the secret from the stores in order
+type chainedStore []AccessKeyStore
+
+func (stores chainedStore) GetSecret(id string) (string, bool) {
+	for _, store := range stores {
+		if s, ok := store.GetSecret(id); ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
 // CreateFromSpec create a Signer from configuration
 func CreateFromSpec(spec *Spec) *Signer {
 	signer := New()
@@ -60,8 +77,25 @@ func CreateFromSpec(spec *Spec) *Signer {
 		signer.SetTTL(ttl)
 	}
 
+	if ttl, e := time.ParseDuration(spec.ClockSkew); e == nil {
+		signer.SetClockSkew(ttl)
+	}
+
+	signer.RequireSignedHeader(spec.RequiredHeaders...)
+
+	var stores chainedStore
 	if len(spec.AccessKeys) > 0 {
-		signer.SetAccessKeyStore(idSecretMap(spec.AccessKeys))
+		stores = append(stores, idSecretMap(spec.AccessKeys))
+	}
+	if spec.AccessKeyFile != "" {
+		stores = append(stores, NewFileAccessKeyStore(spec.AccessKeyFile))
+	}
+	switch len(stores) {
+	case 0:
+	case 1:
+		signer.SetAccessKeyStore(stores[0])
+	default:
+		signer.SetAccessKeyStore(stores)
 	}
 	return signer
 }
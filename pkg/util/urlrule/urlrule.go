@@ -23,6 +23,8 @@ import (
 	"strings"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/expr"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
@@ -40,7 +42,9 @@ type (
 		id        string
 		Methods   []string    `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
 		URL       StringMatch `yaml:"url" jsonschema:"required"`
+		Expr      string      `yaml:"expr,omitempty" jsonschema:"omitempty,format=expr"`
 		PolicyRef string      `yaml:"policyRef" jsonschema:"omitempty"`
+		expr      *expr.Expr
 	}
 )
 
@@ -103,6 +107,11 @@ func (r *URLRule) Init() {
 	if r.URL.RegEx != "" {
 		r.URL.re = regexp.MustCompile(r.URL.RegEx)
 	}
+	if r.Expr != "" {
+		// NOTE: The expression has been checked by the format validation
+		// of the spec.
+		r.expr = expr.MustCompile(r.Expr)
+	}
 }
 
 // Match matches a URL to the rule
@@ -113,7 +122,19 @@ func (r *URLRule) Match(req context.HTTPRequest) bool {
 		}
 	}
 
-	return r.URL.Match(req.Path())
+	if !r.URL.Match(req.Path()) {
+		return false
+	}
+
+	if r.expr == nil {
+		return true
+	}
+	ok, err := r.expr.EvalBool(req, nil)
+	if err != nil {
+		logger.Warnf("evaluate expr %s failed: %v", r.Expr, err)
+		return false
+	}
+	return ok
 }
 
 // DeepEqual returns true if r deep equal with r1 and false otherwise
@@ -139,5 +160,9 @@ func (r *URLRule) DeepEqual(r1 *URLRule) bool {
 		return false
 	}
 
+	if r.Expr != r1.Expr {
+		return false
+	}
+
 	return r.PolicyRef == r1.PolicyRef
 }
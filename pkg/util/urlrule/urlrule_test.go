@@ -236,6 +236,34 @@ func TestURLRULENoMatchURL(t *testing.T) {
 	}
 }
 
+func TestURLRULEExprMatch(t *testing.T) {
+	u := &URLRule{
+		URL: StringMatch{
+			Prefix: "/",
+		},
+		Expr: `req.header("X-Beta") === "true" || req.query("beta") !== null`,
+	}
+	u.Init()
+
+	for url, want := range map[string]bool{
+		"http://localhost/user/api":        false,
+		"http://localhost/user/api?beta=1": true,
+	} {
+		request, _ := http.NewRequest(http.MethodGet, url, nil)
+		ctx := context.New(httptest.NewRecorder(), request, tracing.NoopTracing, "")
+		if got := u.Match(ctx.Request()); got != want {
+			t.Errorf("%s: want %v, got %v", url, want, got)
+		}
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "http://localhost/user/api", nil)
+	request.Header.Set("X-Beta", "true")
+	ctx := context.New(httptest.NewRecorder(), request, tracing.NoopTracing, "")
+	if !u.Match(ctx.Request()) {
+		t.Error("request with header X-Beta should match")
+	}
+}
+
 func TestFailStringMatch(t *testing.T) {
 	sm := StringMatch{}
 
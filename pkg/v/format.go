@@ -25,6 +25,8 @@ import (
 	"net/url"
 	"regexp"
 	"time"
+
+	"github.com/megaease/easegress/pkg/util/expr"
 )
 
 var (
@@ -42,6 +44,7 @@ var (
 		"regexp":           _regexp,
 		"base64":           _base64,
 		"url":              _url,
+		"expr":             _expr,
 	}
 
 	urlCharsRegexp = regexp.MustCompile(`^[A-Za-z0-9\-_\.~]{1,253}$`)
@@ -207,3 +210,16 @@ func _url(v interface{}) error {
 
 	return nil
 }
+
+func _expr(v interface{}) error {
+	s := v.(string)
+	if s == "" {
+		return nil
+	}
+	_, err := expr.Compile(s)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %v", err)
+	}
+
+	return nil
+}